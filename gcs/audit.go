@@ -0,0 +1,173 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+)
+
+// The prefix of the headers in which audit entries attached with WithAuditInfo
+// are sent to GCS, followed by the entry's key.
+const CustomAuditHeaderPrefix = "X-Goog-Custom-Audit-"
+
+// Limits that GCS places on custom audit entries.
+const (
+	maxAuditEntries     = 4
+	maxAuditKeyLength   = 64
+	maxAuditValueLength = 1200
+)
+
+type auditInfoKey struct{}
+
+// Return a context that attaches the supplied key/value entries to operations
+// performed with it, for inclusion in the Cloud Audit Logs entries that GCS
+// writes for them. Entries are added to any already attached to ctx, replacing
+// those with the same keys.
+//
+// The entries are sent in CustomAuditHeaderPrefix headers, and in the
+// corresponding gRPC metadata. GCS accepts at most four entries per request,
+// with keys of at most 64 letters, digits, hyphens, and underscores and values
+// of at most 1200 characters; operations with other entries fail without
+// contacting GCS. Data Access audit logs must be enabled for the entries to be
+// recorded.
+//
+// Official documentation:
+//     https://cloud.google.com/storage/docs/audit-logging
+func WithAuditInfo(
+	ctx context.Context,
+	entries map[string]string) context.Context {
+	merged := make(map[string]string)
+	for k, v := range AuditInfoFromContext(ctx) {
+		merged[k] = v
+	}
+
+	for k, v := range entries {
+		merged[k] = v
+	}
+
+	return context.WithValue(ctx, auditInfoKey{}, merged)
+}
+
+// Return the audit entries attached to the context with WithAuditInfo, or nil
+// if none. The caller must not modify the result.
+func AuditInfoFromContext(ctx context.Context) (entries map[string]string) {
+	entries, _ = ctx.Value(auditInfoKey{}).(map[string]string)
+	return
+}
+
+// Check that the supplied audit entries are acceptable to GCS.
+func checkAuditInfo(entries map[string]string) (err error) {
+	if len(entries) > maxAuditEntries {
+		err = fmt.Errorf(
+			"Too many custom audit entries: %d (max %d)",
+			len(entries),
+			maxAuditEntries)
+		return
+	}
+
+	for k, v := range entries {
+		if k == "" || len(k) > maxAuditKeyLength {
+			err = fmt.Errorf("Illegal length for custom audit key %q", k)
+			return
+		}
+
+		for _, r := range k {
+			ok := r == '-' || r == '_' ||
+				('a' <= r && r <= 'z') ||
+				('A' <= r && r <= 'Z') ||
+				('0' <= r && r <= '9')
+
+			if !ok {
+				err = fmt.Errorf("Illegal character in custom audit key %q", k)
+				return
+			}
+		}
+
+		if len(v) > maxAuditValueLength {
+			err = fmt.Errorf("Value for custom audit key %q is too long", k)
+			return
+		}
+
+		if strings.ContainsAny(v, "\r\n\x00") {
+			err = fmt.Errorf("Illegal character in custom audit value for key %q", k)
+			return
+		}
+	}
+
+	return
+}
+
+// Add the audit entries attached to the context to the supplied gRPC
+// metadata.
+func addAuditMetadata(
+	ctx context.Context,
+	md map[string]string) (err error) {
+	entries := AuditInfoFromContext(ctx)
+	if err = checkAuditInfo(entries); err != nil {
+		return
+	}
+
+	for k, v := range entries {
+		md[strings.ToLower(CustomAuditHeaderPrefix+k)] = v
+	}
+
+	return
+}
+
+// Wrap the supplied round tripper in a layer that sends the audit entries
+// carried by requests' contexts in CustomAuditHeaderPrefix headers.
+func newAuditRoundTripper(
+	wrapped httputil.CancellableRoundTripper) (rt httputil.CancellableRoundTripper) {
+	rt = &auditRoundTripper{
+		wrapped: wrapped,
+	}
+
+	return
+}
+
+type auditRoundTripper struct {
+	wrapped httputil.CancellableRoundTripper
+}
+
+func (t *auditRoundTripper) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	entries := AuditInfoFromContext(req.Context())
+	if len(entries) == 0 {
+		res, err = t.wrapped.RoundTrip(req)
+		return
+	}
+
+	if err = checkAuditInfo(entries); err != nil {
+		return
+	}
+
+	// Round trippers mustn't modify the request they're given.
+	req = req.Clone(req.Context())
+	for k, v := range entries {
+		req.Header.Set(CustomAuditHeaderPrefix+k, v)
+	}
+
+	res, err = t.wrapped.RoundTrip(req)
+	return
+}
+
+func (t *auditRoundTripper) CancelRequest(req *http.Request) {
+	t.wrapped.CancelRequest(req)
+}
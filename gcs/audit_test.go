@@ -0,0 +1,127 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestAudit(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type AuditTest struct {
+	ctx       context.Context
+	transport headerTransport
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &AuditTest{}
+
+func init() { RegisterTestSuite(&AuditTest{}) }
+
+func (t *AuditTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.transport.status = http.StatusOK
+	t.transport.header = make(http.Header)
+
+	t.bucket = newBucket(
+		&http.Client{Transport: newAuditRoundTripper(&t.transport)},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *AuditTest) FromContext() {
+	ExpectEq(nil, AuditInfoFromContext(t.ctx))
+
+	ctx := WithAuditInfo(t.ctx, map[string]string{"job": "backup", "run": "1"})
+	ctx = WithAuditInfo(ctx, map[string]string{"run": "2"})
+
+	ExpectThat(
+		AuditInfoFromContext(ctx),
+		DeepEquals(map[string]string{"job": "backup", "run": "2"}))
+}
+
+func (t *AuditTest) HeadersSent() {
+	ctx := WithAuditInfo(t.ctx, map[string]string{"job": "backup"})
+	_, err := t.bucket.StatObject(ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	ExpectEq(
+		"backup",
+		t.transport.requests[0].Header.Get("X-Goog-Custom-Audit-Job"))
+}
+
+func (t *AuditTest) HeadersNotSentByDefault() {
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	for k := range t.transport.requests[0].Header {
+		ExpectFalse(strings.HasPrefix(k, CustomAuditHeaderPrefix), "%s", k)
+	}
+}
+
+func (t *AuditTest) InvalidEntries() {
+	testCases := []map[string]string{
+		{"a": "", "b": "", "c": "", "d": "", "e": ""},
+		{"": "taco"},
+		{strings.Repeat("a", 65): "taco"},
+		{"foo bar": "taco"},
+		{"foo": strings.Repeat("a", 1201)},
+		{"foo": "taco\r\nAuthorization: evil"},
+	}
+
+	for i, tc := range testCases {
+		ctx := WithAuditInfo(t.ctx, tc)
+		_, err := t.bucket.StatObject(ctx, &StatObjectRequest{Name: "foo"})
+		ExpectThat(err, Error(HasSubstr("custom audit")), "Test case %d", i)
+	}
+
+	ExpectEq(0, len(t.transport.requests))
+}
+
+func (t *AuditTest) GRPCMetadata() {
+	creds := &tokenSourceCredentials{
+		source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"}),
+	}
+
+	ctx := WithAuditInfo(t.ctx, map[string]string{"Job": "backup"})
+	md, err := creds.GetRequestMetadata(ctx)
+	AssertEq(nil, err)
+	ExpectEq("backup", md["x-goog-custom-audit-job"])
+
+	ctx = WithAuditInfo(t.ctx, map[string]string{"foo bar": "backup"})
+	_, err = creds.GetRequestMetadata(ctx)
+	ExpectThat(err, Error(HasSubstr("custom audit")))
+}
@@ -0,0 +1,279 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// The maximum number of operations that GCS accepts in a single batch
+// request. Cf. https://cloud.google.com/storage/docs/batch
+const maxBatchSize = 100
+
+// An operation within a batch, ready to be sent.
+type batchPart struct {
+	httpReq *http.Request
+
+	// Interpret the response to httpReq, as the corresponding method would.
+	handle func(*http.Response) (*Object, error)
+}
+
+func (b *bucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	// Set up a part for each operation before sending anything, so that an
+	// invalid operation doesn't leave us having carried out some of the others.
+	parts := make([]batchPart, len(req.Ops))
+	for i, op := range req.Ops {
+		parts[i], err = b.makeBatchPart(ctx, op)
+		if err != nil {
+			err = fmt.Errorf("Operation %d: %v", i, err)
+			return
+		}
+	}
+
+	// Send them in chunks of the largest size GCS accepts. If a chunk fails,
+	// return the results for those before it, which have been carried out.
+	results = make([]BatchResult, len(req.Ops))
+	for start := 0; start < len(parts); start += maxBatchSize {
+		limit := start + maxBatchSize
+		if limit > len(parts) {
+			limit = len(parts)
+		}
+
+		err = b.batchOnce(ctx, parts[start:limit], results[start:limit])
+		if err != nil {
+			results = results[:start]
+			return
+		}
+	}
+
+	return
+}
+
+// Create the HTTP request for a single operation within a batch.
+func (b *bucket) makeBatchPart(
+	ctx context.Context,
+	op BatchOp) (p batchPart, err error) {
+	switch {
+	case op.Stat != nil && op.Update == nil && op.Delete == nil:
+		p.httpReq, err = b.makeStatObjectRequest(ctx, op.Stat)
+		p.handle = handleStatObjectResponse
+
+	case op.Stat == nil && op.Update != nil && op.Delete == nil:
+		p.httpReq, err = b.makeUpdateObjectRequest(ctx, op.Update)
+		p.handle = handleUpdateObjectResponse
+
+	case op.Stat == nil && op.Update == nil && op.Delete != nil:
+		p.httpReq, err = b.makeDeleteObjectRequest(ctx, op.Delete)
+		p.handle = func(httpRes *http.Response) (o *Object, err error) {
+			err = handleDeleteObjectResponse(httpRes)
+			return
+		}
+
+	default:
+		err = errors.New("Exactly one of Stat, Update, and Delete must be set")
+	}
+
+	return
+}
+
+// Write an HTTP request in the form expected for a part of a batch request,
+// which uses a path relative to the host rather than an absolute URL.
+func writeBatchRequest(w io.Writer, httpReq *http.Request) (err error) {
+	path := strings.TrimPrefix(httpReq.URL.Opaque, "//"+httpReq.URL.Host)
+	if httpReq.URL.RawQuery != "" {
+		path += "?" + httpReq.URL.RawQuery
+	}
+
+	var body []byte
+	if httpReq.Body != nil {
+		body, err = ioutil.ReadAll(httpReq.Body)
+		if err != nil {
+			err = fmt.Errorf("Reading body: %v", err)
+			return
+		}
+
+		httpReq.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\n", httpReq.Method, path)
+	httpReq.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+
+	_, err = buf.WriteTo(w)
+	return
+}
+
+// Parse the index of the operation to which a part of a batch response
+// corresponds from its Content-ID header, which looks like "<response-17>"
+// for a request part with Content-ID "<17>".
+func parseBatchContentID(id string) (i int, err error) {
+	s := strings.TrimSuffix(strings.TrimPrefix(id, "<response-"), ">")
+	i, err = strconv.Atoi(s)
+	if err != nil {
+		err = fmt.Errorf("Unexpected Content-ID: %q", id)
+		return
+	}
+
+	return
+}
+
+// Send a single batch request containing the supplied parts, which must
+// number no more than maxBatchSize, filling in the corresponding results.
+func (b *bucket) batchOnce(
+	ctx context.Context,
+	parts []batchPart,
+	results []BatchResult) (err error) {
+	// Serialize them into a multipart/mixed body.
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	for i, p := range parts {
+		var w io.Writer
+		w, err = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-ID":   {fmt.Sprintf("<%d>", i)},
+		})
+
+		if err != nil {
+			err = fmt.Errorf("CreatePart: %v", err)
+			return
+		}
+
+		if err = writeBatchRequest(w, p.httpReq); err != nil {
+			err = fmt.Errorf("writeBatchRequest: %v", err)
+			return
+		}
+	}
+
+	if err = mw.Close(); err != nil {
+		err = fmt.Errorf("Closing multipart writer: %v", err)
+		return
+	}
+
+	// Create the outer HTTP request.
+	url := makeURL(b.endpoint, "/batch/storage/v1", nil)
+
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"POST",
+		url,
+		ioutil.NopCloser(&body),
+		int64(body.Len()),
+		b.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set(
+		"Content-Type",
+		"multipart/mixed; boundary="+mw.Boundary())
+
+	// Execute it.
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	if err = checkResponse(httpRes); err != nil {
+		return
+	}
+
+	// Find the boundary of the multipart response.
+	mediaType, params, err := mime.ParseMediaType(
+		httpRes.Header.Get("Content-Type"))
+
+	if err != nil {
+		err = fmt.Errorf("ParseMediaType: %v", err)
+		return
+	}
+
+	if mediaType != "multipart/mixed" {
+		err = fmt.Errorf("Unexpected response Content-Type: %q", mediaType)
+		return
+	}
+
+	// Handle the response to each operation.
+	seen := make([]bool, len(parts))
+	mr := multipart.NewReader(httpRes.Body, params["boundary"])
+
+	for {
+		var part *multipart.Part
+		part, err = mr.NextPart()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+
+		if err != nil {
+			err = fmt.Errorf("NextPart: %v", err)
+			return
+		}
+
+		var i int
+		i, err = parseBatchContentID(part.Header.Get("Content-ID"))
+		if err != nil {
+			return
+		}
+
+		if i < 0 || i >= len(parts) || seen[i] {
+			err = fmt.Errorf("Unexpected response for operation %d", i)
+			return
+		}
+
+		var partRes *http.Response
+		partRes, err = http.ReadResponse(bufio.NewReader(part), parts[i].httpReq)
+		if err != nil {
+			err = fmt.Errorf("ReadResponse: %v", err)
+			return
+		}
+
+		results[i].Object, results[i].Err = parts[i].handle(partRes)
+		googleapi.CloseBody(partRes)
+		seen[i] = true
+	}
+
+	// Make sure that we heard about every operation.
+	for i := range seen {
+		if !seen[i] {
+			err = fmt.Errorf("No response for operation %d", i)
+			return
+		}
+	}
+
+	return
+}
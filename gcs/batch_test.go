@@ -0,0 +1,363 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestBatch(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A transport that acts as the GCS batch endpoint, recording the outer
+// requests and the operations within them, and responding to each operation
+// with the HTTP response returned by respond. Responses are sent in reverse
+// order, to make sure that they're matched up by Content-ID.
+type batchTransport struct {
+	requests []*http.Request
+	ops      []*http.Request
+	opBodies []string
+	respond  func(op *http.Request) string
+
+	// If non-zero, the outer request with this number, counting from one,
+	// fails without any of its operations being carried out.
+	failRequest int
+}
+
+func (bt *batchTransport) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	bt.requests = append(bt.requests, req)
+	if len(bt.requests) == bt.failRequest {
+		err = errors.New("taco")
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return
+	}
+
+	// Parse the operations.
+	type op struct {
+		id  string
+		req *http.Request
+	}
+
+	var ops []op
+	mr := multipart.NewReader(req.Body, params["boundary"])
+	for {
+		var part *multipart.Part
+		part, err = mr.NextPart()
+		if err != nil {
+			break
+		}
+
+		var opReq *http.Request
+		opReq, err = http.ReadRequest(bufio.NewReader(part))
+		if err != nil {
+			return
+		}
+
+		var body []byte
+		if body, err = ioutil.ReadAll(opReq.Body); err != nil {
+			return
+		}
+
+		bt.ops = append(bt.ops, opReq)
+		bt.opBodies = append(bt.opBodies, string(body))
+		ops = append(ops, op{part.Header.Get("Content-ID"), opReq})
+	}
+
+	// Respond.
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i := len(ops) - 1; i >= 0; i-- {
+		id := strings.Replace(ops[i].id, "<", "<response-", 1)
+		w, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-ID":   {id},
+		})
+
+		fmt.Fprint(w, bt.respond(ops[i].req))
+	}
+
+	mw.Close()
+
+	res = &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Type": {"multipart/mixed; boundary=" + mw.Boundary()},
+		},
+		Body:    ioutil.NopCloser(&buf),
+		Request: req,
+	}
+
+	err = nil
+	return
+}
+
+func httpResponse(status string, body string) string {
+	return fmt.Sprintf(
+		"HTTP/1.1 %s\r\nContent-Type: application/json\r\n"+
+			"Content-Length: %d\r\n\r\n%s",
+		status,
+		len(body),
+		body)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type BatchTest struct {
+	ctx       context.Context
+	transport batchTransport
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &BatchTest{}
+
+func init() { RegisterTestSuite(&BatchTest{}) }
+
+func (t *BatchTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.bucket = newBucket(
+		&http.Client{Transport: &t.transport},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *BatchTest) InvalidOp() {
+	_, err := t.bucket.Batch(
+		t.ctx,
+		&BatchRequest{
+			Ops: []BatchOp{
+				{
+					Stat:   &StatObjectRequest{Name: "foo"},
+					Delete: &DeleteObjectRequest{Name: "foo"},
+				},
+			},
+		})
+
+	ExpectThat(err, Error(HasSubstr("Exactly one")))
+	ExpectEq(0, len(t.transport.requests))
+}
+
+func (t *BatchTest) InvalidOpInLaterChunk() {
+	req := &BatchRequest{}
+	for i := 0; i < 2*maxBatchSize; i++ {
+		req.Ops = append(req.Ops, BatchOp{
+			Delete: &DeleteObjectRequest{Name: fmt.Sprintf("%d", i)},
+		})
+	}
+
+	req.Ops[maxBatchSize+50].Delete.Name = ""
+
+	results, err := t.bucket.Batch(t.ctx, req)
+	ExpectThat(err, Error(HasSubstr(fmt.Sprintf("Operation %d", maxBatchSize+50))))
+	ExpectEq(0, len(results))
+
+	// Nothing should have been sent.
+	ExpectEq(0, len(t.transport.requests))
+}
+
+func (t *BatchTest) Requests() {
+	t.transport.respond = func(op *http.Request) string {
+		return httpResponse("404 Not Found", "{}")
+	}
+
+	mg := int64(17)
+	contentType := "text/plain"
+
+	_, err := t.bucket.Batch(
+		t.ctx,
+		&BatchRequest{
+			Ops: []BatchOp{
+				{Stat: &StatObjectRequest{Name: "foo"}},
+				{
+					Update: &UpdateObjectRequest{
+						Name:                       "bar",
+						ContentType:                &contentType,
+						MetaGenerationPrecondition: &mg,
+					},
+				},
+				{Delete: &DeleteObjectRequest{Name: "baz/qux"}},
+			},
+		})
+
+	AssertEq(nil, err)
+
+	// The outer request
+	AssertEq(1, len(t.transport.requests))
+	req := t.transport.requests[0]
+	ExpectEq("POST", req.Method)
+	ExpectEq("https://www.googleapis.com/batch/storage/v1", req.URL.String())
+
+	// The operations
+	AssertEq(3, len(t.transport.ops))
+
+	ExpectEq("GET", t.transport.ops[0].Method)
+	ExpectEq(
+		"/storage/v1/b/some_bucket/o/foo?projection=full",
+		t.transport.ops[0].RequestURI)
+
+	ExpectEq("PATCH", t.transport.ops[1].Method)
+	ExpectEq(
+		"/storage/v1/b/some_bucket/o/bar?ifMetagenerationMatch=17&projection=full",
+		t.transport.ops[1].RequestURI)
+	ExpectEq("application/json", t.transport.ops[1].Header.Get("Content-Type"))
+	ExpectEq(`{"contentType":"text/plain"}`+"\n", t.transport.opBodies[1])
+
+	ExpectEq("DELETE", t.transport.ops[2].Method)
+	ExpectEq(
+		"/storage/v1/b/some_bucket/o/baz%2Fqux",
+		t.transport.ops[2].RequestURI)
+}
+
+func (t *BatchTest) Results() {
+	t.transport.respond = func(op *http.Request) string {
+		switch op.Method {
+		case "GET":
+			return httpResponse(
+				"200 OK",
+				`{"name": "foo", "generation": "3", "crc32c": "AAAAAA=="}`)
+
+		case "PATCH":
+			return httpResponse("412 Precondition Failed", "{}")
+
+		default:
+			return httpResponse("404 Not Found", "{}")
+		}
+	}
+
+	results, err := t.bucket.Batch(
+		t.ctx,
+		&BatchRequest{
+			Ops: []BatchOp{
+				{Stat: &StatObjectRequest{Name: "foo"}},
+				{Update: &UpdateObjectRequest{Name: "bar"}},
+				{Delete: &DeleteObjectRequest{Name: "baz"}},
+			},
+		})
+
+	AssertEq(nil, err)
+	AssertEq(3, len(results))
+
+	AssertEq(nil, results[0].Err)
+	ExpectEq("foo", results[0].Object.Name)
+	ExpectEq(3, results[0].Object.Generation)
+
+	ExpectThat(results[1].Err, HasSameTypeAs(&PreconditionError{}))
+	ExpectEq(nil, results[1].Object)
+
+	// Deletes are idempotent.
+	ExpectEq(nil, results[2].Err)
+	ExpectEq(nil, results[2].Object)
+}
+
+func (t *BatchTest) SplitsLargeBatches() {
+	t.transport.respond = func(op *http.Request) string {
+		return httpResponse("204 No Content", "")
+	}
+
+	req := &BatchRequest{}
+	for i := 0; i < 2*maxBatchSize+1; i++ {
+		req.Ops = append(req.Ops, BatchOp{
+			Delete: &DeleteObjectRequest{Name: fmt.Sprintf("%d", i)},
+		})
+	}
+
+	results, err := t.bucket.Batch(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq(len(req.Ops), len(results))
+	ExpectEq(3, len(t.transport.requests))
+	AssertEq(len(req.Ops), len(t.transport.ops))
+
+	for i, op := range t.transport.ops {
+		ExpectEq(fmt.Sprintf("/storage/v1/b/some_bucket/o/%d", i), op.RequestURI)
+	}
+}
+
+func (t *BatchTest) LaterChunkFails() {
+	t.transport.respond = func(op *http.Request) string {
+		return httpResponse("204 No Content", "")
+	}
+
+	t.transport.failRequest = 2
+
+	req := &BatchRequest{}
+	for i := 0; i < 2*maxBatchSize+1; i++ {
+		req.Ops = append(req.Ops, BatchOp{
+			Delete: &DeleteObjectRequest{Name: fmt.Sprintf("%d", i)},
+		})
+	}
+
+	// The results for the first chunk, which was carried out, should be
+	// returned along with the error.
+	results, err := t.bucket.Batch(t.ctx, req)
+	ExpectThat(err, Error(HasSubstr("taco")))
+	AssertEq(maxBatchSize, len(results))
+	for _, r := range results {
+		ExpectEq(nil, r.Err)
+	}
+
+	ExpectEq(2, len(t.transport.requests))
+}
+
+func (t *BatchTest) BatchFails() {
+	t.bucket = newBucket(
+		&http.Client{
+			Transport: &recordingTransport{},
+		},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+
+	// recordingTransport responds with HTTP 200 and no multipart body.
+	_, err := t.bucket.Batch(
+		t.ctx,
+		&BatchRequest{
+			Ops: []BatchOp{{Stat: &StatObjectRequest{Name: "foo"}}},
+		})
+
+	ExpectThat(err, Error(HasSubstr("ParseMediaType")))
+}
@@ -21,6 +21,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/jacobsa/gcloud/httputil"
 	"golang.org/x/net/context"
@@ -58,6 +59,10 @@ type Bucket interface {
 	// eventually for listing) after this method returns a nil error. It is
 	// guaranteed not to exist before req.Contents returns io.EOF.
 	//
+	// Returns a record for the new object as complete as the one StatObject
+	// would return, so there's no need for a further round trip, or nil if
+	// req.DiscardResponse is set.
+	//
 	// Official documentation:
 	//     https://cloud.google.com/storage/docs/json_api/v1/objects/insert
 	//     https://cloud.google.com/storage/docs/json_api/v1/how-tos/upload
@@ -96,6 +101,19 @@ type Bucket interface {
 		ctx context.Context,
 		req *ComposeObjectsRequest) (*Object, error)
 
+	// Copy an object using the rewrite API, which unlike CopyObject can write
+	// to another bucket, location, storage class, or encryption key. GCS may
+	// take several calls to finish large rewrites; these are made
+	// transparently, calling req.Progress after each.
+	//
+	// Returns a record for the new object.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/objects/rewrite
+	RewriteObject(
+		ctx context.Context,
+		req *RewriteObjectRequest) (*Object, error)
+
 	// Return current information about the object with the given name.
 	//
 	// Official documentation:
@@ -131,6 +149,77 @@ type Bucket interface {
 	DeleteObject(
 		ctx context.Context,
 		req *DeleteObjectRequest) error
+
+	// Restore a soft-deleted generation of an object, making it the live
+	// generation, and return a record for the restored object, which has a new
+	// generation number. Returns *NotFoundError if there is no such
+	// soft-deleted generation, and *PreconditionError if the precondition on
+	// the live generation isn't met.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/objects/restore
+	RestoreObject(
+		ctx context.Context,
+		req *RestoreObjectRequest) (*Object, error)
+
+	// Perform many StatObject, UpdateObject, and DeleteObject operations with
+	// as few round trips as possible, returning a result for each operation in
+	// the order they were supplied. A non-nil error means that the batch as a
+	// whole failed; errors for individual operations are reported in the
+	// results, with the same types as the corresponding methods would return.
+	//
+	// Large batches may be sent in several round trips. If one fails, the
+	// results for the operations carried out before it are returned along
+	// with the error, so that the first len(results) operations need not be
+	// repeated. Invalid operations are detected before anything is sent.
+	//
+	// Operations within a batch are not atomic and may be executed in any
+	// order, so a batch should not contain more than one operation on the same
+	// object.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/batch
+	Batch(
+		ctx context.Context,
+		req *BatchRequest) ([]BatchResult, error)
+
+	// Return the access control list of an object.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/objectAccessControls/list
+	ListObjectACLs(
+		ctx context.Context,
+		req *ListObjectACLsRequest) ([]*ACLRule, error)
+
+	// Grant a role on an object to an entity, replacing any role the entity
+	// already holds. Returns the resulting entry.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/objectAccessControls/insert
+	UpdateObjectACL(
+		ctx context.Context,
+		req *UpdateObjectACLRequest) (*ACLRule, error)
+
+	// Remove an entity's entry from the access control list of an object.
+	// Returns *NotFoundError if the object doesn't exist or the entity has no
+	// entry.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/objectAccessControls/delete
+	DeleteObjectACL(
+		ctx context.Context,
+		req *DeleteObjectACLRequest) error
+
+	// Return a URL that grants time-limited access to an object to anyone who
+	// holds it, signed using the V4 signing process with the credentials from
+	// ConnConfig.SigningCredentials. No request is made to GCS, and the object
+	// need not exist yet.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/access-control/signed-urls
+	SignedURL(
+		ctx context.Context,
+		req *SignedURLRequest) (string, error)
 }
 
 type ReadSeekCloser interface {
@@ -144,9 +233,71 @@ type readSeekCloser struct{
 }
 
 type bucket struct {
-	client    *http.Client
-	userAgent string
-	name      string
+	client          *http.Client
+	userAgent       string
+	name            string
+	uploadChunkSize int
+
+	// Nil if no signing credentials were supplied.
+	signer *urlSigner
+
+	// The project to bill for requests, or empty to bill the bucket's owner.
+	userProject string
+
+	// The endpoints serving the JSON API, its uploads, and its downloads, or
+	// nil for the default.
+	endpoint         *url.URL
+	uploadEndpoint   *url.URL
+	downloadEndpoint *url.URL
+
+	// The source of buffers for upload chunks.
+	bufferPool *BufferPool
+
+	// Contents of known size up to this many bytes are sent with a single
+	// multipart upload request. Zero disables this.
+	multipartUploadThreshold int64
+}
+
+// Add query parameters common to all requests made to the bucket.
+func (b *bucket) addCommonParams(query url.Values) {
+	if b.userProject != "" {
+		query.Set("userProject", b.userProject)
+	}
+}
+
+// Return a value for the "fields" query parameter selecting the supplied
+// fields of an object resource, plus its name.
+func partialResponseFields(fields []string) string {
+	for _, f := range fields {
+		if f == "name" {
+			return strings.Join(fields, ",")
+		}
+	}
+
+	return strings.Join(append([]string{"name"}, fields...), ",")
+}
+
+// Return a value for the "fields" query parameter of an object listing,
+// selecting the supplied fields of each object along with everything needed
+// to continue the listing.
+func partialListingFields(fields []string) string {
+	return fmt.Sprintf(
+		"items(%s),prefixes,nextPageToken",
+		partialResponseFields(fields))
+}
+
+// Decode the JSON body of a successful response into v, in a span of the
+// request's trace.
+func decodeResponse(httpRes *http.Response, v interface{}) (err error) {
+	ctx := context.Background()
+	if httpRes.Request != nil {
+		ctx = httpRes.Request.Context()
+	}
+
+	defer startSpanWithError(&ctx, &err, "Decode JSON response")()
+
+	err = json.NewDecoder(httpRes.Body).Decode(v)
+	return
 }
 
 func (b *bucket) Name() string {
@@ -163,8 +314,8 @@ func (b *bucket) ListObjects(
 	ctx context.Context,
 	req *ListObjectsRequest) (listing *Listing, err error) {
 	// Construct an appropriate URL (cf. http://goo.gl/aVSAhT).
-	opaque := fmt.Sprintf(
-		"//www.googleapis.com/storage/v1/b/%s/o",
+	path := fmt.Sprintf(
+		"/storage/v1/b/%s/o",
 		httputil.EncodePathSegment(b.Name()))
 
 	query := make(url.Values)
@@ -178,21 +329,46 @@ func (b *bucket) ListObjects(
 		query.Set("delimiter", req.Delimiter)
 	}
 
+	if req.IncludeTrailingDelimiter {
+		query.Set("includeTrailingDelimiter", "true")
+	}
+
 	if req.ContinuationToken != "" {
 		query.Set("pageToken", req.ContinuationToken)
 	}
 
+	if req.Versions {
+		query.Set("versions", "true")
+	}
+
 	if req.MaxResults != 0 {
 		query.Set("maxResults", fmt.Sprintf("%v", req.MaxResults))
 	}
 
-	url := &url.URL{
-		Scheme:   "https",
-		Host:     "www.googleapis.com",
-		Opaque:   opaque,
-		RawQuery: query.Encode(),
+	if len(req.Fields) != 0 {
+		query.Set("fields", partialListingFields(req.Fields))
+	}
+
+	if req.StartOffset != "" {
+		query.Set("startOffset", req.StartOffset)
+	}
+
+	if req.EndOffset != "" {
+		query.Set("endOffset", req.EndOffset)
+	}
+
+	if req.MatchGlob != "" {
+		query.Set("matchGlob", req.MatchGlob)
+	}
+
+	if req.SoftDeleted {
+		query.Set("softDeleted", "true")
 	}
 
+	b.addCommonParams(query)
+
+	url := makeURL(b.endpoint, path, query)
+
 	// Create an HTTP request.
 	httpReq, err := httputil.NewRequest(ctx, "GET", url, nil, 0, b.userAgent)
 	if err != nil {
@@ -209,13 +385,13 @@ func (b *bucket) ListObjects(
 	defer googleapi.CloseBody(httpRes)
 
 	// Check for HTTP-level errors.
-	if err = googleapi.CheckResponse(httpRes); err != nil {
+	if err = checkResponse(httpRes); err != nil {
 		return
 	}
 
 	// Parse the response.
 	var rawListing *storagev1.Objects
-	if err = json.NewDecoder(httpRes.Body).Decode(&rawListing); err != nil {
+	if err = decodeResponse(httpRes, &rawListing); err != nil {
 		return
 	}
 
@@ -230,39 +406,73 @@ func (b *bucket) ListObjects(
 func (b *bucket) StatObject(
 	ctx context.Context,
 	req *StatObjectRequest) (o *Object, err error) {
+	// Create an HTTP request.
+	httpReq, err := b.makeStatObjectRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// Execute the HTTP request.
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	o, err = handleStatObjectResponse(httpRes)
+	return
+}
+
+func (b *bucket) makeStatObjectRequest(
+	ctx context.Context,
+	req *StatObjectRequest) (httpReq *http.Request, err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
 	// Construct an appropriate URL (cf. http://goo.gl/MoITmB).
-	opaque := fmt.Sprintf(
-		"//www.googleapis.com/storage/v1/b/%s/o/%s",
+	path := fmt.Sprintf(
+		"/storage/v1/b/%s/o/%s",
 		httputil.EncodePathSegment(b.Name()),
 		httputil.EncodePathSegment(req.Name))
 
 	query := make(url.Values)
 	query.Set("projection", "full")
 
-	url := &url.URL{
-		Scheme:   "https",
-		Host:     "www.googleapis.com",
-		Opaque:   opaque,
-		RawQuery: query.Encode(),
+	if len(req.Fields) != 0 {
+		query.Set("fields", partialResponseFields(req.Fields))
 	}
 
-	// Create an HTTP request.
-	httpReq, err := httputil.NewRequest(ctx, "GET", url, nil, 0, b.userAgent)
-	if err != nil {
-		err = fmt.Errorf("httputil.NewRequest: %v", err)
-		return
+	if req.GenerationPrecondition != nil {
+		query.Set(
+			"ifGenerationMatch",
+			fmt.Sprintf("%d", *req.GenerationPrecondition))
 	}
 
-	// Execute the HTTP request.
-	httpRes, err := b.client.Do(httpReq)
+	if req.MetaGenerationPrecondition != nil {
+		query.Set(
+			"ifMetagenerationMatch",
+			fmt.Sprintf("%d", *req.MetaGenerationPrecondition))
+	}
+
+	b.addCommonParams(query)
+
+	url := makeURL(b.endpoint, path, query)
+
+	// Create an HTTP request.
+	httpReq, err = httputil.NewRequest(ctx, "GET", url, nil, 0, b.userAgent)
 	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
 		return
 	}
 
-	defer googleapi.CloseBody(httpRes)
+	return
+}
 
+func handleStatObjectResponse(httpRes *http.Response) (o *Object, err error) {
 	// Check for HTTP-level errors.
-	if err = googleapi.CheckResponse(httpRes); err != nil {
+	if err = checkResponse(httpRes); err != nil {
 		// Special case: handle not found errors.
 		if typed, ok := err.(*googleapi.Error); ok {
 			if typed.Code == http.StatusNotFound {
@@ -270,12 +480,19 @@ func (b *bucket) StatObject(
 			}
 		}
 
+		// Special case: handle precondition errors.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusPreconditionFailed {
+				err = &PreconditionError{Err: typed}
+			}
+		}
+
 		return
 	}
 
 	// Parse the response.
 	var rawObject *storagev1.Object
-	if err = json.NewDecoder(httpRes.Body).Decode(&rawObject); err != nil {
+	if err = decodeResponse(httpRes, &rawObject); err != nil {
 		return
 	}
 
@@ -291,9 +508,34 @@ func (b *bucket) StatObject(
 func (b *bucket) DeleteObject(
 	ctx context.Context,
 	req *DeleteObjectRequest) (err error) {
+	// Create an HTTP request.
+	httpReq, err := b.makeDeleteObjectRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// Execute the HTTP request.
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	err = handleDeleteObjectResponse(httpRes)
+	return
+}
+
+func (b *bucket) makeDeleteObjectRequest(
+	ctx context.Context,
+	req *DeleteObjectRequest) (httpReq *http.Request, err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
 	// Construct an appropriate URL (cf. http://goo.gl/TRQJjZ).
-	opaque := fmt.Sprintf(
-		"//www.googleapis.com/storage/v1/b/%s/o/%s",
+	path := fmt.Sprintf(
+		"/storage/v1/b/%s/o/%s",
 		httputil.EncodePathSegment(b.Name()),
 		httputil.EncodePathSegment(req.Name))
 
@@ -303,36 +545,35 @@ func (b *bucket) DeleteObject(
 		query.Set("generation", fmt.Sprintf("%d", req.Generation))
 	}
 
+	if req.GenerationPrecondition != nil {
+		query.Set(
+			"ifGenerationMatch",
+			fmt.Sprintf("%d", *req.GenerationPrecondition))
+	}
+
 	if req.MetaGenerationPrecondition != nil {
 		query.Set(
 			"ifMetagenerationMatch",
 			fmt.Sprintf("%d", *req.MetaGenerationPrecondition))
 	}
 
-	url := &url.URL{
-		Scheme:   "https",
-		Host:     "www.googleapis.com",
-		Opaque:   opaque,
-		RawQuery: query.Encode(),
-	}
+	b.addCommonParams(query)
+
+	url := makeURL(b.endpoint, path, query)
 
 	// Create an HTTP request.
-	httpReq, err := httputil.NewRequest(ctx, "DELETE", url, nil, 0, b.userAgent)
+	httpReq, err = httputil.NewRequest(ctx, "DELETE", url, nil, 0, b.userAgent)
 	if err != nil {
 		err = fmt.Errorf("httputil.NewRequest: %v", err)
 		return
 	}
 
-	// Execute the HTTP request.
-	httpRes, err := b.client.Do(httpReq)
-	if err != nil {
-		return
-	}
-
-	defer googleapi.CloseBody(httpRes)
+	return
+}
 
+func handleDeleteObjectResponse(httpRes *http.Response) (err error) {
 	// Check for HTTP-level errors.
-	err = googleapi.CheckResponse(httpRes)
+	err = checkResponse(httpRes)
 
 	// Special case: we want deletes to be idempotent.
 	if typed, ok := err.(*googleapi.Error); ok {
@@ -359,10 +600,17 @@ func (b *bucket) DeleteObject(
 func newBucket(
 	client *http.Client,
 	userAgent string,
-	name string) Bucket {
+	name string,
+	uploadChunkSize int,
+	signer *urlSigner,
+	userProject string) *bucket {
 	return &bucket{
-		client:    client,
-		userAgent: userAgent,
-		name:      name,
+		client:          client,
+		userAgent:       userAgent,
+		name:            name,
+		uploadChunkSize: uploadChunkSize,
+		signer:          signer,
+		userProject:     userProject,
+		bufferPool:      defaultBufferPool,
 	}
 }
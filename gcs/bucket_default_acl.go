@@ -0,0 +1,188 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// Return the URL for the default object ACL of the given bucket, or for a
+// particular entity within it if entity is non-empty.
+func (c *conn) defaultObjectACLURL(name string, entity string) (u *url.URL) {
+	suffix := "/defaultObjectAcl"
+	if entity != "" {
+		suffix += "/" + httputil.EncodePathSegment(entity)
+	}
+
+	u = c.bucketURL(name, suffix, make(url.Values))
+	return
+}
+
+// Execute the supplied request, translating 404 responses into
+// *NotFoundError. On success the caller must close the response body.
+func (c *conn) doDefaultObjectACLRequest(
+	httpReq *http.Request) (httpRes *http.Response, err error) {
+	httpRes, err = c.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	// Check for HTTP-level errors.
+	if err = checkResponse(httpRes); err != nil {
+		googleapi.CloseBody(httpRes)
+
+		// Special case: handle not found errors.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusNotFound {
+				err = &NotFoundError{Err: typed}
+			}
+		}
+
+		return
+	}
+
+	return
+}
+
+func (c *conn) ListDefaultObjectACLs(
+	ctx context.Context,
+	bucketName string) (rules []*ACLRule, err error) {
+	// Create an HTTP request.
+	url := c.defaultObjectACLURL(bucketName, "")
+	httpReq, err := httputil.NewRequest(ctx, "GET", url, nil, 0, c.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	// Execute the HTTP request.
+	httpRes, err := c.doDefaultObjectACLRequest(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Parse the response.
+	var raw *storagev1.ObjectAccessControls
+	if err = json.NewDecoder(httpRes.Body).Decode(&raw); err != nil {
+		return
+	}
+
+	// Convert the response.
+	for _, item := range raw.Items {
+		rules = append(rules, toACLRule(item))
+	}
+
+	return
+}
+
+func (c *conn) UpdateDefaultObjectACL(
+	ctx context.Context,
+	req *UpdateDefaultObjectACLRequest) (rule *ACLRule, err error) {
+	// Validate the request, since GCS's errors for these are unhelpful.
+	if req.Entity == "" {
+		err = errors.New("Entity must be specified")
+		return
+	}
+
+	if req.Role != ACLRoleReader && req.Role != ACLRoleOwner {
+		err = fmt.Errorf("Unsupported role: %q", req.Role)
+		return
+	}
+
+	// Set up the request body. Inserting an entry for an entity that already
+	// has one replaces its role.
+	body, err := json.Marshal(&storagev1.ObjectAccessControl{
+		Entity: req.Entity,
+		Role:   req.Role,
+	})
+
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	// Create an HTTP request.
+	url := c.defaultObjectACLURL(req.BucketName, "")
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"POST",
+		url,
+		ioutil.NopCloser(bytes.NewReader(body)),
+		int64(len(body)),
+		c.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Execute the HTTP request.
+	httpRes, err := c.doDefaultObjectACLRequest(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Parse the response.
+	var raw *storagev1.ObjectAccessControl
+	if err = json.NewDecoder(httpRes.Body).Decode(&raw); err != nil {
+		return
+	}
+
+	rule = toACLRule(raw)
+	return
+}
+
+func (c *conn) DeleteDefaultObjectACL(
+	ctx context.Context,
+	req *DeleteDefaultObjectACLRequest) (err error) {
+	if req.Entity == "" {
+		err = errors.New("Entity must be specified")
+		return
+	}
+
+	// Create an HTTP request.
+	url := c.defaultObjectACLURL(req.BucketName, req.Entity)
+	httpReq, err := httputil.NewRequest(ctx, "DELETE", url, nil, 0, c.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	// Execute the HTTP request.
+	httpRes, err := c.doDefaultObjectACLRequest(httpReq)
+	if err != nil {
+		return
+	}
+
+	googleapi.CloseBody(httpRes)
+	return
+}
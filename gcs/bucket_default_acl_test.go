@@ -0,0 +1,148 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestDefaultObjectACL(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type DefaultObjectACLTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	conn      Conn
+}
+
+var _ SetUpInterface = &DefaultObjectACLTest{}
+
+func init() { RegisterTestSuite(&DefaultObjectACLTest{}) }
+
+func (t *DefaultObjectACLTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.conn = &conn{
+		client:    &http.Client{Transport: &t.transport},
+		userAgent: "test",
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *DefaultObjectACLTest) List() {
+	t.transport.response = `{
+		"items": [
+			{"entity": "project-owners-123", "role": "OWNER"},
+			{"entity": "allUsers", "role": "READER"}
+		]
+	}`
+
+	rules, err := t.conn.ListDefaultObjectACLs(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("GET", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/defaultObjectAcl",
+		httpReq.URL.Opaque)
+
+	// Response
+	AssertEq(2, len(rules))
+	ExpectEq("project-owners-123", rules[0].Entity)
+	ExpectEq(ACLRoleOwner, rules[0].Role)
+	ExpectEq("allUsers", rules[1].Entity)
+	ExpectEq(ACLRoleReader, rules[1].Role)
+}
+
+func (t *DefaultObjectACLTest) List_NotFound() {
+	t.transport.status = http.StatusNotFound
+	t.transport.response = `{"error": {"code": 404, "message": "Not Found"}}`
+
+	_, err := t.conn.ListDefaultObjectACLs(t.ctx, "some_bucket")
+	ExpectThat(err, HasSameTypeAs(&NotFoundError{}))
+}
+
+func (t *DefaultObjectACLTest) Update() {
+	t.transport.response = `{"entity": "allUsers", "role": "READER"}`
+
+	req := &UpdateDefaultObjectACLRequest{
+		BucketName: "some_bucket",
+		Entity:     "allUsers",
+		Role:       ACLRoleReader,
+	}
+
+	rule, err := t.conn.UpdateDefaultObjectACL(t.ctx, req)
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("POST", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/defaultObjectAcl",
+		httpReq.URL.Opaque)
+
+	body, err := decodeBody(httpReq)
+	AssertEq(nil, err)
+	ExpectEq("allUsers", body["entity"])
+	ExpectEq("READER", body["role"])
+
+	// Response
+	ExpectEq("allUsers", rule.Entity)
+	ExpectEq(ACLRoleReader, rule.Role)
+}
+
+func (t *DefaultObjectACLTest) Update_UnsupportedRole() {
+	req := &UpdateDefaultObjectACLRequest{
+		BucketName: "some_bucket",
+		Entity:     "allUsers",
+		Role:       "WRITER",
+	}
+
+	_, err := t.conn.UpdateDefaultObjectACL(t.ctx, req)
+	ExpectThat(err, Error(HasSubstr("WRITER")))
+	ExpectEq(0, len(t.transport.requests))
+}
+
+func (t *DefaultObjectACLTest) Delete() {
+	req := &DeleteDefaultObjectACLRequest{
+		BucketName: "some_bucket",
+		Entity:     "user-foo@example.com",
+	}
+
+	err := t.conn.DeleteDefaultObjectACL(t.ctx, req)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("DELETE", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/defaultObjectAcl/"+
+			"user-foo@example.com",
+		httpReq.URL.Opaque)
+}
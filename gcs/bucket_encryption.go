@@ -0,0 +1,77 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+)
+
+func (c *conn) SetBucketDefaultKMSKey(
+	ctx context.Context,
+	req *SetBucketDefaultKMSKeyRequest) (bi *BucketInfo, err error) {
+	query := make(url.Values)
+	query.Set("projection", "full")
+
+	if req.MetaGenerationPrecondition != nil {
+		query.Set(
+			"ifMetagenerationMatch",
+			fmt.Sprint(*req.MetaGenerationPrecondition))
+	}
+
+	url := c.bucketURL(req.BucketName, "", query)
+
+	// Set up the request body. Null encryption settings remove the default key.
+	jsonMap := map[string]interface{}{
+		"encryption": nil,
+	}
+
+	if req.KMSKeyName != "" {
+		jsonMap["encryption"] = map[string]interface{}{
+			"defaultKmsKeyName": req.KMSKeyName,
+		}
+	}
+
+	body, err := json.Marshal(jsonMap)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"PATCH",
+		url,
+		ioutil.NopCloser(bytes.NewReader(body)),
+		int64(len(body)),
+		c.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	bi, err = c.doBucketRequest(httpReq)
+	return
+}
@@ -0,0 +1,166 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestBucketEncryption(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+const testKMSKeyName = "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+
+type BucketEncryptionTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	conn      Conn
+}
+
+var _ SetUpInterface = &BucketEncryptionTest{}
+
+func init() { RegisterTestSuite(&BucketEncryptionTest{}) }
+
+func (t *BucketEncryptionTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.conn = &conn{
+		client:    &http.Client{Transport: &t.transport},
+		userAgent: "test",
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *BucketEncryptionTest) GetBucket() {
+	t.transport.response = `{
+		"name": "some_bucket",
+		"metageneration": "3",
+		"encryption": {"defaultKmsKeyName": "` + testKMSKeyName + `"}
+	}`
+
+	bi, err := t.conn.GetBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("GET", httpReq.Method)
+	ExpectEq("//www.googleapis.com/storage/v1/b/some_bucket", httpReq.URL.Opaque)
+
+	// Response
+	ExpectEq("some_bucket", bi.Name)
+	ExpectEq(3, bi.MetaGeneration)
+	ExpectEq(testKMSKeyName, bi.DefaultKMSKeyName)
+}
+
+func (t *BucketEncryptionTest) GetBucket_NotFound() {
+	t.transport.status = http.StatusNotFound
+	t.transport.response = `{"error": {"code": 404, "message": "Not Found"}}`
+
+	_, err := t.conn.GetBucket(t.ctx, "some_bucket")
+	ExpectThat(err, HasSameTypeAs(&NotFoundError{}))
+}
+
+func (t *BucketEncryptionTest) Set() {
+	t.transport.response = `{
+		"name": "some_bucket",
+		"metageneration": "18",
+		"encryption": {"defaultKmsKeyName": "` + testKMSKeyName + `"}
+	}`
+
+	precond := int64(17)
+	req := &SetBucketDefaultKMSKeyRequest{
+		BucketName:                 "some_bucket",
+		KMSKeyName:                 testKMSKeyName,
+		MetaGenerationPrecondition: &precond,
+	}
+
+	bi, err := t.conn.SetBucketDefaultKMSKey(t.ctx, req)
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("PATCH", httpReq.Method)
+	ExpectEq("//www.googleapis.com/storage/v1/b/some_bucket", httpReq.URL.Opaque)
+	ExpectEq("17", httpReq.URL.Query().Get("ifMetagenerationMatch"))
+
+	body, err := decodeBody(httpReq)
+	AssertEq(nil, err)
+	ExpectThat(
+		body["encryption"],
+		DeepEquals(map[string]interface{}{"defaultKmsKeyName": testKMSKeyName}))
+
+	// Response
+	ExpectEq(18, bi.MetaGeneration)
+	ExpectEq(testKMSKeyName, bi.DefaultKMSKeyName)
+}
+
+func (t *BucketEncryptionTest) Remove() {
+	t.transport.response = `{"name": "some_bucket"}`
+
+	req := &SetBucketDefaultKMSKeyRequest{
+		BucketName: "some_bucket",
+	}
+
+	bi, err := t.conn.SetBucketDefaultKMSKey(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq("", bi.DefaultKMSKeyName)
+
+	AssertEq(1, len(t.transport.requests))
+	body, err := decodeBody(t.transport.requests[0])
+	AssertEq(nil, err)
+
+	v, ok := body["encryption"]
+	ExpectTrue(ok)
+	ExpectEq(nil, v)
+}
+
+func (t *BucketEncryptionTest) CreateObjectWithKey() {
+	b := newBucket(
+		&http.Client{Transport: &t.transport},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+
+	// The transport doesn't supply an upload URL, so we expect the upload to
+	// fail after starting the session.
+	b.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:       "foo",
+			Contents:   strings.NewReader("taco"),
+			KMSKeyName: testKMSKeyName,
+		})
+
+	AssertLe(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("resumable", query.Get("uploadType"))
+	ExpectEq(testKMSKeyName, query.Get("kmsKeyName"))
+}
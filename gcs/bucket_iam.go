@@ -0,0 +1,259 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// IAMPolicyVersion is the IAM policy format version requested by
+// Conn.GetBucketIAMPolicy. It is the only version that supports conditional
+// bindings.
+const IAMPolicyVersion = 3
+
+// IAMPolicy is the IAM policy of a bucket, as returned by
+// Conn.GetBucketIAMPolicy and accepted by Conn.SetBucketIAMPolicy.
+//
+// See here for more information about its fields:
+//
+//     https://cloud.google.com/storage/docs/json_api/v1/buckets/getIamPolicy
+//
+type IAMPolicy struct {
+	// The policy format version. Policies containing conditional bindings must
+	// have version IAMPolicyVersion.
+	Version int64
+
+	// Bindings of roles to members.
+	Bindings []IAMBinding
+
+	// An opaque token identifying this revision of the policy. When a policy
+	// obtained from GetBucketIAMPolicy is modified and handed back to
+	// SetBucketIAMPolicy with this field intact, the update fails with an error
+	// of type *PreconditionError if the policy has changed in the meantime.
+	// Leave it empty to overwrite unconditionally.
+	Etag string
+}
+
+// IAMBinding grants a role to a set of members, optionally subject to a
+// condition.
+type IAMBinding struct {
+	// The role granted, e.g. "roles/storage.objectViewer".
+	Role string
+
+	// The members to which the role is granted, e.g. "user:foo@example.com",
+	// "serviceAccount:bar@baz.iam.gserviceaccount.com", or "allUsers".
+	Members []string
+
+	// If non-nil, the binding applies only when this condition holds.
+	Condition *IAMCondition
+}
+
+// IAMCondition is a condition on an IAM binding, expressed in the Common
+// Expression Language. See here for more information:
+//
+//     https://cloud.google.com/iam/docs/conditions-overview
+//
+type IAMCondition struct {
+	Title       string
+	Description string
+	Expression  string
+}
+
+// A request to replace the IAM policy of a bucket, accepted by
+// Conn.SetBucketIAMPolicy.
+type SetBucketIAMPolicyRequest struct {
+	// The name of the bucket in question. Must be specified.
+	BucketName string
+
+	// The new policy. Must be non-nil.
+	Policy *IAMPolicy
+}
+
+func toIAMPolicy(in *storagev1.Policy) (out *IAMPolicy) {
+	out = &IAMPolicy{
+		Version: in.Version,
+		Etag:    in.Etag,
+	}
+
+	for _, b := range in.Bindings {
+		binding := IAMBinding{
+			Role:    b.Role,
+			Members: b.Members,
+		}
+
+		if b.Condition != nil {
+			binding.Condition = &IAMCondition{
+				Title:       b.Condition.Title,
+				Description: b.Condition.Description,
+				Expression:  b.Condition.Expression,
+			}
+		}
+
+		out.Bindings = append(out.Bindings, binding)
+	}
+
+	return
+}
+
+func fromIAMPolicy(in *IAMPolicy) (out *storagev1.Policy) {
+	out = &storagev1.Policy{
+		Version: in.Version,
+		Etag:    in.Etag,
+	}
+
+	for _, b := range in.Bindings {
+		binding := &storagev1.PolicyBindings{
+			Role:    b.Role,
+			Members: b.Members,
+		}
+
+		if b.Condition != nil {
+			binding.Condition = &storagev1.Expr{
+				Title:       b.Condition.Title,
+				Description: b.Condition.Description,
+				Expression:  b.Condition.Expression,
+			}
+		}
+
+		out.Bindings = append(out.Bindings, binding)
+	}
+
+	return
+}
+
+// Return the URL for the IAM policy of the named bucket, with the supplied
+// query parameters in addition to the common ones.
+func (c *conn) bucketIAMURL(name string, query url.Values) (u *url.URL) {
+	if c.userProject != "" {
+		query.Set("userProject", c.userProject)
+	}
+
+	u = makeURL(
+		c.endpoint,
+		fmt.Sprintf("/storage/v1/b/%s/iam", httputil.EncodePathSegment(name)),
+		query)
+
+	return
+}
+
+// Execute the supplied request and parse the policy it returns.
+func (c *conn) doIAMRequest(httpReq *http.Request) (p *IAMPolicy, err error) {
+	// Execute the HTTP request.
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Check for HTTP-level errors.
+	if err = checkResponse(httpRes); err != nil {
+		// Special case: handle not found errors.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusNotFound {
+				err = &NotFoundError{Err: typed}
+			}
+		}
+
+		// Special case: handle etag mismatches.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusPreconditionFailed {
+				err = &PreconditionError{Err: typed}
+			}
+		}
+
+		return
+	}
+
+	// Parse the response.
+	var rawPolicy *storagev1.Policy
+	if err = json.NewDecoder(httpRes.Body).Decode(&rawPolicy); err != nil {
+		return
+	}
+
+	p = toIAMPolicy(rawPolicy)
+	return
+}
+
+func (c *conn) GetBucketIAMPolicy(
+	ctx context.Context,
+	name string) (p *IAMPolicy, err error) {
+	// Ask for the version that can represent conditional bindings. Without
+	// this, GCS refuses to return policies that contain them.
+	query := make(url.Values)
+	query.Set(
+		"optionsRequestedPolicyVersion",
+		fmt.Sprintf("%d", IAMPolicyVersion))
+
+	url := c.bucketIAMURL(name, query)
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(ctx, "GET", url, nil, 0, c.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	p, err = c.doIAMRequest(httpReq)
+	return
+}
+
+func (c *conn) SetBucketIAMPolicy(
+	ctx context.Context,
+	req *SetBucketIAMPolicyRequest) (p *IAMPolicy, err error) {
+	if req.Policy == nil {
+		err = errors.New("Policy must be non-nil")
+		return
+	}
+
+	url := c.bucketIAMURL(req.BucketName, make(url.Values))
+
+	// Set up the request body.
+	body, err := json.Marshal(fromIAMPolicy(req.Policy))
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"PUT",
+		url,
+		ioutil.NopCloser(bytes.NewReader(body)),
+		int64(len(body)),
+		c.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	p, err = c.doIAMRequest(httpReq)
+	return
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestBucketIAM(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type BucketIAMTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	conn      Conn
+}
+
+var _ SetUpInterface = &BucketIAMTest{}
+
+func init() { RegisterTestSuite(&BucketIAMTest{}) }
+
+func (t *BucketIAMTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.conn = &conn{
+		client:      &http.Client{Transport: &t.transport},
+		userAgent:   "test",
+		userProject: "some-project",
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *BucketIAMTest) Get() {
+	t.transport.response = `{
+		"version": 3,
+		"etag": "CAE=",
+		"bindings": [
+			{
+				"role": "roles/storage.objectViewer",
+				"members": ["allUsers"],
+				"condition": {"title": "t", "expression": "e"}
+			}
+		]
+	}`
+
+	p, err := t.conn.GetBucketIAMPolicy(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("GET", httpReq.Method)
+	ExpectEq("//www.googleapis.com/storage/v1/b/some_bucket/iam", httpReq.URL.Opaque)
+
+	query := httpReq.URL.Query()
+	ExpectEq("3", query.Get("optionsRequestedPolicyVersion"))
+	ExpectEq("some-project", query.Get("userProject"))
+
+	// Response
+	ExpectEq(3, p.Version)
+	ExpectEq("CAE=", p.Etag)
+	AssertEq(1, len(p.Bindings))
+	ExpectEq("roles/storage.objectViewer", p.Bindings[0].Role)
+	ExpectThat(p.Bindings[0].Members, ElementsAre("allUsers"))
+	AssertNe(nil, p.Bindings[0].Condition)
+	ExpectEq("t", p.Bindings[0].Condition.Title)
+	ExpectEq("e", p.Bindings[0].Condition.Expression)
+}
+
+func (t *BucketIAMTest) Set() {
+	t.transport.response = `{"version": 3, "etag": "CAI="}`
+
+	req := &SetBucketIAMPolicyRequest{
+		BucketName: "some_bucket",
+		Policy: &IAMPolicy{
+			Version: 3,
+			Etag:    "CAE=",
+			Bindings: []IAMBinding{
+				{
+					Role:      "roles/storage.admin",
+					Members:   []string{"user:foo@example.com"},
+					Condition: &IAMCondition{Expression: "e"},
+				},
+			},
+		},
+	}
+
+	p, err := t.conn.SetBucketIAMPolicy(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq("CAI=", p.Etag)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("PUT", httpReq.Method)
+	ExpectEq("//www.googleapis.com/storage/v1/b/some_bucket/iam", httpReq.URL.Opaque)
+
+	body, err := ioutil.ReadAll(httpReq.Body)
+	AssertEq(nil, err)
+
+	var sent struct {
+		Version  int64
+		Etag     string
+		Bindings []struct {
+			Role      string
+			Members   []string
+			Condition struct{ Expression string }
+		}
+	}
+
+	AssertEq(nil, json.Unmarshal(body, &sent))
+	ExpectEq(3, sent.Version)
+	ExpectEq("CAE=", sent.Etag)
+	AssertEq(1, len(sent.Bindings))
+	ExpectEq("roles/storage.admin", sent.Bindings[0].Role)
+	ExpectThat(sent.Bindings[0].Members, ElementsAre("user:foo@example.com"))
+	ExpectEq("e", sent.Bindings[0].Condition.Expression)
+}
+
+func (t *BucketIAMTest) Set_NilPolicy() {
+	_, err := t.conn.SetBucketIAMPolicy(
+		t.ctx,
+		&SetBucketIAMPolicyRequest{BucketName: "some_bucket"})
+
+	ExpectThat(err, Error(HasSubstr("Policy")))
+	ExpectEq(0, len(t.transport.requests))
+}
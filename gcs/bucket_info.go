@@ -0,0 +1,383 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import "time"
+
+// BucketInfo is a record representing the attributes of a GCS bucket, as
+// returned by Conn.CreateBucket and Conn.ListBuckets.
+//
+// See here for more information about its fields:
+//
+//     https://cloud.google.com/storage/docs/json_api/v1/buckets#resource
+//
+type BucketInfo struct {
+	Name           string
+	Location       string
+	LocationType   string
+	StorageClass   string
+	MetaGeneration int64
+	Created        time.Time
+	Updated        time.Time
+
+	// For a configurable dual-region bucket, the regions in which its data is
+	// stored.
+	DataLocations []string
+
+	// The bucket's recovery point objective, which is RPODefault or
+	// RPOAsyncTurbo for dual-region buckets and empty otherwise.
+	RPO string
+
+	// Whether Autoclass is enabled for the bucket, and if so the storage class
+	// to which objects that aren't accessed eventually transition.
+	Autoclass                     bool
+	AutoclassTerminalStorageClass string
+
+	// Whether uniform bucket-level access is enabled, in which case access is
+	// controlled solely by IAM and object ACLs are disabled.
+	UniformBucketLevelAccess bool
+
+	// User-supplied labels, used for example to attribute costs.
+	Labels map[string]string
+
+	// Whether object versioning is enabled, in which case overwritten and
+	// deleted objects are kept as noncurrent versions.
+	VersioningEnabled bool
+
+	// The ACL applied to objects created in the bucket without one of their
+	// own. Only returned with ProjectionFull.
+	DefaultObjectACL []ACLRule
+
+	// The bucket's static website configuration, or nil if it has none.
+	Website *WebsiteConfig
+
+	// The bucket's cross-origin resource sharing rules.
+	CORS []CORSRule
+
+	// The bucket's retention policy, or nil if it has none.
+	RetentionPolicy *RetentionPolicy
+
+	// The resource name of the Cloud KMS key used to encrypt objects created
+	// in the bucket without a key of their own, or empty if GCS manages the
+	// encryption keys.
+	DefaultKMSKeyName string
+
+	// The bucket's soft delete policy, or nil if soft delete is disabled.
+	SoftDeletePolicy *SoftDeletePolicy
+}
+
+// RetentionPolicy describes the minimum time for which objects in a bucket
+// must be kept, as set with Conn.SetBucketRetentionPolicy. See here for more
+// information:
+//
+//     https://cloud.google.com/storage/docs/bucket-lock
+//
+type RetentionPolicy struct {
+	// How long after creation an object must be retained before it may be
+	// deleted or overwritten. GCS has a granularity of one second.
+	Period time.Duration
+
+	// When the policy took effect.
+	EffectiveTime time.Time
+
+	// Whether the policy has been locked with Conn.LockBucketRetentionPolicy,
+	// after which it can no longer be removed or its period reduced.
+	IsLocked bool
+}
+
+// SoftDeletePolicy describes how long deleted and overwritten objects in a
+// bucket are kept in a soft-deleted state, in which they can be listed with
+// ListObjectsRequest.SoftDeleted and restored with Bucket.RestoreObject. See
+// here for more information:
+//
+//     https://cloud.google.com/storage/docs/soft-delete
+//
+type SoftDeletePolicy struct {
+	// How long soft-deleted objects are kept before being permanently deleted.
+	RetentionDuration time.Duration
+
+	// When the policy took effect.
+	EffectiveTime time.Time
+}
+
+// WebsiteConfig controls how GCS serves a bucket as a static website. See
+// here for more information:
+//
+//     https://cloud.google.com/storage/docs/hosting-static-website
+//
+type WebsiteConfig struct {
+	// The object name suffix served for requests naming a directory, for
+	// example "index.html".
+	MainPageSuffix string
+
+	// The object served, with a 404 status, for requests naming an object that
+	// doesn't exist.
+	NotFoundPage string
+}
+
+// CORSRule is a cross-origin resource sharing rule for a bucket. See here for
+// more information:
+//
+//     https://cloud.google.com/storage/docs/cross-origin
+//
+type CORSRule struct {
+	// The origins allowed to make cross-origin requests, for example
+	// "https://example.com", or "*" for any origin.
+	Origins []string
+
+	// The HTTP methods for which CORS headers are returned, for example "GET".
+	Methods []string
+
+	// The response headers the browser may expose to the requesting origin.
+	ResponseHeaders []string
+
+	// How long the browser may cache the results of a preflight request. GCS
+	// has a granularity of one second.
+	MaxAge time.Duration
+}
+
+// Location types, as reported in BucketInfo.LocationType. The location type
+// of a bucket follows from its location; see here for the available
+// locations:
+//
+//     https://cloud.google.com/storage/docs/locations
+//
+const (
+	LocationTypeRegion      = "region"
+	LocationTypeDualRegion  = "dual-region"
+	LocationTypeMultiRegion = "multi-region"
+)
+
+// Recovery point objectives for dual-region buckets. RPOAsyncTurbo enables
+// turbo replication, which replicates new objects between the two regions
+// within 15 minutes. See here for more information:
+//
+//     https://cloud.google.com/storage/docs/availability-durability#turbo-replication
+//
+const (
+	RPODefault    = "DEFAULT"
+	RPOAsyncTurbo = "ASYNC_TURBO"
+)
+
+// A request to create a bucket, accepted by Conn.CreateBucket.
+type CreateBucketRequest struct {
+	// The name of the bucket to create. This field must be set. See here for
+	// the naming rules:
+	//
+	//     https://cloud.google.com/storage/docs/bucket-naming#requirements
+	//
+	Name string
+
+	// Optional information with which to create the bucket. If empty, GCS's
+	// defaults (currently the US multi-region and the STANDARD storage class)
+	// are used.
+	Location     string
+	StorageClass string
+
+	// To create a configurable dual-region bucket, set Location to a
+	// multi-region such as "US" and this to the two regions within it in which
+	// to store data, for example []string{"US-EAST1", "US-WEST1"}.
+	DataLocations []string
+
+	// If non-empty, the recovery point objective for a dual-region bucket.
+	// Set to RPOAsyncTurbo to enable turbo replication.
+	RPO string
+
+	// Whether to enable Autoclass, which moves each object between storage
+	// classes according to how it is accessed. StorageClass must then be empty
+	// or STANDARD. If non-empty, AutoclassTerminalStorageClass is the class to
+	// which objects eventually transition: NEARLINE (the default) or ARCHIVE.
+	Autoclass                     bool
+	AutoclassTerminalStorageClass string
+
+	// Whether to enable uniform bucket-level access, so that access to objects
+	// is controlled solely by IAM and object ACLs are disabled.
+	UniformBucketLevelAccess bool
+
+	// Labels with which to create the bucket, if any.
+	Labels map[string]string
+
+	// If non-empty, the resource name of the Cloud KMS key with which to
+	// encrypt objects created in the bucket without a key of their own. GCS's
+	// service account for the project must be allowed to use the key.
+	DefaultKMSKeyName string
+}
+
+// A request to update the attributes of a bucket, accepted by
+// Conn.UpdateBucket. Fields that are nil are left untouched.
+type UpdateBucketRequest struct {
+	// The name of the bucket to update. This field must be set.
+	BucketName string
+
+	// If non-nil, the request will fail without effect if the bucket's current
+	// meta-generation is not equal to this value.
+	MetaGenerationPrecondition *int64
+
+	// Label updates. Keys that are not mentioned are untouched. Keys whose
+	// values are nil are deleted, and others are updated to the supplied
+	// string.
+	Labels map[string]*string
+
+	// If non-nil, replace the bucket's default object ACL. An empty slice
+	// removes every rule, so that new objects are readable only by those with
+	// access to the bucket.
+	DefaultObjectACL *[]ACLRule
+
+	// If non-nil, enable or suspend object versioning.
+	VersioningEnabled *bool
+
+	// If non-nil, replace the bucket's website configuration. A pointer to the
+	// zero value removes it.
+	Website *WebsiteConfig
+
+	// If non-nil, replace the bucket's CORS rules. An empty slice removes
+	// them.
+	CORS *[]CORSRule
+}
+
+// A request to grant a role on new objects in a bucket to an entity, accepted
+// by Conn.UpdateDefaultObjectACL.
+type UpdateDefaultObjectACLRequest struct {
+	// The name of the bucket in question. Must be specified.
+	BucketName string
+
+	// The entity to which the role is granted. See the notes on
+	// ACLRule.Entity. Must be specified.
+	Entity string
+
+	// The role to grant, replacing any role the entity already holds. Must be
+	// ACLRoleReader or ACLRoleOwner.
+	Role string
+}
+
+// A request to remove an entity's entry from a bucket's default object ACL,
+// accepted by Conn.DeleteDefaultObjectACL.
+type DeleteDefaultObjectACLRequest struct {
+	// The name of the bucket in question. Must be specified.
+	BucketName string
+
+	// The entity whose entry should be removed. Must be specified.
+	Entity string
+}
+
+// Projections accepted by ListBucketsRequest, controlling which properties of
+// each bucket GCS returns.
+const (
+	// All properties, including ACLs. Requires permission to read the ACLs of
+	// each bucket listed.
+	ProjectionFull = "full"
+
+	// All properties except ACLs.
+	ProjectionNoACL = "noAcl"
+)
+
+// A request to list a page of the buckets in the connection's project,
+// accepted by Conn.ListBucketsPage.
+type ListBucketsRequest struct {
+	// List only buckets whose names begin with this prefix.
+	Prefix string
+
+	// The maximum number of buckets to return in this page, or zero for GCS's
+	// default. GCS may return fewer.
+	MaxResults int
+
+	// A continuation token from a previous BucketListing, or empty to start at
+	// the beginning.
+	ContinuationToken string
+
+	// The properties to return: ProjectionFull or ProjectionNoACL. Empty means
+	// ProjectionFull.
+	Projection string
+}
+
+// A page of buckets, returned by Conn.ListBucketsPage.
+type BucketListing struct {
+	// Records for the buckets in this page, in order of increasing name.
+	Buckets []*BucketInfo
+
+	// If non-empty, there are further buckets to list, which can be obtained by
+	// repeating the request with this continuation token.
+	ContinuationToken string
+}
+
+// A request to set or remove the retention policy of a bucket, accepted by
+// Conn.SetBucketRetentionPolicy.
+type SetBucketRetentionPolicyRequest struct {
+	// The name of the bucket to update. This field must be set.
+	BucketName string
+
+	// The retention period to set, which GCS rounds down to a whole number of
+	// seconds. Zero removes the policy, which is not permitted once it has been
+	// locked.
+	Period time.Duration
+
+	// If non-nil, the request will fail without effect if the bucket's current
+	// meta-generation is not equal to this value.
+	MetaGenerationPrecondition *int64
+}
+
+// A request to permanently lock the retention policy of a bucket, accepted by
+// Conn.LockBucketRetentionPolicy.
+type LockBucketRetentionPolicyRequest struct {
+	// The name of the bucket whose policy should be locked. This field must be
+	// set.
+	BucketName string
+
+	// The bucket's current meta-generation, as returned in BucketInfo. GCS
+	// requires this in order to be sure that the policy being locked is the one
+	// the caller has seen. This field must be set.
+	MetaGeneration int64
+}
+
+// A request to set the soft delete policy of a bucket, accepted by
+// Conn.SetBucketSoftDeletePolicy.
+type SetBucketSoftDeletePolicyRequest struct {
+	// The name of the bucket to update. This field must be set.
+	BucketName string
+
+	// How long to keep soft-deleted objects, which GCS rounds down to a whole
+	// number of seconds. GCS requires between 7 and 90 days. Zero disables soft
+	// delete, permanently deleting objects as soon as they are deleted or
+	// overwritten; objects already soft-deleted are kept until their hard
+	// delete time.
+	RetentionDuration time.Duration
+
+	// If non-nil, the request will fail without effect if the bucket's current
+	// meta-generation is not equal to this value.
+	MetaGenerationPrecondition *int64
+}
+
+// A request to set or remove the default Cloud KMS key of a bucket, accepted
+// by Conn.SetBucketDefaultKMSKey. See here for more information:
+//
+//     https://cloud.google.com/storage/docs/encryption/using-customer-managed-keys
+//
+type SetBucketDefaultKMSKeyRequest struct {
+	// The name of the bucket to update. This field must be set.
+	BucketName string
+
+	// The resource name of the key to set, for example:
+	//
+	//     projects/p/locations/l/keyRings/r/cryptoKeys/k
+	//
+	// Empty removes the default key, so that GCS manages the encryption keys of
+	// objects created in the bucket from now on. Existing objects are
+	// unaffected either way.
+	KMSKeyName string
+
+	// If non-nil, the request will fail without effect if the bucket's current
+	// meta-generation is not equal to this value.
+	MetaGenerationPrecondition *int64
+}
@@ -0,0 +1,308 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+func (c *conn) CreateBucket(
+	ctx context.Context,
+	req *CreateBucketRequest) (bi *BucketInfo, err error) {
+	// Buckets belong to projects.
+	if c.projectID == "" {
+		err = errors.New("CreateBucket requires ConnConfig.ProjectID to be set")
+		return
+	}
+
+	// Construct an appropriate URL.
+	query := make(url.Values)
+	query.Set("project", c.projectID)
+	query.Set("projection", "full")
+
+	url := makeURL(c.endpoint, "/storage/v1/b", query)
+
+	// Set up the request body.
+	spec := &storagev1.Bucket{
+		Name:         req.Name,
+		Location:     req.Location,
+		StorageClass: req.StorageClass,
+		Rpo:          req.RPO,
+		Labels:       req.Labels,
+	}
+
+	if len(req.DataLocations) != 0 {
+		spec.CustomPlacementConfig = &storagev1.BucketCustomPlacementConfig{
+			DataLocations: req.DataLocations,
+		}
+	}
+
+	if req.Autoclass {
+		spec.Autoclass = &storagev1.BucketAutoclass{
+			Enabled:              true,
+			TerminalStorageClass: req.AutoclassTerminalStorageClass,
+		}
+	}
+
+	if req.UniformBucketLevelAccess {
+		spec.IamConfiguration = &storagev1.BucketIamConfiguration{
+			UniformBucketLevelAccess: &storagev1.BucketIamConfigurationUniformBucketLevelAccess{
+				Enabled: true,
+			},
+		}
+	}
+
+	if req.DefaultKMSKeyName != "" {
+		spec.Encryption = &storagev1.BucketEncryption{
+			DefaultKmsKeyName: req.DefaultKMSKeyName,
+		}
+	}
+
+	body, err := json.Marshal(spec)
+
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"POST",
+		url,
+		ioutil.NopCloser(bytes.NewReader(body)),
+		int64(len(body)),
+		c.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Execute the HTTP request.
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Check for HTTP-level errors.
+	if err = checkResponse(httpRes); err != nil {
+		return
+	}
+
+	// Parse the response.
+	var rawBucket *storagev1.Bucket
+	if err = json.NewDecoder(httpRes.Body).Decode(&rawBucket); err != nil {
+		return
+	}
+
+	// Convert the response.
+	if bi, err = toBucketInfo(rawBucket); err != nil {
+		err = fmt.Errorf("toBucketInfo: %v", err)
+		return
+	}
+
+	return
+}
+
+func (c *conn) DeleteBucket(
+	ctx context.Context,
+	name string) (err error) {
+	// Construct an appropriate URL.
+	query := make(url.Values)
+	if c.userProject != "" {
+		query.Set("userProject", c.userProject)
+	}
+
+	url := makeURL(
+		c.endpoint,
+		fmt.Sprintf("/storage/v1/b/%s", httputil.EncodePathSegment(name)),
+		query)
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(ctx, "DELETE", url, nil, 0, c.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	// Execute the HTTP request.
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Check for HTTP-level errors.
+	if err = checkResponse(httpRes); err != nil {
+		// Special case: handle not found errors.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusNotFound {
+				err = &NotFoundError{Err: typed}
+			}
+		}
+
+		return
+	}
+
+	return
+}
+
+func (c *conn) GetBucket(
+	ctx context.Context,
+	name string) (bi *BucketInfo, err error) {
+	query := make(url.Values)
+	query.Set("projection", "full")
+
+	url := c.bucketURL(name, "", query)
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(ctx, "GET", url, nil, 0, c.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	bi, err = c.doBucketRequest(httpReq)
+	return
+}
+
+func (c *conn) ListBucketsPage(
+	ctx context.Context,
+	req *ListBucketsRequest) (listing *BucketListing, err error) {
+	// Buckets belong to projects.
+	if c.projectID == "" {
+		err = errors.New("ListBucketsPage requires ConnConfig.ProjectID to be set")
+		return
+	}
+
+	// Construct an appropriate URL.
+	query := make(url.Values)
+	query.Set("project", c.projectID)
+
+	projection := req.Projection
+	if projection == "" {
+		projection = ProjectionFull
+	}
+
+	query.Set("projection", projection)
+
+	if req.Prefix != "" {
+		query.Set("prefix", req.Prefix)
+	}
+
+	if req.MaxResults != 0 {
+		query.Set("maxResults", fmt.Sprintf("%v", req.MaxResults))
+	}
+
+	if req.ContinuationToken != "" {
+		query.Set("pageToken", req.ContinuationToken)
+	}
+
+	if c.userProject != "" {
+		query.Set("userProject", c.userProject)
+	}
+
+	url := makeURL(c.endpoint, "/storage/v1/b", query)
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(ctx, "GET", url, nil, 0, c.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	// Call the server.
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Check for HTTP-level errors.
+	if err = checkResponse(httpRes); err != nil {
+		return
+	}
+
+	// Parse the response.
+	var rawBuckets *storagev1.Buckets
+	if err = json.NewDecoder(httpRes.Body).Decode(&rawBuckets); err != nil {
+		return
+	}
+
+	// Convert the response.
+	listing = &BucketListing{
+		ContinuationToken: rawBuckets.NextPageToken,
+	}
+
+	for _, rawBucket := range rawBuckets.Items {
+		var bi *BucketInfo
+		if bi, err = toBucketInfo(rawBucket); err != nil {
+			err = fmt.Errorf("toBucketInfo(%q): %v", rawBucket.Name, err)
+			return
+		}
+
+		listing.Buckets = append(listing.Buckets, bi)
+	}
+
+	return
+}
+
+func (c *conn) ListBuckets(
+	ctx context.Context) (buckets []*BucketInfo, err error) {
+	// Buckets belong to projects.
+	if c.projectID == "" {
+		err = errors.New("ListBuckets requires ConnConfig.ProjectID to be set")
+		return
+	}
+
+	// Accumulate pages until we run out.
+	req := &ListBucketsRequest{}
+	for {
+		var listing *BucketListing
+		listing, err = c.ListBucketsPage(ctx, req)
+		if err != nil {
+			return
+		}
+
+		buckets = append(buckets, listing.Buckets...)
+
+		// Are we done?
+		if listing.ContinuationToken == "" {
+			break
+		}
+
+		req.ContinuationToken = listing.ContinuationToken
+	}
+
+	return
+}
@@ -0,0 +1,358 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestBucketManagement(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type BucketManagementTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	conn      Conn
+}
+
+var _ SetUpInterface = &BucketManagementTest{}
+
+func init() { RegisterTestSuite(&BucketManagementTest{}) }
+
+func (t *BucketManagementTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.conn = &conn{
+		client:    &http.Client{Transport: &t.transport},
+		userAgent: "test",
+		projectID: "some_project",
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *BucketManagementTest) CreateBucket_RequiresProject() {
+	t.conn.(*conn).projectID = ""
+
+	_, err := t.conn.CreateBucket(
+		t.ctx,
+		&CreateBucketRequest{Name: "some_bucket"})
+
+	ExpectThat(err, Error(HasSubstr("ProjectID")))
+	ExpectEq(0, len(t.transport.requests))
+}
+
+func (t *BucketManagementTest) CreateBucket_Defaults() {
+	t.transport.response = `{"name": "some_bucket"}`
+
+	_, err := t.conn.CreateBucket(
+		t.ctx,
+		&CreateBucketRequest{Name: "some_bucket"})
+
+	AssertEq(nil, err)
+
+	// Only the name should be sent.
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("POST", httpReq.Method)
+	ExpectEq("//www.googleapis.com/storage/v1/b", httpReq.URL.Opaque)
+	ExpectEq("some_project", httpReq.URL.Query().Get("project"))
+
+	body, err := decodeBody(httpReq)
+	AssertEq(nil, err)
+	ExpectThat(body, DeepEquals(map[string]interface{}{"name": "some_bucket"}))
+}
+
+func (t *BucketManagementTest) CreateBucket_LocationAndAccessOptions() {
+	t.transport.response = `{
+		"name": "some_bucket",
+		"location": "US",
+		"locationType": "dual-region",
+		"customPlacementConfig": {"dataLocations": ["US-EAST1", "US-WEST1"]},
+		"rpo": "ASYNC_TURBO",
+		"autoclass": {"enabled": true, "terminalStorageClass": "ARCHIVE"},
+		"iamConfiguration": {"uniformBucketLevelAccess": {"enabled": true}}
+	}`
+
+	bi, err := t.conn.CreateBucket(
+		t.ctx,
+		&CreateBucketRequest{
+			Name:                          "some_bucket",
+			Location:                      "US",
+			DataLocations:                 []string{"US-EAST1", "US-WEST1"},
+			RPO:                           RPOAsyncTurbo,
+			Autoclass:                     true,
+			AutoclassTerminalStorageClass: "ARCHIVE",
+			UniformBucketLevelAccess:      true,
+		})
+
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	body, err := decodeBody(t.transport.requests[0])
+	AssertEq(nil, err)
+
+	ExpectEq("US", body["location"])
+	ExpectEq("ASYNC_TURBO", body["rpo"])
+	ExpectThat(
+		body["customPlacementConfig"],
+		DeepEquals(map[string]interface{}{
+			"dataLocations": []interface{}{"US-EAST1", "US-WEST1"},
+		}))
+
+	ExpectThat(
+		body["autoclass"],
+		DeepEquals(map[string]interface{}{
+			"enabled":              true,
+			"terminalStorageClass": "ARCHIVE",
+		}))
+
+	ExpectThat(
+		body["iamConfiguration"],
+		DeepEquals(map[string]interface{}{
+			"uniformBucketLevelAccess": map[string]interface{}{"enabled": true},
+		}))
+
+	// Response
+	ExpectEq(LocationTypeDualRegion, bi.LocationType)
+	ExpectThat(bi.DataLocations, ElementsAre("US-EAST1", "US-WEST1"))
+	ExpectEq(RPOAsyncTurbo, bi.RPO)
+	ExpectTrue(bi.Autoclass)
+	ExpectEq("ARCHIVE", bi.AutoclassTerminalStorageClass)
+	ExpectTrue(bi.UniformBucketLevelAccess)
+}
+
+func (t *BucketManagementTest) ListBucketsPage() {
+	t.transport.response = `{
+		"items": [
+			{
+				"name": "some_bucket",
+				"location": "EU",
+				"storageClass": "NEARLINE",
+				"labels": {"team": "video"},
+				"versioning": {"enabled": true},
+				"retentionPolicy": {
+					"retentionPeriod": "60",
+					"effectiveTime": "2017-03-01T12:00:00Z"
+				}
+			}
+		],
+		"nextPageToken": "some_token"
+	}`
+
+	listing, err := t.conn.ListBucketsPage(
+		t.ctx,
+		&ListBucketsRequest{
+			Prefix:            "some_",
+			MaxResults:        17,
+			ContinuationToken: "prev_token",
+			Projection:        ProjectionNoACL,
+		})
+
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("GET", httpReq.Method)
+	ExpectEq("//www.googleapis.com/storage/v1/b", httpReq.URL.Opaque)
+
+	query := httpReq.URL.Query()
+	ExpectEq("some_project", query.Get("project"))
+	ExpectEq("some_", query.Get("prefix"))
+	ExpectEq("17", query.Get("maxResults"))
+	ExpectEq("prev_token", query.Get("pageToken"))
+	ExpectEq("noAcl", query.Get("projection"))
+
+	// Response
+	ExpectEq("some_token", listing.ContinuationToken)
+	AssertEq(1, len(listing.Buckets))
+
+	bi := listing.Buckets[0]
+	ExpectEq("some_bucket", bi.Name)
+	ExpectEq("EU", bi.Location)
+	ExpectEq("NEARLINE", bi.StorageClass)
+	ExpectEq("video", bi.Labels["team"])
+	ExpectTrue(bi.VersioningEnabled)
+	AssertNe(nil, bi.RetentionPolicy)
+	ExpectEq(time.Minute, bi.RetentionPolicy.Period)
+}
+
+func (t *BucketManagementTest) ListBucketsPage_DefaultProjection() {
+	t.transport.response = `{}`
+
+	listing, err := t.conn.ListBucketsPage(t.ctx, &ListBucketsRequest{})
+	AssertEq(nil, err)
+	ExpectEq(0, len(listing.Buckets))
+	ExpectEq("", listing.ContinuationToken)
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("full", query.Get("projection"))
+	ExpectEq("", query.Get("pageToken"))
+}
+
+func (t *BucketManagementTest) UpdateBucket() {
+	t.transport.response = `{
+		"name": "some_bucket",
+		"metageneration": "18",
+		"labels": {"team": "video"},
+		"versioning": {"enabled": true},
+		"defaultObjectAcl": [{"entity": "allUsers", "role": "READER"}],
+		"website": {"mainPageSuffix": "index.html", "notFoundPage": "404.html"},
+		"cors": [
+			{
+				"origin": ["https://example.com"],
+				"method": ["GET", "HEAD"],
+				"responseHeader": ["Content-Type"],
+				"maxAgeSeconds": 3600
+			}
+		]
+	}`
+
+	team := "video"
+	versioning := true
+	precond := int64(17)
+	req := &UpdateBucketRequest{
+		BucketName:                 "some_bucket",
+		MetaGenerationPrecondition: &precond,
+		Labels: map[string]*string{
+			"team":  &team,
+			"owner": nil,
+		},
+		DefaultObjectACL: &[]ACLRule{
+			{Entity: "allUsers", Role: ACLRoleReader},
+		},
+		VersioningEnabled: &versioning,
+		Website: &WebsiteConfig{
+			MainPageSuffix: "index.html",
+			NotFoundPage:   "404.html",
+		},
+		CORS: &[]CORSRule{
+			{
+				Origins:         []string{"https://example.com"},
+				Methods:         []string{"GET", "HEAD"},
+				ResponseHeaders: []string{"Content-Type"},
+				MaxAge:          time.Hour,
+			},
+		},
+	}
+
+	bi, err := t.conn.UpdateBucket(t.ctx, req)
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("PATCH", httpReq.Method)
+	ExpectEq("//www.googleapis.com/storage/v1/b/some_bucket", httpReq.URL.Opaque)
+	ExpectEq("17", httpReq.URL.Query().Get("ifMetagenerationMatch"))
+
+	body, err := decodeBody(httpReq)
+	AssertEq(nil, err)
+
+	ExpectThat(
+		body,
+		DeepEquals(map[string]interface{}{
+			"labels": map[string]interface{}{
+				"team":  "video",
+				"owner": nil,
+			},
+			"defaultObjectAcl": []interface{}{
+				map[string]interface{}{"entity": "allUsers", "role": "READER"},
+			},
+			"versioning": map[string]interface{}{"enabled": true},
+			"website": map[string]interface{}{
+				"mainPageSuffix": "index.html",
+				"notFoundPage":   "404.html",
+			},
+			"cors": []interface{}{
+				map[string]interface{}{
+					"origin":         []interface{}{"https://example.com"},
+					"method":         []interface{}{"GET", "HEAD"},
+					"responseHeader": []interface{}{"Content-Type"},
+					"maxAgeSeconds":  float64(3600),
+				},
+			},
+		}))
+
+	// Response
+	ExpectEq(18, bi.MetaGeneration)
+	ExpectEq("video", bi.Labels["team"])
+	ExpectTrue(bi.VersioningEnabled)
+	ExpectThat(
+		bi.DefaultObjectACL,
+		DeepEquals([]ACLRule{{Entity: "allUsers", Role: ACLRoleReader}}))
+
+	AssertNe(nil, bi.Website)
+	ExpectEq("index.html", bi.Website.MainPageSuffix)
+	ExpectEq("404.html", bi.Website.NotFoundPage)
+
+	AssertEq(1, len(bi.CORS))
+	ExpectThat(bi.CORS[0].Methods, ElementsAre("GET", "HEAD"))
+	ExpectEq(time.Hour, bi.CORS[0].MaxAge)
+}
+
+func (t *BucketManagementTest) UpdateBucket_Removals() {
+	t.transport.response = `{"name": "some_bucket"}`
+
+	versioning := false
+	req := &UpdateBucketRequest{
+		BucketName:        "some_bucket",
+		DefaultObjectACL:  &[]ACLRule{},
+		VersioningEnabled: &versioning,
+		Website:           &WebsiteConfig{},
+		CORS:              &[]CORSRule{},
+	}
+
+	_, err := t.conn.UpdateBucket(t.ctx, req)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	body, err := decodeBody(t.transport.requests[0])
+	AssertEq(nil, err)
+
+	ExpectThat(
+		body,
+		DeepEquals(map[string]interface{}{
+			"defaultObjectAcl": []interface{}{},
+			"versioning":       map[string]interface{}{"enabled": false},
+			"website":          nil,
+			"cors":             nil,
+		}))
+}
+
+func (t *BucketManagementTest) UpdateBucket_PreconditionFailed() {
+	t.transport.status = http.StatusPreconditionFailed
+	t.transport.response = `{"error": {"code": 412, "message": "Precondition"}}`
+
+	_, err := t.conn.UpdateBucket(
+		t.ctx,
+		&UpdateBucketRequest{BucketName: "some_bucket"})
+
+	ExpectThat(err, HasSameTypeAs(&PreconditionError{}))
+}
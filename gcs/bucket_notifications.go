@@ -0,0 +1,243 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// Event types that may be selected in Notification.EventTypes.
+const (
+	NotificationObjectFinalize       = "OBJECT_FINALIZE"
+	NotificationObjectMetadataUpdate = "OBJECT_METADATA_UPDATE"
+	NotificationObjectDelete         = "OBJECT_DELETE"
+	NotificationObjectArchive        = "OBJECT_ARCHIVE"
+)
+
+// Payload formats for Notification.PayloadFormat.
+const (
+	NotificationPayloadJSON = "JSON_API_V1"
+	NotificationPayloadNone = "NONE"
+)
+
+// The prefix that GCS expects on Pub/Sub topic names.
+const pubsubTopicPrefix = "//pubsub.googleapis.com/"
+
+// Notification is a configuration that causes GCS to publish a Pub/Sub
+// message when objects in a bucket change, as returned by
+// Conn.ListNotifications.
+//
+// See here for more information about its fields:
+//
+//     https://cloud.google.com/storage/docs/json_api/v1/notifications
+//
+type Notification struct {
+	// An ID assigned by GCS, used to delete the configuration.
+	ID string
+
+	// The Pub/Sub topic to which messages are published, of the form
+	//
+	//     projects/<project>/topics/<topic>
+	//
+	// GCS's service account must be allowed to publish to it.
+	Topic string
+
+	// The types of events that trigger messages, from the Notification*
+	// constants above. If empty, all events do.
+	EventTypes []string
+
+	// If non-empty, only objects whose names begin with this prefix trigger
+	// messages.
+	ObjectNamePrefix string
+
+	// Attributes added to every message.
+	CustomAttributes map[string]string
+
+	// The format of message payloads, NotificationPayloadJSON or
+	// NotificationPayloadNone.
+	PayloadFormat string
+
+	// An opaque identifier for this revision of the configuration.
+	Etag string
+}
+
+// A request to add a notification configuration to a bucket, accepted by
+// Conn.CreateNotification.
+type CreateNotificationRequest struct {
+	// The name of the bucket to watch. This field must be set.
+	BucketName string
+
+	// The configuration to create. Topic must be set; ID and Etag are ignored.
+	// If PayloadFormat is empty, NotificationPayloadJSON is used.
+	Notification Notification
+}
+
+func toNotification(in *storagev1.Notification) (out *Notification) {
+	out = &Notification{
+		ID:               in.Id,
+		Topic:            strings.TrimPrefix(in.Topic, pubsubTopicPrefix),
+		EventTypes:       in.EventTypes,
+		ObjectNamePrefix: in.ObjectNamePrefix,
+		CustomAttributes: in.CustomAttributes,
+		PayloadFormat:    in.PayloadFormat,
+		Etag:             in.Etag,
+	}
+
+	return
+}
+
+// Execute the supplied request, parsing any response into out if non-nil.
+func (c *conn) doNotificationRequest(
+	httpReq *http.Request,
+	out interface{}) (err error) {
+	// Execute the HTTP request.
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Check for HTTP-level errors.
+	if err = checkResponse(httpRes); err != nil {
+		// Special case: handle not found errors.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusNotFound {
+				err = &NotFoundError{Err: typed}
+			}
+		}
+
+		return
+	}
+
+	// Parse the response.
+	if out != nil {
+		err = json.NewDecoder(httpRes.Body).Decode(out)
+	}
+
+	return
+}
+
+func (c *conn) CreateNotification(
+	ctx context.Context,
+	req *CreateNotificationRequest) (n *Notification, err error) {
+	if req.Notification.Topic == "" {
+		err = errors.New("Notification.Topic must be set")
+		return
+	}
+
+	url := c.bucketURL(req.BucketName, "/notificationConfigs", make(url.Values))
+
+	// Set up the request body.
+	payloadFormat := req.Notification.PayloadFormat
+	if payloadFormat == "" {
+		payloadFormat = NotificationPayloadJSON
+	}
+
+	body, err := json.Marshal(&storagev1.Notification{
+		Topic:            pubsubTopicPrefix + req.Notification.Topic,
+		EventTypes:       req.Notification.EventTypes,
+		ObjectNamePrefix: req.Notification.ObjectNamePrefix,
+		CustomAttributes: req.Notification.CustomAttributes,
+		PayloadFormat:    payloadFormat,
+	})
+
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"POST",
+		url,
+		ioutil.NopCloser(bytes.NewReader(body)),
+		int64(len(body)),
+		c.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Call the server.
+	var raw *storagev1.Notification
+	if err = c.doNotificationRequest(httpReq, &raw); err != nil {
+		return
+	}
+
+	n = toNotification(raw)
+	return
+}
+
+func (c *conn) ListNotifications(
+	ctx context.Context,
+	bucketName string) (ns []*Notification, err error) {
+	url := c.bucketURL(bucketName, "/notificationConfigs", make(url.Values))
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(ctx, "GET", url, nil, 0, c.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	// Call the server.
+	var raw *storagev1.Notifications
+	if err = c.doNotificationRequest(httpReq, &raw); err != nil {
+		return
+	}
+
+	for _, n := range raw.Items {
+		ns = append(ns, toNotification(n))
+	}
+
+	return
+}
+
+func (c *conn) DeleteNotification(
+	ctx context.Context,
+	bucketName string,
+	id string) (err error) {
+	url := c.bucketURL(
+		bucketName,
+		"/notificationConfigs/"+httputil.EncodePathSegment(id),
+		make(url.Values))
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(ctx, "DELETE", url, nil, 0, c.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	err = c.doNotificationRequest(httpReq, nil)
+	return
+}
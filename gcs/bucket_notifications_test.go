@@ -0,0 +1,143 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestBucketNotifications(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type BucketNotificationsTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	conn      Conn
+}
+
+var _ SetUpInterface = &BucketNotificationsTest{}
+
+func init() { RegisterTestSuite(&BucketNotificationsTest{}) }
+
+func (t *BucketNotificationsTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.conn = &conn{
+		client:    &http.Client{Transport: &t.transport},
+		userAgent: "test",
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *BucketNotificationsTest) Create() {
+	t.transport.response = `{
+		"id": "17",
+		"topic": "//pubsub.googleapis.com/projects/p/topics/t",
+		"event_types": ["OBJECT_FINALIZE"],
+		"payload_format": "JSON_API_V1",
+		"etag": "17"
+	}`
+
+	req := &CreateNotificationRequest{
+		BucketName: "some_bucket",
+		Notification: Notification{
+			Topic:            "projects/p/topics/t",
+			EventTypes:       []string{NotificationObjectFinalize},
+			ObjectNamePrefix: "incoming/",
+			CustomAttributes: map[string]string{"foo": "bar"},
+		},
+	}
+
+	n, err := t.conn.CreateNotification(t.ctx, req)
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("POST", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/notificationConfigs",
+		httpReq.URL.Opaque)
+
+	body, err := decodeBody(httpReq)
+	AssertEq(nil, err)
+	ExpectEq("//pubsub.googleapis.com/projects/p/topics/t", body["topic"])
+	ExpectEq("JSON_API_V1", body["payload_format"])
+	ExpectEq("incoming/", body["object_name_prefix"])
+	ExpectThat(body["event_types"], ElementsAre("OBJECT_FINALIZE"))
+	ExpectThat(
+		body["custom_attributes"],
+		DeepEquals(map[string]interface{}{"foo": "bar"}))
+
+	// Response
+	ExpectEq("17", n.ID)
+	ExpectEq("projects/p/topics/t", n.Topic)
+	ExpectThat(n.EventTypes, ElementsAre(NotificationObjectFinalize))
+	ExpectEq(NotificationPayloadJSON, n.PayloadFormat)
+}
+
+func (t *BucketNotificationsTest) Create_MissingTopic() {
+	req := &CreateNotificationRequest{
+		BucketName: "some_bucket",
+	}
+
+	_, err := t.conn.CreateNotification(t.ctx, req)
+	ExpectThat(err, Error(HasSubstr("Topic")))
+	ExpectEq(0, len(t.transport.requests))
+}
+
+func (t *BucketNotificationsTest) List() {
+	t.transport.response = `{
+		"items": [
+			{"id": "1", "topic": "//pubsub.googleapis.com/projects/p/topics/a"},
+			{"id": "2", "topic": "//pubsub.googleapis.com/projects/p/topics/b"}
+		]
+	}`
+
+	ns, err := t.conn.ListNotifications(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	ExpectEq("GET", t.transport.requests[0].Method)
+
+	AssertEq(2, len(ns))
+	ExpectEq("1", ns[0].ID)
+	ExpectEq("projects/p/topics/a", ns[0].Topic)
+	ExpectEq("2", ns[1].ID)
+	ExpectEq("projects/p/topics/b", ns[1].Topic)
+}
+
+func (t *BucketNotificationsTest) Delete() {
+	err := t.conn.DeleteNotification(t.ctx, "some_bucket", "17")
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("DELETE", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/notificationConfigs/17",
+		httpReq.URL.Opaque)
+}
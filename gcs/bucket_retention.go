@@ -0,0 +1,173 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// Return the URL for the named bucket, or one of its sub-resources if suffix
+// is non-empty, with the supplied query parameters in addition to the common
+// ones.
+func (c *conn) bucketURL(
+	name string,
+	suffix string,
+	query url.Values) (u *url.URL) {
+	if c.userProject != "" {
+		query.Set("userProject", c.userProject)
+	}
+
+	u = makeURL(
+		c.endpoint,
+		fmt.Sprintf(
+			"/storage/v1/b/%s%s",
+			httputil.EncodePathSegment(name),
+			suffix),
+		query)
+
+	return
+}
+
+// Execute the supplied request and parse the bucket record it returns.
+func (c *conn) doBucketRequest(
+	httpReq *http.Request) (bi *BucketInfo, err error) {
+	// Execute the HTTP request.
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Check for HTTP-level errors.
+	if err = checkResponse(httpRes); err != nil {
+		// Special case: handle not found errors.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusNotFound {
+				err = &NotFoundError{Err: typed}
+			}
+		}
+
+		// Special case: handle precondition errors.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusPreconditionFailed {
+				err = &PreconditionError{Err: typed}
+			}
+		}
+
+		return
+	}
+
+	// Parse the response.
+	var rawBucket *storagev1.Bucket
+	if err = json.NewDecoder(httpRes.Body).Decode(&rawBucket); err != nil {
+		return
+	}
+
+	// Convert the response.
+	if bi, err = toBucketInfo(rawBucket); err != nil {
+		err = fmt.Errorf("toBucketInfo: %v", err)
+		return
+	}
+
+	return
+}
+
+func (c *conn) SetBucketRetentionPolicy(
+	ctx context.Context,
+	req *SetBucketRetentionPolicyRequest) (bi *BucketInfo, err error) {
+	query := make(url.Values)
+	query.Set("projection", "full")
+
+	if req.MetaGenerationPrecondition != nil {
+		query.Set(
+			"ifMetagenerationMatch",
+			fmt.Sprint(*req.MetaGenerationPrecondition))
+	}
+
+	url := c.bucketURL(req.BucketName, "", query)
+
+	// Set up the request body. A null policy removes any existing one.
+	jsonMap := map[string]interface{}{
+		"retentionPolicy": nil,
+	}
+
+	if req.Period != 0 {
+		jsonMap["retentionPolicy"] = map[string]interface{}{
+			"retentionPeriod": fmt.Sprint(int64(req.Period / time.Second)),
+		}
+	}
+
+	body, err := json.Marshal(jsonMap)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"PATCH",
+		url,
+		ioutil.NopCloser(bytes.NewReader(body)),
+		int64(len(body)),
+		c.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	bi, err = c.doBucketRequest(httpReq)
+	return
+}
+
+func (c *conn) LockBucketRetentionPolicy(
+	ctx context.Context,
+	req *LockBucketRetentionPolicyRequest) (bi *BucketInfo, err error) {
+	if req.MetaGeneration == 0 {
+		err = errors.New("MetaGeneration must be set")
+		return
+	}
+
+	query := make(url.Values)
+	query.Set("ifMetagenerationMatch", fmt.Sprint(req.MetaGeneration))
+
+	url := c.bucketURL(req.BucketName, "/lockRetentionPolicy", query)
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(ctx, "POST", url, nil, 0, c.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	bi, err = c.doBucketRequest(httpReq)
+	return
+}
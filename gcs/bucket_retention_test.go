@@ -0,0 +1,167 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestBucketRetention(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type BucketRetentionTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	conn      Conn
+}
+
+var _ SetUpInterface = &BucketRetentionTest{}
+
+func init() { RegisterTestSuite(&BucketRetentionTest{}) }
+
+func (t *BucketRetentionTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.conn = &conn{
+		client:    &http.Client{Transport: &t.transport},
+		userAgent: "test",
+	}
+}
+
+// Decode the JSON body of the supplied request.
+func decodeBody(r *http.Request) (m map[string]interface{}, err error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(b, &m)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *BucketRetentionTest) Set() {
+	t.transport.response = `{
+		"name": "some_bucket",
+		"metageneration": "18",
+		"retentionPolicy": {
+			"retentionPeriod": "86400",
+			"effectiveTime": "2017-03-01T12:00:00Z"
+		}
+	}`
+
+	precond := int64(17)
+	req := &SetBucketRetentionPolicyRequest{
+		BucketName:                 "some_bucket",
+		Period:                     24 * time.Hour,
+		MetaGenerationPrecondition: &precond,
+	}
+
+	bi, err := t.conn.SetBucketRetentionPolicy(t.ctx, req)
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("PATCH", httpReq.Method)
+	ExpectEq("//www.googleapis.com/storage/v1/b/some_bucket", httpReq.URL.Opaque)
+	ExpectEq("17", httpReq.URL.Query().Get("ifMetagenerationMatch"))
+
+	body, err := decodeBody(httpReq)
+	AssertEq(nil, err)
+	ExpectThat(
+		body["retentionPolicy"],
+		DeepEquals(map[string]interface{}{"retentionPeriod": "86400"}))
+
+	// Response
+	ExpectEq(18, bi.MetaGeneration)
+	AssertNe(nil, bi.RetentionPolicy)
+	ExpectEq(24*time.Hour, bi.RetentionPolicy.Period)
+	ExpectFalse(bi.RetentionPolicy.IsLocked)
+	ExpectTrue(
+		bi.RetentionPolicy.EffectiveTime.Equal(
+			time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)))
+}
+
+func (t *BucketRetentionTest) Remove() {
+	t.transport.response = `{"name": "some_bucket"}`
+
+	req := &SetBucketRetentionPolicyRequest{
+		BucketName: "some_bucket",
+	}
+
+	bi, err := t.conn.SetBucketRetentionPolicy(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq(nil, bi.RetentionPolicy)
+
+	AssertEq(1, len(t.transport.requests))
+	body, err := decodeBody(t.transport.requests[0])
+	AssertEq(nil, err)
+
+	v, ok := body["retentionPolicy"]
+	ExpectTrue(ok)
+	ExpectEq(nil, v)
+}
+
+func (t *BucketRetentionTest) Lock() {
+	t.transport.response = `{
+		"name": "some_bucket",
+		"retentionPolicy": {"retentionPeriod": "60", "isLocked": true}
+	}`
+
+	req := &LockBucketRetentionPolicyRequest{
+		BucketName:     "some_bucket",
+		MetaGeneration: 17,
+	}
+
+	bi, err := t.conn.LockBucketRetentionPolicy(t.ctx, req)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("POST", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/lockRetentionPolicy",
+		httpReq.URL.Opaque)
+	ExpectEq("17", httpReq.URL.Query().Get("ifMetagenerationMatch"))
+
+	AssertNe(nil, bi.RetentionPolicy)
+	ExpectEq(time.Minute, bi.RetentionPolicy.Period)
+	ExpectTrue(bi.RetentionPolicy.IsLocked)
+}
+
+func (t *BucketRetentionTest) Lock_MissingMetaGeneration() {
+	req := &LockBucketRetentionPolicyRequest{
+		BucketName: "some_bucket",
+	}
+
+	_, err := t.conn.LockBucketRetentionPolicy(t.ctx, req)
+	ExpectThat(err, Error(HasSubstr("MetaGeneration")))
+	ExpectEq(0, len(t.transport.requests))
+}
@@ -0,0 +1,139 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+)
+
+// Build the body of a PATCH request for the supplied update. Null values
+// remove fields, and null labels remove keys.
+func makeUpdateBucketBody(req *UpdateBucketRequest) (body []byte, err error) {
+	jsonMap := make(map[string]interface{})
+
+	if len(req.Labels) != 0 {
+		labels := make(map[string]interface{})
+		for k, v := range req.Labels {
+			if v == nil {
+				labels[k] = nil
+			} else {
+				labels[k] = *v
+			}
+		}
+
+		jsonMap["labels"] = labels
+	}
+
+	if req.DefaultObjectACL != nil {
+		rules := make([]interface{}, 0, len(*req.DefaultObjectACL))
+		for _, r := range *req.DefaultObjectACL {
+			rules = append(rules, map[string]interface{}{
+				"entity": r.Entity,
+				"role":   r.Role,
+			})
+		}
+
+		jsonMap["defaultObjectAcl"] = rules
+	}
+
+	if req.VersioningEnabled != nil {
+		jsonMap["versioning"] = map[string]interface{}{
+			"enabled": *req.VersioningEnabled,
+		}
+	}
+
+	if req.Website != nil {
+		jsonMap["website"] = nil
+		if *req.Website != (WebsiteConfig{}) {
+			jsonMap["website"] = map[string]interface{}{
+				"mainPageSuffix": req.Website.MainPageSuffix,
+				"notFoundPage":   req.Website.NotFoundPage,
+			}
+		}
+	}
+
+	if req.CORS != nil {
+		jsonMap["cors"] = nil
+		if len(*req.CORS) != 0 {
+			var rules []interface{}
+			for _, r := range *req.CORS {
+				rules = append(rules, map[string]interface{}{
+					"origin":         r.Origins,
+					"method":         r.Methods,
+					"responseHeader": r.ResponseHeaders,
+					"maxAgeSeconds":  int64(r.MaxAge / time.Second),
+				})
+			}
+
+			jsonMap["cors"] = rules
+		}
+	}
+
+	body, err = json.Marshal(jsonMap)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	return
+}
+
+func (c *conn) UpdateBucket(
+	ctx context.Context,
+	req *UpdateBucketRequest) (bi *BucketInfo, err error) {
+	query := make(url.Values)
+	query.Set("projection", "full")
+
+	if req.MetaGenerationPrecondition != nil {
+		query.Set(
+			"ifMetagenerationMatch",
+			fmt.Sprint(*req.MetaGenerationPrecondition))
+	}
+
+	url := c.bucketURL(req.BucketName, "", query)
+
+	// Set up the request body.
+	body, err := makeUpdateBucketBody(req)
+	if err != nil {
+		return
+	}
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"PATCH",
+		url,
+		ioutil.NopCloser(bytes.NewReader(body)),
+		int64(len(body)),
+		c.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	bi, err = c.doBucketRequest(httpReq)
+	return
+}
@@ -0,0 +1,335 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// A pool of byte buffers for transferring object contents, shared between
+// concurrent transfers. Buffers are recycled rather than left to the garbage
+// collector, and the total size of the buffers handed out at any one time may
+// be capped so that many concurrent transfers can't exhaust memory: Get blocks
+// until enough buffers have been returned with Put.
+//
+// Use one pool for a whole process by setting ConnConfig.BufferPool for each
+// connection, and gcschunk.Config.BufferPool where relevant. Safe for
+// concurrent access.
+//
+// Contents of unknown size that must be held in full, such as those buffered
+// by NewRetryBucket or compressed for CreateObjectRequest.Compress, are
+// gathered in blocks taken from the pool one at a time, waiting for room
+// while holding the blocks already gathered. Contents that alone exceed the
+// limit fail at once, but several large ones gathered concurrently can wait
+// for each other until their contexts are cancelled, so allow for them when
+// choosing the limit.
+//
+// The pool is meant for buffers held for the duration of a single call.
+// Long-lived caches such as gcsutil.ObjectReaderAt's blocks don't use it:
+// they are dropped without being closed, so their buffers would never be
+// returned and would count against the limit forever.
+type BufferPool struct {
+	maxBytes int64
+
+	mu sync.Mutex
+
+	// The total capacity of the buffers handed out by Get and not yet returned.
+	//
+	// INVARIANT: maxBytes == 0 || inUse <= maxBytes
+	inUse int64 // GUARDED_BY(mu)
+
+	// Closed and replaced each time a buffer is returned, waking blocked calls
+	// to Get.
+	returned chan struct{} // GUARDED_BY(mu)
+
+	// Returned buffers available for reuse, by size.
+	free map[int]*sync.Pool // GUARDED_BY(mu)
+}
+
+// Create a buffer pool that hands out at most maxBytes bytes of buffers at any
+// one time. Zero means no limit.
+func NewBufferPool(maxBytes int64) (p *BufferPool) {
+	p = &BufferPool{
+		maxBytes: maxBytes,
+		returned: make(chan struct{}),
+		free:     make(map[int]*sync.Pool),
+	}
+
+	return
+}
+
+// The pool used by connections whose config doesn't specify one.
+var defaultBufferPool = NewBufferPool(0)
+
+// Return a buffer of the given size, waiting until the pool's limit allows it
+// or ctx is cancelled. The buffer's contents are arbitrary. Pass the buffer to
+// Put once it is no longer in use.
+//
+// LOCKS_EXCLUDED(p.mu)
+func (p *BufferPool) Get(
+	ctx context.Context,
+	size int) (buf []byte, err error) {
+	if size < 0 || (p.maxBytes != 0 && int64(size) > p.maxBytes) {
+		err = fmt.Errorf(
+			"Can't get a buffer of %d bytes from a pool limited to %d",
+			size,
+			p.maxBytes)
+		return
+	}
+
+	// Wait for room.
+	p.mu.Lock()
+	for p.maxBytes != 0 && p.inUse+int64(size) > p.maxBytes {
+		returned := p.returned
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+
+		case <-returned:
+		}
+
+		p.mu.Lock()
+	}
+
+	p.inUse += int64(size)
+	free := p.freeForSize(size)
+	p.mu.Unlock()
+
+	// Reuse a returned buffer if there is one.
+	if v := free.Get(); v != nil {
+		buf = *v.(*[]byte)
+		return
+	}
+
+	buf = make([]byte, size)
+	return
+}
+
+// Return to the pool a buffer obtained from Get, which the caller must no
+// longer use. The buffer may be resliced, but only from its start.
+//
+// LOCKS_EXCLUDED(p.mu)
+func (p *BufferPool) Put(buf []byte) {
+	buf = buf[:cap(buf)]
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.inUse -= int64(len(buf))
+	if p.inUse < 0 {
+		panic("Put called with a buffer not obtained from Get")
+	}
+
+	// sync.Pool wants pointers. (This allocates a slice header, but that's
+	// small compared to the buffer it saves allocating.)
+	p.freeForSize(len(buf)).Put(&buf)
+
+	close(p.returned)
+	p.returned = make(chan struct{})
+}
+
+// Return the total size of the buffers handed out by Get and not yet returned
+// with Put.
+//
+// LOCKS_EXCLUDED(p.mu)
+func (p *BufferPool) InUse() (n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n = p.inUse
+	return
+}
+
+// LOCKS_REQUIRED(p.mu)
+func (p *BufferPool) freeForSize(size int) (free *sync.Pool) {
+	free = p.free[size]
+	if free == nil {
+		free = &sync.Pool{}
+		p.free[size] = free
+	}
+
+	return
+}
+
+// Read r to EOF, as ioutil.ReadAll does, gathering the contents in blocks from
+// the pool so that the read waits for room under the pool's limit. The
+// contents are returned in a slice allocated outside the pool, and the blocks
+// returned to the pool, before ReadAll returns.
+//
+// LOCKS_EXCLUDED(p.mu)
+func (p *BufferPool) ReadAll(
+	ctx context.Context,
+	r io.Reader) (contents []byte, err error) {
+	pb := newPooledBuffer(ctx, p)
+	defer pb.Release()
+
+	if _, err = io.Copy(pb, r); err != nil {
+		return
+	}
+
+	contents = make([]byte, pb.Size())
+	_, err = pb.ReadAt(contents, 0)
+	if err == io.EOF {
+		err = nil
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Pooled buffers
+////////////////////////////////////////////////////////////////////////
+
+// The sizes of the blocks in which a pooledBuffer gathers its contents. Each
+// block is twice the size of the one before, up to the maximum, so that small
+// contents take little of the pool and large ones few blocks.
+const (
+	pooledBufferMinBlockSize = 4 << 10
+	pooledBufferMaxBlockSize = 256 << 10
+)
+
+// An io.Writer that gathers what is written to it in blocks taken from a
+// pool. The contents can then be read with ReadAt, as many times as needed,
+// until Release returns the blocks to the pool.
+type pooledBuffer struct {
+	ctx  context.Context
+	pool *BufferPool
+
+	// The blocks taken from the pool, each filled up to its length. Every block
+	// but the last is full to its capacity.
+	blocks [][]byte
+
+	// The total capacity of the blocks, and the total length.
+	held int64
+	size int64
+}
+
+func newPooledBuffer(ctx context.Context, pool *BufferPool) (pb *pooledBuffer) {
+	pb = &pooledBuffer{
+		ctx:  ctx,
+		pool: pool,
+	}
+
+	return
+}
+
+func (pb *pooledBuffer) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if pb.size == pb.held {
+			if err = pb.grow(); err != nil {
+				return
+			}
+		}
+
+		last := &pb.blocks[len(pb.blocks)-1]
+		m := copy((*last)[len(*last):cap(*last)], p)
+		*last = (*last)[:len(*last)+m]
+
+		n += m
+		pb.size += int64(m)
+		p = p[m:]
+	}
+
+	return
+}
+
+// Take another block from the pool.
+func (pb *pooledBuffer) grow() (err error) {
+	size := int64(pooledBufferMinBlockSize) << uint(len(pb.blocks))
+	if size > pooledBufferMaxBlockSize {
+		size = pooledBufferMaxBlockSize
+	}
+
+	// Don't ask for more than the limit leaves room for alongside the blocks
+	// we already hold, since waiting for that would never end.
+	if pb.pool.maxBytes != 0 {
+		room := pb.pool.maxBytes - pb.held
+		if room == 0 {
+			err = fmt.Errorf(
+				"Contents exceed the buffer pool's limit of %d bytes",
+				pb.pool.maxBytes)
+			return
+		}
+
+		if size > room {
+			size = room
+		}
+	}
+
+	block, err := pb.pool.Get(pb.ctx, int(size))
+	if err != nil {
+		err = fmt.Errorf("BufferPool.Get: %v", err)
+		return
+	}
+
+	pb.blocks = append(pb.blocks, block[:0])
+	pb.held += size
+
+	return
+}
+
+func (pb *pooledBuffer) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		err = errors.New("Negative offset")
+		return
+	}
+
+	// Find the block containing the offset, then copy from there on.
+	for _, block := range pb.blocks {
+		if len(p) == 0 {
+			break
+		}
+
+		if off >= int64(len(block)) {
+			off -= int64(len(block))
+			continue
+		}
+
+		m := copy(p, block[off:])
+		n += m
+		off = 0
+		p = p[m:]
+	}
+
+	if len(p) > 0 {
+		err = io.EOF
+	}
+
+	return
+}
+
+// Return the number of bytes written.
+func (pb *pooledBuffer) Size() int64 {
+	return pb.size
+}
+
+// Return the blocks to the pool. The buffer must not be used afterward.
+func (pb *pooledBuffer) Release() {
+	for _, block := range pb.blocks {
+		pb.pool.Put(block)
+	}
+
+	pb.blocks = nil
+	pb.held = 0
+	pb.size = 0
+}
@@ -0,0 +1,189 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestBufferPool(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type BufferPoolTest struct {
+	ctx  context.Context
+	pool *gcs.BufferPool
+}
+
+var _ SetUpInterface = &BufferPoolTest{}
+
+func init() { RegisterTestSuite(&BufferPoolTest{}) }
+
+func (t *BufferPoolTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.pool = gcs.NewBufferPool(100)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *BufferPoolTest) GetAndPut() {
+	a, err := t.pool.Get(t.ctx, 60)
+	AssertEq(nil, err)
+	ExpectEq(60, len(a))
+
+	b, err := t.pool.Get(t.ctx, 40)
+	AssertEq(nil, err)
+	ExpectEq(40, len(b))
+	ExpectEq(100, t.pool.InUse())
+
+	t.pool.Put(a)
+	ExpectEq(40, t.pool.InUse())
+
+	// Resliced buffers may be returned.
+	t.pool.Put(b[:10])
+	ExpectEq(0, t.pool.InUse())
+}
+
+func (t *BufferPoolTest) TooLarge() {
+	_, err := t.pool.Get(t.ctx, 101)
+	ExpectThat(err, Error(HasSubstr("limited to 100")))
+}
+
+func (t *BufferPoolTest) GetBlocksUntilPut() {
+	a, err := t.pool.Get(t.ctx, 60)
+	AssertEq(nil, err)
+
+	got := make(chan []byte)
+	go func() {
+		b, err := t.pool.Get(t.ctx, 60)
+		AssertEq(nil, err)
+		got <- b
+	}()
+
+	// The second buffer would exceed the limit.
+	select {
+	case <-got:
+		AddFailure("Get didn't block")
+		AbortTest()
+
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Returning the first should allow it.
+	t.pool.Put(a)
+	b := <-got
+
+	ExpectEq(60, len(b))
+	ExpectEq(60, t.pool.InUse())
+}
+
+func (t *BufferPoolTest) CancelWhileWaiting() {
+	_, err := t.pool.Get(t.ctx, 100)
+	AssertEq(nil, err)
+
+	ctx, cancel := context.WithTimeout(t.ctx, 10*time.Millisecond)
+	defer cancel()
+
+	_, err = t.pool.Get(ctx, 1)
+	ExpectTrue(err == context.DeadlineExceeded, "err: %v", err)
+	ExpectEq(100, t.pool.InUse())
+}
+
+func (t *BufferPoolTest) NoLimit() {
+	pool := gcs.NewBufferPool(0)
+
+	a, err := pool.Get(t.ctx, 1<<20)
+	AssertEq(nil, err)
+
+	b, err := pool.Get(t.ctx, 1<<20)
+	AssertEq(nil, err)
+
+	ExpectEq(2<<20, pool.InUse())
+
+	pool.Put(a)
+	pool.Put(b)
+	ExpectEq(0, pool.InUse())
+}
+
+func (t *BufferPoolTest) ReadAll() {
+	contents, err := t.pool.ReadAll(t.ctx, strings.NewReader("taco burrito"))
+
+	AssertEq(nil, err)
+	ExpectEq("taco burrito", string(contents))
+	ExpectEq(0, t.pool.InUse())
+}
+
+func (t *BufferPoolTest) ReadAllUpToLimit() {
+	expected := strings.Repeat("x", 100)
+	contents, err := t.pool.ReadAll(t.ctx, strings.NewReader(expected))
+
+	AssertEq(nil, err)
+	ExpectEq(expected, string(contents))
+	ExpectEq(0, t.pool.InUse())
+}
+
+func (t *BufferPoolTest) ReadAllSpanningBlocks() {
+	// With a limit.
+	pool := gcs.NewBufferPool(10000)
+	expected := strings.Repeat("x", 9000)
+	contents, err := pool.ReadAll(t.ctx, strings.NewReader(expected))
+
+	AssertEq(nil, err)
+	ExpectEq(expected, string(contents))
+	ExpectEq(0, pool.InUse())
+
+	// Without.
+	pool = gcs.NewBufferPool(0)
+	expected = strings.Repeat("x", 1<<20+17)
+	contents, err = pool.ReadAll(t.ctx, strings.NewReader(expected))
+
+	AssertEq(nil, err)
+	ExpectEq(expected, string(contents))
+	ExpectEq(0, pool.InUse())
+}
+
+func (t *BufferPoolTest) ReadAllExceedingLimit() {
+	_, err := t.pool.ReadAll(t.ctx, strings.NewReader(strings.Repeat("x", 101)))
+
+	ExpectThat(err, Error(HasSubstr("limit of 100 bytes")))
+	ExpectEq(0, t.pool.InUse())
+}
+
+func (t *BufferPoolTest) ReadAllWaitsForRoom() {
+	a, err := t.pool.Get(t.ctx, 60)
+	AssertEq(nil, err)
+
+	// The contents fit within the limit, but not alongside the buffer above.
+	ctx, cancel := context.WithTimeout(t.ctx, 10*time.Millisecond)
+	defer cancel()
+
+	_, err = t.pool.ReadAll(ctx, strings.NewReader(strings.Repeat("x", 50)))
+	ExpectThat(err, Error(HasSubstr("deadline exceeded")))
+	ExpectEq(60, t.pool.InUse())
+
+	t.pool.Put(a)
+}
@@ -17,12 +17,10 @@ package gcs
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"unicode/utf8"
 
 	"github.com/jacobsa/gcloud/httputil"
 	"google.golang.org/api/googleapi"
@@ -67,17 +65,22 @@ func (b *bucket) ComposeObjects(
 	// We encode using json.NewEncoder, which is documented to silently transform
 	// invalid UTF-8 (cf. http://goo.gl/3gIUQB). So we can't rely on the server
 	// to detect this for us.
-	if !utf8.ValidString(req.DstName) {
-		err = errors.New("Invalid object name: not valid UTF-8")
+	if err = CheckObjectName(req.DstName); err != nil {
 		return
 	}
 
+	for _, src := range req.Sources {
+		if err = CheckObjectName(src.Name); err != nil {
+			return
+		}
+	}
+
 	// Construct an appropriate URL.
 	bucketSegment := httputil.EncodePathSegment(b.Name())
 	objectSegment := httputil.EncodePathSegment(req.DstName)
 
-	opaque := fmt.Sprintf(
-		"//www.googleapis.com/storage/v1/b/%s/o/%s/compose",
+	path := fmt.Sprintf(
+		"/storage/v1/b/%s/o/%s/compose",
 		bucketSegment,
 		objectSegment)
 
@@ -93,12 +96,9 @@ func (b *bucket) ComposeObjects(
 			fmt.Sprint(*req.DstMetaGenerationPrecondition))
 	}
 
-	url := &url.URL{
-		Scheme:   "https",
-		Host:     "www.googleapis.com",
-		Opaque:   opaque,
-		RawQuery: query.Encode(),
-	}
+	b.addCommonParams(query)
+
+	url := makeURL(b.endpoint, path, query)
 
 	// Set up the request body.
 	body, err := b.makeComposeObjectsBody(req)
@@ -133,7 +133,7 @@ func (b *bucket) ComposeObjects(
 	defer googleapi.CloseBody(httpRes)
 
 	// Check for HTTP-level errors.
-	if err = googleapi.CheckResponse(httpRes); err != nil {
+	if err = checkResponse(httpRes); err != nil {
 		// Special case: handle not found and precondition errors.
 		if typed, ok := err.(*googleapi.Error); ok {
 			switch typed.Code {
@@ -150,7 +150,7 @@ func (b *bucket) ComposeObjects(
 
 	// Parse the response.
 	var rawObject *storagev1.Object
-	if err = json.NewDecoder(httpRes.Body).Decode(&rawObject); err != nil {
+	if err = decodeResponse(httpRes, &rawObject); err != nil {
 		return
 	}
 
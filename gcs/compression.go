@@ -0,0 +1,138 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// The key of the custom metadata entry in which objects created with
+// CreateObjectRequest.Compress record the size in bytes of their contents
+// before compression, in decimal.
+const UncompressedSizeMetadataKey = "uncompressed-size"
+
+// Return a copy of the supplied request with its contents gzipped, as called
+// for by CreateObjectRequest.Compress, or the request itself if Compress isn't
+// set. This is for use by Bucket implementations.
+//
+// The compressed contents are buffered in memory, and the copy carries their
+// checksums so that they are verified on arrival.
+func CompressCreateObjectRequest(
+	req *CreateObjectRequest) (compressed *CreateObjectRequest, err error) {
+	if !req.Compress {
+		compressed = req
+		return
+	}
+
+	var buf bytes.Buffer
+	if compressed, err = compressInto(req, &buf); err != nil {
+		return
+	}
+
+	compressed.Contents = bytes.NewReader(buf.Bytes())
+	return
+}
+
+// Like CompressCreateObjectRequest, but gather the compressed contents in
+// blocks from the supplied pool rather than in a single buffer. Call release
+// once the returned request is no longer in use.
+func compressCreateObjectRequestPooled(
+	ctx context.Context,
+	req *CreateObjectRequest,
+	pool *BufferPool) (
+	compressed *CreateObjectRequest,
+	release func(),
+	err error) {
+	release = func() {}
+	if !req.Compress {
+		compressed = req
+		return
+	}
+
+	pb := newPooledBuffer(ctx, pool)
+	if compressed, err = compressInto(req, pb); err != nil {
+		pb.Release()
+		return
+	}
+
+	compressed.Contents = io.NewSectionReader(pb, 0, pb.Size())
+	release = pb.Release
+
+	return
+}
+
+// Write the gzipped contents of req to w, returning a copy of req describing
+// them, apart from its Contents field.
+func compressInto(
+	req *CreateObjectRequest,
+	w io.Writer) (compressed *CreateObjectRequest, err error) {
+	// Any of these would be ambiguous about whether they apply to the
+	// compressed or the uncompressed contents.
+	switch {
+	case req.ContentEncoding != "":
+		err = errors.New("Compress may not be combined with ContentEncoding")
+		return
+
+	case req.CRC32C != nil || req.MD5 != nil:
+		err = errors.New("Compress may not be combined with CRC32C or MD5")
+		return
+	}
+
+	// Compress the contents, checksumming the result as it's written.
+	crc32cHash := crc32.New(crc32cTable)
+	md5Hash := md5.New()
+	zw := gzip.NewWriter(io.MultiWriter(w, crc32cHash, md5Hash))
+
+	n, err := io.Copy(zw, req.Contents)
+	if err != nil {
+		err = fmt.Errorf("Compressing contents: %v", err)
+		return
+	}
+
+	if err = zw.Close(); err != nil {
+		err = fmt.Errorf("gzip.Writer.Close: %v", err)
+		return
+	}
+
+	// Set up the copy.
+	reqCopy := *req
+	compressed = &reqCopy
+
+	compressed.Compress = false
+	compressed.ContentEncoding = "gzip"
+
+	crc32c := crc32cHash.Sum32()
+	var md5Sum [md5.Size]byte
+	copy(md5Sum[:], md5Hash.Sum(nil))
+	compressed.CRC32C = &crc32c
+	compressed.MD5 = &md5Sum
+
+	compressed.Metadata = make(map[string]string)
+	for k, v := range req.Metadata {
+		compressed.Metadata[k] = v
+	}
+
+	compressed.Metadata[UncompressedSizeMetadataKey] = fmt.Sprintf("%d", n)
+
+	return
+}
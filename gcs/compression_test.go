@@ -0,0 +1,102 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"compress/gzip"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestCompression(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type CompressionTest struct {
+}
+
+func init() { RegisterTestSuite(&CompressionTest{}) }
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *CompressionTest) NotRequested() {
+	req := &CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader("taco"),
+	}
+
+	compressed, err := CompressCreateObjectRequest(req)
+	AssertEq(nil, err)
+	ExpectEq(req, compressed)
+}
+
+func (t *CompressionTest) ChecksumsSupplied() {
+	crc32c := uint32(17)
+	_, err := CompressCreateObjectRequest(&CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader("taco"),
+		CRC32C:   &crc32c,
+		Compress: true,
+	})
+
+	ExpectThat(err, Error(HasSubstr("CRC32C")))
+}
+
+func (t *CompressionTest) Compresses() {
+	req := &CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader("taco"),
+		Metadata: map[string]string{"foo": "bar"},
+		Compress: true,
+	}
+
+	compressed, err := CompressCreateObjectRequest(req)
+	AssertEq(nil, err)
+
+	ExpectFalse(compressed.Compress)
+	ExpectEq("gzip", compressed.ContentEncoding)
+	ExpectEq("bar", compressed.Metadata["foo"])
+	ExpectEq("4", compressed.Metadata[UncompressedSizeMetadataKey])
+
+	// The original request should be untouched.
+	ExpectTrue(req.Compress)
+	ExpectEq("", req.ContentEncoding)
+	ExpectEq(1, len(req.Metadata))
+
+	// The contents should decompress to the original, and match the
+	// checksums.
+	stored, err := ioutil.ReadAll(compressed.Contents)
+	AssertEq(nil, err)
+
+	AssertNe(nil, compressed.CRC32C)
+	ExpectEq(crc32.Checksum(stored, crc32cTable), *compressed.CRC32C)
+	AssertNe(nil, compressed.MD5)
+
+	zr, err := gzip.NewReader(strings.NewReader(string(stored)))
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(zr)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
@@ -17,8 +17,10 @@ package gcs
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	"golang.org/x/net/context"
@@ -26,9 +28,12 @@ import (
 
 	"github.com/jacobsa/gcloud/httputil"
 	"github.com/jacobsa/reqtrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"google.golang.org/api/googleapi"
 	storagev1 "google.golang.org/api/storage/v1"
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
 )
 
 // OAuth scopes for GCS. For use with e.g. google.DefaultTokenSource.
@@ -46,32 +51,251 @@ type Conn interface {
 	OpenBucket(
 		ctx context.Context,
 		name string) (b Bucket, err error)
+
+	// Create a new bucket in the connection's project, returning a record for
+	// it. Bucket names are globally unique, so this fails if any project
+	// already has a bucket with the requested name.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/insert
+	CreateBucket(
+		ctx context.Context,
+		req *CreateBucketRequest) (bi *BucketInfo, err error)
+
+	// Delete the bucket with the given name, which must be empty. Returns an
+	// error of type *NotFoundError if there is no such bucket.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/delete
+	DeleteBucket(
+		ctx context.Context,
+		name string) (err error)
+
+	// Return records for all of the buckets in the connection's project, in
+	// order of increasing name.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/list
+	ListBuckets(
+		ctx context.Context) (buckets []*BucketInfo, err error)
+
+	// Return a single page of records for the buckets in the connection's
+	// project matching the request, in order of increasing name. To enumerate
+	// a project with many buckets without holding them all in memory, use
+	// gcsutil.NewBucketIterator, which calls this as needed.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/list
+	ListBucketsPage(
+		ctx context.Context,
+		req *ListBucketsRequest) (listing *BucketListing, err error)
+
+	// Return a record for the bucket with the given name. Returns an error of
+	// type *NotFoundError if there is no such bucket.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/get
+	GetBucket(
+		ctx context.Context,
+		name string) (bi *BucketInfo, err error)
+
+	// Return the IAM policy of the bucket with the given name. Returns an error
+	// of type *NotFoundError if there is no such bucket.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/getIamPolicy
+	GetBucketIAMPolicy(
+		ctx context.Context,
+		name string) (p *IAMPolicy, err error)
+
+	// Replace the IAM policy of a bucket, returning the new policy. See the
+	// notes on IAMPolicy.Etag for read-modify-write cycles.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/setIamPolicy
+	SetBucketIAMPolicy(
+		ctx context.Context,
+		req *SetBucketIAMPolicyRequest) (p *IAMPolicy, err error)
+
+	// Set or remove the retention policy of a bucket, returning the updated
+	// record for the bucket. Returns an error of type *NotFoundError if there is
+	// no such bucket.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/patch
+	SetBucketRetentionPolicy(
+		ctx context.Context,
+		req *SetBucketRetentionPolicyRequest) (bi *BucketInfo, err error)
+
+	// Permanently lock the retention policy of a bucket, returning the updated
+	// record for the bucket. This cannot be undone: once locked, the policy
+	// can't be removed or its period reduced, and the bucket can't be deleted
+	// until every object in it has satisfied the policy.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/lockRetentionPolicy
+	LockBucketRetentionPolicy(
+		ctx context.Context,
+		req *LockBucketRetentionPolicyRequest) (bi *BucketInfo, err error)
+
+	// Set or remove the default Cloud KMS key of a bucket, returning the updated
+	// record for the bucket. Returns an error of type *NotFoundError if there is
+	// no such bucket.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/patch
+	SetBucketDefaultKMSKey(
+		ctx context.Context,
+		req *SetBucketDefaultKMSKeyRequest) (bi *BucketInfo, err error)
+
+	// Set the soft delete policy of a bucket, returning the updated record for
+	// the bucket. Returns an error of type *NotFoundError if there is no such
+	// bucket. The current policy is reported in BucketInfo.SoftDeletePolicy.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/patch
+	SetBucketSoftDeletePolicy(
+		ctx context.Context,
+		req *SetBucketSoftDeletePolicyRequest) (bi *BucketInfo, err error)
+
+	// Update the labels, default object ACL, versioning, website, or CORS
+	// configuration of a bucket, returning the updated record for the bucket.
+	// Returns an error of type *NotFoundError if there is no such bucket, or
+	// *PreconditionError if the meta-generation precondition isn't met.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/buckets/patch
+	UpdateBucket(
+		ctx context.Context,
+		req *UpdateBucketRequest) (bi *BucketInfo, err error)
+
+	// Return the default object ACL of the bucket with the given name, which
+	// is applied to objects created in the bucket without an ACL of their own.
+	// Returns an error of type *NotFoundError if there is no such bucket.
+	// Buckets with uniform bucket-level access have no default object ACL.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/defaultObjectAccessControls/list
+	ListDefaultObjectACLs(
+		ctx context.Context,
+		bucketName string) (rules []*ACLRule, err error)
+
+	// Grant a role on objects subsequently created in a bucket to an entity,
+	// returning the resulting default ACL entry. Existing objects are
+	// unaffected.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/defaultObjectAccessControls/insert
+	UpdateDefaultObjectACL(
+		ctx context.Context,
+		req *UpdateDefaultObjectACLRequest) (rule *ACLRule, err error)
+
+	// Remove an entity's entry from a bucket's default object ACL. Returns an
+	// error of type *NotFoundError if the bucket or entry doesn't exist.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/defaultObjectAccessControls/delete
+	DeleteDefaultObjectACL(
+		ctx context.Context,
+		req *DeleteDefaultObjectACLRequest) (err error)
+
+	// Add a Pub/Sub notification configuration to a bucket, returning the
+	// configuration as created.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/notifications/insert
+	CreateNotification(
+		ctx context.Context,
+		req *CreateNotificationRequest) (n *Notification, err error)
+
+	// Return the notification configurations of the bucket with the given
+	// name. Returns an error of type *NotFoundError if there is no such bucket.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/notifications/list
+	ListNotifications(
+		ctx context.Context,
+		bucketName string) (ns []*Notification, err error)
+
+	// Delete the notification configuration with the given ID from a bucket.
+	// Returns an error of type *NotFoundError if there is no such bucket or
+	// configuration.
+	//
+	// Official documentation:
+	//     https://cloud.google.com/storage/docs/json_api/v1/notifications/delete
+	DeleteNotification(
+		ctx context.Context,
+		bucketName string,
+		id string) (err error)
 }
 
 // ConnConfig contains options accepted by NewConn.
 type ConnConfig struct {
-	// An oauth2 token source to use for authenticating to GCS.
+	// An oauth2 token source to use for authenticating to GCS. Tokens are
+	// cached and refreshed shortly before they expire, so the source need not
+	// do this itself. Use ChooseScope to pick an appropriate scope.
 	//
-	// You probably want this one:
+	// You probably want this one, which also supports workload identity on GKE:
 	//     http://godoc.org/golang.org/x/oauth2/google#DefaultTokenSource
+	//
+	// See TokenSourceFromJSON for service account keys and other credentials
+	// files.
 	TokenSource oauth2.TokenSource
 
+	// The ID of the project within which to create and list buckets. This is
+	// required only for Conn.CreateBucket and Conn.ListBuckets.
+	ProjectID string
+
 	// The value to set in User-Agent headers for outgoing HTTP requests. If
 	// empty, a default will be used.
 	UserAgent string
 
+	// If non-empty, appended after a space to the User-Agent, whether the
+	// default or UserAgent. Use this to identify your application while
+	// keeping this package's identifier.
+	UserAgentSuffix string
+
+	// Headers to add to every request sent to GCS, such as
+	// x-goog-custom-audit-* headers for Cloud Audit Logs. Headers that this
+	// package sets itself, such as Authorization and Content-Type, take
+	// precedence. They're sent as metadata with gRPC calls.
+	Headers http.Header
+
 	// The HTTP transport to use for communication with GCS. If not supplied,
-	// http.DefaultTransport will be used.
+	// http.DefaultTransport will be used, or a copy of it adjusted according to
+	// the tuning options below.
 	Transport httputil.CancellableRoundTripper
 
+	// Tuning for the HTTP transport used when Transport is nil. These can't be
+	// combined with Transport; configure your own transport instead.
+	//
+	// MaxIdleConnsPerHost is the number of idle connections to GCS kept open
+	// for reuse. The net/http default of 2 means that highly concurrent
+	// workloads keep opening new connections, so set this to roughly the
+	// number of requests you expect to have in flight.
+	MaxIdleConnsPerHost int
+
+	// If true, HTTP/2 is not used. HTTP/2 multiplexes concurrent requests over
+	// a single connection, which can limit the throughput of workloads that
+	// transfer lots of data in parallel.
+	DisableHTTP2 bool
+
+	// Timeouts for establishing TCP connections to GCS and completing TLS
+	// handshakes with it. If zero, the net/http defaults of 30 and 10 seconds
+	// are used.
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+
 	// The maximum amount of time to spend sleeping in a retry loop with
 	// exponential backoff for failed requests. The default of zero disables
 	// automatic retries.
 	//
 	// If you enable automatic retries, beware of the following:
 	//
-	//  *  Bucket.CreateObject will buffer the entire object contents in memory,
-	//     so your object contents must not be too large to fit.
+	//  *  Bucket.CreateObject will buffer the entire object contents in memory
+	//     unless the request's Contents field implements io.Seeker, its
+	//     ChunkSize field is negative, or it is made by an ObjectWriter, so your
+	//     object contents must otherwise not be too large to fit.
 	//
 	//  *  Bucket.NewReader needs to perform an additional round trip to GCS in
 	//     order to find the latest object generation if you don't specify a
@@ -82,10 +306,112 @@ type ConnConfig struct {
 	//
 	MaxBackoffSleep time.Duration
 
+	// The pool from which to obtain buffers for upload chunks, compressed
+	// contents, and contents buffered for retries. Share one pool between
+	// connections to cap the memory used by all of their transfers together. If
+	// nil, a shared pool without a limit is used.
+	BufferPool *BufferPool
+
+	// Default deadlines for bucket operations whose context has none, by class
+	// of operation. Each covers the whole of a call, including any retries.
+	// See NewTimeoutBucket. The default of zero means no deadline.
+	Timeouts TimeoutPolicy
+
+	// The size of the chunks in which Bucket.CreateObject sends object contents
+	// to GCS. Each chunk is buffered in memory, and after a transient failure
+	// the upload resumes from the last byte GCS has committed rather than
+	// starting over. Must be a multiple of 256 KiB. If zero, 16 MiB is used.
+	// This can be overridden per request with CreateObjectRequest.ChunkSize.
+	UploadChunkSize int
+
+	// If positive, Bucket.CreateObject sends objects whose size is known in
+	// advance (see CreateObjectRequest.Contents) and no larger than this many
+	// bytes in a single media or multipart upload request, rather than
+	// starting a resumable upload session first. This halves the number of
+	// round trips for small objects, at the cost of having to resend the whole
+	// object after a failure. The default of zero always uses resumable
+	// uploads. See also CreateObjectRequest.UploadType.
+	MultipartUploadThreshold int64
+
+	// If non-empty, the ID of the project to bill for requests made to buckets,
+	// as required for buckets with Requester Pays enabled. It is sent as the
+	// userProject parameter of every request and included in signed URLs.
+	UserProject string
+
+	// Credentials with which Bucket.SignedURL signs URLs. If nil, SignedURL
+	// returns an error. See SigningCredentialsFromJSON for a convenient way to
+	// obtain these from a service account key file.
+	SigningCredentials *SigningCredentials
+
+	// If true, buckets use the Cloud Storage gRPC API rather than the JSON API
+	// for reading, writing, copying, composing, statting, listing, and deleting
+	// objects. This has lower per-request latency and streams reads, but
+	// uploads can't be resumed after a failure. Other operations continue to
+	// use the JSON API.
+	//
+	// Cf. https://cloud.google.com/storage/docs/grpc
+	UseGRPC bool
+
+	// The address of the gRPC API when UseGRPC is set. If empty,
+	// storage.googleapis.com:443 is used.
+	GRPCEndpoint string
+
+	// If non-empty, the base URL of the endpoint serving the JSON API, for
+	// example "https://restricted.googleapis.com" or
+	// "https://private.googleapis.com" to reach GCS through Private Google
+	// Access from within a VPC Service Controls perimeter. The URL may include
+	// a base path, as for proxies that serve the API's paths beneath one. If
+	// empty, https://www.googleapis.com is used.
+	//
+	// Uploads and downloads use the same endpoint unless UploadEndpoint or
+	// DownloadEndpoint are set. Use GRPCEndpoint to configure the gRPC API.
+	//
+	// Cf. https://cloud.google.com/vpc/docs/configure-private-google-access
+	Endpoint         string
+	UploadEndpoint   string
+	DownloadEndpoint string
+
+	// If non-empty, the address of a GCS emulator such as fake-gcs-server to
+	// use instead of GCS, for example "localhost:4443" or
+	// "https://localhost:4443/base". Plain HTTP is assumed if no scheme is
+	// given. If empty, the STORAGE_EMULATOR_HOST environment variable is
+	// consulted. The emulator replaces the default for Endpoint. TokenSource
+	// is optional when using an emulator, and the gRPC API and signed URLs
+	// still refer to GCS itself.
+	EmulatorHost string
+
+	// If non-nil, called with the status and headers of every HTTP response
+	// received from GCS. See also WithResponseObserver for observing the
+	// responses for particular operations.
+	ResponseObserver ResponseObserver
+
+	// If non-nil, called at the start of each traced span of work performed by
+	// buckets opened with the connection, whether or not reqtrace is enabled.
+	// See SpanHook.
+	SpanHook SpanHook
+
+	// If true, buckets opened with the connection record an OpenTelemetry span
+	// for each operation, with attributes such as AttrBucket and AttrObject,
+	// and child spans for the steps it takes (see SpanHook). The trace context
+	// of the caller is propagated to GCS in the headers of each request.
+	OpenTelemetry bool
+
+	// The tracer provider and propagator to use when OpenTelemetry is set. If
+	// nil, those registered with the otel package are used.
+	TracerProvider trace.TracerProvider
+	Propagator     propagation.TextMapPropagator
+
 	// Loggers for GCS events, and (much more verbose) HTTP requests and
 	// responses. If nil, no logging is performed.
 	GCSDebugLogger  *log.Logger
 	HTTPDebugLogger *log.Logger
+
+	// If non-nil, a description of each HTTP request and its response is
+	// written to WireLog: the method, URL, headers, status, latency, and the
+	// attempt number when the request is retried (see MaxBackoffSleep).
+	// Unlike with HTTPDebugLogger, bodies are elided and credentials such as
+	// the Authorization header are redacted, so the output is safe to share.
+	WireLog io.Writer
 }
 
 // Open a connection to GCS.
@@ -97,34 +423,143 @@ func NewConn(cfg *ConnConfig) (c Conn, err error) {
 		userAgent = defaultUserAgent
 	}
 
+	if cfg.UserAgentSuffix != "" {
+		userAgent += " " + cfg.UserAgentSuffix
+	}
+
 	// Choose the basic transport.
-	transport := cfg.Transport
-	if cfg.HTTPDebugLogger != nil {
-		transport = http.DefaultTransport.(httputil.CancellableRoundTripper)
+	transport, err := newHTTPTransport(cfg)
+	if err != nil {
+		return
 	}
 
+	// Report responses to any observers.
+	transport = newResponseObservingRoundTripper(transport, cfg.ResponseObserver)
+
 	// Enable HTTP debugging if requested.
 	if cfg.HTTPDebugLogger != nil {
 		transport = httputil.DebuggingRoundTripper(transport, cfg.HTTPDebugLogger)
 	}
 
-	// Wrap the HTTP transport in an oauth layer.
-	if cfg.TokenSource == nil {
+	// Send request IDs, audit entries, trace context, and any extra headers,
+	// outside of the debugging layer so that they are logged.
+	transport = newRequestIDRoundTripper(transport)
+	transport = newAuditRoundTripper(transport)
+
+	if cfg.OpenTelemetry {
+		transport = newTracePropagatingRoundTripper(transport, choosePropagator(cfg))
+	}
+
+	headers := copyHeaders(cfg.Headers)
+	if len(headers) != 0 {
+		transport = newHeaderRoundTripper(transport, headers)
+	}
+
+	// Choose where to send requests.
+	endpoint, uploadEndpoint, downloadEndpoint, err := chooseEndpoints(cfg)
+	if err != nil {
+		return
+	}
+
+	// Wrap the HTTP transport in an oauth layer. Emulators don't check
+	// credentials, so they are optional there.
+	var tokenSource oauth2.TokenSource
+	switch {
+	case cfg.TokenSource != nil:
+		tokenSource = newRefreshingTokenSource(cfg.TokenSource)
+		transport = &oauth2.Transport{
+			Source: tokenSource,
+			Base:   transport,
+		}
+
+	case emulatorHost(cfg) == "":
 		err = errors.New("You must set TokenSource.")
 		return
 	}
 
-	transport = &oauth2.Transport{
-		Source: cfg.TokenSource,
-		Base:   transport,
+	// Log requests on the wire if requested. This goes outside the oauth layer
+	// so that requests are logged with all of their headers.
+	if cfg.WireLog != nil {
+		transport = newWireLoggingRoundTripper(transport, cfg.WireLog)
+	}
+
+	// Choose an upload chunk size.
+	uploadChunkSize := cfg.UploadChunkSize
+	switch {
+	case uploadChunkSize == 0:
+		uploadChunkSize = defaultUploadChunkSize
+
+	case uploadChunkSize < 0 || uploadChunkSize%uploadChunkGranularity != 0:
+		err = fmt.Errorf(
+			"UploadChunkSize must be a positive multiple of %d",
+			uploadChunkGranularity)
+		return
+	}
+
+	// Prepare to sign URLs if we've been given credentials.
+	var signer *urlSigner
+	if cfg.SigningCredentials != nil {
+		signer, err = newURLSigner(cfg.SigningCredentials)
+		if err != nil {
+			err = fmt.Errorf("SigningCredentials: %v", err)
+			return
+		}
+	}
+
+	// Set up a gRPC client if requested.
+	var grpcClient storagepb.StorageClient
+	if cfg.UseGRPC {
+		var propagator propagation.TextMapPropagator
+		if cfg.OpenTelemetry {
+			propagator = choosePropagator(cfg)
+		}
+
+		grpcClient, err = newGRPCClient(
+			cfg.GRPCEndpoint,
+			tokenSource,
+			userAgent,
+			propagator)
+
+		if err != nil {
+			err = fmt.Errorf("newGRPCClient: %v", err)
+			return
+		}
+	}
+
+	// Choose a buffer pool.
+	bufferPool := cfg.BufferPool
+	if bufferPool == nil {
+		bufferPool = defaultBufferPool
+	}
+
+	// Choose a tracer if requested.
+	var tracer trace.Tracer
+	if cfg.OpenTelemetry {
+		tracer = chooseTracerProvider(cfg).Tracer(otelTracerName)
 	}
 
 	// Set up the connection.
 	c = &conn{
 		client:          &http.Client{Transport: transport},
 		userAgent:       userAgent,
+		projectID:       cfg.ProjectID,
 		maxBackoffSleep: cfg.MaxBackoffSleep,
+		timeouts:        cfg.Timeouts,
+		uploadChunkSize: uploadChunkSize,
+		bufferPool:      bufferPool,
+		headers:         headers,
+		signer:          signer,
+		userProject:     cfg.UserProject,
 		debugLogger:     cfg.GCSDebugLogger,
+		spanHook:        cfg.SpanHook,
+		tracer:          tracer,
+		grpcClient:      grpcClient,
+
+		multipartUploadThreshold: cfg.MultipartUploadThreshold,
+
+		endpoint:         endpoint,
+		uploadEndpoint:   uploadEndpoint,
+		downloadEndpoint: downloadEndpoint,
 	}
 
 	return
@@ -133,25 +568,94 @@ func NewConn(cfg *ConnConfig) (c Conn, err error) {
 type conn struct {
 	client          *http.Client
 	userAgent       string
+	projectID       string
 	maxBackoffSleep time.Duration
+	timeouts        TimeoutPolicy
+	uploadChunkSize int
+	bufferPool      *BufferPool
+	headers         http.Header
+	signer          *urlSigner
+	userProject     string
 	debugLogger     *log.Logger
+	spanHook        SpanHook
+
+	// Non-nil if buckets should record OpenTelemetry spans.
+	tracer trace.Tracer
+
+	// See ConnConfig.MultipartUploadThreshold.
+	multipartUploadThreshold int64
+
+	// Non-nil if buckets should use the gRPC API.
+	grpcClient storagepb.StorageClient
+
+	// The endpoints serving the JSON API, its uploads, and its downloads, or
+	// nil for the default.
+	endpoint         *url.URL
+	uploadEndpoint   *url.URL
+	downloadEndpoint *url.URL
 }
 
 func (c *conn) OpenBucket(
 	ctx context.Context,
 	name string) (b Bucket, err error) {
-	b = newBucket(c.client, c.userAgent, name)
+	b, err = c.openBucket(ctx, name, "")
+	return
+}
+
+// Open a bucket, probing for bad credentials by listing objects with the
+// given prefix, which the credentials must be permitted to do.
+func (c *conn) openBucket(
+	ctx context.Context,
+	name string,
+	probePrefix string) (b Bucket, err error) {
+	httpBucket := newBucket(
+		c.client,
+		c.userAgent,
+		name,
+		c.uploadChunkSize,
+		c.signer,
+		c.userProject)
+
+	httpBucket.endpoint = c.endpoint
+	httpBucket.uploadEndpoint = c.uploadEndpoint
+	httpBucket.downloadEndpoint = c.downloadEndpoint
+	httpBucket.bufferPool = c.bufferPool
+	httpBucket.multipartUploadThreshold = c.multipartUploadThreshold
+	b = httpBucket
+
+	// Switch to the gRPC API if requested.
+	if c.grpcClient != nil {
+		grpcBucket := newGRPCBucket(c.grpcClient, b, c.userProject)
+		grpcBucket.bufferPool = c.bufferPool
+		grpcBucket.headers = c.headers
+		b = grpcBucket
+	}
 
 	// Enable retry loops if requested.
 	if c.maxBackoffSleep > 0 {
-		// TODO(jacobsa): Show the retries as distinct spans in the trace.
-		b = newRetryBucket(c.maxBackoffSleep, b)
+		b = NewRetryBucket(b, RetryPolicy{
+			MaxSleep:   c.maxBackoffSleep,
+			BufferPool: c.bufferPool,
+		})
+	}
+
+	// Apply default deadlines, if any, to the retry loops as a whole.
+	if c.timeouts != (TimeoutPolicy{}) {
+		b = NewTimeoutBucket(b, c.timeouts)
+	}
+
+	// Record OpenTelemetry spans if requested. This goes inside the reqtrace
+	// layer so that the spans that layer starts for each operation aren't
+	// recorded again as children of the OpenTelemetry spans.
+	if c.tracer != nil {
+		b = newOTelBucket(b, c.tracer)
 	}
 
 	// Enable tracing if appropriate.
-	if reqtrace.Enabled() {
+	if reqtrace.Enabled() || c.spanHook != nil {
 		b = &reqtraceBucket{
 			Wrapped: b,
+			Hook:    c.spanHook,
 		}
 	}
 
@@ -165,24 +669,34 @@ func (c *conn) OpenBucket(
 	// the latter case, with a more helpful message than just "HTTP 403
 	// Forbidden". Similarly for bad bucket names that don't collide with another
 	// bucket.
-	_, err = b.ListObjects(ctx, &ListObjectsRequest{MaxResults: 1})
+	_, err = b.ListObjects(ctx, &ListObjectsRequest{
+		Prefix:     probePrefix,
+		MaxResults: 1,
+		Fields:     []string{"name"},
+	})
 
-	if typed, ok := err.(*googleapi.Error); ok {
-		switch typed.Code {
-		case http.StatusForbidden:
-			err = fmt.Errorf(
-				"Bad credentials for bucket %q. Check the bucket name and your "+
-					"credentials.",
-				b.Name())
+	if _, ok := err.(*ForbiddenError); ok {
+		err = fmt.Errorf(
+			"Bad credentials for bucket %q. Check the bucket name and your "+
+				"credentials.",
+			b.Name())
 
-			return
+		return
+	}
 
-		case http.StatusNotFound:
+	if typed, ok := err.(*googleapi.Error); ok {
+		if typed.Code == http.StatusNotFound {
 			err = fmt.Errorf("Unknown bucket %q", b.Name())
 			return
 		}
 	}
 
+	// The gRPC API reports this condition as NOT_FOUND.
+	if _, ok := err.(*NotFoundError); ok {
+		err = fmt.Errorf("Unknown bucket %q", b.Name())
+		return
+	}
+
 	// Otherwise, don't interfere.
 	err = nil
 
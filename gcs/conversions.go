@@ -17,6 +17,7 @@ package gcs
 import (
 	"crypto/md5"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -35,6 +36,15 @@ func toTime(s string) (t time.Time, err error) {
 	return
 }
 
+// The inverse of toTime, mapping the zero time to the empty string.
+func fromTime(t time.Time) (s string) {
+	if !t.IsZero() {
+		s = t.UTC().Format(time.RFC3339Nano)
+	}
+
+	return
+}
+
 func toObjects(in []*storagev1.Object) (out []*Object, err error) {
 	for _, rawObject := range in {
 		var o *Object
@@ -65,6 +75,24 @@ func toListing(in *storagev1.Objects) (out *Listing, err error) {
 	return
 }
 
+// Parse an object resource in the format used by the JSON API, as found for
+// example in the payloads of Pub/Sub notifications with format
+// NotificationPayloadJSON.
+func ParseObjectJSON(data []byte) (o *Object, err error) {
+	var rawObject *storagev1.Object
+	if err = json.Unmarshal(data, &rawObject); err != nil {
+		return
+	}
+
+	if rawObject == nil {
+		err = fmt.Errorf("No object in JSON: %q", data)
+		return
+	}
+
+	o, err = toObject(rawObject)
+	return
+}
+
 func toObject(in *storagev1.Object) (out *Object, err error) {
 	// Convert the easy fields.
 	out = &Object{
@@ -80,6 +108,10 @@ func toObject(in *storagev1.Object) (out *Object, err error) {
 		Generation:      in.Generation,
 		MetaGeneration:  in.Metageneration,
 		StorageClass:    in.StorageClass,
+		TemporaryHold:   in.TemporaryHold,
+		EventBasedHold:  in.EventBasedHold,
+		KMSKeyName:      in.KmsKeyName,
+		Etag:            in.Etag,
 	}
 
 	// Work around Google-internal bug 21572928. See notes on the ComponentCount
@@ -93,6 +125,12 @@ func toObject(in *storagev1.Object) (out *Object, err error) {
 		out.Owner = in.Owner.Entity
 	}
 
+	// Creation time
+	if out.Created, err = toTime(in.TimeCreated); err != nil {
+		err = fmt.Errorf("Decoding TimeCreated field: %v", err)
+		return
+	}
+
 	// Deletion time
 	if out.Deleted, err = toTime(in.TimeDeleted); err != nil {
 		err = fmt.Errorf("Decoding TimeDeleted field: %v", err)
@@ -105,44 +143,193 @@ func toObject(in *storagev1.Object) (out *Object, err error) {
 		return
 	}
 
+	// Retention expiration time
+	if out.RetentionExpirationTime, err = toTime(in.RetentionExpirationTime); err != nil {
+		err = fmt.Errorf("Decoding RetentionExpirationTime field: %v", err)
+		return
+	}
+
+	// Custom time
+	if out.CustomTime, err = toTime(in.CustomTime); err != nil {
+		err = fmt.Errorf("Decoding CustomTime field: %v", err)
+		return
+	}
+
+	// Soft deletion times
+	if out.SoftDeleteTime, err = toTime(in.SoftDeleteTime); err != nil {
+		err = fmt.Errorf("Decoding SoftDeleteTime field: %v", err)
+		return
+	}
+
+	if out.HardDeleteTime, err = toTime(in.HardDeleteTime); err != nil {
+		err = fmt.Errorf("Decoding HardDeleteTime field: %v", err)
+		return
+	}
+
+	// Object retention
+	if in.Retention != nil {
+		out.Retention = &ObjectRetention{Mode: in.Retention.Mode}
+		if out.Retention.RetainUntil, err = toTime(in.Retention.RetainUntilTime); err != nil {
+			err = fmt.Errorf("Decoding Retention.RetainUntilTime field: %v", err)
+			return
+		}
+	}
+
 	// MD5
 	if in.Md5Hash != "" {
-		var md5Slice []byte
-		md5Slice, err = base64.StdEncoding.DecodeString(in.Md5Hash)
-		if err != nil {
+		if out.MD5, err = toMD5(in.Md5Hash); err != nil {
 			err = fmt.Errorf("Decoding Md5Hash field: %v", err)
 			return
 		}
+	}
 
-		out.MD5 = new([md5.Size]byte)
-		if len(md5Slice) != len(out.MD5) {
-			err = fmt.Errorf("Unexpected Md5Hash field: %q", in.Md5Hash)
+	// CRC32C. GCS always supplies this, except in partial responses that
+	// didn't ask for it.
+	if in.Crc32c != "" {
+		if out.CRC32C, err = toCRC32C(in.Crc32c); err != nil {
+			err = fmt.Errorf("Decoding Crc32c field: %v", err)
 			return
 		}
+	}
 
-		copy(out.MD5[:], md5Slice)
+	return
+}
+
+// Decode a base64-encoded big-endian CRC32C checksum, as found in object
+// resources and X-Goog-Hash headers.
+func toCRC32C(s string) (crc32c uint32, err error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return
 	}
 
-	// CRC32C
-	crc32cString, err := base64.StdEncoding.DecodeString(in.Crc32c)
+	if len(b) != 4 {
+		err = fmt.Errorf("Wrong length for decoded CRC32C: %d", len(b))
+		return
+	}
+
+	crc32c =
+		uint32(b[0])<<24 |
+			uint32(b[1])<<16 |
+			uint32(b[2])<<8 |
+			uint32(b[3])<<0
+
+	return
+}
+
+// Decode a base64-encoded MD5 hash, as found in object resources and
+// X-Goog-Hash headers.
+func toMD5(s string) (md5Sum *[md5.Size]byte, err error) {
+	b, err := base64.StdEncoding.DecodeString(s)
 	if err != nil {
-		err = fmt.Errorf("Decoding Crc32c field: %v", err)
 		return
 	}
 
-	if len(crc32cString) != 4 {
-		err = fmt.Errorf(
-			"Wrong length for decoded Crc32c field: %d",
-			len(crc32cString))
+	if len(b) != md5.Size {
+		err = fmt.Errorf("Wrong length for decoded MD5: %d", len(b))
+		return
+	}
+
+	md5Sum = new([md5.Size]byte)
+	copy(md5Sum[:], b)
+
+	return
+}
+
+func toBucketInfo(in *storagev1.Bucket) (out *BucketInfo, err error) {
+	out = &BucketInfo{
+		Name:           in.Name,
+		Location:       in.Location,
+		LocationType:   in.LocationType,
+		StorageClass:   in.StorageClass,
+		MetaGeneration: in.Metageneration,
+		RPO:            in.Rpo,
+		Labels:         in.Labels,
+	}
+
+	if in.Versioning != nil {
+		out.VersioningEnabled = in.Versioning.Enabled
+	}
+
+	for _, rule := range in.DefaultObjectAcl {
+		out.DefaultObjectACL = append(out.DefaultObjectACL, *toACLRule(rule))
+	}
+
+	if in.Website != nil {
+		out.Website = &WebsiteConfig{
+			MainPageSuffix: in.Website.MainPageSuffix,
+			NotFoundPage:   in.Website.NotFoundPage,
+		}
+	}
+
+	for _, rule := range in.Cors {
+		out.CORS = append(out.CORS, CORSRule{
+			Origins:         rule.Origin,
+			Methods:         rule.Method,
+			ResponseHeaders: rule.ResponseHeader,
+			MaxAge:          time.Duration(rule.MaxAgeSeconds) * time.Second,
+		})
+	}
+
+	if in.CustomPlacementConfig != nil {
+		out.DataLocations = in.CustomPlacementConfig.DataLocations
+	}
+
+	if in.Autoclass != nil && in.Autoclass.Enabled {
+		out.Autoclass = true
+		out.AutoclassTerminalStorageClass = in.Autoclass.TerminalStorageClass
+	}
+
+	if in.IamConfiguration != nil &&
+		in.IamConfiguration.UniformBucketLevelAccess != nil {
+		out.UniformBucketLevelAccess =
+			in.IamConfiguration.UniformBucketLevelAccess.Enabled
+	}
 
+	if in.Encryption != nil {
+		out.DefaultKMSKeyName = in.Encryption.DefaultKmsKeyName
+	}
+
+	// Creation time
+	if out.Created, err = toTime(in.TimeCreated); err != nil {
+		err = fmt.Errorf("Decoding TimeCreated field: %v", err)
+		return
+	}
+
+	// Update time
+	if out.Updated, err = toTime(in.Updated); err != nil {
+		err = fmt.Errorf("Decoding Updated field: %v", err)
 		return
 	}
 
-	out.CRC32C =
-		uint32(crc32cString[0])<<24 |
-			uint32(crc32cString[1])<<16 |
-			uint32(crc32cString[2])<<8 |
-			uint32(crc32cString[3])<<0
+	// Retention policy
+	if in.RetentionPolicy != nil {
+		out.RetentionPolicy = &RetentionPolicy{
+			Period:   time.Duration(in.RetentionPolicy.RetentionPeriod) * time.Second,
+			IsLocked: in.RetentionPolicy.IsLocked,
+		}
+
+		out.RetentionPolicy.EffectiveTime, err =
+			toTime(in.RetentionPolicy.EffectiveTime)
+
+		if err != nil {
+			err = fmt.Errorf("Decoding EffectiveTime field: %v", err)
+			return
+		}
+	}
+
+	// Soft delete policy. A zero duration means that soft delete is disabled.
+	if p := in.SoftDeletePolicy; p != nil && p.RetentionDurationSeconds != 0 {
+		out.SoftDeletePolicy = &SoftDeletePolicy{
+			RetentionDuration: time.Duration(p.RetentionDurationSeconds) * time.Second,
+		}
+
+		out.SoftDeletePolicy.EffectiveTime, err = toTime(p.EffectiveTime)
+		if err != nil {
+			err = fmt.Errorf("Decoding SoftDeletePolicy.EffectiveTime field: %v", err)
+			return
+		}
+	}
 
 	return
 }
@@ -162,6 +349,10 @@ func toRawObject(
 		ContentEncoding: in.ContentEncoding,
 		CacheControl:    in.CacheControl,
 		Metadata:        in.Metadata,
+		TemporaryHold:   in.TemporaryHold,
+		EventBasedHold:  in.EventBasedHold,
+		CustomTime:      fromTime(in.CustomTime),
+		StorageClass:    in.StorageClass,
 	}
 
 	if in.CRC32C != nil {
@@ -0,0 +1,160 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"testing"
+	"time"
+
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestConversions(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ConversionsTest struct {
+}
+
+func init() { RegisterTestSuite(&ConversionsTest{}) }
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ConversionsTest) JSONObject() {
+	o, err := ParseObjectJSON([]byte(`{
+		"name": "foo",
+		"generation": "17",
+		"metageneration": "2",
+		"size": "4",
+		"crc32c": "AAAAEQ==",
+		"componentCount": 3,
+		"etag": "CBEQAg==",
+		"timeCreated": "2019-01-02T03:04:05Z",
+		"kmsKeyName": "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+		"customTime": "2020-01-02T03:04:05Z",
+		"retention": {
+			"mode": "Locked",
+			"retainUntilTime": "2030-01-02T03:04:05Z"
+		}
+	}`))
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq(17, o.Generation)
+	ExpectEq(2, o.MetaGeneration)
+	ExpectEq(4, o.Size)
+	ExpectEq(17, o.CRC32C)
+	ExpectEq(3, o.ComponentCount)
+	ExpectEq("CBEQAg==", o.Etag)
+	ExpectEq("projects/p/locations/l/keyRings/r/cryptoKeys/k", o.KMSKeyName)
+	ExpectTrue(
+		o.Created.Equal(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)),
+		"%v",
+		o.Created)
+	ExpectTrue(
+		o.CustomTime.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)),
+		"%v",
+		o.CustomTime)
+
+	AssertNe(nil, o.Retention)
+	ExpectEq("Locked", o.Retention.Mode)
+	ExpectTrue(
+		o.Retention.RetainUntil.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)),
+		"%v",
+		o.Retention.RetainUntil)
+}
+
+func (t *ConversionsTest) JSONObjectWithoutOptionalFields() {
+	o, err := ParseObjectJSON([]byte(`{"name": "foo"}`))
+
+	AssertEq(nil, err)
+	ExpectEq(1, o.ComponentCount)
+	ExpectEq("", o.Etag)
+	ExpectTrue(o.Created.IsZero())
+	ExpectEq("", o.KMSKeyName)
+	ExpectTrue(o.CustomTime.IsZero())
+	ExpectEq(nil, o.Retention)
+}
+
+func (t *ConversionsTest) JSONObjectWithBadTimeCreated() {
+	_, err := ParseObjectJSON([]byte(`{"name": "foo", "timeCreated": "taco"}`))
+	ExpectThat(err, Error(HasSubstr("TimeCreated")))
+}
+
+func (t *ConversionsTest) JSONObjectWithBadCustomTime() {
+	_, err := ParseObjectJSON([]byte(`{"name": "foo", "customTime": "taco"}`))
+	ExpectThat(err, Error(HasSubstr("CustomTime")))
+}
+
+func (t *ConversionsTest) ProtoObject() {
+	customTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	createTime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	o, err := fromProtoObject(&storagepb.Object{
+		Name:           "foo",
+		KmsKey:         "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+		CustomTime:     timestamppb.New(customTime),
+		CreateTime:     timestamppb.New(createTime),
+		Etag:           "CBEQAg==",
+		ComponentCount: 2,
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq("CBEQAg==", o.Etag)
+	ExpectEq(2, o.ComponentCount)
+	ExpectTrue(o.Created.Equal(createTime), "%v", o.Created)
+	ExpectEq("projects/p/locations/l/keyRings/r/cryptoKeys/k", o.KMSKeyName)
+	ExpectTrue(o.CustomTime.Equal(customTime), "%v", o.CustomTime)
+	ExpectEq(nil, o.Retention)
+}
+
+func (t *ConversionsTest) RawObjectCustomTime() {
+	customTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("X", 3600))
+	out, err := toRawObject("bucket", &CreateObjectRequest{
+		Name:       "foo",
+		CustomTime: customTime,
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("2020-01-02T02:04:05Z", out.CustomTime)
+
+	out, err = toRawObject("bucket", &CreateObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	ExpectEq("", out.CustomTime)
+}
+
+func (t *ConversionsTest) RawObjectStorageClass() {
+	out, err := toRawObject("bucket", &CreateObjectRequest{
+		Name:         "foo",
+		StorageClass: "COLDLINE",
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("COLDLINE", out.StorageClass)
+
+	out, err = toRawObject("bucket", &CreateObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	ExpectEq("", out.StorageClass)
+}
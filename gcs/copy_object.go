@@ -15,12 +15,9 @@
 package gcs
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
-	"unicode/utf8"
 
 	"github.com/jacobsa/gcloud/httputil"
 	"google.golang.org/api/googleapi"
@@ -35,14 +32,13 @@ func (b *bucket) CopyObject(
 	// We encode using json.NewEncoder, which is documented to silently transform
 	// invalid UTF-8 (cf. http://goo.gl/3gIUQB). So we can't rely on the server
 	// to detect this for us.
-	if !utf8.ValidString(req.DstName) {
-		err = errors.New("Invalid object name: not valid UTF-8")
+	if err = checkObjectNames(req.SrcName, req.DstName); err != nil {
 		return
 	}
 
 	// Construct an appropriate URL (cf. https://goo.gl/A41CyJ).
-	opaque := fmt.Sprintf(
-		"//www.googleapis.com/storage/v1/b/%s/o/%s/copyTo/b/%s/o/%s",
+	path := fmt.Sprintf(
+		"/storage/v1/b/%s/o/%s/copyTo/b/%s/o/%s",
 		httputil.EncodePathSegment(b.Name()),
 		httputil.EncodePathSegment(req.SrcName),
 		httputil.EncodePathSegment(b.Name()),
@@ -61,13 +57,14 @@ func (b *bucket) CopyObject(
 			fmt.Sprintf("%d", *req.SrcMetaGenerationPrecondition))
 	}
 
-	url := &url.URL{
-		Scheme:   "https",
-		Host:     "www.googleapis.com",
-		Opaque:   opaque,
-		RawQuery: query.Encode(),
+	if req.DstPredefinedACL != "" {
+		query.Set("destinationPredefinedAcl", req.DstPredefinedACL)
 	}
 
+	b.addCommonParams(query)
+
+	url := makeURL(b.endpoint, path, query)
+
 	// Create an HTTP request.
 	httpReq, err := httputil.NewRequest(ctx, "POST", url, nil, 0, b.userAgent)
 	if err != nil {
@@ -86,7 +83,7 @@ func (b *bucket) CopyObject(
 	defer googleapi.CloseBody(httpRes)
 
 	// Check for HTTP-level errors.
-	if err = googleapi.CheckResponse(httpRes); err != nil {
+	if err = checkResponse(httpRes); err != nil {
 		// Special case: handle not found errors.
 		if typed, ok := err.(*googleapi.Error); ok {
 			if typed.Code == http.StatusNotFound {
@@ -106,7 +103,7 @@ func (b *bucket) CopyObject(
 
 	// Parse the response.
 	var rawObject *storagev1.Object
-	if err = json.NewDecoder(httpRes.Body).Decode(&rawObject); err != nil {
+	if err = decodeResponse(httpRes, &rawObject); err != nil {
 		return
 	}
 
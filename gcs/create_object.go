@@ -16,13 +16,19 @@ package gcs
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"strings"
+	"os"
+	"time"
 	"unicode/utf8"
 
 	"github.com/jacobsa/gcloud/httputil"
@@ -31,9 +37,76 @@ import (
 	storagev1 "google.golang.org/api/storage/v1"
 )
 
+// The default size of the chunks in which CreateObject sends object contents.
+const defaultUploadChunkSize = 16 << 20
+
+// GCS requires that the size of every chunk but the last in a resumable upload
+// be a multiple of this.
+const uploadChunkGranularity = 256 << 10
+
+// The number of consecutive times we'll attempt to send a chunk without GCS
+// committing any more of it before giving up.
+const maxUploadChunkAttempts = 5
+
+// How long to spend asking GCS to discard a cancelled upload.
+const abortUploadTimeout = 10 * time.Second
+
+// Ways of sending an object's contents to GCS with the JSON API, for
+// CreateObjectRequest.UploadType. See here for more information:
+//
+//     https://cloud.google.com/storage/docs/uploads-downloads#uploads
+//
+const (
+	// A session started with one request and then sent the contents in
+	// chunks, resuming from where GCS got to after a failure.
+	UploadTypeResumable = "resumable"
+
+	// A single multipart/related request carrying the object's metadata and
+	// its contents.
+	UploadTypeMultipart = "multipart"
+
+	// A single request carrying just the contents, with the object's name and
+	// content type in the URL and headers.
+	UploadTypeMedia = "media"
+)
+
+// Backoff between attempts to send a chunk.
+var uploadChunkRetryPolicy = RetryPolicy{
+	InitialDelay: time.Second,
+	MaxDelay:     32 * time.Second,
+}
+
+// Return an error if a user metadata entry can't be carried faithfully by the
+// JSON API. Without this, encoding/json would silently replace invalid UTF-8
+// with U+FFFD, storing something other than what the caller asked for.
+func checkMetadataEntry(key string, value string) (err error) {
+	if key == "" {
+		err = errors.New("Metadata key is empty")
+		return
+	}
+
+	if !utf8.ValidString(key) {
+		err = fmt.Errorf("Metadata key %q is not valid UTF-8", key)
+		return
+	}
+
+	if !utf8.ValidString(value) {
+		err = fmt.Errorf("Metadata value for key %q is not valid UTF-8", key)
+		return
+	}
+
+	return
+}
+
 // Create the JSON for an "object resource", for use as an Objects.insert body.
 func (b *bucket) makeCreateObjectBody(
 	req *CreateObjectRequest) (body []byte, err error) {
+	for k, v := range req.Metadata {
+		if err = checkMetadataEntry(k, v); err != nil {
+			return
+		}
+	}
+
 	// Convert to storagev1.Object.
 	rawObject, err := toRawObject(b.Name(), req)
 	if err != nil {
@@ -54,6 +127,8 @@ func (b *bucket) makeCreateObjectBody(
 func (b *bucket) startResumableUpload(
 	ctx context.Context,
 	req *CreateObjectRequest) (uploadURL *url.URL, err error) {
+	defer startSpanWithError(&ctx, &err, "Start resumable upload")()
+
 	// Construct an appropriate URL.
 	//
 	// The documentation (http://goo.gl/IJSlVK) is extremely vague about how this
@@ -65,30 +140,14 @@ func (b *bucket) startResumableUpload(
 	// the bucket name be encoded into a single path segment, as defined by RFC
 	// 3986.
 	bucketSegment := httputil.EncodePathSegment(b.Name())
-	opaque := fmt.Sprintf(
-		"//www.googleapis.com/upload/storage/v1/b/%s/o",
+	path := fmt.Sprintf(
+		"/upload/storage/v1/b/%s/o",
 		bucketSegment)
 
-	query := make(url.Values)
-	query.Set("projection", "full")
+	query := b.createObjectQuery(req)
 	query.Set("uploadType", "resumable")
 
-	if req.GenerationPrecondition != nil {
-		query.Set("ifGenerationMatch", fmt.Sprint(*req.GenerationPrecondition))
-	}
-
-	if req.MetaGenerationPrecondition != nil {
-		query.Set(
-			"ifMetagenerationMatch",
-			fmt.Sprint(*req.MetaGenerationPrecondition))
-	}
-
-	url := &url.URL{
-		Scheme:   "https",
-		Host:     "www.googleapis.com",
-		Opaque:   opaque,
-		RawQuery: query.Encode(),
-	}
+	url := makeURL(b.uploadEndpoint, path, query)
 
 	// Set up the request body.
 	body, err := b.makeCreateObjectBody(req)
@@ -124,7 +183,7 @@ func (b *bucket) startResumableUpload(
 	defer googleapi.CloseBody(httpRes)
 
 	// Check for HTTP-level errors.
-	if err = googleapi.CheckResponse(httpRes); err != nil {
+	if err = checkResponse(httpRes); err != nil {
 		return
 	}
 
@@ -145,44 +204,750 @@ func (b *bucket) startResumableUpload(
 	return
 }
 
+// Return the query parameters for an upload creating the object described by
+// the supplied request, other than uploadType.
+func (b *bucket) createObjectQuery(req *CreateObjectRequest) (query url.Values) {
+	query = make(url.Values)
+	query.Set("projection", "full")
+
+	if req.GenerationPrecondition != nil {
+		query.Set("ifGenerationMatch", fmt.Sprint(*req.GenerationPrecondition))
+	}
+
+	if req.MetaGenerationPrecondition != nil {
+		query.Set(
+			"ifMetagenerationMatch",
+			fmt.Sprint(*req.MetaGenerationPrecondition))
+	}
+
+	// GCS accepts the key as a parameter rather than in the object resource.
+	if req.KMSKeyName != "" {
+		query.Set("kmsKeyName", req.KMSKeyName)
+	}
+
+	if req.PredefinedACL != "" {
+		query.Set("predefinedAcl", req.PredefinedACL)
+	}
+
+	// If the caller doesn't want the object record, there's no point in GCS
+	// sending it. We still need a response body to tell when an upload is
+	// complete, and enough to check the checksums and delete the object on a
+	// mismatch.
+	if req.DiscardResponse {
+		if req.VerifyChecksums {
+			query.Set("fields", "name,generation,crc32c,md5Hash")
+		} else {
+			query.Set("fields", "name")
+		}
+	}
+
+	b.addCommonParams(query)
+	return
+}
+
 func (b *bucket) CreateObject(
 	ctx context.Context,
 	req *CreateObjectRequest) (o *Object, err error) {
 	// We encode using json.NewEncoder, which is documented to silently transform
 	// invalid UTF-8 (cf. http://goo.gl/3gIUQB). So we can't rely on the server
 	// to detect this for us.
-	if !utf8.ValidString(req.Name) {
-		err = errors.New("Invalid object name: not valid UTF-8")
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	// Compress the contents if requested.
+	req, release, err := compressCreateObjectRequestPooled(ctx, req, b.bufferPool)
+	if err != nil {
+		return
+	}
+
+	defer release()
+
+	// Choose how to send the contents.
+	chunkSize := b.uploadChunkSize
+	if req.ChunkSize != 0 {
+		chunkSize = req.ChunkSize
+	}
+
+	if chunkSize > 0 && chunkSize%uploadChunkGranularity != 0 {
+		err = fmt.Errorf(
+			"ChunkSize must be a multiple of %d",
+			uploadChunkGranularity)
 		return
 	}
 
-	// Start a resumable upload, obtaining an upload URL.
-	uploadURL, err := b.startResumableUpload(ctx, req)
+	// If the contents can be read at arbitrary offsets, we can send them
+	// without buffering.
+	section, err := randomAccessContents(req.Contents)
 	if err != nil {
 		return
 	}
 
-	// Special case: for a few common cases we can explicitly specify a body
-	// length, which may assist the HTTP package. In particular, it works around
-	// https://golang.org/issue/17071 in versions before Go 1.7.2 when the
-	// information is available.
-	contentsLength := int64(-1)
-	switch v := req.Contents.(type) {
-	case *bytes.Buffer:
-		contentsLength = int64(v.Len())
-	case *bytes.Reader:
-		contentsLength = int64(v.Len())
-	case *strings.Reader:
-		contentsLength = int64(v.Len())
+	uploadType, err := b.chooseUploadType(req, section)
+	if err != nil {
+		return
+	}
+
+	// Start a resumable upload if necessary, obtaining an upload URL.
+	var uploadURL *url.URL
+	if uploadType == UploadTypeResumable {
+		uploadURL, err = b.startResumableUpload(ctx, req)
+		if err != nil {
+			return
+		}
+	}
+
+	// If we've been asked to verify checksums, compute them as the contents
+	// stream past.
+	contents := req.Contents
+	var crc32cHash hash.Hash32
+	var md5Hash hash.Hash
+
+	if req.VerifyChecksums {
+		crc32cHash = crc32.New(crc32cTable)
+		md5Hash = md5.New()
+		hashes := io.MultiWriter(crc32cHash, md5Hash)
+
+		// Contents sent straight from a section may be read more than once, so
+		// checksum them in a separate pass.
+		if section != nil {
+			_, err = io.Copy(hashes, io.NewSectionReader(section, 0, section.Size()))
+			if err != nil {
+				err = fmt.Errorf("Reading contents: %v", err)
+				return
+			}
+		} else {
+			contents = io.TeeReader(contents, hashes)
+		}
 	}
 
-	// Set up a follow-up request to the upload URL.
+	// Send the contents.
+	var rawObject *storagev1.Object
+	switch {
+	case uploadType == UploadTypeMedia:
+		rawObject, err = b.uploadMedia(ctx, req, section)
+
+	case uploadType == UploadTypeMultipart:
+		rawObject, err = b.uploadMultipart(ctx, req, section)
+
+	case section != nil:
+		rawObject, err = b.uploadSection(
+			ctx,
+			uploadURL,
+			req.ContentType,
+			section,
+			req.ProgressFunc)
+
+	case chunkSize < 0:
+		rawObject, err = b.uploadStream(
+			ctx,
+			uploadURL,
+			req.ContentType,
+			contents,
+			req.ProgressFunc)
+
+	default:
+		rawObject, err = b.uploadChunks(
+			ctx,
+			uploadURL,
+			req.ContentType,
+			contents,
+			chunkSize,
+			req.ProgressFunc)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if req.DiscardResponse && !req.VerifyChecksums {
+		return
+	}
+
+	// Convert the response.
+	if o, err = toObject(rawObject); err != nil {
+		err = fmt.Errorf("toObject: %v", err)
+		return
+	}
+
+	// Check the checksums if requested.
+	if req.VerifyChecksums {
+		var md5Sum [md5.Size]byte
+		copy(md5Sum[:], md5Hash.Sum(nil))
+
+		err = verifyCreatedObject(ctx, b, o, crc32cHash.Sum32(), md5Sum)
+		if err != nil {
+			o = nil
+			return
+		}
+	}
+
+	if req.DiscardResponse {
+		o = nil
+	}
+
+	return
+}
+
+// Decide how to send the object described by the supplied request, whose
+// contents are the given section if their size is known or nil otherwise.
+func (b *bucket) chooseUploadType(
+	req *CreateObjectRequest,
+	section *io.SectionReader) (uploadType string, err error) {
+	switch req.UploadType {
+	case UploadTypeResumable:
+		uploadType = req.UploadType
+
+	case UploadTypeMultipart, UploadTypeMedia:
+		if section == nil {
+			err = fmt.Errorf(
+				"UploadType %q requires contents of known size",
+				req.UploadType)
+			return
+		}
+
+		if req.UploadType == UploadTypeMedia && !mediaUploadable(req) {
+			err = errors.New(
+				"UploadType \"media\" can't send metadata other than a content type")
+			return
+		}
+
+		uploadType = req.UploadType
+
+	// Small contents of known size can be sent in a single request, saving the
+	// round trip that starts a resumable upload session. Prefer the smaller
+	// media upload if there's no metadata that would need a multipart one.
+	case "":
+		switch {
+		case section == nil ||
+			b.multipartUploadThreshold <= 0 ||
+			section.Size() > b.multipartUploadThreshold:
+			uploadType = UploadTypeResumable
+
+		case mediaUploadable(req):
+			uploadType = UploadTypeMedia
+
+		default:
+			uploadType = UploadTypeMultipart
+		}
+
+	default:
+		err = fmt.Errorf("Unknown UploadType: %q", req.UploadType)
+		return
+	}
+
+	return
+}
+
+// Contents that can be read at arbitrary offsets and whose length is known,
+// such as *bytes.Reader and *io.SectionReader.
+type sizedReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// If the supplied object contents can be read at arbitrary offsets and their
+// length is known, return them in that form, ignoring the current offset.
+// Otherwise return nil. Bucket wrappers that wrap CreateObjectRequest.Contents
+// use this to preserve these abilities.
+func sizedContents(contents io.Reader) (ra sizedReaderAt, err error) {
+	switch typed := contents.(type) {
+	case sizedReaderAt:
+		ra = typed
+
+	// Only regular files have a meaningful size. Pipes and the like must be
+	// streamed.
+	case *os.File:
+		var fi os.FileInfo
+		fi, err = typed.Stat()
+		if err != nil {
+			err = fmt.Errorf("Stat: %v", err)
+			return
+		}
+
+		if fi.Mode().IsRegular() {
+			ra = io.NewSectionReader(typed, 0, fi.Size())
+		}
+	}
+
+	return
+}
+
+// If the supplied object contents can be read at arbitrary offsets and their
+// length is known, return a section covering them from the current offset to
+// the end. Otherwise return nil.
+func randomAccessContents(contents io.Reader) (section *io.SectionReader, err error) {
+	ra, err := sizedContents(contents)
+	if ra == nil || err != nil {
+		return
+	}
+
+	size := ra.Size()
+
+	// The contents start wherever the reader is positioned.
+	var start int64
+	if seeker, ok := contents.(io.Seeker); ok {
+		start, err = seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			err = fmt.Errorf("Seek: %v", err)
+			return
+		}
+	}
+
+	if start > size {
+		start = size
+	}
+
+	section = io.NewSectionReader(ra, start, size-start)
+	return
+}
+
+// Send the supplied contents to the resumable upload session with the given
+// URL, in chunks of chunkSize bytes. Return the object record that GCS
+// responds with once the final chunk has been committed.
+//
+// If progress is non-nil, it is called with the offset reached each time the
+// HTTP transport consumes more of a chunk.
+//
+// Only one chunk is buffered at a time, so the contents are never read twice.
+// See the protocol documentation here:
+//
+//     https://cloud.google.com/storage/docs/json_api/v1/how-tos/resumable-upload
+//
+func (b *bucket) uploadChunks(
+	ctx context.Context,
+	uploadURL *url.URL,
+	contentType string,
+	contents io.Reader,
+	chunkSize int,
+	progress func(int64)) (rawObject *storagev1.Object, err error) {
+	defer startSpanWithError(&ctx, &err, "Upload chunks")()
+
+	// If we're cancelled part way through, tell GCS to discard what it has
+	// received rather than leaving the session around until it expires.
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			b.abortUpload(uploadURL)
+		}
+	}()
+
+	buf, err := b.bufferPool.Get(ctx, chunkSize)
+	if err != nil {
+		err = fmt.Errorf("BufferPool.Get: %v", err)
+		return
+	}
+
+	defer b.bufferPool.Put(buf)
+
+	var offset int64
+
+	for {
+		// Fill the buffer with the next chunk. A short read means that this is
+		// the final chunk, and we now know the total length. If the contents
+		// happen to be a multiple of the chunk size, the final chunk is empty.
+		var n int
+		n, err = io.ReadFull(contents, buf)
+
+		final := false
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			final = true
+			err = nil
+
+		default:
+			err = fmt.Errorf("Reading contents: %v", err)
+			return
+		}
+
+		// Send the chunk, resuming as necessary until GCS has all of it.
+		rawObject, err = b.sendChunk(
+			ctx,
+			uploadURL,
+			contentType,
+			io.NewSectionReader(bytes.NewReader(buf[:n]), 0, int64(n)),
+			offset,
+			final,
+			progress)
+
+		if err != nil {
+			return
+		}
+
+		offset += int64(n)
+
+		// Are we done?
+		if final {
+			if rawObject == nil {
+				err = errors.New("Upload not complete after sending final chunk.")
+			}
+
+			return
+		}
+
+		if rawObject != nil {
+			err = fmt.Errorf(
+				"Upload unexpectedly complete after %d bytes of contents.",
+				offset)
+			return
+		}
+	}
+}
+
+// Send the contents of the supplied section to the resumable upload session
+// with the given URL, reading them straight from the section rather than
+// buffering them. Since any part of the contents can be read again, an upload
+// interrupted part way through resumes from wherever GCS got to. progress is
+// as with uploadChunks.
+func (b *bucket) uploadSection(
+	ctx context.Context,
+	uploadURL *url.URL,
+	contentType string,
+	section *io.SectionReader,
+	progress func(int64)) (rawObject *storagev1.Object, err error) {
+	defer startSpanWithError(&ctx, &err, "Upload section")()
+
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			b.abortUpload(uploadURL)
+		}
+	}()
+
+	// The whole section is a single final chunk.
+	rawObject, err = b.sendChunk(
+		ctx,
+		uploadURL,
+		contentType,
+		section,
+		0,
+		true,
+		progress)
+
+	if err != nil {
+		return
+	}
+
+	if rawObject == nil {
+		err = errors.New("Upload not complete after sending contents.")
+		return
+	}
+
+	return
+}
+
+// Send the supplied contents to the resumable upload session with the given
+// URL in a single request, using chunked transfer encoding so that nothing is
+// buffered and the length needn't be known up front. progress is as with
+// uploadChunks.
+//
+// Because the contents can't be replayed, a failure part way through can't be
+// resumed. In that case the session is discarded.
+func (b *bucket) uploadStream(
+	ctx context.Context,
+	uploadURL *url.URL,
+	contentType string,
+	contents io.Reader,
+	progress func(int64)) (rawObject *storagev1.Object, err error) {
+	defer startSpanWithError(&ctx, &err, "Upload stream")()
+
+	defer func() {
+		if err != nil {
+			b.abortUpload(uploadURL)
+		}
+	}()
+
+	if progress != nil {
+		contents = &progressReader{
+			wrapped:  contents,
+			progress: progress,
+		}
+	}
+
+	// Create the HTTP request. Leaving out Content-Range tells GCS that this
+	// request carries the entire object.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"PUT",
+		uploadURL,
+		ioutil.NopCloser(contents),
+		-1,
+		b.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", contentType)
+
+	// Execute the request.
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	rawObject, _, err = parseUploadResponse(httpRes)
+	if err != nil {
+		return
+	}
+
+	if rawObject == nil {
+		err = errors.New("Upload not complete after streaming contents.")
+		return
+	}
+
+	return
+}
+
+// Ask GCS to discard the resumable upload session with the given URL. This is
+// best effort: abandoned sessions expire on their own eventually, so errors are
+// ignored.
+func (b *bucket) abortUpload(uploadURL *url.URL) {
+	// The caller's context has been cancelled, so use a fresh one.
+	ctx, cancel := context.WithTimeout(context.Background(), abortUploadTimeout)
+	defer cancel()
+
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"DELETE",
+		uploadURL,
+		nil,
+		0,
+		b.userAgent)
+
+	if err != nil {
+		return
+	}
+
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	googleapi.CloseBody(httpRes)
+}
+
+// Send a single chunk of a resumable upload, whose first byte lies at the
+// given offset within the object contents. Return the object record if GCS
+// considers the upload complete.
+//
+// If sending fails with an error that appears to be transient, ask GCS how
+// much of the upload it has committed and send the remainder of the chunk from
+// there, giving up after maxUploadChunkAttempts consecutive attempts that
+// fail to make progress.
+func (b *bucket) sendChunk(
+	ctx context.Context,
+	uploadURL *url.URL,
+	contentType string,
+	chunk *io.SectionReader,
+	start int64,
+	final bool,
+	progress func(int64)) (rawObject *storagev1.Object, err error) {
+	end := start + chunk.Size()
+
+	total := int64(-1)
+	if final {
+		total = end
+	}
+
+	committed := start
+	var failures uint
+
+	for {
+		// If the previous attempt failed, we don't know how much of what we sent
+		// made it. Ask before sending more.
+		if failures > 0 {
+			rawObject, committed, err = b.queryUploadStatus(ctx, uploadURL, total)
+		}
+
+		// Send whatever GCS doesn't yet have. We can't go back to earlier
+		// chunks, since we no longer have their contents.
+		prevCommitted := committed
+		if err == nil && rawObject == nil {
+			if committed < start || committed > end {
+				err = fmt.Errorf(
+					"GCS reports %d bytes committed, outside of chunk [%d, %d]",
+					committed,
+					start,
+					end)
+				return
+			}
+
+			rawObject, committed, err = b.putChunk(
+				ctx,
+				uploadURL,
+				contentType,
+				io.NewSectionReader(chunk, committed-start, end-committed),
+				committed,
+				total,
+				progress)
+		}
+
+		if err == nil {
+			// Stop if GCS considers the upload complete, or has everything in this
+			// (non-final) chunk.
+			if rawObject != nil || (committed == end && !final) {
+				return
+			}
+
+			// GCS may accept only a prefix of what we sent. In that case go around
+			// again to send the rest, as long as we're making progress.
+			if committed > prevCommitted {
+				failures = 0
+				continue
+			}
+
+			err = fmt.Errorf("No progress made at offset %d", committed)
+		} else if !shouldRetry(err) {
+			return
+		}
+
+		// Give up if we've tried too many times.
+		failures++
+		if failures >= maxUploadChunkAttempts {
+			err = fmt.Errorf(
+				"Giving up on chunk at offset %d after %d attempts: %v",
+				start,
+				failures,
+				err)
+			return
+		}
+
+		// Back off before asking GCS where the upload got to.
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+
+		case <-time.After(chooseDelay(uploadChunkRetryPolicy, failures-1)):
+		}
+
+		err = nil
+	}
+}
+
+// Make a single request to a resumable upload session, sending the supplied
+// data as the bytes starting at the given offset. total is the total length
+// of the object contents, or -1 if not yet known. progress is as with
+// uploadChunks.
+func (b *bucket) putChunk(
+	ctx context.Context,
+	uploadURL *url.URL,
+	contentType string,
+	data *io.SectionReader,
+	offset int64,
+	total int64,
+	progress func(int64)) (
+	rawObject *storagev1.Object,
+	committed int64,
+	err error) {
+	desc := fmt.Sprintf("PUT chunk: offset %d, %d bytes", offset, data.Size())
+	defer startSpanWithError(&ctx, &err, desc)()
+
+	// Describe the range we're sending. Content-Range can't express an empty
+	// range, so in that case we can only be finalizing the upload.
+	totalStr := "*"
+	if total >= 0 {
+		totalStr = fmt.Sprint(total)
+	}
+
+	var contentRange string
+	if data.Size() == 0 {
+		contentRange = fmt.Sprintf("bytes */%s", totalStr)
+	} else {
+		contentRange = fmt.Sprintf(
+			"bytes %d-%d/%s",
+			offset,
+			offset+data.Size()-1,
+			totalStr)
+	}
+
+	// Create the HTTP request.
+	var body io.ReadCloser
+	if data.Size() != 0 {
+		var r io.Reader = data
+		if progress != nil {
+			r = &progressReader{
+				wrapped:  r,
+				offset:   offset,
+				progress: progress,
+			}
+		}
+
+		body = ioutil.NopCloser(r)
+	}
+
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"PUT",
+		uploadURL,
+		body,
+		data.Size(),
+		b.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("Content-Range", contentRange)
+
+	// Execute the request.
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	rawObject, committed, err = parseUploadResponse(httpRes)
+	return
+}
+
+// A reader that reports the offset within the object contents reached after
+// each read.
+type progressReader struct {
+	wrapped  io.Reader
+	offset   int64
+	progress func(int64)
+}
+
+func (pr *progressReader) Read(p []byte) (n int, err error) {
+	n, err = pr.wrapped.Read(p)
+	if n > 0 {
+		pr.offset += int64(n)
+		pr.progress(pr.offset)
+	}
+
+	return
+}
+
+// Ask a resumable upload session how many bytes it has committed. total is as
+// with putChunk.
+func (b *bucket) queryUploadStatus(
+	ctx context.Context,
+	uploadURL *url.URL,
+	total int64) (
+	rawObject *storagev1.Object,
+	committed int64,
+	err error) {
+	defer startSpanWithError(&ctx, &err, "Query upload status")()
+
+	totalStr := "*"
+	if total >= 0 {
+		totalStr = fmt.Sprint(total)
+	}
+
+	// Create the HTTP request.
 	httpReq, err := httputil.NewRequest(
 		ctx,
 		"PUT",
 		uploadURL,
-		ioutil.NopCloser(req.Contents),
-		contentsLength,
+		nil,
+		0,
 		b.userAgent)
 
 	if err != nil {
@@ -190,7 +955,7 @@ func (b *bucket) CreateObject(
 		return
 	}
 
-	httpReq.Header.Set("Content-Type", req.ContentType)
+	httpReq.Header.Set("Content-Range", fmt.Sprintf("bytes */%s", totalStr))
 
 	// Execute the request.
 	httpRes, err := b.client.Do(httpReq)
@@ -200,8 +965,44 @@ func (b *bucket) CreateObject(
 
 	defer googleapi.CloseBody(httpRes)
 
+	rawObject, committed, err = parseUploadResponse(httpRes)
+	return
+}
+
+// Interpret a response from a resumable upload session. If the upload is
+// complete, return the object record. Otherwise return the number of bytes
+// committed so far, as described by the Range header of a 308 response.
+func parseUploadResponse(
+	httpRes *http.Response) (
+	rawObject *storagev1.Object,
+	committed int64,
+	err error) {
+	// Special case: the upload is incomplete. GCS uses 308 "Resume Incomplete"
+	// for this, with a Range header of the form "bytes=0-N" if it has received
+	// anything.
+	if httpRes.StatusCode == http.StatusPermanentRedirect {
+		r := httpRes.Header.Get("Range")
+		if r == "" {
+			return
+		}
+
+		var first, last int64
+		if _, err = fmt.Sscanf(r, "bytes=%d-%d", &first, &last); err != nil {
+			err = fmt.Errorf("Parsing Range header %q: %v", r, err)
+			return
+		}
+
+		if first != 0 {
+			err = fmt.Errorf("Unexpected Range header: %q", r)
+			return
+		}
+
+		committed = last + 1
+		return
+	}
+
 	// Check for HTTP-level errors.
-	if err = googleapi.CheckResponse(httpRes); err != nil {
+	if err = checkResponse(httpRes); err != nil {
 		// Special case: handle precondition errors.
 		if typed, ok := err.(*googleapi.Error); ok {
 			if typed.Code == http.StatusPreconditionFailed {
@@ -213,16 +1014,56 @@ func (b *bucket) CreateObject(
 	}
 
 	// Parse the response.
-	var rawObject *storagev1.Object
-	if err = json.NewDecoder(httpRes.Body).Decode(&rawObject); err != nil {
+	if err = decodeResponse(httpRes, &rawObject); err != nil {
 		return
 	}
 
-	// Convert the response.
-	if o, err = toObject(rawObject); err != nil {
-		err = fmt.Errorf("toObject: %v", err)
+	return
+}
+
+// Check that the supplied record for a newly created object matches the
+// checksums computed for the contents we sent. If not, delete the object from
+// the supplied bucket and return an error of type *ChecksumMismatchError.
+func verifyCreatedObject(
+	ctx context.Context,
+	b Bucket,
+	o *Object,
+	crc32c uint32,
+	md5Sum [md5.Size]byte) (err error) {
+	var mismatch error
+	switch {
+	case o.CRC32C != crc32c:
+		mismatch = fmt.Errorf(
+			"CRC32C mismatch: sent 0x%08x, GCS received 0x%08x",
+			crc32c,
+			o.CRC32C)
+
+	case o.MD5 != nil && *o.MD5 != md5Sum:
+		mismatch = fmt.Errorf(
+			"MD5 mismatch: sent %s, GCS received %s",
+			hex.EncodeToString(md5Sum[:]),
+			hex.EncodeToString(o.MD5[:]))
+	}
+
+	if mismatch == nil {
 		return
 	}
 
+	// Don't leave the corrupted generation lying around.
+	deleteErr := b.DeleteObject(
+		ctx,
+		&DeleteObjectRequest{
+			Name:       o.Name,
+			Generation: o.Generation,
+		})
+
+	if deleteErr != nil {
+		mismatch = fmt.Errorf(
+			"%v (and deleting the object failed: %v)",
+			mismatch,
+			deleteErr)
+	}
+
+	err = &ChecksumMismatchError{Err: mismatch}
 	return
 }
@@ -0,0 +1,608 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+func TestCreateObject(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Fake upload session
+////////////////////////////////////////////////////////////////////////
+
+// An HTTP handler that behaves like a GCS resumable upload session, except
+// that it can be told to drop connections after committing only part of what
+// it was sent.
+type fakeUploadSession struct {
+	mu sync.Mutex
+
+	// The contents committed so far.
+	//
+	// GUARDED_BY(mu)
+	contents []byte
+
+	// The indices of requests (counting from zero) after which to drop the
+	// connection, and how many of the bytes sent in each to commit first.
+	//
+	// GUARDED_BY(mu)
+	dropAfter map[int]int
+
+	// GUARDED_BY(mu)
+	requestCount int
+
+	// Set when the session is cancelled with a DELETE request.
+	//
+	// GUARDED_BY(mu)
+	aborted bool
+
+	// Set when a request arrives using chunked transfer encoding.
+	//
+	// GUARDED_BY(mu)
+	chunked bool
+}
+
+func (s *fakeUploadSession) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requestIndex := s.requestCount
+	s.requestCount++
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	// Handle cancellation, to which GCS responds with HTTP 499.
+	if r.Method == "DELETE" {
+		s.aborted = true
+		w.WriteHeader(499)
+		return
+	}
+
+	for _, te := range r.TransferEncoding {
+		if te == "chunked" {
+			s.chunked = true
+		}
+	}
+
+	// A request without a Content-Range header carries the entire object.
+	contentRange := r.Header.Get("Content-Range")
+	if contentRange == "" {
+		contentRange = fmt.Sprintf("bytes 0-%d/%d", len(body)-1, len(body))
+	}
+
+	// Parse the Content-Range header.
+	var first int
+	var rangeStr, totalStr string
+	if _, err := fmt.Sscanf(
+		contentRange,
+		"bytes %s",
+		&rangeStr); err != nil {
+		panic(err)
+	}
+
+	i := strings.Index(rangeStr, "/")
+	rangeStr, totalStr = rangeStr[:i], rangeStr[i+1:]
+
+	if rangeStr != "*" {
+		if _, err := fmt.Sscanf(rangeStr, "%d-", &first); err != nil {
+			panic(err)
+		}
+
+		if first != len(s.contents) {
+			http.Error(w, "non-contiguous write", http.StatusBadRequest)
+			return
+		}
+
+		// Commit all or part of the data.
+		n := len(body)
+		commitCount, drop := s.dropAfter[requestIndex]
+		if drop {
+			n = commitCount
+		}
+
+		s.contents = append(s.contents, body[:n]...)
+
+		if drop {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				panic(err)
+			}
+
+			conn.Close()
+			return
+		}
+	}
+
+	// Are we done?
+	if totalStr != "*" && totalStr == fmt.Sprint(len(s.contents)) {
+		json.NewEncoder(w).Encode(&storagev1.Object{Name: "foo"})
+		return
+	}
+
+	if len(s.contents) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.contents)-1))
+	}
+
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+// A reader that cancels a context once more than limit bytes have been
+// requested from it.
+type cancellingReader struct {
+	wrapped io.Reader
+	limit   int
+	cancel  context.CancelFunc
+
+	requested int
+}
+
+func (r *cancellingReader) Read(p []byte) (n int, err error) {
+	r.requested += len(p)
+	if r.requested > r.limit {
+		r.cancel()
+	}
+
+	n, err = r.wrapped.Read(p)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+const uploadTestChunkSize = 4
+
+type UploadChunksTest struct {
+	ctx     context.Context
+	session fakeUploadSession
+	server  *httptest.Server
+	bucket  *bucket
+
+	oldPolicy RetryPolicy
+}
+
+var _ SetUpInterface = &UploadChunksTest{}
+var _ TearDownInterface = &UploadChunksTest{}
+
+func init() { RegisterTestSuite(&UploadChunksTest{}) }
+
+func (t *UploadChunksTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.session.dropAfter = make(map[int]int)
+	t.server = httptest.NewServer(&t.session)
+
+	t.bucket = &bucket{
+		client:          http.DefaultClient,
+		userAgent:       "test",
+		name:            "some_bucket",
+		uploadChunkSize: uploadTestChunkSize,
+		bufferPool:      NewBufferPool(0),
+	}
+
+	// Don't wait around between attempts.
+	t.oldPolicy = uploadChunkRetryPolicy
+	uploadChunkRetryPolicy = RetryPolicy{
+		InitialDelay: time.Millisecond,
+		MaxDelay:     time.Millisecond,
+	}
+}
+
+func (t *UploadChunksTest) TearDown() {
+	uploadChunkRetryPolicy = t.oldPolicy
+	t.server.Close()
+}
+
+func (t *UploadChunksTest) upload(
+	contents string) (rawObject *storagev1.Object, err error) {
+	u, err := url.Parse(t.server.URL)
+	AssertEq(nil, err)
+
+	rawObject, err = t.bucket.uploadChunks(
+		t.ctx,
+		u,
+		"text/plain",
+		strings.NewReader(contents),
+		uploadTestChunkSize,
+		nil)
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *UploadChunksTest) Empty() {
+	o, err := t.upload("")
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq("", string(t.session.contents))
+}
+
+func (t *UploadChunksTest) LessThanOneChunk() {
+	o, err := t.upload("ta")
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq("ta", string(t.session.contents))
+	ExpectEq(1, t.session.requestCount)
+}
+
+func (t *UploadChunksTest) MultipleOfChunkSize() {
+	o, err := t.upload("tacoburr")
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq("tacoburr", string(t.session.contents))
+	ExpectEq(3, t.session.requestCount)
+}
+
+func (t *UploadChunksTest) ChunkBufferIsReturnedToPool() {
+	_, err := t.upload("tacoburrito")
+
+	AssertEq(nil, err)
+	ExpectEq(0, t.bucket.bufferPool.InUse())
+}
+
+func (t *UploadChunksTest) ResumesAfterDroppedConnection() {
+	// Commit half of the second chunk, then drop the connection.
+	t.session.dropAfter[1] = 2
+
+	o, err := t.upload("tacoburrito")
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq("tacoburrito", string(t.session.contents))
+}
+
+func (t *UploadChunksTest) ResumesAfterDroppedConnectionInFinalChunk() {
+	t.session.dropAfter[2] = 1
+
+	o, err := t.upload("tacoburrito")
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq("tacoburrito", string(t.session.contents))
+}
+
+func (t *UploadChunksTest) ReportsProgress() {
+	u, err := url.Parse(t.server.URL)
+	AssertEq(nil, err)
+
+	var reported []int64
+	progress := func(n int64) {
+		reported = append(reported, n)
+	}
+
+	_, err = t.bucket.uploadChunks(
+		t.ctx,
+		u,
+		"text/plain",
+		strings.NewReader("tacoburrito"),
+		uploadTestChunkSize,
+		progress)
+
+	AssertEq(nil, err)
+	AssertNe(0, len(reported))
+
+	for i := 1; i < len(reported); i++ {
+		ExpectLe(reported[i-1], reported[i])
+	}
+
+	ExpectEq(len("tacoburrito"), reported[len(reported)-1])
+}
+
+func (t *UploadChunksTest) CancelledMidUpload() {
+	ctx, cancel := context.WithCancel(t.ctx)
+	defer cancel()
+
+	u, err := url.Parse(t.server.URL)
+	AssertEq(nil, err)
+
+	// Cancel once the first chunk has been read.
+	contents := &cancellingReader{
+		wrapped: strings.NewReader("tacoburrito"),
+		limit:   uploadTestChunkSize,
+		cancel:  cancel,
+	}
+
+	_, err = t.bucket.uploadChunks(
+		ctx,
+		u,
+		"text/plain",
+		contents,
+		uploadTestChunkSize,
+		nil)
+
+	ExpectNe(nil, err)
+	ExpectTrue(t.session.aborted)
+	ExpectEq("taco", string(t.session.contents))
+}
+
+func (t *UploadChunksTest) GivesUpWithoutProgress() {
+	for i := 0; i < 100; i++ {
+		t.session.dropAfter[i] = 0
+	}
+
+	_, err := t.upload("tacoburrito")
+
+	ExpectThat(err, Error(HasSubstr("Giving up")))
+	ExpectThat(err, Error(HasSubstr("offset 0")))
+}
+
+func (t *UploadChunksTest) Streamed() {
+	u, err := url.Parse(t.server.URL)
+	AssertEq(nil, err)
+
+	var reported []int64
+	progress := func(n int64) {
+		reported = append(reported, n)
+	}
+
+	o, err := t.bucket.uploadStream(
+		t.ctx,
+		u,
+		"text/plain",
+		strings.NewReader("tacoburrito"),
+		progress)
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq("tacoburrito", string(t.session.contents))
+	ExpectEq(1, t.session.requestCount)
+	ExpectTrue(t.session.chunked)
+
+	AssertNe(0, len(reported))
+	ExpectEq(len("tacoburrito"), reported[len(reported)-1])
+}
+
+func (t *UploadChunksTest) StreamedFailureAborts() {
+	t.session.dropAfter[0] = 2
+
+	u, err := url.Parse(t.server.URL)
+	AssertEq(nil, err)
+
+	_, err = t.bucket.uploadStream(
+		t.ctx,
+		u,
+		"text/plain",
+		strings.NewReader("tacoburrito"),
+		nil)
+
+	ExpectNe(nil, err)
+	ExpectTrue(t.session.aborted)
+	ExpectEq(2, t.session.requestCount)
+}
+
+func (t *UploadChunksTest) SectionInOneRequest() {
+	u, err := url.Parse(t.server.URL)
+	AssertEq(nil, err)
+
+	section := io.NewSectionReader(strings.NewReader("tacoburrito"), 0, 11)
+	o, err := t.bucket.uploadSection(t.ctx, u, "text/plain", section, nil)
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq("tacoburrito", string(t.session.contents))
+	ExpectEq(1, t.session.requestCount)
+	ExpectFalse(t.session.chunked)
+}
+
+func (t *UploadChunksTest) SectionResumesFromAnyOffset() {
+	// Commit part of the contents, then drop the connection. The rest should
+	// be sent from where GCS got to, which isn't on a chunk boundary.
+	t.session.dropAfter[0] = 5
+
+	u, err := url.Parse(t.server.URL)
+	AssertEq(nil, err)
+
+	section := io.NewSectionReader(strings.NewReader("tacoburrito"), 0, 11)
+	o, err := t.bucket.uploadSection(t.ctx, u, "text/plain", section, nil)
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq("tacoburrito", string(t.session.contents))
+
+	// Upload, status query, upload of the remainder.
+	ExpectEq(3, t.session.requestCount)
+}
+
+////////////////////////////////////////////////////////////////////////
+// randomAccessContents
+////////////////////////////////////////////////////////////////////////
+
+// A bucket whose CreateObject reads the contents as the JSON bucket would,
+// recording whether it could do so with randomAccessContents.
+type sectionReadingBucket struct {
+	Bucket
+	randomAccess bool
+	contents     string
+}
+
+func (b *sectionReadingBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	section, err := randomAccessContents(req.Contents)
+	if err != nil {
+		return
+	}
+
+	r := req.Contents
+	if section != nil {
+		b.randomAccess = true
+		r = section
+	}
+
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	b.contents = string(contents)
+	o = &Object{Name: req.Name}
+	return
+}
+
+type RandomAccessContentsTest struct {
+}
+
+func init() { RegisterTestSuite(&RandomAccessContentsTest{}) }
+
+func (t *RandomAccessContentsTest) readSection(
+	contents io.Reader) (s string, ok bool) {
+	section, err := randomAccessContents(contents)
+	AssertEq(nil, err)
+
+	if section == nil {
+		return
+	}
+
+	ok = true
+	b, err := ioutil.ReadAll(section)
+	AssertEq(nil, err)
+	s = string(b)
+
+	return
+}
+
+func (t *RandomAccessContentsTest) PlainReader() {
+	_, ok := t.readSection(iotest.OneByteReader(strings.NewReader("taco")))
+	ExpectFalse(ok)
+}
+
+func (t *RandomAccessContentsTest) PartiallyReadBytesReader() {
+	r := bytes.NewReader([]byte("tacoburrito"))
+	_, err := io.ReadFull(r, make([]byte, 4))
+	AssertEq(nil, err)
+
+	s, ok := t.readSection(r)
+	AssertTrue(ok)
+	ExpectEq("burrito", s)
+}
+
+func (t *RandomAccessContentsTest) File() {
+	f, err := ioutil.TempFile("", "create_object_test")
+	AssertEq(nil, err)
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = f.WriteString("tacoburrito")
+	AssertEq(nil, err)
+
+	_, err = f.Seek(4, io.SeekStart)
+	AssertEq(nil, err)
+
+	s, ok := t.readSection(f)
+	AssertTrue(ok)
+	ExpectEq("burrito", s)
+
+	// The file's offset should be unaffected.
+	offset, err := f.Seek(0, io.SeekCurrent)
+	AssertEq(nil, err)
+	ExpectEq(4, offset)
+}
+
+func (t *RandomAccessContentsTest) ThroughTracingBucket() {
+	wrapped := &sectionReadingBucket{}
+
+	var trace *OperationTrace
+	b := NewTracingBucket(wrapped, func(ot *OperationTrace) { trace = ot })
+
+	r := bytes.NewReader([]byte("tacoburrito"))
+	_, err := io.ReadFull(r, make([]byte, 4))
+	AssertEq(nil, err)
+
+	_, err = b.CreateObject(
+		context.Background(),
+		&CreateObjectRequest{Name: "foo", Contents: r})
+
+	AssertEq(nil, err)
+	ExpectTrue(wrapped.randomAccess)
+	ExpectEq("burrito", wrapped.contents)
+
+	AssertNe(nil, trace)
+	ExpectEq(len("burrito"), trace.Bytes)
+}
+
+func (t *RandomAccessContentsTest) ThroughThrottledBucket() {
+	f, err := ioutil.TempFile("", "create_object_test")
+	AssertEq(nil, err)
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = f.WriteString("tacoburrito")
+	AssertEq(nil, err)
+
+	_, err = f.Seek(4, io.SeekStart)
+	AssertEq(nil, err)
+
+	wrapped := &sectionReadingBucket{}
+	throttle := &recordingThrottle{capacity: 3}
+	b := NewThrottledBucket(wrapped, nil, throttle, nil)
+
+	_, err = b.CreateObject(
+		context.Background(),
+		&CreateObjectRequest{Name: "foo", Contents: f})
+
+	AssertEq(nil, err)
+	ExpectTrue(wrapped.randomAccess)
+	ExpectEq("burrito", wrapped.contents)
+
+	// Everything read was paid for, in pieces no larger than the capacity.
+	var total uint64
+	for _, w := range throttle.waits {
+		ExpectLe(w, 3)
+		total += w
+	}
+
+	ExpectGe(total, len("burrito"))
+}
+
+func (t *RandomAccessContentsTest) Pipe() {
+	r, w, err := os.Pipe()
+	AssertEq(nil, err)
+
+	defer r.Close()
+	defer w.Close()
+
+	_, ok := t.readSection(r)
+	ExpectFalse(ok)
+}
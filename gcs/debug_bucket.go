@@ -60,11 +60,12 @@ func (b *debugBucket) requestLogf(
 }
 
 func (b *debugBucket) startRequest(
+	ctx context.Context,
 	format string,
 	v ...interface{}) (id uint64, desc string, start time.Time) {
 	start = time.Now()
 	id = b.mintRequestID()
-	desc = fmt.Sprintf(format, v...)
+	desc = annotateRequestID(ctx, fmt.Sprintf(format, v...))
 
 	b.requestLogf(id, "<- %s", desc)
 	return
@@ -147,7 +148,7 @@ func (b *debugBucket) MoveObject(
 func (b *debugBucket) NewReader(
 	ctx context.Context,
 	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
-	id, desc, start := b.startRequest("Read(%q, %v)", req.Name, req.Range)
+	id, desc, start := b.startRequest(ctx, "Read(%q, %v)", req.Name, req.Range)
 
 	// Call through.
 	rc, err = b.wrapped.NewReader(ctx, req)
@@ -171,7 +172,7 @@ func (b *debugBucket) NewReader(
 func (b *debugBucket) CreateObject(
 	ctx context.Context,
 	req *CreateObjectRequest) (o *Object, err error) {
-	id, desc, start := b.startRequest("CreateObject(%q)", req.Name)
+	id, desc, start := b.startRequest(ctx, "CreateObject(%q)", req.Name)
 	defer b.finishRequest(id, desc, start, &err)
 
 	o, err = b.wrapped.CreateObject(ctx, req)
@@ -182,6 +183,7 @@ func (b *debugBucket) CopyObject(
 	ctx context.Context,
 	req *CopyObjectRequest) (o *Object, err error) {
 	id, desc, start := b.startRequest(
+		ctx,
 		"CopyObject(%q, %q)",
 		req.SrcName,
 		req.DstName)
@@ -196,6 +198,7 @@ func (b *debugBucket) ComposeObjects(
 	ctx context.Context,
 	req *ComposeObjectsRequest) (o *Object, err error) {
 	id, desc, start := b.startRequest(
+		ctx,
 		"ComposeObjects(%q)",
 		req.DstName)
 
@@ -208,7 +211,7 @@ func (b *debugBucket) ComposeObjects(
 func (b *debugBucket) StatObject(
 	ctx context.Context,
 	req *StatObjectRequest) (o *Object, err error) {
-	id, desc, start := b.startRequest("StatObject(%q)", req.Name)
+	id, desc, start := b.startRequest(ctx, "StatObject(%q)", req.Name)
 	defer b.finishRequest(id, desc, start, &err)
 
 	o, err = b.wrapped.StatObject(ctx, req)
@@ -218,7 +221,7 @@ func (b *debugBucket) StatObject(
 func (b *debugBucket) ListObjects(
 	ctx context.Context,
 	req *ListObjectsRequest) (listing *Listing, err error) {
-	id, desc, start := b.startRequest("ListObjects()")
+	id, desc, start := b.startRequest(ctx, "ListObjects()")
 	defer b.finishRequest(id, desc, start, &err)
 
 	listing, err = b.wrapped.ListObjects(ctx, req)
@@ -228,7 +231,7 @@ func (b *debugBucket) ListObjects(
 func (b *debugBucket) UpdateObject(
 	ctx context.Context,
 	req *UpdateObjectRequest) (o *Object, err error) {
-	id, desc, start := b.startRequest("UpdateObject(%q)", req.Name)
+	id, desc, start := b.startRequest(ctx, "UpdateObject(%q)", req.Name)
 	defer b.finishRequest(id, desc, start, &err)
 
 	o, err = b.wrapped.UpdateObject(ctx, req)
@@ -238,9 +241,106 @@ func (b *debugBucket) UpdateObject(
 func (b *debugBucket) DeleteObject(
 	ctx context.Context,
 	req *DeleteObjectRequest) (err error) {
-	id, desc, start := b.startRequest("DeleteObject(%q)", req.Name)
+	id, desc, start := b.startRequest(
+		ctx,
+		"DeleteObject(%q, %d)",
+		req.Name,
+		req.Generation)
+
 	defer b.finishRequest(id, desc, start, &err)
 
 	err = b.wrapped.DeleteObject(ctx, req)
 	return
 }
+
+func (b *debugBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	id, desc, start := b.startRequest(
+		ctx,
+		"RestoreObject(%q, %d)",
+		req.Name,
+		req.Generation)
+
+	defer b.finishRequest(id, desc, start, &err)
+
+	o, err = b.wrapped.RestoreObject(ctx, req)
+	return
+}
+
+func (b *debugBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	id, desc, start := b.startRequest(ctx, "SignedURL(%q, %q)", req.Name, req.Method)
+	defer b.finishRequest(id, desc, start, &err)
+
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
+
+func (b *debugBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	id, desc, start := b.startRequest(ctx, "ListObjectACLs(%q)", req.Name)
+	defer b.finishRequest(id, desc, start, &err)
+
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *debugBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	id, desc, start := b.startRequest(
+		ctx,
+		"UpdateObjectACL(%q, %q, %q)",
+		req.Name,
+		req.Entity,
+		req.Role)
+
+	defer b.finishRequest(id, desc, start, &err)
+
+	rule, err = b.wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *debugBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	id, desc, start := b.startRequest(
+		ctx,
+		"DeleteObjectACL(%q, %q)",
+		req.Name,
+		req.Entity)
+
+	defer b.finishRequest(id, desc, start, &err)
+
+	err = b.wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *debugBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	id, desc, start := b.startRequest(
+		ctx,
+		"RewriteObject(%q, %q, %q)",
+		req.SrcName,
+		req.DstBucket,
+		req.DstName)
+
+	defer b.finishRequest(id, desc, start, &err)
+
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	return
+}
+
+func (b *debugBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	id, desc, start := b.startRequest(ctx, "Batch(%d ops)", len(req.Ops))
+	defer b.finishRequest(id, desc, start, &err)
+
+	results, err = b.wrapped.Batch(ctx, req)
+	return
+}
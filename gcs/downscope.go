@@ -0,0 +1,171 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google/downscope"
+)
+
+// A limit on the access granted by a downscoped token. See
+// DownscopeTokenSource.
+type AccessBoundary struct {
+	// The bucket to which access is granted.
+	BucketName string
+
+	// If non-empty, access is limited to objects whose names begin with this
+	// prefix, and to listing such objects.
+	Prefix string
+
+	// If true, objects may only be read and listed. Otherwise they may also be
+	// created, modified, and deleted.
+	ReadOnly bool
+}
+
+// Return a token source whose tokens grant only the access described by the
+// supplied boundaries, and no more than the root source's tokens do. Tokens
+// are exchanged for downscoped ones using the Security Token Service, so the
+// root source's credentials need not be shared with the holder of the result.
+// At most ten boundaries may be supplied.
+//
+// Official documentation:
+//     https://cloud.google.com/iam/docs/downscoping-short-lived-credentials
+func DownscopeTokenSource(
+	ctx context.Context,
+	root oauth2.TokenSource,
+	boundaries []AccessBoundary) (ts oauth2.TokenSource, err error) {
+	var rules []downscope.AccessBoundaryRule
+	for _, ab := range boundaries {
+		if ab.BucketName == "" {
+			err = errors.New("Access boundaries must name a bucket.")
+			return
+		}
+
+		rules = append(rules, makeAccessBoundaryRule(ab))
+	}
+
+	ts, err = downscope.NewTokenSource(ctx, downscope.DownscopingConfig{
+		RootSource: root,
+		Rules:      rules,
+	})
+
+	if err != nil {
+		err = fmt.Errorf("downscope.NewTokenSource: %v", err)
+		return
+	}
+
+	return
+}
+
+// Open the bucket named by the supplied access boundary using a connection
+// configured like cfg, but authenticated with tokens downscoped to the
+// boundary. This is useful for handing constrained access to less-trusted
+// code.
+//
+// The resulting bucket refuses mutations up front if the boundary is read
+// only, and if the boundary has a prefix then object names are relative to
+// it, as with NewPrefixBucket.
+func OpenDownscopedBucket(
+	ctx context.Context,
+	cfg *ConnConfig,
+	ab AccessBoundary) (b Bucket, err error) {
+	if cfg.TokenSource == nil {
+		err = errors.New("You must set TokenSource.")
+		return
+	}
+
+	// Set up a connection with downscoped tokens.
+	downscopedCfg := *cfg
+	downscopedCfg.TokenSource, err = DownscopeTokenSource(
+		ctx,
+		cfg.TokenSource,
+		[]AccessBoundary{ab})
+
+	if err != nil {
+		return
+	}
+
+	c, err := NewConn(&downscopedCfg)
+	if err != nil {
+		err = fmt.Errorf("NewConn: %v", err)
+		return
+	}
+
+	// The tokens don't permit listing the whole bucket, so probe within the
+	// prefix.
+	b, err = c.(*conn).openBucket(ctx, ab.BucketName, ab.Prefix)
+	if err != nil {
+		return
+	}
+
+	if ab.ReadOnly {
+		b = NewReadOnlyBucket(b)
+	}
+
+	if ab.Prefix != "" {
+		b = NewPrefixBucket(b, ab.Prefix)
+	}
+
+	return
+}
+
+// Return the credential access boundary rule corresponding to the supplied
+// access boundary.
+func makeAccessBoundaryRule(
+	ab AccessBoundary) (rule downscope.AccessBoundaryRule) {
+	rule.AvailableResource = "//storage.googleapis.com/projects/_/buckets/" +
+		ab.BucketName
+
+	role := "roles/storage.objectAdmin"
+	if ab.ReadOnly {
+		role = "roles/storage.objectViewer"
+	}
+
+	rule.AvailablePermissions = []string{"inRole:" + role}
+
+	// Limit to the prefix if requested. Listing is governed by the prefix of
+	// the request rather than the name of any object.
+	if ab.Prefix != "" {
+		const listPrefix = "storage.googleapis.com/objectListPrefix"
+		objectPrefix := fmt.Sprintf(
+			"projects/_/buckets/%s/objects/%s",
+			ab.BucketName,
+			ab.Prefix)
+
+		rule.Condition = &downscope.AvailabilityCondition{
+			Title: "Objects with prefix " + ab.Prefix,
+			Expression: fmt.Sprintf(
+				"resource.name.startsWith(%s) || "+
+					"api.getAttribute('%s', '').startsWith(%s)",
+				quoteCEL(objectPrefix),
+				listPrefix,
+				quoteCEL(ab.Prefix)),
+		}
+	}
+
+	return
+}
+
+// Return a CEL string literal for s.
+func quoteCEL(s string) (q string) {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	q = "'" + r.Replace(s) + "'"
+	return
+}
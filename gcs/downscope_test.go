@@ -0,0 +1,105 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestDownscope(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type DownscopeTest struct {
+	ctx context.Context
+}
+
+var _ SetUpInterface = &DownscopeTest{}
+
+func init() { RegisterTestSuite(&DownscopeTest{}) }
+
+func (t *DownscopeTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *DownscopeTest) WholeBucket() {
+	rule := makeAccessBoundaryRule(AccessBoundary{
+		BucketName: "some_bucket",
+	})
+
+	ExpectEq(
+		"//storage.googleapis.com/projects/_/buckets/some_bucket",
+		rule.AvailableResource)
+
+	ExpectThat(
+		rule.AvailablePermissions,
+		ElementsAre("inRole:roles/storage.objectAdmin"))
+
+	ExpectEq(nil, rule.Condition)
+}
+
+func (t *DownscopeTest) ReadOnlyPrefix() {
+	rule := makeAccessBoundaryRule(AccessBoundary{
+		BucketName: "some_bucket",
+		Prefix:     "tenants/taco's/",
+		ReadOnly:   true,
+	})
+
+	ExpectThat(
+		rule.AvailablePermissions,
+		ElementsAre("inRole:roles/storage.objectViewer"))
+
+	AssertNe(nil, rule.Condition)
+	ExpectEq(
+		`resource.name.startsWith('projects/_/buckets/some_bucket/objects/`+
+			`tenants/taco\'s/') || `+
+			`api.getAttribute('storage.googleapis.com/objectListPrefix', '')`+
+			`.startsWith('tenants/taco\'s/')`,
+		rule.Condition.Expression)
+}
+
+func (t *DownscopeTest) QuoteCEL() {
+	ExpectEq(`''`, quoteCEL(""))
+	ExpectEq(`'foo'`, quoteCEL("foo"))
+	ExpectEq(`'a\\b\'c'`, quoteCEL(`a\b'c`))
+}
+
+func (t *DownscopeTest) MissingBucketName() {
+	root := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"})
+	_, err := DownscopeTokenSource(t.ctx, root, []AccessBoundary{{}})
+
+	ExpectThat(err, Error(HasSubstr("bucket")))
+}
+
+func (t *DownscopeTest) MissingTokenSource() {
+	_, err := OpenDownscopedBucket(
+		t.ctx,
+		&ConnConfig{EmulatorHost: "localhost:4443"},
+		AccessBoundary{BucketName: "some_bucket"})
+
+	ExpectThat(err, Error(HasSubstr("TokenSource")))
+}
@@ -0,0 +1,992 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// A Keyring supplies the key-encryption keys used by buckets created with
+// NewEncryptingBucket. Keys must be 16, 24, or 32 bytes long, selecting
+// AES-128, AES-192, or AES-256.
+type Keyring interface {
+	// Return the key with which to wrap the data keys of new objects, along
+	// with its ID. The ID is recorded in the objects' metadata.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Return the key with the given ID, for unwrapping the data keys of
+	// existing objects. After rotating to a new current key, old keys must
+	// remain available for as long as objects wrapped with them exist.
+	Key(id string) (key []byte, err error)
+}
+
+// Create a keyring with a fixed set of keys, indexed by ID, of which the one
+// with ID currentID is used for new objects.
+func NewStaticKeyring(
+	currentID string,
+	keys map[string][]byte) (kr Keyring, err error) {
+	if _, ok := keys[currentID]; !ok {
+		err = fmt.Errorf("No key with ID %q", currentID)
+		return
+	}
+
+	copied := make(map[string][]byte)
+	for id, key := range keys {
+		if _, err = aes.NewCipher(key); err != nil {
+			err = fmt.Errorf("Key %q: %v", id, err)
+			return
+		}
+
+		copied[id] = append([]byte(nil), key...)
+	}
+
+	kr = &staticKeyring{
+		currentID: currentID,
+		keys:      copied,
+	}
+
+	return
+}
+
+type staticKeyring struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+func (kr *staticKeyring) CurrentKey() (id string, key []byte, err error) {
+	id = kr.currentID
+	key = kr.keys[id]
+	return
+}
+
+func (kr *staticKeyring) Key(id string) (key []byte, err error) {
+	key, ok := kr.keys[id]
+	if !ok {
+		err = fmt.Errorf("Unknown key ID %q", id)
+		return
+	}
+
+	return
+}
+
+// Options for NewEncryptingBucket.
+type EncryptionConfig struct {
+	// If true, objects that were not written through an encrypting bucket are
+	// read as stored, easing migration of existing data. Otherwise reading
+	// them fails, so that plaintext planted in the bucket by someone holding
+	// only GCS credentials isn't mistaken for data that was encrypted.
+	AllowUnencrypted bool
+}
+
+// Create a bucket that encrypts object contents on the client before they
+// reach the wrapped bucket, and decrypts them again when they're read. This is
+// for data that must not be readable by anyone holding only GCS credentials,
+// beyond what server-side encryption provides.
+//
+// Each object is encrypted with AES-256-GCM under its own random data key,
+// which is wrapped with the keyring's current key and stored in the object's
+// metadata alongside the ID of that key. The contents are encrypted in chunks
+// of 64 KiB, so reads of ranges don't require reading the whole object, and
+// any modification of the stored contents, including truncation, makes reads
+// fail.
+//
+// Object records returned by the bucket report the size of the plaintext, and
+// don't include the encryption metadata. Their CRC32C and MD5 fields are those
+// of the stored ciphertext, so don't compare them with the decrypted
+// contents; ReadObjectRequest.VerifyChecksums continues to work.
+//
+// Limitations:
+//
+//  *  Objects are stored without a ContentEncoding, since GCS would otherwise
+//     try to decompress the ciphertext, so CreateObjectRequest.Compress and
+//     ContentEncoding are refused.
+//
+//  *  ComposeObjects is refused, since concatenated ciphertexts can't be
+//     decrypted.
+//
+//  *  Objects that were not written through an encrypting bucket can't be
+//     read through it unless cfg.AllowUnencrypted is set. They are still
+//     listed and can be statted.
+//
+//  *  Signed URLs grant access to the ciphertext.
+//
+func NewEncryptingBucket(
+	wrapped Bucket,
+	keyring Keyring,
+	cfg EncryptionConfig) (b Bucket) {
+	b = &encryptingBucket{
+		wrapped: wrapped,
+		keyring: keyring,
+		cfg:     cfg,
+	}
+
+	return
+}
+
+type encryptingBucket struct {
+	wrapped Bucket
+	keyring Keyring
+	cfg     EncryptionConfig
+}
+
+////////////////////////////////////////////////////////////////////////
+// Format
+////////////////////////////////////////////////////////////////////////
+
+// Metadata keys recording the ID of the key-encryption key and the wrapped
+// data key of an encrypted object.
+const (
+	encryptionKeyIDMetadataKey      = "encryption-key-id"
+	encryptionWrappedKeyMetadataKey = "encryption-wrapped-key"
+)
+
+// The size of the plaintext chunks that are encrypted separately. Every chunk
+// but the last is exactly this size; the last is shorter, and may be empty.
+const encryptionChunkSize = 1 << 16
+
+// The overhead added by AES-GCM to each chunk.
+const encryptionOverhead = 16
+
+// The size of a data key, selecting AES-256.
+const dataKeySize = 32
+
+// Each object has its own data key, so chunk nonces need only be unique within
+// the object. We use the chunk index.
+func chunkNonce(index uint64) (nonce []byte) {
+	nonce = make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], index)
+	return
+}
+
+// The additional authenticated data for a chunk marks whether it is the last,
+// so that truncation at a chunk boundary is detected.
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
+
+func newGCM(key []byte) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+
+	aead, err = cipher.NewGCM(block)
+	return
+}
+
+// Return the number of chunks and the plaintext size for an encrypted object
+// of the given stored size.
+func encryptedLayout(storedSize uint64) (chunks uint64, size uint64, err error) {
+	const storedChunkSize = encryptionChunkSize + encryptionOverhead
+
+	chunks = storedSize/storedChunkSize + 1
+	if storedSize-(chunks-1)*storedChunkSize < encryptionOverhead {
+		err = fmt.Errorf("Invalid size for encrypted contents: %d", storedSize)
+		return
+	}
+
+	size = storedSize - chunks*encryptionOverhead
+	return
+}
+
+// Return true if the metadata name is reserved for use by this bucket.
+func isEncryptionMetadataKey(k string) bool {
+	return k == encryptionKeyIDMetadataKey ||
+		k == encryptionWrappedKeyMetadataKey
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Generate a data key for a new object, returning an AEAD for it along with
+// the metadata entries that record it.
+func (b *encryptingBucket) newDataKey() (
+	aead cipher.AEAD,
+	metadata map[string]string,
+	err error) {
+	id, kek, err := b.keyring.CurrentKey()
+	if err != nil {
+		err = fmt.Errorf("CurrentKey: %v", err)
+		return
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err = io.ReadFull(rand.Reader, dataKey); err != nil {
+		return
+	}
+
+	if aead, err = newGCM(dataKey); err != nil {
+		return
+	}
+
+	// Wrap the data key.
+	kekAEAD, err := newGCM(kek)
+	if err != nil {
+		err = fmt.Errorf("Key %q: %v", id, err)
+		return
+	}
+
+	nonce := make([]byte, kekAEAD.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+
+	wrapped := kekAEAD.Seal(nonce, nonce, dataKey, nil)
+
+	metadata = map[string]string{
+		encryptionKeyIDMetadataKey:      id,
+		encryptionWrappedKeyMetadataKey: base64.StdEncoding.EncodeToString(wrapped),
+	}
+
+	return
+}
+
+// Return an AEAD for the data key recorded in the object's metadata, or nil
+// if the object isn't encrypted.
+func (b *encryptingBucket) dataKey(o *Object) (aead cipher.AEAD, err error) {
+	encoded, ok := o.Metadata[encryptionWrappedKeyMetadataKey]
+	if !ok {
+		return
+	}
+
+	id := o.Metadata[encryptionKeyIDMetadataKey]
+	kek, err := b.keyring.Key(id)
+	if err != nil {
+		err = fmt.Errorf("Key(%q): %v", id, err)
+		return
+	}
+
+	kekAEAD, err := newGCM(kek)
+	if err != nil {
+		err = fmt.Errorf("Key %q: %v", id, err)
+		return
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(wrapped) < kekAEAD.NonceSize() {
+		err = fmt.Errorf("Malformed wrapped key for %q", o.Name)
+		return
+	}
+
+	nonceSize := kekAEAD.NonceSize()
+	dataKey, err := kekAEAD.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], nil)
+	if err != nil {
+		err = fmt.Errorf("Unwrapping key for %q with key %q: %v", o.Name, id, err)
+		return
+	}
+
+	aead, err = newGCM(dataKey)
+	return
+}
+
+// Return a copy of the object record as seen by our users: with the size of
+// the plaintext and without the encryption metadata. Records for objects that
+// aren't encrypted are returned unmodified.
+func (b *encryptingBucket) userObject(o *Object) (u *Object, err error) {
+	if o == nil {
+		return
+	}
+
+	if _, ok := o.Metadata[encryptionWrappedKeyMetadataKey]; !ok {
+		u = o
+		return
+	}
+
+	oCopy := *o
+	u = &oCopy
+
+	if _, u.Size, err = encryptedLayout(o.Size); err != nil {
+		err = fmt.Errorf("%q: %v", o.Name, err)
+		return
+	}
+
+	u.Metadata = nil
+	for k, v := range o.Metadata {
+		if isEncryptionMetadataKey(k) {
+			continue
+		}
+
+		if u.Metadata == nil {
+			u.Metadata = make(map[string]string)
+		}
+
+		u.Metadata[k] = v
+	}
+
+	return
+}
+
+// Make sure that the fields we need are among those requested.
+func encryptionStatFields(fields []string) []string {
+	if len(fields) == 0 {
+		return fields
+	}
+
+	return append(append([]string(nil), fields...), "size", "metadata")
+}
+
+// Check that an update doesn't interfere with encryption.
+func checkEncryptionUpdate(req *UpdateObjectRequest) (err error) {
+	if req.ContentEncoding != nil && *req.ContentEncoding != "" {
+		err = errors.New("Encrypted objects can't have a ContentEncoding")
+		return
+	}
+
+	for k := range req.Metadata {
+		if isEncryptionMetadataKey(k) {
+			err = fmt.Errorf("Metadata key %q is reserved for encryption", k)
+			return
+		}
+	}
+
+	return
+}
+
+// Find the record for a particular generation of an object, which StatObject
+// can't do by itself.
+func (b *encryptingBucket) statGeneration(
+	ctx context.Context,
+	name string,
+	generation int64) (o *Object, err error) {
+	req := &ListObjectsRequest{
+		Prefix:   name,
+		Versions: true,
+	}
+
+	for {
+		var listing *Listing
+		listing, err = b.wrapped.ListObjects(ctx, req)
+		if err != nil {
+			return
+		}
+
+		for _, candidate := range listing.Objects {
+			if candidate.Name == name && candidate.Generation == generation {
+				o = candidate
+				return
+			}
+
+			// Listings are sorted, so we can stop early.
+			if candidate.Name > name {
+				break
+			}
+		}
+
+		if listing.ContinuationToken == "" {
+			break
+		}
+
+		req.ContinuationToken = listing.ContinuationToken
+	}
+
+	err = &NotFoundError{
+		Err: fmt.Errorf("Object %q generation %d not found", name, generation),
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Encryption and decryption
+////////////////////////////////////////////////////////////////////////
+
+// A reader that encrypts the contents of the wrapped reader, optionally
+// checking the plaintext against expected checksums.
+type encryptingReader struct {
+	wrapped io.Reader
+	aead    cipher.AEAD
+
+	// The index of the next chunk to encrypt, and whether the final chunk has
+	// been.
+	index uint64
+	done  bool
+
+	plaintext []byte
+	buf       []byte
+
+	// Ciphertext not yet returned.
+	pending []byte
+
+	// Running checksums, nil if not being checked.
+	crc32c         hash.Hash32
+	md5            hash.Hash
+	expectedCRC32C *uint32
+	expectedMD5    *[md5.Size]byte
+}
+
+func newEncryptingReader(
+	wrapped io.Reader,
+	aead cipher.AEAD,
+	crc32c *uint32,
+	md5Sum *[md5.Size]byte) (r *encryptingReader) {
+	r = &encryptingReader{
+		wrapped:        wrapped,
+		aead:           aead,
+		plaintext:      make([]byte, encryptionChunkSize),
+		expectedCRC32C: crc32c,
+		expectedMD5:    md5Sum,
+	}
+
+	if crc32c != nil {
+		r.crc32c = crc32.New(crc32cTable)
+	}
+
+	if md5Sum != nil {
+		r.md5 = md5.New()
+	}
+
+	return
+}
+
+// Check the plaintext read so far against the expected checksums.
+func (r *encryptingReader) checkPlaintext() (err error) {
+	if r.crc32c != nil && r.crc32c.Sum32() != *r.expectedCRC32C {
+		err = fmt.Errorf(
+			"CRC32C mismatch: contents have %#08x, expected %#08x",
+			r.crc32c.Sum32(),
+			*r.expectedCRC32C)
+		return
+	}
+
+	if r.md5 != nil {
+		var actual [md5.Size]byte
+		r.md5.Sum(actual[:0])
+		if actual != *r.expectedMD5 {
+			err = errors.New("MD5 mismatch")
+			return
+		}
+	}
+
+	return
+}
+
+func (r *encryptingReader) Read(p []byte) (n int, err error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			err = io.EOF
+			return
+		}
+
+		// Read the next chunk. A short one is the last.
+		var m int
+		m, err = io.ReadFull(r.wrapped, r.plaintext)
+
+		var final bool
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			err = nil
+			final = true
+		default:
+			return
+		}
+
+		chunk := r.plaintext[:m]
+		if r.crc32c != nil {
+			r.crc32c.Write(chunk)
+		}
+
+		if r.md5 != nil {
+			r.md5.Write(chunk)
+		}
+
+		if final {
+			if err = r.checkPlaintext(); err != nil {
+				return
+			}
+		}
+
+		r.buf = r.aead.Seal(r.buf[:0], chunkNonce(r.index), chunk, chunkAAD(final))
+		r.pending = r.buf
+		r.index++
+		r.done = final
+	}
+
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return
+}
+
+// A reader that decrypts a run of chunks of an encrypted object.
+type decryptingReader struct {
+	wrapped ReadSeekCloser
+	aead    cipher.AEAD
+
+	// The stored size of the object and its number of chunks.
+	storedSize uint64
+	chunks     uint64
+
+	// The index of the next chunk to read from wrapped, and the index at which
+	// to stop.
+	index    uint64
+	endIndex uint64
+
+	// The number of bytes to discard from the start of the next chunk, and the
+	// number of plaintext bytes left to return.
+	skip      uint64
+	remaining uint64
+
+	buf []byte
+
+	// Plaintext not yet returned.
+	pending []byte
+
+	// If non-nil, called with the number of bytes read so far.
+	progress  func(int64)
+	bytesRead int64
+}
+
+func (r *decryptingReader) readChunk() (err error) {
+	const storedChunkSize = encryptionChunkSize + encryptionOverhead
+
+	final := r.index == r.chunks-1
+	size := uint64(storedChunkSize)
+	if final {
+		size = r.storedSize - r.index*storedChunkSize
+	}
+
+	if uint64(cap(r.buf)) < size {
+		r.buf = make([]byte, size)
+	}
+
+	chunk := r.buf[:size]
+	if _, err = io.ReadFull(r.wrapped, chunk); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		err = fmt.Errorf("Reading chunk %d: %v", r.index, err)
+		return
+	}
+
+	plaintext, err := r.aead.Open(chunk[:0], chunkNonce(r.index), chunk, chunkAAD(final))
+	if err != nil {
+		err = fmt.Errorf("Decrypting chunk %d: %v", r.index, err)
+		return
+	}
+
+	r.pending = plaintext[r.skip:]
+	r.skip = 0
+	if uint64(len(r.pending)) > r.remaining {
+		r.pending = r.pending[:r.remaining]
+	}
+
+	r.remaining -= uint64(len(r.pending))
+	r.index++
+
+	return
+}
+
+func (r *decryptingReader) Read(p []byte) (n int, err error) {
+	for len(r.pending) == 0 {
+		if r.index == r.endIndex {
+			err = io.EOF
+			return
+		}
+
+		if err = r.readChunk(); err != nil {
+			return
+		}
+	}
+
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+
+	if n > 0 && r.progress != nil {
+		r.bytesRead += int64(n)
+		r.progress(r.bytesRead)
+	}
+
+	return
+}
+
+func (r *decryptingReader) Seek(offset int64, whence int) (n int64, err error) {
+	err = errors.New("Seeking is not supported for encrypted objects")
+	return
+}
+
+func (r *decryptingReader) Close() (err error) {
+	err = r.wrapped.Close()
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *encryptingBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *encryptingBucket) NewReader(
+	ctx context.Context,
+	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	// We can only verify checksums for the full contents.
+	if req.VerifyChecksums && req.Range != nil {
+		err = errors.New("VerifyChecksums may not be combined with Range")
+		return
+	}
+
+	// Find the object's metadata.
+	var o *Object
+	if req.Generation == 0 {
+		o, err = b.wrapped.StatObject(ctx, &StatObjectRequest{Name: req.Name})
+	} else {
+		o, err = b.statGeneration(ctx, req.Name, req.Generation)
+	}
+
+	if err != nil {
+		return
+	}
+
+	// Whatever happens, read the generation we've seen.
+	wrappedReq := *req
+	wrappedReq.Generation = o.Generation
+
+	aead, err := b.dataKey(o)
+	if err != nil {
+		return
+	}
+
+	// Objects that aren't encrypted are read as they are, if allowed.
+	if aead == nil {
+		if !b.cfg.AllowUnencrypted {
+			err = fmt.Errorf("Object %q is not encrypted", o.Name)
+			return
+		}
+
+		rc, err = b.wrapped.NewReader(ctx, &wrappedReq)
+		return
+	}
+
+	chunks, size, err := encryptedLayout(o.Size)
+	if err != nil {
+		err = fmt.Errorf("%q: %v", o.Name, err)
+		return
+	}
+
+	// Work out which plaintext bytes are wanted, and which chunks hold them.
+	// Full reads consume every chunk, so that truncation is detected.
+	start, limit := uint64(0), size
+	firstIndex, endIndex := uint64(0), chunks
+	if req.Range != nil {
+		start, limit = req.Range.Start, req.Range.Limit
+		if limit > size {
+			limit = size
+		}
+
+		if start > limit {
+			start = limit
+		}
+
+		firstIndex = start / encryptionChunkSize
+		endIndex = firstIndex
+		if limit > start {
+			endIndex = (limit-1)/encryptionChunkSize + 1
+		}
+	}
+
+	// Read the corresponding stored bytes, letting the wrapped bucket check
+	// the preconditions even if we need no contents.
+	const storedChunkSize = encryptionChunkSize + encryptionOverhead
+
+	wrappedReq.ProgressFunc = nil
+	wrappedReq.ReadCompressed = false
+	wrappedReq.ContentEncodingFunc = nil
+	if req.Range != nil {
+		storedLimit := endIndex * storedChunkSize
+		if storedLimit > o.Size {
+			storedLimit = o.Size
+		}
+
+		wrappedReq.Range = &ByteRange{
+			Start: firstIndex * storedChunkSize,
+			Limit: storedLimit,
+		}
+	}
+
+	wrapped, err := b.wrapped.NewReader(ctx, &wrappedReq)
+	if err != nil {
+		return
+	}
+
+	rc = &decryptingReader{
+		wrapped:    wrapped,
+		aead:       aead,
+		storedSize: o.Size,
+		chunks:     chunks,
+		index:      firstIndex,
+		endIndex:   endIndex,
+		skip:       start - firstIndex*encryptionChunkSize,
+		remaining:  limit - start,
+		progress:   req.ProgressFunc,
+	}
+
+	// Encrypted objects are stored without a content encoding.
+	if req.ContentEncodingFunc != nil {
+		req.ContentEncodingFunc("")
+	}
+
+	return
+}
+
+func (b *encryptingBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	switch {
+	case req.Compress:
+		err = errors.New("Compress is not supported for encrypted objects")
+		return
+
+	case req.ContentEncoding != "":
+		err = errors.New("Encrypted objects can't have a ContentEncoding")
+		return
+	}
+
+	for k := range req.Metadata {
+		if isEncryptionMetadataKey(k) {
+			err = fmt.Errorf("Metadata key %q is reserved for encryption", k)
+			return
+		}
+	}
+
+	aead, metadata, err := b.newDataKey()
+	if err != nil {
+		err = fmt.Errorf("newDataKey: %v", err)
+		return
+	}
+
+	for k, v := range req.Metadata {
+		metadata[k] = v
+	}
+
+	// Any checksums supplied are for the plaintext, so we check them
+	// ourselves.
+	wrappedReq := *req
+	wrappedReq.Metadata = metadata
+	wrappedReq.Contents = newEncryptingReader(
+		req.Contents,
+		aead,
+		req.CRC32C,
+		req.MD5)
+
+	wrappedReq.CRC32C = nil
+	wrappedReq.MD5 = nil
+
+	o, err = b.wrapped.CreateObject(ctx, &wrappedReq)
+	if err != nil {
+		return
+	}
+
+	o, err = b.userObject(o)
+	return
+}
+
+func (b *encryptingBucket) CopyObject(
+	ctx context.Context,
+	req *CopyObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.CopyObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o, err = b.userObject(o)
+	return
+}
+
+func (b *encryptingBucket) MoveObject(
+	ctx context.Context,
+	req *MoveObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.MoveObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o, err = b.userObject(o)
+	return
+}
+
+func (b *encryptingBucket) ComposeObjects(
+	ctx context.Context,
+	req *ComposeObjectsRequest) (o *Object, err error) {
+	err = errors.New("ComposeObjects is not supported for encrypted objects")
+	return
+}
+
+func (b *encryptingBucket) StatObject(
+	ctx context.Context,
+	req *StatObjectRequest) (o *Object, err error) {
+	wrappedReq := *req
+	wrappedReq.Fields = encryptionStatFields(req.Fields)
+
+	o, err = b.wrapped.StatObject(ctx, &wrappedReq)
+	if err != nil {
+		return
+	}
+
+	o, err = b.userObject(o)
+	return
+}
+
+func (b *encryptingBucket) ListObjects(
+	ctx context.Context,
+	req *ListObjectsRequest) (listing *Listing, err error) {
+	wrappedReq := *req
+	wrappedReq.Fields = encryptionStatFields(req.Fields)
+
+	wrapped, err := b.wrapped.ListObjects(ctx, &wrappedReq)
+	if err != nil {
+		return
+	}
+
+	// Don't modify the wrapped bucket's result in place.
+	l := *wrapped
+	l.Objects = make([]*Object, len(wrapped.Objects))
+	for i, o := range wrapped.Objects {
+		if l.Objects[i], err = b.userObject(o); err != nil {
+			return
+		}
+	}
+
+	listing = &l
+	return
+}
+
+func (b *encryptingBucket) UpdateObject(
+	ctx context.Context,
+	req *UpdateObjectRequest) (o *Object, err error) {
+	if err = checkEncryptionUpdate(req); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o, err = b.userObject(o)
+	return
+}
+
+func (b *encryptingBucket) DeleteObject(
+	ctx context.Context,
+	req *DeleteObjectRequest) (err error) {
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+func (b *encryptingBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.RestoreObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o, err = b.userObject(o)
+	return
+}
+
+func (b *encryptingBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
+
+func (b *encryptingBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *encryptingBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	rule, err = b.wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *encryptingBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	err = b.wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *encryptingBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	o, err = b.userObject(o)
+	return
+}
+
+func (b *encryptingBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	wrappedReq := &BatchRequest{
+		Ops: make([]BatchOp, len(req.Ops)),
+	}
+
+	for i, op := range req.Ops {
+		wrappedReq.Ops[i] = op
+
+		if op.Stat != nil {
+			r := *op.Stat
+			r.Fields = encryptionStatFields(r.Fields)
+			wrappedReq.Ops[i].Stat = &r
+		}
+
+		if op.Update != nil {
+			if err = checkEncryptionUpdate(op.Update); err != nil {
+				return
+			}
+		}
+	}
+
+	results, err = b.wrapped.Batch(ctx, wrappedReq)
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+
+		results[i].Object, results[i].Err = b.userObject(results[i].Object)
+	}
+
+	return
+}
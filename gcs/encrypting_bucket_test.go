@@ -0,0 +1,417 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestEncryptingBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// The size of the chunks in which the bucket encrypts contents.
+const encryptionChunk = 1 << 16
+
+type EncryptingBucketTest struct {
+	ctx     context.Context
+	keys    map[string][]byte
+	wrapped gcs.Bucket
+	bucket  gcs.Bucket
+}
+
+var _ SetUpInterface = &EncryptingBucketTest{}
+
+func init() { RegisterTestSuite(&EncryptingBucketTest{}) }
+
+func (t *EncryptingBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.keys = map[string][]byte{
+		"a": bytes.Repeat([]byte{'a'}, 32),
+		"b": bytes.Repeat([]byte{'b'}, 16),
+	}
+
+	t.wrapped = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	t.bucket = gcs.NewEncryptingBucket(t.wrapped, t.keyring("a"), gcs.EncryptionConfig{})
+}
+
+func (t *EncryptingBucketTest) keyring(current string) gcs.Keyring {
+	kr, err := gcs.NewStaticKeyring(current, t.keys)
+	AssertEq(nil, err)
+	return kr
+}
+
+func randomBytes(n int) (b []byte) {
+	b = make([]byte, n)
+	_, err := io.ReadFull(rand.Reader, b)
+	AssertEq(nil, err)
+	return
+}
+
+func (t *EncryptingBucketTest) readRange(
+	name string,
+	br gcs.ByteRange) (contents []byte, err error) {
+	rc, err := t.bucket.NewReader(
+		t.ctx,
+		&gcs.ReadObjectRequest{
+			Name:  name,
+			Range: &br,
+		})
+
+	if err != nil {
+		return
+	}
+
+	defer rc.Close()
+	contents, err = ioutil.ReadAll(rc)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *EncryptingBucketTest) NewStaticKeyring_BadKeys() {
+	_, err := gcs.NewStaticKeyring("c", t.keys)
+	ExpectThat(err, Error(HasSubstr("No key")))
+
+	t.keys["c"] = []byte("too short")
+	_, err = gcs.NewStaticKeyring("a", t.keys)
+	ExpectThat(err, Error(HasSubstr("Key \"c\"")))
+}
+
+func (t *EncryptingBucketTest) RoundTrip() {
+	sizes := []int{
+		0,
+		1,
+		encryptionChunk - 1,
+		encryptionChunk,
+		encryptionChunk + 1,
+		3*encryptionChunk + 5,
+	}
+
+	for _, size := range sizes {
+		contents := randomBytes(size)
+
+		o, err := t.bucket.CreateObject(
+			t.ctx,
+			&gcs.CreateObjectRequest{
+				Name:     "foo",
+				Contents: bytes.NewReader(contents),
+				Metadata: map[string]string{"bar": "baz"},
+			})
+
+		AssertEq(nil, err, "size: %d", size)
+		ExpectEq(size, o.Size)
+		ExpectThat(o.Metadata, DeepEquals(map[string]string{"bar": "baz"}))
+
+		// Stat should agree.
+		o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+		AssertEq(nil, err)
+		ExpectEq(size, o.Size)
+		ExpectThat(o.Metadata, DeepEquals(map[string]string{"bar": "baz"}))
+
+		// Read back the contents, verifying checksums.
+		rc, err := t.bucket.NewReader(
+			t.ctx,
+			&gcs.ReadObjectRequest{
+				Name:            "foo",
+				VerifyChecksums: true,
+			})
+
+		AssertEq(nil, err)
+		actual, err := ioutil.ReadAll(rc)
+		AssertEq(nil, err)
+		AssertEq(nil, rc.Close())
+		ExpectTrue(bytes.Equal(contents, actual), "size: %d", size)
+
+		// The stored contents should be larger, and look nothing like the
+		// plaintext. Short plaintexts could turn up in the ciphertext by
+		// chance, so check only for longer ones.
+		stored, err := gcsutil.ReadObject(t.ctx, t.wrapped, "foo")
+		AssertEq(nil, err)
+
+		chunks := size/encryptionChunk + 1
+		ExpectEq(size+16*chunks, len(stored))
+		if size >= 16 {
+			ExpectFalse(bytes.Contains(stored, contents[:size/2+1]))
+		}
+	}
+}
+
+func (t *EncryptingBucketTest) Ranges() {
+	size := 3*encryptionChunk + 5
+	contents := randomBytes(size)
+
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+	AssertEq(nil, err)
+
+	ranges := []gcs.ByteRange{
+		{0, 0},
+		{0, 1},
+		{0, uint64(size)},
+		{0, uint64(size) + 100},
+		{17, 17},
+		{17, 19},
+		{encryptionChunk - 1, encryptionChunk + 1},
+		{encryptionChunk, 2 * encryptionChunk},
+		{encryptionChunk + 3, 3*encryptionChunk + 2},
+		{uint64(size) - 1, uint64(size)},
+		{uint64(size), uint64(size) + 1},
+		{uint64(size) + 10, uint64(size) + 20},
+		{20, 10},
+	}
+
+	for _, br := range ranges {
+		start, limit := br.Start, br.Limit
+		if limit > uint64(size) {
+			limit = uint64(size)
+		}
+
+		if start > limit {
+			start = limit
+		}
+
+		actual, err := t.readRange("foo", br)
+		AssertEq(nil, err, "range: %v", br)
+		ExpectTrue(
+			bytes.Equal(contents[start:limit], actual),
+			"range: %v, got %d bytes",
+			br,
+			len(actual))
+	}
+}
+
+func (t *EncryptingBucketTest) ParticularGeneration() {
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	req := &gcs.ReadObjectRequest{
+		Name:       "foo",
+		Generation: o.Generation,
+	}
+
+	rc, err := t.bucket.NewReader(t.ctx, req)
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(rc)
+	AssertEq(nil, err)
+	AssertEq(nil, rc.Close())
+	ExpectEq("taco", string(contents))
+
+	// The fake doesn't keep noncurrent generations, so once the object is
+	// overwritten the old generation is gone.
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+
+	_, err = t.bucket.NewReader(t.ctx, req)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *EncryptingBucketTest) KeyRotation() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	// Rotate to a new key. Old objects should still be readable, and new ones
+	// should use the new key.
+	t.bucket = gcs.NewEncryptingBucket(t.wrapped, t.keyring("b"), gcs.EncryptionConfig{})
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "bar", []byte("burrito"))
+	AssertEq(nil, err)
+
+	o, err := t.wrapped.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	AssertEq(nil, err)
+	ExpectEq("b", o.Metadata["encryption-key-id"])
+
+	// Without the old key, the old object can no longer be read.
+	delete(t.keys, "a")
+	t.bucket = gcs.NewEncryptingBucket(t.wrapped, t.keyring("b"), gcs.EncryptionConfig{})
+
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	ExpectThat(err, Error(HasSubstr("Unknown key ID \"a\"")))
+}
+
+func (t *EncryptingBucketTest) Tampering() {
+	contents := randomBytes(2*encryptionChunk + 5)
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+	AssertEq(nil, err)
+
+	// Grab the stored contents and metadata.
+	o, err := t.wrapped.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	stored, err := gcsutil.ReadObject(t.ctx, t.wrapped, "foo")
+	AssertEq(nil, err)
+
+	store := func(b []byte) {
+		_, err := t.wrapped.CreateObject(
+			t.ctx,
+			&gcs.CreateObjectRequest{
+				Name:     "foo",
+				Contents: bytes.NewReader(b),
+				Metadata: o.Metadata,
+			})
+
+		AssertEq(nil, err)
+	}
+
+	// Flip a bit.
+	modified := append([]byte(nil), stored...)
+	modified[encryptionChunk+100] ^= 1
+	store(modified)
+
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	ExpectThat(err, Error(HasSubstr("Decrypting chunk 1")))
+
+	// Drop the final chunk.
+	store(stored[:2*(encryptionChunk+16)])
+
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	ExpectThat(err, Error(HasSubstr("Invalid size")))
+
+	// Drop the final chunk and part of the one before.
+	store(stored[:2*(encryptionChunk+16)-10])
+
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	ExpectThat(err, Error(HasSubstr("Decrypting chunk 1")))
+}
+
+func (t *EncryptingBucketTest) UnencryptedObjects() {
+	_, err := gcsutil.CreateObject(t.ctx, t.wrapped, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq(4, o.Size)
+
+	// By default, the contents can't be read.
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	ExpectThat(err, Error(HasSubstr("not encrypted")))
+
+	// Unless we opt in.
+	t.bucket = gcs.NewEncryptingBucket(
+		t.wrapped,
+		t.keyring("a"),
+		gcs.EncryptionConfig{AllowUnencrypted: true})
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *EncryptingBucketTest) ListObjects() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("foo", listing.Objects[0].Name)
+	ExpectEq(4, listing.Objects[0].Size)
+	ExpectEq(0, len(listing.Objects[0].Metadata))
+}
+
+func (t *EncryptingBucketTest) PlaintextChecksums() {
+	contents := []byte("taco")
+	crc32c := crc32.Checksum(contents, crc32.MakeTable(crc32.Castagnoli))
+
+	// The right checksum is fine.
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: bytes.NewReader(contents),
+			CRC32C:   &crc32c,
+		})
+
+	ExpectEq(nil, err)
+
+	// The wrong one isn't, and no object is created.
+	crc32c++
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "bar",
+			Contents: bytes.NewReader(contents),
+			CRC32C:   &crc32c,
+		})
+
+	ExpectThat(err, Error(HasSubstr("CRC32C mismatch")))
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *EncryptingBucketTest) RefusedRequests() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: bytes.NewReader(nil),
+			Compress: true,
+		})
+
+	ExpectThat(err, Error(HasSubstr("Compress")))
+
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: bytes.NewReader(nil),
+			Metadata: map[string]string{"encryption-key-id": "a"},
+		})
+
+	ExpectThat(err, Error(HasSubstr("reserved")))
+
+	_, err = t.bucket.ComposeObjects(
+		t.ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName: "foo",
+			Sources: []gcs.ComposeSource{{Name: "bar"}},
+		})
+
+	ExpectThat(err, Error(HasSubstr("not supported")))
+
+	deleted := (*string)(nil)
+	_, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:     "foo",
+			Metadata: map[string]*string{"encryption-wrapped-key": deleted},
+		})
+
+	ExpectThat(err, Error(HasSubstr("reserved")))
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The environment variable consulted for the address of a GCS emulator when
+// ConnConfig.EmulatorHost is empty, as understood by Google's client
+// libraries.
+const EmulatorHostEnvVar = "STORAGE_EMULATOR_HOST"
+
+// The endpoint serving the JSON API, including uploads and downloads, unless
+// configured otherwise.
+var defaultEndpoint = &url.URL{
+	Scheme: "https",
+	Host:   "www.googleapis.com",
+}
+
+// Parse the base URL of an endpoint serving the JSON API, which must have an
+// http or https scheme and a host, and may have a base path under which the
+// API's paths are found, as for some proxies.
+func parseEndpoint(s string) (u *url.URL, err error) {
+	u, err = url.Parse(s)
+	if err != nil {
+		return
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = fmt.Errorf("Unsupported scheme %q", u.Scheme)
+		return
+	}
+
+	if u.Host == "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		err = fmt.Errorf("Expected a host and optional path, got %q", s)
+		return
+	}
+
+	return
+}
+
+// Parse the address of an emulator, which may be a bare host and port such as
+// "localhost:4443", in which case plain HTTP is assumed, or a URL accepted by
+// parseEndpoint.
+func parseEmulatorHost(s string) (u *url.URL, err error) {
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+
+	u, err = parseEndpoint(s)
+	return
+}
+
+// Return the address of the emulator configured by cfg or the environment, or
+// the empty string if none.
+func emulatorHost(cfg *ConnConfig) (host string) {
+	host = cfg.EmulatorHost
+	if host == "" {
+		host = os.Getenv(EmulatorHostEnvVar)
+	}
+
+	return
+}
+
+// Choose the endpoints for a connection with the supplied config, returning
+// nil for those that should use the default.
+func chooseEndpoints(cfg *ConnConfig) (
+	endpoint *url.URL,
+	uploadEndpoint *url.URL,
+	downloadEndpoint *url.URL,
+	err error) {
+	// An emulator, if any, changes the default.
+	if host := emulatorHost(cfg); host != "" {
+		endpoint, err = parseEmulatorHost(host)
+		if err != nil {
+			err = fmt.Errorf("EmulatorHost: %v", err)
+			return
+		}
+	}
+
+	// Explicit endpoints take precedence.
+	if cfg.Endpoint != "" {
+		endpoint, err = parseEndpoint(cfg.Endpoint)
+		if err != nil {
+			err = fmt.Errorf("Endpoint: %v", err)
+			return
+		}
+	}
+
+	uploadEndpoint = endpoint
+	if cfg.UploadEndpoint != "" {
+		uploadEndpoint, err = parseEndpoint(cfg.UploadEndpoint)
+		if err != nil {
+			err = fmt.Errorf("UploadEndpoint: %v", err)
+			return
+		}
+	}
+
+	downloadEndpoint = endpoint
+	if cfg.DownloadEndpoint != "" {
+		downloadEndpoint, err = parseEndpoint(cfg.DownloadEndpoint)
+		if err != nil {
+			err = fmt.Errorf("DownloadEndpoint: %v", err)
+			return
+		}
+	}
+
+	return
+}
+
+// Return a URL for the JSON API, with the given already-escaped path relative
+// to the supplied endpoint. A nil endpoint means the default.
+func makeURL(endpoint *url.URL, path string, query url.Values) (u *url.URL) {
+	if endpoint == nil {
+		endpoint = defaultEndpoint
+	}
+
+	u = &url.URL{
+		Scheme: endpoint.Scheme,
+		Host:   endpoint.Host,
+		Opaque: fmt.Sprintf(
+			"//%s%s%s",
+			endpoint.Host,
+			strings.TrimSuffix(endpoint.EscapedPath(), "/"),
+			path),
+		RawQuery: query.Encode(),
+	}
+
+	return
+}
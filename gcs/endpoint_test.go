@@ -0,0 +1,244 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestEndpoint(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type EndpointTest struct {
+	ctx    context.Context
+	server *httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request // GUARDED_BY(mu)
+}
+
+var _ SetUpInterface = &EndpointTest{}
+var _ TearDownInterface = &EndpointTest{}
+
+func init() { RegisterTestSuite(&EndpointTest{}) }
+
+func (t *EndpointTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.server = httptest.NewServer(http.HandlerFunc(t.serveHTTP))
+}
+
+func (t *EndpointTest) TearDown() {
+	t.server.Close()
+}
+
+// A minimal emulator that serves empty listings and objects containing
+// "taco".
+func (t *EndpointTest) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	t.requests = append(t.requests, r)
+	t.mu.Unlock()
+
+	if r.URL.Query().Get("alt") == "media" {
+		w.Write([]byte("taco"))
+		return
+	}
+
+	w.Write([]byte("{}"))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *EndpointTest) ParseEmulatorHost() {
+	testCases := []struct {
+		in       string
+		expected string
+	}{
+		{"localhost:4443", "http://localhost:4443"},
+		{"http://localhost:4443", "http://localhost:4443"},
+		{"https://emulator.example.com/base", "https://emulator.example.com/base"},
+	}
+
+	for _, tc := range testCases {
+		u, err := parseEmulatorHost(tc.in)
+		AssertEq(nil, err, "%q", tc.in)
+		ExpectEq(tc.expected, u.String(), "%q", tc.in)
+	}
+
+	for _, in := range []string{"ftp://localhost", "http://", "localhost?foo=bar"} {
+		_, err := parseEmulatorHost(in)
+		ExpectNe(nil, err, "%q", in)
+	}
+}
+
+func (t *EndpointTest) MakeURL() {
+	query := url.Values{"alt": {"media"}}
+
+	// The default.
+	u := makeURL(nil, "/storage/v1/b/foo%2Fbar", query)
+	ExpectEq("https", u.Scheme)
+	ExpectEq("www.googleapis.com", u.Host)
+	ExpectEq("//www.googleapis.com/storage/v1/b/foo%2Fbar", u.Opaque)
+	ExpectEq("alt=media", u.RawQuery)
+
+	// An emulator with a base path.
+	endpoint, err := parseEmulatorHost("localhost:4443/base/")
+	AssertEq(nil, err)
+
+	u = makeURL(endpoint, "/storage/v1/b/foo%2Fbar", query)
+	ExpectEq("http://localhost:4443/base/storage/v1/b/foo%2Fbar?alt=media", u.String())
+}
+
+func (t *EndpointTest) Emulator() {
+	conn, err := NewConn(&ConnConfig{EmulatorHost: t.server.URL})
+	AssertEq(nil, err)
+
+	b, err := conn.OpenBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	rc, err := b.NewReader(t.ctx, &ReadObjectRequest{Name: "foo/bar"})
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(rc)
+	AssertEq(nil, err)
+	AssertEq(nil, rc.Close())
+	ExpectEq("taco", string(contents))
+
+	// Both the listing made by OpenBucket and the read should have gone to the
+	// emulator, without credentials.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	AssertEq(2, len(t.requests))
+	ExpectEq("/storage/v1/b/some_bucket/o", t.requests[0].URL.EscapedPath())
+	ExpectEq(
+		"/download/storage/v1/b/some_bucket/o/foo%2Fbar",
+		t.requests[1].URL.EscapedPath())
+
+	for _, r := range t.requests {
+		ExpectEq("", r.Header.Get("Authorization"))
+	}
+}
+
+func (t *EndpointTest) EnvironmentVariable() {
+	os.Setenv(EmulatorHostEnvVar, t.server.URL)
+	defer os.Unsetenv(EmulatorHostEnvVar)
+
+	conn, err := NewConn(&ConnConfig{})
+	AssertEq(nil, err)
+
+	_, err = conn.OpenBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ExpectEq(1, len(t.requests))
+}
+
+func (t *EndpointTest) TokenSourceRequiredWithoutEmulator() {
+	os.Unsetenv(EmulatorHostEnvVar)
+
+	_, err := NewConn(&ConnConfig{})
+	ExpectThat(err, Error(HasSubstr("TokenSource")))
+}
+
+func (t *EndpointTest) ChooseEndpoints() {
+	os.Unsetenv(EmulatorHostEnvVar)
+
+	// Defaults.
+	e, u, d, err := chooseEndpoints(&ConnConfig{})
+	AssertEq(nil, err)
+	ExpectEq(nil, e)
+	ExpectEq(nil, u)
+	ExpectEq(nil, d)
+
+	// Uploads and downloads follow the main endpoint, which takes precedence
+	// over an emulator.
+	e, u, d, err = chooseEndpoints(&ConnConfig{
+		EmulatorHost: "localhost:4443",
+		Endpoint:     "https://restricted.googleapis.com",
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("https://restricted.googleapis.com", e.String())
+	ExpectEq("https://restricted.googleapis.com", u.String())
+	ExpectEq("https://restricted.googleapis.com", d.String())
+
+	// Each can be set separately.
+	e, u, d, err = chooseEndpoints(&ConnConfig{
+		UploadEndpoint:   "https://upload.example.com",
+		DownloadEndpoint: "https://download.example.com/gcs",
+	})
+
+	AssertEq(nil, err)
+	ExpectEq(nil, e)
+	ExpectEq("https://upload.example.com", u.String())
+	ExpectEq("https://download.example.com/gcs", d.String())
+
+	// Unlike emulator addresses, endpoints must have a scheme.
+	_, _, _, err = chooseEndpoints(&ConnConfig{Endpoint: "restricted.googleapis.com"})
+	ExpectThat(err, Error(HasSubstr("Endpoint")))
+
+	_, _, _, err = chooseEndpoints(&ConnConfig{UploadEndpoint: "ftp://foo"})
+	ExpectThat(err, Error(HasSubstr("UploadEndpoint")))
+}
+
+func (t *EndpointTest) SeparateEndpoints() {
+	os.Unsetenv(EmulatorHostEnvVar)
+
+	conn, err := NewConn(&ConnConfig{
+		TokenSource:      oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"}),
+		Endpoint:         t.server.URL + "/api",
+		DownloadEndpoint: t.server.URL + "/dl/",
+	})
+
+	AssertEq(nil, err)
+
+	b, err := conn.OpenBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	rc, err := b.NewReader(t.ctx, &ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	AssertEq(nil, rc.Close())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	AssertEq(2, len(t.requests))
+	ExpectEq("/api/storage/v1/b/some_bucket/o", t.requests[0].URL.EscapedPath())
+	ExpectEq(
+		"/dl/download/storage/v1/b/some_bucket/o/foo",
+		t.requests[1].URL.EscapedPath())
+
+	for _, r := range t.requests {
+		ExpectEq("Bearer tok", r.Header.Get("Authorization"))
+	}
+}
@@ -14,7 +14,12 @@
 
 package gcs
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
 
 // A *NotFoundError value is an error that indicates an object name or a
 // particular generation for that name were not found.
@@ -35,3 +40,134 @@ type PreconditionError struct {
 func (pe *PreconditionError) Error() string {
 	return fmt.Sprintf("gcs.PreconditionError: %v", pe.Err)
 }
+
+// A *ChecksumMismatchError value is an error that indicates that the contents
+// read for an object didn't match the checksums in its metadata.
+type ChecksumMismatchError struct {
+	Err error
+}
+
+func (cme *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("gcs.ChecksumMismatchError: %v", cme.Err)
+}
+
+// A *ReadOnlyError value is an error that indicates that a mutating operation
+// was refused by a bucket created with NewReadOnlyBucket.
+type ReadOnlyError struct {
+	Err error
+}
+
+func (roe *ReadOnlyError) Error() string {
+	return fmt.Sprintf("gcs.ReadOnlyError: %v", roe.Err)
+}
+
+// A *RateLimitError value is an error that indicates that GCS rejected a
+// request because too many have been made recently, for example with HTTP 429.
+// Such requests may be retried after backing off.
+type RateLimitError struct {
+	Err error
+}
+
+func (rle *RateLimitError) Error() string {
+	return fmt.Sprintf("gcs.RateLimitError: %v", rle.Err)
+}
+
+// A *QuotaError value is an error that indicates that a quota (for example a
+// daily one) has been exhausted. Unlike rate limiting, retrying soon is
+// unlikely to help.
+type QuotaError struct {
+	Err error
+}
+
+func (qe *QuotaError) Error() string {
+	return fmt.Sprintf("gcs.QuotaError: %v", qe.Err)
+}
+
+// A *ForbiddenError value is an error that indicates that the credentials in
+// use don't grant permission for the operation (HTTP 403).
+type ForbiddenError struct {
+	Err error
+}
+
+func (fe *ForbiddenError) Error() string {
+	return fmt.Sprintf("gcs.ForbiddenError: %v", fe.Err)
+}
+
+// A *StalledReadError value is an error that indicates that a reader returned
+// by Bucket.NewReader was aborted because no data arrived within the
+// ReadObjectRequest's IdleTimeout, for example because the connection died.
+type StalledReadError struct {
+	Err error
+}
+
+func (sre *StalledReadError) Error() string {
+	return fmt.Sprintf("gcs.StalledReadError: %v", sre.Err)
+}
+
+// An *InvalidNameError value is an error that indicates that an object or
+// bucket name doesn't satisfy GCS's naming rules.
+type InvalidNameError struct {
+	Err error
+}
+
+func (ine *InvalidNameError) Error() string {
+	return fmt.Sprintf("gcs.InvalidNameError: %v", ine.Err)
+}
+
+// IsTransient returns true if the supplied error is of a kind that may go away
+// if the operation is retried after a delay: server errors, rate limiting, and
+// network errors that tend to show up under load. Retry policies applied by
+// NewRetryBucket use the same classification.
+func IsTransient(err error) bool {
+	return shouldRetry(err)
+}
+
+// Reasons in the errors list of an HTTP 403 response that indicate rate
+// limiting or an exhausted quota rather than lack of permission. Cf.
+// https://cloud.google.com/storage/docs/json_api/v1/status-codes
+var (
+	rateLimitReasons = map[string]bool{
+		"rateLimitExceeded":     true,
+		"userRateLimitExceeded": true,
+	}
+
+	quotaReasons = map[string]bool{
+		"quotaExceeded":      true,
+		"dailyLimitExceeded": true,
+	}
+)
+
+// Like googleapi.CheckResponse, but translate HTTP 403 and 429 errors into
+// the typed errors above. Other errors are returned as *googleapi.Error, for
+// the caller to handle those with method-specific meaning.
+func checkResponse(res *http.Response) (err error) {
+	err = googleapi.CheckResponse(res)
+
+	typed, ok := err.(*googleapi.Error)
+	if !ok {
+		return
+	}
+
+	// Make it possible to correlate the error with the caller's logs.
+	if res.Request != nil {
+		typed.Message = annotateRequestID(res.Request.Context(), typed.Message)
+	}
+
+	switch typed.Code {
+	case http.StatusTooManyRequests:
+		err = &RateLimitError{Err: typed}
+
+	case http.StatusForbidden:
+		err = &ForbiddenError{Err: typed}
+		for _, item := range typed.Errors {
+			switch {
+			case rateLimitReasons[item.Reason]:
+				err = &RateLimitError{Err: typed}
+			case quotaReasons[item.Reason]:
+				err = &QuotaError{Err: typed}
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestErrors(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ErrorsTest struct {
+}
+
+func init() { RegisterTestSuite(&ErrorsTest{}) }
+
+// Call checkResponse for a response with the given status code and JSON
+// error reason.
+func checkResponseFor(code int, reason string) error {
+	body := fmt.Sprintf(
+		`{"error": {"code": %d, "message": "taco", "errors": [{"reason": %q}]}}`,
+		code,
+		reason)
+
+	return checkResponse(&http.Response{
+		StatusCode: code,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	})
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ErrorsTest) Success() {
+	err := checkResponse(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	})
+
+	ExpectEq(nil, err)
+}
+
+func (t *ErrorsTest) TooManyRequests() {
+	err := checkResponseFor(http.StatusTooManyRequests, "rateLimitExceeded")
+	ExpectThat(err, HasSameTypeAs(&RateLimitError{}))
+	ExpectThat(err, Error(HasSubstr("taco")))
+	ExpectTrue(IsTransient(err))
+}
+
+func (t *ErrorsTest) Forbidden() {
+	err := checkResponseFor(http.StatusForbidden, "forbidden")
+	ExpectThat(err, HasSameTypeAs(&ForbiddenError{}))
+	ExpectThat(err, Error(HasSubstr("taco")))
+	ExpectFalse(IsTransient(err))
+}
+
+func (t *ErrorsTest) Forbidden_RateLimited() {
+	err := checkResponseFor(http.StatusForbidden, "userRateLimitExceeded")
+	ExpectThat(err, HasSameTypeAs(&RateLimitError{}))
+	ExpectTrue(IsTransient(err))
+}
+
+func (t *ErrorsTest) Forbidden_QuotaExceeded() {
+	err := checkResponseFor(http.StatusForbidden, "dailyLimitExceeded")
+	ExpectThat(err, HasSameTypeAs(&QuotaError{}))
+	ExpectFalse(IsTransient(err))
+}
+
+func (t *ErrorsTest) NotFound() {
+	// Left for the caller to handle.
+	err := checkResponseFor(http.StatusNotFound, "notFound")
+	ExpectThat(err, HasSameTypeAs(&googleapi.Error{}))
+	ExpectFalse(IsTransient(err))
+}
+
+func (t *ErrorsTest) ServerError() {
+	err := checkResponseFor(http.StatusServiceUnavailable, "backendError")
+	ExpectThat(err, HasSameTypeAs(&googleapi.Error{}))
+	ExpectTrue(IsTransient(err))
+}
+
+func (t *ErrorsTest) IsTransient_Other() {
+	ExpectFalse(IsTransient(nil))
+	ExpectFalse(IsTransient(errors.New("taco")))
+	ExpectFalse(IsTransient(&NotFoundError{}))
+	ExpectTrue(IsTransient(io.ErrUnexpectedEOF))
+	ExpectTrue(IsTransient(&StalledReadError{}))
+}
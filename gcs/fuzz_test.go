@@ -0,0 +1,195 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// Object names and metadata that have caused or could plausibly cause
+// trouble when encoded into URLs and JSON bodies. The fuzzer mutates these.
+var fuzzSeedStrings = []string{
+	"foo",
+	"foo/bar",
+	"foo//bar/",
+	"%",
+	"%2F",
+	"%252F",
+	"%zz",
+	"foo+bar baz",
+	"foo?bar#baz&qux=1",
+	"./../..",
+	"\\",
+	"\x00",
+	"\"</script>",
+	"\\u0000",
+	"\u00e9",
+	"e\u0301",
+	"\ufeff\u200b\u202e",
+	"\u2028\u2029",
+	"\uff0f\uff05",
+	"\U0001f600",
+	"\U0010ffff",
+	"foo\xff",
+	strings.Repeat("%", MaxObjectNameLength),
+}
+
+func newFuzzBucket() *bucket {
+	return newBucket(
+		&http.Client{},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+// Object names must survive the trip into a JSON API URL and back out of it
+// as a server would parse the request, and into a public URL path.
+func FuzzObjectNameURL(f *testing.F) {
+	for _, s := range fuzzSeedStrings {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		if CheckObjectName(name) != nil {
+			t.Skip("Illegal name")
+		}
+
+		b := newFuzzBucket()
+		httpReq, err := b.makeStatObjectRequest(
+			context.Background(),
+			&StatObjectRequest{Name: name})
+
+		if err != nil {
+			t.Fatalf("makeStatObjectRequest: %v", err)
+		}
+
+		// Parse the URL as it appears on the wire.
+		u, err := url.ParseRequestURI(httpReq.URL.RequestURI())
+		if err != nil {
+			t.Fatalf("ParseRequestURI(%q): %v", httpReq.URL.RequestURI(), err)
+		}
+
+		const prefix = "/storage/v1/b/some_bucket/o/"
+		escaped := u.EscapedPath()
+		if !strings.HasPrefix(escaped, prefix) {
+			t.Fatalf("Path %q lacks prefix %q", escaped, prefix)
+		}
+
+		segment := strings.TrimPrefix(escaped, prefix)
+		if strings.Contains(segment, "/") {
+			t.Fatalf("Unescaped slash in segment %q", segment)
+		}
+
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			t.Fatalf("PathUnescape(%q): %v", segment, err)
+		}
+
+		if decoded != name {
+			t.Fatalf("Decoded %q, want %q", decoded, name)
+		}
+
+		// The public URL path keeps slashes, but must otherwise round trip.
+		p := encodeObjectNamePath(name)
+		if strings.Count(p, "/") != strings.Count(name, "/") {
+			t.Fatalf("encodeObjectNamePath(%q) = %q: slashes changed", name, p)
+		}
+
+		decoded, err = url.PathUnescape(p)
+		if err != nil {
+			t.Fatalf("PathUnescape(%q): %v", p, err)
+		}
+
+		if decoded != name {
+			t.Fatalf("encodeObjectNamePath: decoded %q, want %q", decoded, name)
+		}
+	})
+}
+
+// User metadata must survive the trip into Objects.insert and Objects.patch
+// bodies intact, or be rejected if the JSON API can't carry it.
+func FuzzMetadataJSON(f *testing.F) {
+	for _, s := range fuzzSeedStrings {
+		f.Add(s, s)
+		f.Add(s, "bar")
+	}
+
+	f.Fuzz(func(t *testing.T, key string, value string) {
+		b := newFuzzBucket()
+		representable := checkMetadataEntry(key, value) == nil
+
+		// Create.
+		body, err := b.makeCreateObjectBody(
+			&CreateObjectRequest{
+				Name:     "foo",
+				Metadata: map[string]string{key: value},
+			})
+
+		if !representable {
+			if err == nil {
+				t.Fatalf("makeCreateObjectBody accepted %q: %q", key, value)
+			}
+
+			return
+		}
+
+		if err != nil {
+			t.Fatalf("makeCreateObjectBody: %v", err)
+		}
+
+		var rawObject storagev1.Object
+		if err = json.Unmarshal(body, &rawObject); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", body, err)
+		}
+
+		expected := map[string]string{key: value}
+		if !reflect.DeepEqual(rawObject.Metadata, expected) {
+			t.Fatalf("Create body metadata %q, want %q", rawObject.Metadata, expected)
+		}
+
+		// Update.
+		body, err = b.makeUpdateObjectBody(
+			&UpdateObjectRequest{
+				Name:     "foo",
+				Metadata: map[string]*string{key: &value},
+			})
+
+		if err != nil {
+			t.Fatalf("makeUpdateObjectBody: %v", err)
+		}
+
+		var patch struct {
+			Metadata map[string]string `json:"metadata"`
+		}
+
+		if err = json.Unmarshal(body, &patch); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", body, err)
+		}
+
+		if !reflect.DeepEqual(patch.Metadata, expected) {
+			t.Fatalf("Update body metadata %q, want %q", patch.Metadata, expected)
+		}
+	})
+}
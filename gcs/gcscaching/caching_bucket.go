@@ -0,0 +1,497 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcscaching
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/timeutil"
+)
+
+// The number of object records cached by buckets created with
+// NewCachingBucket.
+const cachingBucketStatCapacity = 4096
+
+// Objects larger than this are never cached by buckets created with
+// NewCachingBucket, so that a few large objects can't flush out many small
+// ones.
+const maxCachedObjectSize = 1 << 20
+
+// Create a bucket that cuts request volume for read-heavy workloads by caching
+// object records for the supplied TTL, as with NewFastStatBucket, along with
+// the contents of objects no larger than 1 MiB in an LRU cache holding at most
+// maxBytes bytes. A maxBytes of zero disables caching of contents.
+//
+// Contents are cached by name and generation, so they never go stale, but
+// reads of the latest generation of an object rely on the cached record to
+// learn what that is. Modifications made through the returned bucket take
+// effect immediately; those made elsewhere may go unnoticed for up to the TTL.
+//
+// Reads with preconditions, or that ask for a range of the object to be
+// verified, always go to the wrapped bucket.
+func NewCachingBucket(
+	wrapped gcs.Bucket,
+	ttl time.Duration,
+	maxBytes int64) (b gcs.Bucket) {
+	b = NewFastStatBucket(
+		ttl,
+		NewStatCache(cachingBucketStatCapacity),
+		timeutil.RealClock(),
+		wrapped,
+		false)
+
+	if maxBytes > 0 {
+		b = &contentCachingBucket{
+			wrapped: b,
+			cache:   newContentCache(maxBytes),
+		}
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Content cache
+////////////////////////////////////////////////////////////////////////
+
+// An LRU cache of object contents keyed by name and generation, holding at
+// most a fixed number of bytes. External synchronization is required.
+type contentCache struct {
+	maxBytes int64
+
+	// The total size of the contents in the cache.
+	//
+	// INVARIANT: size <= maxBytes
+	size int64
+
+	// Entries of type *contentEntry, most recently used first.
+	entries list.List
+
+	// The elements of entries, indexed by name and then generation.
+	index map[string]map[int64]*list.Element
+}
+
+type contentEntry struct {
+	name       string
+	generation int64
+	contents   []byte
+}
+
+func newContentCache(maxBytes int64) (cc *contentCache) {
+	cc = &contentCache{
+		maxBytes: maxBytes,
+		index:    make(map[string]map[int64]*list.Element),
+	}
+
+	return
+}
+
+func (cc *contentCache) removeElement(e *list.Element) {
+	ce := cc.entries.Remove(e).(*contentEntry)
+	cc.size -= int64(len(ce.contents))
+
+	gens := cc.index[ce.name]
+	delete(gens, ce.generation)
+	if len(gens) == 0 {
+		delete(cc.index, ce.name)
+	}
+}
+
+// Add the contents of the given generation of an object, evicting the least
+// recently used entries as necessary. Contents larger than the cache are
+// ignored.
+func (cc *contentCache) insert(name string, generation int64, contents []byte) {
+	if int64(len(contents)) > cc.maxBytes {
+		return
+	}
+
+	if e, ok := cc.index[name][generation]; ok {
+		cc.removeElement(e)
+	}
+
+	for cc.size+int64(len(contents)) > cc.maxBytes {
+		cc.removeElement(cc.entries.Back())
+	}
+
+	e := cc.entries.PushFront(&contentEntry{
+		name:       name,
+		generation: generation,
+		contents:   contents,
+	})
+
+	if cc.index[name] == nil {
+		cc.index[name] = make(map[int64]*list.Element)
+	}
+
+	cc.index[name][generation] = e
+	cc.size += int64(len(contents))
+}
+
+// Return the cached contents for the given generation of an object, if any.
+// The caller must not modify them.
+func (cc *contentCache) lookUp(
+	name string,
+	generation int64) (contents []byte, ok bool) {
+	e, ok := cc.index[name][generation]
+	if !ok {
+		return
+	}
+
+	cc.entries.MoveToFront(e)
+	contents = e.Value.(*contentEntry).contents
+	return
+}
+
+// Discard all cached generations of the named object.
+func (cc *contentCache) erase(name string) {
+	for _, e := range cc.index[name] {
+		cc.removeElement(e)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that serves reads of small objects from a contentCache, relying on
+// the wrapped bucket to cache object records.
+type contentCachingBucket struct {
+	wrapped gcs.Bucket
+
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	cache *contentCache
+}
+
+// A gcs.ReadSeekCloser that serves cached contents.
+type cachedReader struct {
+	io.ReadSeeker
+
+	// If non-nil, called with the number of bytes read so far.
+	progress  func(int64)
+	bytesRead int64
+}
+
+func (cr *cachedReader) Read(p []byte) (n int, err error) {
+	n, err = cr.ReadSeeker.Read(p)
+	if n > 0 && cr.progress != nil {
+		cr.bytesRead += int64(n)
+		cr.progress(cr.bytesRead)
+	}
+
+	return
+}
+
+func (cr *cachedReader) Close() (err error) {
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) insert(o *gcs.Object, contents []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cache.insert(o.Name, o.Generation, contents)
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) lookUp(
+	o *gcs.Object) (contents []byte, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	contents, ok = b.cache.lookUp(o.Name, o.Generation)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) invalidate(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cache.erase(name)
+}
+
+// Return the contents of the supplied generation of an object, from the cache
+// if possible and otherwise by reading them from the wrapped bucket and
+// caching them.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) getContents(
+	ctx context.Context,
+	o *gcs.Object) (contents []byte, err error) {
+	contents, ok := b.lookUp(o)
+	if ok {
+		return
+	}
+
+	// Cache the contents as stored, so that they match the object's size and
+	// checksums.
+	rc, err := b.wrapped.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:           o.Name,
+			Generation:     o.Generation,
+			ReadCompressed: true,
+		})
+
+	if err != nil {
+		return
+	}
+
+	defer rc.Close()
+
+	contents, err = ioutil.ReadAll(rc)
+	if err != nil {
+		return
+	}
+
+	b.insert(o, contents)
+	return
+}
+
+// Serve the request from the cache if it's for a small object and doesn't
+// need the wrapped bucket's attention. Return a nil reader if not.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) newCachedReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc gcs.ReadSeekCloser, err error) {
+	if req.GenerationPrecondition != nil ||
+		req.MetaGenerationPrecondition != nil ||
+		(req.VerifyChecksums && req.Range != nil) {
+		return
+	}
+
+	// Find out which generation we're dealing with and how large it is. If the
+	// caller asked for some other generation than the latest we know about, we
+	// can't tell how large it is.
+	o, err := b.wrapped.StatObject(ctx, &gcs.StatObjectRequest{Name: req.Name})
+	if err != nil {
+		return
+	}
+
+	if req.Generation != 0 && req.Generation != o.Generation {
+		return
+	}
+
+	if o.Size > maxCachedObjectSize {
+		return
+	}
+
+	// Leave decompressing encoded contents to the wrapped bucket.
+	if o.ContentEncoding != "" && !req.ReadCompressed {
+		return
+	}
+
+	contents, err := b.getContents(ctx, o)
+	if err != nil {
+		return
+	}
+
+	// Apply the requested range.
+	start, limit := uint64(0), uint64(len(contents))
+	if req.Range != nil {
+		if req.Range.Start < limit {
+			start = req.Range.Start
+		} else {
+			start = limit
+		}
+
+		if req.Range.Limit < limit {
+			limit = req.Range.Limit
+		}
+
+		if limit < start {
+			limit = start
+		}
+	}
+
+	rc = &cachedReader{
+		ReadSeeker: bytes.NewReader(contents[start:limit]),
+		progress:   req.ProgressFunc,
+	}
+
+	if req.VerifyChecksums {
+		rc = gcs.NewVerifyingReader(rc, o)
+	}
+
+	if req.ContentEncodingFunc != nil {
+		req.ContentEncodingFunc(o.ContentEncoding)
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *contentCachingBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc gcs.ReadSeekCloser, err error) {
+	// Errors from the cached path, for example because the record we have is
+	// stale, aren't final: the wrapped bucket gets the last word.
+	rc, err = b.newCachedReader(ctx, req)
+	if err == nil && rc != nil {
+		return
+	}
+
+	rc, err = b.wrapped.NewReader(ctx, req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	b.invalidate(req.Name)
+	o, err = b.wrapped.CreateObject(ctx, req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	b.invalidate(req.DstName)
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) MoveObject(
+	ctx context.Context,
+	req *gcs.MoveObjectRequest) (o *gcs.Object, err error) {
+	b.invalidate(req.SrcName)
+	b.invalidate(req.DstName)
+	o, err = b.wrapped.MoveObject(ctx, req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	b.invalidate(req.DstName)
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) RewriteObject(
+	ctx context.Context,
+	req *gcs.RewriteObjectRequest) (o *gcs.Object, err error) {
+	if req.DstBucket == "" || req.DstBucket == b.Name() {
+		b.invalidate(req.DstName)
+	}
+
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	return
+}
+
+func (b *contentCachingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *contentCachingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	listing, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *contentCachingBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	b.invalidate(req.Name)
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+func (b *contentCachingBucket) RestoreObject(
+	ctx context.Context,
+	req *gcs.RestoreObjectRequest) (o *gcs.Object, err error) {
+	b.invalidate(req.Name)
+	o, err = b.wrapped.RestoreObject(ctx, req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *contentCachingBucket) Batch(
+	ctx context.Context,
+	req *gcs.BatchRequest) (results []gcs.BatchResult, err error) {
+	for _, op := range req.Ops {
+		if op.Delete != nil {
+			b.invalidate(op.Delete.Name)
+		}
+	}
+
+	results, err = b.wrapped.Batch(ctx, req)
+	return
+}
+
+func (b *contentCachingBucket) ListObjectACLs(
+	ctx context.Context,
+	req *gcs.ListObjectACLsRequest) (rules []*gcs.ACLRule, err error) {
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *contentCachingBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *gcs.UpdateObjectACLRequest) (rule *gcs.ACLRule, err error) {
+	rule, err = b.wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *contentCachingBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *gcs.DeleteObjectACLRequest) (err error) {
+	err = b.wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *contentCachingBucket) SignedURL(
+	ctx context.Context,
+	req *gcs.SignedURLRequest) (signed string, err error) {
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
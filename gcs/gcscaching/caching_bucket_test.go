@@ -0,0 +1,214 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcscaching_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcscaching"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestCachingBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type CachingBucketTest struct {
+	ctx     context.Context
+	wrapped gcs.Bucket
+	bucket  gcs.Bucket
+
+	mu  sync.Mutex
+	ops []string // GUARDED_BY(mu)
+}
+
+var _ SetUpInterface = &CachingBucketTest{}
+
+func init() { RegisterTestSuite(&CachingBucketTest{}) }
+
+func (t *CachingBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+
+	// Record the operations that reach the fake bucket.
+	t.wrapped = gcs.NewTracingBucket(
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+		func(trace *gcs.OperationTrace) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.ops = append(t.ops, trace.Op)
+		})
+
+	t.bucket = gcscaching.NewCachingBucket(t.wrapped, time.Hour, 16)
+}
+
+func (t *CachingBucketTest) takeOps() (ops []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ops = t.ops
+	t.ops = nil
+	return
+}
+
+func (t *CachingBucketTest) create(name string, contents string) *gcs.Object {
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     name,
+			Contents: strings.NewReader(contents),
+		})
+
+	AssertEq(nil, err)
+	return o
+}
+
+func (t *CachingBucketTest) read(req *gcs.ReadObjectRequest) string {
+	rc, err := t.bucket.NewReader(t.ctx, req)
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(rc)
+	AssertEq(nil, err)
+	AssertEq(nil, rc.Close())
+
+	return string(contents)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *CachingBucketTest) StatsAreCached() {
+	t.create("foo", "taco")
+	t.takeOps()
+
+	for i := 0; i < 2; i++ {
+		o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+		AssertEq(nil, err)
+		ExpectEq(4, o.Size)
+	}
+
+	ExpectThat(t.takeOps(), ElementsAre())
+}
+
+func (t *CachingBucketTest) ContentsAreCached() {
+	o := t.create("foo", "taco")
+	t.takeOps()
+
+	ExpectEq("taco", t.read(&gcs.ReadObjectRequest{Name: "foo"}))
+	ExpectThat(t.takeOps(), ElementsAre("NewReader"))
+
+	// Whole and partial reads, of the latest or a particular generation, should
+	// now be served from the cache.
+	ExpectEq("taco", t.read(&gcs.ReadObjectRequest{Name: "foo"}))
+	ExpectEq("ac", t.read(&gcs.ReadObjectRequest{
+		Name:  "foo",
+		Range: &gcs.ByteRange{Start: 1, Limit: 3},
+	}))
+
+	ExpectEq("", t.read(&gcs.ReadObjectRequest{
+		Name:  "foo",
+		Range: &gcs.ByteRange{Start: 10, Limit: 20},
+	}))
+
+	ExpectEq("taco", t.read(&gcs.ReadObjectRequest{
+		Name:            "foo",
+		Generation:      o.Generation,
+		VerifyChecksums: true,
+	}))
+
+	ExpectThat(t.takeOps(), ElementsAre())
+}
+
+func (t *CachingBucketTest) LocalWritesInvalidate() {
+	t.create("foo", "taco")
+	ExpectEq("taco", t.read(&gcs.ReadObjectRequest{Name: "foo"}))
+
+	t.create("foo", "burrito")
+	ExpectEq("burrito", t.read(&gcs.ReadObjectRequest{Name: "foo"}))
+
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	_, err = t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *CachingBucketTest) LeastRecentlyUsedContentsAreEvicted() {
+	t.create("foo", "taco")
+	t.create("bar", "burrito")
+	t.create("baz", "enchilada")
+	t.takeOps()
+
+	// foo and bar fit together, but baz evicts foo.
+	t.read(&gcs.ReadObjectRequest{Name: "foo"})
+	t.read(&gcs.ReadObjectRequest{Name: "bar"})
+	t.read(&gcs.ReadObjectRequest{Name: "baz"})
+	ExpectThat(t.takeOps(), ElementsAre("NewReader", "NewReader", "NewReader"))
+
+	t.read(&gcs.ReadObjectRequest{Name: "bar"})
+	t.read(&gcs.ReadObjectRequest{Name: "baz"})
+	ExpectThat(t.takeOps(), ElementsAre())
+
+	t.read(&gcs.ReadObjectRequest{Name: "foo"})
+	ExpectThat(t.takeOps(), ElementsAre("NewReader"))
+}
+
+func (t *CachingBucketTest) LargeObjectsAreNotCached() {
+	t.bucket = gcscaching.NewCachingBucket(t.wrapped, time.Hour, 4<<20)
+
+	contents := string(bytes.Repeat([]byte("a"), 1<<20+1))
+	t.create("foo", contents)
+	t.takeOps()
+
+	ExpectTrue(t.read(&gcs.ReadObjectRequest{Name: "foo"}) == contents)
+	ExpectTrue(t.read(&gcs.ReadObjectRequest{Name: "foo"}) == contents)
+	ExpectThat(t.takeOps(), ElementsAre("NewReader", "NewReader"))
+}
+
+func (t *CachingBucketTest) PreconditionsBypassCache() {
+	o := t.create("foo", "taco")
+	t.read(&gcs.ReadObjectRequest{Name: "foo"})
+	t.takeOps()
+
+	t.read(&gcs.ReadObjectRequest{
+		Name:                   "foo",
+		GenerationPrecondition: &o.Generation,
+	})
+
+	ExpectThat(t.takeOps(), ElementsAre("NewReader"))
+}
+
+func (t *CachingBucketTest) ContentCachingDisabled() {
+	t.bucket = gcscaching.NewCachingBucket(t.wrapped, time.Hour, 0)
+	t.create("foo", "taco")
+	t.takeOps()
+
+	t.read(&gcs.ReadObjectRequest{Name: "foo"})
+	t.read(&gcs.ReadObjectRequest{Name: "foo"})
+	ExpectThat(t.takeOps(), ElementsAre("NewReader", "NewReader"))
+}
@@ -36,10 +36,11 @@ func NewFastStatBucket(
 	wrapped gcs.Bucket,
 	negcache bool) (b gcs.Bucket) {
 	fsb := &fastStatBucket{
-		cache:   cache,
-		clock:   clock,
-		wrapped: wrapped,
-		ttl:     ttl,
+		cache:    cache,
+		clock:    clock,
+		wrapped:  wrapped,
+		ttl:      ttl,
+		negcache: negcache,
 	}
 
 	b = fsb
@@ -141,8 +142,10 @@ func (b *fastStatBucket) CreateObject(
 		return
 	}
 
-	// Record the new object.
-	b.insert(o)
+	// Record the new object, if we were given a record for it.
+	if o != nil {
+		b.insert(o)
+	}
 
 	return
 }
@@ -189,8 +192,13 @@ func (b *fastStatBucket) ComposeObjects(
 func (b *fastStatBucket) StatObject(
 	ctx context.Context,
 	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	// A request with preconditions is asking for the object's current state,
+	// so it must go to the wrapped bucket.
+	hasPreconditions :=
+		req.GenerationPrecondition != nil || req.MetaGenerationPrecondition != nil
+
 	// Do we have an entry in the cache?
-	if hit, entry := b.lookUp(req.Name); hit {
+	if hit, entry := b.lookUp(req.Name); hit && !hasPreconditions {
 		// Negative entries result in NotFoundError.
 		if entry == nil {
 			err = &gcs.NotFoundError{
@@ -216,8 +224,10 @@ func (b *fastStatBucket) StatObject(
 		return
 	}
 
-	// Put the object in cache.
-	b.insert(o)
+	// Put the object in cache, unless it's only a partial record.
+	if len(req.Fields) == 0 {
+		b.insert(o)
+	}
 
 	return
 }
@@ -232,7 +242,26 @@ func (b *fastStatBucket) ListObjects(
 		return
 	}
 
-	// Note anything we found.
+	// Soft-deleted objects aren't live, so they mustn't be cached. Nor may
+	// partial records.
+	if req.SoftDeleted || len(req.Fields) != 0 {
+		return
+	}
+
+	// Note anything we found, except for noncurrent generations, which would
+	// shadow the live ones.
+	if req.Versions {
+		var live []*gcs.Object
+		for _, o := range listing.Objects {
+			if o.Deleted.IsZero() {
+				live = append(live, o)
+			}
+		}
+
+		b.insertMultiple(live)
+		return
+	}
+
 	b.insertMultiple(listing.Objects)
 
 	return
@@ -266,6 +295,131 @@ func (b *fastStatBucket) DeleteObject(
 	return
 }
 
+func (b *fastStatBucket) RestoreObject(
+	ctx context.Context,
+	req *gcs.RestoreObjectRequest) (o *gcs.Object, err error) {
+	// Throw away any existing record for the name.
+	b.invalidate(req.Name)
+
+	// Restore the object.
+	o, err = b.wrapped.RestoreObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// Record the new version.
+	b.insert(o)
+
+	return
+}
+
+func (b *fastStatBucket) SignedURL(
+	ctx context.Context,
+	req *gcs.SignedURLRequest) (signed string, err error) {
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
+
+func (b *fastStatBucket) ListObjectACLs(
+	ctx context.Context,
+	req *gcs.ListObjectACLsRequest) (rules []*gcs.ACLRule, err error) {
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *fastStatBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *gcs.UpdateObjectACLRequest) (rule *gcs.ACLRule, err error) {
+	// ACL changes bump the object's meta-generation.
+	b.invalidate(req.Name)
+	rule, err = b.wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *fastStatBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *gcs.DeleteObjectACLRequest) (err error) {
+	// ACL changes bump the object's meta-generation.
+	b.invalidate(req.Name)
+	err = b.wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *fastStatBucket) RewriteObject(
+	ctx context.Context,
+	req *gcs.RewriteObjectRequest) (o *gcs.Object, err error) {
+	// Objects written to other buckets are none of our concern.
+	local := req.DstBucket == "" || req.DstBucket == b.Name()
+
+	// Throw away any existing record for the destination name.
+	if local {
+		b.invalidate(req.DstName)
+	}
+
+	// Rewrite the object.
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// Record the new version.
+	if local {
+		b.insert(o)
+	}
+
+	return
+}
+
+// Stat operations within a batch are always sent to the wrapped bucket, but
+// their results are cached as with StatObject.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *fastStatBucket) Batch(
+	ctx context.Context,
+	req *gcs.BatchRequest) (results []gcs.BatchResult, err error) {
+	// Throw away any existing records for objects that may be modified.
+	for _, op := range req.Ops {
+		if op.Update != nil {
+			b.invalidate(op.Update.Name)
+		}
+
+		if op.Delete != nil {
+			b.invalidate(op.Delete.Name)
+		}
+	}
+
+	// Perform the operations.
+	results, err = b.wrapped.Batch(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// Record what we learned.
+	for i, op := range req.Ops {
+		r := results[i]
+		switch {
+		case op.Stat != nil:
+			if _, ok := r.Err.(*gcs.NotFoundError); ok && b.negcache {
+				b.addNegativeEntry(op.Stat.Name)
+			}
+
+			if r.Err == nil && len(op.Stat.Fields) == 0 {
+				b.insert(r.Object)
+			}
+
+		case op.Update != nil:
+			if r.Err == nil {
+				b.insert(r.Object)
+			}
+		}
+	}
+
+	return
+}
+
 // LOCKS_EXCLUDED(b.mu)
 func (b *fastStatBucket) MoveObject(
 	ctx context.Context,
@@ -22,6 +22,7 @@ import (
 	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/gcloud/gcs/gcscaching"
 	"github.com/jacobsa/gcloud/gcs/gcscaching/mock_gcscaching"
+	"github.com/jacobsa/gcloud/gcs/mock_gcs"
 	. "github.com/jacobsa/oglematchers"
 	. "github.com/jacobsa/oglemock"
 	. "github.com/jacobsa/ogletest"
@@ -39,7 +40,7 @@ const ttl = time.Second
 type fastStatBucketTest struct {
 	cache   mock_gcscaching.MockStatCache
 	clock   timeutil.SimulatedClock
-	wrapped gcs.MockBucket
+	wrapped mock_gcs.MockBucket
 
 	bucket gcs.Bucket
 }
@@ -50,13 +51,14 @@ func (t *fastStatBucketTest) SetUp(ti *TestInfo) {
 
 	// Set up dependencies.
 	t.cache = mock_gcscaching.NewMockStatCache(ti.MockController, "cache")
-	t.wrapped = gcs.NewMockBucket(ti.MockController, "wrapped")
+	t.wrapped = mock_gcs.NewMockBucket(ti.MockController, "wrapped")
 
 	t.bucket = gcscaching.NewFastStatBucket(
 		ttl,
 		t.cache,
 		&t.clock,
-		t.wrapped)
+		t.wrapped,
+		true)
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -134,6 +136,23 @@ func (t *CreateObjectTest) WrappedSucceeds() {
 	ExpectEq(obj, o)
 }
 
+func (t *CreateObjectTest) WrappedSucceedsWithoutRecord() {
+	// Erase
+	ExpectCall(t.cache, "Erase")(Any())
+
+	// Wrapped
+	ExpectCall(t.wrapped, "CreateObject")(Any(), Any()).
+		WillOnce(Return(nil, nil))
+
+	// Call
+	o, err := t.bucket.CreateObject(
+		nil,
+		&gcs.CreateObjectRequest{DiscardResponse: true})
+
+	AssertEq(nil, err)
+	ExpectEq(nil, o)
+}
+
 ////////////////////////////////////////////////////////////////////////
 // CopyObject
 ////////////////////////////////////////////////////////////////////////
@@ -356,6 +375,33 @@ func (t *StatObjectTest) CacheHit_Negative() {
 	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
 }
 
+func (t *StatObjectTest) CacheHit_Preconditions() {
+	const name = "taco"
+
+	// LookUp
+	cached := &gcs.Object{
+		Name:           name,
+		MetaGeneration: 1,
+	}
+
+	ExpectCall(t.cache, "LookUp")(Any(), Any()).
+		WillRepeatedly(Return(true, cached))
+
+	// Wrapped
+	ExpectCall(t.wrapped, "StatObject")(Any(), Any()).
+		WillOnce(Return(nil, &gcs.PreconditionError{}))
+
+	// Call
+	var metaGen int64 = 1
+	req := &gcs.StatObjectRequest{
+		Name:                       name,
+		MetaGenerationPrecondition: &metaGen,
+	}
+
+	_, err := t.bucket.StatObject(nil, req)
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+}
+
 func (t *StatObjectTest) CallsWrapped() {
 	const name = ""
 	req := &gcs.StatObjectRequest{
@@ -448,6 +494,33 @@ func (t *StatObjectTest) WrappedSucceeds() {
 	ExpectEq(obj, o)
 }
 
+func (t *StatObjectTest) WrappedSucceeds_PartialRecord() {
+	const name = "taco"
+
+	// LookUp
+	ExpectCall(t.cache, "LookUp")(Any(), Any()).
+		WillOnce(Return(false, nil))
+
+	// Wrapped
+	obj := &gcs.Object{
+		Name:       name,
+		Generation: 17,
+	}
+
+	ExpectCall(t.wrapped, "StatObject")(Any(), Any()).
+		WillOnce(Return(obj, nil))
+
+	// Call. The partial record should not be inserted into the cache.
+	req := &gcs.StatObjectRequest{
+		Name:   name,
+		Fields: []string{"generation"},
+	}
+
+	o, err := t.bucket.StatObject(nil, req)
+	AssertEq(nil, err)
+	ExpectEq(obj, o)
+}
+
 ////////////////////////////////////////////////////////////////////////
 // ListObjects
 ////////////////////////////////////////////////////////////////////////
@@ -505,6 +578,50 @@ func (t *ListObjectsTest) NonEmptyListing() {
 	ExpectEq(expected, listing)
 }
 
+func (t *ListObjectsTest) Versions() {
+	// Wrapped
+	o0 := &gcs.Object{Name: "taco", Generation: 1, Deleted: t.clock.Now()}
+	o1 := &gcs.Object{Name: "taco", Generation: 2}
+
+	expected := &gcs.Listing{
+		Objects: []*gcs.Object{o0, o1},
+	}
+
+	ExpectCall(t.wrapped, "ListObjects")(Any(), Any()).
+		WillOnce(Return(expected, nil))
+
+	// Insert, only for the live generation.
+	ExpectCall(t.cache, "Insert")(o1, timeutil.TimeEq(t.clock.Now().Add(ttl)))
+
+	// Call
+	listing, err := t.bucket.ListObjects(
+		nil,
+		&gcs.ListObjectsRequest{Versions: true})
+
+	AssertEq(nil, err)
+	ExpectEq(expected, listing)
+}
+
+func (t *ListObjectsTest) PartialRecords() {
+	// Wrapped
+	o0 := &gcs.Object{Name: "taco"}
+
+	expected := &gcs.Listing{
+		Objects: []*gcs.Object{o0},
+	}
+
+	ExpectCall(t.wrapped, "ListObjects")(Any(), Any()).
+		WillOnce(Return(expected, nil))
+
+	// Call. Nothing should be inserted.
+	listing, err := t.bucket.ListObjects(
+		nil,
+		&gcs.ListObjectsRequest{Fields: []string{"name"}})
+
+	AssertEq(nil, err)
+	ExpectEq(expected, listing)
+}
+
 ////////////////////////////////////////////////////////////////////////
 // UpdateObject
 ////////////////////////////////////////////////////////////////////////
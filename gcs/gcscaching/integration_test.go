@@ -62,7 +62,8 @@ func (t *IntegrationTest) SetUp(ti *TestInfo) {
 		ttl,
 		t.cache,
 		&t.clock,
-		t.wrapped)
+		t.wrapped,
+		true)
 }
 
 func (t *IntegrationTest) stat(name string) (o *gcs.Object, err error) {
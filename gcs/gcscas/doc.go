@@ -0,0 +1,26 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcscas implements a content-addressable store on top of a GCS
+// bucket, suitable for things like build artifact caches.
+//
+// Blobs are keyed by the SHA-256 hash of their contents, so storing a blob
+// that is already present costs only a metadata request, and contents are
+// verified against their key when read back. Users record which blobs they
+// need with named references, and GC deletes blobs that have none.
+//
+// Within the bucket, a store with prefix p keeps each blob in an object named
+// p + "sha256/" + key and each reference in an empty object named
+// p + "refs/" + key + "/" + ref.
+package gcscas
@@ -0,0 +1,227 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcscas
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"golang.org/x/net/context"
+)
+
+func (s *Store) refsPrefix() string {
+	return s.prefix + "refs/"
+}
+
+func (s *Store) refName(k Key, ref string) string {
+	return s.refsPrefix() + k.String() + "/" + ref
+}
+
+// Record a reference with the given name to the blob with the supplied key,
+// protecting it from GC until RemoveRef is called. References to the same
+// blob with different names are counted separately; adding a reference that
+// already exists does nothing. Return *gcs.NotFoundError if the blob isn't
+// present.
+func (s *Store) AddRef(
+	ctx context.Context,
+	k Key,
+	ref string) (err error) {
+	if ref == "" {
+		err = errors.New("Empty reference name")
+		return
+	}
+
+	// Create the reference before touching the blob. A concurrent GC that
+	// doesn't see the former will then see the blob's meta-generation change
+	// before it can delete it.
+	_, err = gcsutil.CreateObject(ctx, s.bucket, s.refName(k, ref), nil)
+	if err != nil {
+		err = fmt.Errorf("CreateObject: %v", err)
+		return
+	}
+
+	err = s.touch(ctx, k)
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		// Don't leave a dangling reference behind. If this fails, GC cleans up.
+		s.RemoveRef(ctx, k, ref)
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("UpdateObject: %v", err)
+		return
+	}
+
+	return
+}
+
+// Remove the reference with the given name to the blob with the supplied
+// key, if it exists.
+func (s *Store) RemoveRef(
+	ctx context.Context,
+	k Key,
+	ref string) (err error) {
+	err = s.bucket.DeleteObject(
+		ctx,
+		&gcs.DeleteObjectRequest{Name: s.refName(k, ref)})
+
+	return
+}
+
+// Return the names of the references to the blob with the supplied key, in
+// lexicographic order.
+func (s *Store) Refs(
+	ctx context.Context,
+	k Key) (refs []string, err error) {
+	prefix := s.refName(k, "")
+
+	objects, _, err := gcsutil.ListAll(
+		ctx,
+		s.bucket,
+		&gcs.ListObjectsRequest{Prefix: prefix})
+	if err != nil {
+		err = fmt.Errorf("ListAll: %v", err)
+		return
+	}
+
+	for _, o := range objects {
+		refs = append(refs, strings.TrimPrefix(o.Name, prefix))
+	}
+
+	return
+}
+
+// A summary of the work done by GC.
+type GCStats struct {
+	// The number of blobs examined.
+	Blobs int
+
+	// The number of blobs that were deleted.
+	Deleted int
+
+	// The number of references that were deleted because their blobs don't
+	// exist.
+	DanglingRefs int
+}
+
+// Delete blobs that have no references and haven't been stored or referenced
+// since the supplied cutoff time, along with references to blobs that don't
+// exist.
+//
+// The cutoff protects blobs that have just been stored with Put but not yet
+// referenced, so it should be well before the current time, by more than the
+// longest time a user may take between calling Put and AddRef. A blob that
+// is stored again or referenced while GC is running is never deleted.
+func (s *Store) GC(
+	ctx context.Context,
+	cutoff time.Time) (stats GCStats, err error) {
+	// List blobs before references. A reference that we don't see must have
+	// been created after we started listing references, and the blob touched
+	// after that, which changes the meta-generation we recorded.
+	blobs, _, err := gcsutil.ListAll(
+		ctx,
+		s.bucket,
+		&gcs.ListObjectsRequest{Prefix: s.prefix + "sha256/"})
+	if err != nil {
+		err = fmt.Errorf("ListAll: %v", err)
+		return
+	}
+
+	refs, _, err := gcsutil.ListAll(
+		ctx,
+		s.bucket,
+		&gcs.ListObjectsRequest{Prefix: s.refsPrefix()})
+	if err != nil {
+		err = fmt.Errorf("ListAll: %v", err)
+		return
+	}
+
+	// Which blobs are referenced?
+	referenced := make(map[string]bool)
+	for _, o := range refs {
+		k := strings.TrimPrefix(o.Name, s.refsPrefix())
+		if i := strings.Index(k, "/"); i >= 0 {
+			referenced[k[:i]] = true
+		}
+	}
+
+	// Delete the rest, if they're old enough.
+	present := make(map[string]bool)
+	for _, o := range blobs {
+		stats.Blobs++
+
+		k := strings.TrimPrefix(o.Name, s.prefix+"sha256/")
+		present[k] = true
+
+		if referenced[k] || !o.Updated.Before(cutoff) {
+			continue
+		}
+
+		err = s.bucket.DeleteObject(
+			ctx,
+			&gcs.DeleteObjectRequest{
+				Name:                       o.Name,
+				Generation:                 o.Generation,
+				MetaGenerationPrecondition: &o.MetaGeneration,
+			})
+
+		switch err.(type) {
+		case nil:
+			stats.Deleted++
+
+		case *gcs.PreconditionError:
+			// Touched since we listed it; leave it be.
+			err = nil
+
+		default:
+			err = fmt.Errorf("DeleteObject(%q): %v", o.Name, err)
+			return
+		}
+	}
+
+	// Clean up references to blobs that don't exist, which can be left behind
+	// when AddRef fails. Skip recent ones, whose blobs may be in the middle of
+	// being stored.
+	for _, o := range refs {
+		k := strings.TrimPrefix(o.Name, s.refsPrefix())
+		if i := strings.Index(k, "/"); i >= 0 {
+			k = k[:i]
+		}
+
+		if present[k] || !o.Updated.Before(cutoff) {
+			continue
+		}
+
+		err = s.bucket.DeleteObject(
+			ctx,
+			&gcs.DeleteObjectRequest{
+				Name:       o.Name,
+				Generation: o.Generation,
+			})
+
+		if err != nil {
+			err = fmt.Errorf("DeleteObject(%q): %v", o.Name, err)
+			return
+		}
+
+		stats.DanglingRefs++
+	}
+
+	return
+}
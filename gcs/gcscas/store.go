@@ -0,0 +1,251 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcscas
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"golang.org/x/net/context"
+)
+
+// A key identifying a blob by the SHA-256 hash of its contents.
+type Key [sha256.Size]byte
+
+// Return the key for a blob with the supplied contents.
+func KeyOf(contents []byte) Key {
+	return Key(sha256.Sum256(contents))
+}
+
+// Return the key in lower-case hex.
+func (k Key) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+// Parse a key in the format returned by Key.String.
+func ParseKey(s string) (k Key, err error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		err = fmt.Errorf("Invalid key %q: %v", s, err)
+		return
+	}
+
+	if len(b) != len(k) {
+		err = fmt.Errorf("Invalid key %q: wrong length", s)
+		return
+	}
+
+	copy(k[:], b)
+	return
+}
+
+// Metadata key that is updated whenever a blob is stored again or referenced,
+// so that GCS bumps its meta-generation and update time. See GC.
+const touchedKey = "gcscas-touched"
+
+// A content-addressable store within a bucket. Safe for concurrent use,
+// including by several processes sharing the same bucket and prefix.
+type Store struct {
+	bucket gcs.Bucket
+	prefix string
+}
+
+// Create a store keeping its objects in the supplied bucket, with names
+// beginning with the supplied prefix (which may be empty).
+func NewStore(bucket gcs.Bucket, prefix string) *Store {
+	return &Store{
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+func (s *Store) blobName(k Key) string {
+	return s.prefix + "sha256/" + k.String()
+}
+
+// Mark the blob as recently used, failing with *gcs.NotFoundError if it
+// doesn't exist.
+func (s *Store) touch(ctx context.Context, k Key) (err error) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err = s.bucket.UpdateObject(
+		ctx,
+		&gcs.UpdateObjectRequest{
+			Name:     s.blobName(k),
+			Metadata: map[string]*string{touchedKey: &now},
+		})
+
+	return
+}
+
+// Store a blob with the supplied contents, returning its key. If the blob is
+// already present, it is not uploaded again, and uploaded is false.
+func (s *Store) Put(
+	ctx context.Context,
+	contents []byte) (k Key, uploaded bool, err error) {
+	k = KeyOf(contents)
+
+	// Is it already there? If so, mark it as recently used so that it survives
+	// GC for long enough to be referenced.
+	err = s.touch(ctx, k)
+	if err == nil {
+		return
+	}
+
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		err = fmt.Errorf("UpdateObject: %v", err)
+		return
+	}
+
+	// Upload it, unless someone beats us to it.
+	var zero int64
+	_, err = s.bucket.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   s.blobName(k),
+			Contents:               bytes.NewReader(contents),
+			CRC32C:                 gcsutil.CRC32C(contents),
+			MD5:                    gcsutil.MD5(contents),
+			GenerationPrecondition: &zero,
+		})
+
+	switch err.(type) {
+	case nil:
+		uploaded = true
+
+	case *gcs.PreconditionError:
+		err = nil
+
+	default:
+		err = fmt.Errorf("CreateObject: %v", err)
+	}
+
+	return
+}
+
+// Return true if the blob with the supplied key is present.
+func (s *Store) Has(
+	ctx context.Context,
+	k Key) (present bool, err error) {
+	_, err = s.bucket.StatObject(
+		ctx,
+		&gcs.StatObjectRequest{Name: s.blobName(k)})
+
+	switch err.(type) {
+	case nil:
+		present = true
+
+	case *gcs.NotFoundError:
+		err = nil
+
+	default:
+		err = fmt.Errorf("StatObject: %v", err)
+	}
+
+	return
+}
+
+// A reader that hashes what it reads, returning *gcs.ChecksumMismatchError
+// instead of io.EOF if the contents don't match the expected key.
+type verifyingReader struct {
+	wrapped  io.ReadCloser
+	expected Key
+	h        hash.Hash
+}
+
+func (vr *verifyingReader) Read(p []byte) (n int, err error) {
+	n, err = vr.wrapped.Read(p)
+	vr.h.Write(p[:n])
+
+	if err == io.EOF {
+		var actual Key
+		copy(actual[:], vr.h.Sum(nil))
+
+		if actual != vr.expected {
+			err = &gcs.ChecksumMismatchError{
+				Err: fmt.Errorf(
+					"Blob %v has contents with hash %v",
+					vr.expected,
+					actual),
+			}
+		}
+	}
+
+	return
+}
+
+func (vr *verifyingReader) Close() (err error) {
+	err = vr.wrapped.Close()
+	return
+}
+
+// Return a reader for the contents of the blob with the supplied key, or
+// *gcs.NotFoundError if it isn't present. The contents are verified as they
+// are read: at the end, the reader returns *gcs.ChecksumMismatchError instead
+// of io.EOF if they don't match the key.
+func (s *Store) NewReader(
+	ctx context.Context,
+	k Key) (rc io.ReadCloser, err error) {
+	wrapped, err := s.bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{Name: s.blobName(k)})
+
+	if err != nil {
+		return
+	}
+
+	rc = &verifyingReader{
+		wrapped:  wrapped,
+		expected: k,
+		h:        sha256.New(),
+	}
+
+	return
+}
+
+// Return the contents of the blob with the supplied key, having verified
+// them. See NewReader for the errors returned.
+func (s *Store) Get(
+	ctx context.Context,
+	k Key) (contents []byte, err error) {
+	rc, err := s.NewReader(ctx, k)
+	if err != nil {
+		return
+	}
+
+	defer rc.Close()
+
+	contents, err = ioutil.ReadAll(rc)
+	return
+}
+
+// Delete the blob with the supplied key, if present, regardless of any
+// references to it. Most users should use GC instead.
+func (s *Store) Delete(
+	ctx context.Context,
+	k Key) (err error) {
+	err = s.bucket.DeleteObject(
+		ctx,
+		&gcs.DeleteObjectRequest{Name: s.blobName(k)})
+
+	return
+}
@@ -0,0 +1,213 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcscas_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcscas"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestStore(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type StoreTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+	store  *gcscas.Store
+}
+
+var _ SetUpInterface = &StoreTest{}
+
+func init() { RegisterTestSuite(&StoreTest{}) }
+
+func (t *StoreTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+	t.store = gcscas.NewStore(t.bucket, "cas/")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *StoreTest) Keys() {
+	// echo -n taco | sha256sum
+	const hexKey = "07c05679b1cfed895de0d8383a02cafb7a040d5db41878fa2c47103fe7aba541"
+
+	k, err := gcscas.ParseKey(hexKey)
+	AssertEq(nil, err)
+	ExpectEq(hexKey, k.String())
+	ExpectEq(gcscas.KeyOf([]byte("taco")), k)
+
+	_, err = gcscas.ParseKey("taco")
+	ExpectThat(err, Error(HasSubstr("Invalid key")))
+
+	_, err = gcscas.ParseKey("abcd")
+	ExpectThat(err, Error(HasSubstr("wrong length")))
+
+	ExpectEq(
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		gcscas.KeyOf(nil).String())
+}
+
+func (t *StoreTest) PutAndGet() {
+	k, uploaded, err := t.store.Put(t.ctx, []byte("taco"))
+	AssertEq(nil, err)
+	ExpectTrue(uploaded)
+	ExpectEq(gcscas.KeyOf([]byte("taco")), k)
+
+	// The object is where the package docs say.
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "cas/sha256/"+k.String())
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	// Storing it again doesn't upload anything.
+	k2, uploaded, err := t.store.Put(t.ctx, []byte("taco"))
+	AssertEq(nil, err)
+	ExpectFalse(uploaded)
+	ExpectEq(k, k2)
+
+	// Read it back.
+	present, err := t.store.Has(t.ctx, k)
+	AssertEq(nil, err)
+	ExpectTrue(present)
+
+	contents, err = t.store.Get(t.ctx, k)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *StoreTest) Missing() {
+	k := gcscas.KeyOf([]byte("taco"))
+
+	present, err := t.store.Has(t.ctx, k)
+	AssertEq(nil, err)
+	ExpectFalse(present)
+
+	_, err = t.store.Get(t.ctx, k)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *StoreTest) CorruptBlob() {
+	k := gcscas.KeyOf([]byte("taco"))
+
+	_, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		"cas/sha256/"+k.String(),
+		[]byte("burrito"))
+
+	AssertEq(nil, err)
+
+	_, err = t.store.Get(t.ctx, k)
+	ExpectThat(err, HasSameTypeAs(&gcs.ChecksumMismatchError{}))
+}
+
+func (t *StoreTest) Refs() {
+	k, _, err := t.store.Put(t.ctx, []byte("taco"))
+	AssertEq(nil, err)
+
+	AssertEq(nil, t.store.AddRef(t.ctx, k, "build/2"))
+	AssertEq(nil, t.store.AddRef(t.ctx, k, "build/1"))
+	AssertEq(nil, t.store.AddRef(t.ctx, k, "build/1"))
+
+	refs, err := t.store.Refs(t.ctx, k)
+	AssertEq(nil, err)
+	ExpectThat(refs, ElementsAre("build/1", "build/2"))
+
+	AssertEq(nil, t.store.RemoveRef(t.ctx, k, "build/1"))
+
+	refs, err = t.store.Refs(t.ctx, k)
+	AssertEq(nil, err)
+	ExpectThat(refs, ElementsAre("build/2"))
+}
+
+func (t *StoreTest) RefToMissingBlob() {
+	k := gcscas.KeyOf([]byte("taco"))
+
+	err := t.store.AddRef(t.ctx, k, "build")
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	refs, err := t.store.Refs(t.ctx, k)
+	AssertEq(nil, err)
+	ExpectEq(0, len(refs))
+}
+
+func (t *StoreTest) GC() {
+	// An old referenced blob, an old unreferenced one, and one that is old but
+	// was recently stored again.
+	referenced, _, err := t.store.Put(t.ctx, []byte("taco"))
+	AssertEq(nil, err)
+	AssertEq(nil, t.store.AddRef(t.ctx, referenced, "build"))
+
+	garbage, _, err := t.store.Put(t.ctx, []byte("burrito"))
+	AssertEq(nil, err)
+
+	reused, _, err := t.store.Put(t.ctx, []byte("enchilada"))
+	AssertEq(nil, err)
+
+	// A reference to a blob that doesn't exist.
+	dangling := gcscas.KeyOf([]byte("queso"))
+	_, err = gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		"cas/refs/"+dangling.String()+"/build",
+		nil)
+
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Hour)
+	cutoff := t.clock.Now()
+	t.clock.AdvanceTime(time.Minute)
+
+	_, _, err = t.store.Put(t.ctx, []byte("enchilada"))
+	AssertEq(nil, err)
+
+	// Collect.
+	stats, err := t.store.GC(t.ctx, cutoff)
+	AssertEq(nil, err)
+	ExpectEq(3, stats.Blobs)
+	ExpectEq(1, stats.Deleted)
+	ExpectEq(1, stats.DanglingRefs)
+
+	for _, k := range []gcscas.Key{referenced, reused} {
+		present, err := t.store.Has(t.ctx, k)
+		AssertEq(nil, err)
+		ExpectTrue(present, "%v", k)
+	}
+
+	present, err := t.store.Has(t.ctx, garbage)
+	AssertEq(nil, err)
+	ExpectFalse(present)
+
+	refs, err := t.store.Refs(t.ctx, dangling)
+	AssertEq(nil, err)
+	ExpectEq(0, len(refs))
+}
@@ -0,0 +1,344 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcschunk_test
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcschunk"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestChunk(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ChunkTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+	pool   *gcs.BufferPool
+	cfg    gcschunk.Config
+}
+
+var _ SetUpInterface = &ChunkTest{}
+
+func init() { RegisterTestSuite(&ChunkTest{}) }
+
+func (t *ChunkTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+	t.pool = gcs.NewBufferPool(0)
+	t.cfg = gcschunk.Config{
+		Bucket:      t.bucket,
+		Threshold:   10,
+		ChunkSize:   4,
+		Parallelism: 2,
+		BufferPool:  t.pool,
+	}
+}
+
+func (t *ChunkTest) create(name string, contents string) (o *gcs.Object, err error) {
+	o, err = gcschunk.CreateObject(
+		t.ctx,
+		&t.cfg,
+		&gcs.CreateObjectRequest{
+			Name:     name,
+			Contents: strings.NewReader(contents),
+		})
+
+	return
+}
+
+func (t *ChunkTest) read(name string) (contents string, err error) {
+	rc, err := gcschunk.NewReader(t.ctx, &t.cfg, name)
+	if err != nil {
+		return
+	}
+
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	contents = string(b)
+	return
+}
+
+// Return the names of all objects in the bucket.
+func (t *ChunkTest) list() (names []string) {
+	objects, _, err := gcsutil.ListAll(t.ctx, t.bucket, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+
+	return
+}
+
+// Return the generation of each chunk object of the given object.
+func (t *ChunkTest) chunkGenerations(name string) (gens map[string]int64) {
+	objects, _, err := gcsutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{Prefix: name + ".chunks/"})
+
+	AssertEq(nil, err)
+
+	gens = make(map[string]int64)
+	for _, o := range objects {
+		gens[o.Name] = o.Generation
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ChunkTest) MissingBucket() {
+	_, err := gcschunk.NewReader(t.ctx, &gcschunk.Config{}, "foo")
+	ExpectThat(err, Error(HasSubstr("must be set")))
+}
+
+func (t *ChunkTest) SmallObjectIsStoredPlainly() {
+	const contents = "0123456789"
+
+	o, err := t.create("foo", contents)
+	AssertEq(nil, err)
+	ExpectEq(len(contents), o.Size)
+
+	ExpectThat(t.list(), ElementsAre("foo"))
+
+	actual, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq(contents, string(actual))
+
+	_, m, err := gcschunk.Stat(t.ctx, &t.cfg, "foo")
+	AssertEq(nil, err)
+	ExpectEq(nil, m)
+
+	s, err := t.read("foo")
+	AssertEq(nil, err)
+	ExpectEq(contents, s)
+}
+
+func (t *ChunkTest) LargeObjectIsChunked() {
+	const contents = "abcdefghijklmnopqrstuvwxyz"
+
+	o, err := gcschunk.CreateObject(
+		t.ctx,
+		&t.cfg,
+		&gcs.CreateObjectRequest{
+			Name:        "foo",
+			Contents:    strings.NewReader(contents),
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"taco": "burrito"},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("burrito", o.Metadata["taco"])
+
+	// There should be seven chunks plus the manifest.
+	ExpectEq(8, len(t.list()))
+
+	_, m, err := gcschunk.Stat(t.ctx, &t.cfg, "foo")
+	AssertEq(nil, err)
+	AssertNe(nil, m)
+	ExpectEq(len(contents), m.Size)
+	ExpectEq("text/plain", m.ContentType)
+	AssertEq(7, len(m.Chunks))
+	ExpectEq(4, m.Chunks[0].Size)
+	ExpectEq(2, m.Chunks[6].Size)
+
+	s, err := t.read("foo")
+	AssertEq(nil, err)
+	ExpectEq(contents, s)
+}
+
+func (t *ChunkTest) RepeatedChunksAreStoredOnce() {
+	const contents = "aaaabbbbaaaabbbbaaaa"
+
+	_, err := t.create("foo", contents)
+	AssertEq(nil, err)
+	ExpectEq(2, len(t.chunkGenerations("foo")))
+
+	s, err := t.read("foo")
+	AssertEq(nil, err)
+	ExpectEq(contents, s)
+}
+
+func (t *ChunkTest) RewriteUploadsOnlyChangedChunks() {
+	_, err := t.create("foo", "aaaabbbbccccdddd")
+	AssertEq(nil, err)
+	before := t.chunkGenerations("foo")
+	AssertEq(4, len(before))
+
+	// Change the third chunk.
+	_, err = t.create("foo", "aaaabbbbCCCCdddd")
+	AssertEq(nil, err)
+	after := t.chunkGenerations("foo")
+	AssertEq(4, len(after))
+
+	var unchanged int
+	for name, gen := range after {
+		if before[name] == gen {
+			unchanged++
+		}
+	}
+
+	ExpectEq(3, unchanged)
+
+	s, err := t.read("foo")
+	AssertEq(nil, err)
+	ExpectEq("aaaabbbbCCCCdddd", s)
+}
+
+func (t *ChunkTest) ShrinkingRemovesChunks() {
+	_, err := t.create("foo", "aaaabbbbccccdddd")
+	AssertEq(nil, err)
+
+	_, err = t.create("foo", "small")
+	AssertEq(nil, err)
+
+	ExpectThat(t.list(), ElementsAre("foo"))
+
+	s, err := t.read("foo")
+	AssertEq(nil, err)
+	ExpectEq("small", s)
+}
+
+func (t *ChunkTest) CorruptChunk() {
+	_, err := t.create("foo", "aaaabbbbccccdddd")
+	AssertEq(nil, err)
+
+	// Overwrite one of the chunks.
+	for name := range t.chunkGenerations("foo") {
+		_, err = gcsutil.CreateObject(t.ctx, t.bucket, name, []byte("xxxx"))
+		AssertEq(nil, err)
+		break
+	}
+
+	_, err = t.read("foo")
+	ExpectThat(err, Error(HasSubstr("ChecksumMismatchError")))
+}
+
+func (t *ChunkTest) MissingObject() {
+	_, err := t.read("foo")
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *ChunkTest) Delete() {
+	_, err := t.create("foo", "aaaabbbbccccdddd")
+	AssertEq(nil, err)
+
+	_, err = t.create("bar", "small")
+	AssertEq(nil, err)
+
+	AssertEq(nil, gcschunk.DeleteObject(t.ctx, &t.cfg, "foo"))
+	AssertEq(nil, gcschunk.DeleteObject(t.ctx, &t.cfg, "bar"))
+	AssertEq(nil, gcschunk.DeleteObject(t.ctx, &t.cfg, "baz"))
+
+	ExpectEq(0, len(t.list()))
+}
+
+func (t *ChunkTest) EarlyClose() {
+	_, err := t.create("foo", "aaaabbbbccccddddeeeeffff")
+	AssertEq(nil, err)
+
+	rc, err := gcschunk.NewReader(t.ctx, &t.cfg, "foo")
+	AssertEq(nil, err)
+
+	buf := make([]byte, 2)
+	_, err = rc.Read(buf)
+	AssertEq(nil, err)
+	ExpectEq("aa", string(buf))
+
+	ExpectEq(nil, rc.Close())
+
+	// The buffers of the chunks fetched ahead have been returned.
+	ExpectEq(0, t.pool.InUse())
+}
+
+func (t *ChunkTest) BuffersComeFromPool() {
+	// Allow a single chunk at a time.
+	t.cfg.BufferPool = gcs.NewBufferPool(4)
+
+	_, err := t.create("foo", "aaaabbbbccccddddeeeeffff")
+	AssertEq(nil, err)
+	ExpectEq(0, t.cfg.BufferPool.InUse())
+
+	s, err := t.read("foo")
+	AssertEq(nil, err)
+	ExpectEq("aaaabbbbccccddddeeeeffff", s)
+	ExpectEq(0, t.cfg.BufferPool.InUse())
+}
+
+func (t *ChunkTest) ChunkLargerThanPoolLimit() {
+	t.cfg.BufferPool = gcs.NewBufferPool(3)
+
+	_, err := t.create("foo", "aaaabbbbccccdddd")
+	ExpectThat(err, Error(HasSubstr("limited to 3")))
+}
+
+func (t *ChunkTest) Cancellation() {
+	_, err := t.create("foo", "aaaabbbbccccddddeeeeffff")
+	AssertEq(nil, err)
+
+	// Fetch one chunk at a time, and cancel once the first has arrived.
+	t.cfg.Parallelism = 1
+	ctx, cancel := context.WithCancel(t.ctx)
+	defer cancel()
+
+	rc, err := gcschunk.NewReader(ctx, &t.cfg, "foo")
+	AssertEq(nil, err)
+	defer rc.Close()
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(rc, buf)
+	AssertEq(nil, err)
+	ExpectEq("aaaa", string(buf))
+
+	cancel()
+
+	// Reading the rest should fail rather than hang.
+	done := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(rc)
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		ExpectThat(err, Error(HasSubstr("context canceled")))
+
+	case <-time.After(10 * time.Second):
+		AddFailure("Read hung after cancellation")
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcschunk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"golang.org/x/net/context"
+)
+
+// Options accepted by the functions in this package.
+type Config struct {
+	// The bucket holding the objects. Must be set.
+	Bucket gcs.Bucket
+
+	// Contents larger than this many bytes are chunked. Up to this much is
+	// buffered in memory while deciding. If zero, 64 MiB is used.
+	Threshold int64
+
+	// The size in bytes of each chunk but the last. A chunk is buffered in
+	// memory while it is uploaded. If zero, 16 MiB is used.
+	ChunkSize int64
+
+	// The maximum number of chunks that NewReader fetches, and holds in memory,
+	// at once. If zero, 4 is used.
+	Parallelism int
+
+	// The pool from which to obtain the buffers holding chunks, both while they
+	// are uploaded and while NewReader's readers prefetch them. If nil, a
+	// shared pool without a limit is used.
+	BufferPool *gcs.BufferPool
+}
+
+// The pool used by configs that don't specify one.
+var defaultBufferPool = gcs.NewBufferPool(0)
+
+// Return a copy of the supplied config with defaults filled in.
+func withDefaults(in *Config) (cfg Config, err error) {
+	cfg = *in
+
+	if cfg.Bucket == nil {
+		err = errors.New("Config.Bucket must be set")
+		return
+	}
+
+	if cfg.Threshold == 0 {
+		cfg.Threshold = 64 << 20
+	}
+
+	if cfg.ChunkSize == 0 {
+		cfg.ChunkSize = 16 << 20
+	}
+
+	if cfg.Parallelism == 0 {
+		cfg.Parallelism = 4
+	}
+
+	if cfg.BufferPool == nil {
+		cfg.BufferPool = defaultBufferPool
+	}
+
+	if cfg.Threshold < 0 || cfg.ChunkSize < 0 || cfg.Parallelism < 0 {
+		err = errors.New("Config fields must not be negative")
+		return
+	}
+
+	return
+}
+
+// Return the SHA-256 hashes of the chunks of the object with the given name,
+// which are empty if it isn't a chunked object or doesn't exist.
+func existingChunks(
+	ctx context.Context,
+	cfg *Config,
+	name string) (chunks map[string]bool, err error) {
+	chunks = make(map[string]bool)
+
+	_, m, err := Stat(ctx, cfg, name)
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		err = nil
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	if m != nil {
+		for _, c := range m.Chunks {
+			chunks[c.SHA256] = true
+		}
+	}
+
+	return
+}
+
+// Upload the contents of r as chunks for the object with the given name,
+// skipping those in the supplied set and those that already exist. Adds the
+// uploaded chunks to the set.
+func uploadChunks(
+	ctx context.Context,
+	cfg *Config,
+	name string,
+	r io.Reader,
+	present map[string]bool) (m *Manifest, err error) {
+	m = new(Manifest)
+
+	buf, err := cfg.BufferPool.Get(ctx, int(cfg.ChunkSize))
+	if err != nil {
+		err = fmt.Errorf("BufferPool.Get: %v", err)
+		return
+	}
+
+	defer cfg.BufferPool.Put(buf)
+
+	for {
+		// Read the next chunk.
+		var n int
+		n, err = io.ReadFull(r, buf)
+		switch {
+		case err == io.EOF:
+			err = nil
+			return
+
+		case err == io.ErrUnexpectedEOF:
+			err = nil
+
+		case err != nil:
+			err = fmt.Errorf("ReadFull: %v", err)
+			return
+		}
+
+		contents := buf[:n]
+		sum := sha256.Sum256(contents)
+		c := Chunk{
+			SHA256: hex.EncodeToString(sum[:]),
+			Size:   int64(n),
+		}
+
+		m.Size += c.Size
+		m.Chunks = append(m.Chunks, c)
+
+		// Upload it if necessary.
+		if !present[c.SHA256] {
+			var exists bool
+			exists, err = gcsutil.ObjectExists(ctx, cfg.Bucket, chunkName(name, c))
+			if err == nil && !exists {
+				_, err = cfg.Bucket.CreateObject(
+					ctx,
+					&gcs.CreateObjectRequest{
+						Name:     chunkName(name, c),
+						Contents: bytes.NewReader(contents),
+						CRC32C:   gcsutil.CRC32C(contents),
+						MD5:      gcsutil.MD5(contents),
+					})
+			}
+
+			if err != nil {
+				err = fmt.Errorf("Uploading chunk %d: %v", len(m.Chunks)-1, err)
+				return
+			}
+
+			present[c.SHA256] = true
+		}
+
+		if n < len(buf) {
+			return
+		}
+	}
+}
+
+// Store the contents supplied by the request under the requested name, as a
+// chunked object if they are larger than cfg.Threshold and as an ordinary
+// object otherwise. The other fields of the request apply to the object stored
+// under that name, which for chunked objects is the manifest; the content type
+// is recorded in the manifest.
+//
+// Chunks of a previous chunked object with the same name that are no longer
+// needed are deleted afterward. If that fails, the error is returned along
+// with the new object.
+func CreateObject(
+	ctx context.Context,
+	cfg *Config,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	c, err := withDefaults(cfg)
+	if err != nil {
+		return
+	}
+
+	// Find out which chunks already exist, so that we needn't upload them again
+	// and can clean up those that are no longer needed.
+	old, err := existingChunks(ctx, &c, req.Name)
+	if err != nil {
+		return
+	}
+
+	// Read enough to decide whether to chunk.
+	head, err := ioutil.ReadAll(io.LimitReader(req.Contents, c.Threshold+1))
+	if err != nil {
+		err = fmt.Errorf("ReadAll: %v", err)
+		return
+	}
+
+	needed := make(map[string]bool)
+	if int64(len(head)) <= c.Threshold {
+		// Small enough to store as is.
+		plain := *req
+		plain.Contents = bytes.NewReader(head)
+
+		if o, err = c.Bucket.CreateObject(ctx, &plain); err != nil {
+			return
+		}
+	} else {
+		// Upload the chunks.
+		present := make(map[string]bool)
+		for k := range old {
+			present[k] = true
+		}
+
+		var m *Manifest
+		m, err = uploadChunks(
+			ctx,
+			&c,
+			req.Name,
+			io.MultiReader(bytes.NewReader(head), req.Contents),
+			present)
+
+		if err != nil {
+			return
+		}
+
+		m.ContentType = req.ContentType
+		for _, chunk := range m.Chunks {
+			needed[chunk.SHA256] = true
+		}
+
+		// Write the manifest.
+		if o, err = writeManifest(ctx, &c, req, m); err != nil {
+			return
+		}
+	}
+
+	// Clean up.
+	for k := range old {
+		if needed[k] {
+			continue
+		}
+
+		err = c.Bucket.DeleteObject(
+			ctx,
+			&gcs.DeleteObjectRequest{Name: chunkName(req.Name, Chunk{SHA256: k})})
+
+		if err != nil {
+			err = fmt.Errorf("Deleting stale chunk: %v", err)
+			return
+		}
+	}
+
+	return
+}
+
+// Store the supplied manifest under the requested name.
+func writeManifest(
+	ctx context.Context,
+	cfg *Config,
+	req *gcs.CreateObjectRequest,
+	m *Manifest) (o *gcs.Object, err error) {
+	contents, err := json.Marshal(m)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	manifestReq := *req
+	manifestReq.Contents = bytes.NewReader(contents)
+	manifestReq.ContentType = manifestContentType
+	manifestReq.ContentEncoding = ""
+	manifestReq.CRC32C = gcsutil.CRC32C(contents)
+	manifestReq.MD5 = gcsutil.MD5(contents)
+
+	manifestReq.Metadata = map[string]string{manifestKey: manifestVersion}
+	for k, v := range req.Metadata {
+		manifestReq.Metadata[k] = v
+	}
+
+	o, err = cfg.Bucket.CreateObject(ctx, &manifestReq)
+	return
+}
+
+// Delete the object with the given name, along with its chunks if it is a
+// chunked object. It is not an error for the object not to exist.
+func DeleteObject(
+	ctx context.Context,
+	cfg *Config,
+	name string) (err error) {
+	c, err := withDefaults(cfg)
+	if err != nil {
+		return
+	}
+
+	o, m, err := Stat(ctx, &c, name)
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		err = nil
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	// Delete the object first, so that nobody sees a manifest with missing
+	// chunks.
+	err = c.Bucket.DeleteObject(
+		ctx,
+		&gcs.DeleteObjectRequest{
+			Name:       name,
+			Generation: o.Generation,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("DeleteObject: %v", err)
+		return
+	}
+
+	if m == nil {
+		return
+	}
+
+	for _, chunk := range m.Chunks {
+		err = c.Bucket.DeleteObject(
+			ctx,
+			&gcs.DeleteObjectRequest{Name: chunkName(name, chunk)})
+
+		if err != nil {
+			err = fmt.Errorf("Deleting chunk: %v", err)
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,28 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcschunk stores objects too large to comfortably upload or download
+// in one request as a set of fixed-size chunk objects plus a manifest.
+//
+// CreateObject stores contents no larger than Config.Threshold as an ordinary
+// object. Larger contents are split into chunks named
+// name + ".chunks/" + the SHA-256 of the chunk, and a small JSON manifest
+// listing them is stored under the name itself. Because chunks are named by
+// their contents, rewriting a large object only uploads the chunks that
+// changed. NewReader reads either kind of object, fetching and verifying
+// several chunks in parallel.
+//
+// Writing the same name concurrently from several places is not supported;
+// use CreateObjectRequest.GenerationPrecondition to detect it.
+package gcschunk
@@ -0,0 +1,125 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcschunk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Metadata key set on manifest objects, whose value is the version of the
+// manifest format.
+const manifestKey = "gcschunk-manifest"
+
+const manifestVersion = "1"
+
+// The content type of manifest objects.
+const manifestContentType = "application/vnd.gcschunk.manifest+json"
+
+// One piece of a chunked object.
+type Chunk struct {
+	// The SHA-256 hash of the chunk's contents, in lower-case hex.
+	SHA256 string `json:"sha256"`
+
+	// The length of the chunk in bytes.
+	Size int64 `json:"size"`
+}
+
+// A description of the chunks that make up a chunked object.
+type Manifest struct {
+	// The length of the reassembled contents in bytes.
+	Size int64 `json:"size"`
+
+	// The content type supplied when the object was created.
+	ContentType string `json:"contentType,omitempty"`
+
+	// The chunks, in order.
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Return the name of the object holding the given chunk of the object with
+// the given name.
+func chunkName(name string, c Chunk) string {
+	return name + ".chunks/" + c.SHA256
+}
+
+// Does the supplied object look like a manifest?
+func isManifest(o *gcs.Object) bool {
+	_, ok := o.Metadata[manifestKey]
+	return ok
+}
+
+// Read the manifest stored in the supplied object.
+func readManifest(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	o *gcs.Object) (m *Manifest, err error) {
+	if v := o.Metadata[manifestKey]; v != manifestVersion {
+		err = fmt.Errorf("Unsupported manifest version %q for %q", v, o.Name)
+		return
+	}
+
+	// Read the exact generation we statted, so that the manifest matches.
+	rc, err := bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       o.Name,
+			Generation: o.Generation,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	contents, err := ioutil.ReadAll(rc)
+	if err != nil {
+		err = fmt.Errorf("ReadAll: %v", err)
+		return
+	}
+
+	m = new(Manifest)
+	if err = json.Unmarshal(contents, m); err != nil {
+		err = fmt.Errorf("Parsing manifest %q: %v", o.Name, err)
+		return
+	}
+
+	return
+}
+
+// Return the record for the object with the given name, along with its
+// manifest if it is a chunked object. The logical size of the contents is
+// m.Size for chunked objects and o.Size otherwise.
+func Stat(
+	ctx context.Context,
+	cfg *Config,
+	name string) (o *gcs.Object, m *Manifest, err error) {
+	o, err = cfg.Bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: name})
+	if err != nil {
+		return
+	}
+
+	if isManifest(o) {
+		m, err = readManifest(ctx, cfg.Bucket, o)
+	}
+
+	return
+}
@@ -0,0 +1,276 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcschunk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// The outcome of fetching a chunk.
+type chunkResult struct {
+	contents []byte
+	err      error
+}
+
+// A reader that reassembles a chunked object from chunks fetched in the
+// background.
+type chunkReader struct {
+	cancel func()
+
+	// The pool from which the chunks' buffers come, and to which they are
+	// returned once consumed.
+	pool *gcs.BufferPool
+
+	// One channel per chunk, each receiving exactly one result once the chunk
+	// has been fetched.
+	results []chan chunkResult
+
+	// Holds a token for each chunk that has been dispatched but not yet
+	// consumed by Read, limiting the number held in memory.
+	sem chan struct{}
+
+	// The index of the next chunk to consume, the buffer holding the current
+	// one, and its unread remainder.
+	next   int
+	curBuf []byte
+	cur    []byte
+
+	// The first error encountered, returned by all subsequent reads.
+	err error
+}
+
+// Fetch the given chunk in full into the supplied buffer, which must be of
+// the chunk's size, verifying its contents.
+func fetchChunk(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	c Chunk,
+	buf []byte) (contents []byte, err error) {
+	rc, err := bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{Name: chunkName(name, c)})
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	// Read exactly the expected size, checking that nothing follows.
+	n, err := io.ReadFull(rc, buf)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		err = nil
+
+	case err != nil:
+		err = fmt.Errorf("ReadFull: %v", err)
+		return
+	}
+
+	extra, err := io.CopyN(ioutil.Discard, rc, 1)
+	switch {
+	case err == io.EOF:
+		err = nil
+
+	case err != nil:
+		err = fmt.Errorf("Read: %v", err)
+		return
+	}
+
+	contents = buf[:n]
+	sum := sha256.Sum256(contents)
+	if extra != 0 || int64(n) != c.Size || hex.EncodeToString(sum[:]) != c.SHA256 {
+		err = &gcs.ChecksumMismatchError{
+			Err: fmt.Errorf(
+				"Chunk %s of %q has the wrong contents",
+				c.SHA256,
+				name),
+		}
+
+		return
+	}
+
+	return
+}
+
+// Start fetching the chunks of the supplied manifest in the background.
+func newChunkReader(
+	ctx context.Context,
+	cfg *Config,
+	name string,
+	m *Manifest) (cr *chunkReader) {
+	ctx, cancel := context.WithCancel(ctx)
+	cr = &chunkReader{
+		cancel:  cancel,
+		pool:    cfg.BufferPool,
+		results: make([]chan chunkResult, len(m.Chunks)),
+		sem:     make(chan struct{}, cfg.Parallelism),
+	}
+
+	for i := range cr.results {
+		cr.results[i] = make(chan chunkResult, 1)
+	}
+
+	// Dispatch fetches in order as tokens and buffers become available. Taking
+	// buffers in order means that the chunk Read is waiting for never waits for
+	// room in the pool behind later chunks. Once cancelled, or if a buffer
+	// can't be had, fail the chunks not yet dispatched rather than leaving Read
+	// waiting for them forever.
+	go func() {
+		pool := cfg.BufferPool
+		for i, c := range m.Chunks {
+			select {
+			case cr.sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+
+			err := ctx.Err()
+
+			var buf []byte
+			if err == nil {
+				if buf, err = pool.Get(ctx, int(c.Size)); err != nil {
+					err = fmt.Errorf("BufferPool.Get: %v", err)
+				}
+			}
+
+			if err != nil {
+				for _, results := range cr.results[i:] {
+					results <- chunkResult{err: err}
+				}
+
+				return
+			}
+
+			go func(i int, c Chunk) {
+				contents, err := fetchChunk(ctx, cfg.Bucket, name, c, buf)
+				if err != nil {
+					pool.Put(buf)
+				}
+
+				cr.results[i] <- chunkResult{contents, err}
+			}(i, c)
+		}
+	}()
+
+	return
+}
+
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	for len(cr.cur) == 0 && cr.err == nil {
+		cr.releaseCurrent()
+		if cr.next == len(cr.results) {
+			cr.err = io.EOF
+			break
+		}
+
+		// Wait for the next chunk. Chunks that failed may never have been
+		// given a token, and after a failure no more are needed.
+		r := <-cr.results[cr.next]
+		cr.next++
+
+		if r.err != nil {
+			cr.err = r.err
+			break
+		}
+
+		// Let another fetch start.
+		<-cr.sem
+		cr.curBuf = r.contents
+		cr.cur = r.contents
+	}
+
+	if len(cr.cur) == 0 {
+		err = cr.err
+		return
+	}
+
+	n = copy(p, cr.cur)
+	cr.cur = cr.cur[n:]
+
+	return
+}
+
+// Return the current chunk's buffer to the pool, if any.
+func (cr *chunkReader) releaseCurrent() {
+	if cr.curBuf != nil {
+		cr.pool.Put(cr.curBuf)
+		cr.curBuf = nil
+		cr.cur = nil
+	}
+}
+
+// Cancel the fetches in flight, waiting for them so that the buffers of all
+// fetched chunks can be returned to the pool.
+func (cr *chunkReader) Close() (err error) {
+	cr.cancel()
+	cr.releaseCurrent()
+
+	for ; cr.next < len(cr.results); cr.next++ {
+		if r := <-cr.results[cr.next]; r.err == nil {
+			cr.pool.Put(r.contents)
+		}
+	}
+
+	if cr.err == nil {
+		cr.err = errors.New("Reader is closed")
+	}
+
+	return
+}
+
+// Return a reader for the contents of the object with the given name,
+// reassembling them if it is a chunked object. Chunks are fetched in parallel
+// ahead of the reader, and their contents verified.
+//
+// Fail with *gcs.NotFoundError if the object doesn't exist. Reads return
+// *gcs.ChecksumMismatchError if a chunk's contents don't match the manifest.
+func NewReader(
+	ctx context.Context,
+	cfg *Config,
+	name string) (rc io.ReadCloser, err error) {
+	c, err := withDefaults(cfg)
+	if err != nil {
+		return
+	}
+
+	o, m, err := Stat(ctx, &c, name)
+	if err != nil {
+		return
+	}
+
+	if m == nil {
+		rc, err = c.Bucket.NewReader(
+			ctx,
+			&gcs.ReadObjectRequest{
+				Name:       name,
+				Generation: o.Generation,
+			})
+
+		return
+	}
+
+	rc = newChunkReader(ctx, &c, name, m)
+	return
+}
@@ -17,6 +17,8 @@ package gcsfake
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -25,9 +27,10 @@ import (
 	"io/ioutil"
 	"sort"
 	"strings"
-	"unicode/utf8"
+	"time"
 
 	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/internal/customtime"
 	"github.com/jacobsa/syncutil"
 	"github.com/jacobsa/timeutil"
 	"golang.org/x/net/context"
@@ -35,9 +38,24 @@ import (
 
 var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
 
-// Equivalent to NewConn(clock).GetBucket(name).
+// Equivalent to NewConn(clock).OpenBucket(ctx, name), but without the need
+// for a context or the possibility of an error.
 func NewFakeBucket(clock timeutil.Clock, name string) gcs.Bucket {
-	b := &bucket{clock: clock, name: name}
+	return NewFakeBucketWithBufferPool(clock, name, nil)
+}
+
+// Like NewFakeBucket, but read the contents of new objects through buffers
+// from the supplied pool, so that concurrent uploads wait for room under its
+// limit. If pool is nil, a pool without a limit is used.
+func NewFakeBucketWithBufferPool(
+	clock timeutil.Clock,
+	name string,
+	pool *gcs.BufferPool) gcs.Bucket {
+	if pool == nil {
+		pool = gcs.NewBufferPool(0)
+	}
+
+	b := &bucket{clock: clock, name: name, bufferPool: pool}
 	b.mu = syncutil.NewInvariantMutex(b.checkInvariants)
 	return b
 }
@@ -46,9 +64,36 @@ func NewFakeBucket(clock timeutil.Clock, name string) gcs.Bucket {
 // Helper types
 ////////////////////////////////////////////////////////////////////////
 
+// A gcs.ReadSeekCloser that serves an object's contents from memory.
+type readSeekCloser struct {
+	io.ReadSeeker
+
+	// If non-nil, called with the number of bytes read so far.
+	progress  func(int64)
+	bytesRead int64
+}
+
+func (rsc *readSeekCloser) Read(p []byte) (n int, err error) {
+	n, err = rsc.ReadSeeker.Read(p)
+	if n > 0 && rsc.progress != nil {
+		rsc.bytesRead += int64(n)
+		rsc.progress(rsc.bytesRead)
+	}
+
+	return
+}
+
+func (rsc *readSeekCloser) Close() (err error) {
+	return
+}
+
 type fakeObject struct {
 	metadata gcs.Object
 	data     []byte
+
+	// The object's access control list. Never modified in place, so may be
+	// shared between copies of the struct.
+	acl []gcs.ACLRule
 }
 
 // A slice of objects compared by name.
@@ -124,9 +169,10 @@ func (s fakeObjectSlice) prefixUpperBound(prefix string) int {
 ////////////////////////////////////////////////////////////////////////
 
 type bucket struct {
-	clock timeutil.Clock
-	name  string
-	mu    syncutil.InvariantMutex
+	clock      timeutil.Clock
+	name       string
+	bufferPool *gcs.BufferPool
+	mu         syncutil.InvariantMutex
 
 	// The set of extant objects.
 	//
@@ -138,24 +184,55 @@ type bucket struct {
 	//
 	// INVARIANT: This is an upper bound for generation numbers in objects.
 	prevGeneration int64 // GUARDED_BY(mu)
+
+	// The retention period of the bucket's retention policy, as set by the
+	// fake Conn, or zero if none.
+	retentionPeriod time.Duration // GUARDED_BY(mu)
+
+	// The bucket's default KMS key, as set by the fake Conn, or empty if none.
+	defaultKMSKeyName string // GUARDED_BY(mu)
+
+	// The bucket's default object ACL, as set by the fake Conn. Never modified
+	// in place.
+	defaultObjectACL []gcs.ACLRule // GUARDED_BY(mu)
+
+	// How long deleted and overwritten objects are kept around, as set by the
+	// fake Conn, or zero if they are discarded immediately.
+	softDeleteDuration time.Duration // GUARDED_BY(mu)
+
+	// Soft-deleted generations, in order of deletion. Entries may be past their
+	// hard delete time; see pruneSoftDeletedLocked.
+	//
+	// INVARIANT: For each o, o.metadata.SoftDeleteTime is non-zero.
+	softDeleted []fakeObject // GUARDED_BY(mu)
 }
 
-func checkName(name string) (err error) {
-	if len(name) == 0 || len(name) > 1024 {
-		err = errors.New("Invalid object name: length must be in [1, 1024]")
-		return
-	}
+// Check the generation and meta-generation preconditions of a read-only
+// request against the given object.
+func checkReadPreconditions(
+	o *gcs.Object,
+	generationPrecondition *int64,
+	metaGenerationPrecondition *int64) (err error) {
+	if generationPrecondition != nil &&
+		*generationPrecondition != o.Generation {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Precondition failed: object has generation %v",
+				o.Generation),
+		}
 
-	if !utf8.ValidString(name) {
-		err = errors.New("Invalid object name: not valid UTF-8")
 		return
 	}
 
-	for _, r := range name {
-		if r == 0x0a || r == 0x0d {
-			err = errors.New("Invalid object name: must not contain CR or LF")
-			return
+	if metaGenerationPrecondition != nil &&
+		*metaGenerationPrecondition != o.MetaGeneration {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Precondition failed: object has meta-generation %v",
+				o.MetaGeneration),
 		}
+
+		return
 	}
 
 	return
@@ -186,6 +263,21 @@ func (b *bucket) checkInvariants() {
 					b.prevGeneration))
 		}
 	}
+
+	// Check soft-deleted objects.
+	for _, o := range b.softDeleted {
+		if o.metadata.SoftDeleteTime.IsZero() {
+			panic(fmt.Sprintf("Object %q has no soft delete time", o.metadata.Name))
+		}
+
+		if !(o.metadata.Generation <= b.prevGeneration) {
+			panic(
+				fmt.Sprintf(
+					"Soft-deleted generation %v exceeds %v",
+					o.metadata.Generation,
+					b.prevGeneration))
+		}
+	}
 }
 
 // Create an object struct for the given attributes and contents.
@@ -203,7 +295,7 @@ func (b *bucket) mintObject(
 		ContentType:     req.ContentType,
 		ContentLanguage: req.ContentLanguage,
 		CacheControl:    req.CacheControl,
-		Owner:           "user-fake",
+		Owner:           fakeOwner,
 		Size:            uint64(len(contents)),
 		ContentEncoding: req.ContentEncoding,
 		ComponentCount:  1,
@@ -214,12 +306,262 @@ func (b *bucket) mintObject(
 		Generation:      b.prevGeneration,
 		MetaGeneration:  1,
 		StorageClass:    "STANDARD",
+		Created:         b.clock.Now(),
 		Updated:         b.clock.Now(),
+		TemporaryHold:   req.TemporaryHold,
+		EventBasedHold:  req.EventBasedHold,
+		CustomTime:      req.CustomTime,
+		KMSKeyName:      req.KMSKeyName,
+	}
+
+	o.metadata.Etag = makeEtag(o.metadata.Generation, o.metadata.MetaGeneration)
+
+	if req.StorageClass != "" {
+		o.metadata.StorageClass = req.StorageClass
+	}
+
+	if o.metadata.KMSKeyName == "" {
+		o.metadata.KMSKeyName = b.defaultKMSKeyName
 	}
 
+	o.metadata.RetentionExpirationTime =
+		b.retentionExpirationLocked(o.metadata.Updated)
+
 	// Set up data.
 	o.data = contents
 
+	o.acl = b.initialACLLocked(o.metadata.Owner)
+
+	return
+}
+
+// Return the ACL for a new object with the given owner. Like GCS, grant the
+// owner ownership, along with whatever the bucket's default object ACL says.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) initialACLLocked(owner string) (acl []gcs.ACLRule) {
+	acl = []gcs.ACLRule{
+		{Entity: owner, Role: gcs.ACLRoleOwner},
+	}
+
+	for _, r := range b.defaultObjectACL {
+		if r.Entity != owner {
+			acl = append(acl, r)
+		}
+	}
+
+	return
+}
+
+// Return the retention expiration time for an object created at the given
+// time, or the zero time if the bucket has no retention policy.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) retentionExpirationLocked(created time.Time) (t time.Time) {
+	if b.retentionPeriod != 0 {
+		t = created.Add(b.retentionPeriod)
+	}
+
+	return
+}
+
+// Return an error of type *gcs.ForbiddenError if the object at the given index
+// may not be deleted or overwritten because of a hold or the bucket's
+// retention policy.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) checkMutableLocked(index int) (err error) {
+	md := &b.objects[index].metadata
+
+	switch {
+	case md.TemporaryHold || md.EventBasedHold:
+		err = &gcs.ForbiddenError{
+			Err: fmt.Errorf("Object %q is under hold", md.Name),
+		}
+
+	case b.clock.Now().Before(md.RetentionExpirationTime):
+		err = &gcs.ForbiddenError{
+			Err: fmt.Errorf(
+				"Object %q is subject to retention until %v",
+				md.Name,
+				md.RetentionExpirationTime),
+		}
+	}
+
+	return
+}
+
+// Set the bucket's retention period, updating the retention expiration time
+// of each existing object to match, as GCS does.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) setRetentionPeriod(period time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.retentionPeriod = period
+	for i := range b.objects {
+		md := &b.objects[i].metadata
+		md.RetentionExpirationTime = b.retentionExpirationLocked(md.Updated)
+	}
+}
+
+// Set the key recorded for new objects that don't specify their own, as GCS
+// does for buckets with a default KMS key. Existing objects are unaffected.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) setDefaultKMSKeyName(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.defaultKMSKeyName = name
+}
+
+// Set how long objects are kept after being deleted or overwritten. As with
+// GCS, objects already soft-deleted keep their original hard delete time.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) setSoftDeleteDuration(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.softDeleteDuration = d
+}
+
+// Record the supplied object, which is being deleted or overwritten, as
+// soft-deleted if the bucket has a soft delete policy.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) softDeleteLocked(o fakeObject) {
+	if b.softDeleteDuration == 0 {
+		return
+	}
+
+	now := b.clock.Now()
+	o.metadata.SoftDeleteTime = now
+	o.metadata.HardDeleteTime = now.Add(b.softDeleteDuration)
+	b.softDeleted = append(b.softDeleted, o)
+}
+
+// Permanently delete soft-deleted objects whose hard delete time has passed.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) pruneSoftDeletedLocked() {
+	now := b.clock.Now()
+
+	var kept []fakeObject
+	for _, o := range b.softDeleted {
+		if now.Before(o.metadata.HardDeleteTime) {
+			kept = append(kept, o)
+		}
+	}
+
+	b.softDeleted = kept
+}
+
+// Return the soft-deleted objects that may still be restored, sorted by name
+// and, within a name, by order of deletion.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) softDeletedObjectsLocked() (objects fakeObjectSlice) {
+	b.pruneSoftDeletedLocked()
+
+	objects = append(objects, b.softDeleted...)
+	sort.Stable(objects)
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) setDefaultObjectACL(rules []gcs.ACLRule) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.defaultObjectACL = rules
+}
+
+// Return an entity tag for the given generation and meta-generation of an
+// object. Like GCS's, it is the base64 encoding of a protocol buffer holding
+// the generation in field 1 and the meta-generation in field 2.
+func makeEtag(generation int64, metaGeneration int64) string {
+	buf := make([]byte, 0, 2+2*binary.MaxVarintLen64)
+	tmp := make([]byte, binary.MaxVarintLen64)
+
+	buf = append(buf, 0x08)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp, uint64(generation))]...)
+	buf = append(buf, 0x10)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp, uint64(metaGeneration))]...)
+
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// The owner recorded for every object.
+const fakeOwner = "user-fake"
+
+// The project entities that predefined ACLs refer to.
+const (
+	projectOwners  = "project-owners-fake"
+	projectEditors = "project-editors-fake"
+	projectViewers = "project-viewers-fake"
+)
+
+// Return the ACL that GCS gives a new object with the given owner when it is
+// created with the given predefined ACL, which must be non-empty.
+func predefinedACL(owner string, name string) (acl []gcs.ACLRule, err error) {
+	acl = []gcs.ACLRule{
+		{Entity: owner, Role: gcs.ACLRoleOwner},
+	}
+
+	switch name {
+	case gcs.PredefinedACLPrivate:
+
+	case gcs.PredefinedACLPublicRead:
+		acl = append(acl, gcs.ACLRule{Entity: "allUsers", Role: gcs.ACLRoleReader})
+
+	case gcs.PredefinedACLAuthenticatedRead:
+		acl = append(acl, gcs.ACLRule{
+			Entity: "allAuthenticatedUsers",
+			Role:   gcs.ACLRoleReader,
+		})
+
+	case gcs.PredefinedACLBucketOwnerRead:
+		acl = append(acl, gcs.ACLRule{Entity: projectOwners, Role: gcs.ACLRoleReader})
+
+	case gcs.PredefinedACLBucketOwnerFullControl:
+		acl = append(acl, gcs.ACLRule{Entity: projectOwners, Role: gcs.ACLRoleOwner})
+
+	case gcs.PredefinedACLProjectPrivate:
+		acl = append(
+			acl,
+			gcs.ACLRule{Entity: projectOwners, Role: gcs.ACLRoleOwner},
+			gcs.ACLRule{Entity: projectEditors, Role: gcs.ACLRoleOwner},
+			gcs.ACLRule{Entity: projectViewers, Role: gcs.ACLRoleReader})
+
+	default:
+		err = fmt.Errorf("Unsupported predefined ACL: %q", name)
+	}
+
+	return
+}
+
+// Return an error if the supplied rules, which are to replace an object's
+// ACL, aren't acceptable to GCS. Otherwise return a copy of them.
+func checkACL(rules []gcs.ACLRule) (acl []gcs.ACLRule, err error) {
+	acl = make([]gcs.ACLRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Entity == "" {
+			err = errors.New("Entity must be specified")
+			return
+		}
+
+		if r.Role != gcs.ACLRoleReader && r.Role != gcs.ACLRoleOwner {
+			err = fmt.Errorf("Unsupported role: %q", r.Role)
+			return
+		}
+
+		acl = append(acl, gcs.ACLRule{Entity: r.Entity, Role: r.Role})
+	}
+
 	return
 }
 
@@ -227,11 +569,19 @@ func (b *bucket) mintObject(
 func (b *bucket) createObjectLocked(
 	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
 	// Check that the name is legal.
-	err = checkName(req.Name)
+	err = gcs.CheckObjectName(req.Name)
 	if err != nil {
 		return
 	}
 
+	// Check the predefined ACL, if any.
+	var acl []gcs.ACLRule
+	if req.PredefinedACL != "" {
+		if acl, err = predefinedACL(fakeOwner, req.PredefinedACL); err != nil {
+			return
+		}
+	}
+
 	// Snarf the contents.
 	contents, err := ioutil.ReadAll(req.Contents)
 	if err != nil {
@@ -239,6 +589,10 @@ func (b *bucket) createObjectLocked(
 		return
 	}
 
+	if req.ProgressFunc != nil {
+		req.ProgressFunc(int64(len(contents)))
+	}
+
 	// Find any existing record for this name.
 	existingIndex := b.objects.find(req.Name)
 
@@ -326,12 +680,24 @@ func (b *bucket) createObjectLocked(
 		}
 	}
 
+	// Refuse to overwrite objects that are held or retained.
+	if existingRecord != nil {
+		if err = b.checkMutableLocked(existingIndex); err != nil {
+			return
+		}
+	}
+
 	// Create an object record from the given attributes.
 	var fo fakeObject = b.mintObject(req, contents)
+	if acl != nil {
+		fo.acl = acl
+	}
+
 	o = &fo.metadata
 
 	// Replace an entry in or add an entry to our list of objects.
 	if existingIndex < len(b.objects) {
+		b.softDeleteLocked(b.objects[existingIndex])
 		b.objects[existingIndex] = fo
 	} else {
 		b.objects = append(b.objects, fo)
@@ -346,7 +712,11 @@ func (b *bucket) createObjectLocked(
 //
 // LOCKS_REQUIRED(b.mu)
 func (b *bucket) newReaderLocked(
-	req *gcs.ReadObjectRequest) (r io.Reader, index int, err error) {
+	req *gcs.ReadObjectRequest) (r io.ReadSeeker, index int, err error) {
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
 	// Find the object with the requested name.
 	index = b.objects.find(req.Name)
 	if index == len(b.objects) {
@@ -369,6 +739,16 @@ func (b *bucket) newReaderLocked(
 		return
 	}
 
+	// Check preconditions.
+	err = checkReadPreconditions(
+		&o.metadata,
+		req.GenerationPrecondition,
+		req.MetaGenerationPrecondition)
+
+	if err != nil {
+		return
+	}
+
 	// Extract the requested range.
 	result := o.data
 
@@ -435,13 +815,25 @@ func (b *bucket) ListObjects(
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	// The fake doesn't support object versioning, so there are no noncurrent
+	// generations and req.Versions makes no difference.
+	objects := b.objects
+	if req.SoftDeleted {
+		objects = b.softDeletedObjectsLocked()
+	}
+
 	// Set up the result object.
 	listing = new(gcs.Listing)
 
-	// Handle defaults.
+	// Handle defaults and limits, as GCS does.
+	if req.MaxResults < 0 {
+		err = fmt.Errorf("Invalid MaxResults: %d", req.MaxResults)
+		return
+	}
+
 	maxResults := req.MaxResults
-	if maxResults == 0 {
-		maxResults = 1000
+	if maxResults == 0 || maxResults > gcs.MaxListResults {
+		maxResults = gcs.MaxListResults
 	}
 
 	// Find where in the space of object names to start.
@@ -450,17 +842,43 @@ func (b *bucket) ListObjects(
 		nameStart = req.ContinuationToken
 	}
 
+	if req.StartOffset > nameStart {
+		nameStart = req.StartOffset
+	}
+
+	// Compile the glob, if any.
+	var glob *gcs.Glob
+	if req.MatchGlob != "" {
+		if glob, err = gcs.CompileGlob(req.MatchGlob); err != nil {
+			err = fmt.Errorf("CompileGlob: %v", err)
+			return
+		}
+	}
+
 	// Find the range of indexes within the array to scan.
-	indexStart := b.objects.lowerBound(nameStart)
-	prefixLimit := b.objects.prefixUpperBound(req.Prefix)
+	indexStart := objects.lowerBound(nameStart)
+	prefixLimit := objects.prefixUpperBound(req.Prefix)
+	if req.EndOffset != "" {
+		prefixLimit = minInt(prefixLimit, objects.lowerBound(req.EndOffset))
+	}
+
+	indexStart = minInt(indexStart, prefixLimit)
 	indexLimit := minInt(indexStart+maxResults, prefixLimit)
 
 	// Scan the array.
 	var lastResultWasPrefix bool
 	for i := indexStart; i < indexLimit; i++ {
-		var o fakeObject = b.objects[i]
+		var o fakeObject = objects[i]
 		name := o.metadata.Name
 
+		// Skip objects that don't match the glob. They don't contribute to
+		// collapsed runs either. Leave lastResultWasPrefix alone, so that a
+		// continuation token still skips past the remainder of any run we've
+		// already returned.
+		if glob != nil && !glob.Match(name) {
+			continue
+		}
+
 		// Search for a delimiter if necessary.
 		if req.Delimiter != "" {
 			// Search only in the part after the prefix.
@@ -483,6 +901,13 @@ func (b *bucket) ListObjects(
 					listing.CollapsedRuns = append(listing.CollapsedRuns, resultPrefix)
 				}
 
+				// A placeholder object named exactly for the run is returned as well,
+				// if requested.
+				if req.IncludeTrailingDelimiter && name == resultPrefix {
+					var oCopy gcs.Object = o.metadata
+					listing.Objects = append(listing.Objects, &oCopy)
+				}
+
 				lastResultWasPrefix = true
 				continue
 			}
@@ -517,7 +942,7 @@ func (b *bucket) ListObjects(
 			}
 		} else {
 			// Otherwise, we'll start scanning at the next object.
-			listing.ContinuationToken = b.objects[indexLimit].metadata.Name
+			listing.ContinuationToken = objects[indexLimit].metadata.Name
 		}
 	}
 
@@ -527,16 +952,40 @@ func (b *bucket) ListObjects(
 // LOCKS_EXCLUDED(b.mu)
 func (b *bucket) NewReader(
 	ctx context.Context,
-	req *gcs.ReadObjectRequest) (rc io.ReadCloser, err error) {
+	req *gcs.ReadObjectRequest) (rc gcs.ReadSeekCloser, err error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	r, _, err := b.newReaderLocked(req)
+	// We can only verify checksums for the full contents.
+	if req.VerifyChecksums && req.Range != nil {
+		err = errors.New("VerifyChecksums may not be combined with Range")
+		return
+	}
+
+	r, index, err := b.newReaderLocked(req)
 	if err != nil {
 		return
 	}
 
-	rc = ioutil.NopCloser(r)
+	// Our contents are in memory, so reads never stall and IdleTimeout can be
+	// ignored.
+	rc = &readSeekCloser{
+		ReadSeeker: r,
+		progress:   req.ProgressFunc,
+	}
+
+	// Verify checksums if requested. Make a copy of the metadata to avoid
+	// racing with later modifications.
+	if req.VerifyChecksums {
+		var oCopy gcs.Object = b.objects[index].metadata
+		rc = gcs.NewVerifyingReader(rc, &oCopy)
+	}
+
+	// We don't transcode, so the contents are as stored.
+	if req.ContentEncodingFunc != nil {
+		req.ContentEncodingFunc(b.objects[index].metadata.ContentEncoding)
+	}
+
 	return
 }
 
@@ -544,26 +993,60 @@ func (b *bucket) NewReader(
 func (b *bucket) CreateObject(
 	ctx context.Context,
 	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	// Read the contents up front, without holding the lock, so that a slow
+	// producer (such as a gcs.ObjectWriter) doesn't block other operations on
+	// the bucket.
+	contents, err := b.bufferPool.ReadAll(ctx, req.Contents)
+	if err != nil {
+		err = fmt.Errorf("BufferPool.ReadAll: %v", err)
+		return
+	}
+
+	reqCopy := *req
+	reqCopy.Contents = bytes.NewReader(contents)
+	req = &reqCopy
+
+	// Likewise compress them if requested.
+	if req, err = gcs.CompressCreateObjectRequest(req); err != nil {
+		return
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	o, err = b.createObjectLocked(req)
+	if err == nil && req.DiscardResponse {
+		o = nil
+	}
+
 	return
 }
 
-// LOCKS_EXCLUDED(b.mu)
-func (b *bucket) CopyObject(
-	ctx context.Context,
+// Copy the object described by the request to its destination name,
+// returning a record for the new object.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) copyObjectLocked(
 	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	// Check that the names are legal.
+	err = gcs.CheckObjectName(req.SrcName)
+	if err != nil {
+		return
+	}
 
-	// Check that the destination name is legal.
-	err = checkName(req.DstName)
+	err = gcs.CheckObjectName(req.DstName)
 	if err != nil {
 		return
 	}
 
+	// Check the predefined ACL, if any.
+	var acl []gcs.ACLRule
+	if req.DstPredefinedACL != "" {
+		if acl, err = predefinedACL(fakeOwner, req.DstPredefinedACL); err != nil {
+			return
+		}
+	}
+
 	// Does the object exist?
 	srcIndex := b.objects.find(req.SrcName)
 	if srcIndex == len(b.objects) {
@@ -608,10 +1091,30 @@ func (b *bucket) CopyObject(
 
 	b.prevGeneration++
 	dst.metadata.Generation = b.prevGeneration
+	dst.metadata.Created = b.clock.Now()
+	dst.metadata.Etag = makeEtag(
+		dst.metadata.Generation,
+		dst.metadata.MetaGeneration)
+
+	if acl != nil {
+		dst.acl = acl
+	}
+
+	// Holds aren't copied, and retention starts afresh.
+	dst.metadata.TemporaryHold = false
+	dst.metadata.EventBasedHold = false
+	dst.metadata.RetentionExpirationTime =
+		b.retentionExpirationLocked(b.clock.Now())
 
-	// Insert into our array.
+	// Insert into our array, refusing to overwrite objects that are held or
+	// retained.
 	existingIndex := b.objects.find(req.DstName)
 	if existingIndex < len(b.objects) {
+		if err = b.checkMutableLocked(existingIndex); err != nil {
+			return
+		}
+
+		b.softDeleteLocked(b.objects[existingIndex])
 		b.objects[existingIndex] = dst
 	} else {
 		b.objects = append(b.objects, dst)
@@ -623,22 +1126,70 @@ func (b *bucket) CopyObject(
 }
 
 // LOCKS_EXCLUDED(b.mu)
-func (b *bucket) ComposeObjects(
+func (b *bucket) CopyObject(
 	ctx context.Context,
-	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	// GCS doesn't like too few or too many sources.
-	if len(req.Sources) < 1 {
-		err = errors.New("You must provide at least one source component")
-		return
-	}
+	o, err = b.copyObjectLocked(req)
+	return
+}
 
-	if len(req.Sources) > gcs.MaxSourcesPerComposeRequest {
-		err = errors.New("You have provided too many source components")
-		return
-	}
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) MoveObject(
+	ctx context.Context,
+	req *gcs.MoveObjectRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Moving deletes the source, so it must not be held or retained.
+	if srcIndex := b.objects.find(req.SrcName); srcIndex < len(b.objects) {
+		if err = b.checkMutableLocked(srcIndex); err != nil {
+			return
+		}
+	}
+
+	// Copy the source to its new name, checking the source's generation and
+	// meta-generation along the way.
+	o, err = b.copyObjectLocked(&gcs.CopyObjectRequest{
+		SrcName:                       req.SrcName,
+		DstName:                       req.DstName,
+		SrcGeneration:                 req.SrcGeneration,
+		SrcMetaGenerationPrecondition: req.SrcMetaGenerationPrecondition,
+	})
+
+	if err != nil {
+		return
+	}
+
+	// Special case: moving an object onto its own name leaves only the new
+	// generation behind.
+	if req.SrcName != req.DstName {
+		srcIndex := b.objects.find(req.SrcName)
+		b.objects = append(b.objects[:srcIndex], b.objects[srcIndex+1:]...)
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// GCS doesn't like too few or too many sources.
+	if len(req.Sources) < 1 {
+		err = errors.New("You must provide at least one source component")
+		return
+	}
+
+	if len(req.Sources) > gcs.MaxSourcesPerComposeRequest {
+		err = errors.New("You have provided too many source components")
+		return
+	}
 
 	// Find readers for all of the source objects, also computing the sum of
 	// their component counts.
@@ -670,7 +1221,7 @@ func (b *bucket) ComposeObjects(
 
 	// Create the new object.
 	createReq := &gcs.CreateObjectRequest{
-		Name: req.DstName,
+		Name:                       req.DstName,
 		GenerationPrecondition:     req.DstGenerationPrecondition,
 		MetaGenerationPrecondition: req.DstMetaGenerationPrecondition,
 		Contents:                   io.MultiReader(srcReaders...),
@@ -705,6 +1256,10 @@ func (b *bucket) StatObject(
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
 	// Does the object exist?
 	index := b.objects.find(req.Name)
 	if index == len(b.objects) {
@@ -715,6 +1270,16 @@ func (b *bucket) StatObject(
 		return
 	}
 
+	// Check preconditions.
+	err = checkReadPreconditions(
+		&b.objects[index].metadata,
+		req.GenerationPrecondition,
+		req.MetaGenerationPrecondition)
+
+	if err != nil {
+		return
+	}
+
 	// Make a copy to avoid handing back internal state.
 	var objCopy gcs.Object = b.objects[index].metadata
 	o = &objCopy
@@ -729,6 +1294,10 @@ func (b *bucket) UpdateObject(
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
 	// Does the object exist?
 	index := b.objects.find(req.Name)
 	if index == len(b.objects) {
@@ -753,6 +1322,19 @@ func (b *bucket) UpdateObject(
 		return
 	}
 
+	// Does the generation precondition check out?
+	if req.GenerationPrecondition != nil &&
+		obj.Generation != *req.GenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Object %q has generation %d",
+				obj.Name,
+				obj.Generation),
+		}
+
+		return
+	}
+
 	// Does the meta-generation precondition check out?
 	if req.MetaGenerationPrecondition != nil &&
 		obj.MetaGeneration != *req.MetaGenerationPrecondition {
@@ -766,6 +1348,27 @@ func (b *bucket) UpdateObject(
 		return
 	}
 
+	// Check the new ACL, if any, before changing anything.
+	var acl []gcs.ACLRule
+	if req.ACL != nil {
+		if acl, err = checkACL(req.ACL); err != nil {
+			return
+		}
+	}
+
+	// GCS refuses to remove an object's custom time or to move it earlier.
+	if req.CustomTime != nil {
+		if err = customtime.Check(obj.CustomTime, *req.CustomTime); err != nil {
+			return
+		}
+
+		obj.CustomTime = *req.CustomTime
+	}
+
+	if acl != nil {
+		b.objects[index].acl = acl
+	}
+
 	// Update the entry's basic fields according to the request.
 	if req.ContentType != nil {
 		obj.ContentType = *req.ContentType
@@ -783,6 +1386,15 @@ func (b *bucket) UpdateObject(
 		obj.CacheControl = *req.CacheControl
 	}
 
+	// Set or release holds.
+	if req.TemporaryHold != nil {
+		obj.TemporaryHold = *req.TemporaryHold
+	}
+
+	if req.EventBasedHold != nil {
+		obj.EventBasedHold = *req.EventBasedHold
+	}
+
 	// Update the user metadata if necessary.
 	if len(req.Metadata) > 0 {
 		if obj.Metadata == nil {
@@ -802,6 +1414,7 @@ func (b *bucket) UpdateObject(
 	// Bump up the entry generation number and the update time.
 	obj.MetaGeneration++
 	obj.Updated = b.clock.Now()
+	obj.Etag = makeEtag(obj.Generation, obj.MetaGeneration)
 
 	// Make a copy to avoid handing back internal state.
 	var objCopy gcs.Object = *obj
@@ -817,6 +1430,10 @@ func (b *bucket) DeleteObject(
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
 	// Do we possess the object with the given name?
 	index := b.objects.find(req.Name)
 	if index == len(b.objects) {
@@ -829,6 +1446,21 @@ func (b *bucket) DeleteObject(
 		return
 	}
 
+	// Check the generation if requested.
+	if req.GenerationPrecondition != nil {
+		p := *req.GenerationPrecondition
+		if b.objects[index].metadata.Generation != p {
+			err = &gcs.PreconditionError{
+				Err: fmt.Errorf(
+					"Object %q has generation %d",
+					req.Name,
+					b.objects[index].metadata.Generation),
+			}
+
+			return
+		}
+	}
+
 	// Check the meta-generation if requested.
 	if req.MetaGenerationPrecondition != nil {
 		p := *req.MetaGenerationPrecondition
@@ -844,8 +1476,366 @@ func (b *bucket) DeleteObject(
 		}
 	}
 
-	// Remove the object.
+	// Refuse to delete objects that are held or retained.
+	if err = b.checkMutableLocked(index); err != nil {
+		return
+	}
+
+	// Remove the object, keeping it around if the bucket has a soft delete
+	// policy.
+	b.softDeleteLocked(b.objects[index])
 	b.objects = append(b.objects[:index], b.objects[index+1:]...)
 
 	return
 }
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) RestoreObject(
+	ctx context.Context,
+	req *gcs.RestoreObjectRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	b.pruneSoftDeletedLocked()
+
+	// Find the soft-deleted generation.
+	softIndex := -1
+	for i, so := range b.softDeleted {
+		if so.metadata.Name == req.Name && so.metadata.Generation == req.Generation {
+			softIndex = i
+			break
+		}
+	}
+
+	if softIndex < 0 {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf(
+				"Soft-deleted object %q (generation %d) not found",
+				req.Name,
+				req.Generation),
+		}
+
+		return
+	}
+
+	// Check the precondition against the live generation, if any.
+	existingIndex := b.objects.find(req.Name)
+
+	var existingGen int64
+	if existingIndex < len(b.objects) {
+		existingGen = b.objects[existingIndex].metadata.Generation
+	}
+
+	if req.GenerationPrecondition != nil &&
+		*req.GenerationPrecondition != existingGen {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Precondition failed: object has generation %v",
+				existingGen),
+		}
+
+		return
+	}
+
+	// Refuse to overwrite objects that are held or retained.
+	if existingIndex < len(b.objects) {
+		if err = b.checkMutableLocked(existingIndex); err != nil {
+			return
+		}
+	}
+
+	// Like GCS, bring the object back as a new generation.
+	fo := b.softDeleted[softIndex]
+	b.softDeleted = append(b.softDeleted[:softIndex], b.softDeleted[softIndex+1:]...)
+
+	now := b.clock.Now()
+	b.prevGeneration++
+	fo.metadata.Generation = b.prevGeneration
+	fo.metadata.MetaGeneration = 1
+	fo.metadata.Created = now
+	fo.metadata.Updated = now
+	fo.metadata.Etag = makeEtag(fo.metadata.Generation, 1)
+	fo.metadata.SoftDeleteTime = time.Time{}
+	fo.metadata.HardDeleteTime = time.Time{}
+	fo.metadata.RetentionExpirationTime = b.retentionExpirationLocked(now)
+
+	if !req.CopySourceACL {
+		fo.acl = b.initialACLLocked(fo.metadata.Owner)
+	}
+
+	if existingIndex < len(b.objects) {
+		b.softDeleteLocked(b.objects[existingIndex])
+		b.objects[existingIndex] = fo
+	} else {
+		b.objects = append(b.objects, fo)
+		sort.Sort(b.objects)
+	}
+
+	var oCopy gcs.Object = fo.metadata
+	o = &oCopy
+
+	return
+}
+
+func (b *bucket) SignedURL(
+	ctx context.Context,
+	req *gcs.SignedURLRequest) (signed string, err error) {
+	err = errors.New("The fake bucket doesn't support signed URLs.")
+	return
+}
+
+// Find the object with the given name and generation (zero meaning the
+// latest), returning *gcs.NotFoundError if there is none.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) findLocked(
+	name string,
+	generation int64) (index int, err error) {
+	if err = gcs.CheckObjectName(name); err != nil {
+		return
+	}
+
+	index = b.objects.find(name)
+	if index == len(b.objects) ||
+		(generation != 0 && b.objects[index].metadata.Generation != generation) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %q (generation %d) not found", name, generation),
+		}
+
+		return
+	}
+
+	return
+}
+
+// Replace the ACL of the object at the given index, bumping its
+// meta-generation as GCS does.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) setACLLocked(index int, acl []gcs.ACLRule) {
+	o := &b.objects[index]
+	o.acl = acl
+	o.metadata.MetaGeneration++
+	o.metadata.Updated = b.clock.Now()
+	o.metadata.Etag = makeEtag(o.metadata.Generation, o.metadata.MetaGeneration)
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) ListObjectACLs(
+	ctx context.Context,
+	req *gcs.ListObjectACLsRequest) (rules []*gcs.ACLRule, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	index, err := b.findLocked(req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	for _, r := range b.objects[index].acl {
+		rCopy := r
+		rules = append(rules, &rCopy)
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) UpdateObjectACL(
+	ctx context.Context,
+	req *gcs.UpdateObjectACLRequest) (rule *gcs.ACLRule, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if req.Entity == "" {
+		err = errors.New("Entity must be specified")
+		return
+	}
+
+	if req.Role != gcs.ACLRoleReader && req.Role != gcs.ACLRoleOwner {
+		err = fmt.Errorf("Unsupported role: %q", req.Role)
+		return
+	}
+
+	index, err := b.findLocked(req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	// Build a new ACL, replacing any existing entry for the entity.
+	newRule := gcs.ACLRule{Entity: req.Entity, Role: req.Role}
+	var acl []gcs.ACLRule
+	for _, r := range b.objects[index].acl {
+		if r.Entity != req.Entity {
+			acl = append(acl, r)
+		}
+	}
+
+	acl = append(acl, newRule)
+	b.setACLLocked(index, acl)
+
+	rule = &newRule
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) DeleteObjectACL(
+	ctx context.Context,
+	req *gcs.DeleteObjectACLRequest) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if req.Entity == "" {
+		err = errors.New("Entity must be specified")
+		return
+	}
+
+	index, err := b.findLocked(req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	// Build a new ACL without the entity's entry.
+	var acl []gcs.ACLRule
+	for _, r := range b.objects[index].acl {
+		if r.Entity != req.Entity {
+			acl = append(acl, r)
+		}
+	}
+
+	if len(acl) == len(b.objects[index].acl) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %q has no ACL entry for %q", req.Name, req.Entity),
+		}
+
+		return
+	}
+
+	b.setACLLocked(index, acl)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) RewriteObject(
+	ctx context.Context,
+	req *gcs.RewriteObjectRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if req.DstBucket != "" && req.DstBucket != b.name {
+		err = fmt.Errorf(
+			"The fake bucket doesn't support rewriting to other buckets (%q)",
+			req.DstBucket)
+
+		return
+	}
+
+	// Check the destination precondition, if any.
+	if req.DstGenerationPrecondition != nil {
+		var existingGen int64
+		if index := b.objects.find(req.DstName); index < len(b.objects) {
+			existingGen = b.objects[index].metadata.Generation
+		}
+
+		if existingGen != *req.DstGenerationPrecondition {
+			err = &gcs.PreconditionError{
+				Err: fmt.Errorf(
+					"Precondition failed: object has generation %v",
+					existingGen),
+			}
+
+			return
+		}
+	}
+
+	// Everything happens in a single round trip.
+	o, err = b.copyObjectLocked(&gcs.CopyObjectRequest{
+		SrcName:                       req.SrcName,
+		DstName:                       req.DstName,
+		SrcGeneration:                 req.SrcGeneration,
+		SrcMetaGenerationPrecondition: req.SrcMetaGenerationPrecondition,
+		DstPredefinedACL:              req.DstPredefinedACL,
+	})
+
+	if err != nil {
+		return
+	}
+
+	if req.DstStorageClass != "" {
+		index := b.objects.find(req.DstName)
+		b.objects[index].metadata.StorageClass = req.DstStorageClass
+		o.StorageClass = req.DstStorageClass
+	}
+
+	if req.Progress != nil {
+		req.Progress(int64(o.Size), int64(o.Size))
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) Batch(
+	ctx context.Context,
+	req *gcs.BatchRequest) (results []gcs.BatchResult, err error) {
+	// Check the operations up front, so that we don't fail partway through.
+	for i, op := range req.Ops {
+		n := 0
+		if op.Stat != nil {
+			n++
+		}
+
+		if op.Update != nil {
+			n++
+		}
+
+		if op.Delete != nil {
+			n++
+		}
+
+		if n != 1 {
+			err = fmt.Errorf(
+				"Operation %d: exactly one of Stat, Update, and Delete must be set",
+				i)
+			return
+		}
+
+		var name string
+		switch {
+		case op.Stat != nil:
+			name = op.Stat.Name
+
+		case op.Update != nil:
+			name = op.Update.Name
+
+		case op.Delete != nil:
+			name = op.Delete.Name
+		}
+
+		if err = gcs.CheckObjectName(name); err != nil {
+			return
+		}
+	}
+
+	// Perform each operation in turn.
+	results = make([]gcs.BatchResult, len(req.Ops))
+	for i, op := range req.Ops {
+		r := &results[i]
+		switch {
+		case op.Stat != nil:
+			r.Object, r.Err = b.StatObject(ctx, op.Stat)
+
+		case op.Update != nil:
+			r.Object, r.Err = b.UpdateObject(ctx, op.Update)
+
+		case op.Delete != nil:
+			r.Err = b.DeleteObject(ctx, op.Delete)
+		}
+	}
+
+	return
+}
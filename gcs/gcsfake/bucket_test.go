@@ -15,19 +15,27 @@
 package gcsfake_test
 
 import (
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/gcloud/gcs/gcsfake"
 	"github.com/jacobsa/gcloud/gcs/gcstesting"
+	. "github.com/jacobsa/oglematchers"
 	"github.com/jacobsa/ogletest"
 	"github.com/jacobsa/timeutil"
 )
 
 func TestBucket(t *testing.T) { ogletest.RunTests(t) }
 
+func BenchmarkBucket(b *testing.B) { gcstesting.RunBucketBenchmarks(b) }
+
+func FuzzBucket(f *testing.F) { gcstesting.RunBucketFuzz(f) }
+
 func init() {
 	makeDeps := func(ctx context.Context) (deps gcstesting.BucketTestDeps) {
 		// Set up a fixed, non-zero time.
@@ -35,11 +43,66 @@ func init() {
 		clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
 		deps.Clock = clock
 
-		// Set up the bucket.
+		// Set up the bucket. Each test gets its own, so they can run
+		// concurrently.
 		deps.Bucket = gcsfake.NewFakeBucket(clock, "some_bucket")
 
+		// The fake supports everything except cancellation.
+		deps.SupportsVersions = true
+		deps.SupportsPreconditions = true
+		deps.SupportsCompose = true
+		deps.SupportsRewrite = true
+		deps.SupportsHolds = true
+		deps.SupportsACLs = true
+
 		return
 	}
 
-	gcstesting.RegisterBucketTests(makeDeps)
+	gcstesting.RegisterParallelBucketTests(makeDeps, 16)
+	gcstesting.RegisterBucketBenchmarks(makeDeps)
+	gcstesting.RegisterBucketFuzz(makeDeps)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Buffer pool
+////////////////////////////////////////////////////////////////////////
+
+type BufferPoolTest struct {
+	ctx    context.Context
+	pool   *gcs.BufferPool
+	bucket gcs.Bucket
+}
+
+func init() { ogletest.RegisterTestSuite(&BufferPoolTest{}) }
+
+func (t *BufferPoolTest) SetUp(ti *ogletest.TestInfo) {
+	t.ctx = ti.Ctx
+	t.pool = gcs.NewBufferPool(4)
+	t.bucket = gcsfake.NewFakeBucketWithBufferPool(
+		&timeutil.SimulatedClock{},
+		"some_bucket",
+		t.pool)
+}
+
+func (t *BufferPoolTest) create(contents string) (err error) {
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: ioutil.NopCloser(strings.NewReader(contents)),
+		})
+
+	return
+}
+
+func (t *BufferPoolTest) WithinLimit() {
+	ogletest.AssertEq(nil, t.create("taco"))
+	ogletest.ExpectEq(0, t.pool.InUse())
+}
+
+func (t *BufferPoolTest) ExceedingLimit() {
+	err := t.create("burrito")
+
+	ogletest.ExpectThat(err, Error(HasSubstr("limit of 4 bytes")))
+	ogletest.ExpectEq(0, t.pool.InUse())
 }
@@ -15,7 +15,11 @@
 package gcsfake
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -27,10 +31,13 @@ import (
 // Create an "in-memory GCS" that allows access to buckets of any name, each
 // initially with empty contents. The supplied clock will be used for
 // generating timestamps.
+//
+// Buckets spring into existence when first opened, as well as when created
+// explicitly with CreateBucket.
 func NewConn(clock timeutil.Clock) (c gcs.Conn) {
 	typed := &conn{
 		clock:   clock,
-		buckets: make(map[string]gcs.Bucket),
+		buckets: make(map[string]fakeBucketRecord),
 	}
 
 	typed.mu = syncutil.NewInvariantMutex(typed.checkInvariants)
@@ -43,25 +50,238 @@ func NewConn(clock timeutil.Clock) (c gcs.Conn) {
 // Implementation
 ////////////////////////////////////////////////////////////////////////
 
+type fakeBucketRecord struct {
+	bucket gcs.Bucket
+	info   gcs.BucketInfo
+
+	// The bucket's IAM policy. Never modified in place.
+	policy gcs.IAMPolicy
+
+	// The bucket's notification configurations, in order of creation. Never
+	// modified in place.
+	notifications []gcs.Notification
+}
+
 type conn struct {
 	clock timeutil.Clock
 
 	mu syncutil.InvariantMutex
 
-	// INVARIANT: For each k, v: v.Name() == k
+	// INVARIANT: For each k, v: v.bucket.Name() == k
+	// INVARIANT: For each k, v: v.info.Name == k
+	//
+	// GUARDED_BY(mu)
+	buckets map[string]fakeBucketRecord
+
+	// The number of IAM policy etags minted so far.
 	//
 	// GUARDED_BY(mu)
-	buckets map[string]gcs.Bucket
+	prevEtag int64
+
+	// The number of notification IDs minted so far.
+	//
+	// GUARDED_BY(mu)
+	prevNotificationID int64
 }
 
 // LOCKS_REQUIRED(c.mu)
 func (c *conn) checkInvariants() {
-	// INVARIANT: For each k, v: v.Name() == k
 	for k, v := range c.buckets {
-		if v.Name() != k {
-			panic(fmt.Sprintf("Name mismatch: %q vs. %q", v.Name(), k))
+		// INVARIANT: For each k, v: v.bucket.Name() == k
+		if v.bucket.Name() != k {
+			panic(fmt.Sprintf("Name mismatch: %q vs. %q", v.bucket.Name(), k))
+		}
+
+		// INVARIANT: For each k, v: v.info.Name == k
+		if v.info.Name != k {
+			panic(fmt.Sprintf("Info name mismatch: %q vs. %q", v.info.Name, k))
+		}
+	}
+}
+
+// Multi-regions and predefined dual-regions known to the fake. Any other
+// location is taken to be a region.
+var (
+	multiRegions = map[string]bool{
+		"ASIA": true,
+		"EU":   true,
+		"US":   true,
+	}
+
+	dualRegions = map[string]bool{
+		"ASIA1": true,
+		"EUR4":  true,
+		"EUR5":  true,
+		"EUR7":  true,
+		"EUR8":  true,
+		"NAM4":  true,
+	}
+)
+
+// Return the location type of a bucket created with the given location and
+// custom data locations.
+func locationType(location string, dataLocations []string) string {
+	switch {
+	case len(dataLocations) != 0 || dualRegions[location]:
+		return gcs.LocationTypeDualRegion
+
+	case multiRegions[location]:
+		return gcs.LocationTypeMultiRegion
+
+	default:
+		return gcs.LocationTypeRegion
+	}
+}
+
+// Check the location-related and Autoclass options of a request to create a
+// bucket, as GCS does.
+func checkBucketConfig(req *gcs.CreateBucketRequest) (err error) {
+	location := req.Location
+	if location == "" {
+		location = "US"
+	}
+
+	if len(req.DataLocations) != 0 {
+		if !multiRegions[location] {
+			err = fmt.Errorf(
+				"Data locations require a multi-region location, not %q",
+				location)
+			return
+		}
+
+		if len(req.DataLocations) != 2 {
+			err = fmt.Errorf(
+				"Expected exactly two data locations, got %d",
+				len(req.DataLocations))
+			return
+		}
+	}
+
+	switch req.RPO {
+	case "", gcs.RPODefault:
+	case gcs.RPOAsyncTurbo:
+		lt := locationType(location, req.DataLocations)
+		if lt != gcs.LocationTypeDualRegion {
+			err = errors.New("Turbo replication requires a dual-region bucket")
+			return
+		}
+
+	default:
+		err = fmt.Errorf("Unsupported RPO: %q", req.RPO)
+		return
+	}
+
+	if req.Autoclass {
+		if req.StorageClass != "" && req.StorageClass != "STANDARD" {
+			err = fmt.Errorf(
+				"Autoclass buckets must use the STANDARD storage class, not %q",
+				req.StorageClass)
+			return
+		}
+
+		switch req.AutoclassTerminalStorageClass {
+		case "", "NEARLINE", "ARCHIVE":
+		default:
+			err = fmt.Errorf(
+				"Unsupported Autoclass terminal storage class: %q",
+				req.AutoclassTerminalStorageClass)
+			return
+		}
+	} else if req.AutoclassTerminalStorageClass != "" {
+		err = errors.New("Autoclass terminal storage class requires Autoclass")
+		return
+	}
+
+	return
+}
+
+// Create a record for a new bucket with the given attributes.
+//
+// LOCKS_REQUIRED(c.mu)
+func (c *conn) mintBucket(req *gcs.CreateBucketRequest) (r fakeBucketRecord) {
+	now := c.clock.Now()
+	r.bucket = NewFakeBucket(c.clock, req.Name)
+	r.info = gcs.BucketInfo{
+		Name:           req.Name,
+		Location:       req.Location,
+		StorageClass:   req.StorageClass,
+		MetaGeneration: 1,
+		Created:        now,
+		Updated:        now,
+
+		DataLocations:            append([]string(nil), req.DataLocations...),
+		RPO:                      req.RPO,
+		Autoclass:                req.Autoclass,
+		UniformBucketLevelAccess: req.UniformBucketLevelAccess,
+		DefaultKMSKeyName:        req.DefaultKMSKeyName,
+	}
+
+	if len(req.Labels) != 0 {
+		r.info.Labels = make(map[string]string)
+		for k, v := range req.Labels {
+			r.info.Labels[k] = v
+		}
+	}
+
+	r.bucket.(*bucket).setDefaultKMSKeyName(req.DefaultKMSKeyName)
+
+	// Fill in GCS's defaults.
+	if r.info.Location == "" {
+		r.info.Location = "US"
+	}
+
+	r.info.LocationType = locationType(r.info.Location, r.info.DataLocations)
+
+	if r.info.StorageClass == "" {
+		r.info.StorageClass = "STANDARD"
+	}
+
+	if r.info.LocationType == gcs.LocationTypeDualRegion && r.info.RPO == "" {
+		r.info.RPO = gcs.RPODefault
+	}
+
+	if r.info.Autoclass {
+		r.info.AutoclassTerminalStorageClass = req.AutoclassTerminalStorageClass
+		if r.info.AutoclassTerminalStorageClass == "" {
+			r.info.AutoclassTerminalStorageClass = "NEARLINE"
 		}
 	}
+
+	r.policy = gcs.IAMPolicy{
+		Version: 1,
+		Etag:    c.mintEtag(),
+	}
+
+	return
+}
+
+// Return a fresh etag for an IAM policy.
+//
+// LOCKS_REQUIRED(c.mu)
+func (c *conn) mintEtag() string {
+	c.prevEtag++
+	return fmt.Sprintf("etag-%d", c.prevEtag)
+}
+
+// Make a deep copy of the supplied policy, to avoid sharing internal state
+// with the caller.
+func copyPolicy(in *gcs.IAMPolicy) (out *gcs.IAMPolicy) {
+	out = &gcs.IAMPolicy{
+		Version: in.Version,
+		Etag:    in.Etag,
+	}
+
+	for _, b := range in.Bindings {
+		b.Members = append([]string(nil), b.Members...)
+		if b.Condition != nil {
+			condCopy := *b.Condition
+			b.Condition = &condCopy
+		}
+
+		out.Bindings = append(out.Bindings, b)
+	}
+
+	return
 }
 
 // LOCKS_EXCLUDED(c.mu)
@@ -72,14 +292,912 @@ func (c *conn) OpenBucket(
 	defer c.mu.Unlock()
 
 	// Do we already know this bucket name?
-	b = c.buckets[name]
-	if b != nil {
+	if r, ok := c.buckets[name]; ok {
+		b = r.bucket
+		return
+	}
+
+	// Create it.
+	r := c.mintBucket(&gcs.CreateBucketRequest{Name: name})
+	c.buckets[name] = r
+	b = r.bucket
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) CreateBucket(
+	ctx context.Context,
+	req *gcs.CreateBucketRequest) (bi *gcs.BucketInfo, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Check the name.
+	if req.Name == "" {
+		err = &gcs.InvalidNameError{
+			Err: errors.New("Invalid bucket name: must be non-empty"),
+		}
+
+		return
+	}
+
+	// Bucket names are unique.
+	if _, ok := c.buckets[req.Name]; ok {
+		err = fmt.Errorf("Bucket %q already exists", req.Name)
+		return
+	}
+
+	// Check the configuration.
+	if err = checkBucketConfig(req); err != nil {
 		return
 	}
 
 	// Create it.
-	b = NewFakeBucket(c.clock, name)
-	c.buckets[name] = b
+	r := c.mintBucket(req)
+	c.buckets[req.Name] = r
+
+	// Make a copy to avoid handing back internal state.
+	bi = copyBucketInfo(r.info)
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) DeleteBucket(
+	ctx context.Context,
+	name string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Does the bucket exist?
+	r, ok := c.buckets[name]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", name),
+		}
+
+		return
+	}
+
+	// GCS refuses to delete buckets that have contents.
+	listing, err := r.bucket.ListObjects(
+		ctx,
+		&gcs.ListObjectsRequest{MaxResults: 1})
+
+	if err != nil {
+		err = fmt.Errorf("ListObjects: %v", err)
+		return
+	}
+
+	if len(listing.Objects) != 0 {
+		err = fmt.Errorf("Bucket %q is not empty", name)
+		return
+	}
+
+	delete(c.buckets, name)
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) ListBuckets(
+	ctx context.Context) (buckets []*gcs.BucketInfo, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Sort by name.
+	var names []string
+	for name := range c.buckets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	// Make copies to avoid handing back internal state.
+	for _, name := range names {
+		buckets = append(buckets, copyBucketInfo(c.buckets[name].info))
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) ListBucketsPage(
+	ctx context.Context,
+	req *gcs.ListBucketsRequest) (listing *gcs.BucketListing, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch req.Projection {
+	case "", gcs.ProjectionFull, gcs.ProjectionNoACL:
+	default:
+		err = fmt.Errorf("Unsupported projection: %q", req.Projection)
+		return
+	}
+
+	// Find the matching names after the continuation token, which is the name
+	// of the first bucket in the next page.
+	var names []string
+	for name := range c.buckets {
+		if strings.HasPrefix(name, req.Prefix) && name >= req.ContinuationToken {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	// Like GCS, return at most 1000 buckets per page.
+	maxResults := 1000
+	if req.MaxResults != 0 && req.MaxResults < maxResults {
+		maxResults = req.MaxResults
+	}
+
+	listing = &gcs.BucketListing{}
+	if len(names) > maxResults {
+		listing.ContinuationToken = names[maxResults]
+		names = names[:maxResults]
+	}
+
+	// Make copies to avoid handing back internal state.
+	for _, name := range names {
+		listing.Buckets = append(
+			listing.Buckets,
+			copyBucketInfo(c.buckets[name].info))
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) GetBucket(
+	ctx context.Context,
+	name string) (bi *gcs.BucketInfo, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.buckets[name]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", name),
+		}
+
+		return
+	}
+
+	// Make a copy to avoid handing back internal state.
+	bi = copyBucketInfo(r.info)
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) GetBucketIAMPolicy(
+	ctx context.Context,
+	name string) (p *gcs.IAMPolicy, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.buckets[name]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", name),
+		}
+
+		return
+	}
+
+	p = copyPolicy(&r.policy)
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) SetBucketIAMPolicy(
+	ctx context.Context,
+	req *gcs.SetBucketIAMPolicyRequest) (p *gcs.IAMPolicy, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.Policy == nil {
+		err = errors.New("Policy must be non-nil")
+		return
+	}
+
+	r, ok := c.buckets[req.BucketName]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", req.BucketName),
+		}
+
+		return
+	}
+
+	// Check the etag, if any.
+	if req.Policy.Etag != "" && req.Policy.Etag != r.policy.Etag {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Policy for bucket %q has etag %q",
+				req.BucketName,
+				r.policy.Etag),
+		}
+
+		return
+	}
+
+	// Like GCS, refuse conditional bindings in older policy versions.
+	for _, b := range req.Policy.Bindings {
+		if b.Condition != nil && req.Policy.Version < gcs.IAMPolicyVersion {
+			err = fmt.Errorf(
+				"Conditional bindings require policy version %d",
+				gcs.IAMPolicyVersion)
+
+			return
+		}
+	}
+
+	// Store the new policy.
+	r.policy = *copyPolicy(req.Policy)
+	r.policy.Etag = c.mintEtag()
+	c.buckets[req.BucketName] = r
+
+	p = copyPolicy(&r.policy)
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) SetBucketRetentionPolicy(
+	ctx context.Context,
+	req *gcs.SetBucketRetentionPolicyRequest) (bi *gcs.BucketInfo, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.buckets[req.BucketName]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", req.BucketName),
+		}
+
+		return
+	}
+
+	// Check the meta-generation, if requested.
+	if req.MetaGenerationPrecondition != nil &&
+		r.info.MetaGeneration != *req.MetaGenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Bucket %q has meta-generation %d",
+				req.BucketName,
+				r.info.MetaGeneration),
+		}
+
+		return
+	}
+
+	// Like GCS, truncate to whole seconds.
+	period := req.Period - req.Period%time.Second
+
+	// Locked policies may only be lengthened.
+	if p := r.info.RetentionPolicy; p != nil && p.IsLocked && period < p.Period {
+		err = &gcs.ForbiddenError{
+			Err: fmt.Errorf(
+				"Bucket %q has a locked retention policy of %v",
+				req.BucketName,
+				p.Period),
+		}
+
+		return
+	}
+
+	// Update the record. Policies are never modified in place, since they may
+	// be shared with callers.
+	now := c.clock.Now()
+
+	r.info.RetentionPolicy = nil
+	if period != 0 {
+		r.info.RetentionPolicy = &gcs.RetentionPolicy{
+			Period:        period,
+			EffectiveTime: now,
+		}
+	}
+
+	r.info.MetaGeneration++
+	r.info.Updated = now
+	c.buckets[req.BucketName] = r
+
+	// Let the bucket know, so that it can enforce the policy.
+	r.bucket.(*bucket).setRetentionPeriod(period)
+
+	bi = copyBucketInfo(r.info)
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) LockBucketRetentionPolicy(
+	ctx context.Context,
+	req *gcs.LockBucketRetentionPolicyRequest) (bi *gcs.BucketInfo, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.buckets[req.BucketName]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", req.BucketName),
+		}
+
+		return
+	}
+
+	if r.info.MetaGeneration != req.MetaGeneration {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Bucket %q has meta-generation %d",
+				req.BucketName,
+				r.info.MetaGeneration),
+		}
+
+		return
+	}
+
+	if r.info.RetentionPolicy == nil {
+		err = fmt.Errorf("Bucket %q has no retention policy", req.BucketName)
+		return
+	}
+
+	// Update the record.
+	policyCopy := *r.info.RetentionPolicy
+	policyCopy.IsLocked = true
+
+	r.info.RetentionPolicy = &policyCopy
+	r.info.MetaGeneration++
+	r.info.Updated = c.clock.Now()
+	c.buckets[req.BucketName] = r
+
+	bi = copyBucketInfo(r.info)
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) SetBucketDefaultKMSKey(
+	ctx context.Context,
+	req *gcs.SetBucketDefaultKMSKeyRequest) (bi *gcs.BucketInfo, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.buckets[req.BucketName]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", req.BucketName),
+		}
+
+		return
+	}
+
+	// Check the meta-generation, if requested.
+	if req.MetaGenerationPrecondition != nil &&
+		r.info.MetaGeneration != *req.MetaGenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Bucket %q has meta-generation %d",
+				req.BucketName,
+				r.info.MetaGeneration),
+		}
+
+		return
+	}
+
+	// Update the record.
+	r.info.DefaultKMSKeyName = req.KMSKeyName
+	r.info.MetaGeneration++
+	r.info.Updated = c.clock.Now()
+	c.buckets[req.BucketName] = r
+
+	// Let the bucket know, so that it can apply the key to new objects.
+	r.bucket.(*bucket).setDefaultKMSKeyName(req.KMSKeyName)
+
+	bi = copyBucketInfo(r.info)
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) SetBucketSoftDeletePolicy(
+	ctx context.Context,
+	req *gcs.SetBucketSoftDeletePolicyRequest) (bi *gcs.BucketInfo, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.buckets[req.BucketName]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", req.BucketName),
+		}
+
+		return
+	}
+
+	// Check the meta-generation, if requested.
+	if req.MetaGenerationPrecondition != nil &&
+		r.info.MetaGeneration != *req.MetaGenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Bucket %q has meta-generation %d",
+				req.BucketName,
+				r.info.MetaGeneration),
+		}
+
+		return
+	}
+
+	// Like GCS, truncate to whole seconds and insist on between seven and 90
+	// days.
+	const day = 24 * time.Hour
+	duration := req.RetentionDuration - req.RetentionDuration%time.Second
+	if duration != 0 && (duration < 7*day || duration > 90*day) {
+		err = fmt.Errorf(
+			"Soft delete retention duration must be between 7 and 90 days, not %v",
+			duration)
+		return
+	}
+
+	// Update the record. Policies are never modified in place, since they may
+	// be shared with callers.
+	now := c.clock.Now()
+
+	r.info.SoftDeletePolicy = nil
+	if duration != 0 {
+		r.info.SoftDeletePolicy = &gcs.SoftDeletePolicy{
+			RetentionDuration: duration,
+			EffectiveTime:     now,
+		}
+	}
+
+	r.info.MetaGeneration++
+	r.info.Updated = now
+	c.buckets[req.BucketName] = r
+
+	// Let the bucket know, so that it can keep deleted objects around.
+	r.bucket.(*bucket).setSoftDeleteDuration(duration)
+
+	bi = copyBucketInfo(r.info)
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) UpdateBucket(
+	ctx context.Context,
+	req *gcs.UpdateBucketRequest) (bi *gcs.BucketInfo, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.buckets[req.BucketName]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", req.BucketName),
+		}
+
+		return
+	}
+
+	// Check the meta-generation, if requested.
+	if req.MetaGenerationPrecondition != nil &&
+		r.info.MetaGeneration != *req.MetaGenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Bucket %q has meta-generation %d",
+				req.BucketName,
+				r.info.MetaGeneration),
+		}
+
+		return
+	}
+
+	// Check the default object ACL.
+	if req.DefaultObjectACL != nil {
+		if r.info.UniformBucketLevelAccess {
+			err = errUniformBucketLevelAccess
+			return
+		}
+
+		for _, rule := range *req.DefaultObjectACL {
+			if rule.Entity == "" {
+				err = errors.New("Entity must be specified")
+				return
+			}
+
+			if rule.Role != gcs.ACLRoleReader && rule.Role != gcs.ACLRoleOwner {
+				err = fmt.Errorf("Unsupported role: %q", rule.Role)
+				return
+			}
+		}
+	}
+
+	// Update a copy of the record, since the old one may be shared.
+	info := copyBucketInfo(r.info)
+
+	if len(req.Labels) != 0 {
+		if info.Labels == nil {
+			info.Labels = make(map[string]string)
+		}
+
+		for k, v := range req.Labels {
+			if v == nil {
+				delete(info.Labels, k)
+			} else {
+				info.Labels[k] = *v
+			}
+		}
+
+		if len(info.Labels) == 0 {
+			info.Labels = nil
+		}
+	}
+
+	if req.DefaultObjectACL != nil {
+		info.DefaultObjectACL = nil
+		for _, rule := range *req.DefaultObjectACL {
+			info.DefaultObjectACL = append(
+				info.DefaultObjectACL,
+				gcs.ACLRule{Entity: rule.Entity, Role: rule.Role})
+		}
+	}
+
+	if req.VersioningEnabled != nil {
+		info.VersioningEnabled = *req.VersioningEnabled
+	}
+
+	if req.Website != nil {
+		info.Website = nil
+		if *req.Website != (gcs.WebsiteConfig{}) {
+			w := *req.Website
+			info.Website = &w
+		}
+	}
+
+	if req.CORS != nil {
+		info.CORS = copyBucketInfo(gcs.BucketInfo{CORS: *req.CORS}).CORS
+		for _, rule := range info.CORS {
+			if len(rule.Origins) == 0 || len(rule.Methods) == 0 {
+				err = errors.New("CORS rules require origins and methods")
+				return
+			}
+		}
+	}
+
+	info.MetaGeneration++
+	info.Updated = c.clock.Now()
+
+	r.info = *info
+	c.buckets[req.BucketName] = r
+
+	// Let the bucket know, so that it can apply the ACL to new objects.
+	r.bucket.(*bucket).setDefaultObjectACL(r.info.DefaultObjectACL)
+
+	bi = copyBucketInfo(r.info)
+	return
+}
+
+// The error returned, like GCS, for attempts to use default object ACLs on a
+// bucket with uniform bucket-level access.
+var errUniformBucketLevelAccess = errors.New(
+	"Cannot use ACLs with uniform bucket-level access enabled")
+
+// Find the named bucket for a default object ACL operation.
+//
+// LOCKS_REQUIRED(c.mu)
+func (c *conn) findForDefaultObjectACL(
+	name string) (r fakeBucketRecord, err error) {
+	r, ok := c.buckets[name]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", name),
+		}
+
+		return
+	}
+
+	if r.info.UniformBucketLevelAccess {
+		err = errUniformBucketLevelAccess
+		return
+	}
+
+	return
+}
+
+// Replace the default object ACL of the supplied bucket.
+//
+// LOCKS_REQUIRED(c.mu)
+func (c *conn) setDefaultObjectACLLocked(
+	r fakeBucketRecord,
+	rules []gcs.ACLRule) {
+	r.info.DefaultObjectACL = rules
+	r.info.MetaGeneration++
+	r.info.Updated = c.clock.Now()
+	c.buckets[r.info.Name] = r
+
+	r.bucket.(*bucket).setDefaultObjectACL(rules)
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) ListDefaultObjectACLs(
+	ctx context.Context,
+	bucketName string) (rules []*gcs.ACLRule, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, err := c.findForDefaultObjectACL(bucketName)
+	if err != nil {
+		return
+	}
+
+	for _, rule := range r.info.DefaultObjectACL {
+		ruleCopy := rule
+		rules = append(rules, &ruleCopy)
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) UpdateDefaultObjectACL(
+	ctx context.Context,
+	req *gcs.UpdateDefaultObjectACLRequest) (rule *gcs.ACLRule, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.Entity == "" {
+		err = errors.New("Entity must be specified")
+		return
+	}
+
+	if req.Role != gcs.ACLRoleReader && req.Role != gcs.ACLRoleOwner {
+		err = fmt.Errorf("Unsupported role: %q", req.Role)
+		return
+	}
+
+	r, err := c.findForDefaultObjectACL(req.BucketName)
+	if err != nil {
+		return
+	}
+
+	// Replace any existing entry for the entity, or add a new one.
+	newRule := gcs.ACLRule{Entity: req.Entity, Role: req.Role}
+	var rules []gcs.ACLRule
+	found := false
+	for _, existing := range r.info.DefaultObjectACL {
+		if existing.Entity == req.Entity {
+			existing = newRule
+			found = true
+		}
+
+		rules = append(rules, existing)
+	}
+
+	if !found {
+		rules = append(rules, newRule)
+	}
+
+	c.setDefaultObjectACLLocked(r, rules)
+
+	rule = &newRule
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) DeleteDefaultObjectACL(
+	ctx context.Context,
+	req *gcs.DeleteDefaultObjectACLRequest) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.Entity == "" {
+		err = errors.New("Entity must be specified")
+		return
+	}
+
+	r, err := c.findForDefaultObjectACL(req.BucketName)
+	if err != nil {
+		return
+	}
+
+	var rules []gcs.ACLRule
+	for _, existing := range r.info.DefaultObjectACL {
+		if existing.Entity != req.Entity {
+			rules = append(rules, existing)
+		}
+	}
+
+	if len(rules) == len(r.info.DefaultObjectACL) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("No default object ACL entry for %q", req.Entity),
+		}
+
+		return
+	}
+
+	c.setDefaultObjectACLLocked(r, rules)
+	return
+}
+
+// Make a deep copy of the supplied bucket record, to avoid sharing internal
+// state with the caller.
+func copyBucketInfo(in gcs.BucketInfo) (out *gcs.BucketInfo) {
+	bi := in
+	bi.DataLocations = append([]string(nil), in.DataLocations...)
+	bi.DefaultObjectACL = append([]gcs.ACLRule(nil), in.DefaultObjectACL...)
+
+	if in.Labels != nil {
+		bi.Labels = make(map[string]string)
+		for k, v := range in.Labels {
+			bi.Labels[k] = v
+		}
+	}
+
+	if in.RetentionPolicy != nil {
+		p := *in.RetentionPolicy
+		bi.RetentionPolicy = &p
+	}
+
+	if in.SoftDeletePolicy != nil {
+		p := *in.SoftDeletePolicy
+		bi.SoftDeletePolicy = &p
+	}
+
+	if in.Website != nil {
+		w := *in.Website
+		bi.Website = &w
+	}
+
+	bi.CORS = nil
+	for _, r := range in.CORS {
+		r.Origins = append([]string(nil), r.Origins...)
+		r.Methods = append([]string(nil), r.Methods...)
+		r.ResponseHeaders = append([]string(nil), r.ResponseHeaders...)
+		bi.CORS = append(bi.CORS, r)
+	}
+
+	out = &bi
+	return
+}
+
+// Make a deep copy of the supplied notification configuration, to avoid
+// sharing internal state with the caller.
+func copyNotification(in *gcs.Notification) (out *gcs.Notification) {
+	n := *in
+	n.EventTypes = append([]string(nil), in.EventTypes...)
+
+	if in.CustomAttributes != nil {
+		n.CustomAttributes = make(map[string]string)
+		for k, v := range in.CustomAttributes {
+			n.CustomAttributes[k] = v
+		}
+	}
+
+	out = &n
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) CreateNotification(
+	ctx context.Context,
+	req *gcs.CreateNotificationRequest) (n *gcs.Notification, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if req.Notification.Topic == "" {
+		err = errors.New("Notification.Topic must be set")
+		return
+	}
+
+	r, ok := c.buckets[req.BucketName]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", req.BucketName),
+		}
+
+		return
+	}
+
+	// Check the event types and payload format as GCS would.
+	for _, t := range req.Notification.EventTypes {
+		switch t {
+		case gcs.NotificationObjectFinalize,
+			gcs.NotificationObjectMetadataUpdate,
+			gcs.NotificationObjectDelete,
+			gcs.NotificationObjectArchive:
+
+		default:
+			err = fmt.Errorf("Unknown event type %q", t)
+			return
+		}
+	}
+
+	stored := copyNotification(&req.Notification)
+	switch stored.PayloadFormat {
+	case "":
+		stored.PayloadFormat = gcs.NotificationPayloadJSON
+
+	case gcs.NotificationPayloadJSON, gcs.NotificationPayloadNone:
+
+	default:
+		err = fmt.Errorf("Unknown payload format %q", stored.PayloadFormat)
+		return
+	}
+
+	// Assign an ID and etag.
+	c.prevNotificationID++
+	stored.ID = fmt.Sprint(c.prevNotificationID)
+	stored.Etag = fmt.Sprint(c.prevNotificationID)
+
+	// Store a new slice, since the old one may be aliased.
+	r.notifications = append(
+		append([]gcs.Notification(nil), r.notifications...),
+		*stored)
+
+	c.buckets[req.BucketName] = r
+
+	n = copyNotification(stored)
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) ListNotifications(
+	ctx context.Context,
+	bucketName string) (ns []*gcs.Notification, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.buckets[bucketName]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", bucketName),
+		}
+
+		return
+	}
+
+	for i := range r.notifications {
+		ns = append(ns, copyNotification(&r.notifications[i]))
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(c.mu)
+func (c *conn) DeleteNotification(
+	ctx context.Context,
+	bucketName string,
+	id string) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.buckets[bucketName]
+	if !ok {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q not found", bucketName),
+		}
+
+		return
+	}
+
+	// Build a new slice without the configuration.
+	var remaining []gcs.Notification
+	for _, n := range r.notifications {
+		if n.ID != id {
+			remaining = append(remaining, n)
+		}
+	}
+
+	if len(remaining) == len(r.notifications) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Bucket %q has no notification %q", bucketName, id),
+		}
+
+		return
+	}
+
+	r.notifications = remaining
+	c.buckets[bucketName] = r
 
 	return
 }
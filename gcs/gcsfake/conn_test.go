@@ -15,6 +15,7 @@
 package gcsfake_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -23,6 +24,7 @@ import (
 	"github.com/jacobsa/gcloud/gcs"
 	"github.com/jacobsa/gcloud/gcs/gcsfake"
 	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
 	. "github.com/jacobsa/ogletest"
 	"github.com/jacobsa/timeutil"
 )
@@ -110,3 +112,1008 @@ func (t *ConnTest) BucketsAreSegregatedByName() {
 	AssertEq(nil, err)
 	ExpectEq("burrito", string(contents))
 }
+
+func (t *ConnTest) CreateBucket() {
+	var err error
+
+	// Create a bucket.
+	bi, err := t.conn.CreateBucket(
+		t.ctx,
+		&gcs.CreateBucketRequest{
+			Name:     "foo",
+			Location: "EU",
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("foo", bi.Name)
+	ExpectEq("EU", bi.Location)
+	ExpectEq("STANDARD", bi.StorageClass)
+	ExpectThat(bi.Created, timeutil.TimeEq(t.clock.Now()))
+
+	// It should now be listed.
+	buckets, err := t.conn.ListBuckets(t.ctx)
+	AssertEq(nil, err)
+	AssertEq(1, len(buckets))
+	ExpectEq("foo", buckets[0].Name)
+
+	// Creating it again should fail.
+	_, err = t.conn.CreateBucket(t.ctx, &gcs.CreateBucketRequest{Name: "foo"})
+	ExpectThat(err, Error(HasSubstr("already exists")))
+}
+
+func (t *ConnTest) CreateBucket_LocationAndAccessOptions() {
+	var err error
+
+	// A region.
+	bi, err := t.conn.CreateBucket(
+		t.ctx,
+		&gcs.CreateBucketRequest{
+			Name:                     "region",
+			Location:                 "US-EAST1",
+			UniformBucketLevelAccess: true,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(gcs.LocationTypeRegion, bi.LocationType)
+	ExpectEq("", bi.RPO)
+	ExpectTrue(bi.UniformBucketLevelAccess)
+	ExpectFalse(bi.Autoclass)
+
+	// The default multi-region, with Autoclass.
+	bi, err = t.conn.CreateBucket(
+		t.ctx,
+		&gcs.CreateBucketRequest{
+			Name:      "multi",
+			Autoclass: true,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("US", bi.Location)
+	ExpectEq(gcs.LocationTypeMultiRegion, bi.LocationType)
+	ExpectTrue(bi.Autoclass)
+	ExpectEq("NEARLINE", bi.AutoclassTerminalStorageClass)
+	ExpectFalse(bi.UniformBucketLevelAccess)
+
+	// A configurable dual-region with turbo replication.
+	bi, err = t.conn.CreateBucket(
+		t.ctx,
+		&gcs.CreateBucketRequest{
+			Name:          "dual",
+			Location:      "US",
+			DataLocations: []string{"US-EAST1", "US-WEST1"},
+			RPO:           gcs.RPOAsyncTurbo,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(gcs.LocationTypeDualRegion, bi.LocationType)
+	ExpectThat(bi.DataLocations, ElementsAre("US-EAST1", "US-WEST1"))
+	ExpectEq(gcs.RPOAsyncTurbo, bi.RPO)
+
+	// A predefined dual-region gets the default RPO.
+	bi, err = t.conn.CreateBucket(
+		t.ctx,
+		&gcs.CreateBucketRequest{
+			Name:     "nam4",
+			Location: "NAM4",
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(gcs.LocationTypeDualRegion, bi.LocationType)
+	ExpectEq(gcs.RPODefault, bi.RPO)
+
+	// GetBucket should agree.
+	bi, err = t.conn.GetBucket(t.ctx, "dual")
+	AssertEq(nil, err)
+	ExpectEq(gcs.RPOAsyncTurbo, bi.RPO)
+}
+
+func (t *ConnTest) CreateBucket_InvalidLocationAndAccessOptions() {
+	testCases := []struct {
+		req      gcs.CreateBucketRequest
+		expected string
+	}{
+		{
+			gcs.CreateBucketRequest{Location: "US-EAST1", RPO: gcs.RPOAsyncTurbo},
+			"requires a dual-region",
+		},
+		{
+			gcs.CreateBucketRequest{RPO: "SOON"},
+			"Unsupported RPO",
+		},
+		{
+			gcs.CreateBucketRequest{
+				Location:      "US-EAST1",
+				DataLocations: []string{"US-EAST1", "US-WEST1"},
+			},
+			"multi-region",
+		},
+		{
+			gcs.CreateBucketRequest{DataLocations: []string{"US-EAST1"}},
+			"exactly two",
+		},
+		{
+			gcs.CreateBucketRequest{Autoclass: true, StorageClass: "COLDLINE"},
+			"STANDARD",
+		},
+		{
+			gcs.CreateBucketRequest{AutoclassTerminalStorageClass: "ARCHIVE"},
+			"requires Autoclass",
+		},
+	}
+
+	for i, tc := range testCases {
+		tc.req.Name = "foo"
+		_, err := t.conn.CreateBucket(t.ctx, &tc.req)
+		ExpectThat(err, Error(HasSubstr(tc.expected)), "Test case %d", i)
+	}
+
+	// None of them should have created the bucket.
+	buckets, err := t.conn.ListBuckets(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(0, len(buckets))
+}
+
+func (t *ConnTest) ListBuckets() {
+	var err error
+
+	// Create some buckets out of order.
+	for _, name := range []string{"taco", "burrito", "enchilada"} {
+		_, err = t.conn.CreateBucket(t.ctx, &gcs.CreateBucketRequest{Name: name})
+		AssertEq(nil, err)
+	}
+
+	// List.
+	buckets, err := t.conn.ListBuckets(t.ctx)
+	AssertEq(nil, err)
+
+	var names []string
+	for _, bi := range buckets {
+		names = append(names, bi.Name)
+	}
+
+	ExpectThat(names, ElementsAre("burrito", "enchilada", "taco"))
+}
+
+func (t *ConnTest) ListBucketsPage() {
+	var err error
+
+	for _, name := range []string{"taco", "burrito", "enchilada", "tamale"} {
+		_, err = t.conn.CreateBucket(
+			t.ctx,
+			&gcs.CreateBucketRequest{
+				Name:   name,
+				Labels: map[string]string{"food": name},
+			})
+
+		AssertEq(nil, err)
+	}
+
+	// A page limited by prefix and size.
+	listing, err := t.conn.ListBucketsPage(
+		t.ctx,
+		&gcs.ListBucketsRequest{
+			Prefix:     "ta",
+			MaxResults: 1,
+		})
+
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Buckets))
+	ExpectEq("taco", listing.Buckets[0].Name)
+	ExpectEq("taco", listing.Buckets[0].Labels["food"])
+	AssertNe("", listing.ContinuationToken)
+
+	// The rest.
+	listing, err = t.conn.ListBucketsPage(
+		t.ctx,
+		&gcs.ListBucketsRequest{
+			Prefix:            "ta",
+			ContinuationToken: listing.ContinuationToken,
+			Projection:        gcs.ProjectionNoACL,
+		})
+
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Buckets))
+	ExpectEq("tamale", listing.Buckets[0].Name)
+	ExpectEq("", listing.ContinuationToken)
+}
+
+func (t *ConnTest) BucketIterator() {
+	var err error
+
+	expected := []string{"burrito", "enchilada", "taco", "tamale"}
+	for _, name := range expected {
+		_, err = t.conn.CreateBucket(t.ctx, &gcs.CreateBucketRequest{Name: name})
+		AssertEq(nil, err)
+	}
+
+	// Iterate a page at a time.
+	it := gcsutil.NewBucketIterator(
+		t.conn,
+		&gcs.ListBucketsRequest{MaxResults: 1})
+
+	var names []string
+	for {
+		bi, err := it.Next(t.ctx)
+		AssertEq(nil, err)
+
+		if bi == nil {
+			break
+		}
+
+		names = append(names, bi.Name)
+	}
+
+	ExpectThat(names, DeepEquals(expected))
+}
+
+func (t *ConnTest) UpdateBucket() {
+	var err error
+
+	// Updating a non-existent bucket should fail.
+	_, err = t.conn.UpdateBucket(
+		t.ctx,
+		&gcs.UpdateBucketRequest{BucketName: "foo"})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	// Create a bucket with some labels.
+	bi, err := t.conn.CreateBucket(
+		t.ctx,
+		&gcs.CreateBucketRequest{
+			Name:   "foo",
+			Labels: map[string]string{"team": "video", "owner": "alice"},
+		})
+
+	AssertEq(nil, err)
+	AssertEq(1, bi.MetaGeneration)
+
+	// Update everything.
+	env := "prod"
+	versioning := true
+	bi, err = t.conn.UpdateBucket(
+		t.ctx,
+		&gcs.UpdateBucketRequest{
+			BucketName: "foo",
+			Labels: map[string]*string{
+				"owner": nil,
+				"env":   &env,
+			},
+			DefaultObjectACL: &[]gcs.ACLRule{
+				{Entity: "allUsers", Role: gcs.ACLRoleReader},
+			},
+			VersioningEnabled: &versioning,
+			Website:           &gcs.WebsiteConfig{MainPageSuffix: "index.html"},
+			CORS: &[]gcs.CORSRule{
+				{Origins: []string{"*"}, Methods: []string{"GET"}},
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(2, bi.MetaGeneration)
+	ExpectThat(
+		bi.Labels,
+		DeepEquals(map[string]string{"team": "video", "env": "prod"}))
+
+	ExpectThat(
+		bi.DefaultObjectACL,
+		DeepEquals([]gcs.ACLRule{{Entity: "allUsers", Role: gcs.ACLRoleReader}}))
+
+	ExpectTrue(bi.VersioningEnabled)
+	AssertNe(nil, bi.Website)
+	ExpectEq("index.html", bi.Website.MainPageSuffix)
+	AssertEq(1, len(bi.CORS))
+	ExpectThat(bi.CORS[0].Origins, ElementsAre("*"))
+
+	// Modifying the result shouldn't affect the fake.
+	bi.Labels["team"] = "audio"
+	bi.CORS[0].Origins[0] = "https://example.com"
+
+	// A stale precondition should fail without effect.
+	precond := int64(1)
+	_, err = t.conn.UpdateBucket(
+		t.ctx,
+		&gcs.UpdateBucketRequest{
+			BucketName:                 "foo",
+			MetaGenerationPrecondition: &precond,
+			Website:                    &gcs.WebsiteConfig{},
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// Remove things, with the right precondition. Fields not mentioned should
+	// be untouched.
+	precond = 2
+	bi, err = t.conn.UpdateBucket(
+		t.ctx,
+		&gcs.UpdateBucketRequest{
+			BucketName:                 "foo",
+			MetaGenerationPrecondition: &precond,
+			DefaultObjectACL:           &[]gcs.ACLRule{},
+			Website:                    &gcs.WebsiteConfig{},
+			CORS:                       &[]gcs.CORSRule{},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(3, bi.MetaGeneration)
+	ExpectEq("video", bi.Labels["team"])
+	ExpectTrue(bi.VersioningEnabled)
+	ExpectEq(0, len(bi.DefaultObjectACL))
+	ExpectEq(nil, bi.Website)
+	ExpectEq(0, len(bi.CORS))
+
+	// GetBucket should agree.
+	bi, err = t.conn.GetBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+	ExpectEq(3, bi.MetaGeneration)
+	ExpectEq(nil, bi.Website)
+}
+
+func (t *ConnTest) UpdateBucket_InvalidRules() {
+	_, err := t.conn.CreateBucket(t.ctx, &gcs.CreateBucketRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	_, err = t.conn.UpdateBucket(
+		t.ctx,
+		&gcs.UpdateBucketRequest{
+			BucketName: "foo",
+			DefaultObjectACL: &[]gcs.ACLRule{
+				{Entity: "allUsers", Role: "WRITER"},
+			},
+		})
+
+	ExpectThat(err, Error(HasSubstr("Unsupported role")))
+
+	_, err = t.conn.UpdateBucket(
+		t.ctx,
+		&gcs.UpdateBucketRequest{
+			BucketName: "foo",
+			CORS:       &[]gcs.CORSRule{{Origins: []string{"*"}}},
+		})
+
+	ExpectThat(err, Error(HasSubstr("origins and methods")))
+
+	// Neither should have had any effect.
+	bi, err := t.conn.GetBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+	ExpectEq(1, bi.MetaGeneration)
+}
+
+func (t *ConnTest) DefaultObjectACL() {
+	var err error
+
+	// A non-existent bucket.
+	_, err = t.conn.ListDefaultObjectACLs(t.ctx, "foo")
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	// Create a bucket, which starts with an empty default ACL.
+	_, err = t.conn.CreateBucket(t.ctx, &gcs.CreateBucketRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	rules, err := t.conn.ListDefaultObjectACLs(t.ctx, "foo")
+	AssertEq(nil, err)
+	ExpectEq(0, len(rules))
+
+	// Grant public read, then change its role.
+	for _, role := range []string{gcs.ACLRoleOwner, gcs.ACLRoleReader} {
+		_, err = t.conn.UpdateDefaultObjectACL(
+			t.ctx,
+			&gcs.UpdateDefaultObjectACLRequest{
+				BucketName: "foo",
+				Entity:     "allUsers",
+				Role:       role,
+			})
+
+		AssertEq(nil, err)
+	}
+
+	rules, err = t.conn.ListDefaultObjectACLs(t.ctx, "foo")
+	AssertEq(nil, err)
+	AssertEq(1, len(rules))
+	ExpectEq("allUsers", rules[0].Entity)
+	ExpectEq(gcs.ACLRoleReader, rules[0].Role)
+
+	bi, err := t.conn.GetBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+	ExpectEq(3, bi.MetaGeneration)
+	ExpectEq(1, len(bi.DefaultObjectACL))
+
+	// New objects should inherit it.
+	b, err := t.conn.OpenBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	_, err = gcsutil.CreateObject(t.ctx, b, "bar", []byte("taco"))
+	AssertEq(nil, err)
+
+	objectRules, err := b.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{Name: "bar"})
+
+	AssertEq(nil, err)
+	AssertEq(2, len(objectRules))
+	ExpectEq(gcs.ACLRoleOwner, objectRules[0].Role)
+	ExpectEq("allUsers", objectRules[1].Entity)
+	ExpectEq(gcs.ACLRoleReader, objectRules[1].Role)
+
+	// Remove the entry. Removing it again should fail.
+	req := &gcs.DeleteDefaultObjectACLRequest{
+		BucketName: "foo",
+		Entity:     "allUsers",
+	}
+
+	err = t.conn.DeleteDefaultObjectACL(t.ctx, req)
+	AssertEq(nil, err)
+
+	err = t.conn.DeleteDefaultObjectACL(t.ctx, req)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	// Objects created now should be private, and the earlier one unaffected.
+	_, err = gcsutil.CreateObject(t.ctx, b, "baz", []byte("burrito"))
+	AssertEq(nil, err)
+
+	objectRules, err = b.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{Name: "baz"})
+
+	AssertEq(nil, err)
+	ExpectEq(1, len(objectRules))
+
+	objectRules, err = b.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{Name: "bar"})
+
+	AssertEq(nil, err)
+	ExpectEq(2, len(objectRules))
+}
+
+func (t *ConnTest) DefaultObjectACL_UniformBucketLevelAccess() {
+	_, err := t.conn.CreateBucket(
+		t.ctx,
+		&gcs.CreateBucketRequest{
+			Name:                     "foo",
+			UniformBucketLevelAccess: true,
+		})
+
+	AssertEq(nil, err)
+
+	_, err = t.conn.ListDefaultObjectACLs(t.ctx, "foo")
+	ExpectThat(err, Error(HasSubstr("uniform bucket-level access")))
+
+	_, err = t.conn.UpdateDefaultObjectACL(
+		t.ctx,
+		&gcs.UpdateDefaultObjectACLRequest{
+			BucketName: "foo",
+			Entity:     "allUsers",
+			Role:       gcs.ACLRoleReader,
+		})
+
+	ExpectThat(err, Error(HasSubstr("uniform bucket-level access")))
+
+	_, err = t.conn.UpdateBucket(
+		t.ctx,
+		&gcs.UpdateBucketRequest{
+			BucketName:       "foo",
+			DefaultObjectACL: &[]gcs.ACLRule{},
+		})
+
+	ExpectThat(err, Error(HasSubstr("uniform bucket-level access")))
+}
+
+func (t *ConnTest) DeleteBucket() {
+	var err error
+
+	// Deleting a non-existent bucket should fail.
+	err = t.conn.DeleteBucket(t.ctx, "foo")
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	// Create a bucket with an object in it.
+	bucket, err := t.conn.OpenBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	_, err = gcsutil.CreateObject(t.ctx, bucket, "bar", []byte("taco"))
+	AssertEq(nil, err)
+
+	// Non-empty buckets can't be deleted.
+	err = t.conn.DeleteBucket(t.ctx, "foo")
+	ExpectThat(err, Error(HasSubstr("not empty")))
+
+	// Empty it out and try again.
+	err = bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "bar"})
+	AssertEq(nil, err)
+
+	err = t.conn.DeleteBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	buckets, err := t.conn.ListBuckets(t.ctx)
+	AssertEq(nil, err)
+	ExpectEq(0, len(buckets))
+}
+
+func (t *ConnTest) BucketIAMPolicy_NoSuchBucket() {
+	_, err := t.conn.GetBucketIAMPolicy(t.ctx, "foo")
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	_, err = t.conn.SetBucketIAMPolicy(
+		t.ctx,
+		&gcs.SetBucketIAMPolicyRequest{
+			BucketName: "foo",
+			Policy:     &gcs.IAMPolicy{},
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *ConnTest) BucketIAMPolicy_ReadModifyWrite() {
+	var err error
+
+	_, err = t.conn.OpenBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	// Read the initial policy.
+	p, err := t.conn.GetBucketIAMPolicy(t.ctx, "foo")
+	AssertEq(nil, err)
+	AssertNe("", p.Etag)
+
+	// Add a conditional binding and write it back.
+	p.Version = gcs.IAMPolicyVersion
+	p.Bindings = append(p.Bindings, gcs.IAMBinding{
+		Role:    "roles/storage.objectViewer",
+		Members: []string{"user:foo@example.com"},
+		Condition: &gcs.IAMCondition{
+			Title:      "expires",
+			Expression: `request.time < timestamp("2020-01-01T00:00:00Z")`,
+		},
+	})
+
+	newP, err := t.conn.SetBucketIAMPolicy(
+		t.ctx,
+		&gcs.SetBucketIAMPolicyRequest{BucketName: "foo", Policy: p})
+
+	AssertEq(nil, err)
+	ExpectNe(p.Etag, newP.Etag)
+
+	// Reading again should give the new policy.
+	p2, err := t.conn.GetBucketIAMPolicy(t.ctx, "foo")
+	AssertEq(nil, err)
+	ExpectEq(newP.Etag, p2.Etag)
+	AssertEq(1, len(p2.Bindings))
+	ExpectEq("roles/storage.objectViewer", p2.Bindings[0].Role)
+	ExpectThat(p2.Bindings[0].Members, ElementsAre("user:foo@example.com"))
+	AssertNe(nil, p2.Bindings[0].Condition)
+	ExpectEq("expires", p2.Bindings[0].Condition.Title)
+
+	// Writing with the stale etag should fail.
+	_, err = t.conn.SetBucketIAMPolicy(
+		t.ctx,
+		&gcs.SetBucketIAMPolicyRequest{BucketName: "foo", Policy: p})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+}
+
+func (t *ConnTest) BucketIAMPolicy_ConditionRequiresVersion3() {
+	_, err := t.conn.OpenBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	p := &gcs.IAMPolicy{
+		Version: 1,
+		Bindings: []gcs.IAMBinding{
+			{
+				Role:      "roles/storage.objectViewer",
+				Members:   []string{"allUsers"},
+				Condition: &gcs.IAMCondition{Expression: "true"},
+			},
+		},
+	}
+
+	_, err = t.conn.SetBucketIAMPolicy(
+		t.ctx,
+		&gcs.SetBucketIAMPolicyRequest{BucketName: "foo", Policy: p})
+
+	ExpectThat(err, Error(HasSubstr("version 3")))
+}
+
+func (t *ConnTest) RetentionPolicy_SetAndLock() {
+	var err error
+
+	_, err = t.conn.OpenBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	// Set a policy.
+	bi, err := t.conn.SetBucketRetentionPolicy(
+		t.ctx,
+		&gcs.SetBucketRetentionPolicyRequest{
+			BucketName: "foo",
+			Period:     time.Hour,
+		})
+
+	AssertEq(nil, err)
+	AssertNe(nil, bi.RetentionPolicy)
+	ExpectEq(time.Hour, bi.RetentionPolicy.Period)
+	ExpectFalse(bi.RetentionPolicy.IsLocked)
+
+	// Locking with the wrong meta-generation should fail.
+	_, err = t.conn.LockBucketRetentionPolicy(
+		t.ctx,
+		&gcs.LockBucketRetentionPolicyRequest{
+			BucketName:     "foo",
+			MetaGeneration: bi.MetaGeneration - 1,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// Lock it.
+	bi, err = t.conn.LockBucketRetentionPolicy(
+		t.ctx,
+		&gcs.LockBucketRetentionPolicyRequest{
+			BucketName:     "foo",
+			MetaGeneration: bi.MetaGeneration,
+		})
+
+	AssertEq(nil, err)
+	ExpectTrue(bi.RetentionPolicy.IsLocked)
+
+	// Now it can be lengthened, but not removed.
+	_, err = t.conn.SetBucketRetentionPolicy(
+		t.ctx,
+		&gcs.SetBucketRetentionPolicyRequest{BucketName: "foo"})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.ForbiddenError{}))
+
+	bi, err = t.conn.SetBucketRetentionPolicy(
+		t.ctx,
+		&gcs.SetBucketRetentionPolicyRequest{
+			BucketName: "foo",
+			Period:     2 * time.Hour,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(2*time.Hour, bi.RetentionPolicy.Period)
+}
+
+func (t *ConnTest) RetentionPolicy_Enforced() {
+	var err error
+
+	b, err := t.conn.OpenBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	_, err = t.conn.SetBucketRetentionPolicy(
+		t.ctx,
+		&gcs.SetBucketRetentionPolicyRequest{
+			BucketName: "foo",
+			Period:     time.Hour,
+		})
+
+	AssertEq(nil, err)
+
+	// Create an object, which should be retained for an hour.
+	o, err := gcsutil.CreateObject(t.ctx, b, "bar", []byte("taco"))
+	AssertEq(nil, err)
+	ExpectThat(
+		o.RetentionExpirationTime,
+		timeutil.TimeEq(t.clock.Now().Add(time.Hour)))
+
+	// It can't be deleted yet.
+	err = b.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "bar"})
+	ExpectThat(err, HasSameTypeAs(&gcs.ForbiddenError{}))
+
+	// After an hour it can.
+	t.clock.AdvanceTime(time.Hour)
+
+	err = b.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "bar"})
+	ExpectEq(nil, err)
+}
+
+func (t *ConnTest) DefaultKMSKey() {
+	const key = "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+	var err error
+
+	b, err := t.conn.OpenBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	// Unknown buckets.
+	_, err = t.conn.GetBucket(t.ctx, "bar")
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	// Set a default key.
+	bi, err := t.conn.SetBucketDefaultKMSKey(
+		t.ctx,
+		&gcs.SetBucketDefaultKMSKeyRequest{
+			BucketName: "foo",
+			KMSKeyName: key,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(key, bi.DefaultKMSKeyName)
+
+	bi, err = t.conn.GetBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+	ExpectEq(key, bi.DefaultKMSKeyName)
+
+	// New objects should pick it up, unless they have their own.
+	o, err := gcsutil.CreateObject(t.ctx, b, "bar", []byte("taco"))
+	AssertEq(nil, err)
+	ExpectEq(key, o.KMSKeyName)
+
+	o, err = b.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:       "baz",
+			Contents:   strings.NewReader("burrito"),
+			KMSKeyName: key + "2",
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(key+"2", o.KMSKeyName)
+
+	// Remove the key, with a stale precondition and then properly.
+	precond := bi.MetaGeneration - 1
+	_, err = t.conn.SetBucketDefaultKMSKey(
+		t.ctx,
+		&gcs.SetBucketDefaultKMSKeyRequest{
+			BucketName:                 "foo",
+			MetaGenerationPrecondition: &precond,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	bi, err = t.conn.SetBucketDefaultKMSKey(
+		t.ctx,
+		&gcs.SetBucketDefaultKMSKeyRequest{BucketName: "foo"})
+
+	AssertEq(nil, err)
+	ExpectEq("", bi.DefaultKMSKeyName)
+
+	o, err = gcsutil.CreateObject(t.ctx, b, "qux", []byte("enchilada"))
+	AssertEq(nil, err)
+	ExpectEq("", o.KMSKeyName)
+}
+
+func (t *ConnTest) SoftDeletePolicy() {
+	var err error
+
+	_, err = t.conn.OpenBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	// There's no policy to begin with.
+	bi, err := t.conn.GetBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+	ExpectEq(nil, bi.SoftDeletePolicy)
+
+	// GCS insists on between seven and 90 days.
+	for _, d := range []time.Duration{24 * time.Hour, 91 * 24 * time.Hour} {
+		_, err = t.conn.SetBucketSoftDeletePolicy(
+			t.ctx,
+			&gcs.SetBucketSoftDeletePolicyRequest{
+				BucketName:        "foo",
+				RetentionDuration: d,
+			})
+
+		ExpectThat(err, Error(HasSubstr("between 7 and 90 days")), "%v", d)
+	}
+
+	// Set a policy, with a stale precondition and then properly.
+	precond := bi.MetaGeneration - 1
+	_, err = t.conn.SetBucketSoftDeletePolicy(
+		t.ctx,
+		&gcs.SetBucketSoftDeletePolicyRequest{
+			BucketName:                 "foo",
+			RetentionDuration:          7 * 24 * time.Hour,
+			MetaGenerationPrecondition: &precond,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	bi, err = t.conn.SetBucketSoftDeletePolicy(
+		t.ctx,
+		&gcs.SetBucketSoftDeletePolicyRequest{
+			BucketName:        "foo",
+			RetentionDuration: 7*24*time.Hour + time.Millisecond,
+		})
+
+	AssertEq(nil, err)
+	AssertNe(nil, bi.SoftDeletePolicy)
+	ExpectEq(7*24*time.Hour, bi.SoftDeletePolicy.RetentionDuration)
+	ExpectThat(bi.SoftDeletePolicy.EffectiveTime, timeutil.TimeEq(t.clock.Now()))
+
+	bi, err = t.conn.GetBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+	AssertNe(nil, bi.SoftDeletePolicy)
+	ExpectEq(7*24*time.Hour, bi.SoftDeletePolicy.RetentionDuration)
+
+	// Disable it again.
+	bi, err = t.conn.SetBucketSoftDeletePolicy(
+		t.ctx,
+		&gcs.SetBucketSoftDeletePolicyRequest{BucketName: "foo"})
+
+	AssertEq(nil, err)
+	ExpectEq(nil, bi.SoftDeletePolicy)
+}
+
+func (t *ConnTest) SoftDeletePolicy_DeleteAndRestore() {
+	var err error
+
+	b, err := t.conn.OpenBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	// Objects deleted without a policy are gone for good.
+	_, err = gcsutil.CreateObject(t.ctx, b, "taco", []byte("0"))
+	AssertEq(nil, err)
+
+	err = b.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "taco"})
+	AssertEq(nil, err)
+
+	// Enable soft delete, then delete one object and overwrite another.
+	_, err = t.conn.SetBucketSoftDeletePolicy(
+		t.ctx,
+		&gcs.SetBucketSoftDeletePolicyRequest{
+			BucketName:        "foo",
+			RetentionDuration: 7 * 24 * time.Hour,
+		})
+
+	AssertEq(nil, err)
+
+	bar, err := gcsutil.CreateObject(t.ctx, b, "bar", []byte("1"))
+	AssertEq(nil, err)
+
+	baz, err := gcsutil.CreateObject(t.ctx, b, "baz", []byte("2"))
+	AssertEq(nil, err)
+
+	_, err = gcsutil.CreateObject(t.ctx, b, "baz", []byte("3"))
+	AssertEq(nil, err)
+
+	t.clock.AdvanceTime(time.Hour)
+	deleteTime := t.clock.Now()
+
+	err = b.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "bar"})
+	AssertEq(nil, err)
+
+	// Ordinary listings see only the live object.
+	listing, err := b.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("baz", listing.Objects[0].Name)
+
+	// Soft-deleted listings see the other two.
+	listing, err = b.ListObjects(t.ctx, &gcs.ListObjectsRequest{SoftDeleted: true})
+	AssertEq(nil, err)
+	AssertEq(2, len(listing.Objects))
+
+	o := listing.Objects[0]
+	ExpectEq("bar", o.Name)
+	ExpectEq(bar.Generation, o.Generation)
+	ExpectThat(o.SoftDeleteTime, timeutil.TimeEq(deleteTime))
+	ExpectThat(o.HardDeleteTime, timeutil.TimeEq(deleteTime.Add(7*24*time.Hour)))
+
+	o = listing.Objects[1]
+	ExpectEq("baz", o.Name)
+	ExpectEq(baz.Generation, o.Generation)
+
+	// Restoring the overwritten object fails if we insist there be no live
+	// object.
+	precond := int64(0)
+	_, err = b.RestoreObject(
+		t.ctx,
+		&gcs.RestoreObjectRequest{
+			Name:                   "baz",
+			Generation:             baz.Generation,
+			GenerationPrecondition: &precond,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// Unknown generations aren't found.
+	_, err = b.RestoreObject(
+		t.ctx,
+		&gcs.RestoreObjectRequest{Name: "bar", Generation: bar.Generation + 100})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	// Restore the deleted object. It comes back as a new generation.
+	o, err = b.RestoreObject(
+		t.ctx,
+		&gcs.RestoreObjectRequest{
+			Name:                   "bar",
+			Generation:             bar.Generation,
+			GenerationPrecondition: &precond,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("bar", o.Name)
+	ExpectLt(bar.Generation, o.Generation)
+	ExpectTrue(o.SoftDeleteTime.IsZero())
+	ExpectTrue(o.HardDeleteTime.IsZero())
+
+	contents, err := gcsutil.ReadObject(t.ctx, b, "bar")
+	AssertEq(nil, err)
+	ExpectEq("1", string(contents))
+
+	// It can't be restored twice.
+	_, err = b.RestoreObject(
+		t.ctx,
+		&gcs.RestoreObjectRequest{Name: "bar", Generation: bar.Generation})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	// Once the retention duration has passed, the other object is gone.
+	t.clock.AdvanceTime(7 * 24 * time.Hour)
+
+	listing, err = b.ListObjects(t.ctx, &gcs.ListObjectsRequest{SoftDeleted: true})
+	AssertEq(nil, err)
+	ExpectEq(0, len(listing.Objects))
+
+	_, err = b.RestoreObject(
+		t.ctx,
+		&gcs.RestoreObjectRequest{Name: "baz", Generation: baz.Generation})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *ConnTest) Notifications() {
+	var err error
+
+	// A non-existent bucket should be reported.
+	_, err = t.conn.ListNotifications(t.ctx, "foo")
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	_, err = t.conn.OpenBucket(t.ctx, "foo")
+	AssertEq(nil, err)
+
+	// Create two configurations.
+	n0, err := t.conn.CreateNotification(
+		t.ctx,
+		&gcs.CreateNotificationRequest{
+			BucketName: "foo",
+			Notification: gcs.Notification{
+				Topic:      "projects/p/topics/a",
+				EventTypes: []string{gcs.NotificationObjectFinalize},
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectNe("", n0.ID)
+	ExpectEq(gcs.NotificationPayloadJSON, n0.PayloadFormat)
+
+	n1, err := t.conn.CreateNotification(
+		t.ctx,
+		&gcs.CreateNotificationRequest{
+			BucketName: "foo",
+			Notification: gcs.Notification{
+				Topic:      "projects/p/topics/b",
+				EventTypes: []string{gcs.NotificationObjectDelete},
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectNe(n0.ID, n1.ID)
+
+	// Unknown event types should be refused.
+	_, err = t.conn.CreateNotification(
+		t.ctx,
+		&gcs.CreateNotificationRequest{
+			BucketName: "foo",
+			Notification: gcs.Notification{
+				Topic:      "projects/p/topics/c",
+				EventTypes: []string{"OBJECT_EXPLODE"},
+			},
+		})
+
+	ExpectThat(err, Error(HasSubstr("OBJECT_EXPLODE")))
+
+	// List.
+	ns, err := t.conn.ListNotifications(t.ctx, "foo")
+	AssertEq(nil, err)
+	AssertEq(2, len(ns))
+	ExpectEq("projects/p/topics/a", ns[0].Topic)
+	ExpectEq("projects/p/topics/b", ns[1].Topic)
+
+	// Delete one.
+	err = t.conn.DeleteNotification(t.ctx, "foo", n0.ID)
+	AssertEq(nil, err)
+
+	err = t.conn.DeleteNotification(t.ctx, "foo", n0.ID)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	ns, err = t.conn.ListNotifications(t.ctx, "foo")
+	AssertEq(nil, err)
+	AssertEq(1, len(ns))
+	ExpectEq(n1.ID, ns[0].ID)
+}
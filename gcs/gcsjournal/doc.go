@@ -0,0 +1,30 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcsjournal runs large batches of object operations, such as the
+// deletes and copies of a mass migration, in a way that survives crashes.
+//
+// The operations are first recorded in a journal object with Create. Run then
+// executes them in parallel, periodically checkpointing how far it has got in
+// the journal object's metadata. If the process dies part way through, a
+// later call to Run picks up from the last checkpoint rather than starting
+// again.
+//
+// Operations after the last checkpoint may be executed again after a crash,
+// so each must be safe to repeat; those offered by this package are, given
+// the caveats on OpCopy and OpMove. Operations run concurrently and in no
+// particular order, so a journal must not contain operations that depend on
+// one another, such as a copy and a delete of its source. Use OpMove for
+// that.
+package gcsjournal
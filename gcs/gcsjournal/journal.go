@@ -0,0 +1,280 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsjournal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Kinds of operation, for Op.Kind.
+const (
+	// Delete the object Name, or the given generation of it. Deleting an
+	// object that doesn't exist succeeds, so without a generation a repeat
+	// after a crash may delete an object that has since been recreated.
+	OpDelete = "delete"
+
+	// Copy the object Name, or the given generation of it, to DstName. A
+	// repeat overwrites the destination with the same contents.
+	OpCopy = "copy"
+
+	// Move the object Name, or the given generation of it, to DstName. If the
+	// source no longer exists, the move is assumed to have happened already.
+	OpMove = "move"
+)
+
+// The default for Config.Parallelism.
+const DefaultParallelism = 8
+
+// The default for Config.CheckpointInterval.
+const DefaultCheckpointInterval = 1000
+
+// Metadata keys on the journal object, holding the version of the journal
+// format, the number of operations, and the number known to be complete.
+const (
+	versionKey   = "gcsjournal-version"
+	opsKey       = "gcsjournal-ops"
+	completedKey = "gcsjournal-completed"
+)
+
+const journalVersion = "1"
+
+// The journal's contents are its operations, one JSON object per line.
+const journalContentType = "application/x-ndjson"
+
+// An operation recorded in a journal.
+type Op struct {
+	// One of OpDelete, OpCopy, or OpMove.
+	Kind string `json:"kind"`
+
+	// The object to operate on: the one to delete, or the source of a copy or
+	// move.
+	Name string `json:"name"`
+
+	// If non-zero, the generation of Name to operate on. Otherwise the latest
+	// generation at the time the operation is executed is used.
+	Generation int64 `json:"generation,omitempty"`
+
+	// The destination of a copy or move.
+	DstName string `json:"dstName,omitempty"`
+}
+
+// Options accepted by the functions in this package.
+type Config struct {
+	// The bucket holding the objects that the operations act on. Must be set.
+	Bucket gcs.Bucket
+
+	// The bucket in which to keep the journal object. If nil, Bucket is used,
+	// in which case take care that the operations don't touch the journal.
+	JournalBucket gcs.Bucket
+
+	// The name of the journal object. Must be set. Nothing but this package
+	// may modify the object while its operations are being run.
+	Name string
+
+	// The maximum number of operations that Run executes at once. If zero,
+	// DefaultParallelism is used.
+	Parallelism int
+
+	// Run checkpoints its progress each time this many more operations are
+	// known to be complete, and again when it returns. This is the most work
+	// that may be repeated after a crash, give or take the operations that
+	// were in flight. If zero, DefaultCheckpointInterval is used.
+	CheckpointInterval int64
+}
+
+// Return a copy of the supplied config with defaults filled in.
+func withDefaults(in *Config) (cfg Config, err error) {
+	cfg = *in
+
+	if cfg.Bucket == nil {
+		err = errors.New("Config.Bucket must be set")
+		return
+	}
+
+	if cfg.Name == "" {
+		err = errors.New("Config.Name must be set")
+		return
+	}
+
+	if cfg.JournalBucket == nil {
+		cfg.JournalBucket = cfg.Bucket
+	}
+
+	if cfg.Parallelism == 0 {
+		cfg.Parallelism = DefaultParallelism
+	}
+
+	if cfg.CheckpointInterval == 0 {
+		cfg.CheckpointInterval = DefaultCheckpointInterval
+	}
+
+	if cfg.Parallelism < 0 || cfg.CheckpointInterval < 0 {
+		err = errors.New("Config fields must not be negative")
+		return
+	}
+
+	return
+}
+
+// Return an error if the supplied operation is malformed.
+func checkOp(op *Op) (err error) {
+	if op.Name == "" {
+		err = errors.New("Name must be set")
+		return
+	}
+
+	switch op.Kind {
+	case OpDelete:
+		if op.DstName != "" {
+			err = errors.New("DstName must not be set for a delete")
+			return
+		}
+
+	case OpCopy, OpMove:
+		if op.DstName == "" {
+			err = fmt.Errorf("DstName must be set for a %s", op.Kind)
+			return
+		}
+
+	default:
+		err = fmt.Errorf("Unknown Kind: %q", op.Kind)
+		return
+	}
+
+	return
+}
+
+// Record the supplied operations in a new journal object, ready for Run. If
+// the journal object already exists, return an error of type
+// *gcs.PreconditionError.
+//
+// The operations are streamed to GCS as they're encoded, so the journal may
+// be much larger than what's held in memory at once.
+func Create(
+	ctx context.Context,
+	cfg *Config,
+	ops []Op) (err error) {
+	c, err := withDefaults(cfg)
+	if err != nil {
+		return
+	}
+
+	for i := range ops {
+		if err = checkOp(&ops[i]); err != nil {
+			err = fmt.Errorf("Op %d: %v", i, err)
+			return
+		}
+	}
+
+	var zero int64
+	w := gcs.NewObjectWriter(
+		ctx,
+		c.JournalBucket,
+		&gcs.CreateObjectRequest{
+			Name:        c.Name,
+			ContentType: journalContentType,
+			Metadata: map[string]string{
+				versionKey:   journalVersion,
+				opsKey:       fmt.Sprint(len(ops)),
+				completedKey: "0",
+			},
+			GenerationPrecondition: &zero,
+			DiscardResponse:        true,
+		})
+
+	// json.Encoder writes a newline after each value. Writing can only fail
+	// if the upload has, in which case Close says why. Don't wrap its errors,
+	// so that precondition errors come through intact.
+	enc := json.NewEncoder(w)
+	for i := range ops {
+		if err = enc.Encode(&ops[i]); err != nil {
+			if closeErr := w.Close(); closeErr != nil {
+				err = closeErr
+			}
+
+			return
+		}
+	}
+
+	err = w.Close()
+	return
+}
+
+// How far through its operations a journal has got.
+type Progress struct {
+	// The number of operations in the journal.
+	Total int64
+
+	// The number of operations known to be complete, as of the last
+	// checkpoint. The journal is done when this equals Total.
+	Completed int64
+}
+
+// Return the progress recorded in the journal object. If it doesn't exist,
+// return an error of type *gcs.NotFoundError.
+func Status(
+	ctx context.Context,
+	cfg *Config) (p Progress, err error) {
+	c, err := withDefaults(cfg)
+	if err != nil {
+		return
+	}
+
+	o, err := c.JournalBucket.StatObject(
+		ctx,
+		&gcs.StatObjectRequest{Name: c.Name})
+
+	if err != nil {
+		return
+	}
+
+	p, err = readProgress(o)
+	return
+}
+
+// Read the progress recorded in the metadata of the supplied journal object.
+func readProgress(o *gcs.Object) (p Progress, err error) {
+	if v := o.Metadata[versionKey]; v != journalVersion {
+		err = fmt.Errorf("Unsupported journal version %q for %q", v, o.Name)
+		return
+	}
+
+	if p.Total, err = strconv.ParseInt(o.Metadata[opsKey], 10, 64); err != nil {
+		err = fmt.Errorf("Parsing %s: %v", opsKey, err)
+		return
+	}
+
+	if p.Completed, err = strconv.ParseInt(o.Metadata[completedKey], 10, 64); err != nil {
+		err = fmt.Errorf("Parsing %s: %v", completedKey, err)
+		return
+	}
+
+	if p.Completed < 0 || p.Completed > p.Total {
+		err = fmt.Errorf(
+			"Journal %q claims %d of %d operations complete",
+			o.Name,
+			p.Completed,
+			p.Total)
+		return
+	}
+
+	return
+}
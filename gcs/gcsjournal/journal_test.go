@@ -0,0 +1,319 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsjournal_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsjournal"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestJournal(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type JournalTest struct {
+	ctx    context.Context
+	bucket gcs.Bucket
+	cfg    *gcsjournal.Config
+}
+
+var _ SetUpInterface = &JournalTest{}
+
+func init() { RegisterTestSuite(&JournalTest{}) }
+
+func (t *JournalTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.bucket = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	t.cfg = &gcsjournal.Config{
+		Bucket:             t.bucket,
+		JournalBucket:      gcsfake.NewFakeBucket(timeutil.RealClock(), "journals"),
+		Name:               "journal",
+		CheckpointInterval: 2,
+	}
+}
+
+func (t *JournalTest) create(names ...string) {
+	for _, name := range names {
+		_, err := gcsutil.CreateObject(t.ctx, t.bucket, name, []byte(name))
+		AssertEq(nil, err)
+	}
+}
+
+func (t *JournalTest) exists(name string) bool {
+	_, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: name})
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		return false
+	}
+
+	AssertEq(nil, err)
+	return true
+}
+
+func (t *JournalTest) read(name string) string {
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, name)
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+// Record the progress in the journal object, as if an earlier run had got
+// that far.
+func (t *JournalTest) setCompleted(n int) {
+	completed := fmt.Sprint(n)
+	_, err := t.cfg.JournalBucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:     t.cfg.Name,
+			Metadata: map[string]*string{"gcsjournal-completed": &completed},
+		})
+
+	AssertEq(nil, err)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *JournalTest) EmptyJournal() {
+	err := gcsjournal.Create(t.ctx, t.cfg, nil)
+	AssertEq(nil, err)
+
+	p, err := gcsjournal.Run(t.ctx, t.cfg)
+	AssertEq(nil, err)
+	ExpectEq(0, p.Total)
+	ExpectEq(0, p.Completed)
+}
+
+func (t *JournalTest) RunsAllOperations() {
+	t.create("a", "b", "c", "d")
+
+	ops := []gcsjournal.Op{
+		{Kind: gcsjournal.OpDelete, Name: "a"},
+		{Kind: gcsjournal.OpCopy, Name: "b", DstName: "b2"},
+		{Kind: gcsjournal.OpMove, Name: "c", DstName: "c2"},
+		{Kind: gcsjournal.OpDelete, Name: "nonexistent"},
+		{Kind: gcsjournal.OpMove, Name: "d", DstName: "d2"},
+	}
+
+	err := gcsjournal.Create(t.ctx, t.cfg, ops)
+	AssertEq(nil, err)
+
+	p, err := gcsjournal.Status(t.ctx, t.cfg)
+	AssertEq(nil, err)
+	ExpectEq(5, p.Total)
+	ExpectEq(0, p.Completed)
+
+	p, err = gcsjournal.Run(t.ctx, t.cfg)
+	AssertEq(nil, err)
+	ExpectEq(5, p.Total)
+	ExpectEq(5, p.Completed)
+
+	ExpectFalse(t.exists("a"))
+	ExpectEq("b", t.read("b"))
+	ExpectEq("b", t.read("b2"))
+	ExpectFalse(t.exists("c"))
+	ExpectEq("c", t.read("c2"))
+	ExpectFalse(t.exists("d"))
+	ExpectEq("d", t.read("d2"))
+
+	// The progress should have been recorded.
+	p, err = gcsjournal.Status(t.ctx, t.cfg)
+	AssertEq(nil, err)
+	ExpectEq(5, p.Completed)
+}
+
+func (t *JournalTest) ParticularGeneration() {
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "a", []byte("taco"))
+	AssertEq(nil, err)
+
+	// Journal a delete of this generation.
+	ops := []gcsjournal.Op{
+		{Kind: gcsjournal.OpDelete, Name: "a", Generation: o.Generation},
+	}
+
+	err = gcsjournal.Create(t.ctx, t.cfg, ops)
+	AssertEq(nil, err)
+
+	// If the object is recreated before the journal is run, the new generation
+	// should be left alone.
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "a", []byte("burrito"))
+	AssertEq(nil, err)
+
+	_, err = gcsjournal.Run(t.ctx, t.cfg)
+	AssertEq(nil, err)
+	ExpectEq("burrito", t.read("a"))
+}
+
+func (t *JournalTest) ResumesFromCheckpoint() {
+	t.create("a", "b", "c")
+
+	ops := []gcsjournal.Op{
+		{Kind: gcsjournal.OpDelete, Name: "a"},
+		{Kind: gcsjournal.OpDelete, Name: "b"},
+		{Kind: gcsjournal.OpDelete, Name: "c"},
+	}
+
+	err := gcsjournal.Create(t.ctx, t.cfg, ops)
+	AssertEq(nil, err)
+
+	// Pretend that a previous run checkpointed after the first two.
+	t.setCompleted(2)
+
+	p, err := gcsjournal.Run(t.ctx, t.cfg)
+	AssertEq(nil, err)
+	ExpectEq(3, p.Completed)
+
+	// Only the last should have been run.
+	ExpectTrue(t.exists("a"))
+	ExpectTrue(t.exists("b"))
+	ExpectFalse(t.exists("c"))
+}
+
+func (t *JournalTest) RepeatedMoveSucceeds() {
+	t.create("a")
+
+	ops := []gcsjournal.Op{
+		{Kind: gcsjournal.OpMove, Name: "a", DstName: "b"},
+	}
+
+	err := gcsjournal.Create(t.ctx, t.cfg, ops)
+	AssertEq(nil, err)
+
+	_, err = gcsjournal.Run(t.ctx, t.cfg)
+	AssertEq(nil, err)
+
+	// Simulate a crash before the checkpoint, and run again.
+	t.setCompleted(0)
+
+	p, err := gcsjournal.Run(t.ctx, t.cfg)
+	AssertEq(nil, err)
+	ExpectEq(1, p.Completed)
+	ExpectEq("a", t.read("b"))
+}
+
+func (t *JournalTest) FailedOperationCheckpointsProgress() {
+	t.create("a", "c")
+	t.cfg.Parallelism = 1
+
+	ops := []gcsjournal.Op{
+		{Kind: gcsjournal.OpDelete, Name: "a"},
+		{Kind: gcsjournal.OpCopy, Name: "nonexistent", DstName: "b"},
+		{Kind: gcsjournal.OpDelete, Name: "c"},
+	}
+
+	err := gcsjournal.Create(t.ctx, t.cfg, ops)
+	AssertEq(nil, err)
+
+	p, err := gcsjournal.Run(t.ctx, t.cfg)
+	ExpectThat(err, Error(HasSubstr("nonexistent")))
+	ExpectEq(3, p.Total)
+	ExpectEq(1, p.Completed)
+
+	p, err = gcsjournal.Status(t.ctx, t.cfg)
+	AssertEq(nil, err)
+	ExpectEq(1, p.Completed)
+
+	// Fix the problem and resume.
+	t.create("nonexistent")
+
+	p, err = gcsjournal.Run(t.ctx, t.cfg)
+	AssertEq(nil, err)
+	ExpectEq(3, p.Completed)
+	ExpectEq("nonexistent", t.read("b"))
+	ExpectFalse(t.exists("c"))
+}
+
+func (t *JournalTest) ManyOperations() {
+	const n = 100
+
+	var ops []gcsjournal.Op
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("%03d", i)
+		t.create(name)
+		ops = append(ops, gcsjournal.Op{Kind: gcsjournal.OpDelete, Name: name})
+	}
+
+	t.cfg.CheckpointInterval = 7
+
+	err := gcsjournal.Create(t.ctx, t.cfg, ops)
+	AssertEq(nil, err)
+
+	p, err := gcsjournal.Run(t.ctx, t.cfg)
+	AssertEq(nil, err)
+	ExpectEq(n, p.Completed)
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+	ExpectEq(0, len(listing.Objects))
+}
+
+func (t *JournalTest) JournalAlreadyExists() {
+	err := gcsjournal.Create(t.ctx, t.cfg, nil)
+	AssertEq(nil, err)
+
+	err = gcsjournal.Create(t.ctx, t.cfg, nil)
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+}
+
+func (t *JournalTest) InvalidOperations() {
+	testCases := []struct {
+		op       gcsjournal.Op
+		expected string
+	}{
+		{gcsjournal.Op{Kind: gcsjournal.OpDelete}, "Name"},
+		{gcsjournal.Op{Kind: gcsjournal.OpCopy, Name: "a"}, "DstName"},
+		{gcsjournal.Op{Kind: gcsjournal.OpMove, Name: "a"}, "DstName"},
+		{gcsjournal.Op{Kind: gcsjournal.OpDelete, Name: "a", DstName: "b"}, "DstName"},
+		{gcsjournal.Op{Kind: "frobnicate", Name: "a"}, "frobnicate"},
+	}
+
+	for i, tc := range testCases {
+		err := gcsjournal.Create(t.ctx, t.cfg, []gcsjournal.Op{tc.op})
+		ExpectThat(err, Error(HasSubstr(tc.expected)), "Test case %d", i)
+	}
+
+	// Nothing should have been written.
+	_, err := gcsjournal.Status(t.ctx, t.cfg)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *JournalTest) MissingJournal() {
+	_, err := gcsjournal.Run(t.ctx, t.cfg)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *JournalTest) NotAJournal() {
+	_, err := gcsutil.CreateObject(
+		t.ctx,
+		t.cfg.JournalBucket,
+		t.cfg.Name,
+		[]byte("taco"))
+
+	AssertEq(nil, err)
+
+	_, err = gcsjournal.Run(t.ctx, t.cfg)
+	ExpectThat(err, Error(HasSubstr("version")))
+}
@@ -0,0 +1,338 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsjournal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/syncutil"
+	"golang.org/x/net/context"
+)
+
+// Execute the operations in the journal that aren't yet known to be complete,
+// checkpointing progress along the way (see Config.CheckpointInterval), and
+// return the progress recorded by the final checkpoint. That checkpoint is
+// made even if an operation fails, so that a later call can pick up from
+// there. Running a journal that is already done has no effect.
+//
+// Runs of the same journal must not overlap. If they do, all but one will
+// fail when they next checkpoint, though they may have executed some
+// operations twice by then. If the journal object doesn't exist, return an
+// error of type *gcs.NotFoundError.
+func Run(
+	ctx context.Context,
+	cfg *Config) (p Progress, err error) {
+	c, err := withDefaults(cfg)
+	if err != nil {
+		return
+	}
+
+	o, err := c.JournalBucket.StatObject(
+		ctx,
+		&gcs.StatObjectRequest{Name: c.Name})
+
+	if err != nil {
+		return
+	}
+
+	if p, err = readProgress(o); err != nil {
+		return
+	}
+
+	if p.Completed == p.Total {
+		return
+	}
+
+	r := &runner{
+		cfg:          c,
+		total:        p.Total,
+		journal:      o,
+		completed:    p.Completed,
+		checkpointed: p.Completed,
+		finished:     make(map[int64]bool),
+	}
+
+	err = r.run(ctx)
+	p.Completed = r.checkpointed
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// An operation along with its position in the journal.
+type indexedOp struct {
+	index int64
+	op    Op
+}
+
+// The state of a call to Run. The fields below total are touched only by
+// collect until the bundle running it has been joined.
+type runner struct {
+	cfg   Config
+	total int64
+
+	// The journal object as of the last checkpoint. Its meta-generation is a
+	// precondition for the next, so that we notice if someone else is running
+	// the journal too.
+	journal *gcs.Object
+
+	// The number of operations at the start of the journal that are known to
+	// be complete, and the number recorded by the last checkpoint.
+	completed    int64
+	checkpointed int64
+
+	// Operations beyond the first completed that have finished, by index.
+	finished map[int64]bool
+}
+
+// Execute the outstanding operations and checkpoint the progress made.
+func (r *runner) run(ctx context.Context) (err error) {
+	// Read the generation we statted, so that the operations match the
+	// recorded progress.
+	rc, err := r.cfg.JournalBucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       r.journal.Name,
+			Generation: r.journal.Generation,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	// Read operations, execute them, and collect the results, in parallel.
+	b := syncutil.NewBundle(ctx)
+
+	todo := make(chan indexedOp, r.cfg.Parallelism)
+	start := r.completed
+	b.Add(func(ctx context.Context) (err error) {
+		defer close(todo)
+		err = r.readOps(ctx, rc, start, todo)
+		return
+	})
+
+	done := make(chan int64, r.cfg.Parallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < r.cfg.Parallelism; i++ {
+		wg.Add(1)
+		b.Add(func(ctx context.Context) (err error) {
+			defer wg.Done()
+			err = r.execute(ctx, todo, done)
+			return
+		})
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	b.Add(func(ctx context.Context) (err error) {
+		err = r.collect(ctx, done)
+		return
+	})
+
+	err = b.Join()
+
+	// Record whatever progress was made, even if something failed.
+	if r.completed > r.checkpointed {
+		checkpointErr := r.checkpoint(ctx)
+		if err == nil {
+			err = checkpointErr
+		}
+	}
+
+	return
+}
+
+// Decode the operations in the journal, sending those from index start
+// onward to the supplied channel.
+func (r *runner) readOps(
+	ctx context.Context,
+	contents io.Reader,
+	start int64,
+	todo chan<- indexedOp) (err error) {
+	dec := json.NewDecoder(contents)
+
+	for i := int64(0); ; i++ {
+		var op Op
+		err = dec.Decode(&op)
+
+		if err == io.EOF {
+			err = nil
+			if i != r.total {
+				err = fmt.Errorf(
+					"Journal contains %d operations; expected %d",
+					i,
+					r.total)
+			}
+
+			return
+		}
+
+		if err != nil {
+			err = fmt.Errorf("Decoding op %d: %v", i, err)
+			return
+		}
+
+		// Skip those already complete.
+		if i < start {
+			continue
+		}
+
+		if err = checkOp(&op); err != nil {
+			err = fmt.Errorf("Op %d: %v", i, err)
+			return
+		}
+
+		select {
+		case todo <- indexedOp{index: i, op: op}:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+// Execute operations from todo until it is closed, reporting the index of
+// each once it's done.
+func (r *runner) execute(
+	ctx context.Context,
+	todo <-chan indexedOp,
+	done chan<- int64) (err error) {
+	for iop := range todo {
+		if err = r.executeOp(ctx, &iop.op); err != nil {
+			err = fmt.Errorf(
+				"Op %d (%s %q): %v",
+				iop.index,
+				iop.op.Kind,
+				iop.op.Name,
+				err)
+			return
+		}
+
+		select {
+		case done <- iop.index:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+
+	return
+}
+
+func (r *runner) executeOp(ctx context.Context, op *Op) (err error) {
+	switch op.Kind {
+	case OpDelete:
+		err = r.cfg.Bucket.DeleteObject(
+			ctx,
+			&gcs.DeleteObjectRequest{
+				Name:       op.Name,
+				Generation: op.Generation,
+			})
+
+	case OpCopy:
+		_, err = r.cfg.Bucket.CopyObject(
+			ctx,
+			&gcs.CopyObjectRequest{
+				SrcName:       op.Name,
+				SrcGeneration: op.Generation,
+				DstName:       op.DstName,
+			})
+
+	case OpMove:
+		_, err = r.cfg.Bucket.MoveObject(
+			ctx,
+			&gcs.MoveObjectRequest{
+				SrcName:       op.Name,
+				SrcGeneration: op.Generation,
+				DstName:       op.DstName,
+			})
+
+		// A missing source means that the move has probably already happened,
+		// before a crash.
+		if _, ok := err.(*gcs.NotFoundError); ok {
+			err = nil
+		}
+	}
+
+	return
+}
+
+// Track the indices of finished operations until done is closed, advancing
+// completed and checkpointing as appropriate.
+func (r *runner) collect(
+	ctx context.Context,
+	done <-chan int64) (err error) {
+	for i := range done {
+		r.finished[i] = true
+		for r.finished[r.completed] {
+			delete(r.finished, r.completed)
+			r.completed++
+		}
+
+		if r.completed-r.checkpointed >= r.cfg.CheckpointInterval {
+			if err = r.checkpoint(ctx); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// Record the current value of completed in the journal object.
+func (r *runner) checkpoint(ctx context.Context) (err error) {
+	completed := fmt.Sprint(r.completed)
+	o, err := r.cfg.JournalBucket.UpdateObject(
+		ctx,
+		&gcs.UpdateObjectRequest{
+			Name:                       r.journal.Name,
+			Generation:                 r.journal.Generation,
+			MetaGenerationPrecondition: &r.journal.MetaGeneration,
+			Metadata: map[string]*string{
+				completedKey: &completed,
+			},
+		})
+
+	if err != nil {
+		if _, ok := err.(*gcs.PreconditionError); ok {
+			err = fmt.Errorf(
+				"Journal %q modified by another runner: %v",
+				r.journal.Name,
+				err)
+			return
+		}
+
+		err = fmt.Errorf("Checkpointing: %v", err)
+		return
+	}
+
+	r.journal = o
+	r.checkpointed = r.completed
+
+	return
+}
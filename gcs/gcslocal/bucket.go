@@ -0,0 +1,1663 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcslocal
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/internal/customtime"
+	"github.com/jacobsa/syncutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// Create a bucket with the given name that stores its objects in the given
+// directory, creating the directory if it doesn't exist and otherwise picking
+// up the objects stored there by a previous bucket.
+func NewLocalBucket(
+	clock timeutil.Clock,
+	name string,
+	dir string) (b gcs.Bucket, err error) {
+	lb := &bucket{
+		clock: clock,
+		name:  name,
+		dir:   dir,
+	}
+
+	lb.mu = syncutil.NewInvariantMutex(lb.checkInvariants)
+
+	lb.mu.Lock()
+	err = lb.load()
+	lb.mu.Unlock()
+
+	if err != nil {
+		err = fmt.Errorf("Loading %q: %v", dir, err)
+		return
+	}
+
+	b = lb
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helper types
+////////////////////////////////////////////////////////////////////////
+
+// A gcs.ReadSeekCloser that serves an object's contents from a file.
+type readSeekCloser struct {
+	io.ReadSeeker
+	file *os.File
+
+	// If non-nil, called with the number of bytes read so far.
+	progress  func(int64)
+	bytesRead int64
+}
+
+func (rsc *readSeekCloser) Read(p []byte) (n int, err error) {
+	n, err = rsc.ReadSeeker.Read(p)
+	if n > 0 && rsc.progress != nil {
+		rsc.bytesRead += int64(n)
+		rsc.progress(rsc.bytesRead)
+	}
+
+	return
+}
+
+func (rsc *readSeekCloser) Close() (err error) {
+	err = rsc.file.Close()
+	return
+}
+
+// A slice of records compared by name.
+type recordSlice []record
+
+func (s recordSlice) Len() int {
+	return len(s)
+}
+
+func (s recordSlice) Less(i, j int) bool {
+	return s[i].Metadata.Name < s[j].Metadata.Name
+}
+
+func (s recordSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Return the smallest i such that s[i].Metadata.Name >= name, or len(s) if
+// there is no such i.
+func (s recordSlice) lowerBound(name string) int {
+	pred := func(i int) bool {
+		return s[i].Metadata.Name >= name
+	}
+
+	return sort.Search(len(s), pred)
+}
+
+// Return the smallest i such that s[i].Metadata.Name == name, or len(s) if
+// there is no such i.
+func (s recordSlice) find(name string) int {
+	lb := s.lowerBound(name)
+	if lb < len(s) && s[lb].Metadata.Name == name {
+		return lb
+	}
+
+	return len(s)
+}
+
+// Return the smallest string that is lexicographically larger than prefix and
+// does not have prefix as a prefix. For the sole case where this is not
+// possible (all strings consisting solely of 0xff bytes, including the empty
+// string), return the empty string.
+func prefixSuccessor(prefix string) string {
+	limit := []byte(prefix)
+	for len(limit) > 0 {
+		b := limit[len(limit)-1]
+		if b != 0xff {
+			limit[len(limit)-1]++
+			break
+		}
+
+		limit = limit[:len(limit)-1]
+	}
+
+	return string(limit)
+}
+
+// Return the smallest i such that prefix < s[i].Metadata.Name and
+// !strings.HasPrefix(s[i].Metadata.Name, prefix).
+func (s recordSlice) prefixUpperBound(prefix string) int {
+	successor := prefixSuccessor(prefix)
+	if successor == "" {
+		return len(s)
+	}
+
+	return s.lowerBound(successor)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+type bucket struct {
+	clock timeutil.Clock
+	name  string
+	dir   string
+	mu    syncutil.InvariantMutex
+
+	// The set of extant objects, mirroring the sidecars on disk.
+	//
+	// INVARIANT: Strictly increasing.
+	objects recordSlice // GUARDED_BY(mu)
+
+	// The most recent generation number that was minted, mirroring the counter
+	// on disk. The next object will receive generation prevGeneration + 1.
+	//
+	// INVARIANT: This is an upper bound for generation numbers in objects.
+	prevGeneration int64 // GUARDED_BY(mu)
+}
+
+// Check the generation and meta-generation preconditions of a read-only
+// request against the given object.
+func checkReadPreconditions(
+	o *gcs.Object,
+	generationPrecondition *int64,
+	metaGenerationPrecondition *int64) (err error) {
+	if generationPrecondition != nil &&
+		*generationPrecondition != o.Generation {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Precondition failed: object has generation %v",
+				o.Generation),
+		}
+
+		return
+	}
+
+	if metaGenerationPrecondition != nil &&
+		*metaGenerationPrecondition != o.MetaGeneration {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Precondition failed: object has meta-generation %v",
+				o.MetaGeneration),
+		}
+
+		return
+	}
+
+	return
+}
+
+// Return a copy of the supplied object that shares no state with it.
+func copyObject(in *gcs.Object) (out *gcs.Object) {
+	oCopy := *in
+	oCopy.Metadata = copyMetadata(in.Metadata)
+	out = &oCopy
+	return
+}
+
+func copyMetadata(in map[string]string) (out map[string]string) {
+	if in == nil {
+		return
+	}
+
+	out = make(map[string]string)
+	for k, v := range in {
+		out[k] = v
+	}
+
+	return
+}
+
+func mediaLink(name string) string {
+	return "http://localhost/download/storage/local/" + name
+}
+
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) checkInvariants() {
+	// Make sure 'objects' is strictly increasing.
+	for i := 1; i < len(b.objects); i++ {
+		objA := b.objects[i-1]
+		objB := b.objects[i]
+		if !(objA.Metadata.Name < objB.Metadata.Name) {
+			panic(
+				fmt.Sprintf(
+					"Object names are not strictly increasing: %v vs. %v",
+					objA.Metadata.Name,
+					objB.Metadata.Name))
+		}
+	}
+
+	// Make sure prevGeneration is an upper bound for object generation numbers.
+	for _, r := range b.objects {
+		if !(r.Metadata.Generation <= b.prevGeneration) {
+			panic(
+				fmt.Sprintf(
+					"Object generation %v exceeds %v",
+					r.Metadata.Generation,
+					b.prevGeneration))
+		}
+	}
+}
+
+// Mint a new generation number, persisting the counter before returning it.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) mintGenerationLocked() (generation int64, err error) {
+	generation = b.prevGeneration + 1
+	if err = b.writeGeneration(generation); err != nil {
+		err = fmt.Errorf("writeGeneration: %v", err)
+		return
+	}
+
+	b.prevGeneration = generation
+	return
+}
+
+// Persist the supplied record and install it in b.objects, replacing any
+// existing record for the same name. Contents belonging to the replaced record
+// are removed if the generation differs.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) storeLocked(r record) (err error) {
+	if err = b.writeSidecar(&r); err != nil {
+		err = fmt.Errorf("writeSidecar: %v", err)
+		return
+	}
+
+	index := b.objects.find(r.Metadata.Name)
+	if index == len(b.objects) {
+		b.objects = append(b.objects, r)
+		sort.Sort(b.objects)
+		return
+	}
+
+	old := b.objects[index].Metadata
+	b.objects[index] = r
+
+	if old.Generation != r.Metadata.Generation {
+		err = os.Remove(b.contentsPath(old.Name, old.Generation))
+		if err != nil {
+			err = fmt.Errorf("Remove: %v", err)
+			return
+		}
+	}
+
+	return
+}
+
+// Remove the object at the given index from disk and from b.objects.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) removeLocked(index int) (err error) {
+	md := b.objects[index].Metadata
+
+	if err = os.Remove(b.sidecarPath(md.Name)); err != nil {
+		err = fmt.Errorf("Remove: %v", err)
+		return
+	}
+
+	b.objects = append(b.objects[:index], b.objects[index+1:]...)
+
+	if err = os.Remove(b.contentsPath(md.Name, md.Generation)); err != nil {
+		err = fmt.Errorf("Remove: %v", err)
+		return
+	}
+
+	return
+}
+
+// Return an error of type *gcs.ForbiddenError if the object at the given index
+// may not be deleted or overwritten because of a hold.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) checkMutableLocked(index int) (err error) {
+	md := &b.objects[index].Metadata
+	if md.TemporaryHold || md.EventBasedHold {
+		err = &gcs.ForbiddenError{
+			Err: fmt.Errorf("Object %q is under hold", md.Name),
+		}
+	}
+
+	return
+}
+
+// Find the object with the given name and generation (zero meaning the
+// latest), returning *gcs.NotFoundError if there is none.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) findLocked(
+	name string,
+	generation int64) (index int, err error) {
+	if err = gcs.CheckObjectName(name); err != nil {
+		return
+	}
+
+	index = b.objects.find(name)
+	if index == len(b.objects) ||
+		(generation != 0 && b.objects[index].Metadata.Generation != generation) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %q (generation %d) not found", name, generation),
+		}
+
+		return
+	}
+
+	return
+}
+
+// Check the preconditions of a request to create an object with the given
+// name, returning the index of the existing object if any or
+// len(b.objects) otherwise.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) checkCreatePreconditionsLocked(
+	name string,
+	generationPrecondition *int64,
+	metaGenerationPrecondition *int64) (existingIndex int, err error) {
+	existingIndex = b.objects.find(name)
+
+	var existing *gcs.Object
+	if existingIndex < len(b.objects) {
+		existing = &b.objects[existingIndex].Metadata
+	}
+
+	if generationPrecondition != nil {
+		if *generationPrecondition == 0 && existing != nil {
+			err = &gcs.PreconditionError{
+				Err: errors.New("Precondition failed: object exists"),
+			}
+
+			return
+		}
+
+		if *generationPrecondition > 0 {
+			if existing == nil {
+				err = &gcs.PreconditionError{
+					Err: errors.New("Precondition failed: object doesn't exist"),
+				}
+
+				return
+			}
+
+			if existing.Generation != *generationPrecondition {
+				err = &gcs.PreconditionError{
+					Err: fmt.Errorf(
+						"Precondition failed: object has generation %v",
+						existing.Generation),
+				}
+
+				return
+			}
+		}
+	}
+
+	if metaGenerationPrecondition != nil {
+		if existing == nil {
+			err = &gcs.PreconditionError{
+				Err: errors.New("Precondition failed: object doesn't exist"),
+			}
+
+			return
+		}
+
+		if existing.MetaGeneration != *metaGenerationPrecondition {
+			err = &gcs.PreconditionError{
+				Err: fmt.Errorf(
+					"Precondition failed: object has meta-generation %v",
+					existing.MetaGeneration),
+			}
+
+			return
+		}
+	}
+
+	// Refuse to overwrite objects that are held.
+	if existing != nil {
+		if err = b.checkMutableLocked(existingIndex); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Write the supplied contents to a temporary file and verify any checksums in
+// the request, without holding the lock.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) prepareContents(
+	req *gcs.CreateObjectRequest) (tc *tempContents, err error) {
+	tc, err = b.writeTempContents(req.Contents, req.ProgressFunc)
+	if err != nil {
+		err = fmt.Errorf("writeTempContents: %v", err)
+		return
+	}
+
+	// Check the provided checksum, if any.
+	if req.CRC32C != nil && tc.crc32c != *req.CRC32C {
+		err = fmt.Errorf(
+			"CRC32C mismatch: got 0x%08x, expected 0x%08x",
+			tc.crc32c,
+			*req.CRC32C)
+	}
+
+	// Check the provided hash, if any.
+	if err == nil && req.MD5 != nil && tc.md5 != *req.MD5 {
+		err = fmt.Errorf(
+			"MD5 mismatch: got %x, expected %x",
+			tc.md5,
+			*req.MD5)
+	}
+
+	if err != nil {
+		os.Remove(tc.path)
+		tc = nil
+	}
+
+	return
+}
+
+// Return an entity tag for the given generation and meta-generation of an
+// object. Like GCS's, it is the base64 encoding of a protocol buffer holding
+// the generation in field 1 and the meta-generation in field 2.
+func makeEtag(generation int64, metaGeneration int64) string {
+	buf := make([]byte, 0, 2+2*binary.MaxVarintLen64)
+	tmp := make([]byte, binary.MaxVarintLen64)
+
+	buf = append(buf, 0x08)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp, uint64(generation))]...)
+	buf = append(buf, 0x10)
+	buf = append(buf, tmp[:binary.PutUvarint(tmp, uint64(metaGeneration))]...)
+
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// The owner recorded for every object.
+const localOwner = "user-local"
+
+// The project entities that predefined ACLs refer to.
+const (
+	projectOwners  = "project-owners-local"
+	projectEditors = "project-editors-local"
+	projectViewers = "project-viewers-local"
+)
+
+// Return the ACL that GCS gives a new object with the given owner when it is
+// created with the given predefined ACL, which must be non-empty.
+func predefinedACL(owner string, name string) (acl []gcs.ACLRule, err error) {
+	acl = []gcs.ACLRule{
+		{Entity: owner, Role: gcs.ACLRoleOwner},
+	}
+
+	switch name {
+	case gcs.PredefinedACLPrivate:
+
+	case gcs.PredefinedACLPublicRead:
+		acl = append(acl, gcs.ACLRule{Entity: "allUsers", Role: gcs.ACLRoleReader})
+
+	case gcs.PredefinedACLAuthenticatedRead:
+		acl = append(acl, gcs.ACLRule{
+			Entity: "allAuthenticatedUsers",
+			Role:   gcs.ACLRoleReader,
+		})
+
+	case gcs.PredefinedACLBucketOwnerRead:
+		acl = append(acl, gcs.ACLRule{Entity: projectOwners, Role: gcs.ACLRoleReader})
+
+	case gcs.PredefinedACLBucketOwnerFullControl:
+		acl = append(acl, gcs.ACLRule{Entity: projectOwners, Role: gcs.ACLRoleOwner})
+
+	case gcs.PredefinedACLProjectPrivate:
+		acl = append(
+			acl,
+			gcs.ACLRule{Entity: projectOwners, Role: gcs.ACLRoleOwner},
+			gcs.ACLRule{Entity: projectEditors, Role: gcs.ACLRoleOwner},
+			gcs.ACLRule{Entity: projectViewers, Role: gcs.ACLRoleReader})
+
+	default:
+		err = fmt.Errorf("Unsupported predefined ACL: %q", name)
+	}
+
+	return
+}
+
+// Return an error if the supplied rules, which are to replace an object's
+// ACL, aren't acceptable to GCS. Otherwise return a copy of them.
+func checkACL(rules []gcs.ACLRule) (acl []gcs.ACLRule, err error) {
+	acl = make([]gcs.ACLRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Entity == "" {
+			err = errors.New("Entity must be specified")
+			return
+		}
+
+		if r.Role != gcs.ACLRoleReader && r.Role != gcs.ACLRoleOwner {
+			err = fmt.Errorf("Unsupported role: %q", r.Role)
+			return
+		}
+
+		acl = append(acl, gcs.ACLRule{Entity: r.Entity, Role: r.Role})
+	}
+
+	return
+}
+
+// Commit the supplied contents as a new generation of the object named by the
+// request, checking the request's preconditions. On success, the temporary
+// file has been consumed. The touchup function, if non-nil, may modify the
+// new object's metadata before it is persisted.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) commitContentsLocked(
+	req *gcs.CreateObjectRequest,
+	tc *tempContents,
+	touchup func(*gcs.Object)) (o *gcs.Object, err error) {
+	_, err = b.checkCreatePreconditionsLocked(
+		req.Name,
+		req.GenerationPrecondition,
+		req.MetaGenerationPrecondition)
+
+	if err != nil {
+		return
+	}
+
+	var acl []gcs.ACLRule
+	if req.PredefinedACL != "" {
+		if acl, err = predefinedACL(localOwner, req.PredefinedACL); err != nil {
+			return
+		}
+	}
+
+	generation, err := b.mintGenerationLocked()
+	if err != nil {
+		return
+	}
+
+	md5Sum := tc.md5
+	r := record{
+		Metadata: gcs.Object{
+			Name:            req.Name,
+			ContentType:     req.ContentType,
+			ContentLanguage: req.ContentLanguage,
+			CacheControl:    req.CacheControl,
+			Owner:           localOwner,
+			Size:            tc.size,
+			ContentEncoding: req.ContentEncoding,
+			ComponentCount:  1,
+			MD5:             &md5Sum,
+			CRC32C:          tc.crc32c,
+			MediaLink:       mediaLink(req.Name),
+			Metadata:        copyMetadata(req.Metadata),
+			Generation:      generation,
+			MetaGeneration:  1,
+			StorageClass:    "STANDARD",
+			Created:         b.clock.Now(),
+			Updated:         b.clock.Now(),
+			TemporaryHold:   req.TemporaryHold,
+			EventBasedHold:  req.EventBasedHold,
+			CustomTime:      req.CustomTime,
+			KMSKeyName:      req.KMSKeyName,
+			Etag:            makeEtag(generation, 1),
+		},
+	}
+
+	if req.StorageClass != "" {
+		r.Metadata.StorageClass = req.StorageClass
+	}
+
+	// Like GCS, grant the creator ownership, unless a predefined ACL says
+	// otherwise.
+	r.ACL = []gcs.ACLRule{
+		{Entity: r.Metadata.Owner, Role: gcs.ACLRoleOwner},
+	}
+
+	if acl != nil {
+		r.ACL = acl
+	}
+
+	if touchup != nil {
+		touchup(&r.Metadata)
+	}
+
+	// Move the contents into place, then make them visible.
+	err = os.Rename(tc.path, b.contentsPath(req.Name, generation))
+	if err != nil {
+		err = fmt.Errorf("Rename: %v", err)
+		return
+	}
+
+	if err = b.storeLocked(r); err != nil {
+		return
+	}
+
+	o = copyObject(&r.Metadata)
+	return
+}
+
+// Open the contents of the given generation of the given object.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) openContentsLocked(md *gcs.Object) (f *os.File, err error) {
+	f, err = os.Open(b.contentsPath(md.Name, md.Generation))
+	if err != nil {
+		err = fmt.Errorf("Open: %v", err)
+		return
+	}
+
+	return
+}
+
+// Copy the object described by the request to its destination name,
+// returning a record for the new object.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) copyObjectLocked(
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	// Check that the names are legal.
+	err = gcs.CheckObjectName(req.SrcName)
+	if err != nil {
+		return
+	}
+
+	err = gcs.CheckObjectName(req.DstName)
+	if err != nil {
+		return
+	}
+
+	// Does the object exist?
+	srcIndex := b.objects.find(req.SrcName)
+	if srcIndex == len(b.objects) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %q not found", req.SrcName),
+		}
+
+		return
+	}
+
+	src := b.objects[srcIndex]
+
+	// Check the predefined ACL, if any.
+	acl := src.ACL
+	if req.DstPredefinedACL != "" {
+		if acl, err = predefinedACL(localOwner, req.DstPredefinedACL); err != nil {
+			return
+		}
+	}
+
+	// Does it have the correct generation?
+	if req.SrcGeneration != 0 && src.Metadata.Generation != req.SrcGeneration {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf(
+				"Object %s generation %d not found", req.SrcName, req.SrcGeneration),
+		}
+
+		return
+	}
+
+	// Does it have the correct meta-generation?
+	if req.SrcMetaGenerationPrecondition != nil {
+		p := *req.SrcMetaGenerationPrecondition
+		if src.Metadata.MetaGeneration != p {
+			err = &gcs.PreconditionError{
+				Err: fmt.Errorf(
+					"Object %q has meta-generation %d",
+					req.SrcName,
+					src.Metadata.MetaGeneration),
+			}
+
+			return
+		}
+	}
+
+	// Refuse to overwrite objects that are held.
+	if existingIndex := b.objects.find(req.DstName); existingIndex < len(b.objects) {
+		if err = b.checkMutableLocked(existingIndex); err != nil {
+			return
+		}
+	}
+
+	// Copy it and assign a new generation number, to ensure that the generation
+	// number for the destination name is strictly increasing.
+	generation, err := b.mintGenerationLocked()
+	if err != nil {
+		return
+	}
+
+	dst := record{
+		Metadata: *copyObject(&src.Metadata),
+		ACL:      acl,
+	}
+
+	dst.Metadata.Name = req.DstName
+	dst.Metadata.MediaLink = mediaLink(req.DstName)
+	dst.Metadata.Generation = generation
+	dst.Metadata.Created = b.clock.Now()
+	dst.Metadata.Etag = makeEtag(generation, dst.Metadata.MetaGeneration)
+
+	// Holds aren't copied.
+	dst.Metadata.TemporaryHold = false
+	dst.Metadata.EventBasedHold = false
+
+	// Contents files are immutable, so a hard link suffices.
+	err = linkOrCopy(
+		b.contentsPath(src.Metadata.Name, src.Metadata.Generation),
+		b.contentsPath(dst.Metadata.Name, dst.Metadata.Generation))
+
+	if err != nil {
+		err = fmt.Errorf("linkOrCopy: %v", err)
+		return
+	}
+
+	if err = b.storeLocked(dst); err != nil {
+		return
+	}
+
+	o = copyObject(&dst.Metadata)
+	return
+}
+
+// Create a file at newPath with the same contents as the file at oldPath,
+// preferably by creating a hard link.
+func linkOrCopy(oldPath string, newPath string) (err error) {
+	if err = os.Link(oldPath, newPath); err == nil {
+		return
+	}
+
+	src, err := os.Open(oldPath)
+	if err != nil {
+		err = fmt.Errorf("Open: %v", err)
+		return
+	}
+
+	defer src.Close()
+
+	dst, err := os.OpenFile(newPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		err = fmt.Errorf("OpenFile: %v", err)
+		return
+	}
+
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(newPath)
+		err = fmt.Errorf("Copy: %v", err)
+		return
+	}
+
+	if err = dst.Close(); err != nil {
+		os.Remove(newPath)
+		err = fmt.Errorf("Close: %v", err)
+		return
+	}
+
+	return
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+////////////////////////////////////////////////////////////////////////
+// Public interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *bucket) Name() string {
+	return b.name
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Like the fake, the local bucket keeps no noncurrent generations, so
+	// req.Versions makes no difference.
+
+	// Set up the result object.
+	listing = new(gcs.Listing)
+
+	// There is no soft delete, so there are no soft-deleted objects.
+	if req.SoftDeleted {
+		return
+	}
+
+	// Handle defaults and limits, as GCS does.
+	if req.MaxResults < 0 {
+		err = fmt.Errorf("Invalid MaxResults: %d", req.MaxResults)
+		return
+	}
+
+	maxResults := req.MaxResults
+	if maxResults == 0 || maxResults > gcs.MaxListResults {
+		maxResults = gcs.MaxListResults
+	}
+
+	// Find where in the space of object names to start.
+	nameStart := req.Prefix
+	if req.ContinuationToken != "" && req.ContinuationToken > nameStart {
+		nameStart = req.ContinuationToken
+	}
+
+	if req.StartOffset > nameStart {
+		nameStart = req.StartOffset
+	}
+
+	// Compile the glob, if any.
+	var glob *gcs.Glob
+	if req.MatchGlob != "" {
+		if glob, err = gcs.CompileGlob(req.MatchGlob); err != nil {
+			err = fmt.Errorf("CompileGlob: %v", err)
+			return
+		}
+	}
+
+	// Find the range of indexes within the array to scan.
+	indexStart := b.objects.lowerBound(nameStart)
+	prefixLimit := b.objects.prefixUpperBound(req.Prefix)
+	if req.EndOffset != "" {
+		prefixLimit = minInt(prefixLimit, b.objects.lowerBound(req.EndOffset))
+	}
+
+	indexStart = minInt(indexStart, prefixLimit)
+	indexLimit := minInt(indexStart+maxResults, prefixLimit)
+
+	// Scan the array.
+	var lastResultWasPrefix bool
+	for i := indexStart; i < indexLimit; i++ {
+		md := &b.objects[i].Metadata
+		name := md.Name
+
+		// Skip objects that don't match the glob, as with the fake.
+		if glob != nil && !glob.Match(name) {
+			continue
+		}
+
+		// Search for a delimiter if necessary.
+		if req.Delimiter != "" {
+			// Search only in the part after the prefix.
+			nameMinusQueryPrefix := name[len(req.Prefix):]
+
+			delimiterIndex := strings.Index(nameMinusQueryPrefix, req.Delimiter)
+			if delimiterIndex >= 0 {
+				resultPrefixLimit := delimiterIndex + len(req.Prefix) + len(req.Delimiter)
+
+				// Save the result, but only if it's not a duplicate.
+				resultPrefix := name[:resultPrefixLimit]
+				if len(listing.CollapsedRuns) == 0 ||
+					listing.CollapsedRuns[len(listing.CollapsedRuns)-1] != resultPrefix {
+					listing.CollapsedRuns = append(listing.CollapsedRuns, resultPrefix)
+				}
+
+				// A placeholder object named exactly for the run is returned as well,
+				// if requested.
+				if req.IncludeTrailingDelimiter && name == resultPrefix {
+					listing.Objects = append(listing.Objects, copyObject(md))
+				}
+
+				lastResultWasPrefix = true
+				continue
+			}
+		}
+
+		lastResultWasPrefix = false
+		listing.Objects = append(listing.Objects, copyObject(md))
+	}
+
+	// Set up a cursor for where to start the next scan if we didn't exhaust the
+	// results.
+	if indexLimit < prefixLimit {
+		// If the final object we visited was collapsed into a run, skip all other
+		// objects in the same run so we don't return it again.
+		if lastResultWasPrefix {
+			lastResultPrefix := listing.CollapsedRuns[len(listing.CollapsedRuns)-1]
+			listing.ContinuationToken = prefixSuccessor(lastResultPrefix)
+			if listing.ContinuationToken == "" {
+				err = errors.New("Unexpected empty string from prefixSuccessor")
+				return
+			}
+		} else {
+			listing.ContinuationToken = b.objects[indexLimit].Metadata.Name
+		}
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc gcs.ReadSeekCloser, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// We can only verify checksums for the full contents.
+	if req.VerifyChecksums && req.Range != nil {
+		err = errors.New("VerifyChecksums may not be combined with Range")
+		return
+	}
+
+	index, err := b.findLocked(req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	md := &b.objects[index].Metadata
+
+	// Check preconditions.
+	err = checkReadPreconditions(
+		md,
+		req.GenerationPrecondition,
+		req.MetaGenerationPrecondition)
+
+	if err != nil {
+		return
+	}
+
+	// Open the contents. The file stays valid even if the object is later
+	// overwritten or deleted.
+	f, err := b.openContentsLocked(md)
+	if err != nil {
+		return
+	}
+
+	// Extract the requested range.
+	var start, limit uint64 = 0, md.Size
+	if req.Range != nil {
+		start = req.Range.Start
+		limit = req.Range.Limit
+
+		if start > limit || start > md.Size {
+			start = 0
+			limit = 0
+		}
+
+		if limit > md.Size {
+			limit = md.Size
+		}
+	}
+
+	// Local reads don't stall, so IdleTimeout can be ignored.
+	rc = &readSeekCloser{
+		ReadSeeker: io.NewSectionReader(f, int64(start), int64(limit-start)),
+		file:       f,
+		progress:   req.ProgressFunc,
+	}
+
+	// Verify checksums if requested.
+	if req.VerifyChecksums {
+		rc = gcs.NewVerifyingReader(rc, copyObject(md))
+	}
+
+	// We don't transcode, so the contents are as stored.
+	if req.ContentEncodingFunc != nil {
+		req.ContentEncodingFunc(md.ContentEncoding)
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	// Check that the name is legal.
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	// Compress the contents if requested.
+	if req, err = gcs.CompressCreateObjectRequest(req); err != nil {
+		return
+	}
+
+	// Write the contents to disk without holding the lock, so that slow
+	// writers don't block other operations.
+	tc, err := b.prepareContents(req)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, err = b.commitContentsLocked(req, tc, nil)
+	if err != nil {
+		os.Remove(tc.path)
+		return
+	}
+
+	if req.DiscardResponse {
+		o = nil
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, err = b.copyObjectLocked(req)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) MoveObject(
+	ctx context.Context,
+	req *gcs.MoveObjectRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Moving deletes the source, so it must not be held.
+	if srcIndex := b.objects.find(req.SrcName); srcIndex < len(b.objects) {
+		if err = b.checkMutableLocked(srcIndex); err != nil {
+			return
+		}
+	}
+
+	// Copy the source to its new name, checking the source's generation and
+	// meta-generation along the way.
+	o, err = b.copyObjectLocked(&gcs.CopyObjectRequest{
+		SrcName:                       req.SrcName,
+		DstName:                       req.DstName,
+		SrcGeneration:                 req.SrcGeneration,
+		SrcMetaGenerationPrecondition: req.SrcMetaGenerationPrecondition,
+	})
+
+	if err != nil {
+		return
+	}
+
+	// Special case: moving an object onto its own name leaves only the new
+	// generation behind.
+	if req.SrcName != req.DstName {
+		err = b.removeLocked(b.objects.find(req.SrcName))
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	// GCS doesn't like too few or too many sources.
+	if len(req.Sources) < 1 {
+		err = errors.New("You must provide at least one source component")
+		return
+	}
+
+	if len(req.Sources) > gcs.MaxSourcesPerComposeRequest {
+		err = errors.New("You have provided too many source components")
+		return
+	}
+
+	if err = gcs.CheckObjectName(req.DstName); err != nil {
+		return
+	}
+
+	// Open all of the source objects, also computing the sum of their
+	// component counts.
+	var srcReaders []io.Reader
+	var dstComponentCount int64
+
+	b.mu.Lock()
+	for _, src := range req.Sources {
+		var index int
+		index, err = b.findLocked(src.Name, src.Generation)
+		if err != nil {
+			break
+		}
+
+		md := &b.objects[index].Metadata
+
+		var f *os.File
+		if f, err = b.openContentsLocked(md); err != nil {
+			break
+		}
+
+		defer f.Close()
+		srcReaders = append(srcReaders, f)
+		dstComponentCount += md.ComponentCount
+	}
+	b.mu.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	// GCS doesn't like the component count to go too high.
+	if dstComponentCount > gcs.MaxComponentCount {
+		err = errors.New("Result would have too many components")
+		return
+	}
+
+	// Write the concatenated contents without holding the lock.
+	createReq := &gcs.CreateObjectRequest{
+		Name:                       req.DstName,
+		GenerationPrecondition:     req.DstGenerationPrecondition,
+		MetaGenerationPrecondition: req.DstMetaGenerationPrecondition,
+		Contents:                   io.MultiReader(srcReaders...),
+		ContentType:                req.ContentType,
+		Metadata:                   req.Metadata,
+	}
+
+	tc, err := b.prepareContents(createReq)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, err = b.commitContentsLocked(
+		createReq,
+		tc,
+		func(md *gcs.Object) {
+			md.ComponentCount = dstComponentCount
+
+			// Emulate the real GCS behavior of not exporting an MD5 hash for
+			// composite objects.
+			md.MD5 = nil
+		})
+
+	if err != nil {
+		os.Remove(tc.path)
+		return
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	index, err := b.findLocked(req.Name, 0)
+	if err != nil {
+		return
+	}
+
+	md := &b.objects[index].Metadata
+
+	// Check preconditions.
+	err = checkReadPreconditions(
+		md,
+		req.GenerationPrecondition,
+		req.MetaGenerationPrecondition)
+
+	if err != nil {
+		return
+	}
+
+	o = copyObject(md)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	index, err := b.findLocked(req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	// Work on a copy, so that nothing changes if we fail to persist it.
+	r := b.objects[index]
+	obj := copyObject(&r.Metadata)
+
+	// Does the generation precondition check out?
+	if req.GenerationPrecondition != nil &&
+		obj.Generation != *req.GenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Object %q has generation %d",
+				obj.Name,
+				obj.Generation),
+		}
+
+		return
+	}
+
+	// Does the meta-generation precondition check out?
+	if req.MetaGenerationPrecondition != nil &&
+		obj.MetaGeneration != *req.MetaGenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Object %q has meta-generation %d",
+				obj.Name,
+				obj.MetaGeneration),
+		}
+
+		return
+	}
+
+	// Replace the ACL if requested.
+	if req.ACL != nil {
+		if r.ACL, err = checkACL(req.ACL); err != nil {
+			return
+		}
+	}
+
+	// GCS refuses to remove an object's custom time or to move it earlier.
+	if req.CustomTime != nil {
+		if err = customtime.Check(obj.CustomTime, *req.CustomTime); err != nil {
+			return
+		}
+
+		obj.CustomTime = *req.CustomTime
+	}
+
+	// Update the basic fields according to the request.
+	if req.ContentType != nil {
+		obj.ContentType = *req.ContentType
+	}
+
+	if req.ContentEncoding != nil {
+		obj.ContentEncoding = *req.ContentEncoding
+	}
+
+	if req.ContentLanguage != nil {
+		obj.ContentLanguage = *req.ContentLanguage
+	}
+
+	if req.CacheControl != nil {
+		obj.CacheControl = *req.CacheControl
+	}
+
+	// Set or release holds.
+	if req.TemporaryHold != nil {
+		obj.TemporaryHold = *req.TemporaryHold
+	}
+
+	if req.EventBasedHold != nil {
+		obj.EventBasedHold = *req.EventBasedHold
+	}
+
+	// Update the user metadata if necessary.
+	if len(req.Metadata) > 0 {
+		if obj.Metadata == nil {
+			obj.Metadata = make(map[string]string)
+		}
+
+		for k, v := range req.Metadata {
+			if v == nil {
+				delete(obj.Metadata, k)
+				continue
+			}
+
+			obj.Metadata[k] = *v
+		}
+	}
+
+	// Bump up the meta-generation number and the update time.
+	obj.MetaGeneration++
+	obj.Updated = b.clock.Now()
+	obj.Etag = makeEtag(obj.Generation, obj.MetaGeneration)
+
+	r.Metadata = *obj
+	if err = b.storeLocked(r); err != nil {
+		return
+	}
+
+	o = copyObject(obj)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	// Do we possess the object with the given name and generation?
+	index, err := b.findLocked(req.Name, req.Generation)
+	if err != nil {
+		err = nil
+		return
+	}
+
+	md := &b.objects[index].Metadata
+
+	// Check the generation if requested.
+	if req.GenerationPrecondition != nil &&
+		md.Generation != *req.GenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Object %q has generation %d",
+				req.Name,
+				md.Generation),
+		}
+
+		return
+	}
+
+	// Check the meta-generation if requested.
+	if req.MetaGenerationPrecondition != nil &&
+		md.MetaGeneration != *req.MetaGenerationPrecondition {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Object %q has meta-generation %d",
+				req.Name,
+				md.MetaGeneration),
+		}
+
+		return
+	}
+
+	// Refuse to delete objects that are held.
+	if err = b.checkMutableLocked(index); err != nil {
+		return
+	}
+
+	err = b.removeLocked(index)
+	return
+}
+
+// Deleted objects are gone for good, so there is never anything to restore.
+func (b *bucket) RestoreObject(
+	ctx context.Context,
+	req *gcs.RestoreObjectRequest) (o *gcs.Object, err error) {
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	err = &gcs.NotFoundError{
+		Err: fmt.Errorf(
+			"Soft-deleted object %q (generation %d) not found",
+			req.Name,
+			req.Generation),
+	}
+
+	return
+}
+
+func (b *bucket) SignedURL(
+	ctx context.Context,
+	req *gcs.SignedURLRequest) (signed string, err error) {
+	err = errors.New("The local bucket doesn't support signed URLs.")
+	return
+}
+
+// Replace the ACL of the object at the given index, bumping its
+// meta-generation as GCS does.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) setACLLocked(index int, acl []gcs.ACLRule) (err error) {
+	r := b.objects[index]
+	r.Metadata = *copyObject(&r.Metadata)
+	r.ACL = acl
+	r.Metadata.MetaGeneration++
+	r.Metadata.Updated = b.clock.Now()
+	r.Metadata.Etag = makeEtag(r.Metadata.Generation, r.Metadata.MetaGeneration)
+
+	err = b.storeLocked(r)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) ListObjectACLs(
+	ctx context.Context,
+	req *gcs.ListObjectACLsRequest) (rules []*gcs.ACLRule, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	index, err := b.findLocked(req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	for _, r := range b.objects[index].ACL {
+		rCopy := r
+		rules = append(rules, &rCopy)
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) UpdateObjectACL(
+	ctx context.Context,
+	req *gcs.UpdateObjectACLRequest) (rule *gcs.ACLRule, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if req.Entity == "" {
+		err = errors.New("Entity must be specified")
+		return
+	}
+
+	if req.Role != gcs.ACLRoleReader && req.Role != gcs.ACLRoleOwner {
+		err = fmt.Errorf("Unsupported role: %q", req.Role)
+		return
+	}
+
+	index, err := b.findLocked(req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	// Build a new ACL, replacing any existing entry for the entity.
+	newRule := gcs.ACLRule{Entity: req.Entity, Role: req.Role}
+	var acl []gcs.ACLRule
+	for _, r := range b.objects[index].ACL {
+		if r.Entity != req.Entity {
+			acl = append(acl, r)
+		}
+	}
+
+	acl = append(acl, newRule)
+	if err = b.setACLLocked(index, acl); err != nil {
+		return
+	}
+
+	rule = &newRule
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) DeleteObjectACL(
+	ctx context.Context,
+	req *gcs.DeleteObjectACLRequest) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if req.Entity == "" {
+		err = errors.New("Entity must be specified")
+		return
+	}
+
+	index, err := b.findLocked(req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	// Build a new ACL without the entity's entry.
+	var acl []gcs.ACLRule
+	for _, r := range b.objects[index].ACL {
+		if r.Entity != req.Entity {
+			acl = append(acl, r)
+		}
+	}
+
+	if len(acl) == len(b.objects[index].ACL) {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %q has no ACL entry for %q", req.Name, req.Entity),
+		}
+
+		return
+	}
+
+	err = b.setACLLocked(index, acl)
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) RewriteObject(
+	ctx context.Context,
+	req *gcs.RewriteObjectRequest) (o *gcs.Object, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if req.DstBucket != "" && req.DstBucket != b.name {
+		err = fmt.Errorf(
+			"The local bucket doesn't support rewriting to other buckets (%q)",
+			req.DstBucket)
+
+		return
+	}
+
+	// Check the destination precondition, if any.
+	if req.DstGenerationPrecondition != nil {
+		var existingGen int64
+		if index := b.objects.find(req.DstName); index < len(b.objects) {
+			existingGen = b.objects[index].Metadata.Generation
+		}
+
+		if existingGen != *req.DstGenerationPrecondition {
+			err = &gcs.PreconditionError{
+				Err: fmt.Errorf(
+					"Precondition failed: object has generation %v",
+					existingGen),
+			}
+
+			return
+		}
+	}
+
+	// Everything happens in a single round trip.
+	o, err = b.copyObjectLocked(&gcs.CopyObjectRequest{
+		SrcName:                       req.SrcName,
+		DstName:                       req.DstName,
+		SrcGeneration:                 req.SrcGeneration,
+		SrcMetaGenerationPrecondition: req.SrcMetaGenerationPrecondition,
+		DstPredefinedACL:              req.DstPredefinedACL,
+	})
+
+	if err != nil {
+		return
+	}
+
+	if req.DstStorageClass != "" {
+		index := b.objects.find(req.DstName)
+		r := b.objects[index]
+		r.Metadata.StorageClass = req.DstStorageClass
+		if err = b.storeLocked(r); err != nil {
+			return
+		}
+
+		o.StorageClass = req.DstStorageClass
+	}
+
+	if req.Progress != nil {
+		req.Progress(int64(o.Size), int64(o.Size))
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) Batch(
+	ctx context.Context,
+	req *gcs.BatchRequest) (results []gcs.BatchResult, err error) {
+	// Check the operations up front, so that we don't fail partway through.
+	for i, op := range req.Ops {
+		n := 0
+		if op.Stat != nil {
+			n++
+		}
+
+		if op.Update != nil {
+			n++
+		}
+
+		if op.Delete != nil {
+			n++
+		}
+
+		if n != 1 {
+			err = fmt.Errorf(
+				"Operation %d: exactly one of Stat, Update, and Delete must be set",
+				i)
+			return
+		}
+
+		var name string
+		switch {
+		case op.Stat != nil:
+			name = op.Stat.Name
+
+		case op.Update != nil:
+			name = op.Update.Name
+
+		case op.Delete != nil:
+			name = op.Delete.Name
+		}
+
+		if err = gcs.CheckObjectName(name); err != nil {
+			return
+		}
+	}
+
+	// Perform each operation in turn.
+	results = make([]gcs.BatchResult, len(req.Ops))
+	for i, op := range req.Ops {
+		r := &results[i]
+		switch {
+		case op.Stat != nil:
+			r.Object, r.Err = b.StatObject(ctx, op.Stat)
+
+		case op.Update != nil:
+			r.Object, r.Err = b.UpdateObject(ctx, op.Update)
+
+		case op.Delete != nil:
+			r.Err = b.DeleteObject(ctx, op.Delete)
+		}
+	}
+
+	return
+}
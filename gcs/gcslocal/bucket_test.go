@@ -0,0 +1,230 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcslocal_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcslocal"
+	"github.com/jacobsa/gcloud/gcs/gcstesting"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+// A directory under which each test creates its buckets, removed once all
+// tests have run.
+var tempRoot string
+
+func TestBucket(t *testing.T) {
+	defer os.RemoveAll(tempRoot)
+	RunTests(t)
+}
+
+func BenchmarkBucket(b *testing.B) {
+	defer os.RemoveAll(tempRoot)
+	gcstesting.RunBucketBenchmarks(b)
+}
+
+func init() {
+	var err error
+	tempRoot, err = ioutil.TempDir("", "gcslocal_test")
+	if err != nil {
+		panic(err)
+	}
+
+	var nextDir int
+	makeDeps := func(ctx context.Context) (deps gcstesting.BucketTestDeps) {
+		// Set up a fixed, non-zero time.
+		clock := &timeutil.SimulatedClock{}
+		clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+		deps.Clock = clock
+
+		// Set up the bucket in a fresh directory.
+		nextDir++
+		dir := filepath.Join(tempRoot, strconv.Itoa(nextDir))
+
+		deps.Bucket, err = gcslocal.NewLocalBucket(clock, "some_bucket", dir)
+		if err != nil {
+			panic(err)
+		}
+
+		// Like the fake, the bucket supports everything except cancellation.
+		deps.SupportsVersions = true
+		deps.SupportsPreconditions = true
+		deps.SupportsCompose = true
+		deps.SupportsRewrite = true
+		deps.SupportsHolds = true
+		deps.SupportsACLs = true
+
+		return
+	}
+
+	gcstesting.RegisterBucketTests(makeDeps)
+	gcstesting.RegisterBucketBenchmarks(makeDeps)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Persistence
+////////////////////////////////////////////////////////////////////////
+
+type PersistenceTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	dir    string
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &PersistenceTest{}
+
+func init() { RegisterTestSuite(&PersistenceTest{}) }
+
+func (t *PersistenceTest) SetUp(ti *TestInfo) {
+	var err error
+
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+
+	t.dir, err = ioutil.TempDir(tempRoot, "persistence")
+	AssertEq(nil, err)
+
+	t.bucket, err = gcslocal.NewLocalBucket(&t.clock, "some_bucket", t.dir)
+	AssertEq(nil, err)
+}
+
+// Open a new bucket on the same directory, as if after a restart.
+func (t *PersistenceTest) reopen() {
+	var err error
+	t.bucket, err = gcslocal.NewLocalBucket(&t.clock, "some_bucket", t.dir)
+	AssertEq(nil, err)
+}
+
+func (t *PersistenceTest) EmptyDirectory() {
+	t.reopen()
+
+	objects, runs, err := gcsutil.ListAll(t.ctx, t.bucket, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+	ExpectThat(objects, ElementsAre())
+	ExpectThat(runs, ElementsAre())
+}
+
+func (t *PersistenceTest) ObjectsSurviveReopening() {
+	// Create some objects and update one of them.
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "bar/baz", []byte("burrito"))
+	AssertEq(nil, err)
+
+	contentType := "text/plain"
+	updated, err := t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:        "foo",
+			ContentType: &contentType,
+		})
+
+	AssertEq(nil, err)
+
+	// Reopen.
+	t.reopen()
+
+	// The metadata should have been preserved.
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq(updated.Generation, o.Generation)
+	ExpectEq(updated.MetaGeneration, o.MetaGeneration)
+	ExpectEq("text/plain", o.ContentType)
+	ExpectEq(updated.Size, o.Size)
+	ExpectEq(updated.CRC32C, o.CRC32C)
+	ExpectThat(o.MD5, Pointee(DeepEquals(*updated.MD5)))
+	ExpectThat(o.Updated, timeutil.TimeEq(updated.Updated))
+
+	// So should the contents.
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	contents, err = gcsutil.ReadObject(t.ctx, t.bucket, "bar/baz")
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+}
+
+func (t *PersistenceTest) DeletionSurvivesReopening() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	t.reopen()
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *PersistenceTest) GenerationsKeepIncreasing() {
+	// Create and then delete an object.
+	o1, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	// After reopening, a new object should not reuse the generation number.
+	t.reopen()
+
+	o2, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+	ExpectGt(o2.Generation, o1.Generation)
+}
+
+func (t *PersistenceTest) UnreferencedFilesAreRemoved() {
+	// Overwrite an object, so that one generation's contents are referenced.
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("burrito"))
+	AssertEq(nil, err)
+
+	// Leave behind a file as if from an interrupted write.
+	stray := filepath.Join(t.dir, "objects", "tmp-12345")
+	err = ioutil.WriteFile(stray, []byte("enchilada"), 0600)
+	AssertEq(nil, err)
+
+	// Reopening should remove it, and leave exactly one sidecar and one
+	// contents file.
+	t.reopen()
+
+	_, err = os.Stat(stray)
+	ExpectTrue(os.IsNotExist(err), "err: %v", err)
+
+	entries, err := ioutil.ReadDir(filepath.Join(t.dir, "objects"))
+	AssertEq(nil, err)
+	ExpectEq(2, len(entries))
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+}
@@ -0,0 +1,26 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcslocal contains an implementation of gcs.Bucket that stores its
+// objects in a directory on the local file system, so that state survives
+// process restarts. It is intended for integration tests and offline
+// development, and supports the same feature set as gcsfake.
+//
+// Each object is stored as a file containing its contents plus a sidecar
+// JSON file containing its metadata and ACL. Generation numbers are minted
+// from a counter persisted in the directory, so they keep increasing across
+// restarts.
+//
+// Only one bucket may use a given directory at a time.
+package gcslocal
@@ -0,0 +1,312 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcslocal
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+// The on-disk layout of a bucket directory is as follows:
+//
+//	generation             The most recently minted generation number.
+//	objects/<key>.json     The sidecar for the object with the given key.
+//	objects/<key>.<gen>    The contents of the given generation of the
+//	                       object with the given key.
+//	objects/tmp-*          Contents being written, not yet committed.
+//
+// where <key> is the hex-encoded SHA-256 hash of the object name, so that
+// arbitrary names map onto legal file names.
+//
+// A given generation's contents file is never modified once written, so
+// readers may continue to use it after the object is overwritten or deleted.
+// Mutations first write contents, then atomically replace the sidecar, then
+// remove contents that are no longer referenced. Unreferenced files left
+// behind by a crash are garbage collected when the bucket is next opened.
+const (
+	generationFile = "generation"
+	objectsDir     = "objects"
+	sidecarSuffix  = ".json"
+	tempFilePrefix = "tmp-"
+)
+
+// The size of the buffer used when copying contents to disk.
+const copyBufferSize = 32 * 1024
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// The record for an object stored in a sidecar file.
+type record struct {
+	Metadata gcs.Object
+	ACL      []gcs.ACLRule
+}
+
+func objectKey(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *bucket) sidecarPath(name string) string {
+	return filepath.Join(b.dir, objectsDir, objectKey(name)+sidecarSuffix)
+}
+
+func (b *bucket) contentsPath(name string, generation int64) string {
+	return filepath.Join(
+		b.dir,
+		objectsDir,
+		fmt.Sprintf("%s.%d", objectKey(name), generation))
+}
+
+// Write the supplied data to the given path, such that readers see either the
+// previous contents or the new contents and never a mixture.
+func writeFileAtomically(path string, data []byte) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), tempFilePrefix)
+	if err != nil {
+		err = fmt.Errorf("TempFile: %v", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		err = fmt.Errorf("Write: %v", err)
+		return
+	}
+
+	if err = f.Sync(); err != nil {
+		f.Close()
+		err = fmt.Errorf("Sync: %v", err)
+		return
+	}
+
+	if err = f.Close(); err != nil {
+		err = fmt.Errorf("Close: %v", err)
+		return
+	}
+
+	if err = os.Rename(f.Name(), path); err != nil {
+		err = fmt.Errorf("Rename: %v", err)
+		return
+	}
+
+	return
+}
+
+// Contents that have been written to a temporary file but not yet committed
+// to an object.
+type tempContents struct {
+	path   string
+	size   uint64
+	crc32c uint32
+	md5    [md5.Size]byte
+}
+
+// Copy the supplied contents to a new temporary file within the bucket
+// directory, computing checksums along the way. The caller must arrange for
+// the file to be renamed or removed.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) writeTempContents(
+	r io.Reader,
+	progress func(int64)) (tc *tempContents, err error) {
+	f, err := ioutil.TempFile(filepath.Join(b.dir, objectsDir), tempFilePrefix)
+	if err != nil {
+		err = fmt.Errorf("TempFile: %v", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	crc32cHash := crc32.New(crc32cTable)
+	md5Hash := md5.New()
+	w := io.MultiWriter(f, crc32cHash, md5Hash)
+
+	// Copy the contents, reporting progress if requested.
+	var size int64
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			if _, err = w.Write(buf[:n]); err != nil {
+				f.Close()
+				err = fmt.Errorf("Write: %v", err)
+				return
+			}
+
+			size += int64(n)
+			if progress != nil {
+				progress(size)
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+
+		if readErr != nil {
+			f.Close()
+			err = fmt.Errorf("Read: %v", readErr)
+			return
+		}
+	}
+
+	if err = f.Sync(); err != nil {
+		f.Close()
+		err = fmt.Errorf("Sync: %v", err)
+		return
+	}
+
+	if err = f.Close(); err != nil {
+		err = fmt.Errorf("Close: %v", err)
+		return
+	}
+
+	tc = &tempContents{
+		path:   f.Name(),
+		size:   uint64(size),
+		crc32c: crc32cHash.Sum32(),
+	}
+
+	copy(tc.md5[:], md5Hash.Sum(nil))
+	return
+}
+
+// Persist the supplied generation number as the most recently minted one.
+func (b *bucket) writeGeneration(generation int64) (err error) {
+	err = writeFileAtomically(
+		filepath.Join(b.dir, generationFile),
+		[]byte(strconv.FormatInt(generation, 10)))
+
+	return
+}
+
+// Write the sidecar for the supplied record.
+func (b *bucket) writeSidecar(r *record) (err error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		err = fmt.Errorf("Marshal: %v", err)
+		return
+	}
+
+	err = writeFileAtomically(b.sidecarPath(r.Metadata.Name), data)
+	return
+}
+
+// Load the state of the bucket from its directory, creating the directory if
+// it doesn't yet exist, and removing any files that are no longer referenced.
+//
+// LOCKS_REQUIRED(b.mu)
+func (b *bucket) load() (err error) {
+	if err = os.MkdirAll(filepath.Join(b.dir, objectsDir), 0700); err != nil {
+		err = fmt.Errorf("MkdirAll: %v", err)
+		return
+	}
+
+	// Read the generation counter, if any.
+	data, err := ioutil.ReadFile(filepath.Join(b.dir, generationFile))
+	switch {
+	case os.IsNotExist(err):
+		err = nil
+
+	case err != nil:
+		err = fmt.Errorf("ReadFile: %v", err)
+		return
+
+	default:
+		b.prevGeneration, err = strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			err = fmt.Errorf("Parsing generation counter: %v", err)
+			return
+		}
+	}
+
+	// Read each sidecar.
+	entries, err := ioutil.ReadDir(filepath.Join(b.dir, objectsDir))
+	if err != nil {
+		err = fmt.Errorf("ReadDir: %v", err)
+		return
+	}
+
+	referenced := make(map[string]bool)
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), sidecarSuffix) ||
+			strings.HasPrefix(e.Name(), tempFilePrefix) {
+			continue
+		}
+
+		path := filepath.Join(b.dir, objectsDir, e.Name())
+		if data, err = ioutil.ReadFile(path); err != nil {
+			err = fmt.Errorf("ReadFile: %v", err)
+			return
+		}
+
+		var r record
+		if err = json.Unmarshal(data, &r); err != nil {
+			err = fmt.Errorf("Unmarshal(%q): %v", path, err)
+			return
+		}
+
+		md := &r.Metadata
+		referenced[e.Name()] = true
+		referenced[filepath.Base(b.contentsPath(md.Name, md.Generation))] = true
+
+		// Be robust to a counter that is behind, so that we never mint a
+		// generation number twice.
+		if md.Generation > b.prevGeneration {
+			b.prevGeneration = md.Generation
+		}
+
+		b.objects = append(b.objects, r)
+	}
+
+	sort.Sort(b.objects)
+
+	// Garbage collect everything else.
+	for _, e := range entries {
+		if referenced[e.Name()] {
+			continue
+		}
+
+		path := filepath.Join(b.dir, objectsDir, e.Name())
+		if err = os.Remove(path); err != nil {
+			err = fmt.Errorf("Remove: %v", err)
+			return
+		}
+	}
+
+	return
+}
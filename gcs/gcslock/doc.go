@@ -0,0 +1,29 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcslock implements cooperative locks on top of GCS objects, for
+// uses like leader election and making sure that only one instance of a cron
+// job runs at a time.
+//
+// A lock is an empty object. It is acquired by creating the object with a
+// precondition that it doesn't already exist, kept alive by periodically
+// updating its metadata, and released by deleting it. If its holder stops
+// renewing it for longer than its TTL, for example because the holder
+// crashed, another party may take it over.
+//
+// Locks are advisory: nothing stops a party that doesn't use this package, or
+// a holder that has lost its lease without noticing, from doing whatever the
+// lock protects. Holders should watch Lease.Lost, and may pass
+// Lease.Generation to the systems they modify as a fencing token.
+package gcslock
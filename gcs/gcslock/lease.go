@@ -0,0 +1,267 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcslock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// A *LostError value is an error that indicates that a lease is no longer
+// held: it was released, taken over by someone else after expiring, or the
+// lock object was deleted.
+type LostError struct {
+	Err error
+}
+
+func (le *LostError) Error() string {
+	return fmt.Sprintf("gcslock.LostError: %v", le.Err)
+}
+
+// A held lock, as returned by Acquire and TryAcquire. It is renewed in the
+// background until released with Release or lost. Its methods may be called
+// concurrently.
+type Lease struct {
+	bucket     gcs.Bucket
+	name       string
+	generation int64
+	ttl        time.Duration
+	clock      timeutil.Clock
+
+	// Closed when the lease is lost or released.
+	lost chan struct{}
+
+	// Closed by Release to stop the heartbeat goroutine, which closes
+	// heartbeatDone when it returns.
+	stopHeartbeat     chan struct{}
+	stopHeartbeatOnce sync.Once
+	heartbeatDone     chan struct{}
+
+	// Held while renewing or releasing, so that each request uses the
+	// meta-generation that resulted from the last.
+	opMu sync.Mutex
+
+	mu sync.Mutex
+
+	// The meta-generation of the lock object as of our last update.
+	metaGeneration int64 // GUARDED_BY(mu)
+
+	// When the lease expires unless renewed, by our clock.
+	expires time.Time // GUARDED_BY(mu)
+
+	// A *LostError if the lease has been lost or released, nil otherwise.
+	//
+	// INVARIANT: err != nil iff lost is closed
+	err error // GUARDED_BY(mu)
+}
+
+// Create a lease for the supplied freshly created lock object, and start
+// renewing it in the background.
+func newLease(
+	cfg *Config,
+	o *gcs.Object,
+	start time.Time) (l *Lease) {
+	l = &Lease{
+		bucket:         cfg.Bucket,
+		name:           cfg.Name,
+		generation:     o.Generation,
+		ttl:            cfg.TTL,
+		clock:          cfg.Clock,
+		lost:           make(chan struct{}),
+		stopHeartbeat:  make(chan struct{}),
+		heartbeatDone:  make(chan struct{}),
+		metaGeneration: o.MetaGeneration,
+		expires:        start.Add(cfg.TTL),
+	}
+
+	go l.heartbeat(cfg.HeartbeatInterval)
+	return
+}
+
+// Return the generation number of the lock object. It increases each time the
+// lock changes hands, so it may be used as a fencing token.
+func (l *Lease) Generation() int64 {
+	return l.generation
+}
+
+// Return the time at which the lease expires unless renewed, by the clock in
+// the Config used to acquire it.
+//
+// LOCKS_EXCLUDED(l.mu)
+func (l *Lease) Expires() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.expires
+}
+
+// Return a channel that is closed when the lease is lost or released, after
+// which Err returns the reason. Work protected by the lock should stop when it
+// is closed.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Return a *LostError if the lease has been lost or released, and nil
+// otherwise.
+//
+// LOCKS_EXCLUDED(l.mu)
+func (l *Lease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+// Record that the lease is no longer held for the supplied reason, unless
+// that has already happened. Return the *LostError recorded.
+//
+// LOCKS_EXCLUDED(l.mu)
+func (l *Lease) lose(reason error) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err == nil {
+		l.err = &LostError{Err: reason}
+		close(l.lost)
+	}
+
+	err = l.err
+	return
+}
+
+// Extend the lease by the TTL from now. This happens automatically in the
+// background, so there is normally no need to call it. Return a *LostError if
+// the lease turns out to have been lost.
+//
+// LOCKS_EXCLUDED(l.opMu, l.mu)
+func (l *Lease) Renew(ctx context.Context) (err error) {
+	l.opMu.Lock()
+	defer l.opMu.Unlock()
+
+	if err = l.Err(); err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	metaGeneration := l.metaGeneration
+	l.mu.Unlock()
+
+	// Update the heartbeat, insisting that nobody else has touched the object
+	// since we last did.
+	start := l.clock.Now()
+	heartbeat := start.UTC().Format(time.RFC3339Nano)
+
+	o, err := l.bucket.UpdateObject(
+		ctx,
+		&gcs.UpdateObjectRequest{
+			Name:                       l.name,
+			GenerationPrecondition:     &l.generation,
+			MetaGenerationPrecondition: &metaGeneration,
+			Metadata: map[string]*string{
+				heartbeatKey: &heartbeat,
+			},
+		})
+
+	switch err.(type) {
+	case nil:
+	case *gcs.PreconditionError, *gcs.NotFoundError:
+		err = l.lose(fmt.Errorf("Lock %q was taken over or deleted: %v", l.name, err))
+		return
+
+	default:
+		err = fmt.Errorf("UpdateObject: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	l.metaGeneration = o.MetaGeneration
+	l.expires = start.Add(l.ttl)
+	l.mu.Unlock()
+
+	return
+}
+
+// Stop renewing the lease and delete the lock object, so that others may
+// acquire the lock straight away. If the lease has already been lost, there is
+// nothing to delete and Release does nothing.
+//
+// LOCKS_EXCLUDED(l.opMu, l.mu)
+func (l *Lease) Release(ctx context.Context) (err error) {
+	l.stopHeartbeatOnce.Do(func() { close(l.stopHeartbeat) })
+	<-l.heartbeatDone
+
+	l.opMu.Lock()
+	defer l.opMu.Unlock()
+
+	if l.Err() != nil {
+		return
+	}
+
+	l.mu.Lock()
+	metaGeneration := l.metaGeneration
+	l.mu.Unlock()
+
+	// Delete only our generation, so that we can't clobber someone who has
+	// taken over.
+	err = l.bucket.DeleteObject(
+		ctx,
+		&gcs.DeleteObjectRequest{
+			Name:                       l.name,
+			Generation:                 l.generation,
+			MetaGenerationPrecondition: &metaGeneration,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("DeleteObject: %v", err)
+		return
+	}
+
+	l.lose(fmt.Errorf("Lease on %q was released", l.name))
+	return
+}
+
+// Renew the lease every interval until it is lost or Release is called.
+// Transient failures are retried at the next tick, until the lease expires.
+func (l *Lease) heartbeat(interval time.Duration) {
+	defer close(l.heartbeatDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.lost:
+			return
+
+		case <-l.stopHeartbeat:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := l.Renew(ctx)
+		cancel()
+
+		if err != nil && l.Err() == nil && !l.clock.Now().Before(l.Expires()) {
+			l.lose(fmt.Errorf("Couldn't renew lease on %q before it expired: %v", l.name, err))
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcslock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// Metadata keys on lock objects.
+const (
+	// The holder's Config.Owner.
+	ownerKey = "gcslock-owner"
+
+	// The holder's Config.TTL, formatted with time.Duration.String.
+	ttlKey = "gcslock-ttl"
+
+	// Updated on every renewal, so that GCS bumps the object's meta-generation
+	// and update time.
+	heartbeatKey = "gcslock-heartbeat"
+)
+
+// Config contains options accepted by Acquire and TryAcquire.
+type Config struct {
+	// The bucket in which to keep the lock object. Must be set.
+	Bucket gcs.Bucket
+
+	// The name of the lock object. Must be set. Everyone contending for the lock
+	// must use the same name, and nothing else may write to the object.
+	Name string
+
+	// A description of the holder, recorded in the lock object's metadata for
+	// the benefit of humans and HeldError messages. If empty, one made up of
+	// the host name, process ID and some random bytes is used.
+	Owner string
+
+	// How long the lock remains held after the last successful renewal. Others
+	// judge this by comparing the lock object's update time, as recorded by
+	// GCS, with their own clocks, so it must comfortably exceed any clock skew.
+	// If zero, 30 seconds is used.
+	TTL time.Duration
+
+	// How often the lease is renewed in the background. If zero, a third of the
+	// TTL is used.
+	HeartbeatInterval time.Duration
+
+	// How long Acquire waits between attempts while the lock is held by
+	// someone else. If zero, one second is used.
+	RetryInterval time.Duration
+
+	// The clock used to decide when leases expire. If nil, the real clock is
+	// used.
+	Clock timeutil.Clock
+}
+
+// A *HeldError value is an error that indicates that the lock is held by
+// someone else, or changed hands while we were trying to acquire it.
+type HeldError struct {
+	Err error
+}
+
+func (he *HeldError) Error() string {
+	return fmt.Sprintf("gcslock.HeldError: %v", he.Err)
+}
+
+// Make up an owner description that is very likely to be unique.
+func defaultOwner() (owner string, err error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		err = fmt.Errorf("Hostname: %v", err)
+		return
+	}
+
+	var b [8]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		err = fmt.Errorf("rand.Read: %v", err)
+		return
+	}
+
+	owner = fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(b[:]))
+	return
+}
+
+// Return a copy of the supplied config with defaults filled in.
+func withDefaults(in *Config) (cfg Config, err error) {
+	cfg = *in
+
+	if cfg.Bucket == nil || cfg.Name == "" {
+		err = errors.New("Config.Bucket and Config.Name must be set")
+		return
+	}
+
+	if cfg.Owner == "" {
+		if cfg.Owner, err = defaultOwner(); err != nil {
+			return
+		}
+	}
+
+	if cfg.TTL == 0 {
+		cfg.TTL = 30 * time.Second
+	}
+
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = cfg.TTL / 3
+	}
+
+	if cfg.RetryInterval == 0 {
+		cfg.RetryInterval = time.Second
+	}
+
+	if cfg.Clock == nil {
+		cfg.Clock = timeutil.RealClock()
+	}
+
+	return
+}
+
+// Return the time at which the lease recorded by the supplied lock object
+// expires.
+func expiration(o *gcs.Object) (t time.Time, err error) {
+	ttl, err := time.ParseDuration(o.Metadata[ttlKey])
+	if err != nil {
+		err = fmt.Errorf("Object %q is not a lock: %v", o.Name, err)
+		return
+	}
+
+	t = o.Updated.Add(ttl)
+	return
+}
+
+// Make a single attempt to acquire the lock described by the config,
+// returning a *HeldError if someone else holds it. If a previous holder's
+// lease has expired, the lock is taken over.
+//
+// On success, the lease is renewed in the background until it is released
+// or lost.
+func TryAcquire(
+	ctx context.Context,
+	cfg *Config) (l *Lease, err error) {
+	c, err := withDefaults(cfg)
+	if err != nil {
+		return
+	}
+
+	l, err = tryAcquire(ctx, &c)
+	return
+}
+
+// Acquire the lock described by the config, waiting while someone else holds
+// it until the context is cancelled. Returns the context's error in that
+// case.
+//
+// On success, the lease is renewed in the background until it is released
+// or lost.
+func Acquire(
+	ctx context.Context,
+	cfg *Config) (l *Lease, err error) {
+	c, err := withDefaults(cfg)
+	if err != nil {
+		return
+	}
+
+	for {
+		l, err = tryAcquire(ctx, &c)
+		if _, ok := err.(*HeldError); !ok {
+			return
+		}
+
+		select {
+		case <-time.After(c.RetryInterval):
+
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+func tryAcquire(
+	ctx context.Context,
+	cfg *Config) (l *Lease, err error) {
+	// Note the time before making any requests, so that our idea of when the
+	// lease expires is conservative.
+	start := cfg.Clock.Now()
+
+	// Try to create the lock object from scratch.
+	var zero int64
+	req := &gcs.CreateObjectRequest{
+		Name:     cfg.Name,
+		Contents: strings.NewReader(""),
+		Metadata: map[string]string{
+			ownerKey: cfg.Owner,
+			ttlKey:   cfg.TTL.String(),
+		},
+		GenerationPrecondition: &zero,
+	}
+
+	o, err := cfg.Bucket.CreateObject(ctx, req)
+	if err == nil {
+		l = newLease(cfg, o, start)
+		return
+	}
+
+	if _, ok := err.(*gcs.PreconditionError); !ok {
+		err = fmt.Errorf("CreateObject: %v", err)
+		return
+	}
+
+	// Someone else holds the lock, or did. Find out whether their lease has
+	// expired.
+	existing, err := cfg.Bucket.StatObject(
+		ctx,
+		&gcs.StatObjectRequest{Name: cfg.Name})
+
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		err = &HeldError{
+			Err: fmt.Errorf("Lock %q was released while acquiring it", cfg.Name),
+		}
+
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("StatObject: %v", err)
+		return
+	}
+
+	expires, err := expiration(existing)
+	if err != nil {
+		return
+	}
+
+	if start.Before(expires) {
+		err = &HeldError{
+			Err: fmt.Errorf(
+				"Lock %q is held by %q until %v",
+				cfg.Name,
+				existing.Metadata[ownerKey],
+				expires),
+		}
+
+		return
+	}
+
+	// Take over the expired lease, making sure that its holder hasn't renewed
+	// it in the meantime and that nobody else beats us to it.
+	req.GenerationPrecondition = &existing.Generation
+	req.MetaGenerationPrecondition = &existing.MetaGeneration
+	req.Contents = strings.NewReader("")
+
+	o, err = cfg.Bucket.CreateObject(ctx, req)
+	if _, ok := err.(*gcs.PreconditionError); ok {
+		err = &HeldError{
+			Err: fmt.Errorf("Lock %q changed hands while acquiring it", cfg.Name),
+		}
+
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("CreateObject: %v", err)
+		return
+	}
+
+	l = newLease(cfg, o, start)
+	return
+}
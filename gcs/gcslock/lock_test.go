@@ -0,0 +1,258 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcslock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcslock"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestLock(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+const ttl = time.Minute
+
+type LockTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &LockTest{}
+
+func init() { RegisterTestSuite(&LockTest{}) }
+
+func (t *LockTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+// Return a config for the lock, with background renewal effectively
+// disabled.
+func (t *LockTest) config(owner string) *gcslock.Config {
+	return &gcslock.Config{
+		Bucket:            t.bucket,
+		Name:              "lock",
+		Owner:             owner,
+		TTL:               ttl,
+		HeartbeatInterval: time.Hour,
+		RetryInterval:     time.Millisecond,
+		Clock:             &t.clock,
+	}
+}
+
+func (t *LockTest) stat() (o *gcs.Object, err error) {
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "lock"})
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *LockTest) MissingConfig() {
+	_, err := gcslock.TryAcquire(t.ctx, &gcslock.Config{Bucket: t.bucket})
+	ExpectThat(err, Error(HasSubstr("must be set")))
+}
+
+func (t *LockTest) AcquireAndRelease() {
+	l, err := gcslock.TryAcquire(t.ctx, t.config("alice"))
+	AssertEq(nil, err)
+	ExpectEq(nil, l.Err())
+	ExpectThat(l.Expires(), timeutil.TimeEq(t.clock.Now().Add(ttl)))
+
+	o, err := t.stat()
+	AssertEq(nil, err)
+	ExpectEq(l.Generation(), o.Generation)
+	ExpectEq("alice", o.Metadata["gcslock-owner"])
+
+	// Others can't acquire it.
+	_, err = gcslock.TryAcquire(t.ctx, t.config("bob"))
+	ExpectThat(err, HasSameTypeAs(&gcslock.HeldError{}))
+	ExpectThat(err, Error(HasSubstr("alice")))
+
+	// Release it. The object goes away, and the lease reports why it's gone.
+	err = l.Release(t.ctx)
+	AssertEq(nil, err)
+
+	_, err = t.stat()
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	<-l.Lost()
+	ExpectThat(l.Err(), HasSameTypeAs(&gcslock.LostError{}))
+	ExpectThat(l.Renew(t.ctx), HasSameTypeAs(&gcslock.LostError{}))
+
+	// Now someone else can have it, with a larger generation.
+	l2, err := gcslock.TryAcquire(t.ctx, t.config("bob"))
+	AssertEq(nil, err)
+	ExpectLt(l.Generation(), l2.Generation())
+
+	AssertEq(nil, l2.Release(t.ctx))
+}
+
+func (t *LockTest) DefaultOwner() {
+	cfg := t.config("")
+
+	l, err := gcslock.TryAcquire(t.ctx, cfg)
+	AssertEq(nil, err)
+	defer l.Release(t.ctx)
+
+	o, err := t.stat()
+	AssertEq(nil, err)
+	ExpectNe("", o.Metadata["gcslock-owner"])
+	ExpectEq("", cfg.Owner)
+}
+
+func (t *LockTest) RenewedLeaseIsNotTakenOver() {
+	l, err := gcslock.TryAcquire(t.ctx, t.config("alice"))
+	AssertEq(nil, err)
+	defer l.Release(t.ctx)
+
+	// Renew halfway through the TTL.
+	t.clock.AdvanceTime(ttl / 2)
+	AssertEq(nil, l.Renew(t.ctx))
+	ExpectThat(l.Expires(), timeutil.TimeEq(t.clock.Now().Add(ttl)))
+
+	// The original TTL has passed, but the lease is still good.
+	t.clock.AdvanceTime(ttl/2 + time.Second)
+
+	_, err = gcslock.TryAcquire(t.ctx, t.config("bob"))
+	ExpectThat(err, HasSameTypeAs(&gcslock.HeldError{}))
+	ExpectEq(nil, l.Err())
+}
+
+func (t *LockTest) ExpiredLeaseIsTakenOver() {
+	l, err := gcslock.TryAcquire(t.ctx, t.config("alice"))
+	AssertEq(nil, err)
+
+	// Once the TTL has passed, someone else may take over.
+	t.clock.AdvanceTime(ttl)
+
+	l2, err := gcslock.TryAcquire(t.ctx, t.config("bob"))
+	AssertEq(nil, err)
+	ExpectLt(l.Generation(), l2.Generation())
+
+	// The original holder finds out when it next tries to renew.
+	err = l.Renew(t.ctx)
+	ExpectThat(err, HasSameTypeAs(&gcslock.LostError{}))
+	<-l.Lost()
+
+	// Releasing the lost lease doesn't disturb the new holder.
+	AssertEq(nil, l.Release(t.ctx))
+
+	o, err := t.stat()
+	AssertEq(nil, err)
+	ExpectEq(l2.Generation(), o.Generation)
+	ExpectEq("bob", o.Metadata["gcslock-owner"])
+
+	AssertEq(nil, l2.Release(t.ctx))
+}
+
+func (t *LockTest) DeletedLockIsLost() {
+	l, err := gcslock.TryAcquire(t.ctx, t.config("alice"))
+	AssertEq(nil, err)
+
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "lock"})
+	AssertEq(nil, err)
+
+	err = l.Renew(t.ctx)
+	ExpectThat(err, HasSameTypeAs(&gcslock.LostError{}))
+	ExpectThat(l.Err(), HasSameTypeAs(&gcslock.LostError{}))
+}
+
+func (t *LockTest) RenewsInBackground() {
+	cfg := t.config("alice")
+	cfg.HeartbeatInterval = time.Millisecond
+
+	l, err := gcslock.TryAcquire(t.ctx, cfg)
+	AssertEq(nil, err)
+	defer l.Release(t.ctx)
+
+	// Wait for the object's meta-generation to go up.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		o, err := t.stat()
+		AssertEq(nil, err)
+
+		if o.MetaGeneration > 1 {
+			ExpectNe("", o.Metadata["gcslock-heartbeat"])
+			break
+		}
+
+		AssertTrue(time.Now().Before(deadline), "No renewal")
+		time.Sleep(time.Millisecond)
+	}
+
+	ExpectEq(nil, l.Err())
+}
+
+func (t *LockTest) AcquireWaitsForRelease() {
+	l, err := gcslock.TryAcquire(t.ctx, t.config("alice"))
+	AssertEq(nil, err)
+
+	// Start waiting for the lock.
+	type result struct {
+		l   *gcslock.Lease
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		l2, err := gcslock.Acquire(t.ctx, t.config("bob"))
+		done <- result{l2, err}
+	}()
+
+	// It shouldn't succeed until we release.
+	select {
+	case <-done:
+		AddFailure("Acquire returned early")
+		AbortTest()
+
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	AssertEq(nil, l.Release(t.ctx))
+
+	r := <-done
+	AssertEq(nil, r.err)
+	ExpectLt(l.Generation(), r.l.Generation())
+
+	AssertEq(nil, r.l.Release(t.ctx))
+}
+
+func (t *LockTest) AcquireCancelled() {
+	l, err := gcslock.TryAcquire(t.ctx, t.config("alice"))
+	AssertEq(nil, err)
+	defer l.Release(t.ctx)
+
+	ctx, cancel := context.WithTimeout(t.ctx, 10*time.Millisecond)
+	defer cancel()
+
+	_, err = gcslock.Acquire(ctx, t.config("bob"))
+	ExpectTrue(err == context.DeadlineExceeded, "%v", err)
+}
@@ -0,0 +1,1137 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcss3
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/internal/customtime"
+	"github.com/jacobsa/gcloud/httputil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+// Config describes how to reach an S3-compatible service.
+type Config struct {
+	// The base URL of the service, for example
+	// "https://s3.us-east-1.amazonaws.com" or "http://localhost:9000". Buckets
+	// are addressed path-style, as <Endpoint>/<bucket>/<key>.
+	Endpoint string
+
+	// The region with which requests are signed, for example "us-east-1".
+	Region string
+
+	// Credentials with which requests are signed. SessionToken is needed only
+	// for temporary credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// The HTTP client used to make requests. If nil, http.DefaultClient is
+	// used.
+	HTTPClient *http.Client
+
+	// The clock used for signing requests and minting generation numbers. If
+	// nil, timeutil.RealClock() is used.
+	Clock timeutil.Clock
+
+	// The user agent string sent with each request. If empty, a default is
+	// used.
+	UserAgent string
+}
+
+// Return a bucket that accesses the S3 bucket with the given name using the
+// supplied configuration. No requests are made until the bucket is used.
+func NewBucket(name string, cfg *Config) (b gcs.Bucket, err error) {
+	if name == "" {
+		err = errors.New("Empty bucket name")
+		return
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		err = fmt.Errorf("Parsing endpoint: %v", err)
+		return
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		err = fmt.Errorf("Unsupported endpoint scheme: %q", endpoint.Scheme)
+		return
+	}
+
+	if cfg.Region == "" {
+		err = errors.New("Region must be specified")
+		return
+	}
+
+	sb := &bucket{
+		name:     name,
+		endpoint: endpoint,
+		signer: signer{
+			region:          cfg.Region,
+			service:         signingService,
+			accessKeyID:     cfg.AccessKeyID,
+			secretAccessKey: cfg.SecretAccessKey,
+			sessionToken:    cfg.SessionToken,
+		},
+		client:    cfg.HTTPClient,
+		clock:     cfg.Clock,
+		userAgent: cfg.UserAgent,
+	}
+
+	if sb.client == nil {
+		sb.client = http.DefaultClient
+	}
+
+	if sb.clock == nil {
+		sb.clock = timeutil.RealClock()
+	}
+
+	if sb.userAgent == "" {
+		sb.userAgent = "github.com-jacobsa-gcloud-gcss3"
+	}
+
+	b = sb
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// User metadata keys reserved for state that GCS tracks but S3 doesn't.
+const (
+	reservedKeyPrefix = "goog-"
+	generationKey     = reservedKeyPrefix + "generation"
+	metaGenerationKey = reservedKeyPrefix + "metageneration"
+	componentCountKey = reservedKeyPrefix + "component-count"
+	crc32cKey         = reservedKeyPrefix + "crc32c"
+	md5Key            = reservedKeyPrefix + "md5"
+	customTimeKey     = reservedKeyPrefix + "custom-time"
+)
+
+const metadataHeaderPrefix = "X-Amz-Meta-"
+
+type bucket struct {
+	name      string
+	endpoint  *url.URL
+	signer    signer
+	client    *http.Client
+	clock     timeutil.Clock
+	userAgent string
+
+	mu sync.Mutex
+
+	// The most recent generation number minted by this bucket.
+	prevGeneration int64 // GUARDED_BY(mu)
+}
+
+// Mint a generation number for a new object. Like GCS, we use a timestamp,
+// bumped if necessary so that the numbers we mint are strictly increasing.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *bucket) mintGeneration() (generation int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	generation = b.clock.Now().UnixNano() / 1000
+	if generation <= b.prevGeneration {
+		generation = b.prevGeneration + 1
+	}
+
+	b.prevGeneration = generation
+	return
+}
+
+// Return the URL for the given object within the given bucket, or for the
+// bucket itself if key is empty.
+func (b *bucket) objectURL(
+	bucketName string,
+	key string,
+	query url.Values) *url.URL {
+	path := strings.TrimSuffix(b.endpoint.Path, "/") + "/" + uriEncode(bucketName, false)
+	if key != "" {
+		path += "/" + uriEncode(key, true)
+	}
+
+	return &url.URL{
+		Scheme:   b.endpoint.Scheme,
+		Host:     b.endpoint.Host,
+		Opaque:   "//" + b.endpoint.Host + path,
+		RawQuery: encodeQuery(query),
+	}
+}
+
+// Send a signed request concerning the given object in the given bucket (or
+// the bucket itself if key is empty). If the response indicates success, it
+// is returned and the caller must close its body. Otherwise an appropriately
+// typed error is returned.
+func (b *bucket) send(
+	ctx context.Context,
+	method string,
+	bucketName string,
+	key string,
+	query url.Values,
+	header http.Header,
+	body io.Reader,
+	bodyLength int64) (httpRes *http.Response, err error) {
+	var rc io.ReadCloser
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		rc = ioutil.NopCloser(body)
+		payloadHash = unsignedPayload
+	}
+
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		method,
+		b.objectURL(bucketName, key, query),
+		rc,
+		bodyLength,
+		b.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	for k, vs := range header {
+		httpReq.Header[k] = vs
+	}
+
+	httpReq.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	b.signer.sign(httpReq, payloadHash, b.clock.Now())
+
+	httpRes, err = b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	if err = checkResponse(httpRes); err != nil {
+		httpRes.Body.Close()
+		httpRes = nil
+		return
+	}
+
+	return
+}
+
+// An error response from S3.
+type responseError struct {
+	StatusCode int    `xml:"-"`
+	Status     string `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.Status, e.Code, e.Message)
+}
+
+// Turn an error response into an error of the appropriate type: a gcs error
+// type if there is one that fits, or *responseError otherwise. Responses to
+// HEAD requests carry no body, so we rely mostly on the status code.
+func checkResponse(httpRes *http.Response) (err error) {
+	if httpRes.StatusCode >= 200 && httpRes.StatusCode < 300 {
+		return
+	}
+
+	re := &responseError{
+		StatusCode: httpRes.StatusCode,
+		Status:     httpRes.Status,
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(httpRes.Body, 1<<16))
+	xml.Unmarshal(body, re)
+
+	err = wrapError(httpRes.StatusCode, re.Code, re)
+	return
+}
+
+// Wrap the supplied error in the gcs error type appropriate for the given
+// status and S3 error code, if any.
+func wrapError(status int, code string, err error) error {
+	switch {
+	case status == http.StatusNotFound:
+		return &gcs.NotFoundError{Err: err}
+
+	case status == http.StatusPreconditionFailed,
+		status == http.StatusNotModified,
+		code == "ConditionalRequestConflict":
+		return &gcs.PreconditionError{Err: err}
+
+	case status == http.StatusForbidden:
+		return &gcs.ForbiddenError{Err: err}
+
+	case status == http.StatusTooManyRequests,
+		code == "SlowDown",
+		status == http.StatusServiceUnavailable:
+		return &gcs.RateLimitError{Err: err}
+	}
+
+	return err
+}
+
+func encodeCRC32C(crc32c uint32) string {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], crc32c)
+	return base64.StdEncoding.EncodeToString(buf[:])
+}
+
+func decodeCRC32C(s string) (crc32c uint32, err error) {
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return
+	}
+
+	if len(buf) != 4 {
+		err = fmt.Errorf("Wrong length for decoded CRC32C: %d", len(buf))
+		return
+	}
+
+	crc32c = binary.BigEndian.Uint32(buf)
+	return
+}
+
+// Decode an MD5 hash in the supplied encoding, returning nil if it doesn't
+// look like one. S3 ETags are hex-encoded MD5 hashes only for objects that
+// weren't uploaded in parts.
+func decodeMD5(s string, decode func(string) ([]byte, error)) (md5Sum *[16]byte) {
+	buf, err := decode(s)
+	if err != nil || len(buf) != 16 {
+		return
+	}
+
+	md5Sum = new([16]byte)
+	copy(md5Sum[:], buf)
+	return
+}
+
+// An object along with the ETag that identifies its current contents and
+// metadata in S3.
+type s3Object struct {
+	*gcs.Object
+	etag string
+}
+
+// Construct an object record from the headers of a response to a HEAD or
+// full GET request.
+func (b *bucket) objectFromHeaders(
+	name string,
+	h http.Header) (o s3Object, err error) {
+	o.etag = h.Get("ETag")
+	o.Object = &gcs.Object{
+		Name:            name,
+		ContentType:     h.Get("Content-Type"),
+		ContentLanguage: h.Get("Content-Language"),
+		CacheControl:    h.Get("Cache-Control"),
+		ContentEncoding: h.Get("Content-Encoding"),
+		MediaLink:       b.objectURL(b.name, name, nil).String(),
+		StorageClass:    h.Get("X-Amz-Storage-Class"),
+		ComponentCount:  1,
+		MetaGeneration:  1,
+	}
+
+	// S3 omits the storage class for standard objects.
+	if o.StorageClass == "" {
+		o.StorageClass = "STANDARD"
+	}
+
+	if v := h.Get("Content-Length"); v != "" {
+		if o.Size, err = strconv.ParseUint(v, 10, 64); err != nil {
+			err = fmt.Errorf("Parsing Content-Length: %v", err)
+			return
+		}
+	}
+
+	if v := h.Get("Last-Modified"); v != "" {
+		if o.Updated, err = http.ParseTime(v); err != nil {
+			err = fmt.Errorf("Parsing Last-Modified: %v", err)
+			return
+		}
+	}
+
+	// Objects written by other clients don't have a generation number, so
+	// derive one from the modification time. This changes when the object is
+	// overwritten, as long as that doesn't happen twice in one second.
+	o.Generation = o.Updated.UnixNano() / 1000
+
+	// For objects not uploaded in parts, the ETag is the MD5 hash.
+	o.Etag = strings.Trim(o.etag, `"`)
+	o.MD5 = decodeMD5(o.Etag, hex.DecodeString)
+
+	// Split the user metadata from our own.
+	for k, vs := range h {
+		if !strings.HasPrefix(k, metadataHeaderPrefix) || len(vs) == 0 {
+			continue
+		}
+
+		key := strings.ToLower(k[len(metadataHeaderPrefix):])
+		v := vs[0]
+
+		switch key {
+		case generationKey:
+			o.Generation, err = strconv.ParseInt(v, 10, 64)
+
+		case metaGenerationKey:
+			o.MetaGeneration, err = strconv.ParseInt(v, 10, 64)
+
+		case componentCountKey:
+			o.ComponentCount, err = strconv.ParseInt(v, 10, 64)
+
+		case crc32cKey:
+			o.CRC32C, err = decodeCRC32C(v)
+
+		case md5Key:
+			o.MD5 = decodeMD5(v, base64.StdEncoding.DecodeString)
+
+		case customTimeKey:
+			o.CustomTime, err = time.Parse(time.RFC3339Nano, v)
+
+		default:
+			if strings.HasPrefix(key, reservedKeyPrefix) {
+				continue
+			}
+
+			if o.Metadata == nil {
+				o.Metadata = make(map[string]string)
+			}
+
+			o.Metadata[key] = v
+		}
+
+		if err != nil {
+			err = fmt.Errorf("Parsing %s: %v", k, err)
+			return
+		}
+	}
+
+	// Composite objects have no MD5, as in GCS.
+	if o.ComponentCount > 1 {
+		o.MD5 = nil
+	}
+
+	o.Created = createdTime(o.Generation)
+	return
+}
+
+// Return the creation time of the object with the given generation. As with
+// GCS, generation numbers are minted from the creation time in microseconds.
+func createdTime(generation int64) time.Time {
+	return time.Unix(0, generation*1000)
+}
+
+// Does the object carry a CRC32C checksum written by this package?
+func hasCRC32C(h http.Header) bool {
+	return h.Get(metadataHeaderPrefix+crc32cKey) != ""
+}
+
+// Set the headers that describe the supplied object's attributes and
+// metadata, for use when writing it.
+func setObjectHeaders(h http.Header, o *gcs.Object, hasCRC32C bool) {
+	set := func(k string, v string) {
+		if v != "" {
+			h.Set(k, v)
+		}
+	}
+
+	set("Content-Type", o.ContentType)
+	set("Content-Language", o.ContentLanguage)
+	set("Cache-Control", o.CacheControl)
+	set("Content-Encoding", o.ContentEncoding)
+
+	for k, v := range o.Metadata {
+		h.Set(metadataHeaderPrefix+k, v)
+	}
+
+	h.Set(metadataHeaderPrefix+generationKey, strconv.FormatInt(o.Generation, 10))
+	h.Set(
+		metadataHeaderPrefix+metaGenerationKey,
+		strconv.FormatInt(o.MetaGeneration, 10))
+
+	if o.ComponentCount > 1 {
+		h.Set(
+			metadataHeaderPrefix+componentCountKey,
+			strconv.FormatInt(o.ComponentCount, 10))
+	}
+
+	if hasCRC32C {
+		h.Set(metadataHeaderPrefix+crc32cKey, encodeCRC32C(o.CRC32C))
+	}
+
+	if o.MD5 != nil {
+		h.Set(
+			metadataHeaderPrefix+md5Key,
+			base64.StdEncoding.EncodeToString(o.MD5[:]))
+	}
+
+	if !o.CustomTime.IsZero() {
+		h.Set(
+			metadataHeaderPrefix+customTimeKey,
+			o.CustomTime.UTC().Format(time.RFC3339Nano))
+	}
+}
+
+// Look up the current state of the given object with a HEAD request.
+func (b *bucket) head(
+	ctx context.Context,
+	name string) (o s3Object, crc32cKnown bool, err error) {
+	if err = gcs.CheckObjectName(name); err != nil {
+		return
+	}
+
+	httpRes, err := b.send(ctx, "HEAD", b.name, name, nil, nil, nil, 0)
+	if err != nil {
+		return
+	}
+
+	httpRes.Body.Close()
+
+	if o, err = b.objectFromHeaders(name, httpRes.Header); err != nil {
+		return
+	}
+
+	crc32cKnown = hasCRC32C(httpRes.Header)
+	return
+}
+
+// Check generation and meta-generation preconditions against the supplied
+// object.
+func checkPreconditions(
+	o *gcs.Object,
+	generationPrecondition *int64,
+	metaGenerationPrecondition *int64) (err error) {
+	if generationPrecondition != nil &&
+		*generationPrecondition != o.Generation {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Precondition failed: object %q has generation %v",
+				o.Name,
+				o.Generation),
+		}
+
+		return
+	}
+
+	if metaGenerationPrecondition != nil &&
+		*metaGenerationPrecondition != o.MetaGeneration {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Precondition failed: object %q has meta-generation %v",
+				o.Name,
+				o.MetaGeneration),
+		}
+
+		return
+	}
+
+	return
+}
+
+// Look up the given object, returning *gcs.NotFoundError if it doesn't exist
+// or doesn't have the given generation (zero meaning any).
+func (b *bucket) find(
+	ctx context.Context,
+	name string,
+	generation int64) (o s3Object, crc32cKnown bool, err error) {
+	o, crc32cKnown, err = b.head(ctx, name)
+	if err != nil {
+		return
+	}
+
+	if generation != 0 && o.Generation != generation {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf("Object %q generation %d not found", name, generation),
+		}
+
+		return
+	}
+
+	return
+}
+
+// Work out the conditional headers for a write of the given object, checking
+// the supplied preconditions against its current state if necessary.
+func (b *bucket) writePreconditionHeaders(
+	ctx context.Context,
+	name string,
+	generationPrecondition *int64,
+	metaGenerationPrecondition *int64) (h http.Header, err error) {
+	h = make(http.Header)
+
+	// Easy case: the object must not exist.
+	if generationPrecondition != nil && *generationPrecondition == 0 {
+		h.Set("If-None-Match", "*")
+		return
+	}
+
+	if generationPrecondition == nil && metaGenerationPrecondition == nil {
+		return
+	}
+
+	// Otherwise we must check the object's current state, then make sure that
+	// it hasn't changed by the time the write happens.
+	existing, _, err := b.head(ctx, name)
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf("Precondition failed: object %q doesn't exist", name),
+		}
+
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	err = checkPreconditions(
+		existing.Object,
+		generationPrecondition,
+		metaGenerationPrecondition)
+
+	if err != nil {
+		return
+	}
+
+	h.Set("If-Match", existing.etag)
+	return
+}
+
+// The response to a successful copy request. S3 may also send an error
+// document with status 200.
+type copyObjectResult struct {
+	XMLName      xml.Name
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Code         string `xml:"Code"`
+	Message      string `xml:"Message"`
+}
+
+// Copy the source object, whose current ETag must match the one given, to
+// the given destination, writing the supplied attributes. The caller is
+// responsible for filling in the destination's generation and
+// meta-generation.
+func (b *bucket) copy(
+	ctx context.Context,
+	src s3Object,
+	crc32cKnown bool,
+	dstBucket string,
+	dst *gcs.Object,
+	header http.Header) (o *gcs.Object, err error) {
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	header.Set(
+		"X-Amz-Copy-Source",
+		"/"+uriEncode(b.name, false)+"/"+uriEncode(src.Name, true))
+
+	header.Set("X-Amz-Copy-Source-If-Match", src.etag)
+	header.Set("X-Amz-Metadata-Directive", "REPLACE")
+	setObjectHeaders(header, dst, crc32cKnown)
+
+	httpRes, err := b.send(ctx, "PUT", dstBucket, dst.Name, nil, header, nil, 0)
+	if err != nil {
+		return
+	}
+
+	defer httpRes.Body.Close()
+
+	var result copyObjectResult
+	if err = xml.NewDecoder(httpRes.Body).Decode(&result); err != nil {
+		err = fmt.Errorf("Decoding copy result: %v", err)
+		return
+	}
+
+	if result.XMLName.Local == "Error" {
+		err = wrapError(
+			0,
+			result.Code,
+			fmt.Errorf("Copy failed: %s %s", result.Code, result.Message))
+
+		return
+	}
+
+	o = dst
+	if o.Updated, err = time.Parse(time.RFC3339, result.LastModified); err != nil {
+		err = fmt.Errorf("Parsing LastModified: %v", err)
+		return
+	}
+
+	// The ETag of a copy is the MD5 hash if the source's was.
+	o.Etag = strings.Trim(result.ETag, `"`)
+	if o.ComponentCount <= 1 && o.MD5 == nil {
+		o.MD5 = decodeMD5(o.Etag, hex.DecodeString)
+	}
+
+	o.Created = createdTime(o.Generation)
+
+	o.MediaLink = b.objectURL(dstBucket, dst.Name, nil).String()
+	return
+}
+
+// Return a copy of the supplied object that shares no state with it.
+func copyObject(in *gcs.Object) (out *gcs.Object) {
+	oCopy := *in
+	if in.Metadata != nil {
+		oCopy.Metadata = make(map[string]string)
+		for k, v := range in.Metadata {
+			oCopy.Metadata[k] = v
+		}
+	}
+
+	out = &oCopy
+	return
+}
+
+func errHoldsNotSupported() error {
+	return errors.New("Object holds are not supported by S3-compatible buckets")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Public interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *bucket) Name() string {
+	return b.name
+}
+
+func (b *bucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	// S3 always returns the full set of attributes, so req.Fields can be
+	// ignored.
+	so, _, err := b.head(ctx, req.Name)
+	if err != nil {
+		return
+	}
+
+	err = checkPreconditions(
+		so.Object,
+		req.GenerationPrecondition,
+		req.MetaGenerationPrecondition)
+
+	if err != nil {
+		return
+	}
+
+	o = so.Object
+	return
+}
+
+func (b *bucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	if err = gcs.CheckObjectName(req.DstName); err != nil {
+		return
+	}
+
+	if req.DstPredefinedACL != "" {
+		err = errors.New("ACLs are not supported by S3-compatible buckets")
+		return
+	}
+
+	src, crc32cKnown, err := b.find(ctx, req.SrcName, req.SrcGeneration)
+	if err != nil {
+		return
+	}
+
+	err = checkPreconditions(src.Object, nil, req.SrcMetaGenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	dst := copyObject(src.Object)
+	dst.Name = req.DstName
+	dst.Generation = b.mintGeneration()
+	dst.MetaGeneration = 1
+
+	o, err = b.copy(ctx, src, crc32cKnown, b.name, dst, nil)
+	return
+}
+
+func (b *bucket) MoveObject(
+	ctx context.Context,
+	req *gcs.MoveObjectRequest) (o *gcs.Object, err error) {
+	// S3 has no atomic rename, so copy and then delete the source.
+	o, err = b.CopyObject(
+		ctx,
+		&gcs.CopyObjectRequest{
+			SrcName:                       req.SrcName,
+			DstName:                       req.DstName,
+			SrcGeneration:                 req.SrcGeneration,
+			SrcMetaGenerationPrecondition: req.SrcMetaGenerationPrecondition,
+		})
+
+	if err != nil {
+		return
+	}
+
+	if req.SrcName != req.DstName {
+		httpRes, deleteErr := b.send(ctx, "DELETE", b.name, req.SrcName, nil, nil, nil, 0)
+		if deleteErr != nil {
+			err = fmt.Errorf("Deleting source: %v", deleteErr)
+			return
+		}
+
+		httpRes.Body.Close()
+	}
+
+	return
+}
+
+func (b *bucket) RewriteObject(
+	ctx context.Context,
+	req *gcs.RewriteObjectRequest) (o *gcs.Object, err error) {
+	if req.DstKMSKeyName != "" {
+		err = errors.New("DstKMSKeyName is not supported by S3-compatible buckets")
+		return
+	}
+
+	if req.DstPredefinedACL != "" {
+		err = errors.New("ACLs are not supported by S3-compatible buckets")
+		return
+	}
+
+	if err = gcs.CheckObjectName(req.DstName); err != nil {
+		return
+	}
+
+	dstBucket := req.DstBucket
+	if dstBucket == "" {
+		dstBucket = b.name
+	}
+
+	src, crc32cKnown, err := b.find(ctx, req.SrcName, req.SrcGeneration)
+	if err != nil {
+		return
+	}
+
+	err = checkPreconditions(src.Object, nil, req.SrcMetaGenerationPrecondition)
+	if err != nil {
+		return
+	}
+
+	// Condition the destination if requested. We can only check the state of
+	// destinations in our own bucket.
+	var header http.Header
+	if req.DstGenerationPrecondition != nil {
+		if *req.DstGenerationPrecondition != 0 && dstBucket != b.name {
+			err = errors.New(
+				"DstGenerationPrecondition must be zero when rewriting to another bucket")
+			return
+		}
+
+		header, err = b.writePreconditionHeaders(
+			ctx,
+			req.DstName,
+			req.DstGenerationPrecondition,
+			nil)
+
+		if err != nil {
+			return
+		}
+	}
+
+	dst := copyObject(src.Object)
+	dst.Name = req.DstName
+	dst.Generation = b.mintGeneration()
+	dst.MetaGeneration = 1
+
+	if req.DstStorageClass != "" {
+		if header == nil {
+			header = make(http.Header)
+		}
+
+		header.Set("X-Amz-Storage-Class", req.DstStorageClass)
+		dst.StorageClass = req.DstStorageClass
+	}
+
+	// S3 copies in a single call, so req.MaxBytesPerCall makes no difference.
+	o, err = b.copy(ctx, src, crc32cKnown, dstBucket, dst, header)
+	if err != nil {
+		return
+	}
+
+	if req.Progress != nil {
+		req.Progress(int64(o.Size), int64(o.Size))
+	}
+
+	return
+}
+
+func (b *bucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	if req.TemporaryHold != nil || req.EventBasedHold != nil {
+		err = errHoldsNotSupported()
+		return
+	}
+
+	if req.ACL != nil {
+		err = errors.New("ACLs are not supported by S3-compatible buckets")
+		return
+	}
+
+	existing, crc32cKnown, err := b.find(ctx, req.Name, req.Generation)
+	if err != nil {
+		return
+	}
+
+	err = checkPreconditions(
+		existing.Object,
+		req.GenerationPrecondition,
+		req.MetaGenerationPrecondition)
+
+	if err != nil {
+		return
+	}
+
+	// Apply the changes.
+	updated := copyObject(existing.Object)
+	if req.ContentType != nil {
+		updated.ContentType = *req.ContentType
+	}
+
+	if req.ContentEncoding != nil {
+		updated.ContentEncoding = *req.ContentEncoding
+	}
+
+	if req.ContentLanguage != nil {
+		updated.ContentLanguage = *req.ContentLanguage
+	}
+
+	if req.CacheControl != nil {
+		updated.CacheControl = *req.CacheControl
+	}
+
+	if req.CustomTime != nil {
+		if err = customtime.Check(updated.CustomTime, *req.CustomTime); err != nil {
+			return
+		}
+
+		updated.CustomTime = *req.CustomTime
+	}
+
+	for k, v := range req.Metadata {
+		if v == nil {
+			delete(updated.Metadata, k)
+			continue
+		}
+
+		if updated.Metadata == nil {
+			updated.Metadata = make(map[string]string)
+		}
+
+		updated.Metadata[k] = *v
+	}
+
+	updated.MetaGeneration++
+
+	// S3 can't modify metadata in place, so copy the object onto itself,
+	// making sure it hasn't changed in the meantime.
+	header := make(http.Header)
+	if existing.StorageClass != "STANDARD" {
+		header.Set("X-Amz-Storage-Class", existing.StorageClass)
+	}
+
+	o, err = b.copy(ctx, existing, crc32cKnown, b.name, updated, header)
+	return
+}
+
+func (b *bucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	// Check the generation and preconditions if necessary.
+	if req.Generation != 0 ||
+		req.GenerationPrecondition != nil ||
+		req.MetaGenerationPrecondition != nil {
+		var existing s3Object
+		existing, _, err = b.find(ctx, req.Name, req.Generation)
+
+		// Like GCS, succeed if the object is already gone.
+		if _, ok := err.(*gcs.NotFoundError); ok {
+			err = nil
+			return
+		}
+
+		if err != nil {
+			return
+		}
+
+		err = checkPreconditions(
+			existing.Object,
+			req.GenerationPrecondition,
+			req.MetaGenerationPrecondition)
+
+		if err != nil {
+			return
+		}
+	}
+
+	// S3 succeeds when deleting objects that don't exist.
+	httpRes, err := b.send(ctx, "DELETE", b.name, req.Name, nil, nil, nil, 0)
+	if err != nil {
+		return
+	}
+
+	httpRes.Body.Close()
+	return
+}
+
+// S3 has no soft delete, so there is never anything to restore.
+func (b *bucket) RestoreObject(
+	ctx context.Context,
+	req *gcs.RestoreObjectRequest) (o *gcs.Object, err error) {
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	err = &gcs.NotFoundError{
+		Err: fmt.Errorf(
+			"Soft-deleted object %q (generation %d) not found",
+			req.Name,
+			req.Generation),
+	}
+
+	return
+}
+
+func (b *bucket) Batch(
+	ctx context.Context,
+	req *gcs.BatchRequest) (results []gcs.BatchResult, err error) {
+	// Check the operations up front, so that we don't fail partway through.
+	for i, op := range req.Ops {
+		n := 0
+		if op.Stat != nil {
+			n++
+		}
+
+		if op.Update != nil {
+			n++
+		}
+
+		if op.Delete != nil {
+			n++
+		}
+
+		if n != 1 {
+			err = fmt.Errorf(
+				"Operation %d: exactly one of Stat, Update, and Delete must be set",
+				i)
+			return
+		}
+
+		var name string
+		switch {
+		case op.Stat != nil:
+			name = op.Stat.Name
+
+		case op.Update != nil:
+			name = op.Update.Name
+
+		case op.Delete != nil:
+			name = op.Delete.Name
+		}
+
+		if err = gcs.CheckObjectName(name); err != nil {
+			return
+		}
+	}
+
+	// S3 has no batch API for these operations, so perform each in turn.
+	results = make([]gcs.BatchResult, len(req.Ops))
+	for i, op := range req.Ops {
+		r := &results[i]
+		switch {
+		case op.Stat != nil:
+			r.Object, r.Err = b.StatObject(ctx, op.Stat)
+
+		case op.Update != nil:
+			r.Object, r.Err = b.UpdateObject(ctx, op.Update)
+
+		case op.Delete != nil:
+			r.Err = b.DeleteObject(ctx, op.Delete)
+		}
+	}
+
+	return
+}
+
+func (b *bucket) ListObjectACLs(
+	ctx context.Context,
+	req *gcs.ListObjectACLsRequest) (rules []*gcs.ACLRule, err error) {
+	err = errors.New("ACLs are not supported by S3-compatible buckets")
+	return
+}
+
+func (b *bucket) UpdateObjectACL(
+	ctx context.Context,
+	req *gcs.UpdateObjectACLRequest) (rule *gcs.ACLRule, err error) {
+	err = errors.New("ACLs are not supported by S3-compatible buckets")
+	return
+}
+
+func (b *bucket) DeleteObjectACL(
+	ctx context.Context,
+	req *gcs.DeleteObjectACLRequest) (err error) {
+	err = errors.New("ACLs are not supported by S3-compatible buckets")
+	return
+}
+
+func (b *bucket) SignedURL(
+	ctx context.Context,
+	req *gcs.SignedURLRequest) (signed string, err error) {
+	if req.Name == "" || req.Method == "" {
+		err = errors.New("Name and Method must be specified")
+		return
+	}
+
+	if req.Expiry < time.Second || req.Expiry > gcs.MaxSignedURLExpiry {
+		err = fmt.Errorf("Invalid expiry: %v", req.Expiry)
+		return
+	}
+
+	header := make(http.Header)
+	if req.ContentType != "" {
+		header.Set("Content-Type", req.ContentType)
+	}
+
+	for k, v := range req.Headers {
+		header.Set(k, v)
+	}
+
+	u := b.objectURL(b.name, req.Name, req.QueryParameters)
+	signed = b.signer.presign(req.Method, u, header, req.Expiry, b.clock.Now()).String()
+	return
+}
@@ -0,0 +1,682 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcss3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type BucketTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	s3     *fakeS3
+	server *httptest.Server
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &BucketTest{}
+var _ TearDownInterface = &BucketTest{}
+
+func init() { RegisterTestSuite(&BucketTest{}) }
+
+func (t *BucketTest) SetUp(ti *TestInfo) {
+	var err error
+
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.UTC))
+	t.s3 = newFakeS3()
+	t.server = httptest.NewServer(t.s3)
+
+	t.bucket, err = NewBucket(
+		"some_bucket",
+		&Config{
+			Endpoint:        t.server.URL,
+			Region:          "us-east-1",
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "secret",
+			Clock:           &t.clock,
+		})
+
+	AssertEq(nil, err)
+}
+
+func (t *BucketTest) TearDown() {
+	t.server.Close()
+}
+
+func (t *BucketTest) create(name string, contents string) *gcs.Object {
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, name, []byte(contents))
+	AssertEq(nil, err)
+	return o
+}
+
+func (t *BucketTest) read(req *gcs.ReadObjectRequest) (s string, err error) {
+	rc, err := t.bucket.NewReader(t.ctx, req)
+	if err != nil {
+		return
+	}
+
+	defer rc.Close()
+
+	contents, err := ioutil.ReadAll(rc)
+	s = string(contents)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *BucketTest) BadConfig() {
+	var err error
+
+	_, err = NewBucket("", &Config{Endpoint: "http://foo", Region: "bar"})
+	ExpectThat(err, Error(HasSubstr("bucket name")))
+
+	_, err = NewBucket("baz", &Config{Endpoint: "ftp://foo", Region: "bar"})
+	ExpectThat(err, Error(HasSubstr("scheme")))
+
+	_, err = NewBucket("baz", &Config{Endpoint: "http://foo"})
+	ExpectThat(err, Error(HasSubstr("Region")))
+}
+
+func (t *BucketTest) RequestsAreSigned() {
+	t.create("foo", "taco")
+
+	AssertGt(len(t.s3.requests), 0)
+	r := t.s3.requests[0]
+	ExpectEq("/some_bucket/foo", r.URL.Path)
+	ExpectThat(
+		r.Header.Get("Authorization"),
+		HasSubstr("Credential=AKIDEXAMPLE/20120815/us-east-1/s3/aws4_request"))
+	ExpectEq(unsignedPayload, r.Header.Get("X-Amz-Content-Sha256"))
+}
+
+func (t *BucketTest) NamesAreEscaped() {
+	t.create("foo bar/baz?qux", "taco")
+
+	r := t.s3.requests[0]
+	ExpectEq("/some_bucket/foo%20bar/baz%3Fqux", r.URL.EscapedPath())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo bar/baz?qux")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *BucketTest) CreateAndStat() {
+	crc32c := crc32.Checksum([]byte("taco"), crc32.MakeTable(crc32.Castagnoli))
+	req := &gcs.CreateObjectRequest{
+		Name:            "foo",
+		ContentType:     "text/plain",
+		ContentLanguage: "fr",
+		CacheControl:    "no-cache",
+		Metadata:        map[string]string{"bar": "baz"},
+		Contents:        strings.NewReader("taco"),
+	}
+
+	created, err := t.bucket.CreateObject(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq("foo", created.Name)
+	ExpectEq(4, created.Size)
+	ExpectGt(created.Generation, 0)
+	ExpectEq(1, created.MetaGeneration)
+	ExpectEq(1, created.ComponentCount)
+	ExpectFalse(created.Created.IsZero())
+
+	md5Sum := md5.Sum([]byte("taco"))
+	ExpectThat(created.MD5, Pointee(DeepEquals(md5Sum)))
+	ExpectEq(hex.EncodeToString(md5Sum[:]), created.Etag)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	ExpectEq("foo", o.Name)
+	ExpectEq("text/plain", o.ContentType)
+	ExpectEq("fr", o.ContentLanguage)
+	ExpectEq("no-cache", o.CacheControl)
+	ExpectThat(o.Metadata, DeepEquals(map[string]string{"bar": "baz"}))
+	ExpectEq(4, o.Size)
+	ExpectEq(created.Generation, o.Generation)
+	ExpectEq(1, o.MetaGeneration)
+	ExpectEq(crc32c, created.CRC32C)
+	ExpectEq(created.CRC32C, o.CRC32C)
+	ExpectThat(o.MD5, Pointee(DeepEquals(md5Sum)))
+	ExpectEq("STANDARD", o.StorageClass)
+	ExpectEq(created.Etag, o.Etag)
+	ExpectThat(o.Created, timeutil.TimeEq(created.Created))
+	ExpectTrue(strings.HasSuffix(o.MediaLink, "/some_bucket/foo"), "%s", o.MediaLink)
+}
+
+func (t *BucketTest) GenerationsIncrease() {
+	o1 := t.create("foo", "taco")
+	o2 := t.create("foo", "burrito")
+	ExpectLt(o1.Generation, o2.Generation)
+}
+
+func (t *BucketTest) CreateChecksumMismatch() {
+	crc32c := uint32(17)
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+			CRC32C:   &crc32c,
+		})
+
+	ExpectThat(err, Error(HasSubstr("CRC32C mismatch")))
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *BucketTest) CreatePreconditions() {
+	o := t.create("foo", "taco")
+
+	// Must not exist.
+	var zero int64
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   "foo",
+			Contents:               strings.NewReader("burrito"),
+			GenerationPrecondition: &zero,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+	ExpectEq("*", t.s3.requests[len(t.s3.requests)-1].Header.Get("If-None-Match"))
+
+	// Wrong generation.
+	badGen := o.Generation + 1
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   "foo",
+			Contents:               strings.NewReader("burrito"),
+			GenerationPrecondition: &badGen,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// Right generation.
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   "foo",
+			Contents:               strings.NewReader("burrito"),
+			GenerationPrecondition: &o.Generation,
+		})
+
+	AssertEq(nil, err)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(contents))
+}
+
+func (t *BucketTest) CreateWithStorageClass() {
+	created, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:         "foo",
+			Contents:     strings.NewReader("taco"),
+			StorageClass: "GLACIER",
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("GLACIER", created.StorageClass)
+	ExpectEq("GLACIER", t.s3.requests[0].Header.Get("X-Amz-Storage-Class"))
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq("GLACIER", o.StorageClass)
+
+	// Large objects too.
+	created, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:         "bar",
+			Contents:     bytes.NewReader(bytes.Repeat([]byte("a"), partSize+1)),
+			StorageClass: "GLACIER",
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("GLACIER", created.StorageClass)
+
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	AssertEq(nil, err)
+	ExpectEq("GLACIER", o.StorageClass)
+}
+
+func (t *BucketTest) LargeObjectsAreUploadedInParts() {
+	contents := bytes.Repeat([]byte("a"), partSize+1)
+
+	var progress []int64
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:         "foo",
+			Contents:     bytes.NewReader(contents),
+			ProgressFunc: func(n int64) { progress = append(progress, n) },
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(len(contents), o.Size)
+	ExpectEq(nil, o.MD5)
+	ExpectEq(0, o.CRC32C)
+	ExpectTrue(strings.HasSuffix(o.Etag, "-2"), "%s", o.Etag)
+	AssertGt(len(progress), 0)
+	ExpectEq(len(contents), progress[len(progress)-1])
+
+	// Initiate, two parts, complete.
+	ExpectEq(4, len(t.s3.requests))
+
+	s, err := t.read(&gcs.ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectTrue(s == string(contents))
+
+	// Checksums can't be verified.
+	_, err = t.read(&gcs.ReadObjectRequest{Name: "foo", VerifyChecksums: true})
+	ExpectThat(err, Error(HasSubstr("no CRC32C")))
+}
+
+func (t *BucketTest) ReadRanges() {
+	t.create("foo", "taco")
+
+	s, err := t.read(&gcs.ReadObjectRequest{
+		Name:  "foo",
+		Range: &gcs.ByteRange{Start: 1, Limit: 3},
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("ac", s)
+
+	// Empty.
+	s, err = t.read(&gcs.ReadObjectRequest{
+		Name:  "foo",
+		Range: &gcs.ByteRange{Start: 2, Limit: 2},
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("", s)
+
+	// Past the end.
+	s, err = t.read(&gcs.ReadObjectRequest{
+		Name:  "foo",
+		Range: &gcs.ByteRange{Start: 10, Limit: 20},
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("", s)
+
+	// Overlapping the end.
+	s, err = t.read(&gcs.ReadObjectRequest{
+		Name:  "foo",
+		Range: &gcs.ByteRange{Start: 2, Limit: 20},
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("co", s)
+}
+
+func (t *BucketTest) ReadGenerationsAndPreconditions() {
+	o := t.create("foo", "taco")
+
+	// Verified read of the right generation.
+	s, err := t.read(&gcs.ReadObjectRequest{
+		Name:            "foo",
+		Generation:      o.Generation,
+		VerifyChecksums: true,
+	})
+
+	AssertEq(nil, err)
+	ExpectEq("taco", s)
+
+	// Wrong generation.
+	_, err = t.read(&gcs.ReadObjectRequest{
+		Name:       "foo",
+		Generation: o.Generation + 1,
+	})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	// Stale meta-generation.
+	metaGen := o.MetaGeneration + 1
+	_, err = t.read(&gcs.ReadObjectRequest{
+		Name:                       "foo",
+		MetaGenerationPrecondition: &metaGen,
+	})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// Nonexistent.
+	_, err = t.read(&gcs.ReadObjectRequest{Name: "bar"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *BucketTest) UpdateObject() {
+	o := t.create("foo", "taco")
+
+	contentType := "image/png"
+	newValue := "qux"
+	updated, err := t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:        "foo",
+			ContentType: &contentType,
+			Metadata:    map[string]*string{"bar": &newValue},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, updated.Generation)
+	ExpectEq(2, updated.MetaGeneration)
+	ExpectEq("image/png", updated.ContentType)
+
+	stat, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, stat.Generation)
+	ExpectEq(2, stat.MetaGeneration)
+	ExpectEq("image/png", stat.ContentType)
+	ExpectThat(stat.Metadata, DeepEquals(map[string]string{"bar": "qux"}))
+	ExpectEq(o.CRC32C, stat.CRC32C)
+
+	// A stale meta-generation precondition should now fail.
+	_, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:                       "foo",
+			ContentType:                &contentType,
+			MetaGenerationPrecondition: &o.MetaGeneration,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// Holds aren't supported.
+	hold := true
+	_, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{Name: "foo", TemporaryHold: &hold})
+
+	ExpectThat(err, Error(HasSubstr("not supported")))
+}
+
+func (t *BucketTest) CopyAndMove() {
+	src := t.create("foo", "taco")
+
+	copied, err := t.bucket.CopyObject(
+		t.ctx,
+		&gcs.CopyObjectRequest{SrcName: "foo", DstName: "bar"})
+
+	AssertEq(nil, err)
+	ExpectEq("bar", copied.Name)
+	ExpectGt(copied.Generation, src.Generation)
+	ExpectEq(src.CRC32C, copied.CRC32C)
+
+	moved, err := t.bucket.MoveObject(
+		t.ctx,
+		&gcs.MoveObjectRequest{SrcName: "bar", DstName: "baz"})
+
+	AssertEq(nil, err)
+	ExpectEq("baz", moved.Name)
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "baz")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	// Copying a nonexistent object should fail.
+	_, err = t.bucket.CopyObject(
+		t.ctx,
+		&gcs.CopyObjectRequest{SrcName: "qux", DstName: "bar"})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *BucketTest) ComposeObjects() {
+	t.create("foo", "taco")
+	t.create("bar", "burrito")
+
+	o, err := t.bucket.ComposeObjects(
+		t.ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName: "baz",
+			Sources: []gcs.ComposeSource{
+				{Name: "foo"},
+				{Name: "bar"},
+				{Name: "foo"},
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(3, o.ComponentCount)
+	ExpectEq(nil, o.MD5)
+	ExpectEq(len("tacoburritotaco"), o.Size)
+
+	stat, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "baz"})
+	AssertEq(nil, err)
+	ExpectEq(3, stat.ComponentCount)
+	ExpectEq(nil, stat.MD5)
+	ExpectEq(o.CRC32C, stat.CRC32C)
+
+	s, err := t.read(&gcs.ReadObjectRequest{Name: "baz", VerifyChecksums: true})
+	AssertEq(nil, err)
+	ExpectEq("tacoburritotaco", s)
+}
+
+func (t *BucketTest) DeleteObject() {
+	o := t.create("foo", "taco")
+
+	// Nonexistent objects are fine.
+	err := t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "bar"})
+	ExpectEq(nil, err)
+
+	// Wrong meta-generation.
+	badMetaGen := o.MetaGeneration + 1
+	err = t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{
+			Name:                       "foo",
+			MetaGenerationPrecondition: &badMetaGen,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// Success.
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *BucketTest) ListObjects() {
+	t.create("a", "")
+	t.create("b/0", "")
+	t.create("b/1", "")
+	t.create("c", "taco")
+
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{Delimiter: "/"})
+
+	AssertEq(nil, err)
+	AssertEq(2, len(listing.Objects))
+	ExpectEq("a", listing.Objects[0].Name)
+	ExpectEq("c", listing.Objects[1].Name)
+	ExpectEq(4, listing.Objects[1].Size)
+	ExpectThat(listing.CollapsedRuns, ElementsAre("b/"))
+	ExpectEq("", listing.ContinuationToken)
+
+	// Paginate.
+	objects, runs, err := gcsutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{Prefix: "b/", MaxResults: 1})
+
+	AssertEq(nil, err)
+	ExpectThat(runs, ElementsAre())
+	AssertEq(2, len(objects))
+	ExpectEq("b/0", objects[0].Name)
+	ExpectEq("b/1", objects[1].Name)
+}
+
+func (t *BucketTest) ListObjects_OffsetsAndGlob() {
+	t.create("a/0.txt", "")
+	t.create("b/0.txt", "")
+	t.create("b/1.json", "")
+	t.create("b/2.txt", "")
+	t.create("c/0.txt", "")
+
+	// Offsets and a glob, paginated.
+	objects, runs, err := gcsutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{
+			StartOffset: "b/0.txt",
+			EndOffset:   "c",
+			MatchGlob:   "**.txt",
+			MaxResults:  1,
+		})
+
+	AssertEq(nil, err)
+	ExpectThat(runs, ElementsAre())
+	AssertEq(2, len(objects))
+	ExpectEq("b/0.txt", objects[0].Name)
+	ExpectEq("b/2.txt", objects[1].Name)
+
+	// Offsets with a delimiter.
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{
+			Delimiter:   "/",
+			StartOffset: "b/1",
+			EndOffset:   "c/",
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(0, len(listing.Objects))
+	ExpectThat(listing.CollapsedRuns, ElementsAre("b/"))
+
+	// Placeholders for runs.
+	t.create("b/", "")
+
+	listing, err = t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{
+			Delimiter:                "/",
+			IncludeTrailingDelimiter: true,
+		})
+
+	AssertEq(nil, err)
+	ExpectThat(listing.CollapsedRuns, ElementsAre("a/", "b/", "c/"))
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("b/", listing.Objects[0].Name)
+
+	// A glob with a delimiter can't be emulated.
+	_, err = t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{Delimiter: "/", MatchGlob: "*"})
+
+	ExpectThat(err, Error(HasSubstr("MatchGlob")))
+}
+
+func (t *BucketTest) Batch() {
+	t.create("foo", "taco")
+
+	results, err := t.bucket.Batch(
+		t.ctx,
+		&gcs.BatchRequest{
+			Ops: []gcs.BatchOp{
+				{Stat: &gcs.StatObjectRequest{Name: "foo"}},
+				{Delete: &gcs.DeleteObjectRequest{Name: "foo"}},
+				{Stat: &gcs.StatObjectRequest{Name: "foo"}},
+			},
+		})
+
+	AssertEq(nil, err)
+	AssertEq(3, len(results))
+	ExpectEq(nil, results[0].Err)
+	ExpectEq("foo", results[0].Object.Name)
+	ExpectEq(nil, results[1].Err)
+	ExpectThat(results[2].Err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *BucketTest) SignedURL() {
+	signed, err := t.bucket.SignedURL(
+		t.ctx,
+		&gcs.SignedURLRequest{
+			Name:   "foo bar",
+			Method: "GET",
+			Expiry: time.Hour,
+		})
+
+	AssertEq(nil, err)
+	ExpectTrue(strings.HasPrefix(signed, t.server.URL+"/some_bucket/foo%20bar?"), "%s", signed)
+	ExpectThat(signed, HasSubstr("X-Amz-Signature="))
+
+	_, err = t.bucket.SignedURL(
+		t.ctx,
+		&gcs.SignedURLRequest{Name: "foo", Method: "GET"})
+
+	ExpectThat(err, Error(HasSubstr("expiry")))
+}
+
+func (t *BucketTest) ACLsAreUnsupported() {
+	_, err := t.bucket.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{Name: "foo"})
+
+	ExpectThat(err, Error(HasSubstr("not supported")))
+}
+
+func (t *BucketTest) ErrorsAreTyped() {
+	makeErr := func(status int, code string) error {
+		return wrapError(status, code, &responseError{StatusCode: status, Code: code})
+	}
+
+	ExpectThat(makeErr(404, "NoSuchKey"), HasSameTypeAs(&gcs.NotFoundError{}))
+	ExpectThat(makeErr(412, ""), HasSameTypeAs(&gcs.PreconditionError{}))
+	ExpectThat(makeErr(409, "ConditionalRequestConflict"), HasSameTypeAs(&gcs.PreconditionError{}))
+	ExpectThat(makeErr(403, ""), HasSameTypeAs(&gcs.ForbiddenError{}))
+	ExpectThat(makeErr(503, "SlowDown"), HasSameTypeAs(&gcs.RateLimitError{}))
+	ExpectThat(makeErr(400, "InvalidArgument"), HasSameTypeAs(&responseError{}))
+
+	// Real responses are mapped, too.
+	_, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
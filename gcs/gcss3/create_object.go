@@ -0,0 +1,530 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcss3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// The size of the parts in which large objects are uploaded. Objects no
+// larger than this are uploaded with a single request. S3 requires all parts
+// but the last to be at least 5 MiB, and allows at most 10,000 parts.
+const partSize = 16 << 20
+
+// A reader that computes checksums of the contents it reads and reports
+// progress.
+type checksummingReader struct {
+	wrapped   io.Reader
+	crc32c    hash.Hash32
+	md5       hash.Hash
+	progress  func(int64)
+	bytesRead int64
+}
+
+func newChecksummingReader(
+	r io.Reader,
+	progress func(int64)) *checksummingReader {
+	return &checksummingReader{
+		wrapped:  r,
+		crc32c:   crc32.New(crc32cTable),
+		md5:      md5.New(),
+		progress: progress,
+	}
+}
+
+func (cr *checksummingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.wrapped.Read(p)
+	if n > 0 {
+		cr.crc32c.Write(p[:n])
+		cr.md5.Write(p[:n])
+		cr.bytesRead += int64(n)
+		if cr.progress != nil {
+			cr.progress(cr.bytesRead)
+		}
+	}
+
+	return
+}
+
+// Check the checksums of everything read so far against those in the
+// request, if any, filling them in to the supplied object.
+func (cr *checksummingReader) finish(
+	req *gcs.CreateObjectRequest,
+	o *gcs.Object) (err error) {
+	o.CRC32C = cr.crc32c.Sum32()
+	o.MD5 = new([md5.Size]byte)
+	copy(o.MD5[:], cr.md5.Sum(nil))
+
+	if req.CRC32C != nil && o.CRC32C != *req.CRC32C {
+		err = fmt.Errorf(
+			"CRC32C mismatch: got 0x%08x, expected 0x%08x",
+			o.CRC32C,
+			*req.CRC32C)
+
+		return
+	}
+
+	if req.MD5 != nil && *o.MD5 != *req.MD5 {
+		err = fmt.Errorf("MD5 mismatch: got %x, expected %x", *o.MD5, *req.MD5)
+		return
+	}
+
+	return
+}
+
+func (b *bucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	// Compress the contents if requested.
+	if req, err = gcs.CompressCreateObjectRequest(req); err != nil {
+		return
+	}
+
+	o, err = b.createObject(ctx, req, 1)
+	if err == nil && req.DiscardResponse {
+		o = nil
+	}
+
+	return
+}
+
+// Create an object with the given component count, which is greater than
+// one for composite objects.
+func (b *bucket) createObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest,
+	componentCount int64) (o *gcs.Object, err error) {
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	if req.TemporaryHold || req.EventBasedHold {
+		err = errHoldsNotSupported()
+		return
+	}
+
+	// S3's server-side encryption keys live in a different namespace.
+	if req.KMSKeyName != "" {
+		err = errors.New("Cloud KMS keys are not supported by S3-compatible buckets")
+		return
+	}
+
+	if req.PredefinedACL != "" {
+		err = errors.New("ACLs are not supported by S3-compatible buckets")
+		return
+	}
+
+	header, err := b.writePreconditionHeaders(
+		ctx,
+		req.Name,
+		req.GenerationPrecondition,
+		req.MetaGenerationPrecondition)
+
+	if err != nil {
+		return
+	}
+
+	o = &gcs.Object{
+		Name:            req.Name,
+		ContentType:     req.ContentType,
+		ContentLanguage: req.ContentLanguage,
+		CacheControl:    req.CacheControl,
+		ContentEncoding: req.ContentEncoding,
+		MediaLink:       b.objectURL(b.name, req.Name, nil).String(),
+		Metadata:        req.Metadata,
+		Generation:      b.mintGeneration(),
+		MetaGeneration:  1,
+		StorageClass:    "STANDARD",
+		ComponentCount:  componentCount,
+		CustomTime:      req.CustomTime,
+	}
+
+	if req.StorageClass != "" {
+		o.StorageClass = req.StorageClass
+	}
+
+	// Read the first part. If that's everything, we can upload it in a single
+	// request with checksums attached.
+	cr := newChecksummingReader(req.Contents, req.ProgressFunc)
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(cr, buf)
+
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		err = b.putObject(ctx, o, buf[:n], cr, req, header)
+
+	case nil:
+		err = b.uploadParts(ctx, o, buf, cr, req, header)
+
+	default:
+		err = fmt.Errorf("Reading contents: %v", err)
+	}
+
+	if err != nil {
+		o = nil
+		return
+	}
+
+	o.Updated = b.clock.Now()
+	o.Created = createdTime(o.Generation)
+	return
+}
+
+// Upload the supplied contents, which are all of the contents read by cr, in
+// a single request.
+func (b *bucket) putObject(
+	ctx context.Context,
+	o *gcs.Object,
+	contents []byte,
+	cr *checksummingReader,
+	req *gcs.CreateObjectRequest,
+	header http.Header) (err error) {
+	if err = cr.finish(req, o); err != nil {
+		return
+	}
+
+	o.Size = uint64(len(contents))
+
+	// Ask S3 to verify the MD5 hash too.
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(o.MD5[:]))
+
+	// Like GCS, don't expose an MD5 hash for composite objects.
+	if o.ComponentCount > 1 {
+		o.MD5 = nil
+	}
+
+	setObjectHeaders(header, o, true)
+
+	if o.StorageClass != "STANDARD" {
+		header.Set("X-Amz-Storage-Class", o.StorageClass)
+	}
+
+	httpRes, err := b.send(
+		ctx,
+		"PUT",
+		b.name,
+		o.Name,
+		nil,
+		header,
+		bytes.NewReader(contents),
+		int64(len(contents)))
+
+	if err != nil {
+		return
+	}
+
+	httpRes.Body.Close()
+	o.Etag = strings.Trim(httpRes.Header.Get("ETag"), `"`)
+	return
+}
+
+// Requests and responses for multipart uploads.
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name
+	ETag    string `xml:"ETag"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// Upload the contents read by cr using a multipart upload, starting with the
+// supplied first part. Because we don't know the checksums until the end,
+// the object's CRC32C isn't recorded and it has no MD5 hash.
+func (b *bucket) uploadParts(
+	ctx context.Context,
+	o *gcs.Object,
+	firstPart []byte,
+	cr *checksummingReader,
+	req *gcs.CreateObjectRequest,
+	header http.Header) (err error) {
+	// Start the upload. The conditional headers belong on the request that
+	// completes it.
+	initHeader := make(http.Header)
+	setObjectHeaders(initHeader, o, false)
+
+	if o.StorageClass != "STANDARD" {
+		initHeader.Set("X-Amz-Storage-Class", o.StorageClass)
+	}
+
+	httpRes, err := b.send(
+		ctx,
+		"POST",
+		b.name,
+		o.Name,
+		url.Values{"uploads": {""}},
+		initHeader,
+		nil,
+		0)
+
+	if err != nil {
+		err = fmt.Errorf("Initiating multipart upload: %v", err)
+		return
+	}
+
+	var initiated initiateMultipartUploadResult
+	err = xml.NewDecoder(httpRes.Body).Decode(&initiated)
+	httpRes.Body.Close()
+	if err != nil {
+		err = fmt.Errorf("Decoding multipart upload: %v", err)
+		return
+	}
+
+	uploadQuery := url.Values{"uploadId": {initiated.UploadID}}
+
+	// Abort the upload if anything goes wrong, so that S3 doesn't keep the
+	// parts around.
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		httpRes, abortErr := b.send(ctx, "DELETE", b.name, o.Name, uploadQuery, nil, nil, 0)
+		if abortErr == nil {
+			httpRes.Body.Close()
+		}
+	}()
+
+	// Upload each part.
+	var complete completeMultipartUpload
+	part := firstPart
+	for partNumber := 1; len(part) > 0; partNumber++ {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(partNumber)},
+			"uploadId":   {initiated.UploadID},
+		}
+
+		httpRes, err = b.send(
+			ctx,
+			"PUT",
+			b.name,
+			o.Name,
+			query,
+			nil,
+			bytes.NewReader(part),
+			int64(len(part)))
+
+		if err != nil {
+			err = fmt.Errorf("Uploading part %d: %v", partNumber, err)
+			return
+		}
+
+		httpRes.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{
+			PartNumber: partNumber,
+			ETag:       httpRes.Header.Get("ETag"),
+		})
+
+		o.Size += uint64(len(part))
+
+		// Read the next part.
+		var n int
+		n, err = io.ReadFull(cr, firstPart)
+		switch err {
+		case nil, io.EOF, io.ErrUnexpectedEOF:
+			err = nil
+			part = firstPart[:n]
+
+		default:
+			err = fmt.Errorf("Reading contents: %v", err)
+			return
+		}
+	}
+
+	// Check the checksums before making the object visible.
+	if err = cr.finish(req, o); err != nil {
+		return
+	}
+
+	// Complete the upload.
+	body, err := xml.Marshal(&complete)
+	if err != nil {
+		err = fmt.Errorf("Marshal: %v", err)
+		return
+	}
+
+	httpRes, err = b.send(
+		ctx,
+		"POST",
+		b.name,
+		o.Name,
+		uploadQuery,
+		header,
+		bytes.NewReader(body),
+		int64(len(body)))
+
+	if err != nil {
+		err = fmt.Errorf("Completing multipart upload: %v", err)
+		return
+	}
+
+	defer httpRes.Body.Close()
+
+	// S3 may report errors with status 200 when completing uploads.
+	var result completeMultipartUploadResult
+	if err = xml.NewDecoder(httpRes.Body).Decode(&result); err != nil {
+		err = fmt.Errorf("Decoding completion result: %v", err)
+		return
+	}
+
+	if result.XMLName.Local == "Error" {
+		err = wrapError(
+			0,
+			result.Code,
+			fmt.Errorf("Completing multipart upload: %s %s", result.Code, result.Message))
+
+		return
+	}
+
+	// We couldn't record checksums for the object.
+	o.CRC32C = 0
+	o.MD5 = nil
+	o.Etag = strings.Trim(result.ETag, `"`)
+	return
+}
+
+// A reader for the concatenation of the contents of several objects, each
+// opened only when the previous one is exhausted.
+type composeReader struct {
+	ctx     context.Context
+	b       *bucket
+	sources []s3Object
+	current io.ReadCloser
+}
+
+func (cr *composeReader) Read(p []byte) (n int, err error) {
+	for {
+		if cr.current == nil {
+			if len(cr.sources) == 0 {
+				err = io.EOF
+				return
+			}
+
+			// Make sure the source hasn't changed since we looked it up.
+			src := cr.sources[0]
+			cr.sources = cr.sources[1:]
+
+			header := make(http.Header)
+			header.Set("If-Match", src.etag)
+
+			var httpRes *http.Response
+			httpRes, err = cr.b.send(cr.ctx, "GET", cr.b.name, src.Name, nil, header, nil, 0)
+			if err != nil {
+				err = fmt.Errorf("Reading source %q: %v", src.Name, err)
+				return
+			}
+
+			cr.current = httpRes.Body
+		}
+
+		n, err = cr.current.Read(p)
+		if err == io.EOF {
+			cr.current.Close()
+			cr.current = nil
+			err = nil
+		}
+
+		if n > 0 || err != nil {
+			return
+		}
+	}
+}
+
+func (cr *composeReader) Close() {
+	if cr.current != nil {
+		cr.current.Close()
+	}
+}
+
+func (b *bucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	// GCS doesn't like too few or too many sources.
+	if len(req.Sources) < 1 {
+		err = errors.New("You must provide at least one source component")
+		return
+	}
+
+	if len(req.Sources) > gcs.MaxSourcesPerComposeRequest {
+		err = errors.New("You have provided too many source components")
+		return
+	}
+
+	// Look up each source, also computing the sum of their component counts.
+	cr := &composeReader{ctx: ctx, b: b}
+	defer cr.Close()
+
+	var componentCount int64
+	for _, src := range req.Sources {
+		var so s3Object
+		so, _, err = b.find(ctx, src.Name, src.Generation)
+		if err != nil {
+			return
+		}
+
+		cr.sources = append(cr.sources, so)
+		componentCount += so.ComponentCount
+	}
+
+	if componentCount > gcs.MaxComponentCount {
+		err = errors.New("Result would have too many components")
+		return
+	}
+
+	// S3 can only concatenate parts of at least 5 MiB on the server side, so
+	// stream the sources through.
+	o, err = b.createObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:                       req.DstName,
+			GenerationPrecondition:     req.DstGenerationPrecondition,
+			MetaGenerationPrecondition: req.DstMetaGenerationPrecondition,
+			Contents:                   cr,
+			ContentType:                req.ContentType,
+			Metadata:                   req.Metadata,
+		},
+		componentCount)
+
+	return
+}
@@ -0,0 +1,38 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcss3 contains an implementation of gcs.Bucket that talks to
+// S3-compatible services such as AWS S3 and MinIO using the S3 XML API, so
+// that code written against gcs.Bucket can run outside of GCP.
+//
+// S3 has no notion of generations, so this package tracks generation and
+// meta-generation numbers, component counts, and checksums in reserved
+// user metadata keys with the prefix "goog-", and emulates preconditions by
+// checking them against the current object and then conditioning the
+// mutation on its ETag. Objects written by other S3 clients are given a
+// generation number derived from their modification time.
+//
+// The following are not supported:
+//
+//   - Object holds and ACLs.
+//   - Object versioning, and generation numbers in listings.
+//   - Updating the metadata of objects larger than 5 GiB, which S3 can't
+//     copy in a single request.
+//   - IdleTimeout on reads.
+//   - Checksums for objects larger than 16 MiB, which are uploaded in parts.
+//     Such objects have no MD5 hash and a zero CRC32C, and can't be read
+//     with VerifyChecksums.
+//
+// S3 lower-cases user metadata keys, so keys should be lower case.
+package gcss3
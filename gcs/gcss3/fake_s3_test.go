@@ -0,0 +1,341 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcss3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal in-memory implementation of the subset of the S3 XML API used by
+// this package, for tests. It checks only that requests are signed, not that
+// the signatures are correct.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]*fakeS3Object // Keyed by "<bucket>/<key>"
+	uploads map[string]*fakeS3Upload // Keyed by upload ID
+
+	nextID int
+	now    time.Time
+
+	// Every request received, in order.
+	requests []*http.Request
+}
+
+type fakeS3Object struct {
+	data   []byte
+	header http.Header // Content-*, Cache-Control, and X-Amz-Meta-*
+	etag   string
+	mtime  time.Time
+}
+
+type fakeS3Upload struct {
+	key    string
+	header http.Header
+	parts  map[int][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: make(map[string]*fakeS3Object),
+		uploads: make(map[string]*fakeS3Upload),
+		now:     time.Date(2012, 8, 15, 22, 56, 0, 0, time.UTC),
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// Copy the headers that S3 stores with an object.
+func storedHeaders(in http.Header) (out http.Header) {
+	out = make(http.Header)
+	for k, vs := range in {
+		switch {
+		case strings.HasPrefix(k, "Content-") && k != "Content-Length" && k != "Content-Md5",
+			k == "Cache-Control",
+			k == "X-Amz-Storage-Class",
+			strings.HasPrefix(k, metadataHeaderPrefix):
+			out[k] = vs
+		}
+	}
+
+	return
+}
+
+// Check If-Match and If-None-Match headers against the supplied object,
+// which may be nil.
+func checkConditions(
+	w http.ResponseWriter,
+	h http.Header,
+	prefix string,
+	o *fakeS3Object) bool {
+	if v := h.Get(prefix + "If-Match"); v != "" && (o == nil || o.etag != v) {
+		writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return false
+	}
+
+	if h.Get(prefix+"If-None-Match") == "*" && o != nil {
+		writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return false
+	}
+
+	return true
+}
+
+// Store an object, returning the new record.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *fakeS3) put(path string, data []byte, header http.Header) *fakeS3Object {
+	sum := md5.Sum(data)
+	f.now = f.now.Add(time.Second)
+
+	o := &fakeS3Object{
+		data:   data,
+		header: header,
+		etag:   `"` + hex.EncodeToString(sum[:]) + `"`,
+		mtime:  f.now,
+	}
+
+	f.objects[path] = o
+	return o
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r)
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), signingAlgorithm) {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	// Split the path into bucket and key.
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucketName := path
+	var key string
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucketName = path[:i]
+		key = path[i+1:]
+	}
+
+	query := r.URL.Query()
+	body, _ := ioutil.ReadAll(r.Body)
+	o := f.objects[path]
+
+	switch {
+	case key == "" && r.Method == "GET":
+		f.list(w, bucketName, query)
+
+	case r.Method == "POST" && query["uploads"] != nil:
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = &fakeS3Upload{
+			key:    path,
+			header: storedHeaders(r.Header),
+			parts:  make(map[int][]byte),
+		}
+
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+
+	case r.Method == "PUT" && query.Get("uploadId") != "":
+		u := f.uploads[query.Get("uploadId")]
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		u.parts[n] = body
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+
+	case r.Method == "POST" && query.Get("uploadId") != "":
+		u := f.uploads[query.Get("uploadId")]
+		if !checkConditions(w, r.Header, "", o) {
+			return
+		}
+
+		var complete completeMultipartUpload
+		xml.Unmarshal(body, &complete)
+
+		var data []byte
+		for _, p := range complete.Parts {
+			data = append(data, u.parts[p.PartNumber]...)
+		}
+
+		delete(f.uploads, query.Get("uploadId"))
+		stored := f.put(path, data, u.header)
+		stored.etag = fmt.Sprintf(`"%x-%d"`, md5.Sum(data), len(complete.Parts))
+		fmt.Fprintf(
+			w,
+			"<CompleteMultipartUploadResult><ETag>%s</ETag></CompleteMultipartUploadResult>",
+			stored.etag)
+
+	case r.Method == "DELETE" && query.Get("uploadId") != "":
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		srcPath, _ := url.PathUnescape(
+			strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+
+		src := f.objects[srcPath]
+		if src == nil {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		if !checkConditions(w, r.Header, "X-Amz-Copy-Source-", src) ||
+			!checkConditions(w, r.Header, "", o) {
+			return
+		}
+
+		stored := f.put(path, src.data, storedHeaders(r.Header))
+		fmt.Fprintf(
+			w,
+			"<CopyObjectResult><LastModified>%s</LastModified><ETag>%s</ETag></CopyObjectResult>",
+			stored.mtime.Format(time.RFC3339),
+			stored.etag)
+
+	case r.Method == "PUT":
+		if !checkConditions(w, r.Header, "", o) {
+			return
+		}
+
+		if v := r.Header.Get("Content-Md5"); v != "" {
+			sum := md5.Sum(body)
+			if v != base64.StdEncoding.EncodeToString(sum[:]) {
+				writeS3Error(w, http.StatusBadRequest, "BadDigest")
+				return
+			}
+		}
+
+		stored := f.put(path, body, storedHeaders(r.Header))
+		w.Header().Set("ETag", stored.etag)
+
+	case r.Method == "HEAD" || r.Method == "GET":
+		if o == nil {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		if !checkConditions(w, r.Header, "", o) {
+			return
+		}
+
+		for k, vs := range o.header {
+			w.Header()[k] = vs
+		}
+
+		w.Header().Set("ETag", o.etag)
+		w.Header().Set("Last-Modified", o.mtime.Format(http.TimeFormat))
+
+		// Hand off ranges to net/http.
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(o.data))
+
+	case r.Method == "DELETE":
+		delete(f.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// LOCKS_REQUIRED(f.mu)
+func (f *fakeS3) list(w http.ResponseWriter, bucketName string, query url.Values) {
+	prefix := bucketName + "/" + query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	start := query.Get("continuation-token")
+	if after := query.Get("start-after"); after > start {
+		start = after
+	}
+
+	maxKeys := 1000
+	if v := query.Get("max-keys"); v != "" {
+		maxKeys, _ = strconv.Atoi(v)
+	}
+
+	var paths []string
+	for p := range f.objects {
+		if strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+	}
+
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	buf.WriteString("<ListBucketResult>")
+
+	var lastPrefix string
+	count := 0
+	for _, p := range paths {
+		key := strings.TrimPrefix(p, bucketName+"/")
+		if key <= start {
+			continue
+		}
+
+		if count == maxKeys {
+			fmt.Fprintf(
+				&buf,
+				"<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>",
+				start)
+			break
+		}
+
+		if delimiter != "" {
+			rest := strings.TrimPrefix(p, prefix)
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				run := key[:len(key)-len(rest)+i+len(delimiter)]
+				if run != lastPrefix {
+					fmt.Fprintf(&buf, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", run)
+					lastPrefix = run
+					count++
+				}
+
+				start = key
+				continue
+			}
+		}
+
+		o := f.objects[p]
+		fmt.Fprintf(
+			&buf,
+			"<Contents><Key>%s</Key><LastModified>%s</LastModified>"+
+				"<ETag>%s</ETag><Size>%d</Size><StorageClass>STANDARD</StorageClass></Contents>",
+			key,
+			o.mtime.Format(time.RFC3339),
+			o.etag,
+			len(o.data))
+
+		start = key
+		count++
+	}
+
+	buf.WriteString("</ListBucketResult>")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,205 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcss3
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// The response to a ListObjectsV2 request. See here for details:
+//
+//	https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+type listBucketResult struct {
+	Contents []struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         uint64 `xml:"Size"`
+		StorageClass string `xml:"StorageClass"`
+	} `xml:"Contents"`
+
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List the objects in the bucket. S3 listings don't include user metadata, so
+// the objects returned have only their name, size, modification time,
+// storage class, and MD5 hash (where S3 knows it) filled in. In particular
+// their generation numbers are zero; use StatObject to find the rest.
+func (b *bucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	// S3 versioning doesn't map onto generations, so req.Versions is ignored.
+	// Nor does S3 have soft delete, so there are no soft-deleted objects.
+	if req.SoftDeleted {
+		listing = new(gcs.Listing)
+		return
+	}
+
+	// S3 has no notion of glob matching or of an end offset, so we emulate
+	// those (and the inclusive start offset) by filtering its results. That
+	// can't be done for collapsed runs under a glob, since S3 doesn't tell us
+	// which objects they contain.
+	if req.MatchGlob != "" && req.Delimiter != "" {
+		err = fmt.Errorf("S3 listings don't support MatchGlob with a Delimiter")
+		return
+	}
+
+	var glob *gcs.Glob
+	if req.MatchGlob != "" {
+		if glob, err = gcs.CompileGlob(req.MatchGlob); err != nil {
+			err = fmt.Errorf("CompileGlob: %v", err)
+			return
+		}
+	}
+
+	query := url.Values{
+		"list-type": {"2"},
+	}
+
+	if req.Prefix != "" {
+		query.Set("prefix", req.Prefix)
+	}
+
+	if req.Delimiter != "" {
+		query.Set("delimiter", req.Delimiter)
+	}
+
+	if req.ContinuationToken != "" {
+		query.Set("continuation-token", req.ContinuationToken)
+	}
+
+	if req.MaxResults != 0 {
+		query.Set("max-keys", strconv.Itoa(req.MaxResults))
+	}
+
+	// S3's start-after is exclusive. Every name at least StartOffset is greater
+	// than StartOffset with its final byte removed, so start there and filter
+	// out anything left over below.
+	if len(req.StartOffset) > 1 {
+		query.Set("start-after", req.StartOffset[:len(req.StartOffset)-1])
+	}
+
+	httpRes, err := b.send(ctx, "GET", b.name, "", query, nil, nil, 0)
+	if err != nil {
+		return
+	}
+
+	defer httpRes.Body.Close()
+
+	var result listBucketResult
+	if err = xml.NewDecoder(httpRes.Body).Decode(&result); err != nil {
+		err = fmt.Errorf("Decoding listing: %v", err)
+		return
+	}
+
+	listing = new(gcs.Listing)
+
+	// Results are in order, so once we see a name at or after the end offset
+	// there's no point in asking for more.
+	var pastEnd bool
+
+	for _, c := range result.Contents {
+		if c.Key < req.StartOffset {
+			continue
+		}
+
+		if req.EndOffset != "" && c.Key >= req.EndOffset {
+			pastEnd = true
+			continue
+		}
+
+		if glob != nil && !glob.Match(c.Key) {
+			continue
+		}
+
+		o := &gcs.Object{
+			Name:           c.Key,
+			Size:           c.Size,
+			StorageClass:   c.StorageClass,
+			MediaLink:      b.objectURL(b.name, c.Key, nil).String(),
+			MD5:            decodeMD5(strings.Trim(c.ETag, `"`), hex.DecodeString),
+			ComponentCount: 1,
+		}
+
+		if o.Updated, err = time.Parse(time.RFC3339, c.LastModified); err != nil {
+			err = fmt.Errorf("Parsing LastModified for %q: %v", c.Key, err)
+			return
+		}
+
+		listing.Objects = append(listing.Objects, o)
+	}
+
+	for _, p := range result.CommonPrefixes {
+		// A run that sorts before the start offset without being a prefix of it
+		// contains only names before it too. Similarly for runs at or after the
+		// end offset.
+		if p.Prefix < req.StartOffset && !strings.HasPrefix(req.StartOffset, p.Prefix) {
+			continue
+		}
+
+		if req.EndOffset != "" && p.Prefix >= req.EndOffset {
+			pastEnd = true
+			continue
+		}
+
+		listing.CollapsedRuns = append(listing.CollapsedRuns, p.Prefix)
+	}
+
+	// S3 has no equivalent of IncludeTrailingDelimiter, so look for placeholder
+	// objects named for each run ourselves.
+	if req.IncludeTrailingDelimiter && len(listing.CollapsedRuns) != 0 {
+		for _, run := range listing.CollapsedRuns {
+			var o *gcs.Object
+			o, err = b.StatObject(ctx, &gcs.StatObjectRequest{Name: run})
+
+			if _, ok := err.(*gcs.NotFoundError); ok {
+				err = nil
+				continue
+			}
+
+			if err != nil {
+				err = fmt.Errorf("StatObject(%q): %v", run, err)
+				return
+			}
+
+			listing.Objects = append(listing.Objects, o)
+		}
+
+		sort.Slice(listing.Objects, func(i, j int) bool {
+			return listing.Objects[i].Name < listing.Objects[j].Name
+		})
+	}
+
+	if result.IsTruncated && !pastEnd {
+		listing.ContinuationToken = result.NextContinuationToken
+	}
+
+	return
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcss3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// A gcs.ReadSeekCloser for a response body, which doesn't support seeking.
+type objectReader struct {
+	io.ReadCloser
+
+	// If non-nil, called with the number of bytes read so far.
+	progress  func(int64)
+	bytesRead int64
+}
+
+func (r *objectReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	if n > 0 && r.progress != nil {
+		r.bytesRead += int64(n)
+		r.progress(r.bytesRead)
+	}
+
+	return
+}
+
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("Seeking is not supported")
+}
+
+func (b *bucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc gcs.ReadSeekCloser, err error) {
+	if err = gcs.CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	// We can only verify checksums for the full contents.
+	if req.VerifyChecksums && req.Range != nil {
+		err = errors.New("VerifyChecksums may not be combined with Range")
+		return
+	}
+
+	// Special case: S3 can't express an empty range, so check the object and
+	// its preconditions and then return an empty reader.
+	if req.Range != nil && req.Range.Limit <= req.Range.Start {
+		var o s3Object
+		o, _, err = b.find(ctx, req.Name, req.Generation)
+		if err != nil {
+			return
+		}
+
+		err = checkPreconditions(
+			o.Object,
+			req.GenerationPrecondition,
+			req.MetaGenerationPrecondition)
+
+		if err != nil {
+			return
+		}
+
+		rc = &objectReader{ReadCloser: ioutil.NopCloser(strings.NewReader(""))}
+		if req.ContentEncodingFunc != nil {
+			req.ContentEncodingFunc("")
+		}
+
+		return
+	}
+
+	// S3 never transcodes. Setting Accept-Encoding ourselves stops the HTTP
+	// client from transparently decompressing, so contents are always returned
+	// as stored.
+	header := make(http.Header)
+	header.Set("Accept-Encoding", "gzip")
+
+	if req.Range != nil {
+		header.Set(
+			"Range",
+			fmt.Sprintf("bytes=%d-%d", req.Range.Start, req.Range.Limit-1))
+	}
+
+	httpRes, err := b.send(ctx, "GET", b.name, req.Name, nil, header, nil, 0)
+
+	// Special case: like GCS, S3 refuses ranges that start past the end of the
+	// object, but we treat them as empty.
+	if typed, ok := err.(*responseError); ok && req.Range != nil &&
+		typed.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		err = nil
+		rc = &objectReader{ReadCloser: ioutil.NopCloser(strings.NewReader(""))}
+		if req.ContentEncodingFunc != nil {
+			req.ContentEncodingFunc("")
+		}
+
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	// Make sure we close the body if we don't hand it off.
+	defer func() {
+		if err != nil {
+			httpRes.Body.Close()
+		}
+	}()
+
+	// Check the generation and preconditions against what we actually got.
+	o, err := b.objectFromHeaders(req.Name, httpRes.Header)
+	if err != nil {
+		return
+	}
+
+	if req.Generation != 0 && o.Generation != req.Generation {
+		err = &gcs.NotFoundError{
+			Err: fmt.Errorf(
+				"Object %q generation %d not found", req.Name, req.Generation),
+		}
+
+		return
+	}
+
+	err = checkPreconditions(
+		o.Object,
+		req.GenerationPrecondition,
+		req.MetaGenerationPrecondition)
+
+	if err != nil {
+		return
+	}
+
+	// req.IdleTimeout is not supported.
+	rc = &objectReader{
+		ReadCloser: httpRes.Body,
+		progress:   req.ProgressFunc,
+	}
+
+	if req.VerifyChecksums {
+		if !hasCRC32C(httpRes.Header) {
+			err = fmt.Errorf("Object %q has no CRC32C checksum to verify", req.Name)
+			return
+		}
+
+		rc = gcs.NewVerifyingReader(rc, o.Object)
+	}
+
+	if req.ContentEncodingFunc != nil {
+		req.ContentEncodingFunc(o.ContentEncoding)
+	}
+
+	return
+}
@@ -0,0 +1,269 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcss3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Constants for AWS Signature Version 4. See here for details:
+//
+//	https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv.html
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	signingService   = "s3"
+	amzDateFormat    = "20060102T150405Z"
+	scopeDateFormat  = "20060102"
+
+	// The payload hash used when the body isn't signed.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// The SHA-256 hash of the empty string.
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// Credentials and scope used to sign requests.
+type signer struct {
+	region          string
+	service         string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// Percent-encode the supplied string as required by Signature Version 4,
+// leaving only unreserved characters alone. If keepSlashes is set, '/'
+// characters are also left alone, as is appropriate for paths.
+func uriEncode(s string, keepSlashes bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z',
+			'a' <= c && c <= 'z',
+			'0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			buf.WriteByte(c)
+
+		case c == '/' && keepSlashes:
+			buf.WriteByte(c)
+
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+
+	return buf.String()
+}
+
+// Encode the supplied query parameters in the canonical form, sorted by key
+// and then value. Using this for the URL itself ensures that what we sign is
+// exactly what we send.
+func encodeQuery(query url.Values) string {
+	var pairs []string
+	for k, vs := range query {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// Return the path of the supplied URL, percent-encoded exactly as it will be
+// sent.
+func canonicalURI(u *url.URL) string {
+	if u.Opaque != "" {
+		return strings.TrimPrefix(u.Opaque, "//"+u.Host)
+	}
+
+	if p := u.EscapedPath(); p != "" {
+		return p
+	}
+
+	return "/"
+}
+
+// Should the named header be covered by the signature? We sign the headers
+// that S3 requires to be signed when present, and leave alone those that
+// proxies may legitimately rewrite.
+func shouldSignHeader(name string) bool {
+	name = strings.ToLower(name)
+	return name == "content-type" ||
+		name == "content-md5" ||
+		strings.HasPrefix(name, "x-amz-")
+}
+
+// Return the canonical headers block and the signed headers list for the
+// supplied request.
+func canonicalHeaders(req *http.Request) (canonical string, signed string) {
+	values := map[string]string{
+		"host": req.URL.Host,
+	}
+
+	for k, vs := range req.Header {
+		if !shouldSignHeader(k) {
+			continue
+		}
+
+		var trimmed []string
+		for _, v := range vs {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+
+		values[strings.ToLower(k)] = strings.Join(trimmed, ",")
+	}
+
+	var names []string
+	for k := range values {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	var buf strings.Builder
+	for _, k := range names {
+		buf.WriteString(k + ":" + values[k] + "\n")
+	}
+
+	canonical = buf.String()
+	signed = strings.Join(names, ";")
+	return
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *signer) credentialScope(t time.Time) string {
+	return strings.Join(
+		[]string{t.Format(scopeDateFormat), s.region, s.service, "aws4_request"},
+		"/")
+}
+
+// Compute the signature over the supplied canonical request.
+func (s *signer) signature(t time.Time, canonicalRequest string) string {
+	stringToSign := strings.Join(
+		[]string{
+			signingAlgorithm,
+			t.Format(amzDateFormat),
+			s.credentialScope(t),
+			sha256Hex(canonicalRequest),
+		},
+		"\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), t.Format(scopeDateFormat))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// Sign the supplied request in place by setting its X-Amz-Date and
+// Authorization headers. payloadHash must be the hex-encoded SHA-256 hash of
+// the body or unsignedPayload; the caller is responsible for also sending it
+// in an X-Amz-Content-Sha256 header if the service requires one.
+func (s *signer) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers, signedHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join(
+		[]string{
+			req.Method,
+			canonicalURI(req.URL),
+			encodeQuery(req.URL.Query()),
+			headers,
+			signedHeaders,
+			payloadHash,
+		},
+		"\n")
+
+	req.Header.Set(
+		"Authorization",
+		fmt.Sprintf(
+			"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			signingAlgorithm,
+			s.accessKeyID,
+			s.credentialScope(t),
+			signedHeaders,
+			s.signature(t, canonicalRequest)))
+}
+
+// Return a copy of the supplied URL carrying a signature in its query
+// parameters, so that it may be used without credentials until it expires.
+// Requests made with the URL must carry the supplied headers, which are
+// covered by the signature.
+func (s *signer) presign(
+	method string,
+	u *url.URL,
+	header http.Header,
+	expiry time.Duration,
+	t time.Time) (signed *url.URL) {
+	t = t.UTC()
+
+	// Work out which headers to sign.
+	req := &http.Request{Method: method, URL: u, Header: header}
+	headers, signedHeaders := canonicalHeaders(req)
+
+	// Add the signing parameters to the query.
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", signingAlgorithm)
+	query.Set("X-Amz-Credential", s.accessKeyID+"/"+s.credentialScope(t))
+	query.Set("X-Amz-Date", t.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expiry/time.Second)))
+	query.Set("X-Amz-SignedHeaders", signedHeaders)
+	if s.sessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	canonicalRequest := strings.Join(
+		[]string{
+			method,
+			canonicalURI(u),
+			encodeQuery(query),
+			headers,
+			signedHeaders,
+			unsignedPayload,
+		},
+		"\n")
+
+	query.Set("X-Amz-Signature", s.signature(t, canonicalRequest))
+
+	urlCopy := *u
+	urlCopy.RawQuery = encodeQuery(query)
+	signed = &urlCopy
+	return
+}
@@ -0,0 +1,135 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcss3
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestSign(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type SignTest struct {
+	signer signer
+	now    time.Time
+}
+
+func init() { RegisterTestSuite(&SignTest{}) }
+
+func (t *SignTest) SetUp(ti *TestInfo) {
+	// The example credentials from the AWS documentation.
+	t.signer = signer{
+		region:          "us-east-1",
+		service:         "iam",
+		accessKeyID:     "AKIDEXAMPLE",
+		secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+
+	t.now = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *SignTest) UriEncode() {
+	ExpectEq("abcXYZ019-._~", uriEncode("abcXYZ019-._~", false))
+	ExpectEq("a%20b%2Fc%2B%3D", uriEncode("a b/c+=", false))
+	ExpectEq("a%20b/c", uriEncode("a b/c", true))
+	ExpectEq("%C3%A9", uriEncode("é", true))
+}
+
+func (t *SignTest) EncodeQuery() {
+	query := url.Values{
+		"b":  {"2", "1"},
+		"a":  {"x y"},
+		"c~": {""},
+	}
+
+	ExpectEq("a=x%20y&b=1&b=2&c~=", encodeQuery(query))
+}
+
+func (t *SignTest) DocumentationExample() {
+	// The example from the AWS documentation for Signature Version 4.
+	u, err := url.Parse(
+		"https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08")
+	AssertEq(nil, err)
+
+	req := &http.Request{
+		Method: "GET",
+		URL:    u,
+		Header: make(http.Header),
+	}
+
+	req.Header.Set(
+		"Content-Type",
+		"application/x-www-form-urlencoded; charset=utf-8")
+
+	t.signer.sign(req, emptyPayloadHash, t.now)
+
+	ExpectEq("20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	ExpectEq(
+		"AWS4-HMAC-SHA256 "+
+			"Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+			"SignedHeaders=content-type;host;x-amz-date, "+
+			"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		req.Header.Get("Authorization"))
+}
+
+func (t *SignTest) SessionToken() {
+	t.signer.sessionToken = "taco"
+
+	u, err := url.Parse("https://iam.amazonaws.com/")
+	AssertEq(nil, err)
+
+	req := &http.Request{Method: "GET", URL: u, Header: make(http.Header)}
+	t.signer.sign(req, emptyPayloadHash, t.now)
+
+	ExpectEq("taco", req.Header.Get("X-Amz-Security-Token"))
+	ExpectThat(
+		req.Header.Get("Authorization"),
+		HasSubstr("SignedHeaders=host;x-amz-date;x-amz-security-token,"))
+}
+
+func (t *SignTest) Presign() {
+	t.signer.service = "s3"
+
+	u, err := url.Parse("https://example.com/some_bucket/foo")
+	AssertEq(nil, err)
+
+	signed := t.signer.presign("GET", u, make(http.Header), time.Hour, t.now)
+	query := signed.Query()
+
+	ExpectEq("AWS4-HMAC-SHA256", query.Get("X-Amz-Algorithm"))
+	ExpectEq(
+		"AKIDEXAMPLE/20150830/us-east-1/s3/aws4_request",
+		query.Get("X-Amz-Credential"))
+	ExpectEq("20150830T123600Z", query.Get("X-Amz-Date"))
+	ExpectEq("3600", query.Get("X-Amz-Expires"))
+	ExpectEq("host", query.Get("X-Amz-SignedHeaders"))
+	ExpectThat(query.Get("X-Amz-Signature"), MatchesRegexp("^[0-9a-f]{64}$"))
+
+	// The original URL should be unmodified.
+	ExpectEq("", u.RawQuery)
+}
@@ -0,0 +1,248 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcstesting
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+)
+
+// The object sizes at which to benchmark creating and reading objects.
+var benchmarkObjectSizes = []int{
+	0,
+	1 << 10,
+	64 << 10,
+	1 << 20,
+	16 << 20,
+}
+
+// The numbers of objects in the bucket at which to benchmark listing.
+var benchmarkListingFanOuts = []int{
+	10,
+	100,
+	1000,
+}
+
+var gBenchmarksMu sync.Mutex
+var gBenchmarkDeps []func(context.Context) BucketTestDeps // GUARDED_BY(gBenchmarksMu)
+
+// Given a function that returns appropriate test dependencies, register
+// benchmarks that exercise the buckets returned by the function, to be run by
+// RunBucketBenchmarks. For example:
+//
+//     func init() { gcstesting.RegisterBucketBenchmarks(makeDeps) }
+//
+//     func BenchmarkBucket(b *testing.B) { gcstesting.RunBucketBenchmarks(b) }
+//
+// The benchmarks cover creating, reading, and statting objects of several
+// sizes, and listing buckets containing several numbers of objects. A fresh
+// bucket is obtained from makeDeps for each, outside of the timed section.
+func RegisterBucketBenchmarks(makeDeps func(context.Context) BucketTestDeps) {
+	gBenchmarksMu.Lock()
+	defer gBenchmarksMu.Unlock()
+
+	gBenchmarkDeps = append(gBenchmarkDeps, makeDeps)
+}
+
+// Run all benchmarks registered with RegisterBucketBenchmarks as
+// sub-benchmarks of b, so that they may be selected with -bench in the usual
+// way, e.g. -bench=Bucket/CreateObject/1MiB.
+func RunBucketBenchmarks(b *testing.B) {
+	gBenchmarksMu.Lock()
+	registered := gBenchmarkDeps
+	gBenchmarksMu.Unlock()
+
+	for i, makeDeps := range registered {
+		run := func(b *testing.B) { runBucketBenchmarks(b, makeDeps) }
+
+		// Only bother distinguishing registrations if there is more than one.
+		if len(registered) == 1 {
+			run(b)
+			continue
+		}
+
+		b.Run(fmt.Sprint(i), run)
+	}
+}
+
+func runBucketBenchmarks(
+	b *testing.B,
+	makeDeps func(context.Context) BucketTestDeps) {
+	for _, size := range benchmarkObjectSizes {
+		size := size
+		name := formatSize(size)
+
+		b.Run("CreateObject/"+name, func(b *testing.B) {
+			benchmarkCreateObject(b, makeDeps, size)
+		})
+
+		b.Run("ReadObject/"+name, func(b *testing.B) {
+			benchmarkReadObject(b, makeDeps, size)
+		})
+	}
+
+	b.Run("StatObject", func(b *testing.B) {
+		benchmarkStatObject(b, makeDeps)
+	})
+
+	for _, n := range benchmarkListingFanOuts {
+		n := n
+		b.Run(fmt.Sprintf("ListObjects/%d", n), func(b *testing.B) {
+			benchmarkListObjects(b, makeDeps, n)
+		})
+	}
+}
+
+// Format a size in bytes using the largest binary unit that divides it.
+func formatSize(size int) string {
+	switch {
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", size>>20)
+
+	case size >= 1<<10 && size%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", size>>10)
+	}
+
+	return fmt.Sprintf("%dB", size)
+}
+
+// Set up a fresh bucket for a benchmark.
+func setUpBenchmarkBucket(
+	makeDeps func(context.Context) BucketTestDeps) (
+	ctx context.Context,
+	bucket gcs.Bucket) {
+	ctx = context.Background()
+	deps := makeDeps(ctx)
+	bucket = deps.Bucket
+
+	return
+}
+
+func benchmarkCreateObject(
+	b *testing.B,
+	makeDeps func(context.Context) BucketTestDeps,
+	size int) {
+	ctx, bucket := setUpBenchmarkBucket(makeDeps)
+	contents := bytes.Repeat([]byte("a"), size)
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := bucket.CreateObject(
+			ctx,
+			&gcs.CreateObjectRequest{
+				Name:     "foo",
+				Contents: bytes.NewReader(contents),
+			})
+
+		if err != nil {
+			b.Fatalf("CreateObject: %v", err)
+		}
+	}
+}
+
+func benchmarkReadObject(
+	b *testing.B,
+	makeDeps func(context.Context) BucketTestDeps,
+	size int) {
+	ctx, bucket := setUpBenchmarkBucket(makeDeps)
+
+	_, err := gcsutil.CreateObject(
+		ctx,
+		bucket,
+		"foo",
+		bytes.Repeat([]byte("a"), size))
+
+	if err != nil {
+		b.Fatalf("CreateObject: %v", err)
+	}
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rc, err := bucket.NewReader(ctx, &gcs.ReadObjectRequest{Name: "foo"})
+		if err != nil {
+			b.Fatalf("NewReader: %v", err)
+		}
+
+		_, err = io.Copy(ioutil.Discard, rc)
+		rc.Close()
+
+		if err != nil {
+			b.Fatalf("Copy: %v", err)
+		}
+	}
+}
+
+func benchmarkStatObject(
+	b *testing.B,
+	makeDeps func(context.Context) BucketTestDeps) {
+	ctx, bucket := setUpBenchmarkBucket(makeDeps)
+
+	_, err := gcsutil.CreateObject(ctx, bucket, "foo", []byte("taco"))
+	if err != nil {
+		b.Fatalf("CreateObject: %v", err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+		if err != nil {
+			b.Fatalf("StatObject: %v", err)
+		}
+	}
+}
+
+func benchmarkListObjects(
+	b *testing.B,
+	makeDeps func(context.Context) BucketTestDeps,
+	n int) {
+	ctx, bucket := setUpBenchmarkBucket(makeDeps)
+
+	var names []string
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprintf("%06d", i))
+	}
+
+	err := gcsutil.CreateEmptyObjects(ctx, bucket, names)
+	if err != nil {
+		b.Fatalf("CreateEmptyObjects: %v", err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		objects, _, err := gcsutil.ListAll(ctx, bucket, &gcs.ListObjectsRequest{})
+		if err != nil {
+			b.Fatalf("ListAll: %v", err)
+		}
+
+		if len(objects) != n {
+			b.Fatalf("Listed %d objects; expected %d", len(objects), n)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcstesting
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+// Fragments from which hostile object names and metadata are assembled:
+// characters that are special in URLs, JSON, or file systems, percent
+// sequences that are easy to decode twice or not at all, and Unicode edge
+// cases such as combining marks, invisible characters, and runes outside the
+// Basic Multilingual Plane.
+var hostileFragments = []string{
+	// Percent signs and things that look like escapes.
+	"%", "%%", "%2F", "%252F", "%00", "%zz", "%u002F", "%E2%82%AC",
+
+	// Special in URLs.
+	"+", " ", "?", "#", "&", "=", ";", ":", "@", "[", "]",
+
+	// Special in paths.
+	"/", "//", ".", "..", "./", "../", "\\", "~",
+
+	// Special in JSON and HTML.
+	`"`, `\"`, `\u0000`, "'", "<", ">", "</script>", "{", "}",
+
+	// Control characters other than carriage return and line feed.
+	"\x00", "\t", "\x1b", "\x7f", "\u0085",
+
+	// Unicode edge cases.
+	"\u00e9", "e\u0301", "\u212b", "A\u030a", "\ufeff", "\u200b", "\u200d",
+	"\u202e", "\u2028", "\u2029", "\ufffd", "\uffff", "\uff0f", "\uff05",
+	"\u4e16\u754c", "\ud0c0\ucf54", "\U0001f600",
+	"\U0001f469\u200d\U0001f469\u200d\U0001f467", "\U0010ffff",
+}
+
+// The seed for the pseudo-random hostile inputs added to the fuzz corpus, so
+// that the corpus is the same on every run.
+const hostileSeed = 17
+
+// The number of pseudo-random hostile inputs added to the fuzz corpus.
+const hostileInputCount = 256
+
+// Assemble a string of hostile fragments no longer than maxLen bytes.
+// Occasionally a single fragment is repeated up to the limit, to exercise
+// lengths near the maximum.
+func hostileString(r *rand.Rand, maxLen int) string {
+	var buf bytes.Buffer
+	if r.Intn(8) == 0 {
+		frag := hostileFragments[r.Intn(len(hostileFragments))]
+		for buf.Len()+len(frag) <= maxLen {
+			buf.WriteString(frag)
+		}
+
+		return buf.String()
+	}
+
+	n := 1 + r.Intn(16)
+	for i := 0; i < n; i++ {
+		frag := hostileFragments[r.Intn(len(hostileFragments))]
+		if buf.Len()+len(frag) > maxLen {
+			break
+		}
+
+		buf.WriteString(frag)
+
+		// Mix in some plain text, so that not every name is all punctuation.
+		if r.Intn(2) == 0 && buf.Len() < maxLen {
+			buf.WriteByte(byte('a' + r.Intn(26)))
+		}
+	}
+
+	return buf.String()
+}
+
+// Return a legal object name made of hostile fragments.
+func hostileObjectName(r *rand.Rand) (name string) {
+	for name == "" || gcs.CheckObjectName(name) != nil {
+		name = hostileString(r, gcs.MaxObjectNameLength)
+	}
+
+	return
+}
+
+// Return a metadata key and value made of hostile fragments. Keys are
+// occasionally very long.
+func hostileMetadata(r *rand.Rand) (key string, value string) {
+	keyLen := 64
+	if r.Intn(4) == 0 {
+		keyLen = 1024
+	}
+
+	for key == "" {
+		key = hostileString(r, keyLen)
+	}
+
+	value = hostileString(r, 1024)
+	return
+}
+
+var gFuzzMu sync.Mutex
+var gFuzzDeps []func(context.Context) BucketTestDeps // GUARDED_BY(gFuzzMu)
+
+// Given a function that returns appropriate test dependencies, register the
+// bucket it returns to be fuzzed by RunBucketFuzz. For example:
+//
+//     func init() { gcstesting.RegisterBucketFuzz(makeDeps) }
+//
+//     func FuzzBucket(f *testing.F) { gcstesting.RunBucketFuzz(f) }
+//
+// Each input is an object name, a user metadata key and value, and object
+// contents. It is checked that an object created with them survives being
+// statted, listed, read, updated, and deleted without any of them being
+// mangled along the way, and that names the bucket must reject are rejected
+// with *gcs.InvalidNameError. A fresh bucket is obtained from makeDeps for
+// each input.
+func RegisterBucketFuzz(makeDeps func(context.Context) BucketTestDeps) {
+	gFuzzMu.Lock()
+	defer gFuzzMu.Unlock()
+
+	gFuzzDeps = append(gFuzzDeps, makeDeps)
+}
+
+// Fuzz the buckets registered with RegisterBucketFuzz. The seed corpus
+// consists of the illegal names used by the bucket tests, along with
+// pseudo-random hostile names and metadata. Run with -fuzz=Bucket to search
+// further.
+func RunBucketFuzz(f *testing.F) {
+	gFuzzMu.Lock()
+	registered := gFuzzDeps
+	gFuzzMu.Unlock()
+
+	// Seed the corpus.
+	for _, name := range illegalNames() {
+		f.Add(name, "foo", "bar", []byte("taco"))
+	}
+
+	r := rand.New(rand.NewSource(hostileSeed))
+	for i := 0; i < hostileInputCount; i++ {
+		key, value := hostileMetadata(r)
+		f.Add(hostileObjectName(r), key, value, []byte(hostileString(r, 64)))
+	}
+
+	// Check each input against each registered bucket.
+	f.Fuzz(func(
+		t *testing.T,
+		name string,
+		key string,
+		value string,
+		contents []byte) {
+		// JSON can't carry metadata that isn't valid UTF-8, and GCS requires
+		// keys to be non-empty.
+		if key == "" || !utf8.ValidString(key) || !utf8.ValidString(value) {
+			t.Skip("Metadata not representable in GCS")
+		}
+
+		for i, makeDeps := range registered {
+			ctx := context.Background()
+			deps := makeDeps(ctx)
+
+			err := fuzzRoundTrip(ctx, deps.Bucket, name, key, value, contents)
+			if err != nil {
+				t.Fatalf("Bucket %d: %v", i, err)
+			}
+		}
+	})
+}
+
+// Check that an object with the given name, metadata, and contents makes it
+// through a create, stat, list, read, update, and delete round trip intact.
+func fuzzRoundTrip(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	key string,
+	value string,
+	contents []byte) (err error) {
+	metadata := map[string]string{key: value}
+
+	// Create. Illegal names must be rejected.
+	o, err := bucket.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:     name,
+			Metadata: metadata,
+			Contents: bytes.NewReader(contents),
+		})
+
+	if gcs.CheckObjectName(name) != nil {
+		if _, ok := err.(*gcs.InvalidNameError); !ok {
+			err = fmt.Errorf(
+				"CreateObject(%q): expected *gcs.InvalidNameError, got %#v",
+				name,
+				err)
+			return
+		}
+
+		err = nil
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("CreateObject(%q): %v", name, err)
+		return
+	}
+
+	if err = checkFuzzedObject("CreateObject", o, name, metadata); err != nil {
+		return
+	}
+
+	// Stat.
+	o, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: name})
+	if err != nil {
+		err = fmt.Errorf("StatObject(%q): %v", name, err)
+		return
+	}
+
+	if err = checkFuzzedObject("StatObject", o, name, metadata); err != nil {
+		return
+	}
+
+	// List, using the name as a prefix.
+	listing, err := bucket.ListObjects(ctx, &gcs.ListObjectsRequest{Prefix: name})
+	if err != nil {
+		err = fmt.Errorf("ListObjects(%q): %v", name, err)
+		return
+	}
+
+	o = nil
+	for _, listed := range listing.Objects {
+		if listed.Name == name {
+			o = listed
+		}
+	}
+
+	if o == nil {
+		err = fmt.Errorf("ListObjects(%q): object missing from listing", name)
+		return
+	}
+
+	if err = checkFuzzedObject("ListObjects", o, name, metadata); err != nil {
+		return
+	}
+
+	// Read.
+	rc, err := bucket.NewReader(ctx, &gcs.ReadObjectRequest{Name: name})
+	if err != nil {
+		err = fmt.Errorf("NewReader(%q): %v", name, err)
+		return
+	}
+
+	actual, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		err = fmt.Errorf("ReadAll(%q): %v", name, err)
+		return
+	}
+
+	if !bytes.Equal(actual, contents) {
+		err = fmt.Errorf("NewReader(%q): read %q, want %q", name, actual, contents)
+		return
+	}
+
+	// Update the metadata, changing the value.
+	newValue := value + "%"
+	o, err = bucket.UpdateObject(
+		ctx,
+		&gcs.UpdateObjectRequest{
+			Name:     name,
+			Metadata: map[string]*string{key: &newValue},
+		})
+
+	if err != nil {
+		err = fmt.Errorf("UpdateObject(%q): %v", name, err)
+		return
+	}
+
+	metadata = map[string]string{key: newValue}
+	if err = checkFuzzedObject("UpdateObject", o, name, metadata); err != nil {
+		return
+	}
+
+	// Delete, after which the object should be gone.
+	err = bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: name})
+	if err != nil {
+		err = fmt.Errorf("DeleteObject(%q): %v", name, err)
+		return
+	}
+
+	_, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: name})
+	if _, ok := err.(*gcs.NotFoundError); !ok {
+		err = fmt.Errorf(
+			"StatObject(%q) after delete: expected *gcs.NotFoundError, got %#v",
+			name,
+			err)
+		return
+	}
+
+	err = nil
+	return
+}
+
+// Check that an object returned by the named operation has the expected name
+// and user metadata.
+func checkFuzzedObject(
+	op string,
+	o *gcs.Object,
+	name string,
+	metadata map[string]string) (err error) {
+	if o.Name != name {
+		err = fmt.Errorf("%s(%q): name %q", op, name, o.Name)
+		return
+	}
+
+	if !reflect.DeepEqual(o.Metadata, metadata) {
+		err = fmt.Errorf(
+			"%s(%q): metadata %q, want %q",
+			op,
+			name,
+			o.Metadata,
+			metadata)
+		return
+	}
+
+	return
+}
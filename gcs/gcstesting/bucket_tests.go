@@ -17,6 +17,10 @@
 package gcstesting
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"crypto/md5"
 	"crypto/rand"
 	"encoding/hex"
@@ -352,8 +356,11 @@ type bucketTest struct {
 	ctx                            context.Context
 	bucket                         gcs.Bucket
 	clock                          timeutil.Clock
-	supportsCancellation           bool
 	buffersEntireContentsForCreate bool
+
+	// The dependencies with which the test was set up, for checking
+	// capabilities.
+	deps BucketTestDeps
 }
 
 var _ bucketTestSetUpInterface = &bucketTest{}
@@ -362,8 +369,20 @@ func (t *bucketTest) setUpBucketTest(deps BucketTestDeps) {
 	t.ctx = deps.ctx
 	t.bucket = deps.Bucket
 	t.clock = deps.Clock
-	t.supportsCancellation = deps.SupportsCancellation
 	t.buffersEntireContentsForCreate = deps.BuffersEntireContentsForCreate
+	t.deps = deps
+}
+
+// If the bucket lacks the given capability, record the test as skipped in the
+// conformance report and return true.
+func (t *bucketTest) skipUnless(c capability) (skip bool) {
+	if t.deps.supports(c) {
+		return
+	}
+
+	t.deps.report.skip(t.deps.testName, c)
+	skip = true
+	return
 }
 
 func (t *bucketTest) createObject(name string, contents string) error {
@@ -877,7 +896,75 @@ func (t *createTest) CorrectCRC32CAndMD5() {
 	ExpectEq(len(contents), o.Size)
 }
 
+func (t *createTest) VerifyChecksums() {
+	const contents = "taco"
+
+	// Create
+	req := &gcs.CreateObjectRequest{
+		Name:            "foo",
+		Contents:        strings.NewReader(contents),
+		VerifyChecksums: true,
+	}
+
+	o, err := t.bucket.CreateObject(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq(computeCrc32C(contents), o.CRC32C)
+
+	// Read
+	actual, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq(contents, actual)
+}
+
+func (t *createTest) RecordMatchesStat() {
+	req := &gcs.CreateObjectRequest{
+		Name:            "foo",
+		ContentType:     "text/plain",
+		ContentLanguage: "fr",
+		CacheControl:    "no-cache",
+		Metadata:        map[string]string{"bar": "baz"},
+		Contents:        strings.NewReader("taco"),
+	}
+
+	o, err := t.bucket.CreateObject(t.ctx, req)
+	AssertEq(nil, err)
+
+	// The record returned by CreateObject should be as complete as StatObject's,
+	// so that callers needn't make another round trip.
+	m, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectThat(m, DeepEquals(o))
+}
+
+func (t *createTest) DiscardResponse() {
+	const contents = "taco"
+
+	// Create
+	req := &gcs.CreateObjectRequest{
+		Name:            "foo",
+		Contents:        strings.NewReader(contents),
+		DiscardResponse: true,
+	}
+
+	o, err := t.bucket.CreateObject(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq(nil, o)
+
+	// The object should still have been created.
+	m, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq(len(contents), m.Size)
+
+	actual, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq(contents, actual)
+}
+
 func (t *createTest) GenerationPrecondition_Zero_Unsatisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create an existing object.
 	o, err := gcsutil.CreateObject(
 		t.ctx,
@@ -918,6 +1005,10 @@ func (t *createTest) GenerationPrecondition_Zero_Unsatisfied() {
 }
 
 func (t *createTest) GenerationPrecondition_Zero_Satisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Request to create an object with a precondition saying it shouldn't exist.
 	// The request should succeed.
 	var gen int64 = 0
@@ -952,6 +1043,10 @@ func (t *createTest) GenerationPrecondition_Zero_Satisfied() {
 }
 
 func (t *createTest) GenerationPrecondition_NonZero_Unsatisfied_Missing() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Request to create a non-existent object with a precondition saying it
 	// should already exist with some generation number. The request should fail.
 	var gen int64 = 17
@@ -976,6 +1071,10 @@ func (t *createTest) GenerationPrecondition_NonZero_Unsatisfied_Missing() {
 }
 
 func (t *createTest) GenerationPrecondition_NonZero_Unsatisfied_Present() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create an existing object.
 	o, err := gcsutil.CreateObject(
 		t.ctx,
@@ -1016,6 +1115,10 @@ func (t *createTest) GenerationPrecondition_NonZero_Unsatisfied_Present() {
 }
 
 func (t *createTest) GenerationPrecondition_NonZero_Satisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create an existing object.
 	orig, err := gcsutil.CreateObject(
 		t.ctx,
@@ -1058,6 +1161,10 @@ func (t *createTest) GenerationPrecondition_NonZero_Satisfied() {
 }
 
 func (t *createTest) MetaGenerationPrecondition_Unsatisfied_ObjectDoesntExist() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	var err error
 
 	// Request to create a missing object, with a precondition for
@@ -1085,6 +1192,10 @@ func (t *createTest) MetaGenerationPrecondition_Unsatisfied_ObjectDoesntExist()
 }
 
 func (t *createTest) MetaGenerationPrecondition_Unsatisfied_ObjectExists() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create an existing object.
 	o, err := gcsutil.CreateObject(
 		t.ctx,
@@ -1126,6 +1237,10 @@ func (t *createTest) MetaGenerationPrecondition_Unsatisfied_ObjectExists() {
 }
 
 func (t *createTest) MetaGenerationPrecondition_Satisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create an existing object.
 	orig, err := gcsutil.CreateObject(
 		t.ctx,
@@ -1167,6 +1282,117 @@ func (t *createTest) MetaGenerationPrecondition_Satisfied() {
 	ExpectEq("burrito", string(contents))
 }
 
+func (t *createTest) ProgressFunc() {
+	const contents = "taco"
+
+	var lastReported int64
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader(contents),
+			ProgressFunc: func(n int64) {
+				lastReported = n
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(len(contents), o.Size)
+	ExpectEq(len(contents), lastReported)
+}
+
+func (t *createTest) CustomTime() {
+	customTime := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:       "foo",
+			Contents:   strings.NewReader("taco"),
+			CustomTime: customTime,
+		})
+
+	AssertEq(nil, err)
+	ExpectTrue(o.CustomTime.Equal(customTime), "%v", o.CustomTime)
+
+	// Stat should agree.
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	ExpectTrue(o.CustomTime.Equal(customTime), "%v", o.CustomTime)
+}
+
+func (t *createTest) StorageClass() {
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:         "foo",
+			Contents:     strings.NewReader("taco"),
+			StorageClass: "NEARLINE",
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("NEARLINE", o.StorageClass)
+
+	// Stat should agree.
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	ExpectEq("NEARLINE", o.StorageClass)
+}
+
+func (t *createTest) Compress() {
+	contents := strings.Repeat("taco", 1000)
+
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader(contents),
+			Metadata: map[string]string{"foo": "bar"},
+			Compress: true,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("gzip", o.ContentEncoding)
+	ExpectLt(o.Size, len(contents))
+	ExpectEq("bar", o.Metadata["foo"])
+	ExpectEq(
+		fmt.Sprintf("%d", len(contents)),
+		o.Metadata[gcs.UncompressedSizeMetadataKey])
+
+	// Reading the stored contents should give gzipped data.
+	r, err := t.bucket.NewReader(
+		t.ctx,
+		&gcs.ReadObjectRequest{
+			Name:           "foo",
+			ReadCompressed: true,
+		})
+
+	AssertEq(nil, err)
+	defer r.Close()
+
+	zr, err := gzip.NewReader(r)
+	AssertEq(nil, err)
+
+	actual, err := ioutil.ReadAll(zr)
+	AssertEq(nil, err)
+	ExpectEq(contents, string(actual))
+}
+
+func (t *createTest) Compress_WithContentEncoding() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:            "foo",
+			Contents:        strings.NewReader("taco"),
+			ContentEncoding: "gzip",
+			Compress:        true,
+		})
+
+	ExpectThat(err, Error(HasSubstr("ContentEncoding")))
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Copy
 ////////////////////////////////////////////////////////////////////////
@@ -1496,6 +1722,10 @@ func (t *copyTest) IllegalNames() {
 }
 
 func (t *copyTest) ParticularSourceGeneration_NameDoesntExist() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	var err error
 
 	// Copy
@@ -1510,6 +1740,10 @@ func (t *copyTest) ParticularSourceGeneration_NameDoesntExist() {
 }
 
 func (t *copyTest) ParticularSourceGeneration_GenerationDoesntExist() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	var err error
 
 	// Create a source object.
@@ -1534,6 +1768,10 @@ func (t *copyTest) ParticularSourceGeneration_GenerationDoesntExist() {
 }
 
 func (t *copyTest) ParticularSourceGeneration_Exists() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	var err error
 
 	// Create a source object.
@@ -1558,6 +1796,10 @@ func (t *copyTest) ParticularSourceGeneration_Exists() {
 }
 
 func (t *copyTest) SrcMetaGenerationPrecondition_Unsatisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	var err error
 
 	// Create a source object.
@@ -1590,6 +1832,10 @@ func (t *copyTest) SrcMetaGenerationPrecondition_Unsatisfied() {
 }
 
 func (t *copyTest) SrcMetaGenerationPrecondition_Satisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	var err error
 
 	// Create a source object.
@@ -1621,121 +1867,409 @@ func (t *copyTest) SrcMetaGenerationPrecondition_Satisfied() {
 }
 
 ////////////////////////////////////////////////////////////////////////
-// Compose
+// Rewrite
 ////////////////////////////////////////////////////////////////////////
 
-type composeTest struct {
+type rewriteTest struct {
 	bucketTest
 }
 
-func (t *composeTest) createSources(
-	contents []string) (objs []*gcs.Object, err error) {
-	objs = make([]*gcs.Object, len(contents))
+func (t *rewriteTest) requiredCapabilities() []capability {
+	return []capability{capRewrite}
+}
 
-	// Write indices into a channel.
-	indices := make(chan int, len(contents))
-	for i := range contents {
-		indices <- i
+func (t *rewriteTest) SourceDoesntExist() {
+	req := &gcs.RewriteObjectRequest{
+		SrcName: "foo",
+		DstName: "bar",
 	}
-	close(indices)
-
-	// Run a bunch of workers.
-	b := syncutil.NewBundle(t.ctx)
 
-	const parallelism = 128
-	for i := 0; i < parallelism; i++ {
-		b.Add(func(ctx context.Context) (err error) {
-			for i := range indices {
-				// Create an object. Include some metadata; it should be ignored by
-				// ComposeObjects.
-				objs[i], err = t.bucket.CreateObject(
-					ctx,
-					&gcs.CreateObjectRequest{
-						Name:            fmt.Sprint(i),
-						Contents:        strings.NewReader(contents[i]),
-						ContentType:     "application/json",
-						ContentLanguage: "de",
-						Metadata: map[string]string{
-							"foo": "bar",
-						},
-					},
-				)
+	_, err := t.bucket.RewriteObject(t.ctx, req)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
 
-				if err != nil {
-					err = fmt.Errorf("CreateObject: %v", err)
-					return
-				}
-			}
+func (t *rewriteTest) Successful() {
+	AssertEq(nil, t.createObject("foo", "taco"))
 
-			return
-		})
+	// Rewrite, keeping track of progress.
+	var rewritten, total int64
+	req := &gcs.RewriteObjectRequest{
+		SrcName:         "foo",
+		DstName:         "bar",
+		DstStorageClass: "NEARLINE",
+		Progress: func(r int64, t int64) {
+			rewritten = r
+			total = t
+		},
 	}
 
-	err = b.Join()
-	return
-}
+	o, err := t.bucket.RewriteObject(t.ctx, req)
+	AssertEq(nil, err)
 
-func (t *composeTest) OneSimpleSource() {
-	// Create source objects.
-	sources, err := t.createSources([]string{
-		"taco",
-	})
+	ExpectEq("bar", o.Name)
+	ExpectEq(len("taco"), o.Size)
+	ExpectEq("NEARLINE", o.StorageClass)
+	ExpectEq(len("taco"), rewritten)
+	ExpectEq(len("taco"), total)
 
+	// Both objects should now be readable.
+	contents, err := t.readObject("foo")
 	AssertEq(nil, err)
+	ExpectEq("taco", contents)
 
-	// Compose them.
-	t.advanceTime()
-	composeTime := t.clock.Now()
-
-	o, err := t.bucket.ComposeObjects(
-		t.ctx,
-		&gcs.ComposeObjectsRequest{
-			DstName: "foo",
-			Sources: []gcs.ComposeSource{
-				gcs.ComposeSource{
-					Name: sources[0].Name,
-				},
-			},
-		})
+	contents, err = t.readObject("bar")
+	AssertEq(nil, err)
+	ExpectEq("taco", contents)
 
-	t.advanceTime()
+	// Stat should agree about the storage class.
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
 	AssertEq(nil, err)
+	ExpectEq("NEARLINE", o.StorageClass)
+}
 
-	// Check the result.
-	ExpectEq("foo", o.Name)
-	ExpectEq("", o.ContentType)
-	ExpectEq("", o.ContentLanguage)
-	ExpectEq("", o.CacheControl)
-	// Disabled due to Google-internal bug 31476941.
-	// ExpectThat(o.Owner, MatchesRegexp("^user-.*"))
-	ExpectEq(len("taco"), o.Size)
-	ExpectEq("", o.ContentEncoding)
-	ExpectEq(1, o.ComponentCount)
-	ExpectEq(nil, o.MD5)
-	ExpectEq(computeCrc32C("taco"), o.CRC32C)
-	ExpectThat(o.MediaLink, MatchesRegexp("download/storage.*foo"))
-	ExpectEq(nil, o.Metadata)
-	ExpectLt(sources[0].Generation, o.Generation)
-	ExpectEq(1, o.MetaGeneration)
-	ExpectEq("STANDARD", o.StorageClass)
-	ExpectThat(o.Deleted, timeutil.TimeEq(time.Time{}))
-	ExpectThat(o.Updated, t.matchesStartTime(composeTime))
+func (t *rewriteTest) DestinationGenerationPrecondition() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
 
-	// Check contents.
-	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, t.createObject("foo", "taco"))
+	AssertEq(nil, t.createObject("bar", "burrito"))
+
+	// A precondition of zero should fail, since the destination exists.
+	var precond int64
+	req := &gcs.RewriteObjectRequest{
+		SrcName:                   "foo",
+		DstName:                   "bar",
+		DstGenerationPrecondition: &precond,
+	}
+
+	_, err := t.bucket.RewriteObject(t.ctx, req)
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
 
+	// The destination should be unchanged.
+	contents, err := t.readObject("bar")
 	AssertEq(nil, err)
-	ExpectEq("taco", string(contents))
+	ExpectEq("burrito", contents)
 }
 
-func (t *composeTest) TwoSimpleSources() {
-	// Create source objects.
-	sources, err := t.createSources([]string{
-		"taco",
-		"burrito",
-	})
-
-	AssertEq(nil, err)
+// A bucket that updates the source object's metadata right after each
+// rewrite, as if someone else got in between the steps of gcsutil.Rename.
+type meddlingBucket struct {
+	gcs.Bucket
+}
+
+func (b *meddlingBucket) RewriteObject(
+	ctx context.Context,
+	req *gcs.RewriteObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.Bucket.RewriteObject(ctx, req)
+	if err != nil {
+		return
+	}
+
+	_, err = b.Bucket.UpdateObject(
+		ctx,
+		&gcs.UpdateObjectRequest{
+			Name:        req.SrcName,
+			ContentType: makeStringPtr("image/png"),
+		})
+
+	return
+}
+
+func (t *rewriteTest) Rename() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:        "foo",
+			Contents:    strings.NewReader("taco"),
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"a": "b"},
+		})
+
+	AssertEq(nil, err)
+
+	o, err := gcsutil.Rename(t.ctx, t.bucket, "foo", "bar", nil)
+	AssertEq(nil, err)
+	ExpectEq("bar", o.Name)
+	ExpectEq("text/plain", o.ContentType)
+	ExpectThat(o.Metadata, DeepEquals(map[string]string{"a": "b"}))
+
+	// The original should be gone.
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	contents, err := t.readObject("bar")
+	AssertEq(nil, err)
+	ExpectEq("taco", contents)
+
+	// Renaming something that doesn't exist, or to itself, should fail.
+	_, err = gcsutil.Rename(t.ctx, t.bucket, "foo", "baz", nil)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	_, err = gcsutil.Rename(t.ctx, t.bucket, "bar", "bar", nil)
+	ExpectThat(err, Error(HasSubstr("itself")))
+}
+
+func (t *rewriteTest) Rename_Preconditions() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
+	AssertEq(nil, t.createObject("foo", "taco"))
+	AssertEq(nil, t.createObject("bar", "burrito"))
+
+	// The destination exists.
+	_, err := gcsutil.Rename(t.ctx, t.bucket, "foo", "bar", nil)
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// The source has the wrong generation.
+	var badGen int64 = 17
+	_, err = gcsutil.Rename(
+		t.ctx,
+		t.bucket,
+		"foo",
+		"baz",
+		&gcsutil.RenameOptions{SrcGenerationPrecondition: &badGen})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// Nothing should have changed.
+	contents, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", contents)
+
+	contents, err = t.readObject("bar")
+	AssertEq(nil, err)
+	ExpectEq("burrito", contents)
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "baz"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	// Overwriting is allowed on request.
+	_, err = gcsutil.Rename(
+		t.ctx,
+		t.bucket,
+		"foo",
+		"bar",
+		&gcsutil.RenameOptions{Overwrite: true})
+
+	AssertEq(nil, err)
+
+	contents, err = t.readObject("bar")
+	AssertEq(nil, err)
+	ExpectEq("taco", contents)
+}
+
+func (t *rewriteTest) Rename_SourceChangedAfterCopy() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	_, err := gcsutil.Rename(
+		t.ctx,
+		&meddlingBucket{t.bucket},
+		"foo",
+		"bar",
+		nil)
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// The copy should have been cleaned up, and the original left alone.
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq("image/png", o.ContentType)
+}
+
+func (t *rewriteTest) RenamePrefix() {
+	AssertEq(
+		nil,
+		createEmpty(
+			t.ctx,
+			t.bucket,
+			[]string{
+				"dir/",
+				"dir/a",
+				"dir/b/c",
+				"dirt",
+				"other/a",
+			}))
+
+	var progress []string
+	renamed, err := gcsutil.RenamePrefix(
+		t.ctx,
+		t.bucket,
+		"dir/",
+		"new/",
+		&gcsutil.RenamePrefixOptions{
+			Parallelism: 2,
+			Progress: func(oldName string, newName string) {
+				progress = append(progress, oldName+" -> "+newName)
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(3, renamed)
+
+	sort.Strings(progress)
+	ExpectThat(
+		progress,
+		ElementsAre(
+			"dir/ -> new/",
+			"dir/a -> new/a",
+			"dir/b/c -> new/b/c",
+		))
+
+	objects, _, err := gcsutil.ListAll(t.ctx, t.bucket, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+
+	ExpectThat(
+		names,
+		ElementsAre(
+			"dirt",
+			"new/",
+			"new/a",
+			"new/b/c",
+			"other/a",
+		))
+
+	// Overlapping prefixes are refused.
+	_, err = gcsutil.RenamePrefix(t.ctx, t.bucket, "new/", "new/sub/", nil)
+	ExpectThat(err, Error(HasSubstr("prefix of the other")))
+
+	_, err = gcsutil.RenamePrefix(t.ctx, t.bucket, "new/a", "new/", nil)
+	ExpectThat(err, Error(HasSubstr("prefix of the other")))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Compose
+////////////////////////////////////////////////////////////////////////
+
+type composeTest struct {
+	bucketTest
+}
+
+func (t *composeTest) requiredCapabilities() []capability {
+	return []capability{capCompose}
+}
+
+func (t *composeTest) createSources(
+	contents []string) (objs []*gcs.Object, err error) {
+	objs = make([]*gcs.Object, len(contents))
+
+	// Write indices into a channel.
+	indices := make(chan int, len(contents))
+	for i := range contents {
+		indices <- i
+	}
+	close(indices)
+
+	// Run a bunch of workers.
+	b := syncutil.NewBundle(t.ctx)
+
+	const parallelism = 128
+	for i := 0; i < parallelism; i++ {
+		b.Add(func(ctx context.Context) (err error) {
+			for i := range indices {
+				// Create an object. Include some metadata; it should be ignored by
+				// ComposeObjects.
+				objs[i], err = t.bucket.CreateObject(
+					ctx,
+					&gcs.CreateObjectRequest{
+						Name:            fmt.Sprint(i),
+						Contents:        strings.NewReader(contents[i]),
+						ContentType:     "application/json",
+						ContentLanguage: "de",
+						Metadata: map[string]string{
+							"foo": "bar",
+						},
+					},
+				)
+
+				if err != nil {
+					err = fmt.Errorf("CreateObject: %v", err)
+					return
+				}
+			}
+
+			return
+		})
+	}
+
+	err = b.Join()
+	return
+}
+
+func (t *composeTest) OneSimpleSource() {
+	// Create source objects.
+	sources, err := t.createSources([]string{
+		"taco",
+	})
+
+	AssertEq(nil, err)
+
+	// Compose them.
+	t.advanceTime()
+	composeTime := t.clock.Now()
+
+	o, err := t.bucket.ComposeObjects(
+		t.ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName: "foo",
+			Sources: []gcs.ComposeSource{
+				gcs.ComposeSource{
+					Name: sources[0].Name,
+				},
+			},
+		})
+
+	t.advanceTime()
+	AssertEq(nil, err)
+
+	// Check the result.
+	ExpectEq("foo", o.Name)
+	ExpectEq("", o.ContentType)
+	ExpectEq("", o.ContentLanguage)
+	ExpectEq("", o.CacheControl)
+	// Disabled due to Google-internal bug 31476941.
+	// ExpectThat(o.Owner, MatchesRegexp("^user-.*"))
+	ExpectEq(len("taco"), o.Size)
+	ExpectEq("", o.ContentEncoding)
+	ExpectEq(1, o.ComponentCount)
+	ExpectEq(nil, o.MD5)
+	ExpectEq(computeCrc32C("taco"), o.CRC32C)
+	ExpectThat(o.MediaLink, MatchesRegexp("download/storage.*foo"))
+	ExpectEq(nil, o.Metadata)
+	ExpectLt(sources[0].Generation, o.Generation)
+	ExpectEq(1, o.MetaGeneration)
+	ExpectEq("STANDARD", o.StorageClass)
+	ExpectThat(o.Deleted, timeutil.TimeEq(time.Time{}))
+	ExpectThat(o.Updated, t.matchesStartTime(composeTime))
+
+	// Check contents.
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *composeTest) TwoSimpleSources() {
+	// Create source objects.
+	sources, err := t.createSources([]string{
+		"taco",
+		"burrito",
+	})
+
+	AssertEq(nil, err)
 
 	// Compose them.
 	t.advanceTime()
@@ -2118,6 +2652,10 @@ func (t *composeTest) OneSourceDoesntExist() {
 }
 
 func (t *composeTest) ExplicitGenerations_Exist() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	// Create source objects.
 	sources, err := t.createSources([]string{
 		"taco",
@@ -2152,6 +2690,10 @@ func (t *composeTest) ExplicitGenerations_Exist() {
 }
 
 func (t *composeTest) ExplicitGenerations_OneDoesntExist() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	// Create source objects.
 	sources, err := t.createSources([]string{
 		"taco",
@@ -2195,6 +2737,10 @@ func (t *composeTest) ExplicitGenerations_OneDoesntExist() {
 }
 
 func (t *composeTest) DestinationExists_NoPreconditions() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create source objects.
 	sources, err := t.createSources([]string{
 		"taco",
@@ -2236,6 +2782,10 @@ func (t *composeTest) DestinationExists_NoPreconditions() {
 }
 
 func (t *composeTest) DestinationExists_GenerationPreconditionNotSatisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create source objects.
 	sources, err := t.createSources([]string{
 		"taco",
@@ -2276,6 +2826,10 @@ func (t *composeTest) DestinationExists_GenerationPreconditionNotSatisfied() {
 }
 
 func (t *composeTest) DestinationExists_MetaGenerationPreconditionNotSatisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create source objects.
 	sources, err := t.createSources([]string{
 		"taco",
@@ -2317,6 +2871,10 @@ func (t *composeTest) DestinationExists_MetaGenerationPreconditionNotSatisfied()
 }
 
 func (t *composeTest) DestinationExists_PreconditionsSatisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create source objects.
 	sources, err := t.createSources([]string{
 		"taco",
@@ -2361,6 +2919,10 @@ func (t *composeTest) DestinationExists_PreconditionsSatisfied() {
 }
 
 func (t *composeTest) DestinationDoesntExist_PreconditionNotSatisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create source objects.
 	sources, err := t.createSources([]string{
 		"taco",
@@ -2399,6 +2961,10 @@ func (t *composeTest) DestinationDoesntExist_PreconditionNotSatisfied() {
 }
 
 func (t *composeTest) DestinationDoesntExist_PreconditionSatisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create source objects.
 	sources, err := t.createSources([]string{
 		"taco",
@@ -2621,11 +3187,109 @@ func (t *composeTest) IllegalNames() {
 	AssertEq(nil, err)
 }
 
-////////////////////////////////////////////////////////////////////////
-// Read
-////////////////////////////////////////////////////////////////////////
+func (t *composeTest) AppendObject() {
+	// Appending to a non-existent object creates it.
+	o, err := gcsutil.AppendObject(
+		t.ctx,
+		t.bucket,
+		&gcsutil.AppendObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+		})
 
-type readTest struct {
+	AssertEq(nil, err)
+	ExpectEq(1, o.ComponentCount)
+
+	// Set some metadata, which should be preserved.
+	contentType := "text/plain"
+	bar := "bar"
+	_, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:        "foo",
+			ContentType: &contentType,
+			Metadata:    map[string]*string{"foo": &bar},
+		})
+
+	AssertEq(nil, err)
+
+	// Append twice more.
+	for _, s := range []string{"burrito", "enchilada"} {
+		o, err = gcsutil.AppendObject(
+			t.ctx,
+			t.bucket,
+			&gcsutil.AppendObjectRequest{
+				Name:     "foo",
+				Contents: strings.NewReader(s),
+			})
+
+		AssertEq(nil, err)
+	}
+
+	ExpectEq(3, o.ComponentCount)
+	ExpectEq(len("tacoburritoenchilada"), o.Size)
+	ExpectEq("text/plain", o.ContentType)
+	ExpectEq("bar", o.Metadata["foo"])
+
+	contents, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq("tacoburritoenchilada", contents)
+
+	// No temporaries were left behind.
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("foo", listing.Objects[0].Name)
+
+	// A stale precondition is refused.
+	stale := o.Generation - 1
+	_, err = gcsutil.AppendObject(
+		t.ctx,
+		t.bucket,
+		&gcsutil.AppendObjectRequest{
+			Name:                   "foo",
+			Contents:               strings.NewReader("queso"),
+			GenerationPrecondition: &stale,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+}
+
+func (t *composeTest) AppendObject_Flattens() {
+	var o *gcs.Object
+	var err error
+
+	var expected string
+	for i := 0; i < 7; i++ {
+		s := fmt.Sprint(i)
+		expected += s
+
+		o, err = gcsutil.AppendObject(
+			t.ctx,
+			t.bucket,
+			&gcsutil.AppendObjectRequest{
+				Name:             "foo",
+				Contents:         strings.NewReader(s),
+				FlattenThreshold: 3,
+			})
+
+		AssertEq(nil, err)
+		AssertLe(o.ComponentCount, 3)
+	}
+
+	// 1, 2, 3, 1 (flattened), 2, 3, 1 (flattened).
+	ExpectEq(1, o.ComponentCount)
+
+	contents, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq(expected, contents)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Read
+////////////////////////////////////////////////////////////////////////
+
+type readTest struct {
 	bucketTest
 }
 
@@ -2644,6 +3308,28 @@ func (t *readTest) ObjectNameDoesntExist() {
 	ExpectThat(err, Error(MatchesRegexp("(?i)not found|404")))
 }
 
+func (t *readTest) IllegalNames() {
+	err := forEachString(
+		t.ctx,
+		illegalNames(),
+		func(ctx context.Context, name string) (err error) {
+			rc, err := t.bucket.NewReader(ctx, &gcs.ReadObjectRequest{Name: name})
+			if err == nil {
+				rc.Close()
+			}
+
+			if _, ok := err.(*gcs.InvalidNameError); !ok {
+				err = fmt.Errorf("Unexpected error for %q: %#v", name, err)
+				return
+			}
+
+			err = nil
+			return
+		})
+
+	AssertEq(nil, err)
+}
+
 func (t *readTest) EmptyObject() {
 	// Create
 	AssertEq(nil, t.createObject("foo", ""))
@@ -2684,7 +3370,138 @@ func (t *readTest) NonEmptyObject() {
 	AssertEq(nil, r.Close())
 }
 
+func (t *readTest) VerifyChecksums() {
+	// Create
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	// Read
+	req := &gcs.ReadObjectRequest{
+		Name:            "foo",
+		VerifyChecksums: true,
+	}
+
+	r, err := t.bucket.NewReader(t.ctx, req)
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(r)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	// Close
+	AssertEq(nil, r.Close())
+}
+
+func (t *readTest) ProgressFuncAndIdleTimeout() {
+	// Create
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	// Read
+	var lastReported int64
+	req := &gcs.ReadObjectRequest{
+		Name:        "foo",
+		IdleTimeout: time.Minute,
+		ProgressFunc: func(n int64) {
+			lastReported = n
+		},
+	}
+
+	r, err := t.bucket.NewReader(t.ctx, req)
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(r)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+	ExpectEq(len(contents), lastReported)
+
+	// Close
+	AssertEq(nil, r.Close())
+}
+
+func (t *readTest) ContentEncoding_NotEncoded() {
+	// Create
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	// Read
+	encoding := "unset"
+	req := &gcs.ReadObjectRequest{
+		Name: "foo",
+		ContentEncodingFunc: func(e string) {
+			encoding = e
+		},
+	}
+
+	r, err := t.bucket.NewReader(t.ctx, req)
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(r)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+	ExpectEq("", encoding)
+
+	// Close
+	AssertEq(nil, r.Close())
+}
+
+func (t *readTest) ReadCompressed() {
+	// Create an object with gzipped contents.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte("taco"))
+	AssertEq(nil, err)
+	AssertEq(nil, zw.Close())
+
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:            "foo",
+			ContentEncoding: "gzip",
+			Contents:        bytes.NewReader(buf.Bytes()),
+		})
+
+	AssertEq(nil, err)
+
+	// Read, asking for the contents as stored.
+	var encoding string
+	req := &gcs.ReadObjectRequest{
+		Name:           "foo",
+		ReadCompressed: true,
+		ContentEncodingFunc: func(e string) {
+			encoding = e
+		},
+	}
+
+	r, err := t.bucket.NewReader(t.ctx, req)
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(r)
+	AssertEq(nil, err)
+	ExpectEq("gzip", encoding)
+	ExpectTrue(bytes.Equal(buf.Bytes(), contents))
+
+	// Close
+	AssertEq(nil, r.Close())
+}
+
+func (t *readTest) VerifyChecksums_WithRange() {
+	// Create
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	// Read
+	req := &gcs.ReadObjectRequest{
+		Name:            "foo",
+		Range:           &gcs.ByteRange{Start: 1, Limit: 3},
+		VerifyChecksums: true,
+	}
+
+	_, err := t.bucket.NewReader(t.ctx, req)
+	ExpectThat(err, Error(HasSubstr("Range")))
+}
+
 func (t *readTest) ParticularGeneration_NeverExisted() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	// Create an object.
 	o, err := gcsutil.CreateObject(
 		t.ctx,
@@ -2712,6 +3529,10 @@ func (t *readTest) ParticularGeneration_NeverExisted() {
 }
 
 func (t *readTest) ParticularGeneration_HasBeenDeleted() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	// Create an object.
 	o, err := gcsutil.CreateObject(
 		t.ctx,
@@ -2748,6 +3569,10 @@ func (t *readTest) ParticularGeneration_HasBeenDeleted() {
 }
 
 func (t *readTest) ParticularGeneration_Exists() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	// Create an object.
 	o, err := gcsutil.CreateObject(
 		t.ctx,
@@ -2776,6 +3601,10 @@ func (t *readTest) ParticularGeneration_Exists() {
 }
 
 func (t *readTest) ParticularGeneration_ObjectHasBeenOverwritten() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	// Create an object.
 	o, err := gcsutil.CreateObject(
 		t.ctx,
@@ -2826,6 +3655,62 @@ func (t *readTest) ParticularGeneration_ObjectHasBeenOverwritten() {
 	AssertEq(nil, rc.Close())
 }
 
+func (t *readTest) Preconditions() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
+	// Create an object.
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	// A read with a stale generation precondition should fail.
+	badGen := o.Generation + 1
+	req := &gcs.ReadObjectRequest{
+		Name:                   "foo",
+		GenerationPrecondition: &badGen,
+	}
+
+	rc, err := t.bucket.NewReader(t.ctx, req)
+	if err == nil {
+		defer rc.Close()
+		_, err = rc.Read(make([]byte, 1))
+	}
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// So should one with a stale meta-generation precondition.
+	badMetaGen := o.MetaGeneration + 1
+	req = &gcs.ReadObjectRequest{
+		Name:                       "foo",
+		MetaGenerationPrecondition: &badMetaGen,
+	}
+
+	rc, err = t.bucket.NewReader(t.ctx, req)
+	if err == nil {
+		defer rc.Close()
+		_, err = rc.Read(make([]byte, 1))
+	}
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// A read with satisfied preconditions should work.
+	req = &gcs.ReadObjectRequest{
+		Name:                       "foo",
+		GenerationPrecondition:     &o.Generation,
+		MetaGenerationPrecondition: &o.MetaGeneration,
+	}
+
+	rc, err = t.bucket.NewReader(t.ctx, req)
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(rc)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	AssertEq(nil, rc.Close())
+}
+
 func (t *readTest) Ranges_EmptyObject() {
 	// Create an empty object.
 	AssertEq(nil, t.createObject("foo", ""))
@@ -2982,46 +3867,188 @@ func (t *readTest) Ranges_NonEmptyObject() {
 	}
 }
 
-////////////////////////////////////////////////////////////////////////
-// Stat
-////////////////////////////////////////////////////////////////////////
+func (t *readTest) ObjectReaderAt() {
+	// Create an object spanning a few blocks.
+	const blockSize = gcsutil.ObjectReaderAtBlockSize
+	contents := make([]byte, 2*blockSize+17)
+	for i := range contents {
+		contents[i] = byte(i * 7)
+	}
 
-type statTest struct {
-	bucketTest
-}
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", contents)
+	AssertEq(nil, err)
 
-func (t *statTest) NonExistentObject() {
-	req := &gcs.StatObjectRequest{
-		Name: "foo",
-	}
+	// Open it, both at the latest generation and at a specific one.
+	for _, gen := range []int64{0, o.Generation} {
+		r, err := gcsutil.NewObjectReaderAt(t.ctx, t.bucket, "foo", gen)
+		AssertEq(nil, err)
+		ExpectEq(o.Generation, r.Object().Generation)
+		ExpectEq(len(contents), r.Size())
 
-	_, err := t.bucket.StatObject(t.ctx, req)
+		// A read spanning a block boundary.
+		buf := make([]byte, 100)
+		n, err := r.ReadAt(buf, blockSize-50)
+		AssertEq(nil, err)
+		ExpectEq(100, n)
+		ExpectTrue(bytes.Equal(contents[blockSize-50:blockSize+50], buf))
 
-	AssertThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
-	ExpectThat(err, Error(MatchesRegexp("not found|404")))
-}
+		// A read running off the end.
+		n, err = r.ReadAt(buf, int64(len(contents))-10)
+		ExpectEq(io.EOF, err)
+		AssertEq(10, n)
+		ExpectTrue(bytes.Equal(contents[len(contents)-10:], buf[:n]))
 
-func (t *statTest) StatAfterCreating() {
-	// Create an object.
-	createTime := t.clock.Now()
-	orig, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
-	AssertEq(nil, err)
-	AssertThat(orig.Updated, t.matchesStartTime(createTime))
+		// Seeking and reading.
+		pos, err := r.Seek(-17, io.SeekEnd)
+		AssertEq(nil, err)
+		ExpectEq(2*blockSize, pos)
 
-	// Ensure the time below doesn't match exactly.
-	t.advanceTime()
+		rest, err := ioutil.ReadAll(r)
+		AssertEq(nil, err)
+		ExpectTrue(bytes.Equal(contents[2*blockSize:], rest))
+	}
 
-	// Stat it.
-	req := &gcs.StatObjectRequest{
-		Name: "foo",
+	// A generation that doesn't exist.
+	_, err = gcsutil.NewObjectReaderAt(t.ctx, t.bucket, "foo", o.Generation+1)
+	ExpectThat(err, Error(HasSubstr("not found")))
+}
+
+func (t *readTest) Archives() {
+	files := []struct {
+		name     string
+		contents string
+	}{
+		{"taco", "carnitas"},
+		{"empty", ""},
+		{"burrito", strings.Repeat("queso", 1000)},
 	}
 
-	o, err := t.bucket.StatObject(t.ctx, req)
-	AssertEq(nil, err)
-	AssertNe(nil, o)
+	// Create a zip archive and a tar archive with the same files.
+	var zipBuf, tarBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	tw := tar.NewWriter(&tarBuf)
 
-	ExpectEq("foo", o.Name)
-	ExpectEq(orig.Generation, o.Generation)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		AssertEq(nil, err)
+		_, err = io.WriteString(w, f.contents)
+		AssertEq(nil, err)
+
+		err = tw.WriteHeader(&tar.Header{
+			Name: f.name,
+			Mode: 0644,
+			Size: int64(len(f.contents)),
+		})
+
+		AssertEq(nil, err)
+		_, err = io.WriteString(tw, f.contents)
+		AssertEq(nil, err)
+	}
+
+	AssertEq(nil, zw.Close())
+	AssertEq(nil, tw.Close())
+
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo.zip", zipBuf.Bytes())
+	AssertEq(nil, err)
+
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "foo.tar", tarBuf.Bytes())
+	AssertEq(nil, err)
+
+	// Read the zip archive.
+	zr, err := gcsutil.OpenZip(t.ctx, t.bucket, "foo.zip", 0)
+	AssertEq(nil, err)
+	AssertEq(len(files), len(zr.File))
+
+	for i, f := range files {
+		ExpectEq(f.name, zr.File[i].Name)
+
+		rc, err := zr.File[i].Open()
+		AssertEq(nil, err)
+		contents, err := ioutil.ReadAll(rc)
+		rc.Close()
+
+		AssertEq(nil, err)
+		ExpectEq(f.contents, string(contents))
+	}
+
+	// And the tar archive, in reverse order.
+	index, err := gcsutil.IndexTar(t.ctx, t.bucket, "foo.tar", 0)
+	AssertEq(nil, err)
+	AssertEq(len(files), len(index.Entries))
+
+	for i := len(files) - 1; i >= 0; i-- {
+		e := &index.Entries[i]
+		ExpectEq(files[i].name, e.Header.Name)
+
+		rc, err := index.Open(t.ctx, e)
+		AssertEq(nil, err)
+		contents, err := ioutil.ReadAll(rc)
+		rc.Close()
+
+		AssertEq(nil, err)
+		ExpectEq(files[i].contents, string(contents))
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Stat
+////////////////////////////////////////////////////////////////////////
+
+type statTest struct {
+	bucketTest
+}
+
+func (t *statTest) NonExistentObject() {
+	req := &gcs.StatObjectRequest{
+		Name: "foo",
+	}
+
+	_, err := t.bucket.StatObject(t.ctx, req)
+
+	AssertThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+	ExpectThat(err, Error(MatchesRegexp("not found|404")))
+}
+
+func (t *statTest) IllegalNames() {
+	err := forEachString(
+		t.ctx,
+		illegalNames(),
+		func(ctx context.Context, name string) (err error) {
+			_, err = t.bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: name})
+
+			if _, ok := err.(*gcs.InvalidNameError); !ok {
+				err = fmt.Errorf("Unexpected error for %q: %#v", name, err)
+				return
+			}
+
+			err = nil
+			return
+		})
+
+	AssertEq(nil, err)
+}
+
+func (t *statTest) StatAfterCreating() {
+	// Create an object.
+	createTime := t.clock.Now()
+	orig, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+	AssertThat(orig.Updated, t.matchesStartTime(createTime))
+
+	// Ensure the time below doesn't match exactly.
+	t.advanceTime()
+
+	// Stat it.
+	req := &gcs.StatObjectRequest{
+		Name: "foo",
+	}
+
+	o, err := t.bucket.StatObject(t.ctx, req)
+	AssertEq(nil, err)
+	AssertNe(nil, o)
+
+	ExpectEq("foo", o.Name)
+	ExpectEq(orig.Generation, o.Generation)
 	ExpectEq(len("taco"), o.Size)
 	ExpectThat(o.Deleted, timeutil.TimeEq(time.Time{}))
 	ExpectThat(o.Updated, timeutil.TimeEq(orig.Updated))
@@ -3110,6 +4137,85 @@ func (t *statTest) StatAfterUpdating() {
 	ExpectThat(o.Updated, timeutil.TimeEq(o2.Updated))
 }
 
+func (t *statTest) Preconditions() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
+	// Create an object, then stat it so that any caching layer has a record.
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	// Update the object, bumping its meta-generation.
+	o2, err := t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:        "foo",
+			ContentType: makeStringPtr("image/png"),
+		})
+
+	AssertEq(nil, err)
+	AssertNe(o.MetaGeneration, o2.MetaGeneration)
+
+	// A stat with the old meta-generation should fail.
+	req := &gcs.StatObjectRequest{
+		Name:                       "foo",
+		MetaGenerationPrecondition: &o.MetaGeneration,
+	}
+
+	_, err = t.bucket.StatObject(t.ctx, req)
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// So should one with the wrong generation.
+	badGen := o.Generation + 1
+	req = &gcs.StatObjectRequest{
+		Name:                   "foo",
+		GenerationPrecondition: &badGen,
+	}
+
+	_, err = t.bucket.StatObject(t.ctx, req)
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// Current values should succeed.
+	req = &gcs.StatObjectRequest{
+		Name:                       "foo",
+		GenerationPrecondition:     &o2.Generation,
+		MetaGenerationPrecondition: &o2.MetaGeneration,
+	}
+
+	o3, err := t.bucket.StatObject(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq(o2.MetaGeneration, o3.MetaGeneration)
+	ExpectEq("image/png", o3.ContentType)
+}
+
+func (t *statTest) ObjectExists() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	exists, err := gcsutil.ObjectExists(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectTrue(exists)
+
+	exists, err = gcsutil.ObjectExists(t.ctx, t.bucket, "bar")
+	AssertEq(nil, err)
+	ExpectFalse(exists)
+
+	// Other errors are passed on.
+	_, err = gcsutil.ObjectExists(t.ctx, t.bucket, "")
+	ExpectThat(err, Error(HasSubstr("Invalid object name")))
+
+	// Deleted objects don't exist.
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	exists, err = gcsutil.ObjectExists(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectFalse(exists)
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Update
 ////////////////////////////////////////////////////////////////////////
@@ -3130,6 +4236,30 @@ func (t *updateTest) NonExistentObject() {
 	ExpectThat(err, Error(MatchesRegexp("not found|404")))
 }
 
+func (t *updateTest) IllegalNames() {
+	err := forEachString(
+		t.ctx,
+		illegalNames(),
+		func(ctx context.Context, name string) (err error) {
+			_, err = t.bucket.UpdateObject(
+				ctx,
+				&gcs.UpdateObjectRequest{
+					Name:        name,
+					ContentType: makeStringPtr("image/png"),
+				})
+
+			if _, ok := err.(*gcs.InvalidNameError); !ok {
+				err = fmt.Errorf("Unexpected error for %q: %#v", name, err)
+				return
+			}
+
+			err = nil
+			return
+		})
+
+	AssertEq(nil, err)
+}
+
 func (t *updateTest) RemoveAllFields() {
 	// Create an object with explicit attributes set.
 	createReq := &gcs.CreateObjectRequest{
@@ -3419,7 +4549,43 @@ func (t *updateTest) UpdateTime() {
 		o2.Updated)
 }
 
+func (t *updateTest) CreatedAndEtag() {
+	// Create an object.
+	createTime := t.clock.Now()
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte{})
+	AssertEq(nil, err)
+	AssertThat(o.Created, t.matchesStartTime(createTime))
+	AssertNe("", o.Etag)
+
+	// Ensure the time below doesn't match exactly.
+	t.advanceTime()
+
+	// Modify a field.
+	req := &gcs.UpdateObjectRequest{
+		Name:        "foo",
+		ContentType: makeStringPtr("image/jpeg"),
+	}
+
+	o2, err := t.bucket.UpdateObject(t.ctx, req)
+	AssertEq(nil, err)
+
+	// The creation time should be unchanged, but the etag should not.
+	ExpectThat(o2.Created, timeutil.TimeEq(o.Created))
+	ExpectNe("", o2.Etag)
+	ExpectNe(o.Etag, o2.Etag)
+
+	// Stat should agree.
+	m, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectThat(m.Created, timeutil.TimeEq(o.Created))
+	ExpectEq(o2.Etag, m.Etag)
+}
+
 func (t *updateTest) ParticularGeneration_NameDoesntExist() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	req := &gcs.UpdateObjectRequest{
 		Name:        "foo",
 		Generation:  17,
@@ -3433,6 +4599,10 @@ func (t *updateTest) ParticularGeneration_NameDoesntExist() {
 }
 
 func (t *updateTest) ParticularGeneration_GenerationDoesntExist() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	// Create an object.
 	createReq := &gcs.CreateObjectRequest{
 		Name:     "foo",
@@ -3465,6 +4635,10 @@ func (t *updateTest) ParticularGeneration_GenerationDoesntExist() {
 }
 
 func (t *updateTest) ParticularGeneration_Successful() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	// Create an object.
 	createReq := &gcs.CreateObjectRequest{
 		Name:     "foo",
@@ -3496,6 +4670,10 @@ func (t *updateTest) ParticularGeneration_Successful() {
 }
 
 func (t *updateTest) MetaGenerationPrecondition_Unsatisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create an object.
 	createReq := &gcs.CreateObjectRequest{
 		Name:     "foo",
@@ -3526,6 +4704,10 @@ func (t *updateTest) MetaGenerationPrecondition_Unsatisfied() {
 }
 
 func (t *updateTest) MetaGenerationPrecondition_Satisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	// Create an object.
 	createReq := &gcs.CreateObjectRequest{
 		Name:     "foo",
@@ -3554,76 +4736,256 @@ func (t *updateTest) MetaGenerationPrecondition_Satisfied() {
 	ExpectEq("fr", o.ContentLanguage)
 }
 
-////////////////////////////////////////////////////////////////////////
-// Delete
-////////////////////////////////////////////////////////////////////////
-
-type deleteTest struct {
-	bucketTest
-}
-
-func (t *deleteTest) NoParticularGeneration_NameDoesntExist() {
-	// No error should be returned.
-	err := t.bucket.DeleteObject(
-		t.ctx,
-		&gcs.DeleteObjectRequest{
-			Name: "foobar",
-		})
-
-	ExpectEq(nil, err)
-}
+func (t *updateTest) GenerationPrecondition_Unsatisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
 
-func (t *deleteTest) NoParticularGeneration_Successful() {
 	// Create an object.
-	AssertEq(nil, t.createObject("a", "taco"))
-
-	// Delete it.
-	AssertEq(
-		nil,
-		t.bucket.DeleteObject(
-			t.ctx,
-			&gcs.DeleteObjectRequest{
-				Name: "a",
-			}))
+	createReq := &gcs.CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader(""),
+	}
 
-	// It shouldn't show up in a listing.
-	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	o, err := t.bucket.CreateObject(t.ctx, createReq)
 	AssertEq(nil, err)
 
-	AssertNe(nil, listing)
-	AssertThat(listing.CollapsedRuns, ElementsAre())
-	AssertEq("", listing.ContinuationToken)
-	ExpectThat(listing.Objects, ElementsAre())
-
-	// It shouldn't be readable.
-	req := &gcs.ReadObjectRequest{
-		Name: "a",
-	}
-
-	rc, err := t.bucket.NewReader(t.ctx, req)
-	if err == nil {
-		defer rc.Close()
-		_, err = rc.Read(make([]byte, 1))
+	// Attempt to update with a bad precondition.
+	precond := o.Generation + 1
+	req := &gcs.UpdateObjectRequest{
+		Name:                   o.Name,
+		GenerationPrecondition: &precond,
+		ContentLanguage:        makeStringPtr("fr"),
 	}
 
-	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
-}
+	_, err = t.bucket.UpdateObject(t.ctx, req)
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
 
-func (t *deleteTest) ParticularGeneration_NameDoesntExist() {
-	// No error should be returned.
-	err := t.bucket.DeleteObject(
+	// The original object should be unaffected.
+	o, err = t.bucket.StatObject(
 		t.ctx,
-		&gcs.DeleteObjectRequest{
-			Name:       "foobar",
-			Generation: 17,
-		})
+		&gcs.StatObjectRequest{Name: o.Name})
 
-	ExpectEq(nil, err)
+	AssertEq(nil, err)
+	ExpectEq("", o.ContentLanguage)
 }
 
-func (t *deleteTest) ParticularGeneration_GenerationDoesntExist() {
-	const name = "foo"
-	var err error
+func (t *updateTest) GenerationPrecondition_Satisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
+	// Create an object.
+	createReq := &gcs.CreateObjectRequest{
+		Name:     "foo",
+		Contents: strings.NewReader(""),
+	}
+
+	o, err := t.bucket.CreateObject(t.ctx, createReq)
+	AssertEq(nil, err)
+
+	// Update with a good precondition.
+	req := &gcs.UpdateObjectRequest{
+		Name:                   o.Name,
+		GenerationPrecondition: &o.Generation,
+		ContentLanguage:        makeStringPtr("fr"),
+	}
+
+	_, err = t.bucket.UpdateObject(t.ctx, req)
+	AssertEq(nil, err)
+
+	// The object should have been updated.
+	o, err = t.bucket.StatObject(
+		t.ctx,
+		&gcs.StatObjectRequest{Name: o.Name})
+
+	AssertEq(nil, err)
+	ExpectEq("fr", o.ContentLanguage)
+}
+
+func (t *updateTest) SetCustomTime() {
+	// Create an object.
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+		})
+
+	AssertEq(nil, err)
+
+	// Give it a custom time.
+	customTime := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	o, err := t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:       "foo",
+			CustomTime: &customTime,
+		})
+
+	AssertEq(nil, err)
+	ExpectTrue(o.CustomTime.Equal(customTime), "%v", o.CustomTime)
+
+	// Stat should agree.
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	ExpectTrue(o.CustomTime.Equal(customTime), "%v", o.CustomTime)
+
+	// Unrelated updates should leave it alone.
+	o, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:            "foo",
+			ContentLanguage: makeStringPtr("fr"),
+		})
+
+	AssertEq(nil, err)
+	ExpectTrue(o.CustomTime.Equal(customTime), "%v", o.CustomTime)
+}
+
+func (t *updateTest) CustomTimeCannotMoveEarlier() {
+	// Create an object with a custom time.
+	customTime := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:       "foo",
+			Contents:   strings.NewReader("taco"),
+			CustomTime: customTime,
+		})
+
+	AssertEq(nil, err)
+
+	// Attempt to move it earlier.
+	earlier := customTime.Add(-time.Hour)
+	_, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:       "foo",
+			CustomTime: &earlier,
+		})
+
+	ExpectNe(nil, err)
+
+	// Moving it later is fine.
+	later := customTime.Add(time.Hour)
+	o, err := t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:       "foo",
+			CustomTime: &later,
+		})
+
+	AssertEq(nil, err)
+	ExpectTrue(o.CustomTime.Equal(later), "%v", o.CustomTime)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Delete
+////////////////////////////////////////////////////////////////////////
+
+type deleteTest struct {
+	bucketTest
+}
+
+func (t *deleteTest) NoParticularGeneration_NameDoesntExist() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
+	// No error should be returned.
+	err := t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{
+			Name: "foobar",
+		})
+
+	ExpectEq(nil, err)
+}
+
+func (t *deleteTest) IllegalNames() {
+	err := forEachString(
+		t.ctx,
+		illegalNames(),
+		func(ctx context.Context, name string) (err error) {
+			err = t.bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: name})
+
+			if _, ok := err.(*gcs.InvalidNameError); !ok {
+				err = fmt.Errorf("Unexpected error for %q: %#v", name, err)
+				return
+			}
+
+			err = nil
+			return
+		})
+
+	AssertEq(nil, err)
+}
+
+func (t *deleteTest) NoParticularGeneration_Successful() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
+	// Create an object.
+	AssertEq(nil, t.createObject("a", "taco"))
+
+	// Delete it.
+	AssertEq(
+		nil,
+		t.bucket.DeleteObject(
+			t.ctx,
+			&gcs.DeleteObjectRequest{
+				Name: "a",
+			}))
+
+	// It shouldn't show up in a listing.
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	AssertNe(nil, listing)
+	AssertThat(listing.CollapsedRuns, ElementsAre())
+	AssertEq("", listing.ContinuationToken)
+	ExpectThat(listing.Objects, ElementsAre())
+
+	// It shouldn't be readable.
+	req := &gcs.ReadObjectRequest{
+		Name: "a",
+	}
+
+	rc, err := t.bucket.NewReader(t.ctx, req)
+	if err == nil {
+		defer rc.Close()
+		_, err = rc.Read(make([]byte, 1))
+	}
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *deleteTest) ParticularGeneration_NameDoesntExist() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
+	// No error should be returned.
+	err := t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{
+			Name:       "foobar",
+			Generation: 17,
+		})
+
+	ExpectEq(nil, err)
+}
+
+func (t *deleteTest) ParticularGeneration_GenerationDoesntExist() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
+	const name = "foo"
+	var err error
 
 	// Create an object.
 	o, err := gcsutil.CreateObject(
@@ -3653,6 +5015,10 @@ func (t *deleteTest) ParticularGeneration_GenerationDoesntExist() {
 }
 
 func (t *deleteTest) ParticularGeneration_Successful() {
+	if t.skipUnless(capVersions) {
+		return
+	}
+
 	const name = "foo"
 	var err error
 
@@ -3681,6 +5047,10 @@ func (t *deleteTest) ParticularGeneration_Successful() {
 }
 
 func (t *deleteTest) MetaGenerationPrecondition_Unsatisfied_ObjectExists() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
 	const name = "foo"
 	var err error
 
@@ -3702,86 +5072,756 @@ func (t *deleteTest) MetaGenerationPrecondition_Unsatisfied_ObjectExists() {
 			MetaGenerationPrecondition: &precond,
 		})
 
-	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// The object should still exist.
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, name)
+	ExpectEq(nil, err)
+}
+
+func (t *deleteTest) MetaGenerationPrecondition_Unsatisfied_ObjectDoesntExist() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
+	const name = "foo"
+	var err error
+
+	// Attempt to delete a non-existent name with a meta-generation precondition.
+	var precond int64 = 1
+	err = t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{
+			Name: name,
+			MetaGenerationPrecondition: &precond,
+		})
+
+	ExpectEq(nil, err)
+}
+
+func (t *deleteTest) MetaGenerationPrecondition_Unsatisfied_WrongGeneration() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
+	const name = "foo"
+	var err error
+
+	// Create an object.
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		name,
+		[]byte("taco"))
+
+	AssertEq(nil, err)
+
+	// Attempt to delete, with a precondition for the wrong meta-generation,
+	// addressing the wrong object generation.
+	precond := o.MetaGeneration + 1
+	err = t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{
+			Name:                       name,
+			Generation:                 o.Generation + 1,
+			MetaGenerationPrecondition: &precond,
+		})
+
+	ExpectEq(nil, err)
+
+	// The object should still exist.
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, name)
+	ExpectEq(nil, err)
+}
+
+func (t *deleteTest) MetaGenerationPrecondition_Satisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
+	const name = "foo"
+	var err error
+
+	// Create an object.
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		name,
+		[]byte("taco"))
+
+	AssertEq(nil, err)
+
+	// Delete with a precondition.
+	precond := o.MetaGeneration
+	err = t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{
+			Name: name,
+			MetaGenerationPrecondition: &precond,
+		})
+
+	AssertEq(nil, err)
+
+	// The object should no longer exist.
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, name)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *deleteTest) GenerationPrecondition_Unsatisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
+	const name = "foo"
+	var err error
+
+	// Create an object.
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		name,
+		[]byte("taco"))
+
+	AssertEq(nil, err)
+
+	// Attempt to delete, with a precondition for the wrong generation.
+	precond := o.Generation + 1
+	err = t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{
+			Name:                   name,
+			GenerationPrecondition: &precond,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// The object should still exist.
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, name)
+	ExpectEq(nil, err)
+}
+
+func (t *deleteTest) GenerationPrecondition_Satisfied() {
+	if t.skipUnless(capPreconditions) {
+		return
+	}
+
+	const name = "foo"
+	var err error
+
+	// Create an object.
+	o, err := gcsutil.CreateObject(
+		t.ctx,
+		t.bucket,
+		name,
+		[]byte("taco"))
+
+	AssertEq(nil, err)
+
+	// Delete with a precondition.
+	precond := o.Generation
+	err = t.bucket.DeleteObject(
+		t.ctx,
+		&gcs.DeleteObjectRequest{
+			Name:                   name,
+			GenerationPrecondition: &precond,
+		})
+
+	AssertEq(nil, err)
+
+	// The object should no longer exist.
+	_, err = gcsutil.ReadObject(t.ctx, t.bucket, name)
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Batch
+////////////////////////////////////////////////////////////////////////
+
+type batchTest struct {
+	bucketTest
+}
+
+func (t *batchTest) EmptyBatch() {
+	results, err := t.bucket.Batch(t.ctx, &gcs.BatchRequest{})
+	AssertEq(nil, err)
+	ExpectEq(0, len(results))
+}
+
+func (t *batchTest) MixedOperations() {
+	// Create some objects.
+	AssertEq(nil, t.createObject("foo", "taco"))
+	AssertEq(nil, t.createObject("bar", "burrito"))
+	AssertEq(nil, t.createObject("baz", "enchilada"))
+
+	// Operate on them, and on an object that doesn't exist.
+	contentType := "text/plain"
+	wrongMG := int64(17)
+
+	results, err := t.bucket.Batch(
+		t.ctx,
+		&gcs.BatchRequest{
+			Ops: []gcs.BatchOp{
+				{Stat: &gcs.StatObjectRequest{Name: "foo"}},
+				{Stat: &gcs.StatObjectRequest{Name: "qux"}},
+				{
+					Update: &gcs.UpdateObjectRequest{
+						Name:        "bar",
+						ContentType: &contentType,
+					},
+				},
+				{
+					Update: &gcs.UpdateObjectRequest{
+						Name:                       "foo",
+						MetaGenerationPrecondition: &wrongMG,
+					},
+				},
+				{Delete: &gcs.DeleteObjectRequest{Name: "baz"}},
+			},
+		})
+
+	AssertEq(nil, err)
+	AssertEq(5, len(results))
+
+	// Stat
+	AssertEq(nil, results[0].Err)
+	ExpectEq("foo", results[0].Object.Name)
+	ExpectEq(len("taco"), results[0].Object.Size)
+
+	ExpectThat(results[1].Err, HasSameTypeAs(&gcs.NotFoundError{}))
+	ExpectEq(nil, results[1].Object)
+
+	// Update
+	AssertEq(nil, results[2].Err)
+	ExpectEq("bar", results[2].Object.Name)
+	ExpectEq("text/plain", results[2].Object.ContentType)
+
+	ExpectThat(results[3].Err, HasSameTypeAs(&gcs.PreconditionError{}))
+	ExpectEq(nil, results[3].Object)
+
+	// Delete
+	AssertEq(nil, results[4].Err)
+	ExpectEq(nil, results[4].Object)
+
+	// The effects should be visible.
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	AssertEq(nil, err)
+	ExpectEq("text/plain", o.ContentType)
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "baz"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Holds
+////////////////////////////////////////////////////////////////////////
+
+type holdTest struct {
+	bucketTest
+}
+
+func (t *holdTest) requiredCapabilities() []capability {
+	return []capability{capHolds}
+}
+
+func (t *holdTest) CreateWithHolds() {
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:           "foo",
+			TemporaryHold:  true,
+			EventBasedHold: true,
+			Contents:       strings.NewReader("taco"),
+		})
+
+	AssertEq(nil, err)
+	ExpectTrue(o.TemporaryHold)
+	ExpectTrue(o.EventBasedHold)
+
+	// Stat should agree.
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectTrue(o.TemporaryHold)
+	ExpectTrue(o.EventBasedHold)
+
+	// Release the holds so that the object can be cleaned up.
+	f := false
+	_, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:           "foo",
+			TemporaryHold:  &f,
+			EventBasedHold: &f,
+		})
+
+	AssertEq(nil, err)
+}
+
+func (t *holdTest) HeldObjectCannotBeDeletedOrOverwritten() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	// Place a hold.
+	tr := true
+	o, err := t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:          "foo",
+			TemporaryHold: &tr,
+		})
+
+	AssertEq(nil, err)
+	ExpectTrue(o.TemporaryHold)
+	ExpectFalse(o.EventBasedHold)
+
+	// Deleting and overwriting should fail.
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.ForbiddenError{}))
+
+	err = t.createObject("foo", "burrito")
+	ExpectThat(err, HasSameTypeAs(&gcs.ForbiddenError{}))
+
+	contents, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", contents)
+
+	// Release the hold, after which deleting should work.
+	f := false
+	o, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:          "foo",
+			TemporaryHold: &f,
+		})
+
+	AssertEq(nil, err)
+	ExpectFalse(o.TemporaryHold)
+
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	ExpectEq(nil, err)
+}
+
+////////////////////////////////////////////////////////////////////////
+// ACLs
+////////////////////////////////////////////////////////////////////////
+
+type aclTest struct {
+	bucketTest
+}
+
+func (t *aclTest) requiredCapabilities() []capability {
+	return []capability{capACLs}
+}
+
+// Return the role held by the entity according to the object's ACL, or the
+// empty string if none.
+func (t *aclTest) roleFor(name string, entity string) (role string, err error) {
+	rules, err := t.bucket.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{Name: name})
+
+	if err != nil {
+		return
+	}
+
+	for _, r := range rules {
+		if r.Entity == entity {
+			role = r.Role
+		}
+	}
+
+	return
+}
+
+func (t *aclTest) List_ObjectDoesntExist() {
+	_, err := t.bucket.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{Name: "foo"})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *aclTest) List_GenerationDoesntExist() {
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = t.bucket.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{
+			Name:       "foo",
+			Generation: o.Generation + 1,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *aclTest) Update_ObjectDoesntExist() {
+	_, err := t.bucket.UpdateObjectACL(
+		t.ctx,
+		&gcs.UpdateObjectACLRequest{
+			Name:   "foo",
+			Entity: "allUsers",
+			Role:   gcs.ACLRoleReader,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *aclTest) Update_UnsupportedRole() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	_, err := t.bucket.UpdateObjectACL(
+		t.ctx,
+		&gcs.UpdateObjectACLRequest{
+			Name:   "foo",
+			Entity: "allUsers",
+			Role:   "WRITER",
+		})
+
+	ExpectThat(err, Error(HasSubstr("role")))
+}
+
+func (t *aclTest) Update_Successful() {
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	// Grant read access to everyone.
+	rule, err := t.bucket.UpdateObjectACL(
+		t.ctx,
+		&gcs.UpdateObjectACLRequest{
+			Name:   "foo",
+			Entity: "allUsers",
+			Role:   gcs.ACLRoleReader,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("allUsers", rule.Entity)
+	ExpectEq(gcs.ACLRoleReader, rule.Role)
+
+	// The entry should show up in a listing.
+	role, err := t.roleFor("foo", "allUsers")
+	AssertEq(nil, err)
+	ExpectEq(gcs.ACLRoleReader, role)
+
+	// The meta-generation should have been bumped, but not the generation.
+	statO, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq(o.Generation, statO.Generation)
+	ExpectLt(o.MetaGeneration, statO.MetaGeneration)
+}
+
+func (t *aclTest) Update_Idempotent() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	req := &gcs.UpdateObjectACLRequest{
+		Name:   "foo",
+		Entity: "allUsers",
+		Role:   gcs.ACLRoleReader,
+	}
+
+	_, err := t.bucket.UpdateObjectACL(t.ctx, req)
+	AssertEq(nil, err)
+
+	_, err = t.bucket.UpdateObjectACL(t.ctx, req)
+	AssertEq(nil, err)
+
+	// There should be exactly one entry for the entity.
+	rules, err := t.bucket.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+
+	var count int
+	for _, r := range rules {
+		if r.Entity == "allUsers" {
+			count++
+		}
+	}
+
+	ExpectEq(1, count)
+}
+
+func (t *aclTest) Delete_NoSuchEntry() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	err := t.bucket.DeleteObjectACL(
+		t.ctx,
+		&gcs.DeleteObjectACLRequest{
+			Name:   "foo",
+			Entity: "allUsers",
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *aclTest) Delete_Successful() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	// Grant and then revoke.
+	_, err := t.bucket.UpdateObjectACL(
+		t.ctx,
+		&gcs.UpdateObjectACLRequest{
+			Name:   "foo",
+			Entity: "allUsers",
+			Role:   gcs.ACLRoleReader,
+		})
+
+	AssertEq(nil, err)
+
+	err = t.bucket.DeleteObjectACL(
+		t.ctx,
+		&gcs.DeleteObjectACLRequest{
+			Name:   "foo",
+			Entity: "allUsers",
+		})
+
+	AssertEq(nil, err)
+
+	// The entry should be gone.
+	role, err := t.roleFor("foo", "allUsers")
+	AssertEq(nil, err)
+	ExpectEq("", role)
+}
+
+func (t *aclTest) UpdateObject_ReplacesACL() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	// Patch the metadata and the ACL together.
+	contentType := "text/plain"
+	updated, err := t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:        "foo",
+			ContentType: &contentType,
+			ACL: []gcs.ACLRule{
+				{Entity: "allUsers", Role: gcs.ACLRoleReader},
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("text/plain", updated.ContentType)
+	ExpectEq(o.MetaGeneration+1, updated.MetaGeneration)
+
+	rules, err := t.bucket.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	AssertEq(1, len(rules))
+	ExpectEq("allUsers", rules[0].Entity)
+	ExpectEq(gcs.ACLRoleReader, rules[0].Role)
+}
+
+func (t *aclTest) UpdateObject_NilACL() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	_, err := t.bucket.UpdateObjectACL(
+		t.ctx,
+		&gcs.UpdateObjectACLRequest{
+			Name:   "foo",
+			Entity: "allUsers",
+			Role:   gcs.ACLRoleReader,
+		})
+
+	AssertEq(nil, err)
+
+	// An update that doesn't mention the ACL leaves it alone.
+	contentType := "text/plain"
+	_, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{Name: "foo", ContentType: &contentType})
+
+	AssertEq(nil, err)
+
+	role, err := t.roleFor("foo", "allUsers")
+	AssertEq(nil, err)
+	ExpectEq(gcs.ACLRoleReader, role)
+}
+
+func (t *aclTest) UpdateObject_EmptyACL() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	_, err := t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{Name: "foo", ACL: []gcs.ACLRule{}})
+
+	AssertEq(nil, err)
+
+	rules, err := t.bucket.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+	ExpectEq(0, len(rules))
+}
+
+func (t *aclTest) UpdateObject_UnsupportedRole() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	contentType := "text/plain"
+	_, err := t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{
+			Name:        "foo",
+			ContentType: &contentType,
+			ACL:         []gcs.ACLRule{{Entity: "allUsers", Role: "WRITER"}},
+		})
+
+	ExpectThat(err, Error(HasSubstr("WRITER")))
+
+	// Nothing should have changed.
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectNe("text/plain", o.ContentType)
+	ExpectEq(1, o.MetaGeneration)
+}
+
+func (t *aclTest) Create_PredefinedPublicRead() {
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:          "foo",
+			Contents:      strings.NewReader("taco"),
+			PredefinedACL: gcs.PredefinedACLPublicRead,
+		})
+
+	AssertEq(nil, err)
+
+	// Anyone may read it, and the creator still owns it.
+	role, err := t.roleFor("foo", "allUsers")
+	AssertEq(nil, err)
+	ExpectEq(gcs.ACLRoleReader, role)
+
+	role, err = t.roleFor("foo", o.Owner)
+	AssertEq(nil, err)
+	ExpectEq(gcs.ACLRoleOwner, role)
+}
+
+func (t *aclTest) Create_UnknownPredefinedACL() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:          "foo",
+			Contents:      strings.NewReader("taco"),
+			PredefinedACL: "everyoneCanWrite",
+		})
+
+	ExpectNe(nil, err)
+
+	// Nothing should have been created.
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *aclTest) Create_PredefinedPrivate() {
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:          "foo",
+			Contents:      strings.NewReader("taco"),
+			PredefinedACL: gcs.PredefinedACLPrivate,
+		})
+
+	AssertEq(nil, err)
+
+	// Only the creator has access.
+	rules, err := t.bucket.ListObjectACLs(
+		t.ctx,
+		&gcs.ListObjectACLsRequest{Name: "foo"})
 
-	// The object should still exist.
-	_, err = gcsutil.ReadObject(t.ctx, t.bucket, name)
-	ExpectEq(nil, err)
+	AssertEq(nil, err)
+	AssertEq(1, len(rules))
+	ExpectEq(o.Owner, rules[0].Entity)
+	ExpectEq(gcs.ACLRoleOwner, rules[0].Role)
 }
 
-func (t *deleteTest) MetaGenerationPrecondition_Unsatisfied_ObjectDoesntExist() {
-	const name = "foo"
-	var err error
+func (t *aclTest) Copy_PredefinedACL() {
+	AssertEq(nil, t.createObject("foo", "taco"))
 
-	// Attempt to delete a non-existent name with a meta-generation precondition.
-	var precond int64 = 1
-	err = t.bucket.DeleteObject(
+	o, err := t.bucket.CopyObject(
 		t.ctx,
-		&gcs.DeleteObjectRequest{
-			Name: name,
-			MetaGenerationPrecondition: &precond,
+		&gcs.CopyObjectRequest{
+			SrcName:          "foo",
+			DstName:          "bar",
+			DstPredefinedACL: gcs.PredefinedACLPublicRead,
 		})
 
-	ExpectEq(nil, err)
-}
+	AssertEq(nil, err)
 
-func (t *deleteTest) MetaGenerationPrecondition_Unsatisfied_WrongGeneration() {
-	const name = "foo"
-	var err error
+	// The copy is public, but the source is untouched.
+	role, err := t.roleFor("bar", "allUsers")
+	AssertEq(nil, err)
+	ExpectEq(gcs.ACLRoleReader, role)
 
-	// Create an object.
-	o, err := gcsutil.CreateObject(
+	role, err = t.roleFor("bar", o.Owner)
+	AssertEq(nil, err)
+	ExpectEq(gcs.ACLRoleOwner, role)
+
+	role, err = t.roleFor("foo", "allUsers")
+	AssertEq(nil, err)
+	ExpectEq("", role)
+}
+
+func (t *aclTest) Copy_PreservesSourceACL() {
+	_, err := t.bucket.CreateObject(
 		t.ctx,
-		t.bucket,
-		name,
-		[]byte("taco"))
+		&gcs.CreateObjectRequest{
+			Name:          "foo",
+			Contents:      strings.NewReader("taco"),
+			PredefinedACL: gcs.PredefinedACLAuthenticatedRead,
+		})
 
 	AssertEq(nil, err)
 
-	// Attempt to delete, with a precondition for the wrong meta-generation,
-	// addressing the wrong object generation.
-	precond := o.MetaGeneration + 1
-	err = t.bucket.DeleteObject(
+	_, err = t.bucket.CopyObject(
 		t.ctx,
-		&gcs.DeleteObjectRequest{
-			Name:                       name,
-			Generation:                 o.Generation + 1,
-			MetaGenerationPrecondition: &precond,
+		&gcs.CopyObjectRequest{
+			SrcName: "foo",
+			DstName: "bar",
 		})
 
-	ExpectEq(nil, err)
+	AssertEq(nil, err)
 
-	// The object should still exist.
-	_, err = gcsutil.ReadObject(t.ctx, t.bucket, name)
-	ExpectEq(nil, err)
+	role, err := t.roleFor("bar", "allAuthenticatedUsers")
+	AssertEq(nil, err)
+	ExpectEq(gcs.ACLRoleReader, role)
 }
 
-func (t *deleteTest) MetaGenerationPrecondition_Satisfied() {
-	const name = "foo"
-	var err error
+func (t *aclTest) Rewrite_PredefinedACL() {
+	AssertEq(nil, t.createObject("foo", "taco"))
 
-	// Create an object.
-	o, err := gcsutil.CreateObject(
+	_, err := t.bucket.RewriteObject(
 		t.ctx,
-		t.bucket,
-		name,
-		[]byte("taco"))
+		&gcs.RewriteObjectRequest{
+			SrcName:          "foo",
+			DstName:          "bar",
+			DstPredefinedACL: gcs.PredefinedACLPublicRead,
+		})
 
 	AssertEq(nil, err)
 
-	// Delete with a precondition.
-	precond := o.MetaGeneration
-	err = t.bucket.DeleteObject(
+	role, err := t.roleFor("bar", "allUsers")
+	AssertEq(nil, err)
+	ExpectEq(gcs.ACLRoleReader, role)
+}
+
+func (t *aclTest) Copy_UnknownPredefinedACL() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	_, err := t.bucket.CopyObject(
 		t.ctx,
-		&gcs.DeleteObjectRequest{
-			Name: name,
-			MetaGenerationPrecondition: &precond,
+		&gcs.CopyObjectRequest{
+			SrcName:          "foo",
+			DstName:          "bar",
+			DstPredefinedACL: "everyoneCanWrite",
 		})
 
-	AssertEq(nil, err)
+	ExpectNe(nil, err)
 
-	// The object should no longer exist.
-	_, err = gcsutil.ReadObject(t.ctx, t.bucket, name)
+	// Nothing should have been created.
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
 	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
 }
 
@@ -4262,6 +6302,364 @@ func (t *listTest) Cursor_BucketEndsWithRunOfObjectsGroupedByDelimiter() {
 		))
 }
 
+// List everything matching the request, both in one go and one result at a
+// time, checking that the two agree. Return the names of objects and the
+// collapsed runs.
+func (t *listTest) listBothWays(
+	req gcs.ListObjectsRequest) (objects []string, runs []string) {
+	for _, maxResults := range []int{0, 1} {
+		mReq := req
+		mReq.MaxResults = maxResults
+
+		listed, rs, err := gcsutil.ListAll(t.ctx, t.bucket, &mReq)
+		AssertEq(nil, err)
+
+		var names []string
+		for _, o := range listed {
+			names = append(names, o.Name)
+		}
+
+		if maxResults == 0 {
+			objects = names
+			runs = rs
+			continue
+		}
+
+		ExpectThat(names, DeepEquals(objects), "MaxResults: %d", maxResults)
+		ExpectThat(rs, DeepEquals(runs), "MaxResults: %d", maxResults)
+	}
+
+	return
+}
+
+func (t *listTest) Offsets() {
+	AssertEq(
+		nil,
+		createEmpty(
+			t.ctx,
+			t.bucket,
+			[]string{
+				"2015-06-01/a",
+				"2015-06-02/a",
+				"2015-06-02/b",
+				"2015-06-03/a",
+				"2015-06-04/a",
+			}))
+
+	// Start only. The start is inclusive.
+	objects, runs := t.listBothWays(gcs.ListObjectsRequest{
+		StartOffset: "2015-06-02/b",
+	})
+
+	ExpectThat(runs, ElementsAre())
+	ExpectThat(objects, ElementsAre("2015-06-02/b", "2015-06-03/a", "2015-06-04/a"))
+
+	// End only. The end is exclusive.
+	objects, runs = t.listBothWays(gcs.ListObjectsRequest{
+		EndOffset: "2015-06-03/a",
+	})
+
+	ExpectThat(runs, ElementsAre())
+	ExpectThat(objects, ElementsAre("2015-06-01/a", "2015-06-02/a", "2015-06-02/b"))
+
+	// Both.
+	objects, runs = t.listBothWays(gcs.ListObjectsRequest{
+		StartOffset: "2015-06-02",
+		EndOffset:   "2015-06-04",
+	})
+
+	ExpectThat(runs, ElementsAre())
+	ExpectThat(objects, ElementsAre("2015-06-02/a", "2015-06-02/b", "2015-06-03/a"))
+
+	// An empty range.
+	objects, runs = t.listBothWays(gcs.ListObjectsRequest{
+		StartOffset: "2015-06-03",
+		EndOffset:   "2015-06-02",
+	})
+
+	ExpectThat(runs, ElementsAre())
+	ExpectThat(objects, ElementsAre())
+}
+
+func (t *listTest) OffsetsWithPrefixAndDelimiter() {
+	AssertEq(
+		nil,
+		createEmpty(
+			t.ctx,
+			t.bucket,
+			[]string{
+				"logs/2015-06-01/a",
+				"logs/2015-06-02/a",
+				"logs/2015-06-02/b",
+				"logs/2015-06-03",
+				"logs/2015-06-04/a",
+				"other",
+			}))
+
+	objects, runs := t.listBothWays(gcs.ListObjectsRequest{
+		Prefix:      "logs/",
+		Delimiter:   "/",
+		StartOffset: "logs/2015-06-02/b",
+		EndOffset:   "logs/2015-06-04",
+	})
+
+	ExpectThat(runs, ElementsAre("logs/2015-06-02/"))
+	ExpectThat(objects, ElementsAre("logs/2015-06-03"))
+}
+
+func (t *listTest) MatchGlob() {
+	AssertEq(
+		nil,
+		createEmpty(
+			t.ctx,
+			t.bucket,
+			[]string{
+				"a.txt",
+				"a.json",
+				"dir/b.txt",
+				"dir/sub/c.txt",
+				"dir/sub/d.json",
+			}))
+
+	// A single star doesn't cross directories.
+	objects, runs := t.listBothWays(gcs.ListObjectsRequest{
+		MatchGlob: "*.txt",
+	})
+
+	ExpectThat(runs, ElementsAre())
+	ExpectThat(objects, ElementsAre("a.txt"))
+
+	// A double star does.
+	objects, runs = t.listBothWays(gcs.ListObjectsRequest{
+		MatchGlob: "**.txt",
+	})
+
+	ExpectThat(runs, ElementsAre())
+	ExpectThat(objects, ElementsAre("a.txt", "dir/b.txt", "dir/sub/c.txt"))
+
+	// The pattern must match the whole name, including any prefix.
+	objects, runs = t.listBothWays(gcs.ListObjectsRequest{
+		Prefix:    "dir/",
+		MatchGlob: "dir/**.json",
+	})
+
+	ExpectThat(runs, ElementsAre())
+	ExpectThat(objects, ElementsAre("dir/sub/d.json"))
+
+	// Combined with offsets.
+	objects, runs = t.listBothWays(gcs.ListObjectsRequest{
+		StartOffset: "b",
+		EndOffset:   "dir/sub/d",
+		MatchGlob:   "**.txt",
+	})
+
+	ExpectThat(runs, ElementsAre())
+	ExpectThat(objects, ElementsAre("dir/b.txt", "dir/sub/c.txt"))
+}
+
+func (t *listTest) IncludeTrailingDelimiter() {
+	AssertEq(
+		nil,
+		createEmpty(
+			t.ctx,
+			t.bucket,
+			[]string{
+				"a",
+				"b/",
+				"b/0",
+				"c/0",
+				"d//",
+				"e/",
+			}))
+
+	// Without the option, placeholders are hidden in their runs.
+	objects, runs := t.listBothWays(gcs.ListObjectsRequest{
+		Delimiter: "/",
+	})
+
+	ExpectThat(runs, ElementsAre("b/", "c/", "d/", "e/"))
+	ExpectThat(objects, ElementsAre("a"))
+
+	// With it, placeholders named exactly for a run are returned too.
+	objects, runs = t.listBothWays(gcs.ListObjectsRequest{
+		Delimiter:                "/",
+		IncludeTrailingDelimiter: true,
+	})
+
+	ExpectThat(runs, ElementsAre("b/", "c/", "d/", "e/"))
+	ExpectThat(objects, ElementsAre("a", "b/", "e/"))
+}
+
+func (t *listTest) DirectoryView() {
+	AssertEq(
+		nil,
+		createEmpty(
+			t.ctx,
+			t.bucket,
+			[]string{
+				"dir/",
+				"dir/a",
+				"dir/b",
+				"dir/b/0",
+				"dir/c/",
+				"dir/c/0",
+				"dir/d/0",
+				"dir//0",
+				"other",
+			}))
+
+	entries, err := gcsutil.ListDir(t.ctx, t.bucket, "dir/")
+	AssertEq(nil, err)
+
+	type entry struct {
+		Name           string
+		IsDir          bool
+		HasObject      bool
+	}
+
+	var actual []entry
+	for _, e := range entries {
+		actual = append(actual, entry{e.Name, e.IsDir, e.Object != nil})
+	}
+
+	expected := []entry{
+		{"a", false, true},
+		{"b", false, true},
+		{"b", true, false},
+		{"c", true, true},
+		{"d", true, false},
+	}
+
+	ExpectThat(actual, DeepEquals(expected))
+
+	// The root.
+	entries, err = gcsutil.ListDir(t.ctx, t.bucket, "")
+	AssertEq(nil, err)
+	AssertEq(2, len(entries))
+	ExpectEq("dir", entries[0].Name)
+	ExpectTrue(entries[0].IsDir)
+	ExpectEq("dir/", entries[0].Object.Name)
+	ExpectEq("other", entries[1].Name)
+	ExpectFalse(entries[1].IsDir)
+
+	// Bad directory names.
+	_, err = gcsutil.ListDir(t.ctx, t.bucket, "dir")
+	ExpectThat(err, Error(HasSubstr("slash")))
+}
+
+func (t *listTest) MaxResults() {
+	AssertEq(
+		nil,
+		createEmpty(
+			t.ctx,
+			t.bucket,
+			[]string{
+				"a",
+				"b",
+				"c/0",
+				"c/1",
+				"d",
+			}))
+
+	for _, maxResults := range []int{1, 2, gcs.MaxListResults + 1} {
+		req := &gcs.ListObjectsRequest{
+			Delimiter:  "/",
+			MaxResults: maxResults,
+		}
+
+		var objects []string
+		var runs []string
+		for {
+			listing, err := t.bucket.ListObjects(t.ctx, req)
+			AssertEq(nil, err)
+
+			// No page may be larger than requested.
+			n := len(listing.Objects) + len(listing.CollapsedRuns)
+			ExpectLe(n, maxResults)
+
+			for _, o := range listing.Objects {
+				objects = append(objects, o.Name)
+			}
+
+			runs = append(runs, listing.CollapsedRuns...)
+
+			if listing.ContinuationToken == "" {
+				break
+			}
+
+			req.ContinuationToken = listing.ContinuationToken
+		}
+
+		ExpectThat(objects, ElementsAre("a", "b", "d"), "MaxResults: %d", maxResults)
+		ExpectThat(runs, ElementsAre("c/"), "MaxResults: %d", maxResults)
+	}
+}
+
+func (t *listTest) PrefixIsEmpty() {
+	AssertEq(
+		nil,
+		createEmpty(
+			t.ctx,
+			t.bucket,
+			[]string{
+				"dir/",
+				"dir/a",
+				"other",
+			}))
+
+	testCases := []struct {
+		prefix string
+		empty  bool
+	}{
+		{"", false},
+		{"dir", false},
+		{"dir/", false},
+		{"dir/a", false},
+		{"dir/b", true},
+		{"othe", false},
+		{"others", true},
+		{"z", true},
+	}
+
+	for _, tc := range testCases {
+		empty, err := gcsutil.PrefixIsEmpty(t.ctx, t.bucket, tc.prefix)
+		AssertEq(nil, err)
+		ExpectEq(tc.empty, empty, "Prefix: %q", tc.prefix)
+	}
+}
+
+func (t *listTest) Fields() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	// The cheap way of checking for anything matching a prefix.
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{
+			Prefix:     "f",
+			MaxResults: 1,
+			Fields:     []string{"name"},
+		})
+
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("foo", listing.Objects[0].Name)
+
+	// Ask for particular fields.
+	listing, err = t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{
+			Fields: []string{"size", "generation"},
+		})
+
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Objects))
+
+	o := listing.Objects[0]
+	ExpectEq("foo", o.Name)
+	ExpectEq(len("taco"), o.Size)
+	ExpectNe(0, o.Generation)
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Cancellation
 ////////////////////////////////////////////////////////////////////////
@@ -4270,6 +6668,10 @@ type cancellationTest struct {
 	bucketTest
 }
 
+func (t *cancellationTest) requiredCapabilities() []capability {
+	return []capability{capCancellation}
+}
+
 // A Reader that slowly returns junk, forever. A channel is closed after 1 MiB
 // has been read.
 type bottomlessReader struct {
@@ -4301,11 +6703,6 @@ func (t *cancellationTest) CreateObject() {
 	const name = "foo"
 	var err error
 
-	if !t.supportsCancellation {
-		log.Println("Cancellation not supported; skipping test.")
-		return
-	}
-
 	if t.buffersEntireContentsForCreate {
 		log.Println("Can't use a bottomless reader. Skipping test.")
 		return
@@ -4370,11 +6767,6 @@ func (t *cancellationTest) ReadObject() {
 	const name = "foo"
 	var err error
 
-	if !t.supportsCancellation {
-		log.Println("Cancellation not supported; skipping test.")
-		return
-	}
-
 	// Create an object that is larger than we are likely to buffer in total
 	// throughout the HTTP library, etc.
 	const size = 1 << 20
@@ -0,0 +1,525 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcstesting
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+// A kind of failure injected by FlakyBucket.
+type Fault int
+
+const (
+	// The call fails immediately with a *googleapi.Error with code 503, as GCS
+	// returns when it is briefly unable to serve a request.
+	FaultUnavailable Fault = iota
+
+	// The call fails after FlakyBucketConfig.Delay with a *net.OpError whose
+	// Timeout method returns true, as for a connection that stopped responding.
+	FaultTimeout
+
+	// NewReader succeeds, but the reader it returns fails with
+	// io.ErrUnexpectedEOF after FlakyBucketConfig.TruncateAfter bytes, as when
+	// the connection is dropped part way through a read. Shorter objects are
+	// read in full. Applies only to NewReader.
+	FaultTruncatedRead
+
+	// CreateObject succeeds, but only after FlakyBucketConfig.Delay, or fails
+	// with the context's error if it is cancelled before then. Applies only to
+	// CreateObject.
+	FaultSlowWrite
+)
+
+func (f Fault) String() string {
+	switch f {
+	case FaultUnavailable:
+		return "FaultUnavailable"
+
+	case FaultTimeout:
+		return "FaultTimeout"
+
+	case FaultTruncatedRead:
+		return "FaultTruncatedRead"
+
+	case FaultSlowWrite:
+		return "FaultSlowWrite"
+	}
+
+	return fmt.Sprintf("Fault(%d)", int(f))
+}
+
+// Does the fault make sense for the given method?
+func (f Fault) appliesTo(method string) bool {
+	switch f {
+	case FaultTruncatedRead:
+		return method == "NewReader"
+
+	case FaultSlowWrite:
+		return method == "CreateObject"
+	}
+
+	return true
+}
+
+// A rule saying when FlakyBucket should inject a particular fault.
+type FaultRule struct {
+	// The fault to inject.
+	Fault Fault
+
+	// The bucket method to which the rule applies, e.g. "StatObject". If empty,
+	// the rule applies to every method that the fault makes sense for.
+	Method string
+
+	// The calls on which to inject the fault, numbered from one for each
+	// method separately. For example, []int{1, 2} makes the first two calls to
+	// each matching method fail.
+	Calls []int
+
+	// The probability with which to inject the fault on calls not listed in
+	// Calls.
+	Probability float64
+}
+
+// FlakyBucketConfig controls the failures injected by FlakyBucket.
+type FlakyBucketConfig struct {
+	// The rules for injecting faults. For each call, the rules are consulted in
+	// order and the first that fires determines the fault. Calls for which no
+	// rule fires are passed through to the wrapped bucket untouched.
+	Rules []FaultRule
+
+	// The seed for the pseudo-random choices made for rules with a
+	// probability, so that runs are reproducible.
+	Seed int64
+
+	// The delay used by FaultTimeout and FaultSlowWrite.
+	Delay time.Duration
+
+	// The number of bytes a reader yields before failing, for
+	// FaultTruncatedRead.
+	TruncateAfter int64
+}
+
+// A gcs.Bucket that wraps another, injecting failures into calls according to
+// a set of rules. This is useful for deterministically testing code that is
+// meant to cope with GCS's transient errors, such as gcs.NewRetryBucket.
+//
+// Name is passed through untouched. Batch is treated as a single call, with
+// its operations passed through to the wrapped bucket as a unit.
+type FlakyBucket struct {
+	wrapped gcs.Bucket
+	cfg     FlakyBucketConfig
+
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	rand *rand.Rand
+
+	// The number of calls made so far to each method.
+	//
+	// GUARDED_BY(mu)
+	calls map[string]int
+
+	// The number of faults injected so far.
+	//
+	// GUARDED_BY(mu)
+	injected int
+}
+
+var _ gcs.Bucket = &FlakyBucket{}
+
+// Create a bucket that wraps the supplied one, injecting failures as
+// described by the config.
+func NewFlakyBucket(
+	wrapped gcs.Bucket,
+	cfg FlakyBucketConfig) (b *FlakyBucket) {
+	b = &FlakyBucket{
+		wrapped: wrapped,
+		cfg:     cfg,
+		rand:    rand.New(rand.NewSource(cfg.Seed)),
+		calls:   make(map[string]int),
+	}
+
+	return
+}
+
+// Return the number of faults injected so far.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *FlakyBucket) InjectedFaults() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.injected
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Count a call to the given method, and decide which fault if any to inject.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *FlakyBucket) chooseFault(method string) (f Fault, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.calls[method]++
+	n := b.calls[method]
+
+	for _, r := range b.cfg.Rules {
+		if r.Method != "" && r.Method != method {
+			continue
+		}
+
+		if !r.Fault.appliesTo(method) {
+			continue
+		}
+
+		if !ruleFires(&r, n, b.rand) {
+			continue
+		}
+
+		f = r.Fault
+		ok = true
+		b.injected++
+		return
+	}
+
+	return
+}
+
+// Does the rule fire for the nth call to a method?
+func ruleFires(r *FaultRule, n int, rnd *rand.Rand) bool {
+	for _, c := range r.Calls {
+		if c == n {
+			return true
+		}
+	}
+
+	return r.Probability > 0 && rnd.Float64() < r.Probability
+}
+
+// Wait for the configured delay, returning early with the context's error if
+// it is cancelled first.
+func (b *FlakyBucket) sleep(ctx context.Context) (err error) {
+	select {
+	case <-time.After(b.cfg.Delay):
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+// Return the error for a fault that causes the whole call to fail, or nil if
+// the fault affects the call in some other way.
+func (b *FlakyBucket) faultError(
+	ctx context.Context,
+	method string,
+	f Fault) (err error) {
+	switch f {
+	case FaultUnavailable:
+		err = &googleapi.Error{
+			Code:    http.StatusServiceUnavailable,
+			Message: fmt.Sprintf("Injected fault in %s", method),
+		}
+
+	case FaultTimeout:
+		if err = b.sleep(ctx); err != nil {
+			return
+		}
+
+		err = &net.OpError{
+			Op:  "read",
+			Net: "tcp",
+			Err: os.ErrDeadlineExceeded,
+		}
+	}
+
+	return
+}
+
+// Inject a fault into a call to the given method if the rules say so,
+// returning the resulting error. Faults that don't simply fail the call are
+// ignored.
+func (b *FlakyBucket) maybeFail(
+	ctx context.Context,
+	method string) (err error) {
+	f, ok := b.chooseFault(method)
+	if !ok {
+		return
+	}
+
+	err = b.faultError(ctx, method, f)
+	return
+}
+
+// A reader that fails with io.ErrUnexpectedEOF once it has yielded a certain
+// number of bytes.
+type truncatedReader struct {
+	wrapped   gcs.ReadSeekCloser
+	remaining int64
+}
+
+func (r *truncatedReader) Read(p []byte) (n int, err error) {
+	if r.remaining <= 0 {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err = r.wrapped.Read(p)
+	r.remaining -= int64(n)
+
+	// The object ended before the truncation point.
+	if err == io.EOF {
+		return
+	}
+
+	if err == nil && r.remaining <= 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return
+}
+
+func (r *truncatedReader) Seek(offset int64, whence int) (int64, error) {
+	return r.wrapped.Seek(offset, whence)
+}
+
+func (r *truncatedReader) Close() error {
+	return r.wrapped.Close()
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *FlakyBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *FlakyBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc gcs.ReadSeekCloser, err error) {
+	f, ok := b.chooseFault("NewReader")
+	if ok && f != FaultTruncatedRead {
+		err = b.faultError(ctx, "NewReader", f)
+		return
+	}
+
+	rc, err = b.wrapped.NewReader(ctx, req)
+	if err != nil {
+		return
+	}
+
+	if ok {
+		rc = &truncatedReader{
+			wrapped:   rc,
+			remaining: b.cfg.TruncateAfter,
+		}
+	}
+
+	return
+}
+
+func (b *FlakyBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	f, ok := b.chooseFault("CreateObject")
+	if ok {
+		if f == FaultSlowWrite {
+			err = b.sleep(ctx)
+		} else {
+			err = b.faultError(ctx, "CreateObject", f)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	o, err = b.wrapped.CreateObject(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) CopyObject(
+	ctx context.Context,
+	req *gcs.CopyObjectRequest) (o *gcs.Object, err error) {
+	if err = b.maybeFail(ctx, "CopyObject"); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) MoveObject(
+	ctx context.Context,
+	req *gcs.MoveObjectRequest) (o *gcs.Object, err error) {
+	if err = b.maybeFail(ctx, "MoveObject"); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.MoveObject(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) ComposeObjects(
+	ctx context.Context,
+	req *gcs.ComposeObjectsRequest) (o *gcs.Object, err error) {
+	if err = b.maybeFail(ctx, "ComposeObjects"); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) RewriteObject(
+	ctx context.Context,
+	req *gcs.RewriteObjectRequest) (o *gcs.Object, err error) {
+	if err = b.maybeFail(ctx, "RewriteObject"); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	if err = b.maybeFail(ctx, "StatObject"); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	if err = b.maybeFail(ctx, "ListObjects"); err != nil {
+		return
+	}
+
+	listing, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) UpdateObject(
+	ctx context.Context,
+	req *gcs.UpdateObjectRequest) (o *gcs.Object, err error) {
+	if err = b.maybeFail(ctx, "UpdateObject"); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	if err = b.maybeFail(ctx, "DeleteObject"); err != nil {
+		return
+	}
+
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) RestoreObject(
+	ctx context.Context,
+	req *gcs.RestoreObjectRequest) (o *gcs.Object, err error) {
+	if err = b.maybeFail(ctx, "RestoreObject"); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.RestoreObject(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) Batch(
+	ctx context.Context,
+	req *gcs.BatchRequest) (results []gcs.BatchResult, err error) {
+	if err = b.maybeFail(ctx, "Batch"); err != nil {
+		return
+	}
+
+	results, err = b.wrapped.Batch(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) ListObjectACLs(
+	ctx context.Context,
+	req *gcs.ListObjectACLsRequest) (rules []*gcs.ACLRule, err error) {
+	if err = b.maybeFail(ctx, "ListObjectACLs"); err != nil {
+		return
+	}
+
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *gcs.UpdateObjectACLRequest) (rule *gcs.ACLRule, err error) {
+	if err = b.maybeFail(ctx, "UpdateObjectACL"); err != nil {
+		return
+	}
+
+	rule, err = b.wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *gcs.DeleteObjectACLRequest) (err error) {
+	if err = b.maybeFail(ctx, "DeleteObjectACL"); err != nil {
+		return
+	}
+
+	err = b.wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *FlakyBucket) SignedURL(
+	ctx context.Context,
+	req *gcs.SignedURLRequest) (signed string, err error) {
+	if err = b.maybeFail(ctx, "SignedURL"); err != nil {
+		return
+	}
+
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
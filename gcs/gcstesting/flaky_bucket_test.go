@@ -0,0 +1,239 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcstesting_test
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcstesting"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestFlakyBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type FlakyBucketTest struct {
+	ctx     context.Context
+	wrapped gcs.Bucket
+}
+
+var _ SetUpInterface = &FlakyBucketTest{}
+
+func init() { RegisterTestSuite(&FlakyBucketTest{}) }
+
+func (t *FlakyBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.wrapped = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	_, err := gcsutil.CreateObject(t.ctx, t.wrapped, "foo", []byte("taco"))
+	AssertEq(nil, err)
+}
+
+func (t *FlakyBucketTest) stat(b gcs.Bucket) (err error) {
+	_, err = b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *FlakyBucketTest) NoRules() {
+	b := gcstesting.NewFlakyBucket(t.wrapped, gcstesting.FlakyBucketConfig{})
+
+	for i := 0; i < 10; i++ {
+		AssertEq(nil, t.stat(b))
+	}
+
+	ExpectEq(0, b.InjectedFaults())
+}
+
+func (t *FlakyBucketTest) ParticularCalls() {
+	b := gcstesting.NewFlakyBucket(
+		t.wrapped,
+		gcstesting.FlakyBucketConfig{
+			Rules: []gcstesting.FaultRule{
+				{
+					Fault:  gcstesting.FaultUnavailable,
+					Method: "StatObject",
+					Calls:  []int{1, 3},
+				},
+			},
+		})
+
+	// Other methods are unaffected.
+	_, err := b.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	// Calls 1 and 3 should fail with 503s.
+	err = t.stat(b)
+	AssertThat(err, HasSameTypeAs(&googleapi.Error{}))
+	ExpectEq(503, err.(*googleapi.Error).Code)
+
+	ExpectEq(nil, t.stat(b))
+	ExpectNe(nil, t.stat(b))
+	ExpectEq(nil, t.stat(b))
+
+	ExpectEq(2, b.InjectedFaults())
+}
+
+func (t *FlakyBucketTest) ProbabilityIsReproducible() {
+	cfg := gcstesting.FlakyBucketConfig{
+		Rules: []gcstesting.FaultRule{
+			{
+				Fault:       gcstesting.FaultUnavailable,
+				Probability: 0.5,
+			},
+		},
+		Seed: 17,
+	}
+
+	// Record which calls fail for two buckets with the same seed.
+	pattern := func() (failed []bool) {
+		b := gcstesting.NewFlakyBucket(t.wrapped, cfg)
+		for i := 0; i < 100; i++ {
+			failed = append(failed, t.stat(b) != nil)
+		}
+
+		return
+	}
+
+	p0 := pattern()
+	p1 := pattern()
+	ExpectThat(p1, DeepEquals(p0))
+
+	// Roughly half should have failed.
+	var n int
+	for _, f := range p0 {
+		if f {
+			n++
+		}
+	}
+
+	ExpectThat(n, AllOf(GreaterThan(20), LessThan(80)))
+}
+
+func (t *FlakyBucketTest) Timeout() {
+	b := gcstesting.NewFlakyBucket(
+		t.wrapped,
+		gcstesting.FlakyBucketConfig{
+			Rules: []gcstesting.FaultRule{
+				{Fault: gcstesting.FaultTimeout, Calls: []int{1}},
+			},
+			Delay: time.Millisecond,
+		})
+
+	err := t.stat(b)
+	AssertThat(err, HasSameTypeAs(&net.OpError{}))
+	ExpectTrue(err.(net.Error).Timeout())
+}
+
+func (t *FlakyBucketTest) TruncatedRead() {
+	b := gcstesting.NewFlakyBucket(
+		t.wrapped,
+		gcstesting.FlakyBucketConfig{
+			Rules: []gcstesting.FaultRule{
+				{Fault: gcstesting.FaultTruncatedRead, Calls: []int{1}},
+			},
+			TruncateAfter: 2,
+		})
+
+	// The first read should be cut short.
+	rc, err := b.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(rc)
+	rc.Close()
+
+	ExpectEq("ta", string(contents))
+	ExpectThat(err, Error(HasSubstr("unexpected EOF")))
+
+	// The second should be fine.
+	rc, err = b.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	contents, err = ioutil.ReadAll(rc)
+	rc.Close()
+
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *FlakyBucketTest) SlowWriteRespectsCancellation() {
+	b := gcstesting.NewFlakyBucket(
+		t.wrapped,
+		gcstesting.FlakyBucketConfig{
+			Rules: []gcstesting.FaultRule{
+				{Fault: gcstesting.FaultSlowWrite, Probability: 1},
+			},
+			Delay: time.Hour,
+		})
+
+	ctx, cancel := context.WithTimeout(t.ctx, 10*time.Millisecond)
+	defer cancel()
+
+	_, err := gcsutil.CreateObject(ctx, b, "bar", []byte("burrito"))
+	ExpectTrue(err == context.DeadlineExceeded, "%v", err)
+}
+
+func (t *FlakyBucketTest) RetryBucketRecovers() {
+	flaky := gcstesting.NewFlakyBucket(
+		t.wrapped,
+		gcstesting.FlakyBucketConfig{
+			Rules: []gcstesting.FaultRule{
+				{Fault: gcstesting.FaultUnavailable, Method: "StatObject", Calls: []int{1, 2}},
+				{Fault: gcstesting.FaultTimeout, Method: "CreateObject", Calls: []int{1}},
+				{Fault: gcstesting.FaultTruncatedRead, Calls: []int{1}},
+			},
+			Delay:         time.Millisecond,
+			TruncateAfter: 1,
+		})
+
+	b := gcs.NewRetryBucket(
+		flaky,
+		gcs.RetryPolicy{
+			MaxSleep:     time.Minute,
+			InitialDelay: time.Millisecond,
+			MaxDelay:     time.Millisecond,
+		})
+
+	// Stat
+	AssertEq(nil, t.stat(b))
+
+	// Create
+	_, err := gcsutil.CreateObject(t.ctx, b, "bar", []byte("burrito"))
+	AssertEq(nil, err)
+
+	// Read
+	contents, err := gcsutil.ReadObject(t.ctx, b, "foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	ExpectEq(4, flaky.InjectedFaults())
+}
@@ -15,9 +15,17 @@
 package gcstesting
 
 import (
+	"bytes"
 	"errors"
+	"flag"
+	"fmt"
+	"log"
 	"reflect"
+	"regexp"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
 
 	"golang.org/x/net/context"
 
@@ -39,11 +47,158 @@ type BucketTestDeps struct {
 	// A clock matching the bucket's notion of time.
 	Clock timeutil.Clock
 
+	// Does the bucket buffer all contents before creating in GCS?
+	BuffersEntireContentsForCreate bool
+
+	// Optional features of GCS that the bucket supports. Tests that exercise a
+	// feature the bucket doesn't claim to support are skipped, and listed in
+	// the conformance report logged after the bucket tests have run.
+
 	// Does the bucket support cancellation?
 	SupportsCancellation bool
 
-	// Does the bucket buffer all contents before creating in GCS?
-	BuffersEntireContentsForCreate bool
+	// Does the bucket keep distinct generations of an object, so that a
+	// particular one can be read, copied, updated, or deleted?
+	SupportsVersions bool
+
+	// Does the bucket honour generation and meta-generation preconditions?
+	SupportsPreconditions bool
+
+	// Does the bucket support ComposeObjects?
+	SupportsCompose bool
+
+	// Does the bucket support RewriteObject?
+	SupportsRewrite bool
+
+	// Does the bucket support temporary and event-based holds?
+	SupportsHolds bool
+
+	// Does the bucket support object ACLs?
+	SupportsACLs bool
+
+	// The name of the test being set up, and the report to which to add it if
+	// it's skipped.
+	testName string
+	report   *conformanceReport
+}
+
+// An optional feature of GCS, as described by the Supports* fields of
+// BucketTestDeps.
+type capability int
+
+const (
+	capCancellation capability = iota
+	capVersions
+	capPreconditions
+	capCompose
+	capRewrite
+	capHolds
+	capACLs
+)
+
+var capabilityNames = []string{
+	capCancellation:  "SupportsCancellation",
+	capVersions:      "SupportsVersions",
+	capPreconditions: "SupportsPreconditions",
+	capCompose:       "SupportsCompose",
+	capRewrite:       "SupportsRewrite",
+	capHolds:         "SupportsHolds",
+	capACLs:          "SupportsACLs",
+}
+
+func (c capability) String() string {
+	return capabilityNames[c]
+}
+
+// Does the bucket described by the deps claim the given capability?
+func (deps *BucketTestDeps) supports(c capability) bool {
+	switch c {
+	case capCancellation:
+		return deps.SupportsCancellation
+
+	case capVersions:
+		return deps.SupportsVersions
+
+	case capPreconditions:
+		return deps.SupportsPreconditions
+
+	case capCompose:
+		return deps.SupportsCompose
+
+	case capRewrite:
+		return deps.SupportsRewrite
+
+	case capHolds:
+		return deps.SupportsHolds
+
+	case capACLs:
+		return deps.SupportsACLs
+	}
+
+	panic(fmt.Sprintf("Unknown capability: %d", c))
+}
+
+// A record of the tests skipped because the bucket lacks a capability they
+// need, safe for concurrent access.
+type conformanceReport struct {
+	mu sync.Mutex
+
+	// The names of the skipped tests, by missing capability.
+	//
+	// GUARDED_BY(mu)
+	skipped map[capability][]string
+}
+
+// LOCKS_EXCLUDED(r.mu)
+func (r *conformanceReport) skip(testName string, c capability) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.skipped == nil {
+		r.skipped = make(map[capability][]string)
+	}
+
+	r.skipped[c] = append(r.skipped[c], testName)
+}
+
+// Return a human-readable summary of the report.
+//
+// LOCKS_EXCLUDED(r.mu)
+func (r *conformanceReport) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.skipped) == 0 {
+		return "Bucket conformance: all tests were run."
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("Bucket conformance: some tests were skipped.\n")
+	for c := range capabilityNames {
+		names := r.skipped[capability(c)]
+		if len(names) == 0 {
+			continue
+		}
+
+		sort.Strings(names)
+		fmt.Fprintf(
+			&buf,
+			"  Without %v (%d skipped):\n",
+			capability(c),
+			len(names))
+
+		for _, name := range names {
+			fmt.Fprintf(&buf, "    %s\n", name)
+		}
+	}
+
+	return buf.String()
+}
+
+// An interface implemented by test suites all of whose tests need particular
+// capabilities.
+type capabilityRequirer interface {
+	requiredCapabilities() []capability
 }
 
 // An interface that all bucket tests must implement.
@@ -70,8 +225,56 @@ func getTestMethods(suitePointerType reflect.Type) []reflect.Method {
 	return exportedMethods
 }
 
+// Return the capabilities that every test in the suite needs.
+func suiteRequirements(prototype bucketTestSetUpInterface) []capability {
+	if r, ok := prototype.(capabilityRequirer); ok {
+		return r.requiredCapabilities()
+	}
+
+	return nil
+}
+
+// Create a fresh instance of the suite type and set it up for the named test
+// with dependencies from makeDeps, using the supplied context for the test.
+func setUpInstance(
+	suiteType reflect.Type,
+	makeDeps func(context.Context) BucketTestDeps,
+	makeDepsCtx context.Context,
+	testCtx context.Context,
+	testName string,
+	report *conformanceReport) (instance reflect.Value, deps BucketTestDeps) {
+	instance = reflect.New(suiteType)
+
+	deps = makeDeps(makeDepsCtx)
+	deps.ctx = testCtx
+	deps.testName = testName
+	deps.report = report
+
+	// Remember that the suite implements bucketTestSetUpInterface.
+	instance.Interface().(bucketTestSetUpInterface).setUpBucketTest(deps)
+	return
+}
+
+// Invoke the test method on the instance, unless the bucket lacks a capability
+// needed by the whole suite, in which case record the test as skipped.
+func runTestMethod(
+	method reflect.Method,
+	instance reflect.Value,
+	deps *BucketTestDeps,
+	required []capability) {
+	for _, c := range required {
+		if !deps.supports(c) {
+			deps.report.skip(deps.testName, c)
+			return
+		}
+	}
+
+	method.Func.Call([]reflect.Value{instance})
+}
+
 func registerTestSuite(
 	makeDeps func(context.Context) BucketTestDeps,
+	report *conformanceReport,
 	prototype bucketTestSetUpInterface) {
 	suitePointerType := reflect.TypeOf(prototype)
 	suiteType := suitePointerType.Elem()
@@ -80,41 +283,48 @@ func registerTestSuite(
 	var ts ogletest.TestSuite
 	ts.Name = getSuiteName(suiteType)
 
+	required := suiteRequirements(prototype)
+
 	// For each method, we create a test function.
 	for _, method := range getTestMethods(suitePointerType) {
 		var tf ogletest.TestFunction
 		tf.Name = method.Name
+		testName := ts.Name + "." + method.Name
 
-		// Create an instance to be shared among SetUp and the test function itself.
-		var instance reflect.Value = reflect.New(suiteType)
-
-		// SetUp should create a bucket and then initialize the suite object,
-		// remembering that the suite implements bucketTestSetUpInterface.
-		var report reqtrace.ReportFunc
+		// SetUp should create a bucket and then an instance of the suite to be
+		// shared with the test function itself.
+		var instance reflect.Value
+		var deps BucketTestDeps
+		var traceReport reqtrace.ReportFunc
 		tf.SetUp = func(*ogletest.TestInfo) {
 			// Start tracing.
 			var testCtx context.Context
-			testCtx, report = reqtrace.Trace(context.Background(), "Overall test")
+			testCtx, traceReport = reqtrace.Trace(
+				context.Background(),
+				"Overall test")
 
-			// Set up the bucket and other dependencies.
+			// Set up the bucket and other dependencies, handing them off to the
+			// test.
 			makeDepsCtx, makeDepsReport := reqtrace.StartSpan(testCtx, "Test setup")
-			deps := makeDeps(makeDepsCtx)
-			makeDepsReport(nil)
+			instance, deps = setUpInstance(
+				suiteType,
+				makeDeps,
+				makeDepsCtx,
+				testCtx,
+				testName,
+				report)
 
-			// Hand off the dependencies and the context to the test.
-			deps.ctx = testCtx
-			instance.Interface().(bucketTestSetUpInterface).setUpBucketTest(deps)
+			makeDepsReport(nil)
 		}
 
-		// The test function itself should simply invoke the method.
 		methodCopy := method
 		tf.Run = func() {
-			methodCopy.Func.Call([]reflect.Value{instance})
+			runTestMethod(methodCopy, instance, &deps, required)
 		}
 
 		// Report the test result.
 		tf.TearDown = func() {
-			report(errors.New(
+			traceReport(errors.New(
 				"TODO(jacobsa): Plumb through the test failure status. " +
 					"Or offer tracing in ogletest itself."))
 		}
@@ -127,24 +337,197 @@ func registerTestSuite(
 	ogletest.Register(ts)
 }
 
+// Run a test method in the background as part of a parallel suite, turning
+// panics into failures rather than letting them take down the process.
+func runTestMethodProtected(
+	method reflect.Method,
+	suiteType reflect.Type,
+	makeDeps func(context.Context) BucketTestDeps,
+	report *conformanceReport,
+	testName string,
+	required []capability) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		// Failed assertions abort the test with a panic from ogletest, having
+		// already recorded the failure.
+		if reflect.TypeOf(r).PkgPath() == reflect.TypeOf(ogletest.TestInfo{}).PkgPath() {
+			ogletest.AddFailure("%s: aborted after a failed assertion", testName)
+			return
+		}
+
+		ogletest.AddFailure("%s: panic: %v\n%s", testName, r, debug.Stack())
+	}()
+
+	ctx := context.Background()
+	instance, deps := setUpInstance(
+		suiteType,
+		makeDeps,
+		ctx,
+		ctx,
+		testName,
+		report)
+
+	runTestMethod(method, instance, &deps, required)
+}
+
+// Register a test function for each of the suite's test methods, as
+// registerTestSuite does, but run them concurrently, at most parallelism at a
+// time: when the first of them is run, all of those selected by
+// --ogletest.run are started, and each test function then waits for its own
+// method. ogletest attributes failures to whichever test function is waiting,
+// so the methods they come from are identified only by file and line.
+func registerParallelTestSuite(
+	makeDeps func(context.Context) BucketTestDeps,
+	report *conformanceReport,
+	prototype bucketTestSetUpInterface,
+	parallelism int) {
+	suitePointerType := reflect.TypeOf(prototype)
+	suiteType := suitePointerType.Elem()
+	suiteName := getSuiteName(suiteType)
+	required := suiteRequirements(prototype)
+	methods := getTestMethods(suitePointerType)
+
+	// Closed when the corresponding method has finished.
+	done := make(map[string]chan struct{})
+	for _, method := range methods {
+		done[method.Name] = make(chan struct{})
+	}
+
+	// Start the methods that ogletest will run, using the same filter. (It has
+	// already made sure that the filter compiles.)
+	var startOnce sync.Once
+	start := func() {
+		filter := regexp.MustCompile(flag.Lookup("ogletest.run").Value.String())
+		sem := make(chan struct{}, parallelism)
+
+		for _, method := range methods {
+			testName := suiteName + "." + method.Name
+			if !filter.MatchString(testName) {
+				continue
+			}
+
+			go func(method reflect.Method) {
+				sem <- struct{}{}
+				defer func() {
+					<-sem
+					close(done[method.Name])
+				}()
+
+				runTestMethodProtected(
+					method,
+					suiteType,
+					makeDeps,
+					report,
+					testName,
+					required)
+			}(method)
+		}
+	}
+
+	var ts ogletest.TestSuite
+	ts.Name = suiteName
+
+	for _, method := range methods {
+		name := method.Name
+		ts.TestFunctions = append(ts.TestFunctions, ogletest.TestFunction{
+			Name: name,
+			Run: func() {
+				startOnce.Do(start)
+				<-done[name]
+			},
+		})
+	}
+
+	ogletest.Register(ts)
+}
+
+// Register a suite that logs the supplied report once the preceding suites have
+// run.
+func registerReportSuite(report *conformanceReport) {
+	var ts ogletest.TestSuite
+	ts.Name = "BucketConformance"
+	ts.TestFunctions = []ogletest.TestFunction{
+		{
+			Name: "Report",
+			Run: func() {
+				log.Print(report)
+			},
+		},
+	}
+
+	ogletest.Register(ts)
+}
+
 // Given a function that returns appropriate test depencencies, register test
 // suites that exercise the buckets returned by the function with ogletest.
+//
+// Tests that need an optional feature of GCS are run only if the dependencies
+// claim support for it; see the Supports* fields of BucketTestDeps. The tests
+// skipped for lack of support are logged in a conformance report after the
+// others have run, so that implementations of gcs.Bucket other than GCS itself
+// can see at a glance where they differ from it.
 func RegisterBucketTests(makeDeps func(context.Context) BucketTestDeps) {
+	registerBucketTests(makeDeps, 1)
+}
+
+// Like RegisterBucketTests, but run the tests within each suite concurrently,
+// at most parallelism at a time, which can cut the wall time of tests against
+// real GCS dramatically. Tests are registered with ogletest under their usual
+// names, so --ogletest.run selects them as usual, but a failure may be
+// reported against another test of the same suite that was running at the
+// time; go by its file and line.
+//
+// makeDeps must be safe for concurrent use, and the buckets it returns must be
+// isolated from one another. For fakes this comes for free by creating a new
+// bucket each time; for a real bucket, use a fresh gcs.NewPrefixBucket each
+// time rather than clearing the whole bucket.
+func RegisterParallelBucketTests(
+	makeDeps func(context.Context) BucketTestDeps,
+	parallelism int) {
+	if parallelism < 1 {
+		panic(fmt.Sprintf("Illegal parallelism: %d", parallelism))
+	}
+
+	registerBucketTests(makeDeps, parallelism)
+}
+
+// Register the bucket test suites, running the tests within each with the
+// given parallelism. A parallelism of one means running serially, with each
+// test registered separately with ogletest.
+func registerBucketTests(
+	makeDeps func(context.Context) BucketTestDeps,
+	parallelism int) {
 	// A list of empty instances of the test suites we want to register.
 	suitePrototypes := []bucketTestSetUpInterface{
 		&createTest{},
 		&copyTest{},
+		&rewriteTest{},
 		&composeTest{},
 		&readTest{},
 		&statTest{},
 		&updateTest{},
 		&deleteTest{},
+		&batchTest{},
+		&holdTest{},
+		&aclTest{},
 		&listTest{},
 		&cancellationTest{},
 	}
 
-	// Register each.
+	// Register each, followed by the report.
+	report := &conformanceReport{}
 	for _, suitePrototype := range suitePrototypes {
-		registerTestSuite(makeDeps, suitePrototype)
+		if parallelism == 1 {
+			registerTestSuite(makeDeps, report, suitePrototype)
+			continue
+		}
+
+		registerParallelTestSuite(makeDeps, report, suitePrototype, parallelism)
 	}
+
+	registerReportSuite(report)
 }
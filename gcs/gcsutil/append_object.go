@@ -0,0 +1,272 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// The default for AppendObjectRequest.FlattenThreshold.
+const DefaultAppendFlattenThreshold = gcs.MaxComponentCount
+
+// A request to append to an object, accepted by AppendObject.
+type AppendObjectRequest struct {
+	// The name of the object to append to. Must be specified. If the object
+	// doesn't exist it is created.
+	Name string
+
+	// The data to append.
+	Contents io.Reader
+
+	// If non-nil, the append happens only if the object's current generation
+	// is equal to the given value. Zero means the object does not exist.
+	GenerationPrecondition *int64
+
+	// Once an append would give the object more than this many components,
+	// the object is instead rewritten as a single component by downloading it
+	// and uploading it again along with the new data. If zero,
+	// DefaultAppendFlattenThreshold is used. Lower values keep reads of the
+	// object efficient at the cost of flattening more often.
+	FlattenThreshold int64
+
+	// A prefix for the name of the temporary object holding the new data. If
+	// empty, Name followed by a random suffix is used.
+	TempPrefix string
+}
+
+// Append data to an object by uploading it as a temporary object and composing
+// the existing object with that, deleting the temporary afterward. (If that
+// fails, the temporary is left behind, but the append has still happened and
+// no error is returned.) The object's content type and metadata are
+// preserved. Objects with a content
+// encoding, such as those created with CreateObjectRequest.Compress, can't be
+// appended to.
+//
+// GCS limits the number of components in a composite object (see
+// gcs.MaxComponentCount), so an object that has been appended to many times
+// is periodically flattened; see AppendObjectRequest.FlattenThreshold.
+//
+// Appends are atomic: concurrent appenders don't lose each other's data, but
+// all but one of them fail with *gcs.PreconditionError and must retry. This
+// makes AppendObject suitable for shipping logs, but not for high rates of
+// appends to a single object.
+func AppendObject(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	req *AppendObjectRequest) (o *gcs.Object, err error) {
+	if req.Name == "" {
+		err = errors.New("Name must be specified")
+		return
+	}
+
+	threshold := req.FlattenThreshold
+	if threshold == 0 {
+		threshold = DefaultAppendFlattenThreshold
+	}
+
+	if threshold < 2 {
+		err = fmt.Errorf("Invalid FlattenThreshold: %d", threshold)
+		return
+	}
+
+	// Find the existing object, if any.
+	existing, err := bucket.StatObject(
+		ctx,
+		&gcs.StatObjectRequest{Name: req.Name})
+
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		existing = nil
+		err = nil
+	}
+
+	if err != nil {
+		err = annotateError("StatObject", err)
+		return
+	}
+
+	var existingGen int64
+	if existing != nil {
+		existingGen = existing.Generation
+	}
+
+	if req.GenerationPrecondition != nil && *req.GenerationPrecondition != existingGen {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Generation of %q is %d, not %d",
+				req.Name,
+				existingGen,
+				*req.GenerationPrecondition),
+		}
+
+		return
+	}
+
+	// Composition doesn't preserve the content encoding, and appending plain
+	// data to compressed data wouldn't make sense anyway.
+	if existing != nil && existing.ContentEncoding != "" {
+		err = fmt.Errorf(
+			"Can't append to %q, which has content encoding %q",
+			req.Name,
+			existing.ContentEncoding)
+
+		return
+	}
+
+	switch {
+	// If there's nothing there yet, there's nothing to append to.
+	case existing == nil:
+		o, err = bucket.CreateObject(
+			ctx,
+			&gcs.CreateObjectRequest{
+				Name:                   req.Name,
+				Contents:               req.Contents,
+				GenerationPrecondition: &existingGen,
+			})
+
+		if err != nil {
+			err = annotateError("CreateObject", err)
+			return
+		}
+
+	// Flatten if we're about to exceed the threshold.
+	case existing.ComponentCount+1 > threshold:
+		o, err = flattenAndAppend(ctx, bucket, existing, req.Contents)
+
+	default:
+		o, err = composeAndAppend(ctx, bucket, existing, req)
+	}
+
+	return
+}
+
+// Annotate an error from an operation on the existing generation of an
+// object. If the generation has gone, someone else has got there first.
+func annotateExistingError(op string, err error) error {
+	if nfe, ok := err.(*gcs.NotFoundError); ok {
+		err = &gcs.PreconditionError{Err: nfe}
+	}
+
+	return annotateError(op, err)
+}
+
+// Replace the object with a single-component object consisting of its
+// existing contents followed by the new data.
+func flattenAndAppend(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	existing *gcs.Object,
+	contents io.Reader) (o *gcs.Object, err error) {
+	rc, err := bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       existing.Name,
+			Generation: existing.Generation,
+		})
+
+	if err != nil {
+		err = annotateExistingError("NewReader", err)
+		return
+	}
+
+	defer rc.Close()
+
+	o, err = bucket.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   existing.Name,
+			ContentType:            existing.ContentType,
+			Metadata:               existing.Metadata,
+			Contents:               io.MultiReader(rc, contents),
+			GenerationPrecondition: &existing.Generation,
+		})
+
+	if err != nil {
+		err = annotateError("CreateObject", err)
+		return
+	}
+
+	return
+}
+
+// Upload the new data to a temporary object and compose the existing object
+// with it.
+func composeAndAppend(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	existing *gcs.Object,
+	req *AppendObjectRequest) (o *gcs.Object, err error) {
+	tempPrefix := req.TempPrefix
+	if tempPrefix == "" {
+		var suffix [8]byte
+		if _, err = rand.Read(suffix[:]); err != nil {
+			err = fmt.Errorf("rand.Read: %v", err)
+			return
+		}
+
+		tempPrefix = fmt.Sprintf(
+			"%s.append-%s",
+			req.Name,
+			hex.EncodeToString(suffix[:]))
+	}
+
+	temp, err := bucket.CreateObject(
+		ctx,
+		&gcs.CreateObjectRequest{
+			Name:     tempPrefix,
+			Contents: req.Contents,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("CreateObject: %v", err)
+		return
+	}
+
+	// Whatever happens, try to clean up the temporary. Once the compose has
+	// succeeded the append has happened, and reporting a failure to clean up as
+	// an error would lead callers to retry and append the data twice.
+	defer bucket.DeleteObject(
+		ctx,
+		&gcs.DeleteObjectRequest{
+			Name:       temp.Name,
+			Generation: temp.Generation,
+		})
+
+	o, err = bucket.ComposeObjects(
+		ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName:                   existing.Name,
+			DstGenerationPrecondition: &existing.Generation,
+			Sources: []gcs.ComposeSource{
+				{Name: existing.Name, Generation: existing.Generation},
+				{Name: temp.Name, Generation: temp.Generation},
+			},
+			ContentType: existing.ContentType,
+			Metadata:    existing.Metadata,
+		})
+
+	if err != nil {
+		err = annotateExistingError("ComposeObjects", err)
+		return
+	}
+
+	return
+}
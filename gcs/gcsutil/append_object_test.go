@@ -0,0 +1,186 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestAppendObject(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that overwrites an object right after it is first statted, as if
+// another appender got in between the steps of gcsutil.AppendObject.
+type racingBucket struct {
+	gcs.Bucket
+	raced bool
+}
+
+func (b *racingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	o, err = b.Bucket.StatObject(ctx, req)
+	if b.raced {
+		return
+	}
+
+	b.raced = true
+	_, createErr := gcsutil.CreateObject(ctx, b.Bucket, req.Name, []byte("racer"))
+	if createErr != nil {
+		err = createErr
+	}
+
+	return
+}
+
+// A bucket whose DeleteObject always fails.
+type undeletableBucket struct {
+	gcs.Bucket
+}
+
+func (b *undeletableBucket) DeleteObject(
+	ctx context.Context,
+	req *gcs.DeleteObjectRequest) (err error) {
+	err = errors.New("taco")
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type AppendObjectTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &AppendObjectTest{}
+
+func init() { RegisterTestSuite(&AppendObjectTest{}) }
+
+func (t *AppendObjectTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+func (t *AppendObjectTest) append(
+	bucket gcs.Bucket,
+	contents string) (o *gcs.Object, err error) {
+	o, err = gcsutil.AppendObject(
+		t.ctx,
+		bucket,
+		&gcsutil.AppendObjectRequest{
+			Name:       "foo",
+			Contents:   strings.NewReader(contents),
+			TempPrefix: "tmp/",
+		})
+
+	return
+}
+
+func (t *AppendObjectTest) readFoo() string {
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+// Return the names of all objects in the bucket.
+func (t *AppendObjectTest) list() (names []string) {
+	objects, _, err := gcsutil.ListAll(t.ctx, t.bucket, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *AppendObjectTest) ObjectChangedBeforeCompose() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = t.append(&racingBucket{Bucket: t.bucket}, "burrito")
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// The other writer's contents should be left alone, and the temporary
+	// cleaned up.
+	ExpectEq("racer", t.readFoo())
+	ExpectThat(t.list(), ElementsAre("foo"))
+}
+
+func (t *AppendObjectTest) ObjectCreatedBeforeCreate() {
+	_, err := t.append(&racingBucket{Bucket: t.bucket}, "burrito")
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	ExpectEq("racer", t.readFoo())
+}
+
+func (t *AppendObjectTest) ObjectChangedBeforeFlatten() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	// Give the object two components, so that the next append flattens.
+	o, err := t.append(t.bucket, "burrito")
+	AssertEq(nil, err)
+	AssertEq(2, o.ComponentCount)
+
+	_, err = gcsutil.AppendObject(
+		t.ctx,
+		&racingBucket{Bucket: t.bucket},
+		&gcsutil.AppendObjectRequest{
+			Name:             "foo",
+			Contents:         strings.NewReader("enchilada"),
+			FlattenThreshold: 2,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+	ExpectEq("racer", t.readFoo())
+}
+
+func (t *AppendObjectTest) TemporaryNotDeleted() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	// The append has happened, so it must not be reported as having failed.
+	o, err := t.append(&undeletableBucket{t.bucket}, "burrito")
+	AssertEq(nil, err)
+	ExpectEq(len("tacoburrito"), o.Size)
+	ExpectEq("tacoburrito", t.readFoo())
+
+	// The temporary was left behind.
+	ExpectThat(t.list(), ElementsAre("foo", "tmp/"))
+}
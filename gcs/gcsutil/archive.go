@@ -0,0 +1,159 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Open a zip archive stored in the given generation of an object (zero means
+// the latest), reading only its central directory at the end. Entries are
+// fetched on demand as they're opened. See NewObjectReaderAt for details of
+// how reads are made; ctx is used for all of them.
+func OpenZip(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	generation int64) (zr *zip.Reader, err error) {
+	r, err := NewObjectReaderAt(ctx, bucket, name, generation)
+	if err != nil {
+		err = fmt.Errorf("NewObjectReaderAt: %v", err)
+		return
+	}
+
+	zr, err = zip.NewReader(r, r.Size())
+	if err != nil {
+		err = fmt.Errorf("zip.NewReader: %v", err)
+		return
+	}
+
+	return
+}
+
+// An entry in a tar archive, as found by IndexTar.
+type TarEntry struct {
+	Header *tar.Header
+
+	// The offset within the object at which the entry's contents begin. Its
+	// contents are Header.Size bytes long.
+	Offset int64
+}
+
+// An index of the entries in a tar archive stored in an object. See
+// IndexTar.
+type TarIndex struct {
+	bucket gcs.Bucket
+	o      *gcs.Object
+
+	// The entries in the archive, in order.
+	Entries []TarEntry
+}
+
+// Index the tar archive stored in the given generation of an object (zero
+// means the latest). A tar archive has no central index, so this reads each
+// header in turn, but skips over the contents of the entries rather than
+// reading them. Compressed archives (e.g. .tar.gz files) can't be read
+// without decompressing them entirely, so aren't supported.
+func IndexTar(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	generation int64) (index *TarIndex, err error) {
+	r, err := NewObjectReaderAt(ctx, bucket, name, generation)
+	if err != nil {
+		err = fmt.Errorf("NewObjectReaderAt: %v", err)
+		return
+	}
+
+	index = &TarIndex{
+		bucket: bucket,
+		o:      r.Object(),
+	}
+
+	// tar.Reader seeks past the contents of each entry when it can, reading
+	// just their final byte, so only the blocks containing headers and the
+	// ends of entries are fetched.
+	tr := tar.NewReader(r)
+	for {
+		var h *tar.Header
+		h, err = tr.Next()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+
+		if err != nil {
+			index = nil
+			err = fmt.Errorf("Reading tar header: %v", err)
+			return
+		}
+
+		// The reader is now positioned at the start of the contents.
+		var offset int64
+		if offset, err = r.Seek(0, io.SeekCurrent); err != nil {
+			index = nil
+			err = fmt.Errorf("Seek: %v", err)
+			return
+		}
+
+		index.Entries = append(index.Entries, TarEntry{
+			Header: h,
+			Offset: offset,
+		})
+	}
+
+	return
+}
+
+// Return the record for the object generation that was indexed.
+func (ti *TarIndex) Object() *gcs.Object {
+	return ti.o
+}
+
+// Read the contents of the given entry with a single ranged read. Sparse
+// files aren't supported.
+func (ti *TarIndex) Open(
+	ctx context.Context,
+	e *TarEntry) (rc io.ReadCloser, err error) {
+	if e.Header.Typeflag == tar.TypeGNUSparse {
+		err = fmt.Errorf("Sparse file %q is not supported", e.Header.Name)
+		return
+	}
+
+	rc, err = ti.bucket.NewReader(
+		ctx,
+		&gcs.ReadObjectRequest{
+			Name:       ti.o.Name,
+			Generation: ti.o.Generation,
+			Range: &gcs.ByteRange{
+				Start: uint64(e.Offset),
+				Limit: uint64(e.Offset + e.Header.Size),
+			},
+			ReadCompressed: true,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// BucketIterator yields the buckets matched by a ListBucketsRequest one at a
+// time, calling ListBucketsPage to fetch further pages of results as
+// necessary.
+//
+// A BucketIterator is not safe for concurrent use.
+type BucketIterator struct {
+	conn gcs.Conn
+	req  gcs.ListBucketsRequest
+
+	// Buckets from the current page not yet returned by Next.
+	buffered []*gcs.BucketInfo
+
+	// Set when the connection has said there are no further pages.
+	lastPage bool
+}
+
+// Create an iterator over the buckets in the connection's project that match
+// the supplied request. *req is not modified; the iterator starts from req's
+// continuation token, if any.
+func NewBucketIterator(
+	conn gcs.Conn,
+	req *gcs.ListBucketsRequest) (it *BucketIterator) {
+	it = &BucketIterator{
+		conn: conn,
+		req:  *req,
+	}
+
+	return
+}
+
+// Return the next bucket in the listing, or nil if there are no more. The
+// context is consulted before each new page is fetched, so cancelling it stops
+// the iteration at the next page boundary.
+func (it *BucketIterator) Next(
+	ctx context.Context) (bi *gcs.BucketInfo, err error) {
+	for len(it.buffered) == 0 {
+		if it.lastPage {
+			return
+		}
+
+		// Cancelled?
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var listing *gcs.BucketListing
+		listing, err = it.conn.ListBucketsPage(ctx, &it.req)
+		if err != nil {
+			err = fmt.Errorf("ListBucketsPage: %v", err)
+			return
+		}
+
+		it.buffered = listing.Buckets
+		it.req.ContinuationToken = listing.ContinuationToken
+		it.lastPage = listing.ContinuationToken == ""
+	}
+
+	bi = it.buffered[0]
+	it.buffered = it.buffered[1:]
+
+	return
+}
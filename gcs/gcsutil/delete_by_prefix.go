@@ -0,0 +1,358 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/syncutil"
+	"golang.org/x/net/context"
+)
+
+// The defaults for DeleteByPrefixOptions.
+const (
+	DefaultDeleteBatchSize    = 100
+	DefaultDeleteParallelism  = 4
+	DefaultDeleteMaxAttempts  = 5
+	DefaultDeleteInitialDelay = 100 * time.Millisecond
+	DefaultDeleteMaxDelay     = 30 * time.Second
+)
+
+// Options accepted by DeleteByPrefix. The zero value is a sensible default.
+type DeleteByPrefixOptions struct {
+	// The number of deletes sent in each call to Bucket.Batch. If zero,
+	// DefaultDeleteBatchSize is used.
+	BatchSize int
+
+	// The maximum number of batches in flight at once. If zero,
+	// DefaultDeleteParallelism is used.
+	Parallelism int
+
+	// The number of times to try deleting an object whose deletion fails with
+	// a transient error, such as rate limiting, before giving up on it. If
+	// zero, DefaultDeleteMaxAttempts is used.
+	MaxAttempts int
+
+	// Bounds on the delay imposed between batches while GCS is reporting
+	// transient errors. If zero, DefaultDeleteInitialDelay and
+	// DefaultDeleteMaxDelay are used.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+
+	// If non-nil, called once for each object when it has been deleted (with
+	// a nil error) or DeleteByPrefix has given up on it. Calls are not made
+	// concurrently, but should return quickly since they hold up progress.
+	Progress func(name string, err error)
+}
+
+// A summary of what DeleteByPrefix did.
+type DeleteByPrefixSummary struct {
+	// The number of objects deleted.
+	Deleted int64
+
+	// The number of objects that couldn't be deleted.
+	Failed int64
+}
+
+// Delete the objects in the supplied bucket whose names start with the given
+// prefix, listing them a page at a time and deleting them in batches with
+// Bucket.Batch, several batches at once. An empty prefix deletes everything.
+//
+// Each object is deleted only if it hasn't been overwritten since it was
+// listed. Deletes that fail with transient errors (see gcs.IsTransient) are
+// retried, and the rate at which batches are sent slows down while they
+// continue to fail and speeds up again once they succeed. Other failures are
+// reported to opts.Progress and don't stop the remaining deletes; if there
+// were any, an error is returned along with the summary once all objects
+// have been tried. Listing errors stop everything.
+func DeleteByPrefix(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	prefix string,
+	opts *DeleteByPrefixOptions) (summary *DeleteByPrefixSummary, err error) {
+	d := &prefixDeleter{
+		bucket:  bucket,
+		summary: &DeleteByPrefixSummary{},
+	}
+
+	if opts != nil {
+		d.opts = *opts
+	}
+
+	if d.opts.BatchSize <= 0 {
+		d.opts.BatchSize = DefaultDeleteBatchSize
+	}
+
+	if d.opts.Parallelism <= 0 {
+		d.opts.Parallelism = DefaultDeleteParallelism
+	}
+
+	if d.opts.MaxAttempts <= 0 {
+		d.opts.MaxAttempts = DefaultDeleteMaxAttempts
+	}
+
+	if d.opts.InitialDelay <= 0 {
+		d.opts.InitialDelay = DefaultDeleteInitialDelay
+	}
+
+	if d.opts.MaxDelay <= 0 {
+		d.opts.MaxDelay = DefaultDeleteMaxDelay
+	}
+
+	bundle := syncutil.NewBundle(ctx)
+
+	// List the objects, grouping them into batches.
+	batches := make(chan []*gcs.Object, d.opts.Parallelism)
+	bundle.Add(func(ctx context.Context) (err error) {
+		defer close(batches)
+		err = d.listBatches(ctx, prefix, batches)
+		return
+	})
+
+	// Delete the batches in parallel.
+	for i := 0; i < d.opts.Parallelism; i++ {
+		bundle.Add(func(ctx context.Context) (err error) {
+			for objects := range batches {
+				if err = d.deleteBatch(ctx, objects); err != nil {
+					return
+				}
+			}
+
+			return
+		})
+	}
+
+	err = bundle.Join()
+	summary = d.summary
+
+	if err == nil && d.firstErr != nil {
+		err = fmt.Errorf(
+			"Failed to delete %d objects, including %q: %v",
+			summary.Failed,
+			d.firstErrName,
+			d.firstErr)
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+type prefixDeleter struct {
+	bucket gcs.Bucket
+	opts   DeleteByPrefixOptions
+
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	summary *DeleteByPrefixSummary
+
+	// The first failure, for the error returned by DeleteByPrefix.
+	//
+	// GUARDED_BY(mu)
+	firstErr     error
+	firstErrName string
+
+	// The current delay between batches, which is zero while all is well.
+	//
+	// GUARDED_BY(mu)
+	delay time.Duration
+}
+
+// List the objects with the given prefix, writing them to the supplied channel
+// in groups of up to opts.BatchSize.
+func (d *prefixDeleter) listBatches(
+	ctx context.Context,
+	prefix string,
+	batches chan<- []*gcs.Object) (err error) {
+	req := &gcs.ListObjectsRequest{
+		Prefix: prefix,
+	}
+
+	var batch []*gcs.Object
+	for {
+		var listing *gcs.Listing
+		listing, err = d.bucket.ListObjects(ctx, req)
+		if err != nil {
+			err = fmt.Errorf("ListObjects: %v", err)
+			return
+		}
+
+		// Send each batch as soon as it fills up, and whatever is left at the
+		// end.
+		batch = append(batch, listing.Objects...)
+		final := listing.ContinuationToken == ""
+
+		for len(batch) >= d.opts.BatchSize || (final && len(batch) > 0) {
+			n := d.opts.BatchSize
+			if n > len(batch) {
+				n = len(batch)
+			}
+
+			select {
+			case batches <- batch[:n:n]:
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+
+			batch = batch[n:]
+		}
+
+		if final {
+			return
+		}
+
+		req.ContinuationToken = listing.ContinuationToken
+	}
+}
+
+// Delete the supplied objects, retrying transient failures. Return an error
+// only if the whole operation should stop.
+func (d *prefixDeleter) deleteBatch(
+	ctx context.Context,
+	objects []*gcs.Object) (err error) {
+	for attempt := 1; len(objects) > 0; attempt++ {
+		if err = d.wait(ctx); err != nil {
+			return
+		}
+
+		req := &gcs.BatchRequest{}
+		for _, o := range objects {
+			generation := o.Generation
+			req.Ops = append(req.Ops, gcs.BatchOp{
+				Delete: &gcs.DeleteObjectRequest{
+					Name:                   o.Name,
+					GenerationPrecondition: &generation,
+				},
+			})
+		}
+
+		// If the batch as a whole fails transiently, every delete in it is
+		// retried.
+		var results []gcs.BatchResult
+		results, err = d.bucket.Batch(ctx, req)
+		if err != nil {
+			if !gcs.IsTransient(err) {
+				err = fmt.Errorf("Batch: %v", err)
+				return
+			}
+
+			results = make([]gcs.BatchResult, len(objects))
+			for i := range results {
+				results[i].Err = err
+			}
+
+			err = nil
+		}
+
+		// Record the outcomes, collecting the objects to try again.
+		var retry []*gcs.Object
+		for i, o := range objects {
+			opErr := results[i].Err
+
+			// Someone else got there first.
+			if _, ok := opErr.(*gcs.NotFoundError); ok {
+				opErr = nil
+			}
+
+			if opErr != nil && gcs.IsTransient(opErr) && attempt < d.opts.MaxAttempts {
+				retry = append(retry, o)
+				continue
+			}
+
+			d.finish(o.Name, opErr)
+		}
+
+		d.adjustDelay(len(retry) > 0)
+		objects = retry
+	}
+
+	return
+}
+
+// Sleep for the current delay between batches, if any.
+//
+// LOCKS_EXCLUDED(d.mu)
+func (d *prefixDeleter) wait(ctx context.Context) (err error) {
+	d.mu.Lock()
+	delay := d.delay
+	d.mu.Unlock()
+
+	if delay == 0 {
+		return
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+// Back off exponentially while batches encounter transient errors, and
+// recover gradually once they stop.
+//
+// LOCKS_EXCLUDED(d.mu)
+func (d *prefixDeleter) adjustDelay(throttled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case throttled && d.delay == 0:
+		d.delay = d.opts.InitialDelay
+
+	case throttled:
+		d.delay *= 2
+		if d.delay > d.opts.MaxDelay {
+			d.delay = d.opts.MaxDelay
+		}
+
+	case d.delay < d.opts.InitialDelay:
+		d.delay = 0
+
+	default:
+		d.delay /= 2
+	}
+}
+
+// Record the outcome for a single object.
+//
+// LOCKS_EXCLUDED(d.mu)
+func (d *prefixDeleter) finish(name string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		d.summary.Deleted++
+	} else {
+		d.summary.Failed++
+		if d.firstErr == nil {
+			d.firstErr = err
+			d.firstErrName = name
+		}
+	}
+
+	if d.opts.Progress != nil {
+		d.opts.Progress(name, err)
+	}
+}
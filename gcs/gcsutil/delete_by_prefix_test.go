@@ -0,0 +1,341 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil_test
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcstesting"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestDeleteByPrefix(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that rejects each delete in a call to Batch with
+// *gcs.RateLimitError until that name has been rejected a given number of
+// times, passing the rest on to the wrapped bucket. It records the number of
+// operations in each call to Batch and when the call was made.
+type throttlingBucket struct {
+	gcs.Bucket
+	rejections int
+
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	rejected map[string]int
+
+	// GUARDED_BY(mu)
+	batchSizes []int
+
+	// GUARDED_BY(mu)
+	batchTimes []time.Time
+}
+
+func newThrottlingBucket(
+	wrapped gcs.Bucket,
+	rejections int) (b *throttlingBucket) {
+	b = &throttlingBucket{
+		Bucket:     wrapped,
+		rejections: rejections,
+		rejected:   make(map[string]int),
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(b.mu)
+func (b *throttlingBucket) Batch(
+	ctx context.Context,
+	req *gcs.BatchRequest) (results []gcs.BatchResult, err error) {
+	b.mu.Lock()
+	b.batchSizes = append(b.batchSizes, len(req.Ops))
+	b.batchTimes = append(b.batchTimes, time.Now())
+
+	// Decide which operations to reject.
+	results = make([]gcs.BatchResult, len(req.Ops))
+	passed := &gcs.BatchRequest{}
+	var passedIndices []int
+
+	for i, op := range req.Ops {
+		if op.Delete != nil && b.rejected[op.Delete.Name] < b.rejections {
+			b.rejected[op.Delete.Name]++
+			results[i].Err = &gcs.RateLimitError{Err: errors.New("slow down")}
+			continue
+		}
+
+		passed.Ops = append(passed.Ops, op)
+		passedIndices = append(passedIndices, i)
+	}
+
+	b.mu.Unlock()
+
+	// Pass on the rest.
+	passedResults, err := b.Bucket.Batch(ctx, passed)
+	if err != nil {
+		results = nil
+		return
+	}
+
+	for i, r := range passedResults {
+		results[passedIndices[i]] = r
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type DeleteByPrefixTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &DeleteByPrefixTest{}
+
+func init() { RegisterTestSuite(&DeleteByPrefixTest{}) }
+
+func (t *DeleteByPrefixTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+// Create objects with the given names, returning them in sorted order.
+func (t *DeleteByPrefixTest) createByPrefix(prefix string, n int) (names []string) {
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprintf("%s%04d", prefix, i))
+	}
+
+	AssertEq(nil, gcsutil.CreateEmptyObjects(t.ctx, t.bucket, names))
+	return
+}
+
+// Return the names of the objects in the bucket, in order.
+func (t *DeleteByPrefixTest) listAllNames() (names []string) {
+	objects, _, err := gcsutil.ListAll(t.ctx, t.bucket, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *DeleteByPrefixTest) DeletesOnlyPrefix() {
+	// Create objects under two prefixes.
+	names := t.createByPrefix("foo/", 7)
+	others := t.createByPrefix("bar/", 3)
+
+	// Delete one of them, recording progress.
+	var progress []string
+	summary, err := gcsutil.DeleteByPrefix(
+		t.ctx,
+		t.bucket,
+		"foo/",
+		&gcsutil.DeleteByPrefixOptions{
+			BatchSize: 2,
+			Progress: func(name string, err error) {
+				ExpectEq(nil, err, "Name: %q", name)
+				progress = append(progress, name)
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(len(names), summary.Deleted)
+	ExpectEq(0, summary.Failed)
+
+	sort.Strings(progress)
+	ExpectThat(progress, DeepEquals(names))
+
+	// Only the other prefix remains.
+	ExpectThat(t.listAllNames(), DeepEquals(others))
+}
+
+func (t *DeleteByPrefixTest) Empty() {
+	summary, err := gcsutil.DeleteByPrefix(t.ctx, t.bucket, "foo/", nil)
+
+	AssertEq(nil, err)
+	ExpectEq(0, summary.Deleted)
+	ExpectEq(0, summary.Failed)
+}
+
+func (t *DeleteByPrefixTest) LargeBatches() {
+	// Batch sizes beyond the limit for a single round trip to GCS should be
+	// passed on to the bucket whole, for it to split up.
+	const numObjects = 250
+	t.createByPrefix("foo/", numObjects)
+
+	bucket := newThrottlingBucket(t.bucket, 0)
+	summary, err := gcsutil.DeleteByPrefix(
+		t.ctx,
+		bucket,
+		"",
+		&gcsutil.DeleteByPrefixOptions{
+			BatchSize: numObjects,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(numObjects, summary.Deleted)
+	ExpectThat(bucket.batchSizes, ElementsAre(numObjects))
+	ExpectThat(t.listAllNames(), ElementsAre())
+}
+
+func (t *DeleteByPrefixTest) DefaultBatchSize() {
+	const numObjects = 2*gcsutil.DefaultDeleteBatchSize + 50
+	t.createByPrefix("foo/", numObjects)
+
+	bucket := newThrottlingBucket(t.bucket, 0)
+	summary, err := gcsutil.DeleteByPrefix(t.ctx, bucket, "", nil)
+
+	AssertEq(nil, err)
+	ExpectEq(numObjects, summary.Deleted)
+
+	sort.Ints(bucket.batchSizes)
+	ExpectThat(
+		bucket.batchSizes,
+		ElementsAre(
+			50,
+			gcsutil.DefaultDeleteBatchSize,
+			gcsutil.DefaultDeleteBatchSize))
+
+	ExpectThat(t.listAllNames(), ElementsAre())
+}
+
+func (t *DeleteByPrefixTest) TransientOpFailuresRetried() {
+	names := t.createByPrefix("foo/", 5)
+
+	// Reject each delete three times, backing off from 20ms to at most 50ms.
+	const initialDelay = 20 * time.Millisecond
+	const maxDelay = 50 * time.Millisecond
+
+	bucket := newThrottlingBucket(t.bucket, 3)
+	var progress []string
+	summary, err := gcsutil.DeleteByPrefix(
+		t.ctx,
+		bucket,
+		"foo/",
+		&gcsutil.DeleteByPrefixOptions{
+			Parallelism:  1,
+			MaxAttempts:  4,
+			InitialDelay: initialDelay,
+			MaxDelay:     maxDelay,
+			Progress: func(name string, err error) {
+				ExpectEq(nil, err, "Name: %q", name)
+				progress = append(progress, name)
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(len(names), summary.Deleted)
+	ExpectEq(0, summary.Failed)
+
+	sort.Strings(progress)
+	ExpectThat(progress, DeepEquals(names))
+	ExpectThat(t.listAllNames(), ElementsAre())
+
+	// Each attempt should have waited for the delay, which doubles each time
+	// until it reaches the maximum.
+	AssertThat(bucket.batchSizes, ElementsAre(5, 5, 5, 5))
+
+	times := bucket.batchTimes
+	ExpectGe(times[1].Sub(times[0]), initialDelay)
+	ExpectGe(times[2].Sub(times[1]), 2*initialDelay)
+	ExpectGe(times[3].Sub(times[2]), maxDelay)
+}
+
+func (t *DeleteByPrefixTest) GivesUpAfterMaxAttempts() {
+	names := t.createByPrefix("foo/", 3)
+
+	bucket := newThrottlingBucket(t.bucket, 10)
+	var failed []string
+	summary, err := gcsutil.DeleteByPrefix(
+		t.ctx,
+		bucket,
+		"foo/",
+		&gcsutil.DeleteByPrefixOptions{
+			MaxAttempts:  2,
+			InitialDelay: time.Millisecond,
+			Progress: func(name string, err error) {
+				ExpectThat(err, HasSameTypeAs(&gcs.RateLimitError{}))
+				failed = append(failed, name)
+			},
+		})
+
+	ExpectThat(err, Error(HasSubstr("Failed to delete 3 objects")))
+	ExpectThat(err, Error(HasSubstr("slow down")))
+	ExpectEq(0, summary.Deleted)
+	ExpectEq(len(names), summary.Failed)
+
+	sort.Strings(failed)
+	ExpectThat(failed, DeepEquals(names))
+
+	// Each delete was tried exactly twice, and the objects are still there.
+	for _, name := range names {
+		ExpectEq(2, bucket.rejected[name], "Name: %q", name)
+	}
+
+	ExpectThat(t.listAllNames(), DeepEquals(names))
+}
+
+func (t *DeleteByPrefixTest) TransientBatchFailuresRetried() {
+	t.createByPrefix("foo/", 4)
+
+	// The first two calls to Batch fail as a whole.
+	bucket := gcstesting.NewFlakyBucket(
+		t.bucket,
+		gcstesting.FlakyBucketConfig{
+			Rules: []gcstesting.FaultRule{
+				{Fault: gcstesting.FaultUnavailable, Method: "Batch", Calls: []int{1, 2}},
+			},
+		})
+
+	summary, err := gcsutil.DeleteByPrefix(
+		t.ctx,
+		bucket,
+		"foo/",
+		&gcsutil.DeleteByPrefixOptions{
+			InitialDelay: time.Millisecond,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(2, bucket.InjectedFaults())
+	ExpectEq(4, summary.Deleted)
+	ExpectEq(0, summary.Failed)
+	ExpectThat(t.listAllNames(), ElementsAre())
+}
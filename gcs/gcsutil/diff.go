@@ -0,0 +1,142 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// The differences found by Diff.
+type DiffResult struct {
+	// The names of objects present in only one of the buckets, in order.
+	OnlyInA []string
+	OnlyInB []string
+
+	// Objects present in both buckets whose contents differ, in name order.
+	Mismatched []DiffMismatch
+
+	// The number of objects present in both buckets with the same contents.
+	Matched int64
+}
+
+// An object whose contents differ between the buckets compared by Diff.
+type DiffMismatch struct {
+	// The records for the object in each bucket.
+	A *gcs.Object
+	B *gcs.Object
+
+	// A description of the difference, e.g. "size 4 vs. 5".
+	Reason string
+}
+
+// Compare the objects whose names begin with the given prefix in buckets a
+// and b, for example to verify replication or sign off on a migration. Both
+// listings are walked in step, so memory use is proportional to the number of
+// differences rather than the number of objects.
+//
+// Objects with the same name are considered to match if they have the same
+// size and checksums; their contents aren't read. MD5s are compared only if
+// both objects have one (composite objects don't), and likewise CRC32Cs only
+// if neither is zero, since S3-compatible buckets report zero for objects
+// whose checksum they don't know.
+func Diff(
+	ctx context.Context,
+	a gcs.Bucket,
+	b gcs.Bucket,
+	prefix string) (result *DiffResult, err error) {
+	req := &gcs.ListObjectsRequest{Prefix: prefix}
+	itA := NewObjectIterator(a, req)
+	itB := NewObjectIterator(b, req)
+
+	result = &DiffResult{}
+
+	oA, err := itA.Next(ctx)
+	if err != nil {
+		err = fmt.Errorf("Listing a: %v", err)
+		return
+	}
+
+	oB, err := itB.Next(ctx)
+	if err != nil {
+		err = fmt.Errorf("Listing b: %v", err)
+		return
+	}
+
+	// Merge the two listings, which GCS returns in name order.
+	for oA != nil || oB != nil {
+		advanceA := false
+		advanceB := false
+
+		switch {
+		case oB == nil || (oA != nil && oA.Name < oB.Name):
+			result.OnlyInA = append(result.OnlyInA, oA.Name)
+			advanceA = true
+
+		case oA == nil || oB.Name < oA.Name:
+			result.OnlyInB = append(result.OnlyInB, oB.Name)
+			advanceB = true
+
+		default:
+			if reason := compareContents(oA, oB); reason != "" {
+				result.Mismatched = append(result.Mismatched, DiffMismatch{
+					A:      oA,
+					B:      oB,
+					Reason: reason,
+				})
+			} else {
+				result.Matched++
+			}
+
+			advanceA = true
+			advanceB = true
+		}
+
+		if advanceA {
+			if oA, err = itA.Next(ctx); err != nil {
+				err = fmt.Errorf("Listing a: %v", err)
+				return
+			}
+		}
+
+		if advanceB {
+			if oB, err = itB.Next(ctx); err != nil {
+				err = fmt.Errorf("Listing b: %v", err)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// Return a description of how the contents of the supplied objects differ, or
+// the empty string if they appear to be the same.
+func compareContents(a *gcs.Object, b *gcs.Object) (reason string) {
+	switch {
+	case a.Size != b.Size:
+		reason = fmt.Sprintf("size %d vs. %d", a.Size, b.Size)
+
+	case a.CRC32C != 0 && b.CRC32C != 0 && a.CRC32C != b.CRC32C:
+		reason = fmt.Sprintf("CRC32C 0x%08x vs. 0x%08x", a.CRC32C, b.CRC32C)
+
+	case a.MD5 != nil && b.MD5 != nil && *a.MD5 != *b.MD5:
+		reason = fmt.Sprintf("MD5 %x vs. %x", *a.MD5, *b.MD5)
+	}
+
+	return
+}
@@ -0,0 +1,288 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestDiff(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A bucket whose listings leave out checksums, as an S3-compatible bucket's
+// may.
+type checksumlessBucket struct {
+	gcs.Bucket
+	noCRC32C bool
+	noMD5    bool
+}
+
+func (b *checksumlessBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	listing, err = b.Bucket.ListObjects(ctx, req)
+	if err != nil {
+		return
+	}
+
+	for i, o := range listing.Objects {
+		stripped := *o
+		if b.noCRC32C {
+			stripped.CRC32C = 0
+		}
+
+		if b.noMD5 {
+			stripped.MD5 = nil
+		}
+
+		listing.Objects[i] = &stripped
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type DiffTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &DiffTest{}
+
+func init() { RegisterTestSuite(&DiffTest{}) }
+
+func (t *DiffTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+// Create an empty fake bucket to compare with the one under test.
+func (t *DiffTest) newOtherBucket() (b gcs.Bucket) {
+	b = gcsfake.NewFakeBucket(&t.clock, "other")
+	return
+}
+
+// Create objects with the given names and contents in the supplied bucket.
+func (t *DiffTest) populate(b gcs.Bucket, contents map[string]string) {
+	for name, c := range contents {
+		_, err := gcsutil.CreateObject(t.ctx, b, name, []byte(c))
+		AssertEq(nil, err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *DiffTest) EmptyBuckets() {
+	other := t.newOtherBucket()
+
+	result, err := gcsutil.Diff(t.ctx, t.bucket, other, "")
+	AssertEq(nil, err)
+	ExpectThat(result.OnlyInA, ElementsAre())
+	ExpectThat(result.OnlyInB, ElementsAre())
+	ExpectThat(result.Mismatched, ElementsAre())
+	ExpectEq(0, result.Matched)
+
+	// Everything is missing from an empty bucket.
+	t.populate(t.bucket, map[string]string{"a": "taco", "b": "burrito"})
+
+	result, err = gcsutil.Diff(t.ctx, t.bucket, other, "")
+	AssertEq(nil, err)
+	ExpectThat(result.OnlyInA, ElementsAre("a", "b"))
+	ExpectThat(result.OnlyInB, ElementsAre())
+
+	result, err = gcsutil.Diff(t.ctx, other, t.bucket, "")
+	AssertEq(nil, err)
+	ExpectThat(result.OnlyInA, ElementsAre())
+	ExpectThat(result.OnlyInB, ElementsAre("a", "b"))
+}
+
+func (t *DiffTest) MissingObjects() {
+	// Each bucket has objects missing from the other at the start, in the
+	// middle, and at the end.
+	other := t.newOtherBucket()
+	t.populate(t.bucket, map[string]string{
+		"a": "",
+		"c": "taco",
+		"d": "",
+		"f": "burrito",
+		"z": "",
+	})
+
+	t.populate(other, map[string]string{
+		"b": "",
+		"c": "taco",
+		"e": "",
+		"f": "burrito",
+		"y": "",
+	})
+
+	result, err := gcsutil.Diff(t.ctx, t.bucket, other, "")
+	AssertEq(nil, err)
+	ExpectThat(result.OnlyInA, ElementsAre("a", "d", "z"))
+	ExpectThat(result.OnlyInB, ElementsAre("b", "e", "y"))
+	ExpectThat(result.Mismatched, ElementsAre())
+	ExpectEq(2, result.Matched)
+
+	result, err = gcsutil.Diff(t.ctx, other, t.bucket, "")
+	AssertEq(nil, err)
+	ExpectThat(result.OnlyInA, ElementsAre("b", "e", "y"))
+	ExpectThat(result.OnlyInB, ElementsAre("a", "d", "z"))
+	ExpectEq(2, result.Matched)
+}
+
+func (t *DiffTest) Mismatched() {
+	other := t.newOtherBucket()
+	t.populate(t.bucket, map[string]string{
+		"a": "taco",
+		"b": "taco",
+		"c": "taco",
+	})
+
+	t.populate(other, map[string]string{
+		"a": "taco",
+		"b": "tacos",
+		"c": "toco",
+	})
+
+	result, err := gcsutil.Diff(t.ctx, t.bucket, other, "")
+	AssertEq(nil, err)
+	ExpectThat(result.OnlyInA, ElementsAre())
+	ExpectThat(result.OnlyInB, ElementsAre())
+	ExpectEq(1, result.Matched)
+
+	AssertEq(2, len(result.Mismatched))
+
+	m := result.Mismatched[0]
+	ExpectEq("b", m.A.Name)
+	ExpectEq("b", m.B.Name)
+	ExpectEq("size 4 vs. 5", m.Reason)
+
+	m = result.Mismatched[1]
+	ExpectEq("c", m.A.Name)
+	ExpectEq("c", m.B.Name)
+	ExpectThat(m.Reason, HasSubstr("CRC32C"))
+}
+
+func (t *DiffTest) MissingChecksums() {
+	t.populate(t.bucket, map[string]string{"a": "taco"})
+
+	testCases := []struct {
+		noCRC32C bool
+		noMD5    bool
+		reason   string
+	}{
+		// With one checksum missing, the other is still compared.
+		{false, true, "CRC32C"},
+		{true, false, "MD5"},
+
+		// With neither, objects of the same size can't be told apart.
+		{true, true, ""},
+	}
+
+	for _, tc := range testCases {
+		desc := fmt.Sprintf("noCRC32C: %v, noMD5: %v", tc.noCRC32C, tc.noMD5)
+
+		other := t.newOtherBucket()
+		t.populate(other, map[string]string{"a": "toco"})
+
+		result, err := gcsutil.Diff(
+			t.ctx,
+			t.bucket,
+			&checksumlessBucket{other, tc.noCRC32C, tc.noMD5},
+			"")
+
+		AssertEq(nil, err)
+
+		if tc.reason == "" {
+			ExpectThat(result.Mismatched, ElementsAre(), desc)
+			ExpectEq(1, result.Matched, desc)
+			continue
+		}
+
+		AssertEq(1, len(result.Mismatched), desc)
+		ExpectThat(result.Mismatched[0].Reason, HasSubstr(tc.reason), desc)
+		ExpectEq(0, result.Matched, desc)
+	}
+}
+
+func (t *DiffTest) MultiplePages() {
+	other := t.newOtherBucket()
+
+	// Lay out objects under a prefix so that the differences fall on and
+	// around page boundaries, with some outside the prefix to be ignored.
+	contentsA := map[string]string{"before": "", "zzz": ""}
+	contentsB := map[string]string{"before": "x", "zzz": "y"}
+
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("dir/%02d", i)
+		switch {
+		case i%7 == 0:
+			contentsA[name] = "taco"
+
+		case i%5 == 0:
+			contentsB[name] = "taco"
+
+		case i%4 == 0:
+			contentsA[name] = "taco"
+			contentsB[name] = "burrito"
+
+		default:
+			contentsA[name] = name
+			contentsB[name] = name
+		}
+	}
+
+	t.populate(t.bucket, contentsA)
+	t.populate(other, contentsB)
+
+	result, err := gcsutil.Diff(
+		t.ctx,
+		&pagingBucket{t.bucket, 3},
+		&pagingBucket{other, 2},
+		"dir/")
+
+	AssertEq(nil, err)
+	ExpectThat(result.OnlyInA, ElementsAre("dir/00", "dir/07", "dir/14"))
+	ExpectThat(result.OnlyInB, ElementsAre("dir/05", "dir/10", "dir/15"))
+
+	var mismatched []string
+	for _, m := range result.Mismatched {
+		mismatched = append(mismatched, m.A.Name)
+	}
+
+	ExpectThat(mismatched, ElementsAre("dir/04", "dir/08", "dir/12", "dir/16"))
+	ExpectEq(20-3-3-4, result.Matched)
+}
@@ -0,0 +1,243 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Formats for inventories, for InventoryRequest.Format.
+const (
+	// Comma-separated values, with a header row naming the columns.
+	InventoryFormatCSV = "csv"
+
+	// One JSON object per line.
+	InventoryFormatJSON = "json"
+)
+
+// The columns of a CSV inventory.
+var inventoryColumns = []string{
+	"name",
+	"size",
+	"generation",
+	"crc32c",
+	"storage_class",
+	"updated",
+}
+
+// A request to write an inventory of a bucket, accepted by WriteInventory and
+// ExportInventory.
+type InventoryRequest struct {
+	// If non-empty, only objects whose names begin with this are included.
+	Prefix string
+
+	// InventoryFormatCSV or InventoryFormatJSON. If empty, CSV is used.
+	Format string
+
+	// If non-empty, a cursor passed to the Checkpoint function of an earlier
+	// call with the same Prefix, from which to pick up where that call left
+	// off. The CSV header row is written only when starting from the
+	// beginning.
+	Cursor string
+
+	// If non-nil, called with a cursor each time a page of the listing has
+	// been written and flushed, so that a long walk of a very large bucket can
+	// be resumed from there. The cursor is empty after the last page. If it
+	// returns an error, the walk stops with that error.
+	Checkpoint func(cursor string) error
+}
+
+// A summary of the objects included in an inventory.
+type InventorySummary struct {
+	Objects int64
+	Bytes   uint64
+}
+
+// An entry in a JSON inventory.
+type inventoryEntry struct {
+	Name         string    `json:"name"`
+	Size         uint64    `json:"size"`
+	Generation   int64     `json:"generation"`
+	CRC32C       string    `json:"crc32c"`
+	StorageClass string    `json:"storageClass"`
+	Updated      time.Time `json:"updated"`
+}
+
+// Walk the objects in the bucket in name order, writing a manifest of their
+// name, size, generation, CRC32C, storage class, and update time to w in the
+// requested format. CRC32Cs are base64-encoded big-endian, as in the JSON
+// API, and times are in RFC 3339 format.
+func WriteInventory(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	w io.Writer,
+	req *InventoryRequest) (summary *InventorySummary, err error) {
+	bw := bufio.NewWriter(w)
+
+	var writeEntry func(o *gcs.Object) error
+	switch req.Format {
+	case "", InventoryFormatCSV:
+		cw := csv.NewWriter(bw)
+		writeEntry = func(o *gcs.Object) (err error) {
+			err = cw.Write([]string{
+				o.Name,
+				strconv.FormatUint(o.Size, 10),
+				strconv.FormatInt(o.Generation, 10),
+				formatCRC32C(o.CRC32C),
+				o.StorageClass,
+				o.Updated.UTC().Format(time.RFC3339Nano),
+			})
+
+			// Make sure that everything reaches bw before each flush of it.
+			cw.Flush()
+			if err == nil {
+				err = cw.Error()
+			}
+
+			return
+		}
+
+		if req.Cursor == "" {
+			err = cw.Write(inventoryColumns)
+			cw.Flush()
+			if err == nil {
+				err = cw.Error()
+			}
+
+			if err != nil {
+				err = fmt.Errorf("Writing header: %v", err)
+				return
+			}
+		}
+
+	case InventoryFormatJSON:
+		enc := json.NewEncoder(bw)
+		writeEntry = func(o *gcs.Object) error {
+			return enc.Encode(&inventoryEntry{
+				Name:         o.Name,
+				Size:         o.Size,
+				Generation:   o.Generation,
+				CRC32C:       formatCRC32C(o.CRC32C),
+				StorageClass: o.StorageClass,
+				Updated:      o.Updated.UTC(),
+			})
+		}
+
+	default:
+		err = fmt.Errorf("Unknown Format: %q", req.Format)
+		return
+	}
+
+	summary = &InventorySummary{}
+	listReq := &gcs.ListObjectsRequest{
+		Prefix:            req.Prefix,
+		ContinuationToken: req.Cursor,
+	}
+
+	for {
+		var listing *gcs.Listing
+		listing, err = bucket.ListObjects(ctx, listReq)
+		if err != nil {
+			err = fmt.Errorf("ListObjects: %v", err)
+			return
+		}
+
+		for _, o := range listing.Objects {
+			if err = writeEntry(o); err != nil {
+				err = fmt.Errorf("Writing %q: %v", o.Name, err)
+				return
+			}
+
+			summary.Objects++
+			summary.Bytes += o.Size
+		}
+
+		// Make sure the page has been written before saying so.
+		if err = bw.Flush(); err != nil {
+			err = fmt.Errorf("Flush: %v", err)
+			return
+		}
+
+		if req.Checkpoint != nil {
+			if err = req.Checkpoint(listing.ContinuationToken); err != nil {
+				return
+			}
+		}
+
+		if listing.ContinuationToken == "" {
+			break
+		}
+
+		listReq.ContinuationToken = listing.ContinuationToken
+	}
+
+	return
+}
+
+// Like WriteInventory, but write the inventory to a new object with the given
+// name in dst, which may be the same bucket. Nothing is committed until the
+// walk is complete, so to resume after a failure, export the rest to another
+// object starting from the last cursor and compose the pieces.
+func ExportInventory(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	req *InventoryRequest,
+	dst gcs.Bucket,
+	name string) (o *gcs.Object, summary *InventorySummary, err error) {
+	contentType := "text/csv"
+	if req.Format == InventoryFormatJSON {
+		contentType = "application/x-ndjson"
+	}
+
+	w := gcs.NewObjectWriter(
+		ctx,
+		dst,
+		&gcs.CreateObjectRequest{
+			Name:        name,
+			ContentType: contentType,
+		})
+
+	summary, err = WriteInventory(ctx, bucket, w, req)
+	if err != nil {
+		w.Abort(err)
+		return
+	}
+
+	if err = w.Close(); err != nil {
+		err = fmt.Errorf("CreateObject: %v", err)
+		return
+	}
+
+	o = w.Object()
+	return
+}
+
+// Format a CRC32C as the JSON API does.
+func formatCRC32C(crc32c uint32) string {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], crc32c)
+	return base64.StdEncoding.EncodeToString(buf[:])
+}
@@ -0,0 +1,361 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestInventory(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A writer that always fails.
+type failingWriter struct {
+}
+
+func (w *failingWriter) Write(p []byte) (n int, err error) {
+	err = errors.New("taco")
+	return
+}
+
+var inventoryHeader = []string{
+	"name",
+	"size",
+	"generation",
+	"crc32c",
+	"storage_class",
+	"updated",
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type InventoryTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &InventoryTest{}
+
+func init() { RegisterTestSuite(&InventoryTest{}) }
+
+func (t *InventoryTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+func (t *InventoryTest) createObject(name string, contents string) (err error) {
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, name, []byte(contents))
+	return
+}
+
+// Return the contents of the object with the given name.
+func (t *InventoryTest) readObject(name string) (contents string, err error) {
+	b, err := gcsutil.ReadObject(t.ctx, t.bucket, name)
+	contents = string(b)
+	return
+}
+
+// Return the CSV rows that gcsutil.WriteInventory should write for the
+// objects in the bucket with the given prefix, without the header.
+func (t *InventoryTest) inventoryRows(prefix string) (rows [][]string) {
+	objects, _, err := gcsutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{Prefix: prefix})
+
+	AssertEq(nil, err)
+
+	for _, o := range objects {
+		var crc32c [4]byte
+		binary.BigEndian.PutUint32(crc32c[:], o.CRC32C)
+
+		rows = append(rows, []string{
+			o.Name,
+			fmt.Sprint(o.Size),
+			fmt.Sprint(o.Generation),
+			base64.StdEncoding.EncodeToString(crc32c[:]),
+			o.StorageClass,
+			o.Updated.UTC().Format(time.RFC3339Nano),
+		})
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *InventoryTest) WriteInventory_CSV() {
+	AssertEq(nil, t.createObject("a", "taco"))
+	AssertEq(nil, t.createObject("b/c", "burrito"))
+	AssertEq(nil, t.createObject("b/d", ""))
+	AssertEq(nil, t.createObject("e", "enchilada"))
+
+	testCases := []struct {
+		prefix  string
+		objects int64
+		bytes   uint64
+	}{
+		{"", 4, 20},
+		{"b/", 2, 7},
+		{"z", 0, 0},
+	}
+
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		summary, err := gcsutil.WriteInventory(
+			t.ctx,
+			t.bucket,
+			&buf,
+			&gcsutil.InventoryRequest{Prefix: tc.prefix})
+
+		AssertEq(nil, err)
+		ExpectEq(tc.objects, summary.Objects, "Prefix: %q", tc.prefix)
+		ExpectEq(tc.bytes, summary.Bytes, "Prefix: %q", tc.prefix)
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		AssertEq(nil, err)
+
+		expected := append([][]string{inventoryHeader}, t.inventoryRows(tc.prefix)...)
+		ExpectThat(rows, DeepEquals(expected), "Prefix: %q", tc.prefix)
+	}
+}
+
+func (t *InventoryTest) WriteInventory_JSON() {
+	AssertEq(nil, t.createObject("a", "taco"))
+	AssertEq(nil, t.createObject("b", "burrito"))
+
+	var buf bytes.Buffer
+	summary, err := gcsutil.WriteInventory(
+		t.ctx,
+		t.bucket,
+		&buf,
+		&gcsutil.InventoryRequest{Format: gcsutil.InventoryFormatJSON})
+
+	AssertEq(nil, err)
+	ExpectEq(2, summary.Objects)
+	ExpectEq(len("taco")+len("burrito"), summary.Bytes)
+
+	// Each line should be an object holding the same fields as a CSV row.
+	var actual [][]string
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry struct {
+			Name         string    `json:"name"`
+			Size         uint64    `json:"size"`
+			Generation   int64     `json:"generation"`
+			CRC32C       string    `json:"crc32c"`
+			StorageClass string    `json:"storageClass"`
+			Updated      time.Time `json:"updated"`
+		}
+
+		AssertEq(nil, dec.Decode(&entry))
+		actual = append(actual, []string{
+			entry.Name,
+			fmt.Sprint(entry.Size),
+			fmt.Sprint(entry.Generation),
+			entry.CRC32C,
+			entry.StorageClass,
+			entry.Updated.UTC().Format(time.RFC3339Nano),
+		})
+	}
+
+	ExpectThat(actual, DeepEquals(t.inventoryRows("")))
+}
+
+func (t *InventoryTest) WriteInventory_UnknownFormat() {
+	_, err := gcsutil.WriteInventory(
+		t.ctx,
+		t.bucket,
+		&bytes.Buffer{},
+		&gcsutil.InventoryRequest{Format: "xml"})
+
+	ExpectThat(err, Error(HasSubstr("Unknown Format")))
+}
+
+func (t *InventoryTest) WriteInventory_WriterFails() {
+	// Even the header of an inventory of an empty bucket can't be written.
+	_, err := gcsutil.WriteInventory(
+		t.ctx,
+		t.bucket,
+		&failingWriter{},
+		&gcsutil.InventoryRequest{})
+
+	ExpectThat(err, Error(HasSubstr("taco")))
+
+	// Nor can an object.
+	AssertEq(nil, t.createObject("a", "burrito"))
+	_, err = gcsutil.WriteInventory(
+		t.ctx,
+		t.bucket,
+		&failingWriter{},
+		&gcsutil.InventoryRequest{Format: gcsutil.InventoryFormatJSON})
+
+	ExpectThat(err, Error(HasSubstr("taco")))
+}
+
+func (t *InventoryTest) WriteInventory_Resume() {
+	const numObjects = 7
+	for i := 0; i < numObjects; i++ {
+		AssertEq(nil, t.createObject(fmt.Sprintf("obj%d", i), fmt.Sprint(i)))
+	}
+
+	// Walk in pages of three, stopping after the first page.
+	bucket := &pagingBucket{t.bucket, 3}
+	stop := errors.New("stop")
+
+	var first bytes.Buffer
+	var cursor string
+	summary, err := gcsutil.WriteInventory(
+		t.ctx,
+		bucket,
+		&first,
+		&gcsutil.InventoryRequest{
+			Checkpoint: func(c string) error {
+				cursor = c
+				return stop
+			},
+		})
+
+	AssertEq(stop, err)
+	AssertNe("", cursor)
+	ExpectEq(3, summary.Objects)
+
+	// Pick up where we left off. There should be no second header row, and a
+	// checkpoint for each remaining page, the last with an empty cursor.
+	var rest bytes.Buffer
+	var cursors []string
+	summary, err = gcsutil.WriteInventory(
+		t.ctx,
+		bucket,
+		&rest,
+		&gcsutil.InventoryRequest{
+			Cursor: cursor,
+			Checkpoint: func(c string) error {
+				cursors = append(cursors, c)
+				return nil
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(numObjects-3, summary.Objects)
+	AssertEq(2, len(cursors))
+	ExpectNe("", cursors[0])
+	ExpectEq("", cursors[1])
+
+	firstRows, err := csv.NewReader(&first).ReadAll()
+	AssertEq(nil, err)
+
+	restRows, err := csv.NewReader(&rest).ReadAll()
+	AssertEq(nil, err)
+
+	AssertEq(1+3, len(firstRows))
+	ExpectThat(firstRows[0], DeepEquals(inventoryHeader))
+
+	// Together the two make up the whole inventory.
+	ExpectThat(
+		append(firstRows[1:], restRows...),
+		DeepEquals(t.inventoryRows("")))
+}
+
+func (t *InventoryTest) ExportInventory() {
+	AssertEq(nil, t.createObject("objects/a", "taco"))
+	AssertEq(nil, t.createObject("objects/b", "burrito"))
+
+	// Export each format to the same bucket.
+	testCases := []struct {
+		format      string
+		name        string
+		contentType string
+	}{
+		{gcsutil.InventoryFormatCSV, "inventory.csv", "text/csv"},
+		{gcsutil.InventoryFormatJSON, "inventory.json", "application/x-ndjson"},
+	}
+
+	for _, tc := range testCases {
+		req := &gcsutil.InventoryRequest{
+			Prefix: "objects/",
+			Format: tc.format,
+		}
+
+		o, summary, err := gcsutil.ExportInventory(
+			t.ctx,
+			t.bucket,
+			req,
+			t.bucket,
+			tc.name)
+
+		AssertEq(nil, err)
+		ExpectEq(tc.name, o.Name)
+		ExpectEq(tc.contentType, o.ContentType)
+		ExpectEq(2, summary.Objects)
+
+		// The contents should be what WriteInventory writes.
+		var expected bytes.Buffer
+		_, err = gcsutil.WriteInventory(t.ctx, t.bucket, &expected, req)
+		AssertEq(nil, err)
+
+		contents, err := t.readObject(tc.name)
+		AssertEq(nil, err)
+		ExpectEq(expected.String(), contents, "Format: %q", tc.format)
+	}
+}
+
+func (t *InventoryTest) ExportInventory_Fails() {
+	AssertEq(nil, t.createObject("a", "taco"))
+
+	_, _, err := gcsutil.ExportInventory(
+		t.ctx,
+		t.bucket,
+		&gcsutil.InventoryRequest{
+			Checkpoint: func(string) error { return errors.New("taco") },
+		},
+		t.bucket,
+		"inventory")
+
+	ExpectThat(err, Error(HasSubstr("taco")))
+
+	// Nothing was committed.
+	_, err = t.bucket.StatObject(
+		t.ctx,
+		&gcs.StatObjectRequest{Name: "inventory"})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
@@ -0,0 +1,133 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// An entry in a filesystem-style view of a bucket, as returned by ListDir.
+type DirEntry struct {
+	// The entry's name within its directory. For subdirectories this doesn't
+	// include the trailing slash.
+	Name string
+
+	// True if the entry is a subdirectory, i.e. there is at least one object
+	// whose name begins with the directory's name followed by a slash.
+	IsDir bool
+
+	// For files, the object itself. For subdirectories, the placeholder object
+	// whose name is the directory's name followed by a slash, or nil if there
+	// is none and the directory is only implied by the objects within it.
+	Object *gcs.Object
+}
+
+// List the immediate contents of the directory dir, treating '/' as the path
+// separator. dir must be empty to list the root, or else end with a slash.
+// Entries are returned in order of the object names they correspond to.
+//
+// Several situations that filesystem-style consumers must otherwise deal with
+// are taken care of:
+//
+//  *  A placeholder object for dir itself (named exactly dir) is not reported.
+//
+//  *  Placeholder objects for subdirectories are attached to the
+//     subdirectory's entry rather than reported as files with empty names.
+//
+//  *  Subdirectories are reported whether or not they have placeholders.
+//
+//  *  Names with an empty path component immediately after dir (e.g.
+//     "dir//foo"), which can't be represented in a filesystem, are skipped.
+//
+// Note that a file and a subdirectory may share a name, if there are objects
+// named both "dir/foo" and "dir/foo/bar". In that case both entries are
+// returned, with the file first.
+func ListDir(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	dir string) (entries []DirEntry, err error) {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		err = fmt.Errorf("Directory name %q doesn't end with a slash", dir)
+		return
+	}
+
+	req := &gcs.ListObjectsRequest{
+		Prefix:                   dir,
+		Delimiter:                "/",
+		IncludeTrailingDelimiter: true,
+	}
+
+	objects, runs, err := ListAll(ctx, bucket, req)
+	if err != nil {
+		err = fmt.Errorf("ListAll: %v", err)
+		return
+	}
+
+	// Index the placeholder objects for subdirectories. The bucket may or may
+	// not have returned them in the same listing as the corresponding run.
+	placeholders := make(map[string]*gcs.Object)
+	for _, o := range objects {
+		if strings.HasSuffix(o.Name, "/") {
+			placeholders[o.Name] = o
+		}
+	}
+
+	// Files.
+	for _, o := range objects {
+		if o.Name == dir || strings.HasSuffix(o.Name, "/") {
+			continue
+		}
+
+		entries = append(entries, DirEntry{
+			Name:   strings.TrimPrefix(o.Name, dir),
+			Object: o,
+		})
+	}
+
+	// Subdirectories.
+	for _, run := range runs {
+		if run == dir+"/" {
+			continue
+		}
+
+		entries = append(entries, DirEntry{
+			Name:   strings.TrimSuffix(strings.TrimPrefix(run, dir), "/"),
+			IsDir:  true,
+			Object: placeholders[run],
+		})
+	}
+
+	// Put everything back in order of object name. Because directories'
+	// object names have the trailing slash, a file sorts before a directory of
+	// the same name.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entryKey(entries[i]) < entryKey(entries[j])
+	})
+
+	return
+}
+
+func entryKey(e DirEntry) string {
+	if e.IsDir {
+		return e.Name + "/"
+	}
+
+	return e.Name
+}
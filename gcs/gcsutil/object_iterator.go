@@ -0,0 +1,86 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// ObjectIterator yields the objects matched by a ListObjectsRequest one at a
+// time, calling ListObjects to fetch further pages of results as necessary.
+// Collapsed runs are not yielded; use ListAll if you need them.
+//
+// An ObjectIterator is not safe for concurrent use.
+type ObjectIterator struct {
+	bucket gcs.Bucket
+	req    gcs.ListObjectsRequest
+
+	// Objects from the current page not yet returned by Next.
+	buffered []*gcs.Object
+
+	// Set when the bucket has said there are no further pages.
+	lastPage bool
+}
+
+// Create an iterator over the objects in the bucket that match the supplied
+// request. *req is not modified; the iterator starts from req's continuation
+// token, if any.
+func NewObjectIterator(
+	bucket gcs.Bucket,
+	req *gcs.ListObjectsRequest) (it *ObjectIterator) {
+	it = &ObjectIterator{
+		bucket: bucket,
+		req:    *req,
+	}
+
+	return
+}
+
+// Return the next object in the listing, or nil if there are no more. The
+// context is consulted before each new page is fetched, so cancelling it stops
+// the iteration at the next page boundary.
+func (it *ObjectIterator) Next(ctx context.Context) (o *gcs.Object, err error) {
+	// Fetch pages until we have something to return. Pages may legitimately be
+	// empty, for example when they consist only of collapsed runs.
+	for len(it.buffered) == 0 {
+		if it.lastPage {
+			return
+		}
+
+		// Cancelled?
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var listing *gcs.Listing
+		listing, err = it.bucket.ListObjects(ctx, &it.req)
+		if err != nil {
+			err = fmt.Errorf("ListObjects: %v", err)
+			return
+		}
+
+		it.buffered = listing.Objects
+		it.req.ContinuationToken = listing.ContinuationToken
+		it.lastPage = listing.ContinuationToken == ""
+	}
+
+	o = it.buffered[0]
+	it.buffered = it.buffered[1:]
+
+	return
+}
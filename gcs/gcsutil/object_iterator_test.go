@@ -0,0 +1,205 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestObjectIterator(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that returns listings at most a few objects at a time, however
+// many the caller asks for, to exercise code that follows continuation
+// tokens.
+type pagingBucket struct {
+	gcs.Bucket
+	pageSize int
+}
+
+func (b *pagingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	limited := *req
+	if limited.MaxResults == 0 || limited.MaxResults > b.pageSize {
+		limited.MaxResults = b.pageSize
+	}
+
+	listing, err = b.Bucket.ListObjects(ctx, &limited)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ObjectIteratorTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &ObjectIteratorTest{}
+
+func init() { RegisterTestSuite(&ObjectIteratorTest{}) }
+
+func (t *ObjectIteratorTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+// Return the names of the objects yielded by the iterator until it is
+// exhausted.
+func (t *ObjectIteratorTest) drain(it *gcsutil.ObjectIterator) (names []string) {
+	for {
+		o, err := it.Next(t.ctx)
+		AssertEq(nil, err)
+
+		if o == nil {
+			return
+		}
+
+		names = append(names, o.Name)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ObjectIteratorTest) EmptyBucket() {
+	it := gcsutil.NewObjectIterator(t.bucket, &gcs.ListObjectsRequest{})
+
+	// Exhausted iterators stay that way.
+	for i := 0; i < 2; i++ {
+		o, err := it.Next(t.ctx)
+		AssertEq(nil, err)
+		ExpectEq(nil, o)
+	}
+}
+
+func (t *ObjectIteratorTest) MultiplePages() {
+	AssertEq(
+		nil,
+		gcsutil.CreateEmptyObjects(
+			t.ctx,
+			t.bucket,
+			[]string{
+				"a",
+				"dir/0",
+				"dir/1",
+				"dir/2",
+				"dir/3",
+				"dir/4",
+				"z",
+			}))
+
+	req := &gcs.ListObjectsRequest{Prefix: "dir/"}
+	it := gcsutil.NewObjectIterator(&pagingBucket{t.bucket, 2}, req)
+
+	ExpectThat(
+		t.drain(it),
+		ElementsAre("dir/0", "dir/1", "dir/2", "dir/3", "dir/4"))
+
+	// The request wasn't modified.
+	ExpectEq("", req.ContinuationToken)
+}
+
+func (t *ObjectIteratorTest) ContinuationToken() {
+	AssertEq(nil, gcsutil.CreateEmptyObjects(t.ctx, t.bucket, []string{"a", "b", "c", "d", "e"}))
+
+	// Fetch a page by hand.
+	req := &gcs.ListObjectsRequest{MaxResults: 2}
+	listing, err := t.bucket.ListObjects(t.ctx, req)
+	AssertEq(nil, err)
+	AssertEq(2, len(listing.Objects))
+	AssertNe("", listing.ContinuationToken)
+
+	// An iterator given the continuation token picks up from there.
+	req.ContinuationToken = listing.ContinuationToken
+	it := gcsutil.NewObjectIterator(t.bucket, req)
+	ExpectThat(t.drain(it), ElementsAre("c", "d", "e"))
+}
+
+func (t *ObjectIteratorTest) EmptyPages() {
+	AssertEq(
+		nil,
+		gcsutil.CreateEmptyObjects(
+			t.ctx,
+			t.bucket,
+			[]string{
+				"a/0",
+				"b/0",
+				"c",
+				"d/0",
+				"e/0",
+				"f/0",
+			}))
+
+	// With one result per page, pages holding a collapsed run contain no
+	// objects, and must be skipped over.
+	it := gcsutil.NewObjectIterator(
+		&pagingBucket{t.bucket, 1},
+		&gcs.ListObjectsRequest{Delimiter: "/"})
+
+	ExpectThat(t.drain(it), ElementsAre("c"))
+
+	// Likewise when there are no objects at all.
+	it = gcsutil.NewObjectIterator(
+		&pagingBucket{t.bucket, 1},
+		&gcs.ListObjectsRequest{Prefix: "d", Delimiter: "/"})
+
+	ExpectThat(t.drain(it), ElementsAre())
+}
+
+func (t *ObjectIteratorTest) CancelledBetweenPages() {
+	AssertEq(nil, gcsutil.CreateEmptyObjects(t.ctx, t.bucket, []string{"a", "b", "c", "d"}))
+
+	ctx, cancel := context.WithCancel(t.ctx)
+	defer cancel()
+
+	it := gcsutil.NewObjectIterator(
+		&pagingBucket{t.bucket, 2},
+		&gcs.ListObjectsRequest{})
+
+	o, err := it.Next(ctx)
+	AssertEq(nil, err)
+	ExpectEq("a", o.Name)
+
+	// The rest of the page is still returned after cancellation, but the next
+	// page isn't fetched.
+	cancel()
+
+	o, err = it.Next(ctx)
+	AssertEq(nil, err)
+	ExpectEq("b", o.Name)
+
+	_, err = it.Next(ctx)
+	ExpectEq(context.Canceled, err)
+}
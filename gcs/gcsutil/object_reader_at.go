@@ -0,0 +1,290 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/util/lrucache"
+	"golang.org/x/net/context"
+)
+
+// The block size and number of cached blocks used by NewObjectReaderAt.
+const (
+	ObjectReaderAtBlockSize   = 1 << 20
+	ObjectReaderAtCacheBlocks = 16
+)
+
+// Random access to the contents of a particular generation of an object. See
+// NewObjectReaderAt.
+type ObjectReaderAt struct {
+	ctx    context.Context
+	bucket gcs.Bucket
+	o      *gcs.Object
+
+	mu sync.Mutex
+
+	// Recently fetched blocks, keyed by the decimal block index.
+	//
+	// GUARDED_BY(mu)
+	blocks lrucache.Cache
+
+	// The offset for Read and Seek.
+	//
+	// GUARDED_BY(mu)
+	offset int64
+}
+
+var _ io.ReaderAt = &ObjectReaderAt{}
+var _ io.ReadSeeker = &ObjectReaderAt{}
+
+// Return an io.ReaderAt and io.ReadSeeker over the contents of the given
+// generation of the named object, or the latest generation if it's zero. The
+// generation is pinned when this function is called, so that later reads
+// are consistent even if the object is overwritten (though they fail if the
+// generation is deleted).
+//
+// Reads are served from an LRU cache of recently fetched blocks of
+// ObjectReaderAtBlockSize bytes, fetching missing blocks with ranged reads.
+// This makes patterns like reading the central directory at the end of a zip
+// file cheap without downloading the whole object. Blocks are ordinary
+// allocations rather than buffers from a gcs.BufferPool, since the reader has
+// no Close method through which to return them; they are garbage collected
+// along with the reader.
+//
+// Compressed objects are read as stored, without decompressing. The supplied
+// context is used for all reads. The result is safe for concurrent use,
+// though concurrent calls to Read and Seek make little sense.
+func NewObjectReaderAt(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	generation int64) (r *ObjectReaderAt, err error) {
+	var o *gcs.Object
+	if generation == 0 {
+		o, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: name})
+		if err != nil {
+			err = fmt.Errorf("StatObject: %v", err)
+			return
+		}
+	} else {
+		o, err = statGeneration(ctx, bucket, name, generation)
+		if err != nil {
+			err = fmt.Errorf("statGeneration: %v", err)
+			return
+		}
+	}
+
+	r = &ObjectReaderAt{
+		ctx:    ctx,
+		bucket: bucket,
+		o:      o,
+		blocks: lrucache.New(ObjectReaderAtCacheBlocks),
+	}
+
+	return
+}
+
+// Return the record for the object generation being read.
+func (r *ObjectReaderAt) Object() *gcs.Object {
+	return r.o
+}
+
+// Return the size of the object.
+func (r *ObjectReaderAt) Size() int64 {
+	return int64(r.o.Size)
+}
+
+// LOCKS_EXCLUDED(r.mu)
+func (r *ObjectReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		err = errors.New("Negative offset")
+		return
+	}
+
+	size := r.Size()
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= size {
+			err = io.EOF
+			return
+		}
+
+		var block []byte
+		index := pos / ObjectReaderAtBlockSize
+		if block, err = r.getBlock(index); err != nil {
+			return
+		}
+
+		n += copy(p[n:], block[pos-index*ObjectReaderAtBlockSize:])
+	}
+
+	return
+}
+
+// LOCKS_EXCLUDED(r.mu)
+func (r *ObjectReaderAt) Read(p []byte) (n int, err error) {
+	r.mu.Lock()
+	off := r.offset
+	r.mu.Unlock()
+
+	n, err = r.ReadAt(p, off)
+
+	// ReadAt returns io.EOF for short reads, but Read needn't.
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	r.mu.Lock()
+	r.offset = off + int64(n)
+	r.mu.Unlock()
+
+	return
+}
+
+// LOCKS_EXCLUDED(r.mu)
+func (r *ObjectReaderAt) Seek(offset int64, whence int) (pos int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+
+	case io.SeekCurrent:
+		pos = r.offset + offset
+
+	case io.SeekEnd:
+		pos = r.Size() + offset
+
+	default:
+		err = fmt.Errorf("Invalid whence: %d", whence)
+		return
+	}
+
+	if pos < 0 {
+		err = errors.New("Negative position")
+		return
+	}
+
+	r.offset = pos
+	return
+}
+
+// Return the contents of the block with the given index, fetching it if it's
+// not in the cache.
+//
+// LOCKS_EXCLUDED(r.mu)
+func (r *ObjectReaderAt) getBlock(index int64) (block []byte, err error) {
+	key := strconv.FormatInt(index, 10)
+
+	r.mu.Lock()
+	cached := r.blocks.LookUp(key)
+	r.mu.Unlock()
+
+	if cached != nil {
+		block = cached.([]byte)
+		return
+	}
+
+	// Fetch without holding the lock, so that other blocks can be read
+	// concurrently. Concurrent fetches of the same block are harmless.
+	start := uint64(index) * ObjectReaderAtBlockSize
+	limit := start + ObjectReaderAtBlockSize
+	if limit > r.o.Size {
+		limit = r.o.Size
+	}
+
+	rc, err := r.bucket.NewReader(
+		r.ctx,
+		&gcs.ReadObjectRequest{
+			Name:           r.o.Name,
+			Generation:     r.o.Generation,
+			Range:          &gcs.ByteRange{Start: start, Limit: limit},
+			ReadCompressed: true,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	block, err = ioutil.ReadAll(rc)
+	if err != nil {
+		err = fmt.Errorf("ReadAll: %v", err)
+		return
+	}
+
+	if uint64(len(block)) != limit-start {
+		err = fmt.Errorf(
+			"Read %d bytes for range [%d, %d) of %q",
+			len(block),
+			start,
+			limit,
+			r.o.Name)
+
+		return
+	}
+
+	r.mu.Lock()
+	r.blocks.Insert(key, block)
+	r.mu.Unlock()
+
+	return
+}
+
+// Find the record for a particular generation of an object, which StatObject
+// can't do.
+func statGeneration(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	generation int64) (o *gcs.Object, err error) {
+	// Restrict the listing to exactly this name.
+	objects, _, err := ListAll(
+		ctx,
+		bucket,
+		&gcs.ListObjectsRequest{
+			Prefix:    name,
+			EndOffset: name + "\x00",
+			Versions:  true,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("ListAll: %v", err)
+		return
+	}
+
+	for _, candidate := range objects {
+		if candidate.Name == name && candidate.Generation == generation {
+			o = candidate
+			return
+		}
+	}
+
+	err = &gcs.NotFoundError{
+		Err: fmt.Errorf("Object %q generation %d not found", name, generation),
+	}
+
+	return
+}
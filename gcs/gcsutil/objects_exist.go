@@ -0,0 +1,90 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/syncutil"
+	"golang.org/x/net/context"
+)
+
+// Check whether a live object with the supplied name exists, with a single
+// stat call that asks for as little as possible of the object's record.
+func ObjectExists(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string) (exists bool, err error) {
+	req := &gcs.StatObjectRequest{
+		Name:   name,
+		Fields: []string{"name"},
+	}
+
+	_, err = bucket.StatObject(ctx, req)
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		err = nil
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("StatObject: %v", err)
+		return
+	}
+
+	exists = true
+	return
+}
+
+// Check whether objects with each of the supplied names exist, with some
+// parallelism. The result has the same length as names, with exists[i]
+// telling whether names[i] exists.
+func ObjectsExist(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	names []string) (exists []bool, err error) {
+	bundle := syncutil.NewBundle(ctx)
+	exists = make([]bool, len(names))
+
+	// Feed indices into a channel.
+	indices := make(chan int, len(names))
+	for i := range names {
+		indices <- i
+	}
+
+	close(indices)
+
+	// Stat the objects in parallel.
+	const parallelism = 64
+	for i := 0; i < parallelism; i++ {
+		bundle.Add(func(ctx context.Context) (err error) {
+			for i := range indices {
+				exists[i], err = ObjectExists(ctx, bucket, names[i])
+				if err != nil {
+					return
+				}
+			}
+
+			return
+		})
+	}
+
+	err = bundle.Join()
+	if err != nil {
+		exists = nil
+	}
+
+	return
+}
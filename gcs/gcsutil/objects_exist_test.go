@@ -0,0 +1,116 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcstesting"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestObjectsExist(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ObjectsExistTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &ObjectsExistTest{}
+
+func init() { RegisterTestSuite(&ObjectsExistTest{}) }
+
+func (t *ObjectsExistTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ObjectsExistTest) ManyNames() {
+	// Create every third of many more objects than are checked at once.
+	var names []string
+	var expected []bool
+	var toCreate []string
+
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("obj%03d", i)
+		names = append(names, name)
+		expected = append(expected, i%3 == 0)
+
+		if i%3 == 0 {
+			toCreate = append(toCreate, name)
+		}
+	}
+
+	AssertEq(nil, gcsutil.CreateEmptyObjects(t.ctx, t.bucket, toCreate))
+
+	// Names may be repeated.
+	names = append(names, names[0], names[1])
+	expected = append(expected, true, false)
+
+	exists, err := gcsutil.ObjectsExist(t.ctx, t.bucket, names)
+	AssertEq(nil, err)
+	ExpectThat(exists, DeepEquals(expected))
+}
+
+func (t *ObjectsExistTest) NoNames() {
+	exists, err := gcsutil.ObjectsExist(t.ctx, t.bucket, nil)
+	AssertEq(nil, err)
+	ExpectEq(0, len(exists))
+}
+
+func (t *ObjectsExistTest) Error() {
+	AssertEq(nil, gcsutil.CreateEmptyObjects(t.ctx, t.bucket, []string{"a", "b", "c"}))
+
+	// One of the stats fails.
+	bucket := gcstesting.NewFlakyBucket(
+		t.bucket,
+		gcstesting.FlakyBucketConfig{
+			Rules: []gcstesting.FaultRule{
+				{Fault: gcstesting.FaultUnavailable, Method: "StatObject", Calls: []int{2}},
+			},
+		})
+
+	exists, err := gcsutil.ObjectsExist(
+		t.ctx,
+		bucket,
+		[]string{"a", "b", "c", "d"})
+
+	ExpectThat(err, Error(HasSubstr("Injected fault")))
+	ExpectEq(nil, exists)
+
+	// So does one for an invalid name.
+	exists, err = gcsutil.ObjectsExist(t.ctx, t.bucket, []string{"a", ""})
+	ExpectThat(err, Error(HasSubstr("Invalid object name")))
+	ExpectEq(nil, exists)
+}
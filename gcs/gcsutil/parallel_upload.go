@@ -0,0 +1,296 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/syncutil"
+	"golang.org/x/net/context"
+)
+
+// The defaults for ParallelUploadRequest.
+const (
+	DefaultParallelUploadPartSize    = 64 << 20
+	DefaultParallelUploadParallelism = 8
+)
+
+// A request to upload an object in parts, accepted by ParallelUpload.
+type ParallelUploadRequest struct {
+	// The name of the object to create. Must be specified.
+	Name string
+
+	// Optional information with which to create the object.
+	ContentType string
+	Metadata    map[string]string
+
+	// The contents of the object, consisting of Size bytes starting at offset
+	// zero. Distinct parts are read concurrently.
+	Contents io.ReaderAt
+	Size     int64
+
+	// If non-nil, the object will be created/overwritten only if the current
+	// generation for its name is equal to the given value. Zero means the
+	// object does not exist.
+	GenerationPrecondition *int64
+
+	// The size of each part. If zero, DefaultParallelUploadPartSize is used.
+	// Size/PartSize, rounded up, must be no more than gcs.MaxComponentCount.
+	PartSize int64
+
+	// The maximum number of parts to upload at once. If zero,
+	// DefaultParallelUploadParallelism is used.
+	Parallelism int
+
+	// A prefix for the names of the temporary objects created along the way.
+	// If empty, Name followed by a random suffix is used.
+	TempPrefix string
+}
+
+// Upload an object by splitting its contents into parts, uploading each as a
+// temporary object concurrently, composing them into the destination, and
+// deleting the temporaries. For large objects this can be much faster than a
+// single call to CreateObject.
+//
+// Temporaries are deleted whether or not the upload succeeds, but may be left
+// behind if ctx is cancelled; their names all begin with the request's
+// TempPrefix. If the destination is created but cleaning up fails, both the
+// new object and an error are returned.
+//
+// Note that the result is a composite object, so it has no MD5 hash.
+func ParallelUpload(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	req *ParallelUploadRequest) (o *gcs.Object, err error) {
+	if req.Name == "" {
+		err = errors.New("Name must be specified")
+		return
+	}
+
+	// Apply defaults.
+	partSize := req.PartSize
+	if partSize == 0 {
+		partSize = DefaultParallelUploadPartSize
+	}
+
+	parallelism := req.Parallelism
+	if parallelism == 0 {
+		parallelism = DefaultParallelUploadParallelism
+	}
+
+	tempPrefix := req.TempPrefix
+	if tempPrefix == "" {
+		var suffix [8]byte
+		if _, err = rand.Read(suffix[:]); err != nil {
+			err = fmt.Errorf("rand.Read: %v", err)
+			return
+		}
+
+		tempPrefix = fmt.Sprintf(
+			"%s.parallel-%s-",
+			req.Name,
+			hex.EncodeToString(suffix[:]))
+	}
+
+	// Validate.
+	if partSize < 0 || parallelism < 0 || req.Size < 0 {
+		err = errors.New("PartSize, Parallelism, and Size must be non-negative")
+		return
+	}
+
+	// Compose requires at least one source, so even an empty object has a part.
+	numParts := (req.Size + partSize - 1) / partSize
+	if numParts == 0 {
+		numParts = 1
+	}
+
+	if numParts > gcs.MaxComponentCount {
+		err = fmt.Errorf(
+			"%d parts of size %d exceeds the limit of %d components",
+			numParts,
+			partSize,
+			gcs.MaxComponentCount)
+
+		return
+	}
+
+	// Whatever happens, clean up the temporaries we created.
+	var temps []string
+	defer func() {
+		deleteErr := deleteTemporaries(ctx, bucket, temps, parallelism)
+		if deleteErr != nil && err == nil {
+			err = fmt.Errorf("deleting temporaries: %v", deleteErr)
+		}
+	}()
+
+	// Upload the parts.
+	sources := make([]gcs.ComposeSource, numParts)
+	for i := range sources {
+		sources[i].Name = fmt.Sprintf("%s%05d", tempPrefix, i)
+		temps = append(temps, sources[i].Name)
+	}
+
+	err = uploadParts(ctx, bucket, req, partSize, parallelism, sources)
+	if err != nil {
+		err = fmt.Errorf("uploadParts: %v", err)
+		return
+	}
+
+	// Compose into intermediate objects until there are few enough sources for
+	// a single request.
+	for level := 0; len(sources) > gcs.MaxSourcesPerComposeRequest; level++ {
+		var next []gcs.ComposeSource
+		for start := 0; start < len(sources); start += gcs.MaxSourcesPerComposeRequest {
+			end := start + gcs.MaxSourcesPerComposeRequest
+			if end > len(sources) {
+				end = len(sources)
+			}
+
+			name := fmt.Sprintf("%sl%d-%05d", tempPrefix, level, len(next))
+			temps = append(temps, name)
+
+			var intermediate *gcs.Object
+			intermediate, err = bucket.ComposeObjects(
+				ctx,
+				&gcs.ComposeObjectsRequest{
+					DstName: name,
+					Sources: sources[start:end],
+				})
+
+			if err != nil {
+				err = fmt.Errorf("ComposeObjects(%q): %v", name, err)
+				return
+			}
+
+			next = append(next, gcs.ComposeSource{
+				Name:       name,
+				Generation: intermediate.Generation,
+			})
+		}
+
+		sources = next
+	}
+
+	// Compose the destination.
+	o, err = bucket.ComposeObjects(
+		ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName:                   req.Name,
+			DstGenerationPrecondition: req.GenerationPrecondition,
+			Sources:                   sources,
+			ContentType:               req.ContentType,
+			Metadata:                  req.Metadata,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("ComposeObjects: %v", err)
+		return
+	}
+
+	return
+}
+
+// Upload part i of the request's contents to sources[i].Name for each i,
+// filling in the generations of the resulting objects.
+func uploadParts(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	req *ParallelUploadRequest,
+	partSize int64,
+	parallelism int,
+	sources []gcs.ComposeSource) (err error) {
+	bundle := syncutil.NewBundle(ctx)
+
+	// Feed part indices into a channel.
+	indices := make(chan int, len(sources))
+	for i := range sources {
+		indices <- i
+	}
+
+	close(indices)
+
+	// Upload in parallel.
+	for i := 0; i < parallelism; i++ {
+		bundle.Add(func(ctx context.Context) (err error) {
+			for i := range indices {
+				offset := int64(i) * partSize
+				n := partSize
+				if offset+n > req.Size {
+					n = req.Size - offset
+				}
+
+				var o *gcs.Object
+				o, err = bucket.CreateObject(
+					ctx,
+					&gcs.CreateObjectRequest{
+						Name:     sources[i].Name,
+						Contents: io.NewSectionReader(req.Contents, offset, n),
+					})
+
+				if err != nil {
+					err = fmt.Errorf("CreateObject(%q): %v", sources[i].Name, err)
+					return
+				}
+
+				sources[i].Generation = o.Generation
+			}
+
+			return
+		})
+	}
+
+	err = bundle.Join()
+	return
+}
+
+// Delete the named objects with some parallelism.
+func deleteTemporaries(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	names []string,
+	parallelism int) (err error) {
+	bundle := syncutil.NewBundle(ctx)
+
+	// Feed names into a channel.
+	nameChan := make(chan string, len(names))
+	for _, name := range names {
+		nameChan <- name
+	}
+
+	close(nameChan)
+
+	// Delete in parallel.
+	for i := 0; i < parallelism; i++ {
+		bundle.Add(func(ctx context.Context) (err error) {
+			for name := range nameChan {
+				err = bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{Name: name})
+				if err != nil {
+					err = fmt.Errorf("DeleteObject(%q): %v", name, err)
+					return
+				}
+			}
+
+			return
+		})
+	}
+
+	err = bundle.Join()
+	return
+}
@@ -0,0 +1,273 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestParallelUpload(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// An io.ReaderAt that fails reads extending past a given offset.
+type failingReaderAt struct {
+	wrapped io.ReaderAt
+	failAt  int64
+}
+
+func (r *failingReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off+int64(len(p)) > r.failAt {
+		err = errors.New("taco")
+		return
+	}
+
+	n, err = r.wrapped.ReadAt(p, off)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ParallelUploadTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &ParallelUploadTest{}
+
+func init() { RegisterTestSuite(&ParallelUploadTest{}) }
+
+func (t *ParallelUploadTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+// Return the contents of the object with the given name.
+func (t *ParallelUploadTest) readObject(name string) (contents string, err error) {
+	b, err := gcsutil.ReadObject(t.ctx, t.bucket, name)
+	contents = string(b)
+	return
+}
+
+// Return the names of all objects in the bucket with the given prefix.
+func (t *ParallelUploadTest) listNames(prefix string) (names []string, err error) {
+	objects, _, err := gcsutil.ListAll(
+		t.ctx,
+		t.bucket,
+		&gcs.ListObjectsRequest{Prefix: prefix})
+
+	if err != nil {
+		return
+	}
+
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ParallelUploadTest) SinglePart() {
+	const contents = "taco"
+
+	o, err := gcsutil.ParallelUpload(
+		t.ctx,
+		t.bucket,
+		&gcsutil.ParallelUploadRequest{
+			Name:        "foo",
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"burrito": "enchilada"},
+			Contents:    strings.NewReader(contents),
+			Size:        int64(len(contents)),
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq(len(contents), o.Size)
+	ExpectEq("text/plain", o.ContentType)
+	ExpectThat(o.Metadata, DeepEquals(map[string]string{"burrito": "enchilada"}))
+
+	actual, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq(contents, actual)
+
+	// The temporary was deleted.
+	names, err := t.listNames("")
+	AssertEq(nil, err)
+	ExpectThat(names, ElementsAre("foo"))
+}
+
+func (t *ParallelUploadTest) Empty() {
+	o, err := gcsutil.ParallelUpload(
+		t.ctx,
+		t.bucket,
+		&gcsutil.ParallelUploadRequest{
+			Name:     "foo",
+			Contents: strings.NewReader(""),
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(0, o.Size)
+
+	actual, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq("", actual)
+
+	names, err := t.listNames("")
+	AssertEq(nil, err)
+	ExpectThat(names, ElementsAre("foo"))
+}
+
+func (t *ParallelUploadTest) MultipleLevels() {
+	// Use enough single-byte parts that they must be composed into
+	// intermediate objects before the destination can be composed, and a
+	// number that doesn't divide evenly.
+	const numParts = 3*gcs.MaxSourcesPerComposeRequest + 5
+
+	var contents []byte
+	for i := 0; i < numParts; i++ {
+		contents = append(contents, byte('a'+i%26))
+	}
+
+	o, err := gcsutil.ParallelUpload(
+		t.ctx,
+		t.bucket,
+		&gcsutil.ParallelUploadRequest{
+			Name:        "foo",
+			Contents:    bytes.NewReader(contents),
+			Size:        int64(len(contents)),
+			PartSize:    1,
+			Parallelism: 16,
+			TempPrefix:  "tmp/",
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(numParts, o.Size)
+	ExpectEq(numParts, o.ComponentCount)
+
+	actual, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq(string(contents), actual)
+
+	// Both the parts and the intermediate objects were deleted.
+	names, err := t.listNames("")
+	AssertEq(nil, err)
+	ExpectThat(names, ElementsAre("foo"))
+}
+
+func (t *ParallelUploadTest) PartFails() {
+	const contents = "tacoburritoenchilada"
+
+	_, err := gcsutil.ParallelUpload(
+		t.ctx,
+		t.bucket,
+		&gcsutil.ParallelUploadRequest{
+			Name: "foo",
+			Contents: &failingReaderAt{
+				wrapped: strings.NewReader(contents),
+				failAt:  10,
+			},
+			Size:       int64(len(contents)),
+			PartSize:   4,
+			TempPrefix: "tmp/",
+		})
+
+	ExpectThat(err, Error(HasSubstr("taco")))
+
+	// The destination wasn't created, and no temporaries were left behind.
+	names, err := t.listNames("")
+	AssertEq(nil, err)
+	ExpectThat(names, ElementsAre())
+}
+
+func (t *ParallelUploadTest) PreconditionNotSatisfied() {
+	// Create an existing destination.
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	const contents = "burritoenchilada"
+	var zero int64
+
+	_, err = gcsutil.ParallelUpload(
+		t.ctx,
+		t.bucket,
+		&gcsutil.ParallelUploadRequest{
+			Name:                   "foo",
+			Contents:               strings.NewReader(contents),
+			Size:                   int64(len(contents)),
+			GenerationPrecondition: &zero,
+			PartSize:               4,
+			TempPrefix:             "tmp/",
+		})
+
+	ExpectThat(err, Error(HasSubstr("ComposeObjects")))
+
+	// The destination is unchanged, and the temporaries are gone.
+	actual, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", actual)
+
+	names, err := t.listNames("tmp/")
+	AssertEq(nil, err)
+	ExpectThat(names, ElementsAre())
+}
+
+func (t *ParallelUploadTest) TooManyParts() {
+	const size = gcs.MaxComponentCount + 1
+
+	_, err := gcsutil.ParallelUpload(
+		t.ctx,
+		t.bucket,
+		&gcsutil.ParallelUploadRequest{
+			Name:       "foo",
+			Contents:   bytes.NewReader(make([]byte, size)),
+			Size:       size,
+			PartSize:   1,
+			TempPrefix: "tmp/",
+		})
+
+	ExpectThat(err, Error(HasSubstr("exceeds the limit")))
+	ExpectThat(err, Error(HasSubstr(fmt.Sprint(gcs.MaxComponentCount))))
+
+	// Nothing was uploaded.
+	names, err := t.listNames("")
+	AssertEq(nil, err)
+	ExpectThat(names, ElementsAre())
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Check whether there are no live objects whose names begin with the supplied
+// prefix, as when deciding whether a "directory" is empty. An object named
+// exactly the prefix, such as a directory placeholder, counts.
+//
+// This asks for a single name at a time, so it is cheap however many objects
+// there are. GCS may return pages with fewer results than asked for even if
+// more remain, so it follows continuation tokens until it finds an object or
+// runs out.
+func PrefixIsEmpty(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	prefix string) (empty bool, err error) {
+	req := &gcs.ListObjectsRequest{
+		Prefix:     prefix,
+		MaxResults: 1,
+		Fields:     []string{"name"},
+	}
+
+	for {
+		var listing *gcs.Listing
+		listing, err = bucket.ListObjects(ctx, req)
+		if err != nil {
+			err = fmt.Errorf("ListObjects: %v", err)
+			return
+		}
+
+		if len(listing.Objects) != 0 {
+			return
+		}
+
+		// Are we done?
+		if listing.ContinuationToken == "" {
+			break
+		}
+
+		req.ContinuationToken = listing.ContinuationToken
+	}
+
+	empty = true
+	return
+}
@@ -0,0 +1,268 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/syncutil"
+	"golang.org/x/net/context"
+)
+
+// The default for RenamePrefixOptions.Parallelism.
+const DefaultRenameParallelism = 16
+
+// Options accepted by Rename. The zero value is a sensible default.
+type RenameOptions struct {
+	// If non-nil, the object is renamed only if its current generation is
+	// equal to this value. Otherwise Rename fails with *gcs.PreconditionError.
+	SrcGenerationPrecondition *int64
+
+	// If true, an existing object with the new name is replaced. Otherwise
+	// Rename fails with *gcs.PreconditionError if there is one.
+	Overwrite bool
+}
+
+// Rename an object within a bucket, by copying it to the new name and then
+// deleting the original, preserving its contents and metadata.
+//
+// GCS has no atomic rename, so for a moment both names exist, and concurrent
+// readers and listings may see both. Preconditions on both sides make sure
+// that nothing is lost along the way: the copy is made only if the original is
+// still the generation and meta-generation that was found, and, unless
+// opts.Overwrite is set, only if nothing exists with the new name; the
+// original is deleted only if it is still that generation and
+// meta-generation.
+//
+// Returns *gcs.NotFoundError if there is no object named oldName, and
+// *gcs.PreconditionError if a precondition isn't met before anything has been
+// copied.
+//
+// If the original can't be deleted because it has been changed since it was
+// found, the copy is deleted again and the *gcs.PreconditionError from
+// deleting the original is returned, so that the rename appears not to have
+// happened. (If opts.Overwrite is set, whatever the copy replaced is not
+// restored.) If the original can't be deleted for another reason, such as a
+// network error, then it may or may not have been deleted, and both the new
+// object and an error are returned. Calling Rename again completes the job.
+//
+// Deleting an object that doesn't exist succeeds, so if the original is
+// deleted by someone else after it has been copied, Rename can't tell and
+// reports success, leaving the copy in place.
+func Rename(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	oldName string,
+	newName string,
+	opts *RenameOptions) (o *gcs.Object, err error) {
+	var options RenameOptions
+	if opts != nil {
+		options = *opts
+	}
+
+	if oldName == newName {
+		err = fmt.Errorf("Can't rename %q to itself", oldName)
+		return
+	}
+
+	// Find the source, so that we know exactly what we're copying and later
+	// deleting.
+	src, err := bucket.StatObject(
+		ctx,
+		&gcs.StatObjectRequest{Name: oldName})
+
+	if err != nil {
+		err = annotateError("StatObject", err)
+		return
+	}
+
+	p := options.SrcGenerationPrecondition
+	if p != nil && *p != src.Generation {
+		err = &gcs.PreconditionError{
+			Err: fmt.Errorf(
+				"Object %q has generation %d, not %d",
+				oldName,
+				src.Generation,
+				*p),
+		}
+
+		return
+	}
+
+	// Copy.
+	req := &gcs.RewriteObjectRequest{
+		SrcName:                       oldName,
+		SrcGeneration:                 src.Generation,
+		SrcMetaGenerationPrecondition: &src.MetaGeneration,
+		DstName:                       newName,
+	}
+
+	if !options.Overwrite {
+		var zero int64
+		req.DstGenerationPrecondition = &zero
+	}
+
+	o, err = bucket.RewriteObject(ctx, req)
+	if err != nil {
+		o = nil
+		err = annotateError("RewriteObject", err)
+		return
+	}
+
+	// Delete the original, unless it has been changed since it was copied.
+	err = bucket.DeleteObject(
+		ctx,
+		&gcs.DeleteObjectRequest{
+			Name:                       oldName,
+			GenerationPrecondition:     &src.Generation,
+			MetaGenerationPrecondition: &src.MetaGeneration,
+		})
+
+	switch err.(type) {
+	case nil:
+		return
+
+	case *gcs.PreconditionError:
+		// Someone got there first. Undo the copy, taking care not to delete
+		// anything written since.
+		deleteErr := err
+		err = bucket.DeleteObject(
+			ctx,
+			&gcs.DeleteObjectRequest{
+				Name:                   newName,
+				GenerationPrecondition: &o.Generation,
+			})
+
+		if err != nil {
+			err = fmt.Errorf(
+				"Deleting %q (%v), then deleting the copy %q: %v",
+				oldName,
+				deleteErr,
+				newName,
+				err)
+			return
+		}
+
+		o = nil
+		err = deleteErr
+		return
+
+	default:
+		err = fmt.Errorf(
+			"Deleting %q after copying it to %q: %v",
+			oldName,
+			newName,
+			err)
+
+		return
+	}
+}
+
+// Options accepted by RenamePrefix. The zero value is a sensible default.
+type RenamePrefixOptions struct {
+	// The maximum number of objects to rename at once. If zero,
+	// DefaultRenameParallelism is used.
+	Parallelism int
+
+	// If true, existing objects with the new names are replaced. Otherwise
+	// RenamePrefix fails if there are any.
+	Overwrite bool
+
+	// If non-nil, called once for each object when it has been renamed.
+	// Calls are not made concurrently, but should return quickly since they
+	// hold up progress.
+	Progress func(oldName string, newName string)
+}
+
+// Rename every object whose name begins with oldPrefix by replacing that
+// prefix with newPrefix, several at a time, as when moving a "directory". Each
+// object is renamed with Rename, with the same caveats. The number of objects
+// renamed is returned.
+//
+// Neither prefix may be a prefix of the other, since the objects being renamed
+// would then be listed again. The first failure stops everything, leaving the
+// objects not yet renamed under oldPrefix; calling RenamePrefix again carries
+// on from where it left off.
+func RenamePrefix(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	oldPrefix string,
+	newPrefix string,
+	opts *RenamePrefixOptions) (renamed int, err error) {
+	var options RenamePrefixOptions
+	if opts != nil {
+		options = *opts
+	}
+
+	if options.Parallelism <= 0 {
+		options.Parallelism = DefaultRenameParallelism
+	}
+
+	if strings.HasPrefix(newPrefix, oldPrefix) ||
+		strings.HasPrefix(oldPrefix, newPrefix) {
+		err = fmt.Errorf(
+			"Can't rename prefix %q to %q: one is a prefix of the other",
+			oldPrefix,
+			newPrefix)
+
+		return
+	}
+
+	bundle := syncutil.NewBundle(ctx)
+
+	// List the objects.
+	objects := make(chan *gcs.Object, 100)
+	bundle.Add(func(ctx context.Context) (err error) {
+		defer close(objects)
+		err = ListPrefix(ctx, bucket, oldPrefix, objects)
+		return
+	})
+
+	// Rename them in parallel.
+	var mu sync.Mutex
+	for i := 0; i < options.Parallelism; i++ {
+		bundle.Add(func(ctx context.Context) (err error) {
+			for o := range objects {
+				newName := newPrefix + strings.TrimPrefix(o.Name, oldPrefix)
+				_, err = Rename(
+					ctx,
+					bucket,
+					o.Name,
+					newName,
+					&RenameOptions{Overwrite: options.Overwrite})
+
+				if err != nil {
+					err = fmt.Errorf("Rename(%q, %q): %v", o.Name, newName, err)
+					return
+				}
+
+				mu.Lock()
+				renamed++
+				if options.Progress != nil {
+					options.Progress(o.Name, newName)
+				}
+				mu.Unlock()
+			}
+
+			return
+		})
+	}
+
+	err = bundle.Join()
+	return
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Move the latest generation of the named object to the given storage class,
+// e.g. "NEARLINE", "COLDLINE", or "ARCHIVE", by rewriting it in place. The
+// contents and metadata are preserved, but the object gets a new generation.
+// If the object is already in that class, it is returned unchanged.
+//
+// If the object is overwritten while the rewrite is in progress, the new
+// contents are left alone and *gcs.PreconditionError is returned.
+func ChangeStorageClass(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	name string,
+	storageClass string) (o *gcs.Object, err error) {
+	if storageClass == "" {
+		err = errors.New("storageClass must be specified")
+		return
+	}
+
+	o, err = bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: name})
+	if err != nil {
+		err = fmt.Errorf("StatObject: %v", err)
+		return
+	}
+
+	if o.StorageClass == storageClass {
+		return
+	}
+
+	generation := o.Generation
+	o, err = bucket.RewriteObject(
+		ctx,
+		&gcs.RewriteObjectRequest{
+			SrcName:                   name,
+			SrcGeneration:             generation,
+			DstName:                   name,
+			DstGenerationPrecondition: &generation,
+			DstStorageClass:           storageClass,
+		})
+
+	return
+}
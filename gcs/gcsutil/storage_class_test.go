@@ -0,0 +1,175 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsutil_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestChangeStorageClass(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that overwrites the destination of each rewrite just before
+// carrying it out, as if someone else got in between the steps of
+// gcsutil.ChangeStorageClass.
+type clobberingBucket struct {
+	gcs.Bucket
+}
+
+func (b *clobberingBucket) RewriteObject(
+	ctx context.Context,
+	req *gcs.RewriteObjectRequest) (o *gcs.Object, err error) {
+	_, err = gcsutil.CreateObject(ctx, b.Bucket, req.DstName, []byte("clobbered"))
+	if err != nil {
+		return
+	}
+
+	o, err = b.Bucket.RewriteObject(ctx, req)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ChangeStorageClassTest struct {
+	ctx    context.Context
+	clock  timeutil.SimulatedClock
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &ChangeStorageClassTest{}
+
+func init() { RegisterTestSuite(&ChangeStorageClassTest{}) }
+
+func (t *ChangeStorageClassTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.clock.SetTime(time.Date(2012, 8, 15, 22, 56, 0, 0, time.Local))
+	t.bucket = gcsfake.NewFakeBucket(&t.clock, "some_bucket")
+}
+
+func (t *ChangeStorageClassTest) createObject(name string, contents string) (err error) {
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, name, []byte(contents))
+	return
+}
+
+// Return the contents of the object with the given name.
+func (t *ChangeStorageClassTest) readObject(name string) (contents string, err error) {
+	b, err := gcsutil.ReadObject(t.ctx, t.bucket, name)
+	contents = string(b)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ChangeStorageClassTest) ChangesClass() {
+	orig, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:        "foo",
+			Contents:    strings.NewReader("taco"),
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"a": "b"},
+		})
+
+	AssertEq(nil, err)
+
+	o, err := gcsutil.ChangeStorageClass(t.ctx, t.bucket, "foo", "COLDLINE")
+	AssertEq(nil, err)
+
+	ExpectEq("foo", o.Name)
+	ExpectEq("COLDLINE", o.StorageClass)
+	ExpectNe(orig.Generation, o.Generation)
+	ExpectEq(len("taco"), o.Size)
+	ExpectEq("text/plain", o.ContentType)
+	ExpectThat(o.Metadata, DeepEquals(map[string]string{"a": "b"}))
+
+	// Stat and read should agree.
+	o, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq("COLDLINE", o.StorageClass)
+
+	contents, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", contents)
+}
+
+func (t *ChangeStorageClassTest) AlreadyInClass() {
+	orig, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:         "foo",
+			Contents:     strings.NewReader("taco"),
+			StorageClass: "NEARLINE",
+		})
+
+	AssertEq(nil, err)
+
+	// Nothing should be rewritten.
+	o, err := gcsutil.ChangeStorageClass(t.ctx, t.bucket, "foo", "NEARLINE")
+	AssertEq(nil, err)
+	ExpectEq("NEARLINE", o.StorageClass)
+	ExpectEq(orig.Generation, o.Generation)
+	ExpectEq(orig.MetaGeneration, o.MetaGeneration)
+}
+
+func (t *ChangeStorageClassTest) Errors() {
+	// No class.
+	AssertEq(nil, t.createObject("foo", "taco"))
+	_, err := gcsutil.ChangeStorageClass(t.ctx, t.bucket, "foo", "")
+	ExpectThat(err, Error(HasSubstr("storageClass")))
+
+	// No object.
+	_, err = gcsutil.ChangeStorageClass(t.ctx, t.bucket, "bar", "NEARLINE")
+	ExpectThat(err, Error(HasSubstr("StatObject")))
+	ExpectThat(err, Error(HasSubstr("not found")))
+}
+
+func (t *ChangeStorageClassTest) OverwrittenMeanwhile() {
+	AssertEq(nil, t.createObject("foo", "taco"))
+
+	_, err := gcsutil.ChangeStorageClass(
+		t.ctx,
+		&clobberingBucket{t.bucket},
+		"foo",
+		"NEARLINE")
+
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// The new contents should be left alone.
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq("STANDARD", o.StorageClass)
+
+	contents, err := t.readObject("foo")
+	AssertEq(nil, err)
+	ExpectEq("clobbered", contents)
+}
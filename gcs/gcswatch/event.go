@@ -0,0 +1,149 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcswatch
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+)
+
+// EventType is the kind of change described by an ObjectChangeEvent.
+type EventType string
+
+const (
+	// A new object, or a new generation of an existing one, was created.
+	ObjectFinalize EventType = gcs.NotificationObjectFinalize
+
+	// The metadata of a live object was changed.
+	ObjectMetadataUpdate EventType = gcs.NotificationObjectMetadataUpdate
+
+	// An object was permanently deleted, either explicitly or by being
+	// overwritten in a bucket without versioning.
+	ObjectDelete EventType = gcs.NotificationObjectDelete
+
+	// The live version of an object became a noncurrent version in a bucket
+	// with versioning.
+	ObjectArchive EventType = gcs.NotificationObjectArchive
+)
+
+// ObjectChangeEvent describes a single change to a GCS object.
+//
+// See here for more information about its fields:
+//
+//     https://cloud.google.com/storage/docs/pubsub-notifications
+//
+type ObjectChangeEvent struct {
+	Type       EventType
+	Bucket     string
+	Name       string
+	Generation int64
+
+	// When the change happened.
+	Time time.Time
+
+	// For ObjectFinalize events that replaced a live object, its generation.
+	// Zero otherwise.
+	OverwroteGeneration int64
+
+	// For ObjectDelete and ObjectArchive events caused by an overwrite, the
+	// generation of the new object. Zero otherwise.
+	OverwrittenByGeneration int64
+
+	// The object's attributes as of the change, if the notification
+	// configuration's payload format is gcs.NotificationPayloadJSON. Nil
+	// otherwise.
+	Object *gcs.Object
+
+	// The Pub/Sub message ID, which can be used to detect the occasional
+	// duplicate delivery.
+	MessageID string
+}
+
+// Parse an optional integer attribute, treating a missing one as zero.
+func parseInt(attrs map[string]string, key string) (n int64, err error) {
+	s, ok := attrs[key]
+	if !ok {
+		return
+	}
+
+	n, err = strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		err = fmt.Errorf("Parsing %s: %v", key, err)
+		return
+	}
+
+	return
+}
+
+// Convert a message published by GCS into an event.
+func toEvent(m *pubsubMessage) (ev *ObjectChangeEvent, err error) {
+	attrs := m.Attributes
+
+	ev = &ObjectChangeEvent{
+		Type:      EventType(attrs["eventType"]),
+		Bucket:    attrs["bucketId"],
+		Name:      attrs["objectId"],
+		MessageID: m.MessageID,
+	}
+
+	switch ev.Type {
+	case ObjectFinalize, ObjectMetadataUpdate, ObjectDelete, ObjectArchive:
+	default:
+		err = fmt.Errorf("Unknown event type %q", ev.Type)
+		return
+	}
+
+	if ev.Bucket == "" || ev.Name == "" {
+		err = errors.New("Missing bucketId or objectId attribute")
+		return
+	}
+
+	// Integers
+	if ev.Generation, err = parseInt(attrs, "objectGeneration"); err != nil {
+		return
+	}
+
+	ev.OverwroteGeneration, err = parseInt(attrs, "overwroteGeneration")
+	if err != nil {
+		return
+	}
+
+	ev.OverwrittenByGeneration, err = parseInt(attrs, "overwrittenByGeneration")
+	if err != nil {
+		return
+	}
+
+	// Event time
+	if s, ok := attrs["eventTime"]; ok {
+		if ev.Time, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			err = fmt.Errorf("Parsing eventTime: %v", err)
+			return
+		}
+	}
+
+	// Payload
+	if attrs["payloadFormat"] == gcs.NotificationPayloadJSON && len(m.Data) > 0 {
+		if ev.Object, err = gcs.ParseObjectJSON(m.Data); err != nil {
+			err = fmt.Errorf("ParseObjectJSON: %v", err)
+			return
+		}
+	}
+
+	return
+}
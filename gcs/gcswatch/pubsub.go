@@ -0,0 +1,165 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcswatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+const userAgent = "github.com-jacobsa-gcloud-gcswatch"
+
+// A message as returned by the Pub/Sub pull API. See here for more
+// information:
+//
+//     https://cloud.google.com/pubsub/docs/reference/rest/v1/PubsubMessage
+//
+type pubsubMessage struct {
+	Data        []byte            `json:"data"`
+	Attributes  map[string]string `json:"attributes"`
+	MessageID   string            `json:"messageId"`
+	PublishTime string            `json:"publishTime"`
+}
+
+type receivedMessage struct {
+	AckID   string        `json:"ackId"`
+	Message pubsubMessage `json:"message"`
+}
+
+// A minimal client for the parts of the Pub/Sub REST API needed to consume a
+// subscription.
+type pubsubClient struct {
+	client *http.Client
+
+	// Of the form projects/<project>/subscriptions/<subscription>.
+	subscription string
+}
+
+// Call the given method on the subscription, e.g. "pull", serializing req as
+// the request body and parsing the response into resp if it is non-nil.
+func (c *pubsubClient) call(
+	ctx context.Context,
+	method string,
+	req interface{},
+	resp interface{}) (err error) {
+	// Construct an appropriate URL.
+	url := &url.URL{
+		Scheme: "https",
+		Host:   "pubsub.googleapis.com",
+		Opaque: fmt.Sprintf(
+			"//pubsub.googleapis.com/v1/%s:%s",
+			c.subscription,
+			method),
+	}
+
+	// Set up the request body.
+	body, err := json.Marshal(req)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"POST",
+		url,
+		ioutil.NopCloser(bytes.NewReader(body)),
+		int64(len(body)),
+		userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Execute the HTTP request.
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Check for HTTP-level errors.
+	if err = googleapi.CheckResponse(httpRes); err != nil {
+		return
+	}
+
+	// Parse the response.
+	if resp != nil {
+		err = json.NewDecoder(httpRes.Body).Decode(resp)
+	}
+
+	return
+}
+
+// Pull up to max messages from the subscription, blocking until at least one
+// is available or the server gives up.
+func (c *pubsubClient) pull(
+	ctx context.Context,
+	max int) (msgs []*receivedMessage, err error) {
+	req := map[string]interface{}{
+		"maxMessages": max,
+	}
+
+	var resp struct {
+		ReceivedMessages []*receivedMessage `json:"receivedMessages"`
+	}
+
+	if err = c.call(ctx, "pull", req, &resp); err != nil {
+		return
+	}
+
+	msgs = resp.ReceivedMessages
+	return
+}
+
+// Acknowledge the messages with the given ack IDs, so that they won't be
+// delivered again.
+func (c *pubsubClient) acknowledge(
+	ctx context.Context,
+	ackIDs []string) (err error) {
+	req := map[string]interface{}{
+		"ackIds": ackIDs,
+	}
+
+	err = c.call(ctx, "acknowledge", req, nil)
+	return
+}
+
+// Ask for the messages with the given ack IDs to be redelivered as soon as
+// possible.
+func (c *pubsubClient) nack(
+	ctx context.Context,
+	ackIDs []string) (err error) {
+	req := map[string]interface{}{
+		"ackIds":             ackIDs,
+		"ackDeadlineSeconds": 0,
+	}
+
+	err = c.call(ctx, "modifyAckDeadline", req, nil)
+	return
+}
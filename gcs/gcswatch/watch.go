@@ -0,0 +1,186 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcswatch
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"golang.org/x/net/context"
+)
+
+// Config contains options accepted by Watch.
+type Config struct {
+	// An HTTP client authorized to consume the subscription, e.g. with the
+	// scope https://www.googleapis.com/auth/pubsub. Must be set.
+	Client *http.Client
+
+	// The Pub/Sub subscription from which to pull messages, of the form
+	// projects/<project>/subscriptions/<subscription>. It must be attached to
+	// the topic of a notification configuration. Must be set.
+	Subscription string
+
+	// The maximum number of messages to pull at a time. If zero, 100 is used.
+	MaxMessages int
+
+	// After a transient failure to pull messages, Watch sleeps for
+	// InitialBackoff, doubling for each further consecutive failure up to
+	// MaxBackoff. If zero, one second and one minute are used.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// How long to allow for acknowledgements, which are sent even after the
+// context passed to Watch is cancelled.
+const ackTimeout = 10 * time.Second
+
+// Pull messages from the configured subscription, writing an event for each
+// into the supplied channel, until the context is cancelled or a permanent
+// error occurs. Returns the context's error in the former case.
+//
+// Each message is acknowledged once its event has been written to the
+// channel. Messages whose events are not written because the context is
+// cancelled are handed back to Pub/Sub for prompt redelivery, and messages
+// that weren't published by GCS are acknowledged and discarded. Delivery is
+// at least once: events may occasionally be duplicated, for example when an
+// acknowledgement fails.
+func Watch(
+	ctx context.Context,
+	cfg *Config,
+	events chan<- *ObjectChangeEvent) (err error) {
+	if cfg.Client == nil || cfg.Subscription == "" {
+		err = errors.New("Config.Client and Config.Subscription must be set")
+		return
+	}
+
+	// Fill in defaults.
+	maxMessages := cfg.MaxMessages
+	if maxMessages == 0 {
+		maxMessages = 100
+	}
+
+	initialBackoff := cfg.InitialBackoff
+	if initialBackoff == 0 {
+		initialBackoff = time.Second
+	}
+
+	maxBackoff := cfg.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = time.Minute
+	}
+
+	c := &pubsubClient{
+		client:       cfg.Client,
+		subscription: cfg.Subscription,
+	}
+
+	var backoff time.Duration
+	for {
+		// Pull a batch of messages.
+		var msgs []*receivedMessage
+		msgs, err = c.pull(ctx, maxMessages)
+
+		switch {
+		case ctx.Err() != nil:
+			err = ctx.Err()
+			return
+
+		case err != nil && gcs.IsTransient(err):
+			backoff *= 2
+			if backoff == 0 {
+				backoff = initialBackoff
+			}
+
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+
+			select {
+			case <-time.After(backoff):
+				continue
+
+			case <-ctx.Done():
+				err = ctx.Err()
+				return
+			}
+
+		case err != nil:
+			err = fmt.Errorf("pull: %v", err)
+			return
+		}
+
+		backoff = 0
+
+		// Pass them on.
+		if err = deliver(ctx, c, msgs, events); err != nil {
+			return
+		}
+	}
+}
+
+// Write events for the supplied messages to the channel, then acknowledge
+// them. If the context is cancelled first, the undelivered messages are
+// nacked.
+func deliver(
+	ctx context.Context,
+	c *pubsubClient,
+	msgs []*receivedMessage,
+	events chan<- *ObjectChangeEvent) (err error) {
+	var done []string
+
+	// Acknowledge whatever we got through, and nack the rest. Use a fresh
+	// context, since ours may have been cancelled. Failures here merely cause
+	// redelivery, so there's no need to report them.
+	defer func() {
+		ackCtx, cancel := context.WithTimeout(context.Background(), ackTimeout)
+		defer cancel()
+
+		if len(done) > 0 {
+			c.acknowledge(ackCtx, done)
+		}
+
+		var rest []string
+		for _, m := range msgs[len(done):] {
+			rest = append(rest, m.AckID)
+		}
+
+		if len(rest) > 0 {
+			c.nack(ackCtx, rest)
+		}
+	}()
+
+	for _, m := range msgs {
+		// Discard messages that we can't make sense of. Redelivery wouldn't help.
+		ev, parseErr := toEvent(&m.Message)
+		if parseErr != nil {
+			done = append(done, m.AckID)
+			continue
+		}
+
+		select {
+		case events <- ev:
+			done = append(done, m.AckID)
+
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,312 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcswatch
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestWatch(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A fake Pub/Sub server. Each pull is answered with the next of the supplied
+// responses, after which pulls block until cancelled.
+type fakePubsub struct {
+	mu sync.Mutex
+
+	// Status codes and bodies for successive pulls.
+	pullCodes     []int
+	pullResponses []string
+
+	// Ack IDs that have been acknowledged and nacked.
+	acked  []string
+	nacked []string
+}
+
+func (f *fakePubsub) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return
+	}
+
+	var parsed struct {
+		AckIDs []string `json:"ackIds"`
+	}
+
+	if err = json.Unmarshal(body, &parsed); err != nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	code := http.StatusOK
+	var response string
+
+	switch {
+	case strings.HasSuffix(req.URL.Opaque, ":pull"):
+		if len(f.pullResponses) == 0 {
+			f.mu.Unlock()
+			<-req.Context().Done()
+			f.mu.Lock()
+
+			err = req.Context().Err()
+			return
+		}
+
+		code, response = f.pullCodes[0], f.pullResponses[0]
+		f.pullCodes, f.pullResponses = f.pullCodes[1:], f.pullResponses[1:]
+
+	case strings.HasSuffix(req.URL.Opaque, ":acknowledge"):
+		f.acked = append(f.acked, parsed.AckIDs...)
+
+	case strings.HasSuffix(req.URL.Opaque, ":modifyAckDeadline"):
+		f.nacked = append(f.nacked, parsed.AckIDs...)
+
+	default:
+		err = fmt.Errorf("Unexpected URL: %q", req.URL.Opaque)
+		return
+	}
+
+	res = &http.Response{
+		StatusCode: code,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}
+
+	return
+}
+
+func (f *fakePubsub) addPull(code int, response string) {
+	f.pullCodes = append(f.pullCodes, code)
+	f.pullResponses = append(f.pullResponses, response)
+}
+
+// Return a received message in the form GCS would publish, with a JSON
+// payload.
+func gcsMessage(ackID string, eventType string, name string) string {
+	payload := fmt.Sprintf(
+		`{"name": %q, "bucket": "some_bucket", "generation": "17", "size": "4", "crc32c": "AAAAAA=="}`,
+		name)
+
+	return fmt.Sprintf(`{
+		"ackId": %q,
+		"message": {
+			"messageId": "msg-%s",
+			"data": %q,
+			"attributes": {
+				"eventType": %q,
+				"bucketId": "some_bucket",
+				"objectId": %q,
+				"objectGeneration": "17",
+				"overwroteGeneration": "11",
+				"eventTime": "2017-03-01T12:00:00.123Z",
+				"payloadFormat": "JSON_API_V1"
+			}
+		}
+	}`,
+		ackID,
+		ackID,
+		base64.StdEncoding.EncodeToString([]byte(payload)),
+		eventType,
+		name)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type WatchTest struct {
+	ctx    context.Context
+	cancel func()
+	pubsub fakePubsub
+	cfg    Config
+	events chan *ObjectChangeEvent
+
+	// Filled in when Watch returns.
+	watchErr  error
+	watchDone chan struct{}
+}
+
+var _ SetUpInterface = &WatchTest{}
+
+func init() { RegisterTestSuite(&WatchTest{}) }
+
+func (t *WatchTest) SetUp(ti *TestInfo) {
+	t.ctx, t.cancel = context.WithCancel(ti.Ctx)
+	t.cfg = Config{
+		Client:         &http.Client{Transport: &t.pubsub},
+		Subscription:   "projects/p/subscriptions/s",
+		InitialBackoff: time.Millisecond,
+	}
+
+	t.events = make(chan *ObjectChangeEvent)
+	t.watchDone = make(chan struct{})
+}
+
+func (t *WatchTest) start() {
+	go func() {
+		t.watchErr = Watch(t.ctx, &t.cfg, t.events)
+		close(t.watchDone)
+	}()
+}
+
+// Cancel the context and wait for Watch to return.
+func (t *WatchTest) stop() {
+	t.cancel()
+	<-t.watchDone
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *WatchTest) MissingConfig() {
+	err := Watch(t.ctx, &Config{}, t.events)
+	ExpectThat(err, Error(HasSubstr("must be set")))
+}
+
+func (t *WatchTest) DeliversAndAcknowledges() {
+	t.pubsub.addPull(
+		http.StatusOK,
+		fmt.Sprintf(
+			`{"receivedMessages": [%s, %s]}`,
+			gcsMessage("a", "OBJECT_FINALIZE", "foo"),
+			gcsMessage("b", "OBJECT_DELETE", "bar")))
+
+	t.start()
+
+	// First event
+	ev := <-t.events
+	ExpectEq(ObjectFinalize, ev.Type)
+	ExpectEq("some_bucket", ev.Bucket)
+	ExpectEq("foo", ev.Name)
+	ExpectEq(17, ev.Generation)
+	ExpectEq(11, ev.OverwroteGeneration)
+	ExpectEq(0, ev.OverwrittenByGeneration)
+	ExpectEq("msg-a", ev.MessageID)
+	ExpectTrue(
+		ev.Time.Equal(time.Date(2017, 3, 1, 12, 0, 0, 123e6, time.UTC)),
+		"%v",
+		ev.Time)
+
+	AssertNe(nil, ev.Object)
+	ExpectEq("foo", ev.Object.Name)
+	ExpectEq(4, ev.Object.Size)
+
+	// Second event
+	ev = <-t.events
+	ExpectEq(ObjectDelete, ev.Type)
+	ExpectEq("bar", ev.Name)
+
+	t.stop()
+	ExpectEq(context.Canceled, t.watchErr)
+
+	t.pubsub.mu.Lock()
+	defer t.pubsub.mu.Unlock()
+	ExpectThat(t.pubsub.acked, ElementsAre("a", "b"))
+	ExpectThat(t.pubsub.nacked, ElementsAre())
+}
+
+func (t *WatchTest) DiscardsForeignMessages() {
+	t.pubsub.addPull(
+		http.StatusOK,
+		fmt.Sprintf(
+			`{"receivedMessages": [%s, %s]}`,
+			`{"ackId": "a", "message": {"data": "dGFjbw=="}}`,
+			gcsMessage("b", "OBJECT_ARCHIVE", "bar")))
+
+	t.start()
+
+	ev := <-t.events
+	ExpectEq(ObjectArchive, ev.Type)
+	ExpectEq("bar", ev.Name)
+
+	t.stop()
+
+	t.pubsub.mu.Lock()
+	defer t.pubsub.mu.Unlock()
+	ExpectThat(t.pubsub.acked, ElementsAre("a", "b"))
+}
+
+func (t *WatchTest) NacksUndeliveredMessagesOnCancellation() {
+	t.pubsub.addPull(
+		http.StatusOK,
+		fmt.Sprintf(
+			`{"receivedMessages": [%s, %s]}`,
+			gcsMessage("a", "OBJECT_FINALIZE", "foo"),
+			gcsMessage("b", "OBJECT_FINALIZE", "bar")))
+
+	t.start()
+
+	// Take only the first event.
+	ev := <-t.events
+	ExpectEq("foo", ev.Name)
+
+	t.stop()
+	ExpectEq(context.Canceled, t.watchErr)
+
+	t.pubsub.mu.Lock()
+	defer t.pubsub.mu.Unlock()
+	ExpectThat(t.pubsub.acked, ElementsAre("a"))
+	ExpectThat(t.pubsub.nacked, ElementsAre("b"))
+}
+
+func (t *WatchTest) RetriesTransientErrors() {
+	t.pubsub.addPull(http.StatusServiceUnavailable, `{}`)
+	t.pubsub.addPull(http.StatusServiceUnavailable, `{}`)
+	t.pubsub.addPull(
+		http.StatusOK,
+		fmt.Sprintf(
+			`{"receivedMessages": [%s]}`,
+			gcsMessage("a", "OBJECT_METADATA_UPDATE", "foo")))
+
+	t.start()
+
+	ev := <-t.events
+	ExpectEq(ObjectMetadataUpdate, ev.Type)
+
+	t.stop()
+}
+
+func (t *WatchTest) ReturnsPermanentErrors() {
+	t.pubsub.addPull(
+		http.StatusNotFound,
+		`{"error": {"code": 404, "message": "Resource not found"}}`)
+
+	t.start()
+	<-t.watchDone
+
+	ExpectThat(t.watchErr, Error(HasSubstr("pull")))
+	ExpectThat(t.watchErr, Error(HasSubstr("not found")))
+}
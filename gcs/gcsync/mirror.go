@@ -0,0 +1,345 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsync
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"golang.org/x/net/context"
+)
+
+// A summary of what MirrorToDisk did. Names are relative to the prefix and
+// the local directory, use '/' as the separator, and are sorted.
+type MirrorSummary struct {
+	// Objects that were downloaded, in whole or in part.
+	Downloaded []string
+
+	// The subset of Downloaded that continued from a partial file left by an
+	// earlier interrupted call.
+	Resumed []string
+
+	// Objects whose local files already had the right contents.
+	Unchanged []string
+
+	// The number of bytes read from the bucket.
+	BytesDownloaded int64
+}
+
+// The suffix, followed by the object's generation, of the name of the file
+// into which an object is downloaded before being renamed into place.
+const partialSuffix = ".gcsync-partial-"
+
+// Download each object with the given prefix in the bucket to the file in dir
+// named by the rest of its name, creating subdirectories as necessary.
+// Objects whose names end in a slash just cause the corresponding directory
+// to be created. Objects whose names would lead outside of dir are refused
+// with an error.
+//
+// Objects are downloaded concurrently, each into a partial file named for
+// its generation that is renamed into place once the contents have been
+// checked against the object's CRC32C. If MirrorToDisk is interrupted, a
+// later call resumes from the partial files with ranged reads, provided the
+// objects haven't been overwritten in the meantime. Local files that already
+// have the right size and CRC32C are left alone. Files are given the
+// modification time recorded by Sync, where present.
+//
+// Compressed objects are stored on disk as they are in the bucket, without
+// decompressing.
+func MirrorToDisk(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	prefix string,
+	dir string) (summary *MirrorSummary, err error) {
+	objects, _, err := gcsutil.ListAll(
+		ctx,
+		bucket,
+		&gcs.ListObjectsRequest{Prefix: prefix})
+
+	if err != nil {
+		err = fmt.Errorf("ListAll: %v", err)
+		return
+	}
+
+	m := &mirrorer{
+		bucket:  bucket,
+		dir:     dir,
+		objects: make(map[string]*gcs.Object),
+		summary: &MirrorSummary{},
+	}
+
+	var names []string
+	for _, o := range objects {
+		name := strings.TrimPrefix(o.Name, prefix)
+		if !localNameIsSafe(name) {
+			err = fmt.Errorf("Refusing to write object %q outside of %q", o.Name, dir)
+			return
+		}
+
+		m.objects[name] = o
+		names = append(names, name)
+	}
+
+	err = forEach(ctx, DefaultParallelism, names, m.mirrorObject)
+	if err != nil {
+		return
+	}
+
+	summary = m.summary
+	sort.Strings(summary.Downloaded)
+	sort.Strings(summary.Resumed)
+	sort.Strings(summary.Unchanged)
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+type mirrorer struct {
+	bucket gcs.Bucket
+	dir    string
+
+	// The objects to download, keyed by name relative to the prefix.
+	// Read-only.
+	objects map[string]*gcs.Object
+
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	summary *MirrorSummary
+}
+
+// Return false if the slash-separated relative path would refer to something
+// outside of the directory it's relative to.
+func localNameIsSafe(name string) bool {
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return false
+	}
+
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Compute the size and CRC32C of the named file's contents.
+func checksumFile(p string) (size int64, crc uint32, err error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	h := crc32.New(crc32cTable)
+	if size, err = io.Copy(h, f); err != nil {
+		return
+	}
+
+	crc = h.Sum32()
+	return
+}
+
+// LOCKS_EXCLUDED(m.mu)
+func (m *mirrorer) mirrorObject(ctx context.Context, name string) (err error) {
+	o := m.objects[name]
+	p := filepath.Join(m.dir, filepath.FromSlash(name))
+
+	// Placeholders correspond to directories.
+	if name == "" || strings.HasSuffix(name, "/") {
+		err = os.MkdirAll(p, 0755)
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return
+	}
+
+	// Is the file already up to date?
+	size, crc, err := checksumFile(p)
+	switch {
+	case os.IsNotExist(err):
+		err = nil
+
+	case err != nil:
+		err = fmt.Errorf("checksumFile: %v", err)
+		return
+
+	case uint64(size) == o.Size && crc == o.CRC32C:
+		m.mu.Lock()
+		m.summary.Unchanged = append(m.summary.Unchanged, name)
+		m.mu.Unlock()
+		return
+	}
+
+	// Clear away partial files for other generations. (Not with filepath.Glob,
+	// since the name may contain metacharacters.)
+	partial := fmt.Sprintf("%s%s%d", p, partialSuffix, o.Generation)
+
+	entries, err := os.ReadDir(filepath.Dir(p))
+	if err != nil {
+		err = fmt.Errorf("ReadDir: %v", err)
+		return
+	}
+
+	stalePrefix := filepath.Base(p) + partialSuffix
+	for _, e := range entries {
+		s := filepath.Join(filepath.Dir(p), e.Name())
+		if strings.HasPrefix(e.Name(), stalePrefix) && s != partial {
+			if err = os.Remove(s); err != nil {
+				return
+			}
+		}
+	}
+
+	// Open the partial file, finding out how much we already have.
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	start := uint64(fi.Size())
+	if start > o.Size {
+		err = fmt.Errorf("Partial file %q is larger than object %q", partial, o.Name)
+		return
+	}
+
+	// Fetch the rest. Ask for the bytes as stored, so that they match the
+	// checksum.
+	n, err := m.download(ctx, o, start, f)
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return
+	}
+
+	// Check the whole thing, starting over next time if it's wrong.
+	size, crc, err = checksumFile(partial)
+	if err != nil {
+		err = fmt.Errorf("checksumFile: %v", err)
+		return
+	}
+
+	if uint64(size) != o.Size || crc != o.CRC32C {
+		os.Remove(partial)
+		err = &gcs.ChecksumMismatchError{
+			Err: fmt.Errorf(
+				"Downloaded %d bytes with CRC32C %#08x for %q, expected %d bytes with %#08x",
+				size,
+				crc,
+				o.Name,
+				o.Size,
+				o.CRC32C),
+		}
+
+		return
+	}
+
+	// Set the modification time recorded by Sync, if any.
+	if s, ok := o.Metadata[MtimeMetadataKey]; ok {
+		var mtime time.Time
+		if mtime, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			err = fmt.Errorf("Parsing mtime for %q: %v", o.Name, err)
+			return
+		}
+
+		if err = os.Chtimes(partial, mtime, mtime); err != nil {
+			return
+		}
+	}
+
+	if err = os.Rename(partial, p); err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	m.summary.Downloaded = append(m.summary.Downloaded, name)
+	if start != 0 {
+		m.summary.Resumed = append(m.summary.Resumed, name)
+	}
+
+	m.summary.BytesDownloaded += n
+	m.mu.Unlock()
+
+	return
+}
+
+// Append the contents of the given generation of the object from offset start
+// onward to w.
+func (m *mirrorer) download(
+	ctx context.Context,
+	o *gcs.Object,
+	start uint64,
+	w io.Writer) (n int64, err error) {
+	if start == o.Size {
+		return
+	}
+
+	rc, err := m.bucket.NewReader(ctx, &gcs.ReadObjectRequest{
+		Name:           o.Name,
+		Generation:     o.Generation,
+		Range:          &gcs.ByteRange{Start: start, Limit: o.Size},
+		ReadCompressed: true,
+	})
+
+	if err != nil {
+		err = fmt.Errorf("NewReader(%q): %v", o.Name, err)
+		return
+	}
+
+	defer rc.Close()
+
+	n, err = io.Copy(w, rc)
+	if err != nil {
+		err = fmt.Errorf("Copying %q: %v", o.Name, err)
+		return
+	}
+
+	if uint64(n) != o.Size-start {
+		err = fmt.Errorf("Read %d bytes of %q, expected %d", n, o.Name, o.Size-start)
+		return
+	}
+
+	return
+}
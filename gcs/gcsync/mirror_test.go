@@ -0,0 +1,244 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsync_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/gcloud/gcs/gcsync"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestMirror(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type MirrorTest struct {
+	ctx    context.Context
+	bucket gcs.Bucket
+	dir    string
+}
+
+var _ SetUpInterface = &MirrorTest{}
+var _ TearDownInterface = &MirrorTest{}
+
+func init() { RegisterTestSuite(&MirrorTest{}) }
+
+func (t *MirrorTest) SetUp(ti *TestInfo) {
+	var err error
+
+	t.ctx = ti.Ctx
+	t.bucket = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	t.dir, err = ioutil.TempDir("", "gcsync_test")
+	AssertEq(nil, err)
+}
+
+func (t *MirrorTest) TearDown() {
+	os.RemoveAll(t.dir)
+}
+
+func (t *MirrorTest) create(name string, contents string) *gcs.Object {
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, name, []byte(contents))
+	AssertEq(nil, err)
+	return o
+}
+
+func (t *MirrorTest) readFile(name string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(t.dir, filepath.FromSlash(name)))
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+func (t *MirrorTest) mirror() *gcsync.MirrorSummary {
+	summary, err := gcsync.MirrorToDisk(t.ctx, t.bucket, "src/", t.dir)
+	AssertEq(nil, err)
+	return summary
+}
+
+// The partial file for the given generation of the given file.
+func (t *MirrorTest) partialPath(name string, generation int64) string {
+	return fmt.Sprintf(
+		"%s.gcsync-partial-%d",
+		filepath.Join(t.dir, filepath.FromSlash(name)),
+		generation)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *MirrorTest) DownloadsEverythingUnderPrefix() {
+	t.create("src/foo", "taco")
+	t.create("src/bar/baz", "burrito")
+	t.create("src/empty/", "")
+	t.create("other", "enchilada")
+
+	summary := t.mirror()
+	ExpectThat(summary.Downloaded, ElementsAre("bar/baz", "foo"))
+	ExpectThat(summary.Resumed, ElementsAre())
+	ExpectEq(len("taco")+len("burrito"), summary.BytesDownloaded)
+
+	ExpectEq("taco", t.readFile("foo"))
+	ExpectEq("burrito", t.readFile("bar/baz"))
+
+	fi, err := os.Stat(filepath.Join(t.dir, "empty"))
+	AssertEq(nil, err)
+	ExpectTrue(fi.IsDir())
+
+	_, err = os.Stat(filepath.Join(t.dir, "other"))
+	ExpectTrue(os.IsNotExist(err), "err: %v", err)
+
+	// A second time, nothing needs doing.
+	summary = t.mirror()
+	ExpectThat(summary.Downloaded, ElementsAre())
+	ExpectThat(summary.Unchanged, ElementsAre("bar/baz", "foo"))
+}
+
+func (t *MirrorTest) ChangedFilesAreReplaced() {
+	t.create("src/foo", "taco")
+	t.mirror()
+
+	t.create("src/foo", "burrito")
+
+	summary := t.mirror()
+	ExpectThat(summary.Downloaded, ElementsAre("foo"))
+	ExpectEq("burrito", t.readFile("foo"))
+}
+
+func (t *MirrorTest) RoundTripsWithSync() {
+	srcDir, err := ioutil.TempDir("", "gcsync_test")
+	AssertEq(nil, err)
+	defer os.RemoveAll(srcDir)
+
+	p := filepath.Join(srcDir, "foo")
+	AssertEq(nil, ioutil.WriteFile(p, []byte("taco"), 0600))
+	AssertEq(nil, os.Chtimes(p, someTime, someTime))
+
+	_, err = gcsync.Sync(t.ctx, t.bucket, "src/", srcDir, nil)
+	AssertEq(nil, err)
+
+	t.mirror()
+	ExpectEq("taco", t.readFile("foo"))
+
+	fi, err := os.Stat(filepath.Join(t.dir, "foo"))
+	AssertEq(nil, err)
+	ExpectTrue(fi.ModTime().Equal(someTime), "%v", fi.ModTime())
+}
+
+func (t *MirrorTest) ResumesPartialFiles() {
+	o := t.create("src/foo", "tacoburrito")
+
+	partial := t.partialPath("foo", o.Generation)
+	AssertEq(nil, ioutil.WriteFile(partial, []byte("taco"), 0644))
+
+	summary := t.mirror()
+	ExpectThat(summary.Downloaded, ElementsAre("foo"))
+	ExpectThat(summary.Resumed, ElementsAre("foo"))
+	ExpectEq(len("burrito"), summary.BytesDownloaded)
+	ExpectEq("tacoburrito", t.readFile("foo"))
+
+	_, err := os.Stat(partial)
+	ExpectTrue(os.IsNotExist(err), "err: %v", err)
+}
+
+func (t *MirrorTest) CorruptPartialFile() {
+	o := t.create("src/foo", "tacoburrito")
+
+	partial := t.partialPath("foo", o.Generation)
+	AssertEq(nil, ioutil.WriteFile(partial, []byte("TACO"), 0644))
+
+	_, err := gcsync.MirrorToDisk(t.ctx, t.bucket, "src/", t.dir)
+	ExpectThat(err, HasSameTypeAs(&gcs.ChecksumMismatchError{}))
+
+	// The partial file is discarded, so the next attempt starts over.
+	summary := t.mirror()
+	ExpectThat(summary.Resumed, ElementsAre())
+	ExpectEq("tacoburrito", t.readFile("foo"))
+}
+
+func (t *MirrorTest) StalePartialFilesAreDiscarded() {
+	o := t.create("src/foo", "tacoburrito")
+
+	stale := t.partialPath("foo", o.Generation-1)
+	AssertEq(nil, ioutil.WriteFile(stale, []byte("enchilada"), 0644))
+
+	summary := t.mirror()
+	ExpectThat(summary.Resumed, ElementsAre())
+	ExpectEq("tacoburrito", t.readFile("foo"))
+
+	_, err := os.Stat(stale)
+	ExpectTrue(os.IsNotExist(err), "err: %v", err)
+}
+
+func (t *MirrorTest) StalePartialFilesWithMetacharacters() {
+	o := t.create("src/[a]*?", "tacoburrito")
+
+	stale := t.partialPath("[a]*?", o.Generation-1)
+	AssertEq(nil, ioutil.WriteFile(stale, []byte("enchilada"), 0644))
+
+	// A file whose name matches the pattern but isn't a partial file for this
+	// one should be left alone.
+	other := t.partialPath("a??", o.Generation-1)
+	AssertEq(nil, ioutil.WriteFile(other, []byte("queso"), 0644))
+
+	summary := t.mirror()
+	ExpectThat(summary.Resumed, ElementsAre())
+	ExpectEq("tacoburrito", t.readFile("[a]*?"))
+
+	_, err := os.Stat(stale)
+	ExpectTrue(os.IsNotExist(err), "err: %v", err)
+
+	_, err = os.Stat(other)
+	ExpectEq(nil, err)
+}
+
+func (t *MirrorTest) CompressedObjectsAreStoredAsIs() {
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "src/foo",
+			Contents: strings.NewReader("taco"),
+			Compress: true,
+		})
+
+	AssertEq(nil, err)
+
+	t.mirror()
+
+	contents := t.readFile("foo")
+	ExpectEq(o.Size, len(contents))
+	ExpectNe("taco", contents)
+}
+
+func (t *MirrorTest) NamesOutsideDirectory() {
+	t.create("src/../evil", "taco")
+
+	_, err := gcsync.MirrorToDisk(t.ctx, t.bucket, "src/", t.dir)
+	ExpectThat(err, Error(HasSubstr("outside")))
+}
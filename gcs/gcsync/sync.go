@@ -0,0 +1,387 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsync
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/syncutil"
+	"golang.org/x/net/context"
+)
+
+// The metadata key under which Sync records the modification time of the
+// local file from which an object was uploaded, in RFC 3339 format with
+// nanoseconds.
+const MtimeMetadataKey = "gcsync-mtime"
+
+// The default for Options.Parallelism.
+const DefaultParallelism = 8
+
+// Options accepted by Sync. The zero value is a sensible default.
+type Options struct {
+	// If true, delete objects under the prefix that don't correspond to any
+	// local file. Objects whose names end in a slash, which are commonly used
+	// as directory placeholders, are left alone.
+	//
+	// The prefix must then be empty or end with a slash, since otherwise it
+	// also matches objects that don't belong to the directory: with prefix
+	// "photos", objects under "photos-old/" would be deleted.
+	Delete bool
+
+	// If true, compare the CRC32C checksum of every local file with its
+	// object, even when the size and recorded modification time agree.
+	AlwaysChecksum bool
+
+	// If true, report what would be done without modifying the bucket.
+	DryRun bool
+
+	// The maximum number of files to check, upload, or delete at once. If
+	// zero, DefaultParallelism is used.
+	Parallelism int
+}
+
+// A summary of what Sync did. Names are relative to the local directory and
+// the prefix, use '/' as the separator, and are sorted.
+type Summary struct {
+	// Files that were uploaded because their objects were missing or had
+	// different contents.
+	Uploaded []string
+
+	// Files whose objects had the same contents but a different recorded
+	// modification time, which was updated without uploading.
+	Touched []string
+
+	// Files whose objects were already up to date.
+	Unchanged []string
+
+	// Names of objects that were deleted because there was no corresponding
+	// local file. See Options.Delete.
+	Deleted []string
+
+	// The total size of the uploaded files.
+	BytesUploaded int64
+}
+
+// Make the objects with the given prefix in the bucket mirror the regular
+// files in localDir and its subdirectories. The object for a file is named by
+// the prefix followed by the file's slash-separated path relative to
+// localDir, so the prefix will usually be empty or end with a slash. Symbolic
+// links and other non-regular files are skipped.
+//
+// A file is considered up to date if its object has the same size and the
+// modification time recorded under MtimeMetadataKey matches the file's. If
+// not (or if Options.AlwaysChecksum is set) the file's CRC32C is compared
+// with the object's, and the file is uploaded only if they differ.
+//
+// Deletions, if requested, happen after all uploads have succeeded, and only
+// for objects that haven't been changed since they were listed. If an error
+// is returned, some changes may already have been made.
+func Sync(
+	ctx context.Context,
+	bucket gcs.Bucket,
+	prefix string,
+	localDir string,
+	opts *Options) (summary *Summary, err error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if opts.Delete && prefix != "" && !strings.HasSuffix(prefix, "/") {
+		err = fmt.Errorf(
+			"Delete requires a prefix that is empty or ends with a slash, not %q",
+			prefix)
+		return
+	}
+
+	parallelism := opts.Parallelism
+	if parallelism == 0 {
+		parallelism = DefaultParallelism
+	}
+
+	s := &syncer{
+		bucket:   bucket,
+		prefix:   prefix,
+		localDir: localDir,
+		opts:     opts,
+		remote:   make(map[string]*gcs.Object),
+		summary:  &Summary{},
+	}
+
+	// Find the local files.
+	files, err := listLocalFiles(localDir)
+	if err != nil {
+		err = fmt.Errorf("listLocalFiles: %v", err)
+		return
+	}
+
+	// And the existing objects.
+	objects, _, err := gcsutil.ListAll(
+		ctx,
+		bucket,
+		&gcs.ListObjectsRequest{Prefix: prefix})
+
+	if err != nil {
+		err = fmt.Errorf("ListAll: %v", err)
+		return
+	}
+
+	for _, o := range objects {
+		s.remote[strings.TrimPrefix(o.Name, prefix)] = o
+	}
+
+	// Bring each file up to date.
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+
+	err = forEach(ctx, parallelism, names, func(ctx context.Context, name string) error {
+		return s.syncFile(ctx, name, files[name])
+	})
+
+	if err != nil {
+		return
+	}
+
+	// Delete whatever is left over, if requested.
+	if opts.Delete {
+		var extraneous []string
+		for name := range s.remote {
+			if _, ok := files[name]; !ok && !strings.HasSuffix(name, "/") {
+				extraneous = append(extraneous, name)
+			}
+		}
+
+		err = forEach(ctx, parallelism, extraneous, s.deleteObject)
+		if err != nil {
+			return
+		}
+	}
+
+	summary = s.summary
+	sort.Strings(summary.Uploaded)
+	sort.Strings(summary.Touched)
+	sort.Strings(summary.Unchanged)
+	sort.Strings(summary.Deleted)
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+type syncer struct {
+	bucket   gcs.Bucket
+	prefix   string
+	localDir string
+	opts     *Options
+
+	// Existing objects, keyed by name relative to the prefix. Read-only once
+	// populated.
+	remote map[string]*gcs.Object
+
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	summary *Summary
+}
+
+// Return information about each regular file within dir, keyed by its
+// slash-separated path relative to dir.
+func listLocalFiles(dir string) (files map[string]os.FileInfo, err error) {
+	files = make(map[string]os.FileInfo)
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		files[filepath.ToSlash(rel)] = fi
+		return nil
+	})
+
+	return
+}
+
+func formatMtime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Call f for each of the supplied names, with the given parallelism, stopping
+// at the first error.
+func forEach(
+	ctx context.Context,
+	parallelism int,
+	names []string,
+	f func(context.Context, string) error) (err error) {
+	bundle := syncutil.NewBundle(ctx)
+
+	nameChan := make(chan string, len(names))
+	for _, n := range names {
+		nameChan <- n
+	}
+
+	close(nameChan)
+
+	for i := 0; i < parallelism; i++ {
+		bundle.Add(func(ctx context.Context) (err error) {
+			for n := range nameChan {
+				if err = f(ctx, n); err != nil {
+					return
+				}
+			}
+
+			return
+		})
+	}
+
+	err = bundle.Join()
+	return
+}
+
+// LOCKS_EXCLUDED(s.mu)
+func (s *syncer) syncFile(
+	ctx context.Context,
+	name string,
+	fi os.FileInfo) (err error) {
+	o := s.remote[name]
+	mtime := formatMtime(fi.ModTime())
+	sameSize := o != nil && o.Size == uint64(fi.Size())
+
+	// The quick check.
+	if sameSize && !s.opts.AlwaysChecksum && o.Metadata[MtimeMetadataKey] == mtime {
+		s.mu.Lock()
+		s.summary.Unchanged = append(s.summary.Unchanged, name)
+		s.mu.Unlock()
+		return
+	}
+
+	// Checksum the file.
+	f, err := os.Open(filepath.Join(s.localDir, filepath.FromSlash(name)))
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	h := crc32.New(crc32cTable)
+	if _, err = io.Copy(h, f); err != nil {
+		err = fmt.Errorf("Reading %q: %v", name, err)
+		return
+	}
+
+	crc := h.Sum32()
+
+	// If the contents are the same, we need at most to record the new mtime.
+	if sameSize && o.CRC32C == crc {
+		if o.Metadata[MtimeMetadataKey] == mtime {
+			s.mu.Lock()
+			s.summary.Unchanged = append(s.summary.Unchanged, name)
+			s.mu.Unlock()
+			return
+		}
+
+		if !s.opts.DryRun {
+			_, err = s.bucket.UpdateObject(ctx, &gcs.UpdateObjectRequest{
+				Name:     o.Name,
+				Metadata: map[string]*string{MtimeMetadataKey: &mtime},
+			})
+
+			if err != nil {
+				err = fmt.Errorf("UpdateObject(%q): %v", o.Name, err)
+				return
+			}
+		}
+
+		s.mu.Lock()
+		s.summary.Touched = append(s.summary.Touched, name)
+		s.mu.Unlock()
+		return
+	}
+
+	// Otherwise upload, checking that what arrives is what we checksummed.
+	if !s.opts.DryRun {
+		if _, err = f.Seek(0, 0); err != nil {
+			err = fmt.Errorf("Seek: %v", err)
+			return
+		}
+
+		req := &gcs.CreateObjectRequest{
+			Name:        s.prefix + name,
+			ContentType: mime.TypeByExtension(path.Ext(name)),
+			CRC32C:      &crc,
+			Contents:    f,
+			Metadata:    map[string]string{MtimeMetadataKey: mtime},
+		}
+
+		if _, err = s.bucket.CreateObject(ctx, req); err != nil {
+			err = fmt.Errorf("CreateObject(%q): %v", req.Name, err)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.summary.Uploaded = append(s.summary.Uploaded, name)
+	s.summary.BytesUploaded += fi.Size()
+	s.mu.Unlock()
+
+	return
+}
+
+// LOCKS_EXCLUDED(s.mu)
+func (s *syncer) deleteObject(ctx context.Context, name string) (err error) {
+	o := s.remote[name]
+
+	if !s.opts.DryRun {
+		err = s.bucket.DeleteObject(ctx, &gcs.DeleteObjectRequest{
+			Name:                   o.Name,
+			GenerationPrecondition: &o.Generation,
+		})
+
+		if err != nil {
+			err = fmt.Errorf("DeleteObject(%q): %v", o.Name, err)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.summary.Deleted = append(s.summary.Deleted, name)
+	s.mu.Unlock()
+
+	return
+}
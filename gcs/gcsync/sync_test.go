@@ -0,0 +1,280 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsync_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	"github.com/jacobsa/gcloud/gcs/gcsync"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+	"golang.org/x/net/context"
+)
+
+func TestSync(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type SyncTest struct {
+	ctx    context.Context
+	bucket gcs.Bucket
+	dir    string
+}
+
+var _ SetUpInterface = &SyncTest{}
+var _ TearDownInterface = &SyncTest{}
+
+func init() { RegisterTestSuite(&SyncTest{}) }
+
+func (t *SyncTest) SetUp(ti *TestInfo) {
+	var err error
+
+	t.ctx = ti.Ctx
+	t.bucket = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	t.dir, err = ioutil.TempDir("", "gcsync_test")
+	AssertEq(nil, err)
+}
+
+func (t *SyncTest) TearDown() {
+	os.RemoveAll(t.dir)
+}
+
+var someTime = time.Date(2015, 6, 3, 1, 2, 3, 4, time.UTC)
+
+// Write a file within the directory with the given modification time.
+func (t *SyncTest) writeFile(name string, contents string, mtime time.Time) {
+	p := filepath.Join(t.dir, filepath.FromSlash(name))
+
+	err := os.MkdirAll(filepath.Dir(p), 0700)
+	AssertEq(nil, err)
+
+	err = ioutil.WriteFile(p, []byte(contents), 0600)
+	AssertEq(nil, err)
+
+	err = os.Chtimes(p, mtime, mtime)
+	AssertEq(nil, err)
+}
+
+func (t *SyncTest) sync(opts *gcsync.Options) *gcsync.Summary {
+	summary, err := gcsync.Sync(t.ctx, t.bucket, "dst/", t.dir, opts)
+	AssertEq(nil, err)
+	return summary
+}
+
+func (t *SyncTest) read(name string) string {
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, name)
+	AssertEq(nil, err)
+	return string(contents)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *SyncTest) EmptyDirectory() {
+	summary := t.sync(nil)
+	ExpectThat(summary.Uploaded, ElementsAre())
+	ExpectThat(summary.Unchanged, ElementsAre())
+	ExpectEq(0, summary.BytesUploaded)
+}
+
+func (t *SyncTest) InitialSync() {
+	t.writeFile("foo.txt", "taco", someTime)
+	t.writeFile("bar/baz", "burrito", someTime)
+
+	summary := t.sync(nil)
+	ExpectThat(summary.Uploaded, ElementsAre("bar/baz", "foo.txt"))
+	ExpectThat(summary.Touched, ElementsAre())
+	ExpectThat(summary.Unchanged, ElementsAre())
+	ExpectEq(len("taco")+len("burrito"), summary.BytesUploaded)
+
+	ExpectEq("taco", t.read("dst/foo.txt"))
+	ExpectEq("burrito", t.read("dst/bar/baz"))
+
+	o, err := t.bucket.StatObject(
+		t.ctx,
+		&gcs.StatObjectRequest{Name: "dst/foo.txt"})
+
+	AssertEq(nil, err)
+	ExpectEq("2015-06-03T01:02:03.000000004Z", o.Metadata[gcsync.MtimeMetadataKey])
+	ExpectEq("text/plain; charset=utf-8", o.ContentType)
+}
+
+func (t *SyncTest) NothingChanged() {
+	t.writeFile("foo", "taco", someTime)
+	t.sync(nil)
+
+	summary := t.sync(nil)
+	ExpectThat(summary.Uploaded, ElementsAre())
+	ExpectThat(summary.Unchanged, ElementsAre("foo"))
+
+	// Checksumming comes to the same conclusion.
+	summary = t.sync(&gcsync.Options{AlwaysChecksum: true})
+	ExpectThat(summary.Uploaded, ElementsAre())
+	ExpectThat(summary.Unchanged, ElementsAre("foo"))
+}
+
+func (t *SyncTest) OnlyMtimeChanged() {
+	t.writeFile("foo", "taco", someTime)
+	t.sync(nil)
+
+	t.writeFile("foo", "taco", someTime.Add(time.Hour))
+	summary := t.sync(nil)
+	ExpectThat(summary.Uploaded, ElementsAre())
+	ExpectThat(summary.Touched, ElementsAre("foo"))
+
+	// The new time was recorded.
+	summary = t.sync(nil)
+	ExpectThat(summary.Touched, ElementsAre())
+	ExpectThat(summary.Unchanged, ElementsAre("foo"))
+}
+
+func (t *SyncTest) ContentsChanged() {
+	t.writeFile("foo", "taco", someTime)
+	t.writeFile("bar", "enchilada", someTime)
+	t.sync(nil)
+
+	// A change of size with the same mtime, and of contents but not size.
+	t.writeFile("foo", "burrito", someTime)
+	t.writeFile("bar", "quesadilla"[:9], someTime.Add(time.Hour))
+
+	summary := t.sync(nil)
+	ExpectThat(summary.Uploaded, ElementsAre("bar", "foo"))
+	ExpectEq(len("burrito")+9, summary.BytesUploaded)
+
+	ExpectEq("burrito", t.read("dst/foo"))
+	ExpectEq("quesadill", t.read("dst/bar"))
+}
+
+func (t *SyncTest) AlwaysChecksum() {
+	t.writeFile("foo", "taco", someTime)
+	t.sync(nil)
+
+	// Same size and mtime, different contents. Only checksumming notices.
+	t.writeFile("foo", "tako", someTime)
+
+	summary := t.sync(nil)
+	ExpectThat(summary.Unchanged, ElementsAre("foo"))
+
+	summary = t.sync(&gcsync.Options{AlwaysChecksum: true})
+	ExpectThat(summary.Uploaded, ElementsAre("foo"))
+	ExpectEq("tako", t.read("dst/foo"))
+}
+
+func (t *SyncTest) Delete() {
+	t.writeFile("foo", "taco", someTime)
+
+	err := gcsutil.CreateEmptyObjects(
+		t.ctx,
+		t.bucket,
+		[]string{"dst/bar", "dst/baz/", "dst/baz/qux", "other"})
+
+	AssertEq(nil, err)
+
+	// Without the option, nothing is deleted.
+	summary := t.sync(nil)
+	ExpectThat(summary.Deleted, ElementsAre())
+
+	// With it, extraneous objects within the prefix go, but not placeholders.
+	summary = t.sync(&gcsync.Options{Delete: true})
+	ExpectThat(summary.Deleted, ElementsAre("bar", "baz/qux"))
+
+	objects, _, err := gcsutil.ListAll(t.ctx, t.bucket, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+
+	ExpectThat(names, ElementsAre("dst/baz/", "dst/foo", "other"))
+}
+
+func (t *SyncTest) DeleteWithPartialPrefix() {
+	t.writeFile("foo", "taco", someTime)
+
+	err := gcsutil.CreateEmptyObjects(
+		t.ctx,
+		t.bucket,
+		[]string{"dst-old/bar", "dstbar"})
+
+	AssertEq(nil, err)
+
+	// A prefix without a trailing slash would match the siblings above, so
+	// deleting is refused.
+	_, err = gcsync.Sync(t.ctx, t.bucket, "dst", t.dir, &gcsync.Options{Delete: true})
+	ExpectThat(err, Error(HasSubstr("ends with a slash")))
+
+	objects, _, err := gcsutil.ListAll(t.ctx, t.bucket, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+
+	ExpectThat(names, ElementsAre("dst-old/bar", "dstbar"))
+
+	// Without deleting, such a prefix is fine.
+	_, err = gcsync.Sync(t.ctx, t.bucket, "dst", t.dir, nil)
+	AssertEq(nil, err)
+	ExpectEq("taco", t.read("dstfoo"))
+}
+
+func (t *SyncTest) DryRun() {
+	t.writeFile("foo", "taco", someTime)
+	t.writeFile("bar", "burrito", someTime)
+	t.sync(nil)
+
+	t.writeFile("foo", "enchilada", someTime)
+	t.writeFile("bar", "burrito", someTime.Add(time.Hour))
+	t.writeFile("baz", "queso", someTime)
+
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "dst/qux", []byte{})
+	AssertEq(nil, err)
+
+	summary := t.sync(&gcsync.Options{DryRun: true, Delete: true})
+	ExpectThat(summary.Uploaded, ElementsAre("baz", "foo"))
+	ExpectThat(summary.Touched, ElementsAre("bar"))
+	ExpectThat(summary.Deleted, ElementsAre("qux"))
+
+	// Nothing happened.
+	ExpectEq("taco", t.read("dst/foo"))
+	ExpectEq("", t.read("dst/qux"))
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "dst/baz"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *SyncTest) SymlinksAreSkipped() {
+	t.writeFile("foo", "taco", someTime)
+
+	err := os.Symlink("foo", filepath.Join(t.dir, "bar"))
+	AssertEq(nil, err)
+
+	summary := t.sync(nil)
+	ExpectThat(summary.Uploaded, ElementsAre("foo"))
+}
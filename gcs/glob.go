@@ -0,0 +1,212 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A compiled glob pattern, as accepted by ListObjectsRequest.MatchGlob.
+type Glob struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// Compile a glob pattern matching entire object names. The syntax follows
+// GCS's:
+//
+//  *  "*" matches zero or more characters other than '/'.
+//
+//  *  "**" matches zero or more characters including '/'. When followed by
+//     '/', as in "a/**/b", it matches zero or more whole path segments.
+//
+//  *  "?" matches exactly one character other than '/'.
+//
+//  *  "[abc]" and "[a-z]" match one character from the set, and "[!abc]"
+//     one character not in it.
+//
+//  *  "{foo,bar}" matches any of the comma-separated alternatives, each of
+//     which may itself contain wildcards.
+//
+//  *  "\" causes the following character to be matched literally.
+//
+// Everything else matches itself.
+func CompileGlob(pattern string) (g *Glob, err error) {
+	var buf bytes.Buffer
+	buf.WriteString(`\A`)
+
+	// Outside of braces, translation always consumes the entire pattern.
+	if _, err = translateGlob(&buf, pattern, false); err != nil {
+		err = fmt.Errorf("Invalid glob %q: %v", pattern, err)
+		return
+	}
+
+	buf.WriteString(`\z`)
+
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		err = fmt.Errorf("Invalid glob %q: %v", pattern, err)
+		return
+	}
+
+	g = &Glob{
+		pattern: pattern,
+		re:      re,
+	}
+
+	return
+}
+
+// Return true iff the supplied name matches the entirety of the pattern.
+func (g *Glob) Match(name string) bool {
+	return g.re.MatchString(name)
+}
+
+func (g *Glob) String() string {
+	return g.pattern
+}
+
+// Escape any glob metacharacters in s, returning a pattern that matches only
+// s itself.
+func escapeGlob(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]{},\`, r) {
+			buf.WriteByte('\\')
+		}
+
+		buf.WriteRune(r)
+	}
+
+	return buf.String()
+}
+
+// Write a regexp equivalent to the glob p to buf, stopping at the end of p or,
+// if inBraces is set, at the first unescaped ',' or '}' at this level. Return
+// the untranslated remainder of p.
+func translateGlob(
+	buf *bytes.Buffer,
+	p string,
+	inBraces bool) (rest string, err error) {
+	for p != "" {
+		switch c := p[0]; {
+		case inBraces && (c == ',' || c == '}'):
+			rest = p
+			return
+
+		case strings.HasPrefix(p, "**/"):
+			buf.WriteString(`(?:.*/)?`)
+			p = p[3:]
+
+		case strings.HasPrefix(p, "**"):
+			buf.WriteString(`.*`)
+			p = p[2:]
+
+		case c == '*':
+			buf.WriteString(`[^/]*`)
+			p = p[1:]
+
+		case c == '?':
+			buf.WriteString(`[^/]`)
+			p = p[1:]
+
+		case c == '\\':
+			if len(p) < 2 {
+				err = fmt.Errorf("trailing backslash")
+				return
+			}
+
+			buf.WriteString(regexp.QuoteMeta(p[1:2]))
+			p = p[2:]
+
+		case c == '[':
+			if p, err = translateGlobClass(buf, p[1:]); err != nil {
+				return
+			}
+
+		case c == '{':
+			buf.WriteString(`(?:`)
+			p = p[1:]
+			for {
+				if p, err = translateGlob(buf, p, true); err != nil {
+					return
+				}
+
+				if p == "" {
+					err = fmt.Errorf("unterminated '{'")
+					return
+				}
+
+				if p[0] == '}' {
+					p = p[1:]
+					break
+				}
+
+				buf.WriteByte('|')
+				p = p[1:]
+			}
+
+			buf.WriteString(`)`)
+
+		default:
+			buf.WriteString(regexp.QuoteMeta(p[:1]))
+			p = p[1:]
+		}
+	}
+
+	return
+}
+
+// Translate a character class whose opening '[' has already been consumed.
+func translateGlobClass(
+	buf *bytes.Buffer,
+	p string) (rest string, err error) {
+	buf.WriteByte('[')
+	if strings.HasPrefix(p, "!") {
+		buf.WriteByte('^')
+		p = p[1:]
+	}
+
+	// As in shells, a leading ']' is a member of the class rather than its end.
+	for first := true; ; first = false {
+		if p == "" {
+			err = fmt.Errorf("unterminated '['")
+			return
+		}
+
+		c := p[0]
+		switch {
+		case c == ']' && !first:
+			buf.WriteByte(']')
+			rest = p[1:]
+			return
+
+		case c == '\\' && len(p) >= 2:
+			buf.WriteString(regexp.QuoteMeta(p[1:2]))
+			p = p[2:]
+
+		case c == '-':
+			buf.WriteByte('-')
+			p = p[1:]
+
+		default:
+			buf.WriteString(regexp.QuoteMeta(p[:1]))
+			p = p[1:]
+		}
+	}
+}
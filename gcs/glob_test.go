@@ -0,0 +1,166 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"testing"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestGlob(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type GlobTest struct {
+}
+
+func init() { RegisterTestSuite(&GlobTest{}) }
+
+type globCase struct {
+	name     string
+	expected bool
+}
+
+func (t *GlobTest) check(pattern string, cases []globCase) {
+	g, err := CompileGlob(pattern)
+	AssertEq(nil, err)
+
+	for _, c := range cases {
+		ExpectEq(c.expected, g.Match(c.name), "pattern: %q, name: %q", pattern, c.name)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *GlobTest) Literal() {
+	t.check("a.b(c)+", []globCase{
+		{"a.b(c)+", true},
+		{"axb(c)+", false},
+		{"a.b(c)", false},
+		{"a.b(c)+d", false},
+		{"", false},
+	})
+}
+
+func (t *GlobTest) Star() {
+	t.check("logs/*.txt", []globCase{
+		{"logs/.txt", true},
+		{"logs/foo.txt", true},
+		{"logs/foo/bar.txt", false},
+		{"logs/foo.txt.gz", false},
+	})
+}
+
+func (t *GlobTest) DoubleStar() {
+	t.check("logs/**.txt", []globCase{
+		{"logs/.txt", true},
+		{"logs/foo.txt", true},
+		{"logs/foo/bar.txt", true},
+		{"other/foo.txt", false},
+	})
+}
+
+func (t *GlobTest) DoubleStarSlash() {
+	t.check("a/**/b", []globCase{
+		{"a/b", true},
+		{"a/x/b", true},
+		{"a/x/y/b", true},
+		{"a/xb", false},
+		{"ab", false},
+	})
+}
+
+func (t *GlobTest) QuestionMark() {
+	t.check("?.txt", []globCase{
+		{"a.txt", true},
+		{"타.txt", true},
+		{".txt", false},
+		{"ab.txt", false},
+		{"/.txt", false},
+	})
+}
+
+func (t *GlobTest) CharacterClasses() {
+	t.check("[a-c]x[!0-9]", []globCase{
+		{"axb", true},
+		{"cxz", true},
+		{"dxb", false},
+		{"ax1", false},
+	})
+
+	t.check("[]!]", []globCase{
+		{"]", true},
+		{"!", true},
+		{"a", false},
+	})
+}
+
+func (t *GlobTest) Alternatives() {
+	t.check("2015/{01,02/*}/log", []globCase{
+		{"2015/01/log", true},
+		{"2015/02/17/log", true},
+		{"2015/02/log", false},
+		{"2015/03/log", false},
+	})
+
+	t.check("{a,{b,c}d}", []globCase{
+		{"a", true},
+		{"bd", true},
+		{"cd", true},
+		{"d", false},
+	})
+}
+
+func (t *GlobTest) Escapes() {
+	t.check(`a\*b\{`, []globCase{
+		{"a*b{", true},
+		{"axb{", false},
+	})
+}
+
+func (t *GlobTest) EscapeGlob() {
+	names := []string{
+		"",
+		"foo/bar",
+		`*?[]{},\`,
+		"a{b,c}[!d]",
+	}
+
+	for _, n := range names {
+		g, err := CompileGlob(escapeGlob(n))
+		AssertEq(nil, err)
+		ExpectTrue(g.Match(n), "%q", n)
+		ExpectFalse(g.Match(n+"x"), "%q", n)
+	}
+}
+
+func (t *GlobTest) Invalid() {
+	patterns := []string{
+		"[abc",
+		"{a,b",
+		`foo\`,
+	}
+
+	for _, p := range patterns {
+		_, err := CompileGlob(p)
+		ExpectThat(err, Error(HasSubstr("Invalid glob")), "%q", p)
+	}
+}
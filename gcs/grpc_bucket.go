@@ -0,0 +1,381 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
+	"google.golang.org/grpc/metadata"
+)
+
+// A bucket that uses the Cloud Storage gRPC API for reading, writing, copying,
+// composing, statting, listing, and deleting objects, and the JSON API (via
+// the wrapped bucket) for everything else.
+//
+// Cf. https://cloud.google.com/storage/docs/grpc
+type grpcBucket struct {
+	client storagepb.StorageClient
+
+	// A bucket using the JSON API, for operations that we don't implement
+	// ourselves.
+	json Bucket
+
+	name        string
+	userProject string
+
+	// The source of buffers for the contents of write messages.
+	bufferPool *BufferPool
+
+	// Extra headers to send as metadata with every call.
+	headers http.Header
+}
+
+func newGRPCBucket(
+	client storagepb.StorageClient,
+	json Bucket,
+	userProject string) *grpcBucket {
+	return &grpcBucket{
+		client:      client,
+		json:        json,
+		name:        json.Name(),
+		userProject: userProject,
+		bufferPool:  defaultBufferPool,
+	}
+}
+
+// Add the metadata that GCS requires to route requests concerning the bucket,
+// and to bill them to the right project, along with any extra headers.
+func (b *grpcBucket) outgoingContext(ctx context.Context) context.Context {
+	kv := []string{
+		"x-goog-request-params",
+		"bucket=" + url.QueryEscape(grpcBucketPath(b.name)),
+	}
+
+	if b.userProject != "" {
+		kv = append(kv, "x-goog-user-project", b.userProject)
+	}
+
+	kv = append(kv, headersToMetadata(b.headers)...)
+
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+func (b *grpcBucket) Name() string {
+	return b.name
+}
+
+func (b *grpcBucket) CopyObject(
+	ctx context.Context,
+	req *CopyObjectRequest) (o *Object, err error) {
+	// The gRPC API has no separate copy operation.
+	o, err = b.RewriteObject(
+		ctx,
+		&RewriteObjectRequest{
+			SrcName:                       req.SrcName,
+			SrcGeneration:                 req.SrcGeneration,
+			SrcMetaGenerationPrecondition: req.SrcMetaGenerationPrecondition,
+			DstName:                       req.DstName,
+			DstPredefinedACL:              req.DstPredefinedACL,
+		})
+
+	return
+}
+
+func (b *grpcBucket) MoveObject(
+	ctx context.Context,
+	req *MoveObjectRequest) (o *Object, err error) {
+	o, err = b.json.MoveObject(ctx, req)
+	return
+}
+
+func (b *grpcBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	if err = checkObjectNames(req.SrcName, req.DstName); err != nil {
+		return
+	}
+
+	dstBucket := req.DstBucket
+	if dstBucket == "" {
+		dstBucket = b.name
+	}
+
+	pbReq := &storagepb.RewriteObjectRequest{
+		DestinationName:             req.DstName,
+		DestinationBucket:           grpcBucketPath(dstBucket),
+		DestinationKmsKey:           req.DstKMSKeyName,
+		DestinationPredefinedAcl:    req.DstPredefinedACL,
+		SourceBucket:                grpcBucketPath(b.name),
+		SourceObject:                req.SrcName,
+		SourceGeneration:            req.SrcGeneration,
+		IfGenerationMatch:           req.DstGenerationPrecondition,
+		IfSourceMetagenerationMatch: req.SrcMetaGenerationPrecondition,
+		MaxBytesRewrittenPerCall:    req.MaxBytesPerCall,
+	}
+
+	if req.DstStorageClass != "" {
+		pbReq.Destination = &storagepb.Object{
+			StorageClass: req.DstStorageClass,
+		}
+	}
+
+	ctx = b.outgoingContext(ctx)
+
+	// Keep calling until GCS says that it's done.
+	for {
+		var res *storagepb.RewriteResponse
+		res, err = b.client.RewriteObject(ctx, pbReq)
+		if err != nil {
+			err = fromGRPCError(err)
+			return
+		}
+
+		if req.Progress != nil {
+			req.Progress(res.TotalBytesRewritten, res.ObjectSize)
+		}
+
+		if res.Done {
+			if res.Resource == nil {
+				err = errors.New("No resource in completed RewriteResponse")
+				return
+			}
+
+			o, err = fromProtoObject(res.Resource)
+			if err != nil {
+				err = fmt.Errorf("fromProtoObject: %v", err)
+				return
+			}
+
+			return
+		}
+
+		if res.RewriteToken == "" {
+			err = errors.New("No rewrite token in incomplete RewriteResponse")
+			return
+		}
+
+		pbReq.RewriteToken = res.RewriteToken
+	}
+}
+
+func (b *grpcBucket) ComposeObjects(
+	ctx context.Context,
+	req *ComposeObjectsRequest) (o *Object, err error) {
+	if err = CheckObjectName(req.DstName); err != nil {
+		return
+	}
+
+	for _, src := range req.Sources {
+		if err = CheckObjectName(src.Name); err != nil {
+			return
+		}
+	}
+
+	pbReq := &storagepb.ComposeObjectRequest{
+		Destination: &storagepb.Object{
+			Bucket:      grpcBucketPath(b.name),
+			Name:        req.DstName,
+			ContentType: req.ContentType,
+			Metadata:    req.Metadata,
+		},
+		IfGenerationMatch:     req.DstGenerationPrecondition,
+		IfMetagenerationMatch: req.DstMetaGenerationPrecondition,
+	}
+
+	for _, src := range req.Sources {
+		pbReq.SourceObjects = append(
+			pbReq.SourceObjects,
+			&storagepb.ComposeObjectRequest_SourceObject{
+				Name:       src.Name,
+				Generation: src.Generation,
+			})
+	}
+
+	pbObject, err := b.client.ComposeObject(b.outgoingContext(ctx), pbReq)
+	if err != nil {
+		err = fromGRPCError(err)
+		return
+	}
+
+	if o, err = fromProtoObject(pbObject); err != nil {
+		err = fmt.Errorf("fromProtoObject: %v", err)
+		return
+	}
+
+	return
+}
+
+// The fields of StatObjectRequest are named as in the JSON API, which doesn't
+// always match the gRPC API, so we ignore them and always return the full
+// record. This is permitted by the StatObjectRequest documentation.
+func (b *grpcBucket) StatObject(
+	ctx context.Context,
+	req *StatObjectRequest) (o *Object, err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	pbObject, err := b.client.GetObject(
+		b.outgoingContext(ctx),
+		&storagepb.GetObjectRequest{
+			Bucket:                grpcBucketPath(b.name),
+			Object:                req.Name,
+			IfGenerationMatch:     req.GenerationPrecondition,
+			IfMetagenerationMatch: req.MetaGenerationPrecondition,
+		})
+
+	if err != nil {
+		err = fromGRPCError(err)
+		return
+	}
+
+	if o, err = fromProtoObject(pbObject); err != nil {
+		err = fmt.Errorf("fromProtoObject: %v", err)
+		return
+	}
+
+	return
+}
+
+func (b *grpcBucket) ListObjects(
+	ctx context.Context,
+	req *ListObjectsRequest) (listing *Listing, err error) {
+	// The gRPC API we build against doesn't know about glob matching or
+	// soft-deleted objects, so fall back to JSON for those listings.
+	if req.MatchGlob != "" || req.SoftDeleted {
+		listing, err = b.json.ListObjects(ctx, req)
+		return
+	}
+
+	res, err := b.client.ListObjects(
+		b.outgoingContext(ctx),
+		&storagepb.ListObjectsRequest{
+			Parent:                   grpcBucketPath(b.name),
+			PageSize:                 int32(req.MaxResults),
+			PageToken:                req.ContinuationToken,
+			Delimiter:                req.Delimiter,
+			Prefix:                   req.Prefix,
+			IncludeTrailingDelimiter: req.IncludeTrailingDelimiter,
+			Versions:                 req.Versions,
+			LexicographicStart:       req.StartOffset,
+			LexicographicEnd:         req.EndOffset,
+		})
+
+	if err != nil {
+		err = fromGRPCError(err)
+		return
+	}
+
+	listing = &Listing{
+		CollapsedRuns:     res.Prefixes,
+		ContinuationToken: res.NextPageToken,
+	}
+
+	for _, pbObject := range res.Objects {
+		var o *Object
+		if o, err = fromProtoObject(pbObject); err != nil {
+			err = fmt.Errorf("fromProtoObject(%q): %v", pbObject.Name, err)
+			return
+		}
+
+		listing.Objects = append(listing.Objects, o)
+	}
+
+	return
+}
+
+func (b *grpcBucket) UpdateObject(
+	ctx context.Context,
+	req *UpdateObjectRequest) (o *Object, err error) {
+	o, err = b.json.UpdateObject(ctx, req)
+	return
+}
+
+func (b *grpcBucket) DeleteObject(
+	ctx context.Context,
+	req *DeleteObjectRequest) (err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	_, err = b.client.DeleteObject(
+		b.outgoingContext(ctx),
+		&storagepb.DeleteObjectRequest{
+			Bucket:                grpcBucketPath(b.name),
+			Object:                req.Name,
+			Generation:            req.Generation,
+			IfGenerationMatch:     req.GenerationPrecondition,
+			IfMetagenerationMatch: req.MetaGenerationPrecondition,
+		})
+
+	err = fromGRPCError(err)
+
+	// Special case: we want deletes to be idempotent.
+	if _, ok := err.(*NotFoundError); ok {
+		err = nil
+	}
+
+	return
+}
+
+// The gRPC API we build against can't restore objects, so use JSON.
+func (b *grpcBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	o, err = b.json.RestoreObject(ctx, req)
+	return
+}
+
+// Batching exists to save HTTP round trips, so batches are sent via the JSON
+// API.
+func (b *grpcBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	results, err = b.json.Batch(ctx, req)
+	return
+}
+
+func (b *grpcBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	rules, err = b.json.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *grpcBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	rule, err = b.json.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *grpcBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	err = b.json.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *grpcBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	signed, err = b.json.SignedURL(ctx, req)
+	return
+}
@@ -0,0 +1,539 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/oglemock"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestGRPCBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A StorageClient that records requests and returns canned responses. Methods
+// not overridden here panic.
+type fakeStorageClient struct {
+	storagepb.StorageClient
+
+	// The metadata of the most recent call.
+	md metadata.MD
+
+	getRequest  *storagepb.GetObjectRequest
+	getResponse *storagepb.Object
+
+	readRequest   *storagepb.ReadObjectRequest
+	readResponses []*storagepb.ReadObjectResponse
+
+	writeRequests []*storagepb.WriteObjectRequest
+	writeResponse *storagepb.WriteObjectResponse
+
+	deleteRequest *storagepb.DeleteObjectRequest
+
+	rewriteRequests  []*storagepb.RewriteObjectRequest
+	rewriteResponses []*storagepb.RewriteResponse
+
+	listRequest  *storagepb.ListObjectsRequest
+	listResponse *storagepb.ListObjectsResponse
+
+	// The error to return from any call, if non-nil.
+	err error
+}
+
+func (c *fakeStorageClient) recordMetadata(ctx context.Context) {
+	c.md, _ = metadata.FromOutgoingContext(ctx)
+}
+
+func (c *fakeStorageClient) GetObject(
+	ctx context.Context,
+	in *storagepb.GetObjectRequest,
+	opts ...grpc.CallOption) (*storagepb.Object, error) {
+	c.recordMetadata(ctx)
+	c.getRequest = in
+	return c.getResponse, c.err
+}
+
+func (c *fakeStorageClient) ReadObject(
+	ctx context.Context,
+	in *storagepb.ReadObjectRequest,
+	opts ...grpc.CallOption) (storagepb.Storage_ReadObjectClient, error) {
+	c.recordMetadata(ctx)
+	c.readRequest = in
+	return &fakeReadStream{responses: c.readResponses, err: c.err}, nil
+}
+
+func (c *fakeStorageClient) WriteObject(
+	ctx context.Context,
+	opts ...grpc.CallOption) (storagepb.Storage_WriteObjectClient, error) {
+	c.recordMetadata(ctx)
+	return &fakeWriteStream{client: c}, nil
+}
+
+func (c *fakeStorageClient) DeleteObject(
+	ctx context.Context,
+	in *storagepb.DeleteObjectRequest,
+	opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	c.recordMetadata(ctx)
+	c.deleteRequest = in
+	return nil, c.err
+}
+
+func (c *fakeStorageClient) RewriteObject(
+	ctx context.Context,
+	in *storagepb.RewriteObjectRequest,
+	opts ...grpc.CallOption) (res *storagepb.RewriteResponse, err error) {
+	c.recordMetadata(ctx)
+
+	// Copy the request, since the bucket modifies it between calls.
+	c.rewriteRequests = append(
+		c.rewriteRequests,
+		proto.Clone(in).(*storagepb.RewriteObjectRequest))
+
+	if c.err != nil {
+		err = c.err
+		return
+	}
+
+	res = c.rewriteResponses[0]
+	c.rewriteResponses = c.rewriteResponses[1:]
+	return
+}
+
+func (c *fakeStorageClient) ListObjects(
+	ctx context.Context,
+	in *storagepb.ListObjectsRequest,
+	opts ...grpc.CallOption) (*storagepb.ListObjectsResponse, error) {
+	c.recordMetadata(ctx)
+	c.listRequest = in
+	return c.listResponse, c.err
+}
+
+type fakeReadStream struct {
+	grpc.ClientStream
+	responses []*storagepb.ReadObjectResponse
+	err       error
+}
+
+func (s *fakeReadStream) Recv() (res *storagepb.ReadObjectResponse, err error) {
+	if len(s.responses) == 0 {
+		err = s.err
+		if err == nil {
+			err = io.EOF
+		}
+
+		return
+	}
+
+	res = s.responses[0]
+	s.responses = s.responses[1:]
+	return
+}
+
+type fakeWriteStream struct {
+	grpc.ClientStream
+	client *fakeStorageClient
+}
+
+func (s *fakeWriteStream) Send(req *storagepb.WriteObjectRequest) (err error) {
+	// The bucket reuses its buffer, as it's entitled to.
+	s.client.writeRequests = append(
+		s.client.writeRequests,
+		proto.Clone(req).(*storagepb.WriteObjectRequest))
+
+	return
+}
+
+func (s *fakeWriteStream) CloseAndRecv() (*storagepb.WriteObjectResponse, error) {
+	return s.client.writeResponse, s.client.err
+}
+
+func readContent(content string) *storagepb.ReadObjectResponse {
+	return &storagepb.ReadObjectResponse{
+		ChecksummedData: &storagepb.ChecksummedData{
+			Content: []byte(content),
+		},
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type GRPCBucketTest struct {
+	ctx    context.Context
+	client fakeStorageClient
+	json   MockBucket
+	bucket Bucket
+}
+
+var _ SetUpInterface = &GRPCBucketTest{}
+
+func init() { RegisterTestSuite(&GRPCBucketTest{}) }
+
+func (t *GRPCBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.json = NewMockBucket(ti.MockController, "json")
+
+	ExpectCall(t.json, "Name")().WillOnce(Return("some_bucket"))
+	t.bucket = newGRPCBucket(&t.client, t.json, "some-project")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *GRPCBucketTest) StatObject() {
+	t.client.getResponse = &storagepb.Object{
+		Name:           "foo",
+		Generation:     17,
+		Metageneration: 2,
+		Size:           4,
+		Checksums: &storagepb.ObjectChecksums{
+			Crc32C:  new(uint32),
+			Md5Hash: make([]byte, md5.Size),
+		},
+	}
+
+	o, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	ExpectEq("projects/_/buckets/some_bucket", t.client.getRequest.Bucket)
+	ExpectEq("foo", t.client.getRequest.Object)
+
+	ExpectThat(
+		t.client.md.Get("x-goog-request-params"),
+		ElementsAre("bucket=projects%2F_%2Fbuckets%2Fsome_bucket"))
+	ExpectThat(
+		t.client.md.Get("x-goog-user-project"),
+		ElementsAre("some-project"))
+
+	ExpectEq("foo", o.Name)
+	ExpectEq(17, o.Generation)
+	ExpectEq(2, o.MetaGeneration)
+	ExpectEq(4, o.Size)
+	ExpectEq(1, o.ComponentCount)
+	ExpectNe(nil, o.MD5)
+}
+
+func (t *GRPCBucketTest) StatObject_NotFound() {
+	t.client.err = status.Error(codes.NotFound, "taco")
+
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&NotFoundError{}))
+	ExpectThat(err, Error(HasSubstr("taco")))
+}
+
+func (t *GRPCBucketTest) NewReader() {
+	t.client.readResponses = []*storagepb.ReadObjectResponse{
+		readContent("ta"),
+		readContent(""),
+		readContent("co"),
+	}
+
+	rc, err := t.bucket.NewReader(
+		t.ctx,
+		&ReadObjectRequest{
+			Name:       "foo",
+			Generation: 17,
+			Range:      &ByteRange{Start: 2, Limit: 6},
+		})
+
+	AssertEq(nil, err)
+	defer rc.Close()
+
+	ExpectEq("foo", t.client.readRequest.Object)
+	ExpectEq(17, t.client.readRequest.Generation)
+	ExpectEq(2, t.client.readRequest.ReadOffset)
+	ExpectEq(4, t.client.readRequest.ReadLimit)
+
+	contents, err := ioutil.ReadAll(rc)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *GRPCBucketTest) NewReader_NotFound() {
+	t.client.err = status.Error(codes.NotFound, "")
+
+	_, err := t.bucket.NewReader(t.ctx, &ReadObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&NotFoundError{}))
+}
+
+func (t *GRPCBucketTest) NewReader_RangePastEnd() {
+	t.client.err = status.Error(codes.OutOfRange, "")
+
+	rc, err := t.bucket.NewReader(
+		t.ctx,
+		&ReadObjectRequest{
+			Name:  "foo",
+			Range: &ByteRange{Start: 100, Limit: 200},
+		})
+
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(rc)
+	AssertEq(nil, err)
+	ExpectEq("", string(contents))
+}
+
+func (t *GRPCBucketTest) NewReader_ErrorMidStream() {
+	t.client.readResponses = []*storagepb.ReadObjectResponse{readContent("ta")}
+	t.client.err = status.Error(codes.Unavailable, "")
+
+	rc, err := t.bucket.NewReader(t.ctx, &ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(rc)
+	ExpectEq("ta", string(contents))
+	ExpectTrue(IsTransient(err))
+}
+
+func (t *GRPCBucketTest) NewReader_VerifyChecksums() {
+	crc := crc32.Checksum([]byte("taco"), crc32cTable) + 1
+	first := readContent("taco")
+	first.ObjectChecksums = &storagepb.ObjectChecksums{Crc32C: &crc}
+	t.client.readResponses = []*storagepb.ReadObjectResponse{first}
+
+	rc, err := t.bucket.NewReader(
+		t.ctx,
+		&ReadObjectRequest{
+			Name:            "foo",
+			VerifyChecksums: true,
+		})
+
+	AssertEq(nil, err)
+
+	_, err = ioutil.ReadAll(rc)
+	ExpectThat(err, HasSameTypeAs(&ChecksumMismatchError{}))
+}
+
+func (t *GRPCBucketTest) NewReader_Gzipped() {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte("tacoburrito"))
+	AssertEq(nil, err)
+	AssertEq(nil, zw.Close())
+	compressed := buf.String()
+
+	respond := func() {
+		first := readContent(compressed[:5])
+		first.Metadata = &storagepb.Object{ContentEncoding: "gzip"}
+		t.client.readResponses = []*storagepb.ReadObjectResponse{
+			first,
+			readContent(compressed[5:]),
+		}
+	}
+
+	read := func(req *ReadObjectRequest) (contents string, encoding string) {
+		req.Name = "foo"
+		req.ContentEncodingFunc = func(ce string) { encoding = ce }
+
+		rc, err := t.bucket.NewReader(t.ctx, req)
+		AssertEq(nil, err)
+		defer rc.Close()
+
+		b, err := ioutil.ReadAll(rc)
+		AssertEq(nil, err)
+		contents = string(b)
+		return
+	}
+
+	// By default, the contents are decompressed.
+	respond()
+	contents, encoding := read(&ReadObjectRequest{})
+	ExpectEq("tacoburrito", contents)
+	ExpectEq("", encoding)
+
+	// Unless they're wanted as stored.
+	respond()
+	contents, encoding = read(&ReadObjectRequest{ReadCompressed: true})
+	ExpectEq(compressed, contents)
+	ExpectEq("gzip", encoding)
+
+	// Ranges of the decompressed contents can't be read.
+	respond()
+	_, err = t.bucket.NewReader(
+		t.ctx,
+		&ReadObjectRequest{
+			Name:  "foo",
+			Range: &ByteRange{Start: 0, Limit: 4},
+		})
+
+	ExpectThat(err, Error(HasSubstr("ReadCompressed")))
+}
+
+func (t *GRPCBucketTest) CreateObject() {
+	t.client.writeResponse = &storagepb.WriteObjectResponse{
+		WriteStatus: &storagepb.WriteObjectResponse_Resource{
+			Resource: &storagepb.Object{Name: "foo", Generation: 17},
+		},
+	}
+
+	// Contents that span several messages.
+	contents := strings.Repeat("a", grpcWriteChunkSize) + "taco"
+	crc := uint32(17)
+	var progress []int64
+	precond := int64(0)
+
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:                   "foo",
+			ContentType:            "text/plain",
+			Metadata:               map[string]string{"a": "b"},
+			Contents:               strings.NewReader(contents),
+			CRC32C:                 &crc,
+			GenerationPrecondition: &precond,
+			ProgressFunc: func(n int64) {
+				progress = append(progress, n)
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq(17, o.Generation)
+	ExpectThat(progress, ElementsAre(grpcWriteChunkSize, len(contents)))
+
+	reqs := t.client.writeRequests
+	AssertEq(2, len(reqs))
+
+	// First message
+	spec := reqs[0].GetWriteObjectSpec()
+	AssertNe(nil, spec)
+	ExpectEq("projects/_/buckets/some_bucket", spec.Resource.Bucket)
+	ExpectEq("foo", spec.Resource.Name)
+	ExpectEq("text/plain", spec.Resource.ContentType)
+	ExpectEq("b", spec.Resource.Metadata["a"])
+	ExpectEq(0, *spec.IfGenerationMatch)
+
+	ExpectEq(0, reqs[0].WriteOffset)
+	ExpectEq(grpcWriteChunkSize, len(reqs[0].GetChecksummedData().Content))
+	ExpectFalse(reqs[0].FinishWrite)
+
+	// Second message
+	ExpectEq(nil, reqs[1].GetWriteObjectSpec())
+	ExpectEq(grpcWriteChunkSize, reqs[1].WriteOffset)
+	ExpectEq("taco", string(reqs[1].GetChecksummedData().Content))
+	ExpectTrue(reqs[1].FinishWrite)
+	ExpectEq(17, reqs[1].ObjectChecksums.GetCrc32C())
+}
+
+func (t *GRPCBucketTest) CreateObject_PreconditionFailed() {
+	t.client.err = status.Error(codes.FailedPrecondition, "")
+
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader(""),
+		})
+
+	ExpectThat(err, HasSameTypeAs(&PreconditionError{}))
+}
+
+func (t *GRPCBucketTest) DeleteObject_NotFound() {
+	t.client.err = status.Error(codes.NotFound, "")
+
+	err := t.bucket.DeleteObject(
+		t.ctx,
+		&DeleteObjectRequest{Name: "foo", Generation: 17})
+
+	ExpectEq(nil, err)
+	ExpectEq("foo", t.client.deleteRequest.Object)
+	ExpectEq(17, t.client.deleteRequest.Generation)
+}
+
+func (t *GRPCBucketTest) ListObjects() {
+	t.client.listResponse = &storagepb.ListObjectsResponse{
+		Objects:       []*storagepb.Object{{Name: "a/b"}},
+		Prefixes:      []string{"a/c/"},
+		NextPageToken: "token",
+	}
+
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&ListObjectsRequest{
+			Prefix:     "a/",
+			Delimiter:  "/",
+			MaxResults: 10,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("projects/_/buckets/some_bucket", t.client.listRequest.Parent)
+	ExpectEq("a/", t.client.listRequest.Prefix)
+	ExpectEq("/", t.client.listRequest.Delimiter)
+	ExpectEq(10, t.client.listRequest.PageSize)
+
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("a/b", listing.Objects[0].Name)
+	ExpectThat(listing.CollapsedRuns, ElementsAre("a/c/"))
+	ExpectEq("token", listing.ContinuationToken)
+}
+
+func (t *GRPCBucketTest) CopyObject_MultipleCalls() {
+	t.client.rewriteResponses = []*storagepb.RewriteResponse{
+		{RewriteToken: "token"},
+		{Done: true, Resource: &storagepb.Object{Name: "bar"}},
+	}
+
+	o, err := t.bucket.CopyObject(
+		t.ctx,
+		&CopyObjectRequest{
+			SrcName: "foo",
+			DstName: "bar",
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("bar", o.Name)
+
+	reqs := t.client.rewriteRequests
+	AssertEq(2, len(reqs))
+	ExpectEq("projects/_/buckets/some_bucket", reqs[0].SourceBucket)
+	ExpectEq("foo", reqs[0].SourceObject)
+	ExpectEq("projects/_/buckets/some_bucket", reqs[0].DestinationBucket)
+	ExpectEq("bar", reqs[0].DestinationName)
+	ExpectEq("", reqs[0].RewriteToken)
+	ExpectEq("token", reqs[1].RewriteToken)
+}
+
+func (t *GRPCBucketTest) UpdateObjectUsesJSONAPI() {
+	expected := errors.New("taco")
+	ExpectCall(t.json, "UpdateObject")(Any(), Any()).
+		WillOnce(Return(nil, expected))
+
+	_, err := t.bucket.UpdateObject(t.ctx, &UpdateObjectRequest{Name: "foo"})
+	ExpectEq(expected, err)
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// The default address of the Cloud Storage gRPC API.
+const defaultGRPCEndpoint = "storage.googleapis.com:443"
+
+// Per-RPC credentials that attach access tokens from an oauth2 token source,
+// in the same way that oauth2.Transport does for HTTP requests.
+type tokenSourceCredentials struct {
+	source oauth2.TokenSource
+
+	// If non-nil, used to send the trace context of each call.
+	propagator propagation.TextMapPropagator
+}
+
+func (c *tokenSourceCredentials) GetRequestMetadata(
+	ctx context.Context,
+	uri ...string) (md map[string]string, err error) {
+	t, err := c.source.Token()
+	if err != nil {
+		err = fmt.Errorf("Token: %v", err)
+		return
+	}
+
+	md = map[string]string{
+		"authorization": t.Type() + " " + t.AccessToken,
+	}
+
+	if id := RequestIDFromContext(ctx); id != "" {
+		md[requestIDMetadataKey] = id
+	}
+
+	if err = addAuditMetadata(ctx, md); err != nil {
+		return
+	}
+
+	if c.propagator != nil {
+		c.propagator.Inject(ctx, propagation.MapCarrier(md))
+	}
+
+	return
+}
+
+func (c *tokenSourceCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// Create a client for the Cloud Storage gRPC API at the given endpoint.
+func newGRPCClient(
+	endpoint string,
+	tokenSource oauth2.TokenSource,
+	userAgent string,
+	propagator propagation.TextMapPropagator) (client storagepb.StorageClient, err error) {
+	if endpoint == "" {
+		endpoint = defaultGRPCEndpoint
+	}
+
+	cc, err := grpc.NewClient(
+		endpoint,
+		grpc.WithTransportCredentials(credentials.NewTLS(nil)),
+		grpc.WithPerRPCCredentials(&tokenSourceCredentials{
+			source:     tokenSource,
+			propagator: propagator,
+		}),
+		grpc.WithUserAgent(userAgent))
+
+	if err != nil {
+		err = fmt.Errorf("grpc.NewClient: %v", err)
+		return
+	}
+
+	client = storagepb.NewStorageClient(cc)
+	return
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"crypto/md5"
+	"fmt"
+	"time"
+
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Buckets are named by resource paths in the gRPC API.
+func grpcBucketPath(name string) string {
+	return "projects/_/buckets/" + name
+}
+
+func fromProtoTime(ts *timestamppb.Timestamp) (t time.Time) {
+	if ts != nil {
+		t = ts.AsTime()
+	}
+
+	return
+}
+
+func fromProtoObject(in *storagepb.Object) (out *Object, err error) {
+	// Convert the easy fields.
+	out = &Object{
+		Name:                    in.Name,
+		ContentType:             in.ContentType,
+		ContentLanguage:         in.ContentLanguage,
+		CacheControl:            in.CacheControl,
+		Owner:                   in.GetOwner().GetEntity(),
+		Size:                    uint64(in.Size),
+		ContentEncoding:         in.ContentEncoding,
+		Metadata:                in.Metadata,
+		Generation:              in.Generation,
+		MetaGeneration:          in.Metageneration,
+		StorageClass:            in.StorageClass,
+		Created:                 fromProtoTime(in.CreateTime),
+		Deleted:                 fromProtoTime(in.DeleteTime),
+		Updated:                 fromProtoTime(in.UpdateTime),
+		TemporaryHold:           in.TemporaryHold,
+		EventBasedHold:          in.GetEventBasedHold(),
+		RetentionExpirationTime: fromProtoTime(in.RetentionExpireTime),
+		CustomTime:              fromProtoTime(in.CustomTime),
+		KMSKeyName:              in.KmsKey,
+		ComponentCount:          int64(in.ComponentCount),
+		Etag:                    in.Etag,
+	}
+
+	// As with the JSON API, synthesize a component count for objects that
+	// don't have one. See the notes on the ComponentCount field.
+	if out.ComponentCount == 0 {
+		out.ComponentCount = 1
+	}
+
+	// Checksums
+	if in.Checksums != nil {
+		out.CRC32C = in.Checksums.GetCrc32C()
+
+		if md5Hash := in.Checksums.Md5Hash; len(md5Hash) != 0 {
+			if len(md5Hash) != md5.Size {
+				err = fmt.Errorf("Wrong length for MD5 hash: %d", len(md5Hash))
+				return
+			}
+
+			out.MD5 = new([md5.Size]byte)
+			copy(out.MD5[:], md5Hash)
+		}
+	}
+
+	return
+}
+
+// Translate an error returned by the gRPC API into one of the typed errors
+// returned by the JSON API for the same condition, where there is one.
+func fromGRPCError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return &NotFoundError{Err: err}
+
+	case codes.FailedPrecondition:
+		return &PreconditionError{Err: err}
+
+	case codes.PermissionDenied:
+		return &ForbiddenError{Err: err}
+
+	case codes.ResourceExhausted:
+		return &RateLimitError{Err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,209 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"golang.org/x/net/context"
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The largest amount of data that GCS accepts in a single WriteObject message.
+// Cf. https://cloud.google.com/storage/docs/reference/rpc/google.storage.v2
+const grpcWriteChunkSize = 2 << 20
+
+// Unlike the JSON API implementation, this sends the contents in a single
+// stream rather than a resumable upload, so a failure part way through means
+// starting over.
+func (b *grpcBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	// Compress the contents if requested.
+	req, release, err := compressCreateObjectRequestPooled(ctx, req, b.bufferPool)
+	if err != nil {
+		return
+	}
+
+	defer release()
+
+	spec := &storagepb.WriteObjectSpec{
+		Resource: &storagepb.Object{
+			Bucket:          grpcBucketPath(b.name),
+			Name:            req.Name,
+			ContentType:     req.ContentType,
+			ContentLanguage: req.ContentLanguage,
+			ContentEncoding: req.ContentEncoding,
+			CacheControl:    req.CacheControl,
+			Metadata:        req.Metadata,
+			TemporaryHold:   req.TemporaryHold,
+			KmsKey:          req.KMSKeyName,
+			StorageClass:    req.StorageClass,
+		},
+		PredefinedAcl:         req.PredefinedACL,
+		IfGenerationMatch:     req.GenerationPrecondition,
+		IfMetagenerationMatch: req.MetaGenerationPrecondition,
+	}
+
+	if req.EventBasedHold {
+		spec.Resource.EventBasedHold = &req.EventBasedHold
+	}
+
+	if !req.CustomTime.IsZero() {
+		spec.Resource.CustomTime = timestamppb.New(req.CustomTime)
+	}
+
+	// GCS checks any checksums supplied by the user once it has everything.
+	var checksums *storagepb.ObjectChecksums
+	if req.CRC32C != nil || req.MD5 != nil {
+		checksums = &storagepb.ObjectChecksums{
+			Crc32C: req.CRC32C,
+		}
+
+		if req.MD5 != nil {
+			checksums.Md5Hash = req.MD5[:]
+		}
+	}
+
+	// If we've been asked to verify checksums, compute them as the contents
+	// stream past.
+	contents := req.Contents
+	var crc32cHash hash.Hash32
+	var md5Hash hash.Hash
+
+	if req.VerifyChecksums {
+		crc32cHash = crc32.New(crc32cTable)
+		md5Hash = md5.New()
+		contents = io.TeeReader(contents, io.MultiWriter(crc32cHash, md5Hash))
+	}
+
+	// Abort the stream if we bail out part way through.
+	ctx, cancel := context.WithCancel(b.outgoingContext(ctx))
+	defer cancel()
+
+	stream, err := b.client.WriteObject(ctx)
+	if err != nil {
+		err = fromGRPCError(err)
+		return
+	}
+
+	// Send the contents, with the spec in the first message and the checksums
+	// in the last.
+	buf, err := b.bufferPool.Get(ctx, grpcWriteChunkSize)
+	if err != nil {
+		err = fmt.Errorf("BufferPool.Get: %v", err)
+		return
+	}
+
+	defer b.bufferPool.Put(buf)
+
+	var offset int64
+
+	for done := false; !done; {
+		var n int
+		n, err = io.ReadFull(contents, buf)
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			err = nil
+			done = true
+
+		case err != nil:
+			err = fmt.Errorf("Reading contents: %v", err)
+			return
+		}
+
+		msg := &storagepb.WriteObjectRequest{
+			WriteOffset: offset,
+			Data: &storagepb.WriteObjectRequest_ChecksummedData{
+				ChecksummedData: &storagepb.ChecksummedData{
+					Content: buf[:n],
+				},
+			},
+		}
+
+		if offset == 0 {
+			msg.FirstMessage = &storagepb.WriteObjectRequest_WriteObjectSpec{
+				WriteObjectSpec: spec,
+			}
+		}
+
+		if done {
+			msg.FinishWrite = true
+			msg.ObjectChecksums = checksums
+		}
+
+		// An io.EOF error means that the server has ended the stream, in which
+		// case CloseAndRecv below tells us why.
+		if err = stream.Send(msg); err == io.EOF {
+			err = nil
+			break
+		}
+
+		if err != nil {
+			err = fromGRPCError(err)
+			return
+		}
+
+		offset += int64(n)
+		if req.ProgressFunc != nil && n > 0 {
+			req.ProgressFunc(offset)
+		}
+	}
+
+	// Find out how it went.
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		err = fromGRPCError(err)
+		return
+	}
+
+	if res.GetResource() == nil {
+		err = errors.New("No resource in WriteObjectResponse")
+		return
+	}
+
+	if o, err = fromProtoObject(res.GetResource()); err != nil {
+		err = fmt.Errorf("fromProtoObject: %v", err)
+		return
+	}
+
+	// Check the checksums if requested.
+	if req.VerifyChecksums {
+		var md5Sum [md5.Size]byte
+		copy(md5Sum[:], md5Hash.Sum(nil))
+
+		err = verifyCreatedObject(ctx, b, o, crc32cHash.Sum32(), md5Sum)
+		if err != nil {
+			o = nil
+			return
+		}
+	}
+
+	if req.DiscardResponse {
+		o = nil
+	}
+
+	return
+}
@@ -0,0 +1,234 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"golang.org/x/net/context"
+	storagepb "google.golang.org/genproto/googleapis/storage/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (b *grpcBucket) NewReader(
+	ctx context.Context,
+	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	// We can only verify checksums for the full contents.
+	if req.VerifyChecksums && req.Range != nil {
+		err = errors.New("VerifyChecksums may not be combined with Range")
+		return
+	}
+
+	pbReq := &storagepb.ReadObjectRequest{
+		Bucket:     grpcBucketPath(b.name),
+		Object:     req.Name,
+		Generation: req.Generation,
+
+		IfGenerationMatch:     req.GenerationPrecondition,
+		IfMetagenerationMatch: req.MetaGenerationPrecondition,
+	}
+
+	// Set up the range, handling empty ranges ourselves. As with the JSON API,
+	// we clip to sizes that fit in an int64 (see makeRangeHeaderValue).
+	if req.Range != nil {
+		br := *req.Range
+		if br.Start > math.MaxInt64 {
+			br.Start = math.MaxInt64
+		}
+
+		if br.Limit <= br.Start {
+			rc = readSeekCloser{ioutil.NopCloser(strings.NewReader("")), nil}
+			if req.ContentEncodingFunc != nil {
+				req.ContentEncodingFunc("")
+			}
+
+			return
+		}
+
+		pbReq.ReadOffset = int64(br.Start)
+		if br.Limit-br.Start <= math.MaxInt64 {
+			pbReq.ReadLimit = int64(br.Limit - br.Start)
+		}
+	}
+
+	// The stream lives as long as the reader, so it needs its own context that
+	// we can cancel when the reader is closed.
+	ctx, cancel := context.WithCancel(b.outgoingContext(ctx))
+	defer func() {
+		if err != nil {
+			cancel()
+		}
+	}()
+
+	stream, err := b.client.ReadObject(ctx, pbReq)
+	if err != nil {
+		err = fromGRPCError(err)
+		return
+	}
+
+	// Wait for the first response, so that errors like the object not existing
+	// are returned here rather than from Read.
+	first, err := stream.Recv()
+	switch {
+	case err == io.EOF:
+		err = nil
+
+	// Like the JSON API, treat ranges starting past the end of the object as
+	// empty.
+	case status.Code(err) == codes.OutOfRange && req.Range != nil:
+		err = nil
+		cancel()
+		rc = readSeekCloser{ioutil.NopCloser(strings.NewReader("")), nil}
+		if req.ContentEncodingFunc != nil {
+			req.ContentEncodingFunc("")
+		}
+
+		return
+
+	case err != nil:
+		err = fromGRPCError(err)
+		return
+	}
+
+	rc = &grpcObjectReader{
+		stream: stream,
+		cancel: cancel,
+		buf:    first.GetChecksummedData().GetContent(),
+	}
+
+	// Verify checksums if requested. The first response carries those of the
+	// full object.
+	if req.VerifyChecksums {
+		checksums := first.GetObjectChecksums()
+
+		var md5Sum *[md5.Size]byte
+		if len(checksums.GetMd5Hash()) == md5.Size {
+			md5Sum = new([md5.Size]byte)
+			copy(md5Sum[:], checksums.GetMd5Hash())
+		}
+
+		rc = newVerifyingReader(rc, checksums.Crc32C, md5Sum)
+	}
+
+	// The gRPC API doesn't transcode, so decompress gzipped contents ourselves
+	// unless they're wanted as stored, as GCS would for the JSON API.
+	contentEncoding := first.GetMetadata().GetContentEncoding()
+	if contentEncoding == "gzip" && !req.ReadCompressed {
+		if req.Range != nil {
+			err = errors.New(
+				"Range may not be combined with decompressing gzipped contents; " +
+					"set ReadCompressed")
+			return
+		}
+
+		rc = &gunzippingReader{wrapped: rc}
+		contentEncoding = ""
+	}
+
+	// Report progress and watch for stalls if requested.
+	if req.ProgressFunc != nil || req.IdleTimeout > 0 {
+		rc = newWatchingReader(rc, req.ProgressFunc, req.IdleTimeout, cancel)
+	}
+
+	if req.ContentEncodingFunc != nil {
+		req.ContentEncodingFunc(contentEncoding)
+	}
+
+	return
+}
+
+// A reader that decompresses the gzipped contents of the wrapped reader.
+type gunzippingReader struct {
+	wrapped ReadSeekCloser
+
+	// Created by the first call to Read, since doing so reads the gzip header.
+	zr *gzip.Reader
+}
+
+func (r *gunzippingReader) Read(p []byte) (n int, err error) {
+	if r.zr == nil {
+		if r.zr, err = gzip.NewReader(r.wrapped); err != nil {
+			return
+		}
+	}
+
+	n, err = r.zr.Read(p)
+	return
+}
+
+func (r *gunzippingReader) Seek(offset int64, whence int) (n int64, err error) {
+	err = errors.New("Seeking is not supported when reading via gRPC")
+	return
+}
+
+func (r *gunzippingReader) Close() (err error) {
+	err = r.wrapped.Close()
+	return
+}
+
+// A reader for the contents streamed in response to a ReadObject call.
+type grpcObjectReader struct {
+	stream storagepb.Storage_ReadObjectClient
+	cancel func()
+
+	// Contents received but not yet returned.
+	buf []byte
+
+	// The error with which the stream ended, if it has.
+	err error
+}
+
+func (r *grpcObjectReader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			err = r.err
+			return
+		}
+
+		var res *storagepb.ReadObjectResponse
+		res, r.err = r.stream.Recv()
+		if r.err != nil && r.err != io.EOF {
+			r.err = fromGRPCError(r.err)
+		}
+
+		r.buf = res.GetChecksummedData().GetContent()
+	}
+
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return
+}
+
+func (r *grpcObjectReader) Seek(offset int64, whence int) (n int64, err error) {
+	err = errors.New("Seeking is not supported when reading via gRPC")
+	return
+}
+
+func (r *grpcObjectReader) Close() (err error) {
+	r.cancel()
+	return
+}
@@ -0,0 +1,86 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jacobsa/gcloud/httputil"
+)
+
+// Wrap the supplied round tripper in a layer that adds the supplied headers to
+// every request, except where the request already has a header with the same
+// name.
+func newHeaderRoundTripper(
+	wrapped httputil.CancellableRoundTripper,
+	headers http.Header) (rt httputil.CancellableRoundTripper) {
+	rt = &headerRoundTripper{
+		wrapped: wrapped,
+		headers: headers,
+	}
+
+	return
+}
+
+type headerRoundTripper struct {
+	wrapped httputil.CancellableRoundTripper
+	headers http.Header
+}
+
+func (t *headerRoundTripper) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	// Round trippers mustn't modify the request they're given.
+	req = req.Clone(req.Context())
+	for k, vs := range t.headers {
+		if _, ok := req.Header[k]; ok {
+			continue
+		}
+
+		req.Header[k] = vs
+	}
+
+	res, err = t.wrapped.RoundTrip(req)
+	return
+}
+
+func (t *headerRoundTripper) CancelRequest(req *http.Request) {
+	t.wrapped.CancelRequest(req)
+}
+
+// Return a canonicalized copy of the supplied headers, so that later changes
+// by the caller have no effect.
+func copyHeaders(h http.Header) (c http.Header) {
+	c = make(http.Header)
+	for k, vs := range h {
+		for _, v := range vs {
+			c.Add(k, v)
+		}
+	}
+
+	return
+}
+
+// Return the supplied headers as key/value pairs for gRPC metadata, whose keys
+// must be lower case.
+func headersToMetadata(h http.Header) (kv []string) {
+	for k, vs := range h {
+		for _, v := range vs {
+			kv = append(kv, strings.ToLower(k), v)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,160 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestHeaders(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type HeadersTest struct {
+	ctx    context.Context
+	server *httptest.Server
+
+	mu      sync.Mutex
+	headers []http.Header // GUARDED_BY(mu)
+}
+
+var _ SetUpInterface = &HeadersTest{}
+var _ TearDownInterface = &HeadersTest{}
+
+func init() { RegisterTestSuite(&HeadersTest{}) }
+
+func (t *HeadersTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.server = httptest.NewServer(http.HandlerFunc(t.serveHTTP))
+}
+
+func (t *HeadersTest) TearDown() {
+	t.server.Close()
+}
+
+// Record the request's headers and serve empty listings.
+func (t *HeadersTest) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	t.headers = append(t.headers, r.Header)
+	t.mu.Unlock()
+
+	w.Write([]byte("{}"))
+}
+
+// Open a bucket with a connection using the supplied config, which is
+// directed at the test server, and return the headers of the request made to
+// open it.
+func (t *HeadersTest) openBucket(cfg *ConnConfig) (h http.Header) {
+	cfg.TokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"})
+	cfg.Endpoint = t.server.URL
+
+	conn, err := NewConn(cfg)
+	AssertEq(nil, err)
+
+	_, err = conn.OpenBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	AssertEq(1, len(t.headers))
+	h = t.headers[0]
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *HeadersTest) DefaultUserAgent() {
+	h := t.openBucket(&ConnConfig{})
+	ExpectEq("github.com-jacobsa-gloud-gcs", h.Get("User-Agent"))
+}
+
+func (t *HeadersTest) UserAgentSuffix() {
+	h := t.openBucket(&ConnConfig{
+		UserAgentSuffix: "taco-service/1.0",
+	})
+
+	ExpectEq("github.com-jacobsa-gloud-gcs taco-service/1.0", h.Get("User-Agent"))
+}
+
+func (t *HeadersTest) CustomUserAgentAndSuffix() {
+	h := t.openBucket(&ConnConfig{
+		UserAgent:       "burrito",
+		UserAgentSuffix: "taco-service/1.0",
+	})
+
+	ExpectEq("burrito taco-service/1.0", h.Get("User-Agent"))
+}
+
+func (t *HeadersTest) ExtraHeaders() {
+	headers := http.Header{
+		"x-goog-custom-audit-job": []string{"nightly-backup"},
+	}
+
+	conn, err := NewConn(&ConnConfig{
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"}),
+		Endpoint:    t.server.URL,
+		Headers:     headers,
+	})
+
+	AssertEq(nil, err)
+
+	// Changes made after the connection is created have no effect.
+	headers["x-goog-custom-audit-job"][0] = "changed"
+	headers.Set("Authorization", "Bearer evil")
+
+	_, err = conn.OpenBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	AssertEq(1, len(t.headers))
+	ExpectEq("nightly-backup", t.headers[0].Get("X-Goog-Custom-Audit-Job"))
+	ExpectEq("Bearer tok", t.headers[0].Get("Authorization"))
+}
+
+func (t *HeadersTest) PackageHeadersTakePrecedence() {
+	h := t.openBucket(&ConnConfig{
+		Headers: http.Header{
+			"Authorization": []string{"Bearer evil"},
+			"User-Agent":    []string{"evil"},
+		},
+	})
+
+	ExpectEq("Bearer tok", h.Get("Authorization"))
+	ExpectEq("github.com-jacobsa-gloud-gcs", h.Get("User-Agent"))
+}
+
+func (t *HeadersTest) HeadersToMetadata() {
+	kv := headersToMetadata(http.Header{
+		"X-Goog-Custom-Audit-Job": []string{"nightly-backup"},
+	})
+
+	ExpectThat(kv, ElementsAre("x-goog-custom-audit-job", "nightly-backup"))
+}
@@ -17,7 +17,8 @@
 //
 //     go test -v -tags integration . -bucket <bucket name>
 //
-// The bucket's contents are not preserved.
+// The bucket's contents are not preserved. Pass "-parallelism N" to run the
+// tests within each suite N at a time, which is much faster.
 //
 // The first time you run the test, it may die with a URL to visit to obtain an
 // authorization code after authorizing the test to access your bucket. Run it
@@ -33,6 +34,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -62,11 +64,20 @@ var fDebugHTTP = flag.Bool(
 	"debug_http", false,
 	"Enable HTTP request/response debugging.")
 
+var fParallelism = flag.Int(
+	"parallelism", 1,
+	"The number of tests within a suite to run concurrently, each against "+
+		"its own prefix of the bucket.")
+
 ////////////////////////////////////////////////////////////////////////
 // Registration
 ////////////////////////////////////////////////////////////////////////
 
-func TestOgletest(t *testing.T) { RunTests(t) }
+func TestOgletest(t *testing.T) {
+	// Registration depends on flags, so must wait until now.
+	registerBucketTests()
+	RunTests(t)
+}
 
 func createConnForIntegrationTest(
 	ctx context.Context) (conn gcs.Conn, err error) {
@@ -100,7 +111,10 @@ func createConnForIntegrationTest(
 	return
 }
 
-func init() {
+// Used to give each concurrently running test its own prefix.
+var nextPrefix uint64
+
+func registerBucketTests() {
 	makeDeps := func(ctx context.Context) (deps gcstesting.BucketTestDeps) {
 		var err error
 
@@ -116,7 +130,18 @@ func init() {
 		deps.Bucket, err = conn.OpenBucket(ctx, *fBucket)
 		AssertEq(nil, err)
 
-		// Clear the bucket.
+		// When running tests concurrently, isolate them from each other by
+		// confining each to a prefix of its own.
+		if *fParallelism > 1 {
+			prefix := fmt.Sprintf(
+				"gcstesting.%d.%d/",
+				os.Getpid(),
+				atomic.AddUint64(&nextPrefix, 1))
+
+			deps.Bucket = gcs.NewPrefixBucket(deps.Bucket, prefix)
+		}
+
+		// Clear the bucket, or just the test's prefix.
 		err = gcsutil.DeleteAllObjects(ctx, deps.Bucket)
 		if err != nil {
 			panic("DeleteAllObjects: " + err.Error())
@@ -125,7 +150,18 @@ func init() {
 		// Set up other information.
 		deps.Clock = timeutil.RealClock()
 		deps.SupportsCancellation = true
+		deps.SupportsVersions = true
+		deps.SupportsPreconditions = true
+		deps.SupportsCompose = true
+		deps.SupportsRewrite = true
+		deps.SupportsHolds = true
+		deps.SupportsACLs = true
+
+		return
+	}
 
+	if *fParallelism > 1 {
+		gcstesting.RegisterParallelBucketTests(makeDeps, *fParallelism)
 		return
 	}
 
@@ -0,0 +1,40 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package customtime holds the rules for changing an object's custom time,
+// shared by the buckets under gcs that emulate GCS.
+package customtime
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Return an error if an object's custom time may not be changed from old to
+// new, as with gcs.UpdateObjectRequest.CustomTime. Like GCS, refuse to remove
+// it or to move it earlier.
+func Check(old time.Time, new time.Time) (err error) {
+	switch {
+	case new.IsZero():
+		err = errors.New("Custom time may not be removed")
+
+	case new.Before(old):
+		err = fmt.Errorf(
+			"Custom time may not be moved earlier than %v",
+			old.Format(time.RFC3339Nano))
+	}
+
+	return
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package customtime_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacobsa/gcloud/gcs/internal/customtime"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestCustomTime(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type CustomTimeTest struct {
+}
+
+func init() { RegisterTestSuite(&CustomTimeTest{}) }
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *CustomTimeTest) Set() {
+	now := time.Date(2015, 4, 5, 2, 15, 0, 0, time.UTC)
+	ExpectEq(nil, customtime.Check(time.Time{}, now))
+}
+
+func (t *CustomTimeTest) MovedLater() {
+	now := time.Date(2015, 4, 5, 2, 15, 0, 0, time.UTC)
+	ExpectEq(nil, customtime.Check(now, now))
+	ExpectEq(nil, customtime.Check(now, now.Add(time.Nanosecond)))
+}
+
+func (t *CustomTimeTest) MovedEarlier() {
+	now := time.Date(2015, 4, 5, 2, 15, 0, 0, time.UTC)
+	err := customtime.Check(now, now.Add(-time.Second))
+	ExpectThat(err, Error(HasSubstr("moved earlier")))
+	ExpectThat(err, Error(HasSubstr("2015-04-05T02:15:00Z")))
+}
+
+func (t *CustomTimeTest) Removed() {
+	now := time.Date(2015, 4, 5, 2, 15, 0, 0, time.UTC)
+	ExpectThat(customtime.Check(now, time.Time{}), Error(HasSubstr("removed")))
+}
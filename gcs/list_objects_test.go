@@ -0,0 +1,174 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestListObjects(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ListObjectsTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &ListObjectsTest{}
+
+func init() { RegisterTestSuite(&ListObjectsTest{}) }
+
+func (t *ListObjectsTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.bucket = newBucket(
+		&http.Client{Transport: &t.transport},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ListObjectsTest) LiveGenerationsOnly() {
+	t.transport.response = `{}`
+
+	_, err := t.bucket.ListObjects(t.ctx, &ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	_, ok := t.transport.requests[0].URL.Query()["versions"]
+	ExpectFalse(ok)
+}
+
+func (t *ListObjectsTest) IncludeTrailingDelimiter() {
+	t.transport.response = `{}`
+
+	_, err := t.bucket.ListObjects(
+		t.ctx,
+		&ListObjectsRequest{
+			Delimiter:                "/",
+			IncludeTrailingDelimiter: true,
+		})
+
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("/", query.Get("delimiter"))
+	ExpectEq("true", query.Get("includeTrailingDelimiter"))
+}
+
+func (t *ListObjectsTest) OffsetsAndGlob() {
+	t.transport.response = `{}`
+
+	_, err := t.bucket.ListObjects(
+		t.ctx,
+		&ListObjectsRequest{
+			StartOffset: "2015-06-01",
+			EndOffset:   "2015-07-01",
+			MatchGlob:   "**.json",
+		})
+
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("2015-06-01", query.Get("startOffset"))
+	ExpectEq("2015-07-01", query.Get("endOffset"))
+	ExpectEq("**.json", query.Get("matchGlob"))
+}
+
+func (t *ListObjectsTest) Versions() {
+	t.transport.response = `{
+		"items": [
+			{
+				"name": "foo",
+				"generation": "1",
+				"crc32c": "AAAAAA==",
+				"timeDeleted": "2015-06-03T01:02:03.004Z"
+			},
+			{
+				"name": "foo",
+				"generation": "2",
+				"crc32c": "AAAAAA=="
+			}
+		]
+	}`
+
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&ListObjectsRequest{Versions: true})
+
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	ExpectEq("true", t.transport.requests[0].URL.Query().Get("versions"))
+
+	// Response
+	AssertEq(2, len(listing.Objects))
+
+	ExpectEq(1, listing.Objects[0].Generation)
+	ExpectTrue(
+		listing.Objects[0].Deleted.Equal(
+			time.Date(2015, 6, 3, 1, 2, 3, 4e6, time.UTC)),
+		"Deleted: %v",
+		listing.Objects[0].Deleted)
+
+	ExpectEq(2, listing.Objects[1].Generation)
+	ExpectTrue(listing.Objects[1].Deleted.IsZero())
+}
+
+func (t *ListObjectsTest) MaxResultsAndFields() {
+	t.transport.response = `{
+		"items": [{"name": "foo", "size": "17"}],
+		"nextPageToken": "bar"
+	}`
+
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&ListObjectsRequest{
+			MaxResults: 1,
+			Fields:     []string{"size"},
+		})
+
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("1", query.Get("maxResults"))
+	ExpectEq("items(name,size),prefixes,nextPageToken", query.Get("fields"))
+
+	// Response
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("foo", listing.Objects[0].Name)
+	ExpectEq(17, listing.Objects[0].Size)
+	ExpectEq("bar", listing.ContinuationToken)
+}
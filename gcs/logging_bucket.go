@@ -0,0 +1,93 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// LogVerbosity controls which calls a bucket created with NewLoggingBucket
+// logs.
+type LogVerbosity int
+
+const (
+	// Log only calls that return an error.
+	LogErrors LogVerbosity = iota
+
+	// Additionally log successful calls that modify the bucket.
+	LogMutations
+
+	// Log every call.
+	LogAll
+)
+
+// Operations that modify the bucket, as named in OperationTrace.Op.
+var mutatingOps = map[string]bool{
+	"CreateObject":    true,
+	"CopyObject":      true,
+	"MoveObject":      true,
+	"ComposeObjects":  true,
+	"UpdateObject":    true,
+	"DeleteObject":    true,
+	"UpdateObjectACL": true,
+	"DeleteObjectACL": true,
+	"RewriteObject":   true,
+	"Batch":           true,
+}
+
+// Create a bucket that calls through to the wrapped bucket, logging calls
+// that match the supplied verbosity to the supplied logger once they
+// complete. Each call is logged on a single line of key=value pairs giving the
+// bucket, operation, object name, number of bytes transferred, duration, and
+// outcome, for example:
+//
+//     bucket=foo op=CreateObject name="bar" bytes=1024 duration=35ms result=OK
+//
+// As with NewTracingBucket, calls to NewReader are logged when the reader is
+// closed.
+func NewLoggingBucket(
+	wrapped Bucket,
+	logger *log.Logger,
+	verbosity LogVerbosity) (b Bucket) {
+	bucketName := wrapped.Name()
+	report := func(t *OperationTrace) {
+		switch {
+		case t.Err != nil:
+		case verbosity >= LogAll:
+		case verbosity >= LogMutations && mutatingOps[t.Op]:
+		default:
+			return
+		}
+
+		result := "OK"
+		if t.Err != nil {
+			result = fmt.Sprintf("%q", t.Err.Error())
+		}
+
+		logger.Printf(
+			"bucket=%s op=%s name=%q bytes=%d duration=%v result=%s",
+			bucketName,
+			t.Op,
+			t.Name,
+			t.Bytes,
+			t.Latency.Round(time.Microsecond),
+			result)
+	}
+
+	b = NewTracingBucket(wrapped, report)
+	return
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestLoggingBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type LoggingBucketTest struct {
+	ctx     context.Context
+	wrapped gcs.Bucket
+	buf     bytes.Buffer
+}
+
+var _ SetUpInterface = &LoggingBucketTest{}
+
+func init() { RegisterTestSuite(&LoggingBucketTest{}) }
+
+func (t *LoggingBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.wrapped = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+}
+
+// Create a logging bucket with the given verbosity, then create, stat, and
+// delete an object, and attempt to stat a missing one. Return the lines
+// logged.
+func (t *LoggingBucketTest) exercise(verbosity gcs.LogVerbosity) []string {
+	logger := log.New(&t.buf, "", 0)
+	bucket := gcs.NewLoggingBucket(t.wrapped, logger, verbosity)
+
+	_, err := gcsutil.CreateObject(t.ctx, bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	_, err = bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	err = bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	_, err = bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	AssertNe(nil, err)
+
+	return strings.Split(strings.TrimSpace(t.buf.String()), "\n")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *LoggingBucketTest) LogErrors() {
+	lines := t.exercise(gcs.LogErrors)
+
+	AssertEq(1, len(lines))
+	ExpectThat(lines[0], HasSubstr("bucket=some_bucket op=StatObject name=\"bar\""))
+	ExpectThat(lines[0], HasSubstr("result=\"gcs.NotFoundError"))
+}
+
+func (t *LoggingBucketTest) LogMutations() {
+	lines := t.exercise(gcs.LogMutations)
+
+	AssertEq(3, len(lines))
+	ExpectThat(lines[0], HasSubstr("op=CreateObject name=\"foo\" bytes=4"))
+	ExpectThat(lines[0], HasSubstr("result=OK"))
+	ExpectThat(lines[1], HasSubstr("op=DeleteObject name=\"foo\""))
+	ExpectThat(lines[2], HasSubstr("op=StatObject name=\"bar\""))
+}
+
+func (t *LoggingBucketTest) LogAll() {
+	lines := t.exercise(gcs.LogAll)
+
+	AssertEq(4, len(lines))
+	ExpectThat(lines[0], HasSubstr("op=CreateObject"))
+	ExpectThat(lines[1], HasSubstr("op=StatObject name=\"foo\""))
+	ExpectThat(lines[1], MatchesRegexp("duration=[0-9.]+[µnm]?s "))
+	ExpectThat(lines[2], HasSubstr("op=DeleteObject"))
+	ExpectThat(lines[3], HasSubstr("op=StatObject name=\"bar\""))
+}
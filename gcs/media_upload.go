@@ -0,0 +1,123 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// Return true if everything about the object described by the supplied
+// request other than its contents can be expressed in the URL and headers of
+// a media upload.
+func mediaUploadable(req *CreateObjectRequest) bool {
+	return req.ContentLanguage == "" &&
+		req.CacheControl == "" &&
+		len(req.Metadata) == 0 &&
+		!req.TemporaryHold &&
+		!req.EventBasedHold &&
+		req.CustomTime.IsZero() &&
+		req.StorageClass == "" &&
+		req.CRC32C == nil &&
+		req.MD5 == nil
+}
+
+// Send the object described by the supplied request, whose contents are the
+// given section, in a single request carrying nothing but the contents. This
+// is the smallest possible upload, but a failure means starting over and
+// the request must satisfy mediaUploadable.
+func (b *bucket) uploadMedia(
+	ctx context.Context,
+	req *CreateObjectRequest,
+	section *io.SectionReader) (rawObject *storagev1.Object, err error) {
+	defer startSpanWithError(&ctx, &err, "Media upload")()
+
+	// Construct an appropriate URL, as in startResumableUpload.
+	path := fmt.Sprintf(
+		"/upload/storage/v1/b/%s/o",
+		httputil.EncodePathSegment(b.Name()))
+
+	query := b.createObjectQuery(req)
+	query.Set("uploadType", "media")
+	query.Set("name", req.Name)
+
+	if req.ContentEncoding != "" {
+		query.Set("contentEncoding", req.ContentEncoding)
+	}
+
+	url := makeURL(b.uploadEndpoint, path, query)
+
+	// Leave out the body for empty contents, so that net/http doesn't treat
+	// its length as unknown.
+	var body io.ReadCloser
+	if section.Size() != 0 {
+		var contents io.Reader = io.NewSectionReader(section, 0, section.Size())
+		if req.ProgressFunc != nil {
+			contents = &progressReader{
+				wrapped:  contents,
+				progress: req.ProgressFunc,
+			}
+		}
+
+		body = ioutil.NopCloser(contents)
+	}
+
+	// Create the HTTP request.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"POST",
+		url,
+		body,
+		section.Size(),
+		b.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	httpReq.Header.Set("Content-Type", contentType)
+
+	// Execute the HTTP request.
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	rawObject, _, err = parseUploadResponse(httpRes)
+	if err != nil {
+		return
+	}
+
+	if rawObject == nil {
+		err = fmt.Errorf("Unexpected HTTP status: %s", httpRes.Status)
+		return
+	}
+
+	return
+}
@@ -12,7 +12,6 @@ import (
 	context "golang.org/x/net/context"
 	runtime "runtime"
 	unsafe "unsafe"
-	"errors"
 )
 
 type MockBucket interface {
@@ -42,6 +41,35 @@ func (m *mockBucket) Oglemock_Description() string {
 	return m.description
 }
 
+func (m *mockBucket) Batch(p0 context.Context, p1 *BatchRequest) (o0 []BatchResult, o1 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"Batch",
+		file,
+		line,
+		[]interface{}{p0, p1})
+
+	if len(retVals) != 2 {
+		panic(fmt.Sprintf("mockBucket.Batch: invalid return values: %v", retVals))
+	}
+
+	// o0 []BatchResult
+	if retVals[0] != nil {
+		o0 = retVals[0].([]BatchResult)
+	}
+
+	// o1 error
+	if retVals[1] != nil {
+		o1 = retVals[1].(error)
+	}
+
+	return
+}
+
 func (m *mockBucket) ComposeObjects(p0 context.Context, p1 *ComposeObjectsRequest) (o0 *Object, o1 error) {
 	// Get a file name and line number for the caller.
 	_, file, line, _ := runtime.Caller(1)
@@ -71,12 +99,6 @@ func (m *mockBucket) ComposeObjects(p0 context.Context, p1 *ComposeObjectsReques
 	return
 }
 
-func (b *mockBucket) MoveObject(
-	ctx context.Context,
-	req *MoveObjectRequest) (o *Object, err error) {
-	return nil, errors.New("MoveObject not implemented")
-}
-
 func (m *mockBucket) CopyObject(p0 context.Context, p1 *CopyObjectRequest) (o0 *Object, o1 error) {
 	// Get a file name and line number for the caller.
 	_, file, line, _ := runtime.Caller(1)
@@ -159,6 +181,59 @@ func (m *mockBucket) DeleteObject(p0 context.Context, p1 *DeleteObjectRequest) (
 	return
 }
 
+func (m *mockBucket) DeleteObjectACL(p0 context.Context, p1 *DeleteObjectACLRequest) (o0 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"DeleteObjectACL",
+		file,
+		line,
+		[]interface{}{p0, p1})
+
+	if len(retVals) != 1 {
+		panic(fmt.Sprintf("mockBucket.DeleteObjectACL: invalid return values: %v", retVals))
+	}
+
+	// o0 error
+	if retVals[0] != nil {
+		o0 = retVals[0].(error)
+	}
+
+	return
+}
+
+func (m *mockBucket) ListObjectACLs(p0 context.Context, p1 *ListObjectACLsRequest) (o0 []*ACLRule, o1 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"ListObjectACLs",
+		file,
+		line,
+		[]interface{}{p0, p1})
+
+	if len(retVals) != 2 {
+		panic(fmt.Sprintf("mockBucket.ListObjectACLs: invalid return values: %v", retVals))
+	}
+
+	// o0 []*ACLRule
+	if retVals[0] != nil {
+		o0 = retVals[0].([]*ACLRule)
+	}
+
+	// o1 error
+	if retVals[1] != nil {
+		o1 = retVals[1].(error)
+	}
+
+	return
+}
+
 func (m *mockBucket) ListObjects(p0 context.Context, p1 *ListObjectsRequest) (o0 *Listing, o1 error) {
 	// Get a file name and line number for the caller.
 	_, file, line, _ := runtime.Caller(1)
@@ -188,6 +263,35 @@ func (m *mockBucket) ListObjects(p0 context.Context, p1 *ListObjectsRequest) (o0
 	return
 }
 
+func (m *mockBucket) MoveObject(p0 context.Context, p1 *MoveObjectRequest) (o0 *Object, o1 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"MoveObject",
+		file,
+		line,
+		[]interface{}{p0, p1})
+
+	if len(retVals) != 2 {
+		panic(fmt.Sprintf("mockBucket.MoveObject: invalid return values: %v", retVals))
+	}
+
+	// o0 *Object
+	if retVals[0] != nil {
+		o0 = retVals[0].(*Object)
+	}
+
+	// o1 error
+	if retVals[1] != nil {
+		o1 = retVals[1].(error)
+	}
+
+	return
+}
+
 func (m *mockBucket) Name() (o0 string) {
 	// Get a file name and line number for the caller.
 	_, file, line, _ := runtime.Caller(1)
@@ -228,7 +332,7 @@ func (m *mockBucket) NewReader(p0 context.Context, p1 *ReadObjectRequest) (o0 Re
 		panic(fmt.Sprintf("mockBucket.NewReader: invalid return values: %v", retVals))
 	}
 
-	// o0 io.ReadCloser
+	// o0 ReadSeekCloser
 	if retVals[0] != nil {
 		o0 = retVals[0].(ReadSeekCloser)
 	}
@@ -241,6 +345,93 @@ func (m *mockBucket) NewReader(p0 context.Context, p1 *ReadObjectRequest) (o0 Re
 	return
 }
 
+func (m *mockBucket) RestoreObject(p0 context.Context, p1 *RestoreObjectRequest) (o0 *Object, o1 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"RestoreObject",
+		file,
+		line,
+		[]interface{}{p0, p1})
+
+	if len(retVals) != 2 {
+		panic(fmt.Sprintf("mockBucket.RestoreObject: invalid return values: %v", retVals))
+	}
+
+	// o0 *Object
+	if retVals[0] != nil {
+		o0 = retVals[0].(*Object)
+	}
+
+	// o1 error
+	if retVals[1] != nil {
+		o1 = retVals[1].(error)
+	}
+
+	return
+}
+
+func (m *mockBucket) RewriteObject(p0 context.Context, p1 *RewriteObjectRequest) (o0 *Object, o1 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"RewriteObject",
+		file,
+		line,
+		[]interface{}{p0, p1})
+
+	if len(retVals) != 2 {
+		panic(fmt.Sprintf("mockBucket.RewriteObject: invalid return values: %v", retVals))
+	}
+
+	// o0 *Object
+	if retVals[0] != nil {
+		o0 = retVals[0].(*Object)
+	}
+
+	// o1 error
+	if retVals[1] != nil {
+		o1 = retVals[1].(error)
+	}
+
+	return
+}
+
+func (m *mockBucket) SignedURL(p0 context.Context, p1 *SignedURLRequest) (o0 string, o1 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"SignedURL",
+		file,
+		line,
+		[]interface{}{p0, p1})
+
+	if len(retVals) != 2 {
+		panic(fmt.Sprintf("mockBucket.SignedURL: invalid return values: %v", retVals))
+	}
+
+	// o0 string
+	if retVals[0] != nil {
+		o0 = retVals[0].(string)
+	}
+
+	// o1 error
+	if retVals[1] != nil {
+		o1 = retVals[1].(error)
+	}
+
+	return
+}
+
 func (m *mockBucket) StatObject(p0 context.Context, p1 *StatObjectRequest) (o0 *Object, o1 error) {
 	// Get a file name and line number for the caller.
 	_, file, line, _ := runtime.Caller(1)
@@ -298,3 +489,32 @@ func (m *mockBucket) UpdateObject(p0 context.Context, p1 *UpdateObjectRequest) (
 
 	return
 }
+
+func (m *mockBucket) UpdateObjectACL(p0 context.Context, p1 *UpdateObjectACLRequest) (o0 *ACLRule, o1 error) {
+	// Get a file name and line number for the caller.
+	_, file, line, _ := runtime.Caller(1)
+
+	// Hand the call off to the controller, which does most of the work.
+	retVals := m.controller.HandleMethodCall(
+		m,
+		"UpdateObjectACL",
+		file,
+		line,
+		[]interface{}{p0, p1})
+
+	if len(retVals) != 2 {
+		panic(fmt.Sprintf("mockBucket.UpdateObjectACL: invalid return values: %v", retVals))
+	}
+
+	// o0 *ACLRule
+	if retVals[0] != nil {
+		o0 = retVals[0].(*ACLRule)
+	}
+
+	// o1 error
+	if retVals[1] != nil {
+		o1 = retVals[1].(error)
+	}
+
+	return
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// MetricsRegistry receives the measurements made by buckets created with
+// NewMonitoredBucket. Implementations typically forward them to a metrics
+// system such as Prometheus or OpenCensus, for example by incrementing a
+// counter vector labelled by op and class and observing a histogram of
+// latencies labelled by op. Methods may be called concurrently.
+type MetricsRegistry interface {
+	// Record a completed call to the Bucket method with the given name (e.g.
+	// "NewReader" or "CreateObject"), how long it took, and how it ended. The
+	// class is one of:
+	//
+	//  *  "ok"
+	//  *  "not_found", "precondition", "checksum_mismatch", "read_only",
+	//     "rate_limit", "quota", "forbidden", "stalled_read", or
+	//     "invalid_name", for the error types of the same names
+	//  *  "cancelled", for context cancellation and deadlines
+	//  *  "other"
+	//
+	RecordOp(op string, class string, latency time.Duration)
+
+	// Record bytes of object contents transferred by a call to the Bucket
+	// method with the given name. direction is "up" for bytes sent to GCS and
+	// "down" for bytes received from it. Called only when n is non-zero.
+	RecordBytes(op string, direction string, n int64)
+}
+
+// Create a bucket that calls through to the wrapped bucket, recording in the
+// supplied registry the number, latency, and outcome of calls to each method
+// along with the bytes of object contents uploaded and downloaded.
+//
+// As with NewTracingBucket, calls to NewReader are recorded when the reader is
+// closed, and last until then.
+func NewMonitoredBucket(
+	wrapped Bucket,
+	registry MetricsRegistry) (b Bucket) {
+	b = NewTracingBucket(
+		wrapped,
+		func(t *OperationTrace) { recordTrace(registry, t) })
+
+	return
+}
+
+// Record the measurements contained in the supplied trace.
+func recordTrace(registry MetricsRegistry, t *OperationTrace) {
+	registry.RecordOp(t.Op, errorClass(t.Err), t.Latency)
+
+	if t.Bytes == 0 {
+		return
+	}
+
+	direction := "down"
+	if t.Op == "CreateObject" {
+		direction = "up"
+	}
+
+	registry.RecordBytes(t.Op, direction, t.Bytes)
+}
+
+// Classify an error for the purposes of MetricsRegistry.RecordOp.
+func errorClass(err error) string {
+	switch err.(type) {
+	case nil:
+		return "ok"
+
+	case *NotFoundError:
+		return "not_found"
+
+	case *PreconditionError:
+		return "precondition"
+
+	case *ChecksumMismatchError:
+		return "checksum_mismatch"
+
+	case *ReadOnlyError:
+		return "read_only"
+
+	case *RateLimitError:
+		return "rate_limit"
+
+	case *QuotaError:
+		return "quota"
+
+	case *ForbiddenError:
+		return "forbidden"
+
+	case *StalledReadError:
+		return "stalled_read"
+
+	case *InvalidNameError:
+		return "invalid_name"
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return "cancelled"
+	}
+
+	return "other"
+}
@@ -0,0 +1,165 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/oglemock"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestMonitoredBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Fake registry
+////////////////////////////////////////////////////////////////////////
+
+// A MetricsRegistry that records what it is told as strings.
+type fakeRegistry struct {
+	mu    sync.Mutex
+	ops   []string // GUARDED_BY(mu)
+	bytes []string // GUARDED_BY(mu)
+}
+
+func (r *fakeRegistry) RecordOp(
+	op string,
+	class string,
+	latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if latency < 0 {
+		panic(fmt.Sprintf("Negative latency: %v", latency))
+	}
+
+	r.ops = append(r.ops, op+" "+class)
+}
+
+func (r *fakeRegistry) RecordBytes(op string, direction string, n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bytes = append(r.bytes, fmt.Sprintf("%s %s %d", op, direction, n))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type MonitoredBucketTest struct {
+	ctx            context.Context
+	mockController Controller
+	registry       fakeRegistry
+	bucket         gcs.Bucket
+}
+
+var _ SetUpInterface = &MonitoredBucketTest{}
+
+func init() { RegisterTestSuite(&MonitoredBucketTest{}) }
+
+func (t *MonitoredBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.mockController = ti.MockController
+	t.bucket = gcs.NewMonitoredBucket(
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+		&t.registry)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *MonitoredBucketTest) BytesUpAndDown() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+		})
+
+	AssertEq(nil, err)
+
+	rc, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	_, err = ioutil.ReadAll(rc)
+	AssertEq(nil, err)
+	AssertEq(nil, rc.Close())
+
+	ExpectThat(t.registry.ops, ElementsAre("CreateObject ok", "NewReader ok"))
+	ExpectThat(
+		t.registry.bytes,
+		ElementsAre("CreateObject up 4", "NewReader down 4"))
+}
+
+func (t *MonitoredBucketTest) ErrorClasses() {
+	var err error
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	var zero int64
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   "bar",
+			Contents:               strings.NewReader(""),
+			GenerationPrecondition: &zero,
+		})
+
+	AssertEq(nil, err)
+
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:                   "bar",
+			Contents:               strings.NewReader(""),
+			GenerationPrecondition: &zero,
+		})
+
+	AssertThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+
+	// The fake bucket doesn't observe cancellation, so use a mock.
+	wrapped := gcs.NewMockBucket(t.mockController, "wrapped")
+	bucket := gcs.NewMonitoredBucket(wrapped, &t.registry)
+
+	ExpectCall(wrapped, "ListObjects")(Any(), Any()).
+		WillOnce(Return(nil, context.Canceled))
+
+	_, err = bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(context.Canceled, err)
+
+	ExpectThat(
+		t.registry.ops,
+		ElementsAre(
+			"StatObject not_found",
+			"CreateObject ok",
+			"CreateObject precondition",
+			"ListObjects cancelled"))
+
+	ExpectThat(t.registry.bytes, ElementsAre())
+}
@@ -0,0 +1,148 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// Send the object described by the supplied request, whose contents are the
+// given section, in a single multipart/related request carrying both its
+// metadata and its contents. Unlike a resumable upload this takes one round
+// trip rather than two, but a failure means starting over. See here for more
+// information:
+//
+//     https://cloud.google.com/storage/docs/uploading-objects#uploading-an-object
+//
+func (b *bucket) uploadMultipart(
+	ctx context.Context,
+	req *CreateObjectRequest,
+	section *io.SectionReader) (rawObject *storagev1.Object, err error) {
+	defer startSpanWithError(&ctx, &err, "Multipart upload")()
+
+	// Construct an appropriate URL, as in startResumableUpload.
+	path := fmt.Sprintf(
+		"/upload/storage/v1/b/%s/o",
+		httputil.EncodePathSegment(b.Name()))
+
+	query := b.createObjectQuery(req)
+	query.Set("uploadType", "multipart")
+
+	url := makeURL(b.uploadEndpoint, path, query)
+
+	// Write everything but the contents themselves: the metadata part, and the
+	// header of the part holding the contents.
+	metadata, err := b.makeCreateObjectBody(req)
+	if err != nil {
+		err = fmt.Errorf("makeCreateObjectBody: %v", err)
+		return
+	}
+
+	var head bytes.Buffer
+	mw := multipart.NewWriter(&head)
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"application/json; charset=UTF-8"},
+	})
+
+	if err != nil {
+		err = fmt.Errorf("CreatePart: %v", err)
+		return
+	}
+
+	if _, err = pw.Write(metadata); err != nil {
+		err = fmt.Errorf("Writing metadata: %v", err)
+		return
+	}
+
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	_, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {contentType},
+	})
+
+	if err != nil {
+		err = fmt.Errorf("CreatePart: %v", err)
+		return
+	}
+
+	// The contents are sent straight from the section, followed by the closing
+	// boundary.
+	tail := "\r\n--" + mw.Boundary() + "--\r\n"
+
+	var contents io.Reader = io.NewSectionReader(section, 0, section.Size())
+	if req.ProgressFunc != nil {
+		contents = &progressReader{
+			wrapped:  contents,
+			progress: req.ProgressFunc,
+		}
+	}
+
+	body := io.MultiReader(
+		bytes.NewReader(head.Bytes()),
+		contents,
+		bytes.NewReader([]byte(tail)))
+
+	// Create the HTTP request.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"POST",
+		url,
+		ioutil.NopCloser(body),
+		int64(head.Len())+section.Size()+int64(len(tail)),
+		b.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set(
+		"Content-Type",
+		"multipart/related; boundary="+mw.Boundary())
+
+	// Execute the HTTP request.
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	rawObject, _, err = parseUploadResponse(httpRes)
+	if err != nil {
+		return
+	}
+
+	if rawObject == nil {
+		err = fmt.Errorf("Unexpected HTTP status: %s", httpRes.Status)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,490 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestMultipartUpload(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// A request received by the fake upload endpoint.
+type multipartRequest struct {
+	method      string
+	query       url.Values
+	contentType string
+
+	// For multipart requests, the parts in order.
+	partTypes []string
+	parts     []string
+}
+
+type MultipartUploadTest struct {
+	ctx    context.Context
+	server *httptest.Server
+	bucket *bucket
+
+	// The object record with which to respond to uploads.
+	response string
+
+	mu       sync.Mutex
+	requests []multipartRequest // GUARDED_BY(mu)
+}
+
+var _ SetUpInterface = &MultipartUploadTest{}
+var _ TearDownInterface = &MultipartUploadTest{}
+
+func init() { RegisterTestSuite(&MultipartUploadTest{}) }
+
+func (t *MultipartUploadTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.server = httptest.NewServer(http.HandlerFunc(t.serveHTTP))
+
+	u, err := url.Parse(t.server.URL)
+	AssertEq(nil, err)
+
+	t.bucket = newBucket(
+		http.DefaultClient,
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+
+	t.bucket.uploadEndpoint = u
+	t.bucket.multipartUploadThreshold = 8
+
+	t.response = `{"name": "foo", "size": "4", "crc32c": "AAAAAA=="}`
+}
+
+func (t *MultipartUploadTest) TearDown() {
+	t.server.Close()
+}
+
+// Record each request. Respond to multipart and media uploads with an object,
+// and to anything else with an error, so that resumable uploads fail fast.
+func (t *MultipartUploadTest) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	req := multipartRequest{
+		method:      r.Method,
+		query:       r.URL.Query(),
+		contentType: r.Header.Get("Content-Type"),
+	}
+
+	defer func() {
+		t.mu.Lock()
+		t.requests = append(t.requests, req)
+		t.mu.Unlock()
+	}()
+
+	switch req.query.Get("uploadType") {
+	case "multipart":
+	case "media":
+		contents, _ := ioutil.ReadAll(r.Body)
+		req.partTypes = []string{req.contentType}
+		req.parts = []string{string(contents)}
+		w.Write([]byte(t.response))
+		return
+
+	default:
+		http.Error(w, "not supported", http.StatusBadRequest)
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(req.contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+
+		contents, _ := ioutil.ReadAll(p)
+		req.partTypes = append(req.partTypes, p.Header.Get("Content-Type"))
+		req.parts = append(req.parts, string(contents))
+	}
+
+	w.Write([]byte(t.response))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *MultipartUploadTest) SmallObjectInOneRequest() {
+	var progress []int64
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:          "foo",
+			ContentType:   "text/plain",
+			Metadata:      map[string]string{"bar": "baz"},
+			PredefinedACL: PredefinedACLPublicRead,
+			Contents:      strings.NewReader("taco"),
+			ProgressFunc:  func(n int64) { progress = append(progress, n) },
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectEq(4, o.Size)
+	ExpectThat(progress, ElementsAre(4))
+
+	AssertEq(1, len(t.requests))
+	req := t.requests[0]
+
+	ExpectEq("POST", req.method)
+	ExpectEq("multipart", req.query.Get("uploadType"))
+	ExpectEq("full", req.query.Get("projection"))
+	ExpectEq("publicRead", req.query.Get("predefinedAcl"))
+	ExpectThat(req.contentType, HasSubstr("multipart/related"))
+
+	// Metadata, then contents.
+	AssertEq(2, len(req.parts))
+	ExpectThat(req.partTypes[0], HasSubstr("application/json"))
+	ExpectEq("text/plain", req.partTypes[1])
+	ExpectEq("taco", req.parts[1])
+
+	var metadata map[string]interface{}
+	AssertEq(nil, json.Unmarshal([]byte(req.parts[0]), &metadata))
+	ExpectEq("foo", metadata["name"])
+	ExpectEq("text/plain", metadata["contentType"])
+	ExpectThat(
+		metadata["metadata"],
+		DeepEquals(map[string]interface{}{"bar": "baz"}))
+}
+
+func (t *MultipartUploadTest) DefaultContentType() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:       "foo",
+			Contents:   strings.NewReader("taco"),
+			UploadType: UploadTypeMultipart,
+		})
+
+	AssertEq(nil, err)
+	AssertEq(1, len(t.requests))
+	AssertEq(2, len(t.requests[0].partTypes))
+	ExpectEq("application/octet-stream", t.requests[0].partTypes[1])
+}
+
+func (t *MultipartUploadTest) LargeObjectUsesResumableUpload() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("tacoburrito"),
+		})
+
+	ExpectNe(nil, err)
+	AssertEq(1, len(t.requests))
+	ExpectEq("resumable", t.requests[0].query.Get("uploadType"))
+}
+
+func (t *MultipartUploadTest) UnknownSizeUsesResumableUpload() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:     "foo",
+			Contents: ioutil.NopCloser(strings.NewReader("taco")),
+		})
+
+	ExpectNe(nil, err)
+	AssertEq(1, len(t.requests))
+	ExpectEq("resumable", t.requests[0].query.Get("uploadType"))
+}
+
+func (t *MultipartUploadTest) DisabledByDefault() {
+	t.bucket.multipartUploadThreshold = 0
+
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+		})
+
+	ExpectNe(nil, err)
+	AssertEq(1, len(t.requests))
+	ExpectEq("resumable", t.requests[0].query.Get("uploadType"))
+}
+
+func (t *MultipartUploadTest) PreconditionFailure() {
+	t.server.Config.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte("{}"))
+		})
+
+	var gen int64 = 0
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:                   "foo",
+			Contents:               strings.NewReader("taco"),
+			GenerationPrecondition: &gen,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&PreconditionError{}))
+}
+
+func (t *MultipartUploadTest) MediaUploadWithoutMetadata() {
+	var progress []int64
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:            "foo/bar",
+			ContentType:     "text/plain",
+			ContentEncoding: "identity",
+			Contents:        strings.NewReader("taco"),
+			ProgressFunc:    func(n int64) { progress = append(progress, n) },
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+	ExpectThat(progress, ElementsAre(4))
+
+	AssertEq(1, len(t.requests))
+	req := t.requests[0]
+
+	ExpectEq("POST", req.method)
+	ExpectEq("media", req.query.Get("uploadType"))
+	ExpectEq("foo/bar", req.query.Get("name"))
+	ExpectEq("identity", req.query.Get("contentEncoding"))
+	ExpectEq("text/plain", req.contentType)
+	ExpectThat(req.parts, ElementsAre("taco"))
+}
+
+func (t *MultipartUploadTest) MediaUploadOfEmptyObject() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader(""),
+		})
+
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.requests))
+	ExpectEq("media", t.requests[0].query.Get("uploadType"))
+	ExpectEq("application/octet-stream", t.requests[0].contentType)
+	ExpectThat(t.requests[0].parts, ElementsAre(""))
+}
+
+func (t *MultipartUploadTest) ExplicitMediaUpload() {
+	// The threshold doesn't apply to explicit choices.
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:       "foo",
+			Contents:   strings.NewReader("tacoburrito"),
+			UploadType: UploadTypeMedia,
+		})
+
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.requests))
+	ExpectEq("media", t.requests[0].query.Get("uploadType"))
+	ExpectThat(t.requests[0].parts, ElementsAre("tacoburrito"))
+}
+
+func (t *MultipartUploadTest) ExplicitMediaUploadWithMetadata() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:       "foo",
+			Contents:   strings.NewReader("taco"),
+			Metadata:   map[string]string{"bar": "baz"},
+			UploadType: UploadTypeMedia,
+		})
+
+	ExpectThat(err, Error(HasSubstr("metadata")))
+	ExpectEq(0, len(t.requests))
+}
+
+func (t *MultipartUploadTest) ExplicitSingleRequestUploadOfUnknownSize() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:       "foo",
+			Contents:   ioutil.NopCloser(strings.NewReader("taco")),
+			UploadType: UploadTypeMultipart,
+		})
+
+	ExpectThat(err, Error(HasSubstr("known size")))
+	ExpectEq(0, len(t.requests))
+}
+
+func (t *MultipartUploadTest) ExplicitResumableUpload() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:       "foo",
+			Contents:   strings.NewReader("taco"),
+			UploadType: UploadTypeResumable,
+		})
+
+	ExpectNe(nil, err)
+	AssertEq(1, len(t.requests))
+	ExpectEq("resumable", t.requests[0].query.Get("uploadType"))
+}
+
+func (t *MultipartUploadTest) UnknownUploadType() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:       "foo",
+			Contents:   strings.NewReader("taco"),
+			UploadType: "carrier-pigeon",
+		})
+
+	ExpectThat(err, Error(HasSubstr("carrier-pigeon")))
+	ExpectEq(0, len(t.requests))
+}
+
+func (t *MultipartUploadTest) FullProjectionForEveryUploadType() {
+	uploadTypes := []string{
+		UploadTypeResumable,
+		UploadTypeMultipart,
+		UploadTypeMedia,
+	}
+
+	// Resumable uploads fail after starting the session, which is where the
+	// query parameters go.
+	for _, uploadType := range uploadTypes {
+		t.bucket.CreateObject(
+			t.ctx,
+			&CreateObjectRequest{
+				Name:       "foo",
+				Contents:   strings.NewReader("taco"),
+				UploadType: uploadType,
+			})
+	}
+
+	AssertEq(len(uploadTypes), len(t.requests))
+	for i, req := range t.requests {
+		ExpectEq(uploadTypes[i], req.query.Get("uploadType"))
+		ExpectEq("full", req.query.Get("projection"), "%s", uploadTypes[i])
+		ExpectEq("", req.query.Get("fields"), "%s", uploadTypes[i])
+	}
+}
+
+func (t *MultipartUploadTest) ReturnsCompleteRecord() {
+	t.response = `{
+		"name": "foo",
+		"size": "4",
+		"crc32c": "AAAAAA==",
+		"generation": "1234",
+		"metageneration": "1",
+		"etag": "CNIJEAE=",
+		"storageClass": "NEARLINE",
+		"owner": {"entity": "user-taco"},
+		"timeCreated": "2019-01-02T03:04:05Z",
+		"updated": "2019-01-02T03:04:05Z"
+	}`
+
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(1234, o.Generation)
+	ExpectEq(1, o.MetaGeneration)
+	ExpectEq("CNIJEAE=", o.Etag)
+	ExpectEq("NEARLINE", o.StorageClass)
+	ExpectEq("user-taco", o.Owner)
+	ExpectFalse(o.Created.IsZero())
+	ExpectFalse(o.Updated.IsZero())
+}
+
+func (t *MultipartUploadTest) DiscardResponse() {
+	t.response = `{"name": "foo"}`
+
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:            "foo",
+			Contents:        strings.NewReader("taco"),
+			DiscardResponse: true,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(nil, o)
+
+	AssertEq(1, len(t.requests))
+	ExpectEq("name", t.requests[0].query.Get("fields"))
+}
+
+func (t *MultipartUploadTest) DiscardResponseWithVerifyChecksums() {
+	// Respond with the correct checksums.
+	var crc32c [4]byte
+	binary.BigEndian.PutUint32(
+		crc32c[:],
+		crc32.Checksum([]byte("taco"), crc32cTable))
+
+	md5Sum := md5.Sum([]byte("taco"))
+
+	t.response = fmt.Sprintf(
+		`{"name": "foo", "generation": "1", "crc32c": %q, "md5Hash": %q}`,
+		base64.StdEncoding.EncodeToString(crc32c[:]),
+		base64.StdEncoding.EncodeToString(md5Sum[:]))
+
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:            "foo",
+			Contents:        strings.NewReader("taco"),
+			VerifyChecksums: true,
+			DiscardResponse: true,
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(nil, o)
+
+	AssertEq(1, len(t.requests))
+	ExpectEq(
+		"name,generation,crc32c,md5Hash",
+		t.requests[0].query.Get("fields"))
+}
@@ -41,9 +41,49 @@ type Object struct {
 	Generation      int64
 	MetaGeneration  int64
 	StorageClass    string
+	Created         time.Time
 	Deleted         time.Time
 	Updated         time.Time
 
+	// An opaque tag that changes whenever the object's contents or metadata
+	// do, for use in cache validation. Unlike the JSON API's HTTP ETag, it is
+	// not quoted.
+	Etag string
+
+	// Holds that prevent the object from being deleted or overwritten while
+	// set. See here for more information:
+	//
+	//     https://cloud.google.com/storage/docs/object-holds
+	//
+	TemporaryHold  bool
+	EventBasedHold bool
+
+	// The earliest time at which the object may be deleted or overwritten
+	// under the bucket's retention policy, or the zero time if the bucket has
+	// none.
+	RetentionExpirationTime time.Time
+
+	// The object's own retention configuration, or nil if it has none. See
+	// here for more information:
+	//
+	//     https://cloud.google.com/storage/docs/object-lock
+	//
+	Retention *ObjectRetention
+
+	// A user-specified timestamp for the object, which lifecycle rules can act
+	// upon. The zero time if unset.
+	CustomTime time.Time
+
+	// The resource name of the Cloud KMS key used to encrypt the object, or
+	// empty if it is encrypted with a Google-managed key.
+	KMSKeyName string
+
+	// For soft-deleted objects, as listed with ListObjectsRequest.SoftDeleted,
+	// when the object was deleted and the time after which it can no longer be
+	// restored. The zero time for other objects.
+	SoftDeleteTime time.Time
+	HardDeleteTime time.Time
+
 	// NOTE(jacobsa): As of 2015-06-03, the official GCS documentation for this
 	// property (https://goo.gl/GwD5Dq) says this:
 	//
@@ -65,3 +105,15 @@ type Object struct {
 	// component count of 1 for objects that do not have a component count.
 	ComponentCount int64
 }
+
+// ObjectRetention describes the retention configuration of an individual
+// object, which prevents it from being deleted or overwritten until a given
+// time.
+type ObjectRetention struct {
+	// "Unlocked", meaning the configuration may be changed or removed, or
+	// "Locked", meaning that RetainUntil may only be extended.
+	Mode string
+
+	// The time until which the object is retained.
+	RetainUntil time.Time
+}
@@ -0,0 +1,302 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+)
+
+// Roles that may be granted on an object. Note that unlike buckets, objects
+// have no writer role; anyone with write access to the bucket may overwrite or
+// delete its objects.
+const (
+	ACLRoleReader = "READER"
+	ACLRoleOwner  = "OWNER"
+)
+
+// Predefined ACLs that may be applied to an object as it is created, via
+// CreateObjectRequest.PredefinedACL or the DstPredefinedACL field of the copy
+// and rewrite requests, rather than with a separate request afterward. See
+// here for more information:
+//
+//     https://cloud.google.com/storage/docs/access-control/lists#predefined-acl
+//
+const (
+	// The object owner gets OWNER access, and allUsers get READER access, so
+	// that anyone may download the object at its PublicURL.
+	PredefinedACLPublicRead = "publicRead"
+
+	// The object owner gets OWNER access, and no one else has access.
+	PredefinedACLPrivate = "private"
+
+	// The object owner gets OWNER access, and allAuthenticatedUsers get READER
+	// access.
+	PredefinedACLAuthenticatedRead = "authenticatedRead"
+
+	// The object owner gets OWNER access, and the project's owners get READER
+	// access.
+	PredefinedACLBucketOwnerRead = "bucketOwnerRead"
+
+	// The object owner and the project's owners get OWNER access.
+	PredefinedACLBucketOwnerFullControl = "bucketOwnerFullControl"
+
+	// The object owner gets OWNER access, and the project's members get access
+	// according to their roles.
+	PredefinedACLProjectPrivate = "projectPrivate"
+)
+
+// ACLRule is an entry in the access control list of an object, granting a
+// role to an entity.
+//
+// See here for more information about its fields:
+//
+//     https://cloud.google.com/storage/docs/json_api/v1/objectAccessControls#resource
+//
+type ACLRule struct {
+	// The entity holding the role, in one of the following forms:
+	//
+	//  *  user-<userId> or user-<email>
+	//  *  group-<groupId> or group-<email>
+	//  *  domain-<domain>
+	//  *  project-<team>-<projectId>
+	//  *  allUsers
+	//  *  allAuthenticatedUsers
+	//
+	Entity string
+
+	// The role held by the entity. See ACLRoleReader and ACLRoleOwner.
+	Role string
+
+	// Information filled in by GCS for some kinds of entity, or empty.
+	Email  string
+	Domain string
+}
+
+func toACLRule(in *storagev1.ObjectAccessControl) (out *ACLRule) {
+	out = &ACLRule{
+		Entity: in.Entity,
+		Role:   in.Role,
+		Email:  in.Email,
+		Domain: in.Domain,
+	}
+
+	return
+}
+
+// Return an error if GCS would reject a rule granting the given role to the
+// given entity. GCS's own errors for these are unhelpful.
+func checkACLRule(entity string, role string) (err error) {
+	if entity == "" {
+		err = errors.New("Entity must be specified")
+		return
+	}
+
+	if role != ACLRoleReader && role != ACLRoleOwner {
+		err = fmt.Errorf("Unsupported role: %q", role)
+		return
+	}
+
+	return
+}
+
+// Return the URL for the ACL of the given object, or for a particular entity
+// within it if entity is non-empty.
+func (b *bucket) objectACLURL(
+	name string,
+	generation int64,
+	entity string) (u *url.URL) {
+	path := fmt.Sprintf(
+		"/storage/v1/b/%s/o/%s/acl",
+		httputil.EncodePathSegment(b.Name()),
+		httputil.EncodePathSegment(name))
+
+	if entity != "" {
+		path += "/" + httputil.EncodePathSegment(entity)
+	}
+
+	query := make(url.Values)
+	if generation != 0 {
+		query.Set("generation", fmt.Sprintf("%d", generation))
+	}
+
+	b.addCommonParams(query)
+
+	u = makeURL(b.endpoint, path, query)
+
+	return
+}
+
+// Execute the supplied request, translating 404 responses into
+// *NotFoundError. On success the caller must close the response body.
+func (b *bucket) doACLRequest(
+	httpReq *http.Request) (httpRes *http.Response, err error) {
+	httpRes, err = b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	// Check for HTTP-level errors.
+	if err = checkResponse(httpRes); err != nil {
+		googleapi.CloseBody(httpRes)
+
+		// Special case: handle not found errors.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusNotFound {
+				err = &NotFoundError{Err: typed}
+			}
+		}
+
+		return
+	}
+
+	return
+}
+
+func (b *bucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	// Create an HTTP request.
+	url := b.objectACLURL(req.Name, req.Generation, "")
+	httpReq, err := httputil.NewRequest(ctx, "GET", url, nil, 0, b.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	// Execute the HTTP request.
+	httpRes, err := b.doACLRequest(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Parse the response.
+	var raw *storagev1.ObjectAccessControls
+	if err = decodeResponse(httpRes, &raw); err != nil {
+		return
+	}
+
+	// Convert the response.
+	for _, item := range raw.Items {
+		rules = append(rules, toACLRule(item))
+	}
+
+	return
+}
+
+func (b *bucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	// Validate the request, since GCS's errors for these are unhelpful.
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	if err = checkACLRule(req.Entity, req.Role); err != nil {
+		return
+	}
+
+	// Set up the request body. Inserting an entry for an entity that already
+	// has one replaces its role.
+	body, err := json.Marshal(&storagev1.ObjectAccessControl{
+		Entity: req.Entity,
+		Role:   req.Role,
+	})
+
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	// Create an HTTP request.
+	url := b.objectACLURL(req.Name, req.Generation, "")
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"POST",
+		url,
+		ioutil.NopCloser(bytes.NewReader(body)),
+		int64(len(body)),
+		b.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Execute the HTTP request.
+	httpRes, err := b.doACLRequest(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Parse the response.
+	var raw *storagev1.ObjectAccessControl
+	if err = decodeResponse(httpRes, &raw); err != nil {
+		return
+	}
+
+	rule = toACLRule(raw)
+	return
+}
+
+func (b *bucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	if req.Entity == "" {
+		err = errors.New("Entity must be specified")
+		return
+	}
+
+	// Create an HTTP request.
+	url := b.objectACLURL(req.Name, req.Generation, req.Entity)
+	httpReq, err := httputil.NewRequest(ctx, "DELETE", url, nil, 0, b.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	// Execute the HTTP request.
+	httpRes, err := b.doACLRequest(httpReq)
+	if err != nil {
+		return
+	}
+
+	googleapi.CloseBody(httpRes)
+	return
+}
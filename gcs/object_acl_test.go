@@ -0,0 +1,234 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestObjectACL(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ObjectACLTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &ObjectACLTest{}
+
+func init() { RegisterTestSuite(&ObjectACLTest{}) }
+
+func (t *ObjectACLTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.bucket = newBucket(
+		&http.Client{Transport: &t.transport},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ObjectACLTest) List() {
+	t.transport.response = `{
+		"items": [
+			{"entity": "user-foo@example.com", "role": "OWNER", "email": "foo@example.com"},
+			{"entity": "allUsers", "role": "READER"}
+		]
+	}`
+
+	req := &ListObjectACLsRequest{
+		Name:       "foo/bar",
+		Generation: 17,
+	}
+
+	rules, err := t.bucket.ListObjectACLs(t.ctx, req)
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("GET", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/o/foo%2Fbar/acl",
+		httpReq.URL.Opaque)
+	ExpectEq("17", httpReq.URL.Query().Get("generation"))
+
+	// Response
+	AssertEq(2, len(rules))
+	ExpectEq("user-foo@example.com", rules[0].Entity)
+	ExpectEq(ACLRoleOwner, rules[0].Role)
+	ExpectEq("foo@example.com", rules[0].Email)
+	ExpectEq("allUsers", rules[1].Entity)
+	ExpectEq(ACLRoleReader, rules[1].Role)
+}
+
+func (t *ObjectACLTest) Update() {
+	t.transport.response = `{"entity": "allUsers", "role": "READER"}`
+
+	req := &UpdateObjectACLRequest{
+		Name:   "foo",
+		Entity: "allUsers",
+		Role:   ACLRoleReader,
+	}
+
+	rule, err := t.bucket.UpdateObjectACL(t.ctx, req)
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("POST", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/o/foo/acl",
+		httpReq.URL.Opaque)
+
+	body, err := ioutil.ReadAll(httpReq.Body)
+	AssertEq(nil, err)
+
+	var sent map[string]string
+	AssertEq(nil, json.Unmarshal(body, &sent))
+	ExpectEq("allUsers", sent["entity"])
+	ExpectEq("READER", sent["role"])
+
+	// Response
+	ExpectEq("allUsers", rule.Entity)
+	ExpectEq(ACLRoleReader, rule.Role)
+}
+
+func (t *ObjectACLTest) Update_UnsupportedRole() {
+	req := &UpdateObjectACLRequest{
+		Name:   "foo",
+		Entity: "allUsers",
+		Role:   "WRITER",
+	}
+
+	_, err := t.bucket.UpdateObjectACL(t.ctx, req)
+	ExpectThat(err, Error(HasSubstr("WRITER")))
+	ExpectEq(0, len(t.transport.requests))
+}
+
+func (t *ObjectACLTest) Delete() {
+	req := &DeleteObjectACLRequest{
+		Name:   "foo",
+		Entity: "user-foo@example.com",
+	}
+
+	err := t.bucket.DeleteObjectACL(t.ctx, req)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("DELETE", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/o/foo/acl/user-foo@example.com",
+		httpReq.URL.Opaque)
+}
+
+func (t *ObjectACLTest) Delete_MissingEntity() {
+	err := t.bucket.DeleteObjectACL(t.ctx, &DeleteObjectACLRequest{Name: "foo"})
+	ExpectThat(err, Error(HasSubstr("Entity")))
+	ExpectEq(0, len(t.transport.requests))
+}
+
+func (t *ObjectACLTest) UpdateObjectWithACL() {
+	t.transport.response = `{"name": "foo", "crc32c": "AAAAAA=="}`
+
+	contentType := "text/plain"
+	req := &UpdateObjectRequest{
+		Name:        "foo",
+		ContentType: &contentType,
+		ACL: []ACLRule{
+			{Entity: "user-foo@example.com", Role: ACLRoleOwner},
+			{Entity: "allUsers", Role: ACLRoleReader, Email: "ignored"},
+		},
+	}
+
+	_, err := t.bucket.UpdateObject(t.ctx, req)
+	AssertEq(nil, err)
+
+	// Both changes go in a single request.
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("PATCH", httpReq.Method)
+
+	body, err := ioutil.ReadAll(httpReq.Body)
+	AssertEq(nil, err)
+	ExpectEq(
+		`{"acl":[{"entity":"user-foo@example.com","role":"OWNER"},`+
+			`{"entity":"allUsers","role":"READER"}],"contentType":"text/plain"}`+"\n",
+		string(body))
+}
+
+func (t *ObjectACLTest) UpdateObjectWithEmptyACL() {
+	t.transport.response = `{"name": "foo", "crc32c": "AAAAAA=="}`
+
+	_, err := t.bucket.UpdateObject(
+		t.ctx,
+		&UpdateObjectRequest{Name: "foo", ACL: []ACLRule{}})
+
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	body, err := ioutil.ReadAll(t.transport.requests[0].Body)
+	AssertEq(nil, err)
+	ExpectEq(`{"acl":[]}`+"\n", string(body))
+}
+
+func (t *ObjectACLTest) UpdateObjectWithACL_UnsupportedRole() {
+	_, err := t.bucket.UpdateObject(
+		t.ctx,
+		&UpdateObjectRequest{
+			Name: "foo",
+			ACL:  []ACLRule{{Entity: "allUsers", Role: "WRITER"}},
+		})
+
+	ExpectThat(err, Error(HasSubstr("WRITER")))
+	ExpectEq(0, len(t.transport.requests))
+}
+
+func (t *ObjectACLTest) CreateWithPredefinedACL() {
+	// The transport doesn't supply an upload URL, so we expect the upload to
+	// fail after starting the session.
+	t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:          "foo",
+			Contents:      strings.NewReader("taco"),
+			PredefinedACL: PredefinedACLPublicRead,
+		})
+
+	AssertLe(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("resumable", query.Get("uploadType"))
+	ExpectEq("publicRead", query.Get("predefinedAcl"))
+}
@@ -0,0 +1,81 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// The maximum length in bytes of an object name.
+const MaxObjectNameLength = 1024
+
+// The number of bytes of an invalid name to include in error messages.
+const invalidNameQuoteLength = 64
+
+// Return an *InvalidNameError if the supplied string isn't a legal object
+// name, as documented for CreateObjectRequest.Name. Buckets created by this
+// package and its subpackages check names with this function before doing
+// anything else, so that illegal names fail fast with a clear message rather
+// than with whatever GCS or the backing store makes of them.
+func CheckObjectName(name string) (err error) {
+	var problem string
+	switch {
+	case name == "":
+		problem = "empty"
+
+	case len(name) > MaxObjectNameLength:
+		problem = fmt.Sprintf(
+			"%d bytes long, but the limit is %d",
+			len(name),
+			MaxObjectNameLength)
+
+	case !utf8.ValidString(name):
+		problem = "not valid UTF-8"
+
+	case strings.ContainsAny(name, "\r\n"):
+		problem = "contains a carriage return or line feed"
+
+	default:
+		return
+	}
+
+	err = &InvalidNameError{
+		Err: fmt.Errorf("Invalid object name %s: %s", quoteName(name), problem),
+	}
+
+	return
+}
+
+// Like CheckObjectName, but for each of several names.
+func checkObjectNames(names ...string) (err error) {
+	for _, name := range names {
+		if err = CheckObjectName(name); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Quote a possibly very long name for use in an error message.
+func quoteName(name string) string {
+	if len(name) <= invalidNameQuoteLength {
+		return fmt.Sprintf("%q", name)
+	}
+
+	return fmt.Sprintf("%q...", name[:invalidNameQuoteLength])
+}
@@ -0,0 +1,144 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestObjectName(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ObjectNameTest struct {
+	ctx       context.Context
+	transport headerTransport
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &ObjectNameTest{}
+
+func init() { RegisterTestSuite(&ObjectNameTest{}) }
+
+func (t *ObjectNameTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.transport.status = http.StatusOK
+	t.transport.header = make(http.Header)
+
+	t.bucket = newBucket(
+		&http.Client{Transport: &t.transport},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ObjectNameTest) LegalNames() {
+	names := []string{
+		"a",
+		"foo/bar",
+		"foo bar\t",
+		"タコス",
+		strings.Repeat("a", MaxObjectNameLength),
+		strings.Repeat("é", MaxObjectNameLength/2),
+	}
+
+	for _, name := range names {
+		ExpectEq(nil, CheckObjectName(name), "name: %q", name)
+	}
+}
+
+func (t *ObjectNameTest) IllegalNames() {
+	testCases := []struct {
+		name    string
+		message string
+	}{
+		{"", `Invalid object name "": empty`},
+		{"foo\xff", `Invalid object name "foo\xff": not valid UTF-8`},
+		{"foo\nbar", `Invalid object name "foo\nbar": contains a carriage return`},
+		{"foo\rbar", `Invalid object name "foo\rbar": contains a carriage return`},
+		{strings.Repeat("a", MaxObjectNameLength+1), "1025 bytes long"},
+	}
+
+	for _, tc := range testCases {
+		err := CheckObjectName(tc.name)
+		ExpectThat(err, HasSameTypeAs(&InvalidNameError{}), "name: %q", tc.name)
+		ExpectThat(err, Error(HasSubstr(tc.message)), "name: %q", tc.name)
+	}
+}
+
+func (t *ObjectNameTest) LongNamesAbbreviated() {
+	err := CheckObjectName(strings.Repeat("a", 2000))
+	AssertNe(nil, err)
+
+	ExpectThat(err, Error(HasSubstr(`"`+strings.Repeat("a", 64)+`"...`)))
+	ExpectThat(err, Error(Not(HasSubstr(strings.Repeat("a", 65)))))
+}
+
+func (t *ObjectNameTest) CheckedBeforeSending() {
+	var err error
+	const name = "foo\nbar"
+
+	_, err = t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: name})
+	ExpectThat(err, HasSameTypeAs(&InvalidNameError{}))
+
+	_, err = t.bucket.NewReader(t.ctx, &ReadObjectRequest{Name: name})
+	ExpectThat(err, HasSameTypeAs(&InvalidNameError{}))
+
+	_, err = t.bucket.UpdateObject(t.ctx, &UpdateObjectRequest{Name: name})
+	ExpectThat(err, HasSameTypeAs(&InvalidNameError{}))
+
+	err = t.bucket.DeleteObject(t.ctx, &DeleteObjectRequest{Name: name})
+	ExpectThat(err, HasSameTypeAs(&InvalidNameError{}))
+
+	_, err = t.bucket.CopyObject(
+		t.ctx,
+		&CopyObjectRequest{SrcName: name, DstName: "foo"})
+	ExpectThat(err, HasSameTypeAs(&InvalidNameError{}))
+
+	_, err = t.bucket.ComposeObjects(
+		t.ctx,
+		&ComposeObjectsRequest{
+			DstName: "foo",
+			Sources: []ComposeSource{{Name: name}},
+		})
+	ExpectThat(err, HasSameTypeAs(&InvalidNameError{}))
+
+	_, err = t.bucket.ListObjectACLs(t.ctx, &ListObjectACLsRequest{Name: name})
+	ExpectThat(err, HasSameTypeAs(&InvalidNameError{}))
+
+	_, err = t.bucket.Batch(
+		t.ctx,
+		&BatchRequest{
+			Ops: []BatchOp{{Delete: &DeleteObjectRequest{Name: name}}},
+		})
+	ExpectThat(err, Error(HasSubstr("Invalid object name")))
+
+	ExpectEq(0, len(t.transport.requests))
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// An io.WriteCloser that creates an object from the data written to it. See
+// NewObjectWriter.
+type ObjectWriter struct {
+	pw *io.PipeWriter
+
+	// Closed once the call to CreateObject has returned, after which the fields
+	// below are set.
+	done chan struct{}
+
+	o   *Object
+	err error
+}
+
+// Return a writer that creates an object with the given name and attributes
+// from the data written to it. req.Contents is ignored. The object is created
+// by a call to bucket.CreateObject, which streams the data to GCS as it's
+// written, buffering at most a chunk at a time (see
+// CreateObjectRequest.ChunkSize) unless req.Compress is set. Because it's
+// built on CreateObject, it works with any Bucket, including the decorators in
+// this package.
+//
+// The data can't be replayed, so a bucket returned by NewRetryBucket retries
+// the upload only until it has started to consume the data, as for a request
+// with a negative ChunkSize, rather than buffering all of it.
+//
+// The object is committed only when Close returns nil. If ctx is cancelled,
+// or Abort is called, the upload is abandoned and the object is left
+// untouched. Once the upload has failed, Write and Close return its error.
+//
+// Write and Close must not be called concurrently.
+func NewObjectWriter(
+	ctx context.Context,
+	bucket Bucket,
+	req *CreateObjectRequest) (w *ObjectWriter) {
+	pr, pw := io.Pipe()
+
+	w = &ObjectWriter{
+		pw:   pw,
+		done: make(chan struct{}),
+	}
+
+	reqCopy := *req
+	reqCopy.Contents = pr
+	reqCopy.streamContents = true
+
+	go func() {
+		w.o, w.err = bucket.CreateObject(ctx, &reqCopy)
+
+		// Make sure that writes don't block forever if CreateObject stopped
+		// reading early.
+		closeErr := w.err
+		if closeErr == nil {
+			closeErr = errors.New("CreateObject returned before the writer was closed")
+		}
+
+		pr.CloseWithError(closeErr)
+		close(w.done)
+	}()
+
+	return
+}
+
+// Write data to the object. If the upload fails, the error is returned.
+func (w *ObjectWriter) Write(p []byte) (n int, err error) {
+	n, err = w.pw.Write(p)
+	return
+}
+
+// Finish writing data, and wait for the object to be committed.
+func (w *ObjectWriter) Close() (err error) {
+	w.pw.Close()
+	<-w.done
+
+	err = w.err
+	return
+}
+
+// Abandon the upload, making CreateObject fail with the supplied error (or
+// io.ErrClosedPipe if it's nil), and wait for it to return. If the object has
+// already been committed by Close, this has no effect.
+func (w *ObjectWriter) Abort(err error) {
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+
+	w.pw.CloseWithError(err)
+	<-w.done
+}
+
+// Return the record for the object that was created, or nil if the request
+// set DiscardResponse. Valid only after Close has returned nil.
+func (w *ObjectWriter) Object() *Object {
+	return w.o
+}
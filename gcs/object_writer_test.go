@@ -0,0 +1,225 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestObjectWriter(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that closes a channel when CreateObject first reads from
+// req.Contents.
+type startNotifyingBucket struct {
+	gcs.Bucket
+	started chan struct{}
+}
+
+func (b *startNotifyingBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	reqCopy := *req
+	reqCopy.Contents = &startNotifyingReader{
+		wrapped: req.Contents,
+		started: b.started,
+	}
+
+	o, err = b.Bucket.CreateObject(ctx, &reqCopy)
+	return
+}
+
+type startNotifyingReader struct {
+	wrapped io.Reader
+	started chan struct{}
+	once    sync.Once
+}
+
+func (r *startNotifyingReader) Read(p []byte) (n int, err error) {
+	n, err = r.wrapped.Read(p)
+	if n > 0 {
+		r.once.Do(func() { close(r.started) })
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ObjectWriterTest struct {
+	ctx    context.Context
+	bucket gcs.Bucket
+}
+
+var _ SetUpInterface = &ObjectWriterTest{}
+
+func init() { RegisterTestSuite(&ObjectWriterTest{}) }
+
+func (t *ObjectWriterTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.bucket = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+}
+
+func (t *ObjectWriterTest) exists(name string) bool {
+	_, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: name})
+	if _, ok := err.(*gcs.NotFoundError); ok {
+		return false
+	}
+
+	AssertEq(nil, err)
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ObjectWriterTest) WritesInPieces() {
+	w := gcs.NewObjectWriter(
+		t.ctx,
+		t.bucket,
+		&gcs.CreateObjectRequest{
+			Name:        "foo",
+			ContentType: "text/plain",
+		})
+
+	var expected string
+	for i := 0; i < 100; i++ {
+		s := fmt.Sprintf("line %d\n", i)
+		expected += s
+
+		_, err := io.WriteString(w, s)
+		AssertEq(nil, err)
+	}
+
+	// Nothing is committed until Close.
+	ExpectFalse(t.exists("foo"))
+
+	AssertEq(nil, w.Close())
+
+	o := w.Object()
+	AssertNe(nil, o)
+	ExpectEq("foo", o.Name)
+	ExpectEq("text/plain", o.ContentType)
+	ExpectEq(len(expected), o.Size)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq(expected, string(contents))
+}
+
+func (t *ObjectWriterTest) NotBufferedByRetryBucket() {
+	started := make(chan struct{})
+	b := gcs.NewRetryBucket(
+		&startNotifyingBucket{Bucket: t.bucket, started: started},
+		gcs.RetryPolicy{MaxSleep: time.Minute})
+
+	w := gcs.NewObjectWriter(t.ctx, b, &gcs.CreateObjectRequest{Name: "foo"})
+
+	// The wrapped bucket should see the data before Close, rather than the
+	// retry bucket holding on to it until then.
+	_, err := io.WriteString(w, "taco")
+	AssertEq(nil, err)
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		AddFailure("The wrapped bucket didn't start reading the contents")
+	}
+
+	AssertEq(nil, w.Close())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *ObjectWriterTest) EmptyObject() {
+	w := gcs.NewObjectWriter(t.ctx, t.bucket, &gcs.CreateObjectRequest{Name: "foo"})
+	AssertEq(nil, w.Close())
+	ExpectEq(0, w.Object().Size)
+	ExpectTrue(t.exists("foo"))
+}
+
+func (t *ObjectWriterTest) ContentsFieldIsIgnored() {
+	w := gcs.NewObjectWriter(
+		t.ctx,
+		t.bucket,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("ignored"),
+		})
+
+	_, err := io.WriteString(w, "taco")
+	AssertEq(nil, err)
+	AssertEq(nil, w.Close())
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *ObjectWriterTest) Abort() {
+	w := gcs.NewObjectWriter(t.ctx, t.bucket, &gcs.CreateObjectRequest{Name: "foo"})
+
+	_, err := io.WriteString(w, "taco")
+	AssertEq(nil, err)
+
+	w.Abort(errors.New("taco"))
+	ExpectFalse(t.exists("foo"))
+
+	// Further writes fail.
+	_, err = io.WriteString(w, "burrito")
+	ExpectNe(nil, err)
+}
+
+func (t *ObjectWriterTest) UploadFails() {
+	var gen int64 = 17
+	w := gcs.NewObjectWriter(
+		t.ctx,
+		t.bucket,
+		&gcs.CreateObjectRequest{
+			Name:                   "foo",
+			GenerationPrecondition: &gen,
+		})
+
+	_, err := io.WriteString(w, "taco")
+	AssertEq(nil, err)
+
+	err = w.Close()
+	ExpectThat(err, HasSameTypeAs(&gcs.PreconditionError{}))
+	ExpectEq(nil, w.Object())
+	ExpectFalse(t.exists("foo"))
+}
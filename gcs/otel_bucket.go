@@ -0,0 +1,426 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+)
+
+// The name of the OpenTelemetry tracer used by this package.
+const otelTracerName = "github.com/jacobsa/gcloud/gcs"
+
+// Attributes set on the OpenTelemetry spans recorded for bucket operations.
+const (
+	// The name of the bucket.
+	AttrBucket = attribute.Key("gcs.bucket")
+
+	// The name of the object operated upon: the destination object for copies,
+	// moves, composes, and rewrites.
+	AttrObject = attribute.Key("gcs.object")
+
+	// The name of the source object for copies, moves, and rewrites.
+	AttrSourceObject = attribute.Key("gcs.source_object")
+
+	// The generation of the object, when requested by the caller or known from
+	// the result of the operation.
+	AttrGeneration = attribute.Key("gcs.object.generation")
+
+	// The size of the object, when known from the result of the operation.
+	AttrSize = attribute.Key("gcs.object.size")
+
+	// The prefix of a listing.
+	AttrPrefix = attribute.Key("gcs.prefix")
+
+	// The request ID attached to the operation's context with WithRequestID.
+	AttrRequestID = attribute.Key("gcs.request_id")
+
+	// The number of operations in a batch.
+	AttrBatchSize = attribute.Key("gcs.batch.size")
+)
+
+// Create a bucket that records an OpenTelemetry span with the supplied tracer
+// for each call to the wrapped bucket, along with child spans for the steps
+// the wrapped bucket takes (see SpanHook).
+func newOTelBucket(
+	wrapped Bucket,
+	tracer trace.Tracer) (b Bucket) {
+	b = &otelBucket{
+		wrapped: wrapped,
+		tracer:  tracer,
+	}
+
+	return
+}
+
+type otelBucket struct {
+	wrapped Bucket
+	tracer  trace.Tracer
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Start a span for a call to the named method, with the supplied attributes
+// in addition to those common to all calls. The returned context carries a
+// span hook that records child spans.
+func (b *otelBucket) startSpan(
+	ctx context.Context,
+	method string,
+	attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, AttrBucket.String(b.Name()))
+	if id := RequestIDFromContext(ctx); id != "" {
+		attrs = append(attrs, AttrRequestID.String(id))
+	}
+
+	ctx, span := b.tracer.Start(
+		ctx,
+		"gcs."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+
+	ctx = withSpanHook(ctx, b.childSpan)
+	return ctx, span
+}
+
+// A SpanHook that records the steps taken within a call as child spans.
+func (b *otelBucket) childSpan(
+	ctx context.Context,
+	desc string) (context.Context, func(error)) {
+	ctx, span := b.tracer.Start(ctx, desc)
+	report := func(err error) {
+		endSpan(span, nil, err)
+	}
+
+	return ctx, report
+}
+
+// Record the outcome of an operation on its span and end it. o is the object
+// record returned by the operation, if any.
+func endSpan(span trace.Span, o *Object, err error) {
+	if o != nil {
+		span.SetAttributes(
+			AttrGeneration.Int64(o.Generation),
+			AttrSize.Int64(int64(o.Size)))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Return attributes for a generation chosen by the caller, if any.
+func generationAttrs(generation int64) (attrs []attribute.KeyValue) {
+	if generation != 0 {
+		attrs = append(attrs, AttrGeneration.Int64(generation))
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *otelBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *otelBucket) NewReader(
+	ctx context.Context,
+	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	attrs := append(
+		generationAttrs(req.Generation),
+		AttrObject.String(req.Name))
+
+	ctx, span := b.startSpan(ctx, "NewReader", attrs...)
+
+	// Call the wrapped bucket.
+	rc, err = b.wrapped.NewReader(ctx, req)
+
+	// If the bucket failed, we must report that now.
+	if err != nil {
+		endSpan(span, nil, err)
+		return
+	}
+
+	// Otherwise the span lasts until the reader is closed.
+	rc = &reportingReadCloser{
+		Wrapped: rc,
+		Report: func(err error) {
+			endSpan(span, nil, err)
+		},
+	}
+
+	return
+}
+
+func (b *otelBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	ctx, span := b.startSpan(ctx, "CreateObject", AttrObject.String(req.Name))
+	defer func() { endSpan(span, o, err) }()
+
+	o, err = b.wrapped.CreateObject(ctx, req)
+	return
+}
+
+func (b *otelBucket) CopyObject(
+	ctx context.Context,
+	req *CopyObjectRequest) (o *Object, err error) {
+	ctx, span := b.startSpan(
+		ctx,
+		"CopyObject",
+		AttrSourceObject.String(req.SrcName),
+		AttrObject.String(req.DstName))
+
+	defer func() { endSpan(span, o, err) }()
+
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *otelBucket) MoveObject(
+	ctx context.Context,
+	req *MoveObjectRequest) (o *Object, err error) {
+	ctx, span := b.startSpan(
+		ctx,
+		"MoveObject",
+		AttrSourceObject.String(req.SrcName),
+		AttrObject.String(req.DstName))
+
+	defer func() { endSpan(span, o, err) }()
+
+	o, err = b.wrapped.MoveObject(ctx, req)
+	return
+}
+
+func (b *otelBucket) ComposeObjects(
+	ctx context.Context,
+	req *ComposeObjectsRequest) (o *Object, err error) {
+	ctx, span := b.startSpan(ctx, "ComposeObjects", AttrObject.String(req.DstName))
+	defer func() { endSpan(span, o, err) }()
+
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *otelBucket) StatObject(
+	ctx context.Context,
+	req *StatObjectRequest) (o *Object, err error) {
+	ctx, span := b.startSpan(ctx, "StatObject", AttrObject.String(req.Name))
+	defer func() { endSpan(span, o, err) }()
+
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *otelBucket) ListObjects(
+	ctx context.Context,
+	req *ListObjectsRequest) (listing *Listing, err error) {
+	ctx, span := b.startSpan(ctx, "ListObjects", AttrPrefix.String(req.Prefix))
+	defer func() { endSpan(span, nil, err) }()
+
+	listing, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *otelBucket) UpdateObject(
+	ctx context.Context,
+	req *UpdateObjectRequest) (o *Object, err error) {
+	attrs := append(
+		generationAttrs(req.Generation),
+		AttrObject.String(req.Name))
+
+	ctx, span := b.startSpan(ctx, "UpdateObject", attrs...)
+	defer func() { endSpan(span, o, err) }()
+
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *otelBucket) DeleteObject(
+	ctx context.Context,
+	req *DeleteObjectRequest) (err error) {
+	attrs := append(
+		generationAttrs(req.Generation),
+		AttrObject.String(req.Name))
+
+	ctx, span := b.startSpan(ctx, "DeleteObject", attrs...)
+	defer func() { endSpan(span, nil, err) }()
+
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+func (b *otelBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	attrs := append(
+		generationAttrs(req.Generation),
+		AttrObject.String(req.Name))
+
+	ctx, span := b.startSpan(ctx, "RestoreObject", attrs...)
+	defer func() { endSpan(span, o, err) }()
+
+	o, err = b.wrapped.RestoreObject(ctx, req)
+	return
+}
+
+func (b *otelBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	ctx, span := b.startSpan(ctx, "SignedURL", AttrObject.String(req.Name))
+	defer func() { endSpan(span, nil, err) }()
+
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
+
+func (b *otelBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	attrs := append(
+		generationAttrs(req.Generation),
+		AttrObject.String(req.Name))
+
+	ctx, span := b.startSpan(ctx, "ListObjectACLs", attrs...)
+	defer func() { endSpan(span, nil, err) }()
+
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *otelBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	attrs := append(
+		generationAttrs(req.Generation),
+		AttrObject.String(req.Name))
+
+	ctx, span := b.startSpan(ctx, "UpdateObjectACL", attrs...)
+	defer func() { endSpan(span, nil, err) }()
+
+	rule, err = b.wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *otelBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	attrs := append(
+		generationAttrs(req.Generation),
+		AttrObject.String(req.Name))
+
+	ctx, span := b.startSpan(ctx, "DeleteObjectACL", attrs...)
+	defer func() { endSpan(span, nil, err) }()
+
+	err = b.wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *otelBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	attrs := append(
+		generationAttrs(req.SrcGeneration),
+		AttrSourceObject.String(req.SrcName),
+		AttrObject.String(req.DstName))
+
+	ctx, span := b.startSpan(ctx, "RewriteObject", attrs...)
+	defer func() { endSpan(span, o, err) }()
+
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	return
+}
+
+func (b *otelBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	ctx, span := b.startSpan(ctx, "Batch", AttrBatchSize.Int(len(req.Ops)))
+	defer func() { endSpan(span, nil, err) }()
+
+	results, err = b.wrapped.Batch(ctx, req)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Propagation
+////////////////////////////////////////////////////////////////////////
+
+// Return the tracer provider to use for the supplied config: the configured
+// one if any, otherwise the global one.
+func chooseTracerProvider(cfg *ConnConfig) (tp trace.TracerProvider) {
+	tp = cfg.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return
+}
+
+// Return the propagator to use for the supplied config: the configured one if
+// any, otherwise the global one.
+func choosePropagator(cfg *ConnConfig) (p propagation.TextMapPropagator) {
+	p = cfg.Propagator
+	if p == nil {
+		p = otel.GetTextMapPropagator()
+	}
+
+	return
+}
+
+// Wrap the supplied round tripper in a layer that injects the trace context
+// of each request's context into its headers using the supplied propagator.
+func newTracePropagatingRoundTripper(
+	wrapped httputil.CancellableRoundTripper,
+	propagator propagation.TextMapPropagator) (rt httputil.CancellableRoundTripper) {
+	rt = &tracePropagatingRoundTripper{
+		wrapped:    wrapped,
+		propagator: propagator,
+	}
+
+	return
+}
+
+type tracePropagatingRoundTripper struct {
+	wrapped    httputil.CancellableRoundTripper
+	propagator propagation.TextMapPropagator
+}
+
+func (t *tracePropagatingRoundTripper) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	// Round trippers mustn't modify the request they're given.
+	req = req.Clone(req.Context())
+	t.propagator.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+
+	res, err = t.wrapped.RoundTrip(req)
+	return
+}
+
+func (t *tracePropagatingRoundTripper) CancelRequest(req *http.Request) {
+	t.wrapped.CancelRequest(req)
+}
@@ -0,0 +1,242 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestOTelBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Return the value of the given attribute of the span, or nil if it has none.
+func spanAttr(
+	s sdktrace.ReadOnlySpan,
+	key attribute.Key) (v interface{}) {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			v = kv.Value.AsInterface()
+			return
+		}
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type OTelBucketTest struct {
+	ctx       context.Context
+	transport headerTransport
+	recorder  *tracetest.SpanRecorder
+	provider  *sdktrace.TracerProvider
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &OTelBucketTest{}
+
+func init() { RegisterTestSuite(&OTelBucketTest{}) }
+
+func (t *OTelBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.transport.status = http.StatusOK
+	t.transport.header = make(http.Header)
+
+	t.recorder = tracetest.NewSpanRecorder()
+	t.provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(t.recorder))
+
+	rt := newTracePropagatingRoundTripper(&t.transport, propagation.TraceContext{})
+	t.bucket = newOTelBucket(
+		newBucket(
+			&http.Client{Transport: rt},
+			"test",
+			"some_bucket",
+			defaultUploadChunkSize,
+			nil,
+			""),
+		t.provider.Tracer(otelTracerName))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *OTelBucketTest) SpanPerOperation() {
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	spans := t.recorder.Ended()
+	AssertEq(2, len(spans))
+
+	s := spans[1]
+	ExpectEq("gcs.StatObject", s.Name())
+	ExpectEq(trace.SpanKindClient, s.SpanKind())
+	ExpectEq("some_bucket", spanAttr(s, AttrBucket))
+	ExpectEq("foo", spanAttr(s, AttrObject))
+	ExpectEq(codes.Unset, s.Status().Code)
+}
+
+func (t *OTelBucketTest) ResultAttributes() {
+	o, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	spans := t.recorder.Ended()
+	AssertEq(2, len(spans))
+	ExpectEq(o.Generation, spanAttr(spans[1], AttrGeneration))
+	ExpectEq(int64(o.Size), spanAttr(spans[1], AttrSize))
+}
+
+func (t *OTelBucketTest) RequestedGeneration() {
+	err := t.bucket.DeleteObject(t.ctx, &DeleteObjectRequest{
+		Name:       "foo",
+		Generation: 17,
+	})
+
+	AssertEq(nil, err)
+
+	spans := t.recorder.Ended()
+	AssertEq(1, len(spans))
+	ExpectEq("gcs.DeleteObject", spans[0].Name())
+	ExpectEq(17, spanAttr(spans[0], AttrGeneration))
+}
+
+func (t *OTelBucketTest) RequestID() {
+	ctx := WithRequestID(t.ctx, "taco")
+	_, err := t.bucket.StatObject(ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	spans := t.recorder.Ended()
+	AssertEq(2, len(spans))
+	ExpectEq("taco", spanAttr(spans[1], AttrRequestID))
+}
+
+func (t *OTelBucketTest) ChildSpans() {
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	spans := t.recorder.Ended()
+	AssertEq(2, len(spans))
+
+	child, parent := spans[0], spans[1]
+	ExpectEq("Decode JSON response", child.Name())
+	ExpectEq(parent.SpanContext().SpanID(), child.Parent().SpanID())
+}
+
+func (t *OTelBucketTest) ErrorRecorded() {
+	t.transport.status = http.StatusNotFound
+
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertNe(nil, err)
+
+	spans := t.recorder.Ended()
+	AssertEq(1, len(spans))
+	ExpectEq(codes.Error, spans[0].Status().Code)
+	ExpectEq(err.Error(), spans[0].Status().Description)
+}
+
+func (t *OTelBucketTest) ReaderSpanEndsOnClose() {
+	rc, err := t.bucket.NewReader(t.ctx, &ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	ExpectEq(0, len(t.recorder.Ended()))
+
+	AssertEq(nil, rc.Close())
+
+	spans := t.recorder.Ended()
+	AssertEq(1, len(spans))
+	ExpectEq("gcs.NewReader", spans[0].Name())
+}
+
+func (t *OTelBucketTest) TraceContextPropagated() {
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	spans := t.recorder.Ended()
+	AssertEq(2, len(spans))
+	traceID := spans[1].SpanContext().TraceID().String()
+
+	AssertEq(1, len(t.transport.requests))
+	ExpectThat(
+		t.transport.requests[0].Header.Get("Traceparent"),
+		HasSubstr(traceID))
+}
+
+func (t *OTelBucketTest) CallerSpanIsParent() {
+	ctx, span := t.provider.Tracer("test").Start(t.ctx, "caller")
+	_, err := t.bucket.StatObject(ctx, &StatObjectRequest{Name: "foo"})
+	span.End()
+
+	AssertEq(nil, err)
+
+	spans := t.recorder.Ended()
+	AssertEq(3, len(spans))
+	ExpectEq(span.SpanContext().SpanID(), spans[1].Parent().SpanID())
+	ExpectEq(span.SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+}
+
+func (t *OTelBucketTest) NoHeaderWithoutSpan() {
+	transport := &headerTransport{
+		status: http.StatusOK,
+		header: make(http.Header),
+	}
+
+	rt := newTracePropagatingRoundTripper(transport, propagation.TraceContext{})
+	b := newBucket(
+		&http.Client{Transport: rt},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+
+	_, err := b.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(transport.requests))
+	_, ok := transport.requests[0].Header["Traceparent"]
+	ExpectFalse(ok)
+}
+
+func (t *OTelBucketTest) GRPCMetadata() {
+	creds := &tokenSourceCredentials{
+		source:     oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"}),
+		propagator: propagation.TraceContext{},
+	}
+
+	ctx, span := t.provider.Tracer("test").Start(t.ctx, "caller")
+	defer span.End()
+
+	md, err := creds.GetRequestMetadata(ctx)
+	AssertEq(nil, err)
+	ExpectThat(md["traceparent"], HasSubstr(span.SpanContext().TraceID().String()))
+}
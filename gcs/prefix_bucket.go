@@ -0,0 +1,326 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Create a view on the wrapped bucket that pretends to be a bucket containing
+// only those objects whose names begin with the given prefix, with the prefix
+// removed. Object names in requests have the prefix prepended before being
+// passed on, and object names and collapsed runs in results have it stripped.
+//
+// For example, with a prefix of "tenant-17/" a request to stat "foo/bar" in
+// the returned bucket is a request to stat "tenant-17/foo/bar" in the wrapped
+// bucket, and a listing of the returned bucket sees only that tenant's
+// objects.
+//
+// Name returns the name of the wrapped bucket. Fields of returned objects
+// other than Name, such as MediaLink, are not rewritten.
+func NewPrefixBucket(
+	wrapped Bucket,
+	prefix string) (b Bucket) {
+	b = &prefixBucket{
+		prefix:  prefix,
+		wrapped: wrapped,
+	}
+
+	return
+}
+
+type prefixBucket struct {
+	prefix  string
+	wrapped Bucket
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+func (b *prefixBucket) wrappedName(n string) string {
+	return b.prefix + n
+}
+
+func (b *prefixBucket) localName(n string) string {
+	return strings.TrimPrefix(n, b.prefix)
+}
+
+// Return a copy of the supplied object record with the prefix stripped from
+// its name, or nil if o is nil.
+func (b *prefixBucket) localObject(o *Object) (local *Object) {
+	if o == nil {
+		return
+	}
+
+	copied := *o
+	copied.Name = b.localName(o.Name)
+	local = &copied
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *prefixBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *prefixBucket) NewReader(
+	ctx context.Context,
+	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	mReq := *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	rc, err = b.wrapped.NewReader(ctx, &mReq)
+	return
+}
+
+func (b *prefixBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	mReq := *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	o, err = b.wrapped.CreateObject(ctx, &mReq)
+	o = b.localObject(o)
+	return
+}
+
+func (b *prefixBucket) CopyObject(
+	ctx context.Context,
+	req *CopyObjectRequest) (o *Object, err error) {
+	mReq := *req
+	mReq.SrcName = b.wrappedName(req.SrcName)
+	mReq.DstName = b.wrappedName(req.DstName)
+
+	o, err = b.wrapped.CopyObject(ctx, &mReq)
+	o = b.localObject(o)
+	return
+}
+
+func (b *prefixBucket) MoveObject(
+	ctx context.Context,
+	req *MoveObjectRequest) (o *Object, err error) {
+	mReq := *req
+	mReq.SrcName = b.wrappedName(req.SrcName)
+	mReq.DstName = b.wrappedName(req.DstName)
+
+	o, err = b.wrapped.MoveObject(ctx, &mReq)
+	o = b.localObject(o)
+	return
+}
+
+func (b *prefixBucket) ComposeObjects(
+	ctx context.Context,
+	req *ComposeObjectsRequest) (o *Object, err error) {
+	mReq := *req
+	mReq.DstName = b.wrappedName(req.DstName)
+
+	mReq.Sources = make([]ComposeSource, len(req.Sources))
+	for i, s := range req.Sources {
+		s.Name = b.wrappedName(s.Name)
+		mReq.Sources[i] = s
+	}
+
+	o, err = b.wrapped.ComposeObjects(ctx, &mReq)
+	o = b.localObject(o)
+	return
+}
+
+func (b *prefixBucket) StatObject(
+	ctx context.Context,
+	req *StatObjectRequest) (o *Object, err error) {
+	mReq := *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	o, err = b.wrapped.StatObject(ctx, &mReq)
+	o = b.localObject(o)
+	return
+}
+
+func (b *prefixBucket) ListObjects(
+	ctx context.Context,
+	req *ListObjectsRequest) (l *Listing, err error) {
+	mReq := *req
+	mReq.Prefix = b.wrappedName(req.Prefix)
+
+	if req.StartOffset != "" {
+		mReq.StartOffset = b.wrappedName(req.StartOffset)
+	}
+
+	if req.EndOffset != "" {
+		mReq.EndOffset = b.wrappedName(req.EndOffset)
+	}
+
+	// The glob must match the wrapped name in its entirety, so have it match
+	// our prefix literally.
+	if req.MatchGlob != "" {
+		mReq.MatchGlob = escapeGlob(b.prefix) + req.MatchGlob
+	}
+
+	l, err = b.wrapped.ListObjects(ctx, &mReq)
+	if err != nil {
+		return
+	}
+
+	// Strip the prefix from everything we hand back, taking care not to modify
+	// the wrapped bucket's result in place.
+	local := &Listing{
+		ContinuationToken: l.ContinuationToken,
+	}
+
+	for _, o := range l.Objects {
+		local.Objects = append(local.Objects, b.localObject(o))
+	}
+
+	for _, r := range l.CollapsedRuns {
+		local.CollapsedRuns = append(local.CollapsedRuns, b.localName(r))
+	}
+
+	l = local
+	return
+}
+
+func (b *prefixBucket) UpdateObject(
+	ctx context.Context,
+	req *UpdateObjectRequest) (o *Object, err error) {
+	mReq := *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	o, err = b.wrapped.UpdateObject(ctx, &mReq)
+	o = b.localObject(o)
+	return
+}
+
+func (b *prefixBucket) DeleteObject(
+	ctx context.Context,
+	req *DeleteObjectRequest) (err error) {
+	mReq := *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	err = b.wrapped.DeleteObject(ctx, &mReq)
+	return
+}
+
+func (b *prefixBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	mReq := *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	o, err = b.wrapped.RestoreObject(ctx, &mReq)
+	o = b.localObject(o)
+	return
+}
+
+func (b *prefixBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	mReq := *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	signed, err = b.wrapped.SignedURL(ctx, &mReq)
+	return
+}
+
+func (b *prefixBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	mReq := *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	rules, err = b.wrapped.ListObjectACLs(ctx, &mReq)
+	return
+}
+
+func (b *prefixBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	mReq := *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	rule, err = b.wrapped.UpdateObjectACL(ctx, &mReq)
+	return
+}
+
+func (b *prefixBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	mReq := *req
+	mReq.Name = b.wrappedName(req.Name)
+
+	err = b.wrapped.DeleteObjectACL(ctx, &mReq)
+	return
+}
+
+func (b *prefixBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	mReq := *req
+	mReq.SrcName = b.wrappedName(req.SrcName)
+
+	// Names in other buckets are not subject to the prefix.
+	local := req.DstBucket == "" || req.DstBucket == b.Name()
+	if local {
+		mReq.DstName = b.wrappedName(req.DstName)
+	}
+
+	o, err = b.wrapped.RewriteObject(ctx, &mReq)
+	if local {
+		o = b.localObject(o)
+	}
+
+	return
+}
+
+func (b *prefixBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	mReq := &BatchRequest{
+		Ops: make([]BatchOp, len(req.Ops)),
+	}
+
+	for i, op := range req.Ops {
+		if op.Stat != nil {
+			r := *op.Stat
+			r.Name = b.wrappedName(r.Name)
+			mReq.Ops[i].Stat = &r
+		}
+
+		if op.Update != nil {
+			r := *op.Update
+			r.Name = b.wrappedName(r.Name)
+			mReq.Ops[i].Update = &r
+		}
+
+		if op.Delete != nil {
+			r := *op.Delete
+			r.Name = b.wrappedName(r.Name)
+			mReq.Ops[i].Delete = &r
+		}
+	}
+
+	results, err = b.wrapped.Batch(ctx, mReq)
+	for i := range results {
+		results[i].Object = b.localObject(results[i].Object)
+	}
+
+	return
+}
@@ -0,0 +1,214 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestPrefixBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type PrefixBucketTest struct {
+	ctx     context.Context
+	prefix  string
+	wrapped gcs.Bucket
+	bucket  gcs.Bucket
+}
+
+var _ SetUpInterface = &PrefixBucketTest{}
+
+func init() { RegisterTestSuite(&PrefixBucketTest{}) }
+
+func (t *PrefixBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.prefix = "foo_"
+	t.wrapped = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	t.bucket = gcs.NewPrefixBucket(t.wrapped, t.prefix)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *PrefixBucketTest) Name() {
+	ExpectEq(t.wrapped.Name(), t.bucket.Name())
+}
+
+func (t *PrefixBucketTest) CreateAndRead() {
+	o, err := gcsutil.CreateObject(t.ctx, t.bucket, "bar", []byte("taco"))
+	AssertEq(nil, err)
+	ExpectEq("bar", o.Name)
+
+	// Reading through the prefix bucket.
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "bar")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	// Reading through the wrapped bucket.
+	contents, err = gcsutil.ReadObject(t.ctx, t.wrapped, t.prefix+"bar")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+}
+
+func (t *PrefixBucketTest) StatObject() {
+	_, err := gcsutil.CreateObject(t.ctx, t.wrapped, t.prefix+"bar", []byte{})
+	AssertEq(nil, err)
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	AssertEq(nil, err)
+	ExpectEq("bar", o.Name)
+
+	// Objects outside the prefix are invisible.
+	_, err = gcsutil.CreateObject(t.ctx, t.wrapped, "baz", []byte{})
+	AssertEq(nil, err)
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "baz"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *PrefixBucketTest) CopyAndCompose() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "a", []byte("taco"))
+	AssertEq(nil, err)
+
+	o, err := t.bucket.CopyObject(
+		t.ctx,
+		&gcs.CopyObjectRequest{SrcName: "a", DstName: "b"})
+
+	AssertEq(nil, err)
+	ExpectEq("b", o.Name)
+
+	o, err = t.bucket.ComposeObjects(
+		t.ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName: "c",
+			Sources: []gcs.ComposeSource{{Name: "a"}, {Name: "b"}},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq("c", o.Name)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.wrapped, t.prefix+"c")
+	AssertEq(nil, err)
+	ExpectEq("tacotaco", string(contents))
+}
+
+func (t *PrefixBucketTest) ListObjects() {
+	// Create some objects in the wrapped bucket, some within the prefix and
+	// some not.
+	err := gcsutil.CreateEmptyObjects(
+		t.ctx,
+		t.wrapped,
+		[]string{
+			"a",
+			t.prefix + "a",
+			t.prefix + "b/0",
+			t.prefix + "b/1",
+			t.prefix + "c",
+			"zzz",
+		})
+
+	AssertEq(nil, err)
+
+	// List with a delimiter.
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{Delimiter: "/"})
+
+	AssertEq(nil, err)
+	AssertEq("", listing.ContinuationToken)
+	ExpectThat(listing.CollapsedRuns, ElementsAre("b/"))
+
+	var names []string
+	for _, o := range listing.Objects {
+		names = append(names, o.Name)
+	}
+
+	ExpectThat(names, ElementsAre("a", "c"))
+
+	// List within a sub-prefix.
+	listing, err = t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{Prefix: "b/"})
+
+	AssertEq(nil, err)
+	AssertEq(2, len(listing.Objects))
+	ExpectEq("b/0", listing.Objects[0].Name)
+	ExpectEq("b/1", listing.Objects[1].Name)
+}
+
+func (t *PrefixBucketTest) ListObjects_OffsetsAndGlob() {
+	err := gcsutil.CreateEmptyObjects(
+		t.ctx,
+		t.wrapped,
+		[]string{
+			"a.txt",
+			t.prefix + "a.txt",
+			t.prefix + "b.json",
+			t.prefix + "c.txt",
+			t.prefix + "d.txt",
+			"zzz.txt",
+		})
+
+	AssertEq(nil, err)
+
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{
+			StartOffset: "b",
+			EndOffset:   "d",
+			MatchGlob:   "*.txt",
+		})
+
+	AssertEq(nil, err)
+	AssertEq("", listing.ContinuationToken)
+	AssertEq(1, len(listing.Objects))
+	ExpectEq("c.txt", listing.Objects[0].Name)
+}
+
+func (t *PrefixBucketTest) UpdateAndDelete() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "bar", []byte{})
+	AssertEq(nil, err)
+
+	contentType := "text/plain"
+	o, err := t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{Name: "bar", ContentType: &contentType})
+
+	AssertEq(nil, err)
+	ExpectEq("bar", o.Name)
+	ExpectEq("text/plain", o.ContentType)
+
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "bar"})
+	AssertEq(nil, err)
+
+	_, err = t.wrapped.StatObject(
+		t.ctx,
+		&gcs.StatObjectRequest{Name: t.prefix + "bar"})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"strings"
+
+	"github.com/jacobsa/gcloud/httputil"
+)
+
+// Encode an object name for use in a URL path. Each slash-separated part is
+// encoded as a path segment, and the slashes are left alone so that the URL
+// looks like the object name.
+func encodeObjectNamePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = httputil.EncodePathSegment(s)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// Return the URL from which anyone may download the named object without
+// credentials, provided that it is publicly readable, for example because it
+// was created with PredefinedACLPublicRead. See here for more information:
+//
+//     https://cloud.google.com/storage/docs/access-public-data
+//
+func PublicURL(bucketName string, objectName string) string {
+	return "https://storage.googleapis.com/" +
+		httputil.EncodePathSegment(bucketName) + "/" +
+		encodeObjectNamePath(objectName)
+}
+
+// Return the URL from which a user signed in to a Google account in a web
+// browser may download the named object, if that account is allowed to read
+// it. See here for more information:
+//
+//     https://cloud.google.com/storage/docs/request-endpoints#cookieauth
+//
+func AuthenticatedURL(bucketName string, objectName string) string {
+	return "https://storage.cloud.google.com/" +
+		httputil.EncodePathSegment(bucketName) + "/" +
+		encodeObjectNamePath(objectName)
+}
+
+// Return the URL of the named object within a static website served from a
+// bucket named after a domain, with a CNAME record for that domain pointing at
+// c.storage.googleapis.com. GCS serves such sites over plain HTTP only. See
+// here for more information:
+//
+//     https://cloud.google.com/storage/docs/hosting-static-website-http
+//
+func WebsiteURL(bucketName string, objectName string) string {
+	return "http://" + bucketName + "/" + encodeObjectNamePath(objectName)
+}
@@ -0,0 +1,58 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"testing"
+
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestPublicURL(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type PublicURLTest struct {
+}
+
+func init() { RegisterTestSuite(&PublicURLTest{}) }
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *PublicURLTest) PublicURL() {
+	ExpectEq(
+		"https://storage.googleapis.com/some-bucket/foo/bar%20baz%3F%23.txt",
+		PublicURL("some-bucket", "foo/bar baz?#.txt"))
+}
+
+func (t *PublicURLTest) AuthenticatedURL() {
+	ExpectEq(
+		"https://storage.cloud.google.com/some-bucket/foo/%E2%98%83%25",
+		AuthenticatedURL("some-bucket", "foo/☃%"))
+}
+
+func (t *PublicURLTest) WebsiteURL() {
+	ExpectEq(
+		"http://www.example.com/index.html",
+		WebsiteURL("www.example.com", "index.html"))
+
+	ExpectEq(
+		"http://www.example.com/a//b%20c/",
+		WebsiteURL("www.example.com", "a//b c/"))
+}
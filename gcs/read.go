@@ -15,6 +15,8 @@
 package gcs
 
 import (
+	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -31,6 +33,16 @@ import (
 func (b *bucket) NewReader(
 	ctx context.Context,
 	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	// We can only verify checksums for the full contents.
+	if req.VerifyChecksums && req.Range != nil {
+		err = errors.New("VerifyChecksums may not be combined with Range")
+		return
+	}
+
 	// Construct an appropriate URL.
 	//
 	// The documentation (https://goo.gl/9zeA98) is vague about how this is
@@ -43,8 +55,8 @@ func (b *bucket) NewReader(
 	// segment, as defined by RFC 3986.
 	bucketSegment := httputil.EncodePathSegment(b.name)
 	objectSegment := httputil.EncodePathSegment(req.Name)
-	opaque := fmt.Sprintf(
-		"//www.googleapis.com/download/storage/v1/b/%s/o/%s",
+	path := fmt.Sprintf(
+		"/download/storage/v1/b/%s/o/%s",
 		bucketSegment,
 		objectSegment)
 
@@ -55,13 +67,35 @@ func (b *bucket) NewReader(
 		query.Set("generation", fmt.Sprintf("%d", req.Generation))
 	}
 
-	url := &url.URL{
-		Scheme:   "https",
-		Host:     "www.googleapis.com",
-		Opaque:   opaque,
-		RawQuery: query.Encode(),
+	if req.GenerationPrecondition != nil {
+		query.Set(
+			"ifGenerationMatch",
+			fmt.Sprintf("%d", *req.GenerationPrecondition))
 	}
 
+	if req.MetaGenerationPrecondition != nil {
+		query.Set(
+			"ifMetagenerationMatch",
+			fmt.Sprintf("%d", *req.MetaGenerationPrecondition))
+	}
+
+	b.addCommonParams(query)
+
+	url := makeURL(b.downloadEndpoint, path, query)
+
+	// If we've been asked to watch for stalls, we need to be able to abort the
+	// request when one occurs.
+	cancel := func() {}
+	if req.IdleTimeout > 0 {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	defer func() {
+		if err != nil {
+			cancel()
+		}
+	}()
+
 	// Create an HTTP request.
 	httpReq, err := httputil.NewRequest(ctx, "GET", url, nil, 0, b.userAgent)
 	if err != nil {
@@ -69,6 +103,12 @@ func (b *bucket) NewReader(
 		return
 	}
 
+	// Ask for compressed contents, if appropriate. Setting the header ourselves
+	// stops the HTTP client from transparently decompressing the response.
+	if req.ReadCompressed {
+		httpReq.Header.Set("Accept-Encoding", "gzip")
+	}
+
 	// Set a Range header, if appropriate.
 	var bodyLimit int64
 	if req.Range != nil {
@@ -91,13 +131,18 @@ func (b *bucket) NewReader(
 	}()
 
 	// Check for HTTP error statuses.
-	if err = googleapi.CheckResponse(httpRes); err != nil {
+	if err = checkResponse(httpRes); err != nil {
 		if typed, ok := err.(*googleapi.Error); ok {
 			// Special case: handle not found errors.
 			if typed.Code == http.StatusNotFound {
 				err = &NotFoundError{Err: typed}
 			}
 
+			// Special case: handle precondition errors.
+			if typed.Code == http.StatusPreconditionFailed {
+				err = &PreconditionError{Err: typed}
+			}
+
 			// Special case: if the user requested a range and we received HTTP 416
 			// from the server, treat this as an empty body. See makeRangeHeaderValue
 			// for more details.
@@ -105,7 +150,11 @@ func (b *bucket) NewReader(
 				typed.Code == http.StatusRequestedRangeNotSatisfiable {
 				err = nil
 				googleapi.CloseBody(httpRes)
+				cancel()
 				rc = readSeekCloser{ioutil.NopCloser(strings.NewReader("")),nil}
+				if req.ContentEncodingFunc != nil {
+					req.ContentEncodingFunc("")
+				}
 			}
 		}
 
@@ -130,6 +179,80 @@ func (b *bucket) NewReader(
 		rc = readSeekCloser{newLimitReadCloser(rc, bodyLimit), nil}
 	}
 
+	// Verify checksums if requested.
+	if req.VerifyChecksums {
+		var crc32c *uint32
+		var md5Sum *[md5.Size]byte
+
+		crc32c, md5Sum, err = parseGoogHash(httpRes)
+		if err != nil {
+			err = fmt.Errorf("parseGoogHash: %v", err)
+			return
+		}
+
+		rc = newVerifyingReader(rc, crc32c, md5Sum)
+	}
+
+	// Report progress and watch for stalls if requested.
+	if req.ProgressFunc != nil || req.IdleTimeout > 0 {
+		rc = newWatchingReader(rc, req.ProgressFunc, req.IdleTimeout, cancel)
+	}
+
+	// Report the encoding if requested. If the HTTP package transparently
+	// decompressed the body, it has removed the Content-Encoding header.
+	if req.ContentEncodingFunc != nil {
+		req.ContentEncodingFunc(httpRes.Header.Get("Content-Encoding"))
+	}
+
+	return
+}
+
+// Extract the checksums from the X-Goog-Hash header(s) of an object download
+// response, which look like this:
+//
+//     X-Goog-Hash: crc32c=n03x6A==,md5=Ojk9c3dhfxgoKVVHYwFbHQ==
+//
+// The MD5 hash is missing for composite objects. If the HTTP package
+// transparently decompressed the body, the checksums (which are for the stored
+// bytes) don't apply and both results are nil.
+func parseGoogHash(
+	httpRes *http.Response) (crc32c *uint32, md5Sum *[md5.Size]byte, err error) {
+	if httpRes.Uncompressed {
+		return
+	}
+
+	for _, header := range httpRes.Header["X-Goog-Hash"] {
+		for _, elem := range strings.Split(header, ",") {
+			kv := strings.SplitN(strings.TrimSpace(elem), "=", 2)
+			if len(kv) != 2 {
+				err = fmt.Errorf("Malformed X-Goog-Hash element: %q", elem)
+				return
+			}
+
+			switch kv[0] {
+			case "crc32c":
+				var v uint32
+				if v, err = toCRC32C(kv[1]); err != nil {
+					err = fmt.Errorf("Decoding crc32c: %v", err)
+					return
+				}
+
+				crc32c = &v
+
+			case "md5":
+				if md5Sum, err = toMD5(kv[1]); err != nil {
+					err = fmt.Errorf("Decoding md5: %v", err)
+					return
+				}
+			}
+		}
+	}
+
+	if crc32c == nil {
+		err = errors.New("No crc32c value in X-Goog-Hash header")
+		return
+	}
+
 	return
 }
 
@@ -0,0 +1,184 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// Create a bucket that passes reads through to the wrapped bucket, but refuses
+// all mutations with an error of type *ReadOnlyError without contacting GCS.
+// This is useful as a safeguard when working with buckets that must not be
+// modified.
+//
+// SignedURL is permitted only for the GET and HEAD methods, since URLs for
+// other methods would grant write access. RewriteObject is permitted only when
+// the destination is another bucket.
+func NewReadOnlyBucket(wrapped Bucket) (b Bucket) {
+	b = &readOnlyBucket{
+		wrapped: wrapped,
+	}
+
+	return
+}
+
+type readOnlyBucket struct {
+	wrapped Bucket
+}
+
+func readOnlyError(format string, v ...interface{}) error {
+	return &ReadOnlyError{
+		Err: fmt.Errorf(format, v...),
+	}
+}
+
+func (b *readOnlyBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *readOnlyBucket) NewReader(
+	ctx context.Context,
+	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	rc, err = b.wrapped.NewReader(ctx, req)
+	return
+}
+
+func (b *readOnlyBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	err = readOnlyError("CreateObject(%q)", req.Name)
+	return
+}
+
+func (b *readOnlyBucket) CopyObject(
+	ctx context.Context,
+	req *CopyObjectRequest) (o *Object, err error) {
+	err = readOnlyError("CopyObject(%q, %q)", req.SrcName, req.DstName)
+	return
+}
+
+func (b *readOnlyBucket) MoveObject(
+	ctx context.Context,
+	req *MoveObjectRequest) (o *Object, err error) {
+	err = readOnlyError("MoveObject(%q, %q)", req.SrcName, req.DstName)
+	return
+}
+
+func (b *readOnlyBucket) ComposeObjects(
+	ctx context.Context,
+	req *ComposeObjectsRequest) (o *Object, err error) {
+	err = readOnlyError("ComposeObjects(%q)", req.DstName)
+	return
+}
+
+func (b *readOnlyBucket) StatObject(
+	ctx context.Context,
+	req *StatObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *readOnlyBucket) ListObjects(
+	ctx context.Context,
+	req *ListObjectsRequest) (listing *Listing, err error) {
+	listing, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *readOnlyBucket) UpdateObject(
+	ctx context.Context,
+	req *UpdateObjectRequest) (o *Object, err error) {
+	err = readOnlyError("UpdateObject(%q)", req.Name)
+	return
+}
+
+func (b *readOnlyBucket) DeleteObject(
+	ctx context.Context,
+	req *DeleteObjectRequest) (err error) {
+	err = readOnlyError("DeleteObject(%q)", req.Name)
+	return
+}
+
+func (b *readOnlyBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	err = readOnlyError("RestoreObject(%q)", req.Name)
+	return
+}
+
+func (b *readOnlyBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	switch req.Method {
+	case "GET", "HEAD":
+	default:
+		err = readOnlyError("SignedURL(%q, %q)", req.Name, req.Method)
+		return
+	}
+
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
+
+func (b *readOnlyBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *readOnlyBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	err = readOnlyError("UpdateObjectACL(%q, %q)", req.Name, req.Entity)
+	return
+}
+
+func (b *readOnlyBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	err = readOnlyError("DeleteObjectACL(%q, %q)", req.Name, req.Entity)
+	return
+}
+
+func (b *readOnlyBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	// Rewriting into another bucket only reads from this one.
+	if req.DstBucket == "" || req.DstBucket == b.Name() {
+		err = readOnlyError("RewriteObject(%q, %q)", req.SrcName, req.DstName)
+		return
+	}
+
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	return
+}
+
+func (b *readOnlyBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	// Batches consisting only of stats are fine.
+	for _, op := range req.Ops {
+		if op.Update != nil || op.Delete != nil {
+			err = readOnlyError("Batch(%d ops)", len(req.Ops))
+			return
+		}
+	}
+
+	results, err = b.wrapped.Batch(ctx, req)
+	return
+}
@@ -0,0 +1,133 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestReadOnlyBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ReadOnlyBucketTest struct {
+	ctx     context.Context
+	wrapped gcs.Bucket
+	bucket  gcs.Bucket
+}
+
+var _ SetUpInterface = &ReadOnlyBucketTest{}
+
+func init() { RegisterTestSuite(&ReadOnlyBucketTest{}) }
+
+func (t *ReadOnlyBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.wrapped = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	t.bucket = gcs.NewReadOnlyBucket(t.wrapped)
+
+	// Create an object to work with.
+	_, err := gcsutil.CreateObject(t.ctx, t.wrapped, "foo", []byte("taco"))
+	AssertEq(nil, err)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ReadOnlyBucketTest) ReadsSucceed() {
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+
+	o, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq("foo", o.Name)
+
+	listing, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+	ExpectEq(1, len(listing.Objects))
+}
+
+func (t *ReadOnlyBucketTest) MutationsFail() {
+	var err error
+	contentType := "text/plain"
+
+	_, err = t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "bar",
+			Contents: strings.NewReader(""),
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.ReadOnlyError{}))
+
+	_, err = t.bucket.CopyObject(
+		t.ctx,
+		&gcs.CopyObjectRequest{SrcName: "foo", DstName: "bar"})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.ReadOnlyError{}))
+
+	_, err = t.bucket.MoveObject(
+		t.ctx,
+		&gcs.MoveObjectRequest{SrcName: "foo", DstName: "bar"})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.ReadOnlyError{}))
+
+	_, err = t.bucket.ComposeObjects(
+		t.ctx,
+		&gcs.ComposeObjectsRequest{
+			DstName: "bar",
+			Sources: []gcs.ComposeSource{{Name: "foo"}},
+		})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.ReadOnlyError{}))
+
+	_, err = t.bucket.UpdateObject(
+		t.ctx,
+		&gcs.UpdateObjectRequest{Name: "foo", ContentType: &contentType})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.ReadOnlyError{}))
+
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.ReadOnlyError{}))
+
+	_, err = t.bucket.SignedURL(
+		t.ctx,
+		&gcs.SignedURLRequest{Name: "foo", Method: "PUT"})
+
+	ExpectThat(err, HasSameTypeAs(&gcs.ReadOnlyError{}))
+
+	// Nothing should have changed.
+	listing, err := t.wrapped.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+	AssertEq(1, len(listing.Objects))
+
+	o := listing.Objects[0]
+	ExpectEq("foo", o.Name)
+	ExpectEq(1, o.MetaGeneration)
+	ExpectEq("", o.ContentType)
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestRead(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ReadTest struct {
+	ctx       context.Context
+	transport headerTransport
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &ReadTest{}
+
+func init() { RegisterTestSuite(&ReadTest{}) }
+
+func (t *ReadTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.transport.status = http.StatusOK
+	t.transport.header = make(http.Header)
+
+	t.bucket = newBucket(
+		&http.Client{Transport: &t.transport},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ReadTest) DefaultEncoding() {
+	var encoding string
+	rc, err := t.bucket.NewReader(
+		t.ctx,
+		&ReadObjectRequest{
+			Name:                "foo",
+			ContentEncodingFunc: func(e string) { encoding = e },
+		})
+
+	AssertEq(nil, err)
+	rc.Close()
+
+	// We should have left Accept-Encoding to the HTTP client.
+	AssertEq(1, len(t.transport.requests))
+	ExpectEq("", t.transport.requests[0].Header.Get("Accept-Encoding"))
+	ExpectEq("", encoding)
+}
+
+func (t *ReadTest) ReadCompressed() {
+	t.transport.header.Set("Content-Encoding", "gzip")
+
+	var encoding string
+	rc, err := t.bucket.NewReader(
+		t.ctx,
+		&ReadObjectRequest{
+			Name:                "foo",
+			ReadCompressed:      true,
+			ContentEncodingFunc: func(e string) { encoding = e },
+		})
+
+	AssertEq(nil, err)
+	rc.Close()
+
+	AssertEq(1, len(t.transport.requests))
+	ExpectEq("gzip", t.transport.requests[0].Header.Get("Accept-Encoding"))
+	ExpectEq("gzip", encoding)
+}
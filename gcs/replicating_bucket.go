@@ -0,0 +1,419 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// A queue on which a bucket created with NewReplicatingBucket schedules
+// replication work.
+type ReplicationQueue interface {
+	// Arrange for f to be called soon, typically on another goroutine. Must
+	// not block for long.
+	Enqueue(f func())
+}
+
+// Return a queue that runs each function on its own goroutine, with at most
+// the given number running at once.
+func NewReplicationQueue(parallelism int) ReplicationQueue {
+	return &goroutineQueue{
+		sem: make(chan struct{}, parallelism),
+	}
+}
+
+type goroutineQueue struct {
+	sem chan struct{}
+}
+
+func (q *goroutineQueue) Enqueue(f func()) {
+	go func() {
+		q.sem <- struct{}{}
+		defer func() { <-q.sem }()
+
+		f()
+	}()
+}
+
+// Options for NewReplicatingBucket.
+type ReplicationConfig struct {
+	// The queue on which to run replication. If nil, one created with
+	// NewReplicationQueue(8) is used.
+	Queue ReplicationQueue
+
+	// If non-nil, called when replicating an object to a secondary bucket
+	// fails. The object is not retried until it next changes, so this is the
+	// place to log, alert, or reschedule.
+	OnFailure func(secondary Bucket, name string, err error)
+}
+
+// Create a bucket that sends reads to the primary bucket, and mutations to the
+// primary followed by asynchronous replication of the affected objects to each
+// of the secondary buckets. The secondaries may be in other projects or
+// regions, providing redundancy managed in application code.
+//
+// Replication copies state rather than replaying operations: to replicate an
+// object, its current contents and metadata are read from the primary and
+// written to the secondary, or it is deleted from the secondary if it no
+// longer exists in the primary. This makes replication idempotent and
+// insensitive to ordering. Further changes to an object while it's being
+// replicated cause it to be replicated again afterward, so each secondary
+// converges on the primary's latest state.
+//
+// Only changes made through this bucket are replicated, and only once the
+// primary has reported success. ACLs, holds, and KMS keys are not
+// replicated, and RewriteObject into another bucket is not replicated.
+func NewReplicatingBucket(
+	primary Bucket,
+	cfg ReplicationConfig,
+	secondaries ...Bucket) (b Bucket) {
+	if cfg.Queue == nil {
+		cfg.Queue = NewReplicationQueue(8)
+	}
+
+	b = &replicatingBucket{
+		primary:     primary,
+		secondaries: secondaries,
+		cfg:         cfg,
+		pending:     make(map[replicationKey]bool),
+	}
+
+	return
+}
+
+type replicatingBucket struct {
+	primary     Bucket
+	secondaries []Bucket
+	cfg         ReplicationConfig
+
+	mu sync.Mutex
+
+	// Objects that are queued or being replicated. A value of true means that
+	// the object changed again after replication began, and must be
+	// replicated again when it finishes.
+	//
+	// GUARDED_BY(mu)
+	pending map[replicationKey]bool
+}
+
+type replicationKey struct {
+	secondary int
+	name      string
+}
+
+////////////////////////////////////////////////////////////////////////
+// Replication
+////////////////////////////////////////////////////////////////////////
+
+// Schedule replication of the named objects to every secondary.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *replicatingBucket) schedule(names ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := range b.secondaries {
+		for _, name := range names {
+			k := replicationKey{i, name}
+
+			// If already in progress, make sure it happens again.
+			if _, ok := b.pending[k]; ok {
+				b.pending[k] = true
+				continue
+			}
+
+			b.pending[k] = false
+			b.cfg.Queue.Enqueue(func() { b.run(k) })
+		}
+	}
+}
+
+// Replicate an object until there are no further changes to it.
+//
+// LOCKS_EXCLUDED(b.mu)
+func (b *replicatingBucket) run(k replicationKey) {
+	secondary := b.secondaries[k.secondary]
+
+	for {
+		err := replicateObject(context.Background(), b.primary, secondary, k.name)
+
+		b.mu.Lock()
+		again := b.pending[k]
+		if again {
+			b.pending[k] = false
+		} else {
+			delete(b.pending, k)
+		}
+		b.mu.Unlock()
+
+		// A failure that may have been caused by a concurrent change to the
+		// object will be superseded by the next attempt.
+		if again {
+			continue
+		}
+
+		if err != nil && b.cfg.OnFailure != nil {
+			b.cfg.OnFailure(secondary, k.name, err)
+		}
+
+		return
+	}
+}
+
+// Bring the named object in the destination bucket up to date with the
+// source bucket.
+func replicateObject(
+	ctx context.Context,
+	src Bucket,
+	dst Bucket,
+	name string) (err error) {
+	o, err := src.StatObject(ctx, &StatObjectRequest{Name: name})
+
+	// If the object is gone, delete it from the destination too.
+	if _, ok := err.(*NotFoundError); ok {
+		err = dst.DeleteObject(ctx, &DeleteObjectRequest{Name: name})
+		if _, ok := err.(*NotFoundError); ok {
+			err = nil
+		}
+
+		if err != nil {
+			err = fmt.Errorf("DeleteObject: %v", err)
+			return
+		}
+
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("StatObject: %v", err)
+		return
+	}
+
+	// Otherwise copy it, as stored.
+	rc, err := src.NewReader(
+		ctx,
+		&ReadObjectRequest{
+			Name:           name,
+			Generation:     o.Generation,
+			ReadCompressed: true,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("NewReader: %v", err)
+		return
+	}
+
+	defer rc.Close()
+
+	crc32c := o.CRC32C
+	_, err = dst.CreateObject(
+		ctx,
+		&CreateObjectRequest{
+			Name:            name,
+			ContentType:     o.ContentType,
+			ContentLanguage: o.ContentLanguage,
+			ContentEncoding: o.ContentEncoding,
+			CacheControl:    o.CacheControl,
+			Metadata:        o.Metadata,
+			CustomTime:      o.CustomTime,
+			Contents:        rc,
+			CRC32C:          &crc32c,
+			MD5:             o.MD5,
+		})
+
+	if err != nil {
+		err = fmt.Errorf("CreateObject: %v", err)
+		return
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *replicatingBucket) Name() string {
+	return b.primary.Name()
+}
+
+func (b *replicatingBucket) NewReader(
+	ctx context.Context,
+	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	rc, err = b.primary.NewReader(ctx, req)
+	return
+}
+
+func (b *replicatingBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	o, err = b.primary.CreateObject(ctx, req)
+	if err == nil {
+		b.schedule(req.Name)
+	}
+
+	return
+}
+
+func (b *replicatingBucket) CopyObject(
+	ctx context.Context,
+	req *CopyObjectRequest) (o *Object, err error) {
+	o, err = b.primary.CopyObject(ctx, req)
+	if err == nil {
+		b.schedule(req.DstName)
+	}
+
+	return
+}
+
+func (b *replicatingBucket) MoveObject(
+	ctx context.Context,
+	req *MoveObjectRequest) (o *Object, err error) {
+	o, err = b.primary.MoveObject(ctx, req)
+	if err == nil {
+		b.schedule(req.SrcName, req.DstName)
+	}
+
+	return
+}
+
+func (b *replicatingBucket) ComposeObjects(
+	ctx context.Context,
+	req *ComposeObjectsRequest) (o *Object, err error) {
+	o, err = b.primary.ComposeObjects(ctx, req)
+	if err == nil {
+		b.schedule(req.DstName)
+	}
+
+	return
+}
+
+func (b *replicatingBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	o, err = b.primary.RewriteObject(ctx, req)
+
+	// Rewrites into other buckets don't concern us.
+	if err == nil && (req.DstBucket == "" || req.DstBucket == b.Name()) {
+		b.schedule(req.DstName)
+	}
+
+	return
+}
+
+func (b *replicatingBucket) StatObject(
+	ctx context.Context,
+	req *StatObjectRequest) (o *Object, err error) {
+	o, err = b.primary.StatObject(ctx, req)
+	return
+}
+
+func (b *replicatingBucket) ListObjects(
+	ctx context.Context,
+	req *ListObjectsRequest) (listing *Listing, err error) {
+	listing, err = b.primary.ListObjects(ctx, req)
+	return
+}
+
+func (b *replicatingBucket) UpdateObject(
+	ctx context.Context,
+	req *UpdateObjectRequest) (o *Object, err error) {
+	o, err = b.primary.UpdateObject(ctx, req)
+	if err == nil {
+		b.schedule(req.Name)
+	}
+
+	return
+}
+
+func (b *replicatingBucket) DeleteObject(
+	ctx context.Context,
+	req *DeleteObjectRequest) (err error) {
+	err = b.primary.DeleteObject(ctx, req)
+	if err == nil {
+		b.schedule(req.Name)
+	}
+
+	return
+}
+
+func (b *replicatingBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	o, err = b.primary.RestoreObject(ctx, req)
+	if err == nil {
+		b.schedule(req.Name)
+	}
+
+	return
+}
+
+func (b *replicatingBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	results, err = b.primary.Batch(ctx, req)
+	if err != nil {
+		return
+	}
+
+	var changed []string
+	for i, op := range req.Ops {
+		if i >= len(results) || results[i].Err != nil {
+			continue
+		}
+
+		switch {
+		case op.Update != nil:
+			changed = append(changed, op.Update.Name)
+
+		case op.Delete != nil:
+			changed = append(changed, op.Delete.Name)
+		}
+	}
+
+	b.schedule(changed...)
+	return
+}
+
+func (b *replicatingBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	rules, err = b.primary.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *replicatingBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	rule, err = b.primary.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *replicatingBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	err = b.primary.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *replicatingBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	signed, err = b.primary.SignedURL(ctx, req)
+	return
+}
@@ -0,0 +1,266 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestReplicatingBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A queue that runs nothing until drained.
+type manualQueue struct {
+	mu sync.Mutex
+	fs []func()
+}
+
+func (q *manualQueue) Enqueue(f func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.fs = append(q.fs, f)
+}
+
+// Run queued functions until there are none left, returning how many ran.
+func (q *manualQueue) drain() (n int) {
+	for {
+		q.mu.Lock()
+		fs := q.fs
+		q.fs = nil
+		q.mu.Unlock()
+
+		if len(fs) == 0 {
+			return
+		}
+
+		for _, f := range fs {
+			f()
+			n++
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type ReplicatingBucketTest struct {
+	ctx         context.Context
+	queue       manualQueue
+	primary     gcs.Bucket
+	secondaries []gcs.Bucket
+	bucket      gcs.Bucket
+
+	failures []string
+}
+
+var _ SetUpInterface = &ReplicatingBucketTest{}
+
+func init() { RegisterTestSuite(&ReplicatingBucketTest{}) }
+
+func (t *ReplicatingBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.primary = gcsfake.NewFakeBucket(timeutil.RealClock(), "primary")
+	t.secondaries = []gcs.Bucket{
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "secondary-0"),
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "secondary-1"),
+	}
+
+	t.bucket = gcs.NewReplicatingBucket(
+		t.primary,
+		gcs.ReplicationConfig{
+			Queue:     &t.queue,
+			OnFailure: t.onFailure,
+		},
+		t.secondaries...)
+}
+
+func (t *ReplicatingBucketTest) onFailure(
+	secondary gcs.Bucket,
+	name string,
+	err error) {
+	t.failures = append(t.failures, secondary.Name()+"/"+name)
+}
+
+// Return the contents of the object in each secondary, or "<missing>".
+func (t *ReplicatingBucketTest) secondaryContents(name string) (contents []string) {
+	for _, b := range t.secondaries {
+		c, err := gcsutil.ReadObject(t.ctx, b, name)
+		if _, ok := err.(*gcs.NotFoundError); ok {
+			contents = append(contents, "<missing>")
+			continue
+		}
+
+		AssertEq(nil, err)
+		contents = append(contents, string(c))
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ReplicatingBucketTest) CreateIsReplicated() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:        "foo",
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"bar": "baz"},
+			Contents:    strings.NewReader("taco"),
+		})
+
+	AssertEq(nil, err)
+
+	// Nothing happens until the queue runs.
+	ExpectThat(t.secondaryContents("foo"), ElementsAre("<missing>", "<missing>"))
+
+	ExpectEq(2, t.queue.drain())
+	ExpectThat(t.secondaryContents("foo"), ElementsAre("taco", "taco"))
+
+	for _, b := range t.secondaries {
+		o, err := b.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+		AssertEq(nil, err)
+		ExpectEq("text/plain", o.ContentType)
+		ExpectEq("baz", o.Metadata["bar"])
+	}
+
+	ExpectThat(t.failures, ElementsAre())
+}
+
+func (t *ReplicatingBucketTest) ReadsUsePrimary() {
+	_, err := gcsutil.CreateObject(t.ctx, t.primary, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+	ExpectEq("primary", t.bucket.Name())
+	ExpectEq(0, t.queue.drain())
+}
+
+func (t *ReplicatingBucketTest) DeleteAndMove() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "bar", []byte("burrito"))
+	AssertEq(nil, err)
+	t.queue.drain()
+
+	err = t.bucket.DeleteObject(t.ctx, &gcs.DeleteObjectRequest{Name: "bar"})
+	AssertEq(nil, err)
+
+	_, err = t.bucket.MoveObject(
+		t.ctx,
+		&gcs.MoveObjectRequest{SrcName: "foo", DstName: "baz"})
+
+	AssertEq(nil, err)
+	t.queue.drain()
+
+	ExpectThat(t.secondaryContents("foo"), ElementsAre("<missing>", "<missing>"))
+	ExpectThat(t.secondaryContents("bar"), ElementsAre("<missing>", "<missing>"))
+	ExpectThat(t.secondaryContents("baz"), ElementsAre("taco", "taco"))
+	ExpectThat(t.failures, ElementsAre())
+}
+
+func (t *ReplicatingBucketTest) RepeatedChangesAreCoalesced() {
+	for _, s := range []string{"taco", "burrito", "enchilada"} {
+		_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte(s))
+		AssertEq(nil, err)
+	}
+
+	// One task per secondary, each replicating twice to pick up the changes
+	// made after it was scheduled.
+	ExpectEq(2, t.queue.drain())
+	ExpectThat(t.secondaryContents("foo"), ElementsAre("enchilada", "enchilada"))
+}
+
+func (t *ReplicatingBucketTest) Batch() {
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+	_, err = gcsutil.CreateObject(t.ctx, t.bucket, "bar", []byte("burrito"))
+	AssertEq(nil, err)
+	t.queue.drain()
+
+	contentType := "text/plain"
+	_, err = t.bucket.Batch(
+		t.ctx,
+		&gcs.BatchRequest{
+			Ops: []gcs.BatchOp{
+				{Update: &gcs.UpdateObjectRequest{Name: "foo", ContentType: &contentType}},
+				{Delete: &gcs.DeleteObjectRequest{Name: "bar"}},
+			},
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(4, t.queue.drain())
+	ExpectThat(t.secondaryContents("bar"), ElementsAre("<missing>", "<missing>"))
+
+	o, err := t.secondaries[1].StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectEq("text/plain", o.ContentType)
+}
+
+func (t *ReplicatingBucketTest) FailuresAreReported() {
+	t.secondaries[1] = gcs.NewReadOnlyBucket(t.secondaries[1])
+	t.bucket = gcs.NewReplicatingBucket(
+		t.primary,
+		gcs.ReplicationConfig{
+			Queue:     &t.queue,
+			OnFailure: t.onFailure,
+		},
+		t.secondaries...)
+
+	_, err := gcsutil.CreateObject(t.ctx, t.bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	t.queue.drain()
+	ExpectThat(t.secondaryContents("foo"), ElementsAre("taco", "<missing>"))
+	ExpectThat(t.failures, ElementsAre("secondary-1/foo"))
+}
+
+func (t *ReplicatingBucketTest) DefaultQueue() {
+	done := make(chan error, 1)
+	b := gcs.NewReplicatingBucket(
+		t.primary,
+		gcs.ReplicationConfig{
+			OnFailure: func(secondary gcs.Bucket, name string, err error) {
+				done <- err
+			},
+		},
+		gcs.NewReadOnlyBucket(t.secondaries[0]))
+
+	_, err := gcsutil.CreateObject(t.ctx, b, "foo", []byte("taco"))
+	AssertEq(nil, err)
+
+	err = <-done
+	ExpectThat(err, Error(HasSubstr("ReadOnlyError")))
+}
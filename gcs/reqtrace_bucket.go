@@ -24,9 +24,25 @@ import (
 	"golang.org/x/net/context"
 )
 
-// A bucket that uses reqtrace.Trace to annotate calls.
+// A bucket that uses reqtrace.Trace to annotate calls, reporting its spans and
+// those started by the wrapped bucket to Hook if it is non-nil.
 type reqtraceBucket struct {
 	Wrapped Bucket
+	Hook    SpanHook
+}
+
+// Start a span for a call to the wrapped bucket, updating *ctx so that the
+// wrapped bucket's nested spans are reported to the hook too.
+func (b *reqtraceBucket) startSpan(
+	ctx *context.Context,
+	err *error,
+	desc string) (f func()) {
+	if b.Hook != nil {
+		*ctx = withSpanHook(*ctx, b.Hook)
+	}
+
+	f = startSpanWithError(ctx, err, annotateRequestID(*ctx, desc))
+	return
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -119,7 +135,11 @@ func (b *reqtraceBucket) Name() string {
 func (b *reqtraceBucket) MoveObject(
 	ctx context.Context,
 	req *MoveObjectRequest) (o *Object, err error) {
-	return b.Wrapped.MoveObject(ctx, req)
+	desc := fmt.Sprintf("MoveObject: %q -> %q", req.SrcName, req.DstName)
+	defer b.startSpan(&ctx, &err, desc)()
+
+	o, err = b.Wrapped.MoveObject(ctx, req)
+	return
 }
 
 func (b *reqtraceBucket) NewReader(
@@ -128,8 +148,12 @@ func (b *reqtraceBucket) NewReader(
 	var report reqtrace.ReportFunc
 
 	// Start a span.
+	if b.Hook != nil {
+		ctx = withSpanHook(ctx, b.Hook)
+	}
+
 	desc := fmt.Sprintf("Read: %s", sanitizeObjectName(req.Name))
-	ctx, report = reqtrace.StartSpan(ctx, desc)
+	ctx, report = startSpan(ctx, annotateRequestID(ctx, desc))
 
 	// Call the wrapped bucket.
 	rc, err = b.Wrapped.NewReader(ctx, req)
@@ -153,7 +177,7 @@ func (b *reqtraceBucket) CreateObject(
 	ctx context.Context,
 	req *CreateObjectRequest) (o *Object, err error) {
 	desc := fmt.Sprintf("CreateObject: %s", sanitizeObjectName(req.Name))
-	defer reqtrace.StartSpanWithError(&ctx, &err, desc)()
+	defer b.startSpan(&ctx, &err, desc)()
 
 	o, err = b.Wrapped.CreateObject(ctx, req)
 	return
@@ -163,7 +187,7 @@ func (b *reqtraceBucket) CopyObject(
 	ctx context.Context,
 	req *CopyObjectRequest) (o *Object, err error) {
 	desc := fmt.Sprintf("CopyObject: %q -> %q", req.SrcName, req.DstName)
-	defer reqtrace.StartSpanWithError(&ctx, &err, desc)()
+	defer b.startSpan(&ctx, &err, desc)()
 
 	o, err = b.Wrapped.CopyObject(ctx, req)
 	return
@@ -173,7 +197,7 @@ func (b *reqtraceBucket) ComposeObjects(
 	ctx context.Context,
 	req *ComposeObjectsRequest) (o *Object, err error) {
 	desc := fmt.Sprintf("ComposeObjects: -> %q", req.DstName)
-	defer reqtrace.StartSpanWithError(&ctx, &err, desc)()
+	defer b.startSpan(&ctx, &err, desc)()
 
 	o, err = b.Wrapped.ComposeObjects(ctx, req)
 	return
@@ -183,7 +207,7 @@ func (b *reqtraceBucket) StatObject(
 	ctx context.Context,
 	req *StatObjectRequest) (o *Object, err error) {
 	desc := fmt.Sprintf("StatObject: %s", sanitizeObjectName(req.Name))
-	defer reqtrace.StartSpanWithError(&ctx, &err, desc)()
+	defer b.startSpan(&ctx, &err, desc)()
 
 	o, err = b.Wrapped.StatObject(ctx, req)
 	return
@@ -193,7 +217,7 @@ func (b *reqtraceBucket) ListObjects(
 	ctx context.Context,
 	req *ListObjectsRequest) (listing *Listing, err error) {
 	desc := fmt.Sprintf("ListObjects")
-	defer reqtrace.StartSpanWithError(&ctx, &err, desc)()
+	defer b.startSpan(&ctx, &err, desc)()
 
 	listing, err = b.Wrapped.ListObjects(ctx, req)
 	return
@@ -203,7 +227,7 @@ func (b *reqtraceBucket) UpdateObject(
 	ctx context.Context,
 	req *UpdateObjectRequest) (o *Object, err error) {
 	desc := fmt.Sprintf("UpdateObject: %s", sanitizeObjectName(req.Name))
-	defer reqtrace.StartSpanWithError(&ctx, &err, desc)()
+	defer b.startSpan(&ctx, &err, desc)()
 
 	o, err = b.Wrapped.UpdateObject(ctx, req)
 	return
@@ -213,12 +237,82 @@ func (b *reqtraceBucket) DeleteObject(
 	ctx context.Context,
 	req *DeleteObjectRequest) (err error) {
 	desc := fmt.Sprintf("DeleteObject: %s", sanitizeObjectName(req.Name))
-	defer reqtrace.StartSpanWithError(&ctx, &err, desc)()
+	defer b.startSpan(&ctx, &err, desc)()
 
 	err = b.Wrapped.DeleteObject(ctx, req)
 	return
 }
 
+func (b *reqtraceBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	desc := fmt.Sprintf("RestoreObject: %s", sanitizeObjectName(req.Name))
+	defer b.startSpan(&ctx, &err, desc)()
+
+	o, err = b.Wrapped.RestoreObject(ctx, req)
+	return
+}
+
+func (b *reqtraceBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	desc := fmt.Sprintf("SignedURL: %s", sanitizeObjectName(req.Name))
+	defer b.startSpan(&ctx, &err, desc)()
+
+	signed, err = b.Wrapped.SignedURL(ctx, req)
+	return
+}
+
+func (b *reqtraceBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	desc := fmt.Sprintf("ListObjectACLs: %s", sanitizeObjectName(req.Name))
+	defer b.startSpan(&ctx, &err, desc)()
+
+	rules, err = b.Wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *reqtraceBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	desc := fmt.Sprintf("UpdateObjectACL: %s", sanitizeObjectName(req.Name))
+	defer b.startSpan(&ctx, &err, desc)()
+
+	rule, err = b.Wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *reqtraceBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	desc := fmt.Sprintf("DeleteObjectACL: %s", sanitizeObjectName(req.Name))
+	defer b.startSpan(&ctx, &err, desc)()
+
+	err = b.Wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *reqtraceBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	desc := fmt.Sprintf("RewriteObject: %q -> %q", req.SrcName, req.DstName)
+	defer b.startSpan(&ctx, &err, desc)()
+
+	o, err = b.Wrapped.RewriteObject(ctx, req)
+	return
+}
+
+func (b *reqtraceBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	desc := fmt.Sprintf("Batch: %d ops", len(req.Ops))
+	defer b.startSpan(&ctx, &err, desc)()
+
+	results, err = b.Wrapped.Batch(ctx, req)
+	return
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Helpers
 ////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,101 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+)
+
+// The header in which request IDs attached with WithRequestID are sent to GCS,
+// and the gRPC metadata key used for the same purpose.
+const (
+	RequestIDHeader      = "X-Request-Id"
+	requestIDMetadataKey = "x-request-id"
+)
+
+type requestIDKey struct{}
+
+// Return a context that attaches the supplied client-chosen ID to operations
+// performed with it, making it possible to correlate them with the logs of
+// other services. The ID is:
+//
+//  *  Sent to GCS in the RequestIDHeader header of every HTTP request, and in
+//     the corresponding gRPC metadata.
+//
+//  *  Included in the messages of errors returned by GCS.
+//
+//  *  Included in debug logging (ConnConfig.GCSDebugLogger), reqtrace spans,
+//     and the OperationTrace values produced by NewTracingBucket.
+//
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// Return the request ID attached to the context with WithRequestID, or the
+// empty string if none.
+func RequestIDFromContext(ctx context.Context) (id string) {
+	id, _ = ctx.Value(requestIDKey{}).(string)
+	return
+}
+
+// If the context carries a request ID, add it to the supplied description of
+// an operation.
+func annotateRequestID(ctx context.Context, desc string) string {
+	id := RequestIDFromContext(ctx)
+	if id == "" {
+		return desc
+	}
+
+	return fmt.Sprintf("%s [request ID %s]", desc, id)
+}
+
+// Wrap the supplied round tripper in a layer that sets RequestIDHeader on
+// requests whose contexts carry a request ID.
+func newRequestIDRoundTripper(
+	wrapped httputil.CancellableRoundTripper) (rt httputil.CancellableRoundTripper) {
+	rt = &requestIDRoundTripper{
+		wrapped: wrapped,
+	}
+
+	return
+}
+
+type requestIDRoundTripper struct {
+	wrapped httputil.CancellableRoundTripper
+}
+
+func (t *requestIDRoundTripper) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	id := RequestIDFromContext(req.Context())
+	if id == "" {
+		res, err = t.wrapped.RoundTrip(req)
+		return
+	}
+
+	// Round trippers mustn't modify the request they're given.
+	req = req.Clone(req.Context())
+	req.Header.Set(RequestIDHeader, id)
+
+	res, err = t.wrapped.RoundTrip(req)
+	return
+}
+
+func (t *requestIDRoundTripper) CancelRequest(req *http.Request) {
+	t.wrapped.CancelRequest(req)
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestRequestID(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type RequestIDTest struct {
+	ctx       context.Context
+	transport headerTransport
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &RequestIDTest{}
+
+func init() { RegisterTestSuite(&RequestIDTest{}) }
+
+func (t *RequestIDTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.transport.status = http.StatusOK
+	t.transport.header = make(http.Header)
+
+	t.bucket = newBucket(
+		&http.Client{Transport: newRequestIDRoundTripper(&t.transport)},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *RequestIDTest) FromContext() {
+	ExpectEq("", RequestIDFromContext(t.ctx))
+	ExpectEq("taco", RequestIDFromContext(WithRequestID(t.ctx, "taco")))
+}
+
+func (t *RequestIDTest) HeaderSent() {
+	ctx := WithRequestID(t.ctx, "taco")
+	_, err := t.bucket.StatObject(ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	ExpectEq("taco", t.transport.requests[0].Header.Get(RequestIDHeader))
+}
+
+func (t *RequestIDTest) HeaderNotSentByDefault() {
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	_, ok := t.transport.requests[0].Header[RequestIDHeader]
+	ExpectFalse(ok)
+}
+
+func (t *RequestIDTest) IncludedInErrors() {
+	t.transport.status = http.StatusServiceUnavailable
+
+	ctx := WithRequestID(t.ctx, "taco")
+	_, err := t.bucket.StatObject(ctx, &StatObjectRequest{Name: "foo"})
+	ExpectThat(err, Error(HasSubstr("request ID taco")))
+}
+
+func (t *RequestIDTest) TypedErrorsPreserved() {
+	t.transport.status = http.StatusNotFound
+
+	ctx := WithRequestID(t.ctx, "taco")
+	_, err := t.bucket.StatObject(ctx, &StatObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&NotFoundError{}))
+	ExpectThat(err, Error(HasSubstr("request ID taco")))
+}
@@ -18,6 +18,8 @@ import (
 	"crypto/md5"
 	"fmt"
 	"io"
+	"net/url"
+	"time"
 )
 
 // A request to create an object, accepted by Bucket.CreateObject.
@@ -47,7 +49,49 @@ type CreateObjectRequest struct {
 	CacheControl    string
 	Metadata        map[string]string
 
+	// Holds to place on the new object, preventing it from being deleted or
+	// overwritten until they are released with UpdateObject.
+	TemporaryHold  bool
+	EventBasedHold bool
+
+	// If non-zero, the object's custom time, which lifecycle rules may act on
+	// using the daysSinceCustomTime condition.
+	CustomTime time.Time
+
+	// If non-empty, the resource name of the Cloud KMS key with which to
+	// encrypt the object, overriding the bucket's default key (see
+	// Conn.SetBucketDefaultKMSKey). For example:
+	//
+	//     projects/p/locations/l/keyRings/r/cryptoKeys/k
+	//
+	// See here for more information:
+	//
+	//     https://cloud.google.com/storage/docs/encryption/customer-managed-keys
+	//
+	KMSKeyName string
+
+	// If non-empty, a predefined ACL such as PredefinedACLPublicRead to apply
+	// to the new object in place of the bucket's default object ACL. Making an
+	// object public this way is atomic, unlike following its creation with
+	// UpdateObjectACL. Not allowed for buckets with uniform bucket-level access.
+	PredefinedACL string
+
+	// If non-empty, the storage class for the new object, e.g. "NEARLINE",
+	// "COLDLINE", or "ARCHIVE". Otherwise the bucket's default is used. See
+	// here for more information:
+	//
+	//     https://cloud.google.com/storage/docs/storage-classes
+	//
+	StorageClass string
+
 	// A reader from which to obtain the contents of the object. Must be non-nil.
+	//
+	// If Contents is an *os.File for a regular file, or otherwise implements
+	// io.ReaderAt along with a Size() int64 method (as *bytes.Reader and
+	// *io.SectionReader do), the bytes from its current offset to its end are
+	// sent straight from it with ReadAt, leaving its offset unchanged. Nothing
+	// is buffered, ChunkSize is ignored, and an upload interrupted part way
+	// through resumes from wherever GCS got to.
 	Contents io.Reader
 
 	// If non-nil, the object will not be created if the checksum of the received
@@ -58,6 +102,23 @@ type CreateObjectRequest struct {
 	// contents does not match the supplied value.
 	MD5 *[md5.Size]byte
 
+	// If true, the CRC32C and MD5 of the contents are computed as they're read
+	// and compared to the checksums that GCS reports for the new object. On a
+	// mismatch the new generation is deleted and an error of type
+	// *ChecksumMismatchError is returned.
+	//
+	// This is useful when the expected checksums aren't known up front, and
+	// protects against corruption between reading Contents and GCS receiving
+	// it.
+	VerifyChecksums bool
+
+	// If true, CreateObject returns a nil *Object on success rather than a
+	// record for the new object, for writers that have no use for one. The JSON
+	// API then asks GCS to leave everything but the object's name out of its
+	// response, aside from what VerifyChecksums needs. Bucket wrappers that
+	// look at the record must be prepared for it to be missing.
+	DiscardResponse bool
+
 	// If non-nil, the object will be created/overwritten only if the current
 	// generation for the object name is equal to the given value. Zero means the
 	// object does not exist.
@@ -67,6 +128,60 @@ type CreateObjectRequest struct {
 	// meta-generation for the object name is equal to the given value. This is
 	// only meaningful in conjunction with GenerationPrecondition.
 	MetaGenerationPrecondition *int64
+
+	// If non-nil, called periodically while the contents are being sent with
+	// the number of bytes of them sent so far, for displaying progress or
+	// detecting stalls. This may go backwards if part of the contents has to be
+	// resent after a failure. Calls are made from the goroutine sending the
+	// contents and may be frequent, so the function should return quickly.
+	ProgressFunc func(bytesSent int64)
+
+	// Controls how the contents are sent, and hence how much of them is held in
+	// memory at once:
+	//
+	//  *  If zero, ConnConfig.UploadChunkSize is used.
+	//
+	//  *  If positive, the contents are sent in chunks of this many bytes, each
+	//     of which is buffered in memory so that it can be resent if the
+	//     connection fails part way through. Must be a multiple of 256 KiB.
+	//
+	//  *  If negative, the contents are streamed in a single request using
+	//     chunked transfer encoding. Nothing is buffered and the length needn't
+	//     be known in advance, but a failure part way through fails the whole
+	//     upload since the contents can't be replayed.
+	//
+	// This applies to the JSON API. Other Bucket implementations may ignore it.
+	ChunkSize int
+
+	// How to send the object when using the JSON API: UploadTypeResumable,
+	// UploadTypeMultipart, or UploadTypeMedia. The latter two take a single
+	// request, but need contents of known size (see Contents) and must start
+	// over after a failure, and a media upload can't carry metadata other than
+	// a content type and content encoding.
+	//
+	// If empty, a resumable upload is used unless the contents are no larger
+	// than ConnConfig.MultipartUploadThreshold, in which case a media upload
+	// is used if possible and a multipart one otherwise. Other Bucket
+	// implementations ignore this.
+	UploadType string
+
+	// If true, gzip the contents on the way to GCS, saving storage and egress
+	// for compressible data. The object's ContentEncoding is set to "gzip" and
+	// the size of the uncompressed contents recorded in its Metadata under
+	// UncompressedSizeMetadataKey. GCS decompresses the contents for readers
+	// that don't ask for them compressed; see ReadObjectRequest.ReadCompressed.
+	//
+	// The compressed contents are buffered in memory before sending, and
+	// ProgressFunc sees the number of compressed bytes sent. This may not be
+	// combined with ContentEncoding, CRC32C, or MD5.
+	Compress bool
+
+	// Set by NewObjectWriter, whose contents must not be buffered in full.
+	// Bucket wrappers that would otherwise do so, such as the one returned by
+	// NewRetryBucket, instead treat the request as they would one with a
+	// negative ChunkSize. Unlike that, it doesn't affect how the JSON API
+	// sends the contents.
+	streamContents bool
 }
 
 // A request to copy an object to a new name, preserving all metadata.
@@ -83,6 +198,10 @@ type CopyObjectRequest struct {
 	//
 	// This is probably only meaningful in conjunction with SrcGeneration.
 	SrcMetaGenerationPrecondition *int64
+
+	// If non-empty, a predefined ACL such as PredefinedACLBucketOwnerFullControl
+	// to apply to the destination object in place of the source object's ACL.
+	DstPredefinedACL string
 }
 
 // A request to move an object to a new name, preserving all metadata.
@@ -101,6 +220,57 @@ type MoveObjectRequest struct {
 	SrcMetaGenerationPrecondition *int64
 }
 
+// A request to rewrite an object to a new name, possibly in another bucket,
+// with another storage class, or with another encryption key, preserving all
+// metadata. Accepted by Bucket.RewriteObject.
+type RewriteObjectRequest struct {
+	SrcName string
+
+	// The generation of the source object to rewrite, or zero for the latest
+	// generation.
+	SrcGeneration int64
+
+	// If non-nil, the destination object will be created/overwritten only if the
+	// current meta-generation for the source object is equal to the given value.
+	//
+	// This is probably only meaningful in conjunction with SrcGeneration.
+	SrcMetaGenerationPrecondition *int64
+
+	// The bucket in which to create the destination object. If empty, the
+	// bucket in which RewriteObject is called.
+	DstBucket string
+
+	DstName string
+
+	// If non-nil, the destination object will be created/overwritten only if the
+	// current generation for its name is equal to the given value. Zero means
+	// the object does not exist.
+	DstGenerationPrecondition *int64
+
+	// If non-empty, the storage class for the destination object, e.g.
+	// "NEARLINE". Otherwise the destination bucket's default is used.
+	DstStorageClass string
+
+	// If non-empty, the resource name of the Cloud KMS key with which to
+	// encrypt the destination object, of the form
+	//
+	//     projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>
+	//
+	DstKMSKeyName string
+
+	// If non-empty, a predefined ACL such as PredefinedACLBucketOwnerFullControl
+	// to apply to the destination object in place of the source object's ACL.
+	DstPredefinedACL string
+
+	// The maximum number of bytes that GCS should copy in each round trip. If
+	// zero, GCS chooses. Mostly useful for testing.
+	MaxBytesPerCall int64
+
+	// If non-nil, called after each round trip with the number of bytes copied
+	// so far and the total number to copy.
+	Progress func(bytesRewritten int64, totalBytes int64)
+}
+
 // MaxSourcesPerComposeRequest is the maximum number of sources that a
 // ComposeObjectsRequest may contain.
 //
@@ -185,13 +355,90 @@ type ReadObjectRequest struct {
 
 	// If present, limit the contents returned to a range within the object.
 	Range *ByteRange
+
+	// If true, the returned reader computes the CRC32C and (when available) MD5
+	// of the contents as they're read, and returns an error of type
+	// *ChecksumMismatchError in place of io.EOF if they don't match the
+	// object's metadata. Such a reader doesn't support seeking.
+	//
+	// This may not be combined with Range.
+	VerifyChecksums bool
+
+	// If non-nil, the read will fail with an error of type *PreconditionError
+	// if the object's current generation is not equal to this value. Unlike
+	// Generation, this doesn't select a generation to read; it lets callers
+	// that have cached contents or metadata check cheaply that they're still
+	// up to date.
+	GenerationPrecondition *int64
+
+	// If non-nil, the read will fail with an error of type *PreconditionError
+	// if the object's meta-generation is not equal to this value.
+	MetaGenerationPrecondition *int64
+
+	// If non-nil, called while the contents are being read with the number of
+	// bytes of them returned by the reader so far. Calls are made from the
+	// goroutine calling Read, so the function should return quickly.
+	ProgressFunc func(bytesRead int64)
+
+	// If positive, abort a call to Read on the returned reader when no data
+	// arrives for this long, returning an error of type *StalledReadError
+	// rather than waiting forever on a dead connection. Time spent by the
+	// caller between calls to Read doesn't count.
+	IdleTimeout time.Duration
+
+	// By default, the contents of an object stored with Content-Encoding: gzip
+	// are returned decompressed when reading via the JSON API, either by GCS
+	// (decompressive transcoding) or by the HTTP client. If this is true, ask
+	// for them exactly as stored instead, e.g. to pass them on to another
+	// client that understands gzip without decompressing and recompressing
+	// them. To stop GCS from ever transcoding an object for any reader, give it
+	// a CacheControl value of "no-transform". Cf.
+	// https://cloud.google.com/storage/docs/transcoding
+	//
+	// The gRPC API doesn't transcode, so reads via it decompress such contents
+	// on the client instead. Since the compressed contents can't be read from
+	// an arbitrary offset, this can't be combined with Range. Other
+	// implementations, such as fakes, always return contents as stored.
+	ReadCompressed bool
+
+	// If non-nil, called when NewReader succeeds with the content encoding of
+	// the contents that the returned reader will produce: "gzip" if they are
+	// compressed, or the empty string if they are not encoded. Use this rather
+	// than the object's ContentEncoding to tell whether the stream needs
+	// decompressing. Buckets that open the contents lazily, such as those
+	// created by NewRetryBucket, call it from the first call to Read instead.
+	ContentEncodingFunc func(contentEncoding string)
 }
 
 type StatObjectRequest struct {
 	// The name of the object in question.
 	Name string
+
+	// If non-empty, ask GCS to return only these fields of the object resource,
+	// named as in the JSON API (e.g. "generation", "metageneration", "size").
+	// This reduces latency and egress for callers that poll frequently for
+	// just a few fields. Cf. https://goo.gl/gNxV9e
+	//
+	// The name is always returned. Other fields of the resulting Object that
+	// were not requested are left with their zero values, except that
+	// implementations are free to fill in more fields than requested.
+	Fields []string
+
+	// If non-nil, the request will fail with an error of type
+	// *PreconditionError if the object's generation is not equal to this
+	// value.
+	GenerationPrecondition *int64
+
+	// If non-nil, the request will fail with an error of type
+	// *PreconditionError if the object's meta-generation is not equal to this
+	// value.
+	MetaGenerationPrecondition *int64
 }
 
+// The largest number of results GCS returns in a single page of a listing.
+// ListObjectsRequest.MaxResults values greater than this are treated as this.
+const MaxListResults = 1000
+
 type ListObjectsRequest struct {
 	// List only objects whose names begin with this prefix.
 	Prefix string
@@ -204,7 +451,7 @@ type ListObjectsRequest struct {
 	//     <Prefix><S><Delimiter><...>
 	//
 	// where <S> is a string that doesn't itself contain Delimiter and <...> is
-	// anything, return a single Collaped entry in the listing consisting of
+	// anything, return a single collapsed entry in the listing consisting of
 	//
 	//     <Prefix><S><Delimiter>
 	//
@@ -212,17 +459,75 @@ type ListObjectsRequest struct {
 	// a large number of objects, this may be more efficient.
 	Delimiter string
 
+	// If true and Delimiter is non-empty, an object whose name is exactly a
+	// collapsed run (i.e. one ending in its only instance of Delimiter, like a
+	// "directory placeholder" named "foo/") is returned in Listing.Objects in
+	// addition to the run being returned in Listing.CollapsedRuns.
+	//
+	// Cf. https://cloud.google.com/storage/docs/json_api/v1/objects/list
+	IncludeTrailingDelimiter bool
+
 	// Used to continue a listing where a previous one left off. See
 	// Listing.ContinuationToken for more information.
 	ContinuationToken string
 
-	// The maximum number of objects and collapsed runs to return. Fewer than
-	// this number may actually be returned. If this is zero, a sensible default
-	// is used.
+	// The maximum number of objects and collapsed runs to return in this page.
+	// Fewer than this number may actually be returned, even if more remain to
+	// be listed. If this is zero, a sensible default is used. GCS returns at
+	// most MaxListResults per page however large this is, and rejects negative
+	// values.
+	//
+	// To check cheaply whether anything at all matches the request, set this
+	// to one and Fields to []string{"name"}.
 	MaxResults int
+
+	// If non-empty, ask GCS to return only these fields of each object
+	// resource, named as in the JSON API (e.g. "generation", "size"). This
+	// reduces latency and egress for large listings that need only a few
+	// fields. Collapsed runs and the continuation token are always returned.
+	//
+	// As for StatObjectRequest.Fields, the name is always returned, and other
+	// fields not requested are left with their zero values, except that
+	// implementations are free to fill in more fields than requested.
+	Fields []string
+
+	// If true, list every generation of each object rather than only the live
+	// one. Noncurrent generations have a non-zero Deleted time, and may be read
+	// or restored by passing their Generation to NewReader or CopyObject.
+	//
+	// Buckets without object versioning enabled don't retain noncurrent
+	// generations, so for them this makes no difference.
+	//
+	// Cf. https://cloud.google.com/storage/docs/object-versioning
+	Versions bool
+
+	// If non-empty, list only objects whose names are lexicographically greater
+	// than or equal to this string. Collapsed runs are returned only if they
+	// contain such an object.
+	StartOffset string
+
+	// If non-empty, list only objects whose names are lexicographically
+	// strictly less than this string. Collapsed runs are returned only if they
+	// contain such an object.
+	EndOffset string
+
+	// If non-empty, list only objects whose full names match this glob
+	// pattern. The syntax is described by CompileGlob. Collapsed runs are
+	// returned only if they contain a matching object.
+	//
+	// Cf. https://cloud.google.com/storage/docs/json_api/v1/objects/list
+	MatchGlob string
+
+	// If true, list only soft-deleted objects, which have a non-zero
+	// SoftDeleteTime and may be restored with Bucket.RestoreObject until their
+	// HardDeleteTime. Every soft-deleted generation of each object is listed.
+	// Buckets without a soft delete policy have no soft-deleted objects.
+	//
+	// Cf. https://cloud.google.com/storage/docs/soft-delete
+	SoftDeleted bool
 }
 
-// Listing contains a set of objects and delimter-based collapsed runs returned
+// Listing contains a set of objects and delimiter-based collapsed runs returned
 // by a call to ListObjects. See also ListObjectsRequest.
 type Listing struct {
 	// Records for objects matching the listing criteria.
@@ -268,6 +573,12 @@ type UpdateObjectRequest struct {
 	// The generation of the object to update. Zero means the latest generation.
 	Generation int64
 
+	// If non-nil, the request will fail without effect if there is an object
+	// with the given name, and its current generation is not equal to this
+	// value. Unlike Generation, this doesn't select a generation to update; it
+	// guards against the object having been overwritten concurrently.
+	GenerationPrecondition *int64
+
 	// If non-nil, the request will fail without effect if there is an object
 	// with the given name (and optionally generation), and its meta-generation
 	// is not equal to this value.
@@ -298,6 +609,21 @@ type UpdateObjectRequest struct {
 	// supplied string. There is no facility for completely removing user
 	// metadata.
 	Metadata map[string]*string
+
+	// If non-nil, set or release the corresponding hold on the object.
+	TemporaryHold  *bool
+	EventBasedHold *bool
+
+	// If non-nil, set the object's custom time. GCS does not allow the custom
+	// time to be removed once set, nor moved earlier.
+	CustomTime *time.Time
+
+	// If non-nil, replace the object's entire access control list with these
+	// rules, in the same request as any other changes. Only Entity and Role
+	// are consulted. A nil slice leaves the ACL untouched, while an empty
+	// non-nil slice removes every rule. To change the rule for a single entity,
+	// see Bucket.UpdateObjectACL.
+	ACL []ACLRule
 }
 
 // A request to delete an object by name. Non-existence is not treated as an
@@ -309,8 +635,132 @@ type DeleteObjectRequest struct {
 	// The generation of the object to delete. Zero means the latest generation.
 	Generation int64
 
+	// If non-nil, the request will fail without effect if there is an object
+	// with the given name, and its current generation is not equal to this
+	// value. Unlike Generation, this doesn't select a generation to delete; it
+	// guards against the object having been overwritten concurrently.
+	GenerationPrecondition *int64
+
 	// If non-nil, the request will fail without effect if there is an object
 	// with the given name (and optionally generation), and its meta-generation
 	// is not equal to this value.
 	MetaGenerationPrecondition *int64
 }
+
+// A request to restore a soft-deleted object, accepted by Bucket.RestoreObject.
+type RestoreObjectRequest struct {
+	// The name of the object to restore. Must be specified.
+	Name string
+
+	// The generation of the soft-deleted object to restore, as found by listing
+	// with ListObjectsRequest.SoftDeleted. Must be specified, since an object
+	// may have been deleted several times.
+	Generation int64
+
+	// If non-nil, the request will fail without effect if the live generation
+	// of the object is not equal to this value. Zero means that there must be
+	// no live generation, so that restoring can't overwrite anything.
+	GenerationPrecondition *int64
+
+	// If true, the restored object has the ACL it had when it was deleted.
+	// Otherwise it has the bucket's default object ACL.
+	CopySourceACL bool
+}
+
+// A request to perform several operations at once, accepted by Bucket.Batch.
+type BatchRequest struct {
+	// The operations to perform. There is no limit on the number; they are
+	// sent to GCS in as many batches as necessary.
+	Ops []BatchOp
+}
+
+// A single operation within a BatchRequest. Exactly one field must be set.
+type BatchOp struct {
+	Stat   *StatObjectRequest
+	Update *UpdateObjectRequest
+	Delete *DeleteObjectRequest
+}
+
+// The outcome of a BatchOp.
+type BatchResult struct {
+	// The object record returned by a Stat or Update operation. Nil for Delete
+	// operations and on error.
+	Object *Object
+
+	// The error, if any, that the corresponding method would have returned.
+	Err error
+}
+
+// MaxSignedURLExpiry is the longest validity period that GCS accepts for a V4
+// signed URL.
+//
+// Cf. https://cloud.google.com/storage/docs/access-control/signed-urls
+const MaxSignedURLExpiry = 7 * 24 * time.Hour
+
+// A request to generate a signed URL for an object, accepted by
+// Bucket.SignedURL.
+type SignedURLRequest struct {
+	// The name of the object to which the URL grants access. Must be specified.
+	Name string
+
+	// The HTTP method with which the URL may be used, e.g. "GET" or "PUT". Must
+	// be specified.
+	Method string
+
+	// How long the URL remains valid, counting from when it is generated. Must
+	// be at least one second and no more than MaxSignedURLExpiry.
+	Expiry time.Duration
+
+	// If non-empty, requests made with the URL must carry exactly this
+	// Content-Type header. Useful for constraining uploads.
+	ContentType string
+
+	// Further headers that requests made with the URL must carry, for example
+	// "x-goog-meta-foo" for an upload. Header names are case-insensitive.
+	Headers map[string]string
+
+	// Further query parameters to include in the URL, for example
+	// "response-content-disposition" for a download.
+	QueryParameters url.Values
+}
+
+// A request to list the access control entries of an object, accepted by
+// Bucket.ListObjectACLs.
+type ListObjectACLsRequest struct {
+	// The name of the object in question. Must be specified.
+	Name string
+
+	// The generation of the object. Zero means the latest generation.
+	Generation int64
+}
+
+// A request to grant a role on an object to an entity, accepted by
+// Bucket.UpdateObjectACL.
+type UpdateObjectACLRequest struct {
+	// The name of the object in question. Must be specified.
+	Name string
+
+	// The generation of the object. Zero means the latest generation.
+	Generation int64
+
+	// The entity to which the role is granted, e.g. "user-foo@example.com" or
+	// "allUsers". See the notes on ACLRule.Entity. Must be specified.
+	Entity string
+
+	// The role to grant, replacing any role the entity already holds. Must be
+	// ACLRoleReader or ACLRoleOwner.
+	Role string
+}
+
+// A request to remove an entity's entry from an object's access control list,
+// accepted by Bucket.DeleteObjectACL.
+type DeleteObjectACLRequest struct {
+	// The name of the object in question. Must be specified.
+	Name string
+
+	// The generation of the object. Zero means the latest generation.
+	Generation int64
+
+	// The entity whose entry should be removed. Must be specified.
+	Entity string
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+)
+
+// Information about an HTTP response received from GCS. This is what Google
+// support will ask for when investigating slow or failed requests.
+type ResponseInfo struct {
+	// The method and URL of the request.
+	Method string
+	URL    *url.URL
+
+	// The status code and headers of the response. The headers of interest
+	// include X-GUploader-UploadID, which identifies the request to GCS, Age,
+	// and Server-Timing.
+	StatusCode int
+	Header     http.Header
+
+	// The time between sending the request and receiving the response headers.
+	Latency time.Duration
+}
+
+// A function called with information about each HTTP response received from
+// GCS, including those for requests that are later retried. It is called
+// synchronously before the response body is read, so it should be quick. It
+// must not modify the info.
+//
+// Operations that use the gRPC API when ConnConfig.UseGRPC is set are not
+// observed.
+type ResponseObserver func(info *ResponseInfo)
+
+type responseObserverKey struct{}
+
+// Return a context that causes f to be called for each HTTP response received
+// for requests made with it, in addition to any ConnConfig.ResponseObserver.
+// This is useful for gathering the responses for a particular operation, e.g.
+// a slow call to Bucket.CreateObject.
+func WithResponseObserver(
+	ctx context.Context,
+	f ResponseObserver) context.Context {
+	return context.WithValue(ctx, responseObserverKey{}, f)
+}
+
+// Wrap the supplied round tripper in a layer that reports responses to the
+// supplied observer, if non-nil, and to any observer in the request's context.
+func newResponseObservingRoundTripper(
+	wrapped httputil.CancellableRoundTripper,
+	observer ResponseObserver) (rt httputil.CancellableRoundTripper) {
+	rt = &responseObservingRoundTripper{
+		wrapped:  wrapped,
+		observer: observer,
+	}
+
+	return
+}
+
+type responseObservingRoundTripper struct {
+	wrapped  httputil.CancellableRoundTripper
+	observer ResponseObserver
+}
+
+func (t *responseObservingRoundTripper) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	ctxObserver, _ := req.Context().Value(responseObserverKey{}).(ResponseObserver)
+
+	// Skip the bookkeeping if there's no one to tell.
+	if t.observer == nil && ctxObserver == nil {
+		res, err = t.wrapped.RoundTrip(req)
+		return
+	}
+
+	start := time.Now()
+	res, err = t.wrapped.RoundTrip(req)
+	if err != nil {
+		return
+	}
+
+	info := &ResponseInfo{
+		Method:     req.Method,
+		URL:        req.URL,
+		StatusCode: res.StatusCode,
+		Header:     res.Header.Clone(),
+		Latency:    time.Since(start),
+	}
+
+	if t.observer != nil {
+		t.observer(info)
+	}
+
+	if ctxObserver != nil {
+		ctxObserver(info)
+	}
+
+	return
+}
+
+func (t *responseObservingRoundTripper) CancelRequest(req *http.Request) {
+	t.wrapped.CancelRequest(req)
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestResponseObserver(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// A round tripper that records requests and responds to each with the
+// supplied status code and headers.
+type headerTransport struct {
+	status   int
+	header   http.Header
+	requests []*http.Request
+}
+
+func (ht *headerTransport) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	ht.requests = append(ht.requests, req)
+	res = &http.Response{
+		StatusCode: ht.status,
+		Header:     ht.header,
+		Body: ioutil.NopCloser(strings.NewReader(
+			`{"name": "foo", "bucket": "some_bucket", "crc32c": "AAAAAA=="}`)),
+		Request: req,
+	}
+
+	return
+}
+
+func (ht *headerTransport) CancelRequest(req *http.Request) {
+}
+
+type ResponseObserverTest struct {
+	ctx       context.Context
+	transport headerTransport
+
+	// Responses seen by the conn-wide observer.
+	observed []*ResponseInfo
+
+	bucket Bucket
+}
+
+var _ SetUpInterface = &ResponseObserverTest{}
+
+func init() { RegisterTestSuite(&ResponseObserverTest{}) }
+
+func (t *ResponseObserverTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.transport.status = http.StatusOK
+	t.transport.header = http.Header{
+		"X-Guploader-Uploadid": []string{"taco"},
+		"Server-Timing":        []string{"gfet4t7; dur=17"},
+	}
+
+	observer := func(info *ResponseInfo) {
+		t.observed = append(t.observed, info)
+	}
+
+	t.bucket = newBucket(
+		&http.Client{
+			Transport: newResponseObservingRoundTripper(&t.transport, observer),
+		},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *ResponseObserverTest) ConnObserver() {
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.observed))
+	info := t.observed[0]
+
+	ExpectEq("GET", info.Method)
+	ExpectThat(info.URL.String(), HasSubstr("/b/some_bucket/o/foo"))
+	ExpectEq(http.StatusOK, info.StatusCode)
+	ExpectEq("taco", info.Header.Get("X-GUploader-UploadID"))
+	ExpectEq("gfet4t7; dur=17", info.Header.Get("Server-Timing"))
+	ExpectGe(info.Latency, 0)
+}
+
+func (t *ResponseObserverTest) ContextObserver() {
+	var observed []*ResponseInfo
+	ctx := WithResponseObserver(t.ctx, func(info *ResponseInfo) {
+		observed = append(observed, info)
+	})
+
+	_, err := t.bucket.StatObject(ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	// Both observers should have been told.
+	AssertEq(1, len(observed))
+	ExpectEq("taco", observed[0].Header.Get("X-GUploader-UploadID"))
+	ExpectEq(1, len(t.observed))
+
+	// Other requests shouldn't involve the context's observer.
+	_, err = t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	ExpectEq(1, len(observed))
+	ExpectEq(2, len(t.observed))
+}
+
+func (t *ResponseObserverTest) ErrorStatus() {
+	t.transport.status = http.StatusServiceUnavailable
+
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	ExpectNe(nil, err)
+
+	AssertEq(1, len(t.observed))
+	ExpectEq(http.StatusServiceUnavailable, t.observed[0].StatusCode)
+	ExpectEq("taco", t.observed[0].Header.Get("X-GUploader-UploadID"))
+}
+
+func (t *ResponseObserverTest) NoObservers() {
+	b := newBucket(
+		&http.Client{
+			Transport: newResponseObservingRoundTripper(&t.transport, nil),
+		},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+
+	_, err := b.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	ExpectEq(nil, err)
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+
+	"golang.org/x/net/context"
+)
+
+func (b *bucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	// Construct an appropriate URL.
+	path := fmt.Sprintf(
+		"/storage/v1/b/%s/o/%s/restore",
+		httputil.EncodePathSegment(b.Name()),
+		httputil.EncodePathSegment(req.Name))
+
+	query := make(url.Values)
+	query.Set("projection", "full")
+	query.Set("generation", fmt.Sprintf("%d", req.Generation))
+
+	if req.GenerationPrecondition != nil {
+		query.Set(
+			"ifGenerationMatch",
+			fmt.Sprintf("%d", *req.GenerationPrecondition))
+	}
+
+	if req.CopySourceACL {
+		query.Set("copySourceAcl", "true")
+	}
+
+	b.addCommonParams(query)
+
+	url := makeURL(b.endpoint, path, query)
+
+	// Create an HTTP request.
+	httpReq, err := httputil.NewRequest(ctx, "POST", url, nil, 0, b.userAgent)
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	// Execute the HTTP request.
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Check for HTTP-level errors.
+	if err = checkResponse(httpRes); err != nil {
+		if typed, ok := err.(*googleapi.Error); ok {
+			switch typed.Code {
+			case http.StatusNotFound:
+				err = &NotFoundError{Err: typed}
+
+			case http.StatusPreconditionFailed:
+				err = &PreconditionError{Err: typed}
+			}
+		}
+
+		return
+	}
+
+	// Parse the response.
+	var rawObject *storagev1.Object
+	if err = decodeResponse(httpRes, &rawObject); err != nil {
+		return
+	}
+
+	// Convert the response.
+	if o, err = toObject(rawObject); err != nil {
+		err = fmt.Errorf("toObject: %v", err)
+		return
+	}
+
+	return
+}
@@ -15,10 +15,9 @@
 package gcs
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"math"
 	"math/rand"
@@ -28,26 +27,62 @@ import (
 
 	"golang.org/x/net/context"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// A bucket that wraps another, calling its methods in a retry loop with
-// randomized exponential backoff.
-type retryBucket struct {
-	maxSleep time.Duration
-	wrapped  Bucket
+// RetryPolicy controls the behavior of the retry loop used by the bucket
+// returned by NewRetryBucket.
+type RetryPolicy struct {
+	// The maximum total amount of time to spend sleeping in a retry loop for a
+	// single logical request. Zero disables retries.
+	MaxSleep time.Duration
+
+	// The upper bound on the first sleep. Subsequent upper bounds double each
+	// time, up to MaxDelay. If zero, one millisecond is used.
+	InitialDelay time.Duration
+
+	// The maximum upper bound on any single sleep. If zero, there is no limit
+	// other than MaxSleep.
+	MaxDelay time.Duration
+
+	// The pool from which to take the blocks in which CreateObject buffers
+	// contents that can't be rewound. If nil, a pool without a limit is used.
+	BufferPool *BufferPool
 }
 
-func newRetryBucket(
-	maxSleep time.Duration,
-	wrapped Bucket) (b Bucket) {
+// Create a bucket that wraps the supplied one, calling its methods in a retry
+// loop with randomized exponential backoff when they fail with errors that
+// appear to be transient (HTTP 429 and 50x errors, and connection resets).
+//
+// The actual sleep for each retry is chosen uniformly at random below the
+// current upper bound. No sleep is started if it would take the caller past
+// the deadline of the context for the request.
+//
+// CreateObject is replayed by seeking req.Contents back to its starting
+// offset if it implements io.Seeker. Otherwise the entire contents are
+// buffered in memory, in blocks from policy.BufferPool, before the first
+// attempt, unless req.ChunkSize is negative or the request was made by an
+// ObjectWriter. In that case nothing is buffered, and the request is retried
+// only if it fails before any of the contents have been consumed.
+func NewRetryBucket(
+	wrapped Bucket,
+	policy RetryPolicy) (b Bucket) {
 	b = &retryBucket{
-		maxSleep: maxSleep,
-		wrapped:  wrapped,
+		policy:  policy,
+		wrapped: wrapped,
 	}
 
 	return
 }
 
+// A bucket that wraps another, calling its methods in a retry loop with
+// randomized exponential backoff.
+type retryBucket struct {
+	policy  RetryPolicy
+	wrapped Bucket
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Helpers
 ////////////////////////////////////////////////////////////////////////
@@ -69,6 +104,12 @@ func shouldRetry(err error) (b bool) {
 		}
 	}
 
+	// Rate limiting, as classified by checkResponse.
+	if _, ok := err.(*RateLimitError); ok {
+		b = true
+		return
+	}
+
 	// Network errors, which tend to show up transiently when doing lots of
 	// operations in parallel. For example:
 	//
@@ -87,6 +128,19 @@ func shouldRetry(err error) (b bool) {
 		return
 	}
 
+	// The gRPC equivalent of HTTP 503.
+	if status.Code(err) == codes.Unavailable {
+		b = true
+		return
+	}
+
+	// Reads that stalled, which most likely means the connection is dead and
+	// a fresh one will fare better.
+	if _, ok := err.(*StalledReadError); ok {
+		b = true
+		return
+	}
+
 	// The HTTP library also appears to leak EOF errors from... somewhere in its
 	// guts as URL errors sometimes.
 	if urlErr, ok := err.(*url.Error); ok {
@@ -108,11 +162,25 @@ func shouldRetry(err error) (b bool) {
 
 // Choose an appropriate delay for exponential backoff, given that we have
 // already slept the given number of times for this logical request.
-func chooseDelay(prevSleepCount uint) (d time.Duration) {
-	const baseDelay = time.Millisecond
+func chooseDelay(
+	policy RetryPolicy,
+	prevSleepCount uint) (d time.Duration) {
+	baseDelay := policy.InitialDelay
+	if baseDelay <= 0 {
+		baseDelay = time.Millisecond
+	}
+
+	// Choose a a delay in [0, 2^prevSleepCount * baseDelay), taking care not to
+	// overflow and respecting the user's cap.
+	d = baseDelay
+	for i := uint(0); i < prevSleepCount && d < math.MaxInt64/2; i++ {
+		d *= 2
+	}
+
+	if policy.MaxDelay > 0 && d > policy.MaxDelay {
+		d = policy.MaxDelay
+	}
 
-	// Choose a a delay in [0, 2^prevSleepCount * baseDelay).
-	d = (1 << prevSleepCount) * baseDelay
 	d = time.Duration(rand.Int63n(int64(d)))
 
 	return
@@ -135,19 +203,24 @@ func chooseDelay(prevSleepCount uint) (d time.Duration) {
 //
 //  *  We retry more types of errors; see shouldRetry above.
 //
+//  *  We give up early rather than sleep past the context's deadline.
+//
 // State for total sleep time and number of previous sleeps is housed outside
 // of this function to allow it to be "resumed" by multiple invocations of
 // retryObjectReader.Read.
+//
+// f is called with a context that records the number of the attempt, counting
+// from one; see ConnConfig.WireLog.
 func expBackoff(
 	ctx context.Context,
 	desc string,
-	maxSleep time.Duration,
-	f func() error,
+	policy RetryPolicy,
+	f func(context.Context) error,
 	prevSleepCount *uint,
 	prevSleepDuration *time.Duration) (err error) {
 	for {
 		// Make an attempt. Stop if successful.
-		err = f()
+		err = f(withAttempt(ctx, int(*prevSleepCount)+1))
 		if err == nil {
 			return
 		}
@@ -169,11 +242,17 @@ func expBackoff(
 		}
 
 		// Choose a a delay.
-		d := chooseDelay(*prevSleepCount)
+		d := chooseDelay(policy, *prevSleepCount)
 		*prevSleepCount++
 
 		// Are we out of credit?
-		if *prevSleepDuration+d > maxSleep {
+		if *prevSleepDuration+d > policy.MaxSleep {
+			// Return the most recent error.
+			return
+		}
+
+		// Would we wake up after the caller has stopped caring?
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(d).After(deadline) {
 			// Return the most recent error.
 			return
 		}
@@ -186,12 +265,16 @@ func expBackoff(
 			err,
 			d)
 
+		_, report := startSpan(ctx, fmt.Sprintf("Retry backoff: %s", desc))
+
 		select {
 		case <-ctx.Done():
 			// On cancellation, return the last error we saw.
+			report(ctx.Err())
 			return
 
 		case <-time.After(d):
+			report(nil)
 			*prevSleepDuration += d
 			continue
 		}
@@ -203,15 +286,15 @@ func expBackoff(
 func oneShotExpBackoff(
 	ctx context.Context,
 	desc string,
-	maxSleep time.Duration,
-	f func() error) (err error) {
+	policy RetryPolicy,
+	f func(context.Context) error) (err error) {
 	var prevSleepCount uint
 	var prevSleepDuration time.Duration
 
 	err = expBackoff(
 		ctx,
 		desc,
-		maxSleep,
+		policy,
 		f,
 		&prevSleepCount,
 		&prevSleepDuration)
@@ -230,9 +313,14 @@ type retryObjectReader struct {
 	ctx context.Context
 
 	// What we are trying to read.
-	name       string
-	generation int64
-	byteRange  ByteRange
+	name           string
+	generation     int64
+	byteRange      ByteRange
+	readCompressed bool
+
+	// If non-nil, to be called with the content encoding when the wrapped
+	// reader is first set up, and then cleared.
+	contentEncodingFunc func(string)
 
 	// nil when we start or have seen a permanent error.
 	wrapped ReadSeekCloser
@@ -247,21 +335,24 @@ type retryObjectReader struct {
 	sleepDuration time.Duration
 }
 
-// Set up the wrapped reader.
-func (rc *retryObjectReader) setUpWrapped() (err error) {
+// Set up the wrapped reader, using the supplied context for the request.
+func (rc *retryObjectReader) setUpWrapped(ctx context.Context) (err error) {
 	// Call through to create the reader.
 	req := &ReadObjectRequest{
-		Name:       rc.name,
-		Generation: rc.generation,
-		Range:      &rc.byteRange,
+		Name:                rc.name,
+		Generation:          rc.generation,
+		Range:               &rc.byteRange,
+		ReadCompressed:      rc.readCompressed,
+		ContentEncodingFunc: rc.contentEncodingFunc,
 	}
 
-	wrapped, err := rc.bucket.wrapped.NewReader(rc.ctx, req)
+	wrapped, err := rc.bucket.wrapped.NewReader(ctx, req)
 	if err != nil {
 		return
 	}
 
 	rc.wrapped = wrapped
+	rc.contentEncodingFunc = nil
 	return
 }
 
@@ -269,10 +360,12 @@ func (rc *retryObjectReader) setUpWrapped() (err error) {
 // it.
 //
 // Clears the wrapped reader on error.
-func (rc *retryObjectReader) readOnce(p []byte) (n int, err error) {
+func (rc *retryObjectReader) readOnce(
+	ctx context.Context,
+	p []byte) (n int, err error) {
 	// Set up the wrapped reader if it's not already around.
 	if rc.wrapped == nil {
-		err = rc.setUpWrapped()
+		err = rc.setUpWrapped(ctx)
 		if err != nil {
 			return
 		}
@@ -293,15 +386,6 @@ func (rc *retryObjectReader) readOnce(p []byte) (n int, err error) {
 // on retrying. In particular, we won't return a short read because the wrapped
 // reader returned a short read and an error.
 func (rc *retryObjectReader) Read(p []byte) (n int, err error) {
-	// Whatever we do, accumulate the bytes that we're returning to the user.
-	defer func() {
-		if n < 0 {
-			panic(fmt.Sprintf("Negative byte count: %d", n))
-		}
-
-		rc.byteRange.Start += uint64(n)
-	}()
-
 	// If we've already decided on a permanent error, return that.
 	if rc.permanentErr != nil {
 		err = rc.permanentErr
@@ -316,12 +400,19 @@ func (rc *retryObjectReader) Read(p []byte) (n int, err error) {
 	}()
 
 	// We will repeatedly make single attempts until we get a successful request.
-	// Don't forget to accumulate the result each time.
-	tryOnce := func() (err error) {
+	// Don't forget to accumulate the result each time, advancing the range
+	// straight away so that a retry picks up where the failed attempt left off
+	// rather than re-reading what it returned.
+	tryOnce := func(ctx context.Context) (err error) {
 		var bytesRead int
-		bytesRead, err = rc.readOnce(p)
+		bytesRead, err = rc.readOnce(ctx, p)
+		if bytesRead < 0 {
+			panic(fmt.Sprintf("Negative byte count: %d", bytesRead))
+		}
+
 		n += bytesRead
 		p = p[bytesRead:]
+		rc.byteRange.Start += uint64(bytesRead)
 
 		return
 	}
@@ -329,7 +420,7 @@ func (rc *retryObjectReader) Read(p []byte) (n int, err error) {
 	err = expBackoff(
 		rc.ctx,
 		fmt.Sprintf("Read(%q, %d)", rc.name, rc.generation),
-		rc.bucket.maxSleep,
+		rc.bucket.policy,
 		tryOnce,
 		&rc.sleepCount,
 		&rc.sleepDuration)
@@ -373,16 +464,26 @@ func (rb *retryBucket) Name() (name string) {
 func (rb *retryBucket) NewReader(
 	ctx context.Context,
 	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	// We can only verify checksums for the full contents.
+	if req.VerifyChecksums && req.Range != nil {
+		err = errors.New("VerifyChecksums may not be combined with Range")
+		return
+	}
+
 	// If the user specified the latest generation, we need to figure out what
 	// that is so that we can create a reader that knows how to keep a stable
 	// generation despite retrying repeatedly.
+	//
+	// Because the wrapped reads below are for ranges, we must also find the
+	// object's checksums ourselves if we're to verify them.
 	var generation int64 = req.Generation
+	var o *Object
 	var sleepCount uint
 	var sleepDuration time.Duration
 
-	if generation == 0 {
-		findGeneration := func() (err error) {
-			o, err := rb.wrapped.StatObject(
+	if generation == 0 || req.VerifyChecksums {
+		findGeneration := func(ctx context.Context) (err error) {
+			o, err = rb.wrapped.StatObject(
 				ctx,
 				&StatObjectRequest{
 					Name: req.Name,
@@ -392,6 +493,18 @@ func (rb *retryBucket) NewReader(
 				return
 			}
 
+			// The checksums are only available for the latest generation.
+			if generation != 0 && o.Generation != generation {
+				err = &NotFoundError{
+					Err: fmt.Errorf(
+						"Can't verify checksums for non-latest generation %d of %q",
+						generation,
+						req.Name),
+				}
+
+				return
+			}
+
 			generation = o.Generation
 			return
 		}
@@ -399,7 +512,7 @@ func (rb *retryBucket) NewReader(
 		err = expBackoff(
 			ctx,
 			fmt.Sprintf("FindLatestGeneration(%q)", req.Name),
-			rb.maxSleep,
+			rb.policy,
 			findGeneration,
 			&sleepCount,
 			&sleepDuration)
@@ -422,14 +535,21 @@ func (rb *retryBucket) NewReader(
 		bucket: rb,
 		ctx:    ctx,
 
-		name:       req.Name,
-		generation: generation,
-		byteRange:  byteRange,
+		name:                req.Name,
+		generation:          generation,
+		byteRange:           byteRange,
+		readCompressed:      req.ReadCompressed,
+		contentEncodingFunc: req.ContentEncodingFunc,
 
 		sleepCount:    sleepCount,
 		sleepDuration: sleepDuration,
 	}
 
+	// Verify checksums if requested.
+	if req.VerifyChecksums {
+		rc = NewVerifyingReader(rc, o)
+	}
+
 	return
 }
 
@@ -440,11 +560,31 @@ func (rb *retryBucket) CreateObject(
 	// attempt might exhaust some of the req.Contents reader, leaving missing
 	// contents for the second attempt.
 	//
-	// So, copy out all contents and create a copy of the request that we will
-	// modify to serve from memory for each call.
-	contents, err := ioutil.ReadAll(req.Contents)
-	if err != nil {
-		err = fmt.Errorf("ioutil.ReadAll: %v", err)
+	// If the reader is seekable, we can rewind it before each attempt.
+	if seeker, ok := req.Contents.(io.Seeker); ok {
+		o, err = rb.createObjectSeekable(ctx, req, seeker)
+		return
+	}
+
+	// If the caller has asked for the contents to be streamed without buffering,
+	// don't buffer them here either.
+	if req.ChunkSize < 0 || req.streamContents {
+		o, err = rb.createObjectStreaming(ctx, req)
+		return
+	}
+
+	// Otherwise, copy out all contents and create a copy of the request that we
+	// will modify to serve from memory for each call.
+	pool := rb.policy.BufferPool
+	if pool == nil {
+		pool = defaultBufferPool
+	}
+
+	contents := newPooledBuffer(ctx, pool)
+	defer contents.Release()
+
+	if _, err = io.Copy(contents, req.Contents); err != nil {
+		err = fmt.Errorf("Buffering contents: %v", err)
 		return
 	}
 
@@ -454,9 +594,9 @@ func (rb *retryBucket) CreateObject(
 	err = oneShotExpBackoff(
 		ctx,
 		fmt.Sprintf("CreateObject(%q)", req.Name),
-		rb.maxSleep,
-		func() (err error) {
-			reqCopy.Contents = bytes.NewReader(contents)
+		rb.policy,
+		func(ctx context.Context) (err error) {
+			reqCopy.Contents = io.NewSectionReader(contents, 0, contents.Size())
 			o, err = rb.wrapped.CreateObject(ctx, &reqCopy)
 			return
 		})
@@ -464,14 +604,96 @@ func (rb *retryBucket) CreateObject(
 	return
 }
 
+// Like CreateObject, but for a request whose contents can be rewound with the
+// supplied seeker rather than buffered.
+func (rb *retryBucket) createObjectSeekable(
+	ctx context.Context,
+	req *CreateObjectRequest,
+	seeker io.Seeker) (o *Object, err error) {
+	// Find where the contents start.
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		err = fmt.Errorf("Seek: %v", err)
+		return
+	}
+
+	// Call through, rewinding before each attempt. A failure to rewind is
+	// permanent, since it isn't a type of error that shouldRetry recognizes.
+	err = oneShotExpBackoff(
+		ctx,
+		fmt.Sprintf("CreateObject(%q)", req.Name),
+		rb.policy,
+		func(ctx context.Context) (err error) {
+			_, err = seeker.Seek(start, io.SeekStart)
+			if err != nil {
+				err = fmt.Errorf("Seek: %v", err)
+				return
+			}
+
+			o, err = rb.wrapped.CreateObject(ctx, req)
+			return
+		})
+
+	return
+}
+
+// A reader that records whether anything has been read from it.
+type consumptionReader struct {
+	wrapped  io.Reader
+	consumed bool
+}
+
+func (r *consumptionReader) Read(p []byte) (n int, err error) {
+	n, err = r.wrapped.Read(p)
+	if n > 0 {
+		r.consumed = true
+	}
+
+	return
+}
+
+// Like CreateObject, but for a request whose contents must not be buffered.
+// Such a request can be replayed only until the wrapped bucket starts to
+// consume its contents.
+func (rb *retryBucket) createObjectStreaming(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	contents := &consumptionReader{wrapped: req.Contents}
+	reqCopy := *req
+	reqCopy.Contents = contents
+
+	// Hide errors that happen after the contents have been consumed from
+	// expBackoff, so that it doesn't retry them.
+	var lateErr error
+	err = oneShotExpBackoff(
+		ctx,
+		fmt.Sprintf("CreateObject(%q)", req.Name),
+		rb.policy,
+		func(ctx context.Context) (err error) {
+			o, err = rb.wrapped.CreateObject(ctx, &reqCopy)
+			if err != nil && contents.consumed {
+				lateErr = err
+				err = nil
+			}
+
+			return
+		})
+
+	if lateErr != nil {
+		err = lateErr
+	}
+
+	return
+}
+
 func (rb *retryBucket) CopyObject(
 	ctx context.Context,
 	req *CopyObjectRequest) (o *Object, err error) {
 	err = oneShotExpBackoff(
 		ctx,
 		fmt.Sprintf("CopyObject(%q, %q)", req.SrcName, req.DstName),
-		rb.maxSleep,
-		func() (err error) {
+		rb.policy,
+		func(ctx context.Context) (err error) {
 			o, err = rb.wrapped.CopyObject(ctx, req)
 			return
 		})
@@ -485,8 +707,8 @@ func (rb *retryBucket) MoveObject(
 	err = oneShotExpBackoff(
 		ctx,
 		fmt.Sprintf("MoveObject(%q, %q)", req.SrcName, req.DstName),
-		rb.maxSleep,
-		func() (err error) {
+		rb.policy,
+		func(ctx context.Context) (err error) {
 			o, err = rb.wrapped.MoveObject(ctx, req)
 			return
 		})
@@ -500,8 +722,8 @@ func (rb *retryBucket) ComposeObjects(
 	err = oneShotExpBackoff(
 		ctx,
 		fmt.Sprintf("ComposeObjects(%q)", req.DstName),
-		rb.maxSleep,
-		func() (err error) {
+		rb.policy,
+		func(ctx context.Context) (err error) {
 			o, err = rb.wrapped.ComposeObjects(ctx, req)
 			return
 		})
@@ -515,8 +737,8 @@ func (rb *retryBucket) StatObject(
 	err = oneShotExpBackoff(
 		ctx,
 		fmt.Sprintf("StatObject(%q)", req.Name),
-		rb.maxSleep,
-		func() (err error) {
+		rb.policy,
+		func(ctx context.Context) (err error) {
 			o, err = rb.wrapped.StatObject(ctx, req)
 			return
 		})
@@ -530,8 +752,8 @@ func (rb *retryBucket) ListObjects(
 	err = oneShotExpBackoff(
 		ctx,
 		fmt.Sprintf("ListObjects(%q)", req.Prefix),
-		rb.maxSleep,
-		func() (err error) {
+		rb.policy,
+		func(ctx context.Context) (err error) {
 			listing, err = rb.wrapped.ListObjects(ctx, req)
 			return
 		})
@@ -544,8 +766,8 @@ func (rb *retryBucket) UpdateObject(
 	err = oneShotExpBackoff(
 		ctx,
 		fmt.Sprintf("UpdateObject(%q)", req.Name),
-		rb.maxSleep,
-		func() (err error) {
+		rb.policy,
+		func(ctx context.Context) (err error) {
 			o, err = rb.wrapped.UpdateObject(ctx, req)
 			return
 		})
@@ -559,11 +781,157 @@ func (rb *retryBucket) DeleteObject(
 	err = oneShotExpBackoff(
 		ctx,
 		fmt.Sprintf("DeleteObject(%q)", req.Name),
-		rb.maxSleep,
-		func() (err error) {
+		rb.policy,
+		func(ctx context.Context) (err error) {
 			err = rb.wrapped.DeleteObject(ctx, req)
 			return
 		})
 
 	return
 }
+
+func (rb *retryBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	err = oneShotExpBackoff(
+		ctx,
+		fmt.Sprintf("RestoreObject(%q, %d)", req.Name, req.Generation),
+		rb.policy,
+		func(ctx context.Context) (err error) {
+			o, err = rb.wrapped.RestoreObject(ctx, req)
+			return
+		})
+
+	return
+}
+
+func (rb *retryBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	// This doesn't touch the network, so there's nothing to retry.
+	signed, err = rb.wrapped.SignedURL(ctx, req)
+	return
+}
+
+func (rb *retryBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	err = oneShotExpBackoff(
+		ctx,
+		fmt.Sprintf("ListObjectACLs(%q)", req.Name),
+		rb.policy,
+		func(ctx context.Context) (err error) {
+			rules, err = rb.wrapped.ListObjectACLs(ctx, req)
+			return
+		})
+
+	return
+}
+
+func (rb *retryBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	err = oneShotExpBackoff(
+		ctx,
+		fmt.Sprintf("UpdateObjectACL(%q, %q)", req.Name, req.Entity),
+		rb.policy,
+		func(ctx context.Context) (err error) {
+			rule, err = rb.wrapped.UpdateObjectACL(ctx, req)
+			return
+		})
+
+	return
+}
+
+func (rb *retryBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	err = oneShotExpBackoff(
+		ctx,
+		fmt.Sprintf("DeleteObjectACL(%q, %q)", req.Name, req.Entity),
+		rb.policy,
+		func(ctx context.Context) (err error) {
+			err = rb.wrapped.DeleteObjectACL(ctx, req)
+			return
+		})
+
+	return
+}
+
+func (rb *retryBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	err = oneShotExpBackoff(
+		ctx,
+		fmt.Sprintf("RewriteObject(%q, %q)", req.SrcName, req.DstName),
+		rb.policy,
+		func(ctx context.Context) (err error) {
+			o, err = rb.wrapped.RewriteObject(ctx, req)
+			return
+		})
+
+	return
+}
+
+func (rb *retryBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	results = make([]BatchResult, len(req.Ops))
+
+	// The indices of operations that have yet to succeed or fail permanently.
+	pending := make([]int, len(req.Ops))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	// Retry the batch as a whole when it fails transiently, and also retry just
+	// those operations within it that did.
+	var opFailed bool
+	err = oneShotExpBackoff(
+		ctx,
+		fmt.Sprintf("Batch(%d ops)", len(req.Ops)),
+		rb.policy,
+		func(ctx context.Context) (err error) {
+			opFailed = false
+
+			subReq := &BatchRequest{}
+			for _, i := range pending {
+				subReq.Ops = append(subReq.Ops, req.Ops[i])
+			}
+
+			// A batch that fails may still report results for the operations it
+			// carried out before failing. Keep those, so they aren't repeated.
+			subResults, err := rb.wrapped.Batch(ctx, subReq)
+
+			var stillPending []int
+			for j, i := range pending {
+				if j >= len(subResults) {
+					stillPending = append(stillPending, i)
+					continue
+				}
+
+				results[i] = subResults[j]
+				if shouldRetry(subResults[j].Err) {
+					stillPending = append(stillPending, i)
+					if err == nil || opFailed {
+						opFailed = true
+						err = subResults[j].Err
+					}
+				}
+			}
+
+			pending = stillPending
+			return
+		})
+
+	// Errors for individual operations are reported in the results.
+	if opFailed {
+		err = nil
+	}
+
+	if err != nil {
+		results = nil
+	}
+
+	return
+}
@@ -78,7 +78,7 @@ type retryBucketTest struct {
 func (t *retryBucketTest) SetUp(ti *TestInfo) {
 	t.ctx = ti.Ctx
 	t.wrapped = NewMockBucket(ti.MockController, "wrapped")
-	t.bucket = newRetryBucket(time.Second, t.wrapped)
+	t.bucket = NewRetryBucket(t.wrapped, RetryPolicy{MaxSleep: time.Second})
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -111,7 +111,7 @@ func (t *RetryBucket_CreateObjectTest) ErrorReading() {
 	// Call
 	err = t.call()
 
-	ExpectThat(err, Error(HasSubstr("ReadAll")))
+	ExpectThat(err, Error(HasSubstr("Buffering contents")))
 	ExpectThat(err, Error(HasSubstr("timeout")))
 }
 
@@ -201,3 +201,164 @@ func (t *RetryBucket_CreateObjectTest) RetrySuccessful() {
 	AssertEq(nil, err)
 	ExpectEq(expected, t.obj)
 }
+
+func (t *RetryBucket_CreateObjectTest) SeekableContentsAreRewound() {
+	const expected = "taco"
+
+	// Request
+	t.req.Contents = strings.NewReader(expected)
+
+	// Wrapped
+	retryable := io.ErrUnexpectedEOF
+
+	ExpectCall(t.wrapped, "CreateObject")(Any(), contentsAre(expected)).
+		WillOnce(Return(nil, retryable)).
+		WillOnce(Return(nil, errors.New("")))
+
+	// Call
+	t.call()
+}
+
+func (t *RetryBucket_CreateObjectTest) BufferedContentsComeFromPool() {
+	pool := NewBufferPool(1 << 20)
+	t.bucket = NewRetryBucket(
+		t.wrapped,
+		RetryPolicy{MaxSleep: time.Second, BufferPool: pool})
+
+	// Request
+	t.req.Contents = ioutil.NopCloser(strings.NewReader("taco"))
+
+	// Wrapped, checking that the contents are held in the pool.
+	var inUse int64
+	ExpectCall(t.wrapped, "CreateObject")(Any(), contentsAre("taco")).
+		WillOnce(Invoke(func(ctx context.Context, req *CreateObjectRequest) (*Object, error) {
+			inUse = pool.InUse()
+			return &Object{}, nil
+		}))
+
+	// Call
+	err := t.call()
+
+	AssertEq(nil, err)
+	ExpectGt(inUse, 0)
+	ExpectEq(0, pool.InUse())
+}
+
+func (t *RetryBucket_CreateObjectTest) BufferedContentsExceedPoolLimit() {
+	pool := NewBufferPool(3)
+	t.bucket = NewRetryBucket(
+		t.wrapped,
+		RetryPolicy{MaxSleep: time.Second, BufferPool: pool})
+
+	// Request
+	t.req.Contents = ioutil.NopCloser(strings.NewReader("taco"))
+
+	// Call
+	err := t.call()
+
+	ExpectThat(err, Error(HasSubstr("limit of 3 bytes")))
+	ExpectEq(0, pool.InUse())
+}
+
+func (t *RetryBucket_CreateObjectTest) StreamedContentsAreNotReplayed() {
+	var err error
+
+	// Request
+	t.req.Contents = ioutil.NopCloser(strings.NewReader("taco"))
+	t.req.ChunkSize = -1
+
+	// Wrapped, consuming the contents before failing.
+	retryable := io.ErrUnexpectedEOF
+
+	ExpectCall(t.wrapped, "CreateObject")(Any(), contentsAre("taco")).
+		WillOnce(Return(nil, retryable))
+
+	// Call
+	err = t.call()
+
+	ExpectEq(retryable, err)
+}
+
+func (t *RetryBucket_CreateObjectTest) StreamedRetryBeforeConsumption() {
+	var err error
+
+	// Request
+	t.req.Contents = ioutil.NopCloser(strings.NewReader("taco"))
+	t.req.ChunkSize = -1
+
+	// Wrapped, failing before touching the contents.
+	retryable := io.ErrUnexpectedEOF
+	expected := &Object{}
+
+	ExpectCall(t.wrapped, "CreateObject")(Any(), Any()).
+		WillOnce(Return(nil, retryable)).
+		WillOnce(Return(expected, nil))
+
+	// Call
+	err = t.call()
+
+	AssertEq(nil, err)
+	ExpectEq(expected, t.obj)
+}
+
+func (t *RetryBucket_CreateObjectTest) DoesntSleepPastDeadline() {
+	var err error
+
+	// Request
+	t.req.Contents = strings.NewReader("")
+
+	// Context
+	ctx, cancel := context.WithTimeout(t.ctx, time.Nanosecond)
+	defer cancel()
+
+	// Wrapped
+	retryable := io.ErrUnexpectedEOF
+
+	ExpectCall(t.wrapped, "CreateObject")(Any(), Any()).
+		WillOnce(Return(nil, retryable))
+
+	// Call
+	t.obj, err = t.bucket.CreateObject(ctx, &t.req)
+
+	ExpectEq(retryable, err)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Batch
+////////////////////////////////////////////////////////////////////////
+
+type RetryBucket_BatchTest struct {
+	retryBucketTest
+}
+
+func init() { RegisterTestSuite(&RetryBucket_BatchTest{}) }
+
+func (t *RetryBucket_BatchTest) PartialResultsAreNotRepeated() {
+	req := &BatchRequest{
+		Ops: []BatchOp{
+			{Delete: &DeleteObjectRequest{Name: "foo"}},
+			{Delete: &DeleteObjectRequest{Name: "bar"}},
+			{Delete: &DeleteObjectRequest{Name: "baz"}},
+		},
+	}
+
+	// The first call gets through one operation before failing transiently.
+	// The retry should contain only the remaining two.
+	var retried []string
+	ExpectCall(t.wrapped, "Batch")(Any(), Any()).
+		WillOnce(Return([]BatchResult{{}}, io.ErrUnexpectedEOF)).
+		WillOnce(Invoke(func(
+			ctx context.Context,
+			req *BatchRequest) ([]BatchResult, error) {
+			for _, op := range req.Ops {
+				retried = append(retried, op.Delete.Name)
+			}
+
+			return make([]BatchResult, len(req.Ops)), nil
+		}))
+
+	results, err := t.bucket.Batch(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq(3, len(results))
+	ExpectThat(retried, ElementsAre("bar", "baz"))
+}
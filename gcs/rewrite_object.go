@@ -0,0 +1,196 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"google.golang.org/api/googleapi"
+	storagev1 "google.golang.org/api/storage/v1"
+
+	"golang.org/x/net/context"
+)
+
+func (b *bucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	// We encode using json.NewEncoder, which is documented to silently transform
+	// invalid UTF-8 (cf. http://goo.gl/3gIUQB). So we can't rely on the server
+	// to detect this for us.
+	if err = checkObjectNames(req.SrcName, req.DstName); err != nil {
+		return
+	}
+
+	// GCS may need several calls to finish the job, each of which returns a
+	// token that must be passed to the next.
+	var token string
+	for {
+		var res *storagev1.RewriteResponse
+		res, err = b.rewriteOnce(ctx, req, token)
+		if err != nil {
+			return
+		}
+
+		if req.Progress != nil {
+			req.Progress(res.TotalBytesRewritten, res.ObjectSize)
+		}
+
+		if res.Done {
+			if res.Resource == nil {
+				err = errors.New("Rewrite done, but no resource returned.")
+				return
+			}
+
+			if o, err = toObject(res.Resource); err != nil {
+				err = fmt.Errorf("toObject: %v", err)
+				return
+			}
+
+			return
+		}
+
+		if res.RewriteToken == "" {
+			err = errors.New("Rewrite not done, but no rewrite token returned.")
+			return
+		}
+
+		token = res.RewriteToken
+	}
+}
+
+// Make a single call to the rewrite API, passing along the token returned by
+// the previous call if any.
+func (b *bucket) rewriteOnce(
+	ctx context.Context,
+	req *RewriteObjectRequest,
+	token string) (res *storagev1.RewriteResponse, err error) {
+	dstBucket := req.DstBucket
+	if dstBucket == "" {
+		dstBucket = b.Name()
+	}
+
+	// Construct an appropriate URL.
+	path := fmt.Sprintf(
+		"/storage/v1/b/%s/o/%s/rewriteTo/b/%s/o/%s",
+		httputil.EncodePathSegment(b.Name()),
+		httputil.EncodePathSegment(req.SrcName),
+		httputil.EncodePathSegment(dstBucket),
+		httputil.EncodePathSegment(req.DstName))
+
+	query := make(url.Values)
+	query.Set("projection", "full")
+
+	if token != "" {
+		query.Set("rewriteToken", token)
+	}
+
+	if req.SrcGeneration != 0 {
+		query.Set("sourceGeneration", fmt.Sprint(req.SrcGeneration))
+	}
+
+	if req.SrcMetaGenerationPrecondition != nil {
+		query.Set(
+			"ifSourceMetagenerationMatch",
+			fmt.Sprint(*req.SrcMetaGenerationPrecondition))
+	}
+
+	if req.DstGenerationPrecondition != nil {
+		query.Set("ifGenerationMatch", fmt.Sprint(*req.DstGenerationPrecondition))
+	}
+
+	if req.DstKMSKeyName != "" {
+		query.Set("destinationKmsKeyName", req.DstKMSKeyName)
+	}
+
+	if req.DstPredefinedACL != "" {
+		query.Set("destinationPredefinedAcl", req.DstPredefinedACL)
+	}
+
+	if req.MaxBytesPerCall != 0 {
+		query.Set("maxBytesRewrittenPerCall", fmt.Sprint(req.MaxBytesPerCall))
+	}
+
+	b.addCommonParams(query)
+
+	url := makeURL(b.endpoint, path, query)
+
+	// Set up the request body. Fields left empty are carried over from the
+	// source object.
+	body, err := json.Marshal(&storagev1.Object{
+		StorageClass: req.DstStorageClass,
+	})
+
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %v", err)
+		return
+	}
+
+	// Create the HTTP request.
+	httpReq, err := httputil.NewRequest(
+		ctx,
+		"POST",
+		url,
+		ioutil.NopCloser(bytes.NewReader(body)),
+		int64(len(body)),
+		b.userAgent)
+
+	if err != nil {
+		err = fmt.Errorf("httputil.NewRequest: %v", err)
+		return
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Execute the HTTP request.
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	// Check for HTTP-level errors.
+	if err = checkResponse(httpRes); err != nil {
+		// Special case: handle not found errors.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusNotFound {
+				err = &NotFoundError{Err: typed}
+			}
+		}
+
+		// Special case: handle precondition errors.
+		if typed, ok := err.(*googleapi.Error); ok {
+			if typed.Code == http.StatusPreconditionFailed {
+				err = &PreconditionError{Err: typed}
+			}
+		}
+
+		return
+	}
+
+	// Parse the response.
+	if err = decodeResponse(httpRes, &res); err != nil {
+		return
+	}
+
+	return
+}
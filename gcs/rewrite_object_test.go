@@ -0,0 +1,231 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestRewriteObject(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A transport that returns the supplied responses in order, recording each
+// request and its body.
+type sequenceTransport struct {
+	responses []string
+	requests  []*http.Request
+	bodies    []string
+}
+
+func (st *sequenceTransport) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	var body []byte
+	if req.Body != nil {
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return
+		}
+	}
+
+	st.requests = append(st.requests, req)
+	st.bodies = append(st.bodies, string(body))
+
+	response := st.responses[0]
+	st.responses = st.responses[1:]
+
+	res = &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(response)),
+		Request:    req,
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type RewriteObjectTest struct {
+	ctx       context.Context
+	transport sequenceTransport
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &RewriteObjectTest{}
+
+func init() { RegisterTestSuite(&RewriteObjectTest{}) }
+
+func (t *RewriteObjectTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.bucket = newBucket(
+		&http.Client{Transport: &t.transport},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *RewriteObjectTest) SingleCall() {
+	t.transport.responses = []string{
+		`{
+			"done": true,
+			"objectSize": "3",
+			"totalBytesRewritten": "3",
+			"resource": {"name": "bar", "size": "3", "crc32c": "AAAAAA=="}
+		}`,
+	}
+
+	req := &RewriteObjectRequest{
+		SrcName:          "foo",
+		DstBucket:        "other_bucket",
+		DstName:          "bar",
+		DstStorageClass:  "NEARLINE",
+		DstKMSKeyName:    "projects/p/locations/l/keyRings/r/cryptoKeys/k",
+		DstPredefinedACL: PredefinedACLBucketOwnerFullControl,
+	}
+
+	o, err := t.bucket.RewriteObject(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq("bar", o.Name)
+	ExpectEq(3, o.Size)
+
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+
+	ExpectEq("POST", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/o/foo/rewriteTo/b/other_bucket/o/bar",
+		httpReq.URL.Opaque)
+
+	query := httpReq.URL.Query()
+	ExpectEq(
+		"projects/p/locations/l/keyRings/r/cryptoKeys/k",
+		query.Get("destinationKmsKeyName"))
+
+	ExpectEq("bucketOwnerFullControl", query.Get("destinationPredefinedAcl"))
+
+	_, ok := query["rewriteToken"]
+	ExpectFalse(ok)
+
+	ExpectThat(t.transport.bodies[0], HasSubstr(`"storageClass":"NEARLINE"`))
+}
+
+func (t *RewriteObjectTest) DefaultsToSameBucket() {
+	t.transport.responses = []string{
+		`{"done": true, "resource": {"name": "bar", "crc32c": "AAAAAA=="}}`,
+	}
+
+	req := &RewriteObjectRequest{
+		SrcName: "foo",
+		DstName: "bar",
+	}
+
+	_, err := t.bucket.RewriteObject(t.ctx, req)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/o/foo/rewriteTo/b/some_bucket/o/bar",
+		t.transport.requests[0].URL.Opaque)
+
+	query := t.transport.requests[0].URL.Query()
+
+	_, ok := query["destinationKmsKeyName"]
+	ExpectFalse(ok)
+
+	_, ok = query["destinationPredefinedAcl"]
+	ExpectFalse(ok)
+}
+
+func (t *RewriteObjectTest) MultipleCalls() {
+	t.transport.responses = []string{
+		`{
+			"done": false,
+			"objectSize": "30",
+			"totalBytesRewritten": "10",
+			"rewriteToken": "taco"
+		}`,
+		`{
+			"done": false,
+			"objectSize": "30",
+			"totalBytesRewritten": "20",
+			"rewriteToken": "burrito"
+		}`,
+		`{
+			"done": true,
+			"objectSize": "30",
+			"totalBytesRewritten": "30",
+			"resource": {"name": "bar", "size": "30", "crc32c": "AAAAAA=="}
+		}`,
+	}
+
+	var progress []int64
+	req := &RewriteObjectRequest{
+		SrcName:         "foo",
+		DstName:         "bar",
+		MaxBytesPerCall: 10,
+		Progress: func(rewritten int64, total int64) {
+			ExpectEq(30, total)
+			progress = append(progress, rewritten)
+		},
+	}
+
+	o, err := t.bucket.RewriteObject(t.ctx, req)
+	AssertEq(nil, err)
+	ExpectEq("bar", o.Name)
+
+	ExpectThat(progress, ElementsAre(10, 20, 30))
+
+	AssertEq(3, len(t.transport.requests))
+
+	var tokens []string
+	for _, r := range t.transport.requests {
+		ExpectEq("10", r.URL.Query().Get("maxBytesRewrittenPerCall"))
+		tokens = append(tokens, r.URL.Query().Get("rewriteToken"))
+	}
+
+	ExpectThat(tokens, ElementsAre("", "taco", "burrito"))
+}
+
+func (t *RewriteObjectTest) MissingToken() {
+	t.transport.responses = []string{
+		`{"done": false, "objectSize": "30", "totalBytesRewritten": "10"}`,
+	}
+
+	req := &RewriteObjectRequest{
+		SrcName: "foo",
+		DstName: "bar",
+	}
+
+	_, err := t.bucket.RewriteObject(t.ctx, req)
+	ExpectThat(err, Error(HasSubstr("no rewrite token")))
+}
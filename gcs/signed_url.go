@@ -0,0 +1,289 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// SigningCredentials identify a service account and hold its private key, for
+// use in signing URLs.
+type SigningCredentials struct {
+	// The service account's email address, e.g.
+	// "foo@my-project.iam.gserviceaccount.com".
+	GoogleAccessID string
+
+	// The service account's RSA private key, PEM-encoded in either PKCS #1 or
+	// PKCS #8 form.
+	PrivateKey []byte
+}
+
+// Extract signing credentials from the contents of a JSON key file for a
+// service account, as downloaded from the Cloud Console.
+func SigningCredentialsFromJSON(
+	jsonKey []byte) (creds *SigningCredentials, err error) {
+	var key struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+
+	if err = json.Unmarshal(jsonKey, &key); err != nil {
+		err = fmt.Errorf("json.Unmarshal: %v", err)
+		return
+	}
+
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		err = errors.New("Key file is missing client_email or private_key.")
+		return
+	}
+
+	creds = &SigningCredentials{
+		GoogleAccessID: key.ClientEmail,
+		PrivateKey:     []byte(key.PrivateKey),
+	}
+
+	return
+}
+
+// A parsed form of SigningCredentials.
+type urlSigner struct {
+	accessID string
+	key      *rsa.PrivateKey
+}
+
+func newURLSigner(creds *SigningCredentials) (s *urlSigner, err error) {
+	if creds.GoogleAccessID == "" {
+		err = errors.New("GoogleAccessID must be set.")
+		return
+	}
+
+	block, _ := pem.Decode(creds.PrivateKey)
+	if block == nil {
+		err = errors.New("PrivateKey doesn't contain a PEM block.")
+		return
+	}
+
+	// Try PKCS #8 first, since that's what key files contain.
+	var key *rsa.PrivateKey
+	if parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes); pkcs8Err == nil {
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			err = fmt.Errorf("PrivateKey is a %T, not an RSA key.", parsed)
+			return
+		}
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		err = fmt.Errorf("Parsing PrivateKey: %v", err)
+		return
+	}
+
+	s = &urlSigner{
+		accessID: creds.GoogleAccessID,
+		key:      key,
+	}
+
+	return
+}
+
+// Escape everything other than RFC 3986 unreserved characters, as required
+// for each component of the canonical request.
+func escapeV4(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// Produce a V4 signed URL for the supplied request against the named bucket,
+// as if it were now the given time.
+//
+// The process is described here:
+//
+//     https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+//
+func (s *urlSigner) sign(
+	bucketName string,
+	req *SignedURLRequest,
+	now time.Time) (signed string, err error) {
+	// Validate the request.
+	if req.Name == "" {
+		err = errors.New("Name must be specified.")
+		return
+	}
+
+	if req.Method == "" {
+		err = errors.New("Method must be specified.")
+		return
+	}
+
+	if req.Expiry < time.Second || req.Expiry > MaxSignedURLExpiry {
+		err = fmt.Errorf(
+			"Expiry must be between one second and %v.",
+			MaxSignedURLExpiry)
+		return
+	}
+
+	now = now.UTC()
+	timestamp := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/auto/storage/goog4_request", now.Format("20060102"))
+
+	// Build the canonical URI. Slashes in the object name are left alone.
+	const host = "storage.googleapis.com"
+
+	var segments []string
+	for _, seg := range strings.Split(req.Name, "/") {
+		segments = append(segments, escapeV4(seg))
+	}
+
+	canonicalURI := fmt.Sprintf(
+		"/%s/%s",
+		escapeV4(bucketName),
+		strings.Join(segments, "/"))
+
+	// Gather the headers to be signed, which must include the host.
+	headers := map[string]string{
+		"host": host,
+	}
+
+	if req.ContentType != "" {
+		headers["content-type"] = req.ContentType
+	}
+
+	for k, v := range req.Headers {
+		headers[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+
+	var headerNames []string
+	for k := range headers {
+		headerNames = append(headerNames, k)
+	}
+
+	sort.Strings(headerNames)
+
+	var canonicalHeaders string
+	for _, k := range headerNames {
+		canonicalHeaders += fmt.Sprintf("%s:%s\n", k, headers[k])
+	}
+
+	signedHeaders := strings.Join(headerNames, ";")
+
+	// Build the canonical query string, which includes the caller's parameters
+	// along with those describing the signature.
+	query := make(url.Values)
+	for k, vs := range req.QueryParameters {
+		query[k] = append([]string(nil), vs...)
+	}
+
+	query.Set("X-Goog-Algorithm", "GOOG4-RSA-SHA256")
+	query.Set("X-Goog-Credential", fmt.Sprintf("%s/%s", s.accessID, scope))
+	query.Set("X-Goog-Date", timestamp)
+	query.Set("X-Goog-Expires", fmt.Sprint(int64(req.Expiry/time.Second)))
+	query.Set("X-Goog-SignedHeaders", signedHeaders)
+
+	var queryKeys []string
+	for k := range query {
+		queryKeys = append(queryKeys, k)
+	}
+
+	sort.Strings(queryKeys)
+
+	var queryParts []string
+	for _, k := range queryKeys {
+		for _, v := range query[k] {
+			queryParts = append(queryParts, escapeV4(k)+"="+escapeV4(v))
+		}
+	}
+
+	canonicalQuery := strings.Join(queryParts, "&")
+
+	// Assemble the canonical request and the string to sign.
+	canonicalRequest := strings.Join(
+		[]string{
+			req.Method,
+			canonicalURI,
+			canonicalQuery,
+			canonicalHeaders,
+			signedHeaders,
+			"UNSIGNED-PAYLOAD",
+		},
+		"\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join(
+		[]string{
+			"GOOG4-RSA-SHA256",
+			timestamp,
+			scope,
+			hex.EncodeToString(requestHash[:]),
+		},
+		"\n")
+
+	// Sign.
+	digest := sha256.Sum256([]byte(stringToSign))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		err = fmt.Errorf("rsa.SignPKCS1v15: %v", err)
+		return
+	}
+
+	signed = fmt.Sprintf(
+		"https://%s%s?%s&X-Goog-Signature=%s",
+		host,
+		canonicalURI,
+		canonicalQuery,
+		hex.EncodeToString(sig))
+
+	return
+}
+
+func (b *bucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
+	if b.signer == nil {
+		err = errors.New(
+			"No credentials for signing URLs; see ConnConfig.SigningCredentials.")
+		return
+	}
+
+	// Requests made with the URL must also name the project to bill, if any.
+	if b.userProject != "" && req.QueryParameters.Get("userProject") == "" {
+		mReq := *req
+		mReq.QueryParameters = make(url.Values)
+		for k, vs := range req.QueryParameters {
+			mReq.QueryParameters[k] = vs
+		}
+
+		mReq.QueryParameters.Set("userProject", b.userProject)
+		req = &mReq
+	}
+
+	signed, err = b.signer.sign(b.name, req, time.Now())
+	return
+}
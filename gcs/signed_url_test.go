@@ -0,0 +1,275 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestSignedURL(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+const signedURLTestAccessID = "foo@some-project.iam.gserviceaccount.com"
+
+// Create a signer with a freshly generated key.
+func newTestURLSigner() (key *rsa.PrivateKey, signer *urlSigner) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	AssertEq(nil, err)
+
+	signer, err = newURLSigner(&SigningCredentials{
+		GoogleAccessID: signedURLTestAccessID,
+		PrivateKey: pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}),
+	})
+
+	AssertEq(nil, err)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type SignedURLTest struct {
+	key    *rsa.PrivateKey
+	signer *urlSigner
+	now    time.Time
+}
+
+var _ SetUpInterface = &SignedURLTest{}
+
+func init() { RegisterTestSuite(&SignedURLTest{}) }
+
+func (t *SignedURLTest) SetUp(ti *TestInfo) {
+	t.key, t.signer = newTestURLSigner()
+	t.now = time.Date(2019, 2, 1, 9, 0, 0, 0, time.UTC)
+}
+
+// Split a signed URL into the part that was signed and the signature, and
+// check that the signature is valid for the supplied canonical request.
+func (t *SignedURLTest) checkSignature(
+	signed string,
+	canonicalRequest string) (unsigned string) {
+	const sigParam = "&X-Goog-Signature="
+	i := strings.LastIndex(signed, sigParam)
+	AssertGe(i, 0, "%s", signed)
+
+	unsigned = signed[:i]
+	sig, err := hex.DecodeString(signed[i+len(sigParam):])
+	AssertEq(nil, err)
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join(
+		[]string{
+			"GOOG4-RSA-SHA256",
+			"20190201T090000Z",
+			"20190201/auto/storage/goog4_request",
+			hex.EncodeToString(requestHash[:]),
+		},
+		"\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	err = rsa.VerifyPKCS1v15(&t.key.PublicKey, crypto.SHA256, digest[:], sig)
+	ExpectEq(nil, err)
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *SignedURLTest) MissingFields() {
+	var err error
+
+	_, err = t.signer.sign(
+		"some-bucket",
+		&SignedURLRequest{Method: "GET", Expiry: time.Hour},
+		t.now)
+
+	ExpectThat(err, Error(HasSubstr("Name")))
+
+	_, err = t.signer.sign(
+		"some-bucket",
+		&SignedURLRequest{Name: "foo", Expiry: time.Hour},
+		t.now)
+
+	ExpectThat(err, Error(HasSubstr("Method")))
+}
+
+func (t *SignedURLTest) BadExpiry() {
+	var err error
+
+	_, err = t.signer.sign(
+		"some-bucket",
+		&SignedURLRequest{Name: "foo", Method: "GET"},
+		t.now)
+
+	ExpectThat(err, Error(HasSubstr("Expiry")))
+
+	_, err = t.signer.sign(
+		"some-bucket",
+		&SignedURLRequest{Name: "foo", Method: "GET", Expiry: time.Millisecond},
+		t.now)
+
+	ExpectThat(err, Error(HasSubstr("Expiry")))
+
+	_, err = t.signer.sign(
+		"some-bucket",
+		&SignedURLRequest{
+			Name:   "foo",
+			Method: "GET",
+			Expiry: MaxSignedURLExpiry + time.Second,
+		},
+		t.now)
+
+	ExpectThat(err, Error(HasSubstr("Expiry")))
+}
+
+func (t *SignedURLTest) SimpleGet() {
+	signed, err := t.signer.sign(
+		"some-bucket",
+		&SignedURLRequest{
+			Name:   "foo/bar baz.txt",
+			Method: "GET",
+			Expiry: time.Hour,
+		},
+		t.now)
+
+	AssertEq(nil, err)
+
+	const query = "X-Goog-Algorithm=GOOG4-RSA-SHA256" +
+		"&X-Goog-Credential=foo%40some-project.iam.gserviceaccount.com" +
+		"%2F20190201%2Fauto%2Fstorage%2Fgoog4_request" +
+		"&X-Goog-Date=20190201T090000Z" +
+		"&X-Goog-Expires=3600" +
+		"&X-Goog-SignedHeaders=host"
+
+	canonicalRequest := strings.Join(
+		[]string{
+			"GET",
+			"/some-bucket/foo/bar%20baz.txt",
+			query,
+			"host:storage.googleapis.com\n",
+			"host",
+			"UNSIGNED-PAYLOAD",
+		},
+		"\n")
+
+	unsigned := t.checkSignature(signed, canonicalRequest)
+	ExpectEq(
+		"https://storage.googleapis.com/some-bucket/foo/bar%20baz.txt?"+query,
+		unsigned)
+}
+
+func (t *SignedURLTest) HeadersAndQueryParameters() {
+	signed, err := t.signer.sign(
+		"some-bucket",
+		&SignedURLRequest{
+			Name:        "foo",
+			Method:      "PUT",
+			Expiry:      time.Minute,
+			ContentType: "text/plain",
+			Headers: map[string]string{
+				"X-Goog-Meta-Taco": " burrito ",
+			},
+			QueryParameters: url.Values{
+				"response-content-disposition": []string{"attachment"},
+			},
+		},
+		t.now)
+
+	AssertEq(nil, err)
+
+	const query = "X-Goog-Algorithm=GOOG4-RSA-SHA256" +
+		"&X-Goog-Credential=foo%40some-project.iam.gserviceaccount.com" +
+		"%2F20190201%2Fauto%2Fstorage%2Fgoog4_request" +
+		"&X-Goog-Date=20190201T090000Z" +
+		"&X-Goog-Expires=60" +
+		"&X-Goog-SignedHeaders=content-type%3Bhost%3Bx-goog-meta-taco" +
+		"&response-content-disposition=attachment"
+
+	canonicalRequest := strings.Join(
+		[]string{
+			"PUT",
+			"/some-bucket/foo",
+			query,
+			"content-type:text/plain\n" +
+				"host:storage.googleapis.com\n" +
+				"x-goog-meta-taco:burrito\n",
+			"content-type;host;x-goog-meta-taco",
+			"UNSIGNED-PAYLOAD",
+		},
+		"\n")
+
+	unsigned := t.checkSignature(signed, canonicalRequest)
+	ExpectEq("https://storage.googleapis.com/some-bucket/foo?"+query, unsigned)
+}
+
+func (t *SignedURLTest) CredentialsFromJSON() {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(t.key)
+	AssertEq(nil, err)
+
+	jsonKey, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": signedURLTestAccessID,
+		"private_key": string(pem.EncodeToMemory(&pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: pkcs8,
+		})),
+	})
+
+	AssertEq(nil, err)
+
+	creds, err := SigningCredentialsFromJSON(jsonKey)
+	AssertEq(nil, err)
+	ExpectEq(signedURLTestAccessID, creds.GoogleAccessID)
+
+	signer, err := newURLSigner(creds)
+	AssertEq(nil, err)
+	ExpectEq(signedURLTestAccessID, signer.accessID)
+	ExpectEq(0, signer.key.N.Cmp(t.key.N))
+}
+
+func (t *SignedURLTest) NoCredentials() {
+	b := &bucket{name: "some-bucket"}
+
+	_, err := b.SignedURL(
+		context.Background(),
+		&SignedURLRequest{Name: "foo", Method: "GET", Expiry: time.Hour})
+
+	ExpectThat(err, Error(HasSubstr("SigningCredentials")))
+}
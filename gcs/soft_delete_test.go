@@ -0,0 +1,247 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestSoftDelete(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type SoftDeleteTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	conn      Conn
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &SoftDeleteTest{}
+
+func init() { RegisterTestSuite(&SoftDeleteTest{}) }
+
+func (t *SoftDeleteTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+
+	client := &http.Client{Transport: &t.transport}
+	t.conn = &conn{
+		client:    client,
+		userAgent: "test",
+	}
+
+	t.bucket = newBucket(
+		client,
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *SoftDeleteTest) GetBucket() {
+	t.transport.response = `{
+		"name": "some_bucket",
+		"softDeletePolicy": {
+			"retentionDurationSeconds": "604800",
+			"effectiveTime": "2024-03-01T12:00:00Z"
+		}
+	}`
+
+	bi, err := t.conn.GetBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	AssertNe(nil, bi.SoftDeletePolicy)
+	ExpectEq(7*24*time.Hour, bi.SoftDeletePolicy.RetentionDuration)
+	ExpectTrue(
+		bi.SoftDeletePolicy.EffectiveTime.Equal(
+			time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)),
+		"%v",
+		bi.SoftDeletePolicy.EffectiveTime)
+}
+
+func (t *SoftDeleteTest) GetBucket_Disabled() {
+	t.transport.response = `{
+		"name": "some_bucket",
+		"softDeletePolicy": {"retentionDurationSeconds": "0"}
+	}`
+
+	bi, err := t.conn.GetBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+	ExpectEq(nil, bi.SoftDeletePolicy)
+}
+
+func (t *SoftDeleteTest) SetPolicy() {
+	t.transport.response = `{
+		"name": "some_bucket",
+		"metageneration": "18",
+		"softDeletePolicy": {"retentionDurationSeconds": "864000"}
+	}`
+
+	precond := int64(17)
+	bi, err := t.conn.SetBucketSoftDeletePolicy(
+		t.ctx,
+		&SetBucketSoftDeletePolicyRequest{
+			BucketName:                 "some_bucket",
+			RetentionDuration:          10*24*time.Hour + time.Millisecond,
+			MetaGenerationPrecondition: &precond,
+		})
+
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("PATCH", httpReq.Method)
+	ExpectEq("//www.googleapis.com/storage/v1/b/some_bucket", httpReq.URL.Opaque)
+	ExpectEq("17", httpReq.URL.Query().Get("ifMetagenerationMatch"))
+
+	body, err := decodeBody(httpReq)
+	AssertEq(nil, err)
+	ExpectThat(
+		body["softDeletePolicy"],
+		DeepEquals(map[string]interface{}{"retentionDurationSeconds": "864000"}))
+
+	// Response
+	ExpectEq(18, bi.MetaGeneration)
+	AssertNe(nil, bi.SoftDeletePolicy)
+	ExpectEq(10*24*time.Hour, bi.SoftDeletePolicy.RetentionDuration)
+}
+
+func (t *SoftDeleteTest) ListSoftDeleted() {
+	t.transport.response = `{
+		"items": [{
+			"name": "foo",
+			"generation": "17",
+			"metageneration": "1",
+			"softDeleteTime": "2024-03-01T12:00:00Z",
+			"hardDeleteTime": "2024-03-08T12:00:00Z"
+		}]
+	}`
+
+	listing, err := t.bucket.ListObjects(
+		t.ctx,
+		&ListObjectsRequest{SoftDeleted: true})
+
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	ExpectEq("true", t.transport.requests[0].URL.Query().Get("softDeleted"))
+
+	// Response
+	AssertEq(1, len(listing.Objects))
+	o := listing.Objects[0]
+	ExpectEq("foo", o.Name)
+	ExpectEq(17, o.Generation)
+	ExpectTrue(
+		o.SoftDeleteTime.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)),
+		"%v",
+		o.SoftDeleteTime)
+	ExpectTrue(
+		o.HardDeleteTime.Equal(time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)),
+		"%v",
+		o.HardDeleteTime)
+}
+
+func (t *SoftDeleteTest) ListLiveObjects() {
+	t.transport.response = `{}`
+
+	_, err := t.bucket.ListObjects(t.ctx, &ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	ExpectEq("", t.transport.requests[0].URL.Query().Get("softDeleted"))
+}
+
+func (t *SoftDeleteTest) Restore() {
+	t.transport.response = `{
+		"name": "foo bar",
+		"generation": "19",
+		"metageneration": "1"
+	}`
+
+	precond := int64(0)
+	o, err := t.bucket.RestoreObject(
+		t.ctx,
+		&RestoreObjectRequest{
+			Name:                   "foo bar",
+			Generation:             17,
+			GenerationPrecondition: &precond,
+			CopySourceACL:          true,
+		})
+
+	AssertEq(nil, err)
+
+	// Request
+	AssertEq(1, len(t.transport.requests))
+	httpReq := t.transport.requests[0]
+	ExpectEq("POST", httpReq.Method)
+	ExpectEq(
+		"//www.googleapis.com/storage/v1/b/some_bucket/o/foo%20bar/restore",
+		httpReq.URL.Opaque)
+
+	query := httpReq.URL.Query()
+	ExpectEq("17", query.Get("generation"))
+	ExpectEq("0", query.Get("ifGenerationMatch"))
+	ExpectEq("true", query.Get("copySourceAcl"))
+
+	// Response
+	ExpectEq("foo bar", o.Name)
+	ExpectEq(19, o.Generation)
+}
+
+func (t *SoftDeleteTest) Restore_NotFound() {
+	t.transport.status = http.StatusNotFound
+	t.transport.response = `{"error": {"code": 404, "message": "Not Found"}}`
+
+	_, err := t.bucket.RestoreObject(
+		t.ctx,
+		&RestoreObjectRequest{Name: "foo", Generation: 17})
+
+	ExpectThat(err, HasSameTypeAs(&NotFoundError{}))
+
+	AssertEq(1, len(t.transport.requests))
+	ExpectEq("", t.transport.requests[0].URL.Query().Get("copySourceAcl"))
+}
+
+func (t *SoftDeleteTest) Restore_PreconditionFailed() {
+	t.transport.status = http.StatusPreconditionFailed
+	t.transport.response = `{"error": {"code": 412, "message": "Failed"}}`
+
+	precond := int64(0)
+	_, err := t.bucket.RestoreObject(
+		t.ctx,
+		&RestoreObjectRequest{
+			Name:                   "foo",
+			Generation:             17,
+			GenerationPrecondition: &precond,
+		})
+
+	ExpectThat(err, HasSameTypeAs(&PreconditionError{}))
+}
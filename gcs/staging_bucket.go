@@ -0,0 +1,229 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/net/context"
+)
+
+// Create a bucket whose CreateObject first copies req.Contents to a temporary
+// file in the supplied directory (or the default directory for temporary
+// files if empty), syncs it to disk, and only then calls through to the
+// wrapped bucket with the file as the contents. This protects long-running
+// producers of data from having to regenerate it if the upload fails: wrap a
+// bucket created with NewRetryBucket, and it will rewind and resend the file
+// rather than buffering the contents in memory.
+//
+// The CRC32C of the contents is computed while they're copied and, unless the
+// request already specifies one, passed to the wrapped bucket so that GCS
+// rejects an upload that doesn't match what was staged. The temporary file is
+// removed once CreateObject returns. All other methods call straight through.
+func NewStagingBucket(
+	wrapped Bucket,
+	dir string) (b Bucket) {
+	b = &stagingBucket{
+		wrapped: wrapped,
+		dir:     dir,
+	}
+
+	return
+}
+
+type stagingBucket struct {
+	wrapped Bucket
+	dir     string
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Copy the supplied contents to a new temporary file, syncing it and seeking
+// it back to the start. The caller must close and remove the file, which is
+// returned even if err is non-nil.
+func (b *stagingBucket) stage(
+	contents io.Reader) (f *os.File, crc32c uint32, err error) {
+	f, err = ioutil.TempFile(b.dir, "gcs_staging")
+	if err != nil {
+		err = fmt.Errorf("TempFile: %v", err)
+		return
+	}
+
+	hash := crc32.New(crc32cTable)
+	if _, err = io.Copy(io.MultiWriter(f, hash), contents); err != nil {
+		err = fmt.Errorf("Copying contents: %v", err)
+		return
+	}
+
+	if err = f.Sync(); err != nil {
+		err = fmt.Errorf("Sync: %v", err)
+		return
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		err = fmt.Errorf("Seek: %v", err)
+		return
+	}
+
+	crc32c = hash.Sum32()
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *stagingBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *stagingBucket) NewReader(
+	ctx context.Context,
+	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	rc, err = b.wrapped.NewReader(ctx, req)
+	return
+}
+
+func (b *stagingBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	f, crc32c, err := b.stage(req.Contents)
+	if f != nil {
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+	}
+
+	if err != nil {
+		return
+	}
+
+	// Call through with the staged contents.
+	reqCopy := *req
+	reqCopy.Contents = f
+	// The checksum is of the uncompressed contents, so it can't be used if the
+	// wrapped bucket is to compress them.
+	if reqCopy.CRC32C == nil && !reqCopy.Compress {
+		reqCopy.CRC32C = &crc32c
+	}
+
+	o, err = b.wrapped.CreateObject(ctx, &reqCopy)
+	return
+}
+
+func (b *stagingBucket) CopyObject(
+	ctx context.Context,
+	req *CopyObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *stagingBucket) MoveObject(
+	ctx context.Context,
+	req *MoveObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.MoveObject(ctx, req)
+	return
+}
+
+func (b *stagingBucket) ComposeObjects(
+	ctx context.Context,
+	req *ComposeObjectsRequest) (o *Object, err error) {
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *stagingBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	return
+}
+
+func (b *stagingBucket) StatObject(
+	ctx context.Context,
+	req *StatObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *stagingBucket) ListObjects(
+	ctx context.Context,
+	req *ListObjectsRequest) (listing *Listing, err error) {
+	listing, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *stagingBucket) UpdateObject(
+	ctx context.Context,
+	req *UpdateObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *stagingBucket) DeleteObject(
+	ctx context.Context,
+	req *DeleteObjectRequest) (err error) {
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+func (b *stagingBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	o, err = b.wrapped.RestoreObject(ctx, req)
+	return
+}
+
+func (b *stagingBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	results, err = b.wrapped.Batch(ctx, req)
+	return
+}
+
+func (b *stagingBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *stagingBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	rule, err = b.wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *stagingBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	err = b.wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *stagingBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestStagingBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that records the CreateObject requests it sees, failing the given
+// number of them after reading some of their contents.
+type flakyCreateBucket struct {
+	gcs.Bucket
+	failures int
+	reqs     []gcs.CreateObjectRequest
+}
+
+func (b *flakyCreateBucket) CreateObject(
+	ctx context.Context,
+	req *gcs.CreateObjectRequest) (o *gcs.Object, err error) {
+	b.reqs = append(b.reqs, *req)
+	if b.failures > 0 {
+		b.failures--
+		io.ReadFull(req.Contents, make([]byte, 2))
+		err = io.ErrUnexpectedEOF
+		return
+	}
+
+	o, err = b.Bucket.CreateObject(ctx, req)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type StagingBucketTest struct {
+	ctx     context.Context
+	dir     string
+	wrapped flakyCreateBucket
+	bucket  gcs.Bucket
+}
+
+var _ SetUpInterface = &StagingBucketTest{}
+var _ TearDownInterface = &StagingBucketTest{}
+
+func init() { RegisterTestSuite(&StagingBucketTest{}) }
+
+func (t *StagingBucketTest) SetUp(ti *TestInfo) {
+	var err error
+
+	t.ctx = ti.Ctx
+	t.dir, err = ioutil.TempDir("", "staging_bucket_test")
+	AssertEq(nil, err)
+
+	t.wrapped.Bucket = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+
+	// Staging outside of retries, so that retries resend the staged file.
+	t.bucket = gcs.NewStagingBucket(
+		gcs.NewRetryBucket(&t.wrapped, gcs.RetryPolicy{MaxSleep: time.Second}),
+		t.dir)
+}
+
+func (t *StagingBucketTest) TearDown() {
+	os.RemoveAll(t.dir)
+}
+
+func (t *StagingBucketTest) stagingDirEntries() []string {
+	entries, err := ioutil.ReadDir(t.dir)
+	AssertEq(nil, err)
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	return names
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *StagingBucketTest) RetriesFromStagedFile() {
+	t.wrapped.failures = 1
+
+	// Contents that can't be rewound.
+	o, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: iotest.OneByteReader(strings.NewReader("tacoburrito")),
+		})
+
+	AssertEq(nil, err)
+	ExpectEq(len("tacoburrito"), o.Size)
+
+	contents, err := gcsutil.ReadObject(t.ctx, t.bucket, "foo")
+	AssertEq(nil, err)
+	ExpectEq("tacoburrito", string(contents))
+
+	// The wrapped bucket should have seen the staged file, with its checksum.
+	AssertEq(2, len(t.wrapped.reqs))
+	for _, req := range t.wrapped.reqs {
+		ExpectThat(req.Contents, HasSameTypeAs(&os.File{}))
+		AssertNe(nil, req.CRC32C)
+		ExpectEq(o.CRC32C, *req.CRC32C)
+	}
+
+	// The file should be gone.
+	ExpectThat(t.stagingDirEntries(), ElementsAre())
+}
+
+func (t *StagingBucketTest) SuppliedChecksumIsPreserved() {
+	crc32c := uint32(17)
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+			CRC32C:   &crc32c,
+		})
+
+	ExpectThat(err, Error(HasSubstr("CRC32C")))
+	AssertEq(1, len(t.wrapped.reqs))
+	ExpectEq(&crc32c, t.wrapped.reqs[0].CRC32C)
+}
+
+func (t *StagingBucketTest) ErrorReadingContents() {
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name: "foo",
+			Contents: io.MultiReader(
+				strings.NewReader("taco"),
+				iotest.ErrReader(errors.New("taco")),
+			),
+		})
+
+	ExpectThat(err, Error(HasSubstr("Copying contents")))
+	ExpectEq(0, len(t.wrapped.reqs))
+	ExpectThat(t.stagingDirEntries(), ElementsAre())
+}
@@ -0,0 +1,181 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestStatObject(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type StatObjectTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &StatObjectTest{}
+
+func init() { RegisterTestSuite(&StatObjectTest{}) }
+
+func (t *StatObjectTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.bucket = newBucket(
+		&http.Client{Transport: &t.transport},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *StatObjectTest) NoFields() {
+	t.transport.response = `{"name": "foo", "crc32c": "AAAAAA=="}`
+
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("full", query.Get("projection"))
+	_, ok := query["fields"]
+	ExpectFalse(ok)
+}
+
+func (t *StatObjectTest) Fields() {
+	t.transport.response = `{"name": "foo", "generation": "17"}`
+
+	req := &StatObjectRequest{
+		Name:   "foo",
+		Fields: []string{"generation", "metageneration"},
+	}
+
+	o, err := t.bucket.StatObject(t.ctx, req)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("name,generation,metageneration", query.Get("fields"))
+
+	ExpectEq("foo", o.Name)
+	ExpectEq(17, o.Generation)
+	ExpectEq(0, o.CRC32C)
+	ExpectEq(nil, o.MD5)
+}
+
+func (t *StatObjectTest) FieldsIncludingName() {
+	t.transport.response = `{"name": "foo", "size": "3"}`
+
+	req := &StatObjectRequest{
+		Name:   "foo",
+		Fields: []string{"size", "name"},
+	}
+
+	o, err := t.bucket.StatObject(t.ctx, req)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("size,name", query.Get("fields"))
+	ExpectEq(3, o.Size)
+}
+
+func (t *StatObjectTest) MalformedCRC32C() {
+	t.transport.response = `{"name": "foo", "crc32c": "AAAA"}`
+
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	ExpectThat(err, Error(HasSubstr("Crc32c")))
+}
+
+func (t *StatObjectTest) Preconditions() {
+	t.transport.response = `{"name": "foo", "crc32c": "AAAAAA=="}`
+
+	var gen int64 = 17
+	var metaGen int64 = 19
+	req := &StatObjectRequest{
+		Name:                       "foo",
+		GenerationPrecondition:     &gen,
+		MetaGenerationPrecondition: &metaGen,
+	}
+
+	_, err := t.bucket.StatObject(t.ctx, req)
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("17", query.Get("ifGenerationMatch"))
+	ExpectEq("19", query.Get("ifMetagenerationMatch"))
+}
+
+func (t *StatObjectTest) PreconditionFailed() {
+	t.transport.status = http.StatusPreconditionFailed
+	t.transport.response = `{"error": {"code": 412, "message": "Precondition Failed"}}`
+
+	var gen int64 = 17
+	req := &StatObjectRequest{
+		Name:                   "foo",
+		GenerationPrecondition: &gen,
+	}
+
+	_, err := t.bucket.StatObject(t.ctx, req)
+	ExpectThat(err, HasSameTypeAs(&PreconditionError{}))
+}
+
+func (t *StatObjectTest) NewReaderPreconditions() {
+	var gen int64 = 17
+	var metaGen int64 = 19
+	req := &ReadObjectRequest{
+		Name:                       "foo",
+		GenerationPrecondition:     &gen,
+		MetaGenerationPrecondition: &metaGen,
+	}
+
+	rc, err := t.bucket.NewReader(t.ctx, req)
+	AssertEq(nil, err)
+	AssertEq(nil, rc.Close())
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("17", query.Get("ifGenerationMatch"))
+	ExpectEq("19", query.Get("ifMetagenerationMatch"))
+}
+
+func (t *StatObjectTest) NewReaderPreconditionFailed() {
+	t.transport.status = http.StatusPreconditionFailed
+	t.transport.response = `{"error": {"code": 412, "message": "Precondition Failed"}}`
+
+	var metaGen int64 = 19
+	req := &ReadObjectRequest{
+		Name:                       "foo",
+		MetaGenerationPrecondition: &metaGen,
+	}
+
+	_, err := t.bucket.NewReader(t.ctx, req)
+	ExpectThat(err, HasSameTypeAs(&PreconditionError{}))
+}
@@ -0,0 +1,153 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A Throttle limits the rate at which some resource, for example bytes or
+// operations, is consumed.
+//
+// Implementations must be safe for concurrent access.
+type Throttle interface {
+	// Return the maximum number of tokens that may be requested by a single
+	// call to Wait.
+	Capacity() uint64
+
+	// Block until the given number of tokens, which must be no more than
+	// Capacity(), are available and then consume them. Return an error without
+	// consuming anything if the context is cancelled first.
+	Wait(ctx context.Context, tokens uint64) error
+}
+
+// Create a token bucket throttle that accrues tokens at the given rate per
+// second, up to the given capacity. The bucket starts full, so bursts of up to
+// capacity tokens are permitted.
+func NewThrottle(
+	rateHz float64,
+	capacity uint64) (t Throttle) {
+	if !(rateHz > 0) || math.IsInf(rateHz, 1) {
+		panic(fmt.Sprintf("Illegal rate: %f", rateHz))
+	}
+
+	if capacity == 0 {
+		panic("Capacity must be positive.")
+	}
+
+	t = &tokenBucket{
+		rateHz:    rateHz,
+		capacity:  capacity,
+		available: float64(capacity),
+		last:      time.Now(),
+	}
+
+	return
+}
+
+type tokenBucket struct {
+	/////////////////////////
+	// Constant data
+	/////////////////////////
+
+	rateHz   float64
+	capacity uint64
+
+	/////////////////////////
+	// Mutable state
+	/////////////////////////
+
+	mu sync.Mutex
+
+	// The number of tokens available as of the time last.
+	//
+	// INVARIANT: 0 <= available <= float64(capacity)
+	//
+	// GUARDED_BY(mu)
+	available float64
+
+	// GUARDED_BY(mu)
+	last time.Time
+}
+
+func (tb *tokenBucket) Capacity() uint64 {
+	return tb.capacity
+}
+
+func (tb *tokenBucket) Wait(
+	ctx context.Context,
+	tokens uint64) (err error) {
+	if tokens > tb.capacity {
+		err = fmt.Errorf(
+			"Requested %d tokens, more than capacity %d",
+			tokens,
+			tb.capacity)
+		return
+	}
+
+	for {
+		// Take the tokens if they're there. Otherwise figure out how long until
+		// they will be.
+		delay := tb.tryTake(float64(tokens), time.Now())
+		if delay == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Refill the bucket as of the given time, then consume the given number of
+// tokens if available. If not, return how long the caller should wait for
+// them.
+//
+// LOCKS_EXCLUDED(tb.mu)
+func (tb *tokenBucket) tryTake(
+	tokens float64,
+	now time.Time) (delay time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.available += elapsed.Seconds() * tb.rateHz
+		if tb.available > float64(tb.capacity) {
+			tb.available = float64(tb.capacity)
+		}
+
+		tb.last = now
+	}
+
+	if tb.available >= tokens {
+		tb.available -= tokens
+		return
+	}
+
+	// Round up so that we don't wake a hair too early and spin.
+	seconds := (tokens - tb.available) / tb.rateHz
+	delay = time.Duration(math.Ceil(seconds * float64(time.Second)))
+
+	return
+}
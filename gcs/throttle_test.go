@@ -0,0 +1,181 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/oglemock"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestThrottle(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Token bucket
+////////////////////////////////////////////////////////////////////////
+
+type TokenBucketTest struct {
+	start time.Time
+	tb    *tokenBucket
+}
+
+var _ SetUpInterface = &TokenBucketTest{}
+
+func init() { RegisterTestSuite(&TokenBucketTest{}) }
+
+func (t *TokenBucketTest) SetUp(ti *TestInfo) {
+	t.tb = NewThrottle(10, 20).(*tokenBucket)
+	t.start = t.tb.last
+}
+
+func (t *TokenBucketTest) StartsFull() {
+	ExpectEq(0, t.tb.tryTake(20, t.start))
+	ExpectEq(100*time.Millisecond, t.tb.tryTake(1, t.start))
+}
+
+func (t *TokenBucketTest) Refills() {
+	AssertEq(0, t.tb.tryTake(20, t.start))
+
+	// Half a second later, five tokens have accrued.
+	now := t.start.Add(500 * time.Millisecond)
+	ExpectEq(0, t.tb.tryTake(5, now))
+	ExpectEq(200*time.Millisecond, t.tb.tryTake(2, now))
+}
+
+func (t *TokenBucketTest) RefillIsCappedAtCapacity() {
+	AssertEq(0, t.tb.tryTake(20, t.start))
+
+	now := t.start.Add(time.Hour)
+	ExpectEq(0, t.tb.tryTake(20, now))
+	ExpectEq(100*time.Millisecond, t.tb.tryTake(1, now))
+}
+
+func (t *TokenBucketTest) RequestExceedsCapacity() {
+	err := t.tb.Wait(context.Background(), 21)
+	ExpectThat(err, Error(HasSubstr("capacity")))
+}
+
+func (t *TokenBucketTest) Cancellation() {
+	AssertEq(0, t.tb.tryTake(20, time.Now()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	err := t.tb.Wait(ctx, 20)
+	ExpectTrue(err == context.DeadlineExceeded, "err: %v", err)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Throttled bucket
+////////////////////////////////////////////////////////////////////////
+
+// A throttle that records what was asked of it.
+type recordingThrottle struct {
+	capacity uint64
+	waits    []uint64
+}
+
+func (rt *recordingThrottle) Capacity() uint64 {
+	return rt.capacity
+}
+
+func (rt *recordingThrottle) Wait(ctx context.Context, tokens uint64) error {
+	rt.waits = append(rt.waits, tokens)
+	return nil
+}
+
+type ThrottledBucketTest struct {
+	ctx     context.Context
+	wrapped MockBucket
+	read    recordingThrottle
+	write   recordingThrottle
+	op      recordingThrottle
+	bucket  Bucket
+}
+
+var _ SetUpInterface = &ThrottledBucketTest{}
+
+func init() { RegisterTestSuite(&ThrottledBucketTest{}) }
+
+func (t *ThrottledBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.wrapped = NewMockBucket(ti.MockController, "wrapped")
+	t.read.capacity = 3
+	t.write.capacity = 2
+	t.op.capacity = 1
+	t.bucket = NewThrottledBucket(t.wrapped, &t.read, &t.write, &t.op)
+}
+
+func (t *ThrottledBucketTest) CreateObject() {
+	ExpectCall(t.wrapped, "CreateObject")(Any(), contentsAre("taco")).
+		WillOnce(Return(&Object{}, nil))
+
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+		})
+
+	AssertEq(nil, err)
+	ExpectThat(t.op.waits, ElementsAre(1))
+
+	// Each read is truncated to the write throttle's capacity.
+	AssertGe(len(t.write.waits), 2)
+	ExpectThat(t.write.waits[:2], ElementsAre(2, 2))
+	ExpectEq(0, len(t.read.waits))
+}
+
+func (t *ThrottledBucketTest) NewReader() {
+	rc := &readSeekCloser{
+		ReadCloser: ioutil.NopCloser(strings.NewReader("burrito")),
+	}
+
+	ExpectCall(t.wrapped, "NewReader")(Any(), Any()).
+		WillOnce(Return(rc, nil))
+
+	throttled, err := t.bucket.NewReader(
+		t.ctx,
+		&ReadObjectRequest{Name: "foo"})
+
+	AssertEq(nil, err)
+
+	b, err := ioutil.ReadAll(throttled)
+	AssertEq(nil, err)
+	ExpectEq("burrito", string(b))
+	AssertEq(nil, throttled.Close())
+
+	ExpectThat(t.op.waits, ElementsAre(1))
+
+	AssertGe(len(t.read.waits), 3)
+	ExpectThat(t.read.waits[:3], ElementsAre(3, 3, 3))
+	ExpectEq(0, len(t.write.waits))
+}
+
+func (t *ThrottledBucketTest) StatObject() {
+	ExpectCall(t.wrapped, "StatObject")(Any(), Any()).
+		WillOnce(Return(&Object{}, nil))
+
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	ExpectThat(t.op.waits, ElementsAre(1))
+}
@@ -0,0 +1,388 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// Create a bucket that limits the rate at which the wrapped bucket is used:
+//
+//  *  Bytes of object contents read via NewReader are limited by readThrottle.
+//
+//  *  Bytes of object contents consumed by CreateObject are limited by
+//     writeThrottle.
+//
+//  *  Each call to a method that may contact GCS consumes one token from
+//     opThrottle before calling through.
+//
+// Any of the throttles may be nil, meaning no limit. Waits for throttles are
+// subject to the context for the relevant call.
+func NewThrottledBucket(
+	wrapped Bucket,
+	readThrottle Throttle,
+	writeThrottle Throttle,
+	opThrottle Throttle) (b Bucket) {
+	b = &throttledBucket{
+		wrapped:       wrapped,
+		readThrottle:  readThrottle,
+		writeThrottle: writeThrottle,
+		opThrottle:    opThrottle,
+	}
+
+	return
+}
+
+type throttledBucket struct {
+	wrapped       Bucket
+	readThrottle  Throttle
+	writeThrottle Throttle
+	opThrottle    Throttle
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+func (b *throttledBucket) waitForOp(ctx context.Context) (err error) {
+	if b.opThrottle == nil {
+		return
+	}
+
+	err = b.opThrottle.Wait(ctx, 1)
+	return
+}
+
+// A reader that waits for a throttle before each read, charging it for the
+// size of the read. Reads are truncated to the throttle's capacity.
+type throttledReader struct {
+	ctx      context.Context
+	throttle Throttle
+	wrapped  io.Reader
+}
+
+func (tr *throttledReader) Read(p []byte) (n int, err error) {
+	if c := tr.throttle.Capacity(); uint64(len(p)) > c {
+		p = p[:c]
+	}
+
+	if err = tr.throttle.Wait(tr.ctx, uint64(len(p))); err != nil {
+		return
+	}
+
+	n, err = tr.wrapped.Read(p)
+	return
+}
+
+// A throttledReader for contents that support seeking, preserving the ability
+// of the wrapped bucket to rewind them.
+type throttledReadSeeker struct {
+	throttledReader
+	seeker io.Seeker
+}
+
+func (trs *throttledReadSeeker) Seek(
+	offset int64,
+	whence int) (n int64, err error) {
+	n, err = trs.seeker.Seek(offset, whence)
+	return
+}
+
+// A throttledReadSeeker for contents that can also be read at arbitrary
+// offsets, preserving the ability of the wrapped bucket to send them without
+// buffering. Reads with ReadAt are throttled too.
+type throttledReaderAt struct {
+	throttledReadSeeker
+	ra sizedReaderAt
+}
+
+func (tra *throttledReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	// Unlike Read, ReadAt may not return less than was asked for without an
+	// error, so wait for the throttle a piece at a time.
+	for len(p) > 0 && err == nil {
+		piece := p
+		if c := tra.throttle.Capacity(); uint64(len(piece)) > c {
+			piece = piece[:c]
+		}
+
+		if err = tra.throttle.Wait(tra.ctx, uint64(len(piece))); err != nil {
+			return
+		}
+
+		var m int
+		m, err = tra.ra.ReadAt(piece, off)
+		n += m
+		off += int64(m)
+		p = p[m:]
+	}
+
+	return
+}
+
+func (tra *throttledReaderAt) Size() int64 {
+	return tra.ra.Size()
+}
+
+// A throttled object reader.
+type throttledReadSeekCloser struct {
+	throttledReader
+	object ReadSeekCloser
+}
+
+func (trsc *throttledReadSeekCloser) Seek(
+	offset int64,
+	whence int) (n int64, err error) {
+	n, err = trsc.object.Seek(offset, whence)
+	return
+}
+
+func (trsc *throttledReadSeekCloser) Close() (err error) {
+	err = trsc.object.Close()
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *throttledBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *throttledBucket) NewReader(
+	ctx context.Context,
+	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	rc, err = b.wrapped.NewReader(ctx, req)
+	if err != nil || b.readThrottle == nil {
+		return
+	}
+
+	rc = &throttledReadSeekCloser{
+		throttledReader: throttledReader{
+			ctx:      ctx,
+			throttle: b.readThrottle,
+			wrapped:  rc,
+		},
+		object: rc,
+	}
+
+	return
+}
+
+func (b *throttledBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	// Throttle the contents as they're consumed.
+	if b.writeThrottle != nil {
+		var ra sizedReaderAt
+		if ra, err = sizedContents(req.Contents); err != nil {
+			return
+		}
+
+		mReq := *req
+		tr := throttledReader{
+			ctx:      ctx,
+			throttle: b.writeThrottle,
+			wrapped:  req.Contents,
+		}
+
+		seeker, isSeeker := req.Contents.(io.Seeker)
+		switch {
+		case ra != nil && isSeeker:
+			mReq.Contents = &throttledReaderAt{
+				throttledReadSeeker: throttledReadSeeker{
+					throttledReader: tr,
+					seeker:          seeker,
+				},
+				ra: ra,
+			}
+
+		case isSeeker:
+			mReq.Contents = &throttledReadSeeker{
+				throttledReader: tr,
+				seeker:          seeker,
+			}
+
+		default:
+			mReq.Contents = &tr
+		}
+
+		req = &mReq
+	}
+
+	o, err = b.wrapped.CreateObject(ctx, req)
+	return
+}
+
+func (b *throttledBucket) CopyObject(
+	ctx context.Context,
+	req *CopyObjectRequest) (o *Object, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *throttledBucket) MoveObject(
+	ctx context.Context,
+	req *MoveObjectRequest) (o *Object, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.MoveObject(ctx, req)
+	return
+}
+
+func (b *throttledBucket) ComposeObjects(
+	ctx context.Context,
+	req *ComposeObjectsRequest) (o *Object, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *throttledBucket) StatObject(
+	ctx context.Context,
+	req *StatObjectRequest) (o *Object, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *throttledBucket) ListObjects(
+	ctx context.Context,
+	req *ListObjectsRequest) (listing *Listing, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	listing, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *throttledBucket) UpdateObject(
+	ctx context.Context,
+	req *UpdateObjectRequest) (o *Object, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *throttledBucket) DeleteObject(
+	ctx context.Context,
+	req *DeleteObjectRequest) (err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+func (b *throttledBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.RestoreObject(ctx, req)
+	return
+}
+
+func (b *throttledBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *throttledBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	rule, err = b.wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *throttledBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	err = b.wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *throttledBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	return
+}
+
+func (b *throttledBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	if err = b.waitForOp(ctx); err != nil {
+		return
+	}
+
+	results, err = b.wrapped.Batch(ctx, req)
+	return
+}
+
+// SignedURL doesn't contact GCS, so it isn't throttled.
+func (b *throttledBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
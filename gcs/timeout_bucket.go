@@ -0,0 +1,268 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Default deadlines for the operations of a bucket returned by
+// NewTimeoutBucket. A zero duration means no default.
+type TimeoutPolicy struct {
+	// The deadline for operations on metadata alone: statting, listing,
+	// updating, and deleting objects, managing their ACLs, and batches of such
+	// operations.
+	Metadata time.Duration
+
+	// The deadline for operations that transfer object contents: reading
+	// objects (until the reader is closed), creating them, and copying,
+	// moving, composing, and rewriting them within GCS.
+	Transfer time.Duration
+}
+
+// Create a bucket that applies the deadlines of the supplied policy to calls
+// whose context doesn't already have a deadline, so that callers who forget to
+// set one don't hang forever on a stalled connection. Deadlines set by the
+// caller, whether shorter or longer, are left alone.
+//
+// A deadline covers the whole of the call to the wrapped bucket, including
+// any retries it makes.
+func NewTimeoutBucket(
+	wrapped Bucket,
+	policy TimeoutPolicy) (b Bucket) {
+	b = &timeoutBucket{
+		policy:  policy,
+		wrapped: wrapped,
+	}
+
+	return
+}
+
+type timeoutBucket struct {
+	policy  TimeoutPolicy
+	wrapped Bucket
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// Return a context with the supplied timeout if ctx has no deadline and the
+// timeout is non-zero, otherwise ctx itself. The caller must call cancel when
+// the operation is complete.
+func withDefaultTimeout(
+	ctx context.Context,
+	timeout time.Duration) (
+	newCtx context.Context,
+	cancel context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || timeout == 0 {
+		newCtx = ctx
+		cancel = func() {}
+		return
+	}
+
+	newCtx, cancel = context.WithTimeout(ctx, timeout)
+	return
+}
+
+// An object reader that cancels the context of its read when closed.
+type timeoutReadSeekCloser struct {
+	ReadSeekCloser
+	cancel context.CancelFunc
+}
+
+func (rc *timeoutReadSeekCloser) Close() (err error) {
+	err = rc.ReadSeekCloser.Close()
+	rc.cancel()
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *timeoutBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *timeoutBucket) NewReader(
+	ctx context.Context,
+	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Transfer)
+
+	rc, err = b.wrapped.NewReader(ctx, req)
+	if err != nil {
+		cancel()
+		return
+	}
+
+	// The deadline continues to apply to reads until the reader is closed.
+	rc = &timeoutReadSeekCloser{
+		ReadSeekCloser: rc,
+		cancel:         cancel,
+	}
+
+	return
+}
+
+func (b *timeoutBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Transfer)
+	defer cancel()
+
+	o, err = b.wrapped.CreateObject(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) CopyObject(
+	ctx context.Context,
+	req *CopyObjectRequest) (o *Object, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Transfer)
+	defer cancel()
+
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) MoveObject(
+	ctx context.Context,
+	req *MoveObjectRequest) (o *Object, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Transfer)
+	defer cancel()
+
+	o, err = b.wrapped.MoveObject(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) ComposeObjects(
+	ctx context.Context,
+	req *ComposeObjectsRequest) (o *Object, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Transfer)
+	defer cancel()
+
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Transfer)
+	defer cancel()
+
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) StatObject(
+	ctx context.Context,
+	req *StatObjectRequest) (o *Object, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Metadata)
+	defer cancel()
+
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) ListObjects(
+	ctx context.Context,
+	req *ListObjectsRequest) (listing *Listing, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Metadata)
+	defer cancel()
+
+	listing, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) UpdateObject(
+	ctx context.Context,
+	req *UpdateObjectRequest) (o *Object, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Metadata)
+	defer cancel()
+
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) DeleteObject(
+	ctx context.Context,
+	req *DeleteObjectRequest) (err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Metadata)
+	defer cancel()
+
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Metadata)
+	defer cancel()
+
+	o, err = b.wrapped.RestoreObject(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Metadata)
+	defer cancel()
+
+	results, err = b.wrapped.Batch(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Metadata)
+	defer cancel()
+
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Metadata)
+	defer cancel()
+
+	rule, err = b.wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *timeoutBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	ctx, cancel := withDefaultTimeout(ctx, b.policy.Metadata)
+	defer cancel()
+
+	err = b.wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+// SignedURL doesn't contact GCS, so no deadline is needed.
+func (b *timeoutBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
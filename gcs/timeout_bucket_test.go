@@ -0,0 +1,142 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	"github.com/jacobsa/gcloud/gcs/gcsutil"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestTimeoutBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A bucket that records the context of the most recent call to StatObject,
+// ListObjects, or NewReader.
+type contextRecordingBucket struct {
+	gcs.Bucket
+	ctx context.Context
+}
+
+func (b *contextRecordingBucket) StatObject(
+	ctx context.Context,
+	req *gcs.StatObjectRequest) (o *gcs.Object, err error) {
+	b.ctx = ctx
+	o, err = b.Bucket.StatObject(ctx, req)
+	return
+}
+
+func (b *contextRecordingBucket) ListObjects(
+	ctx context.Context,
+	req *gcs.ListObjectsRequest) (listing *gcs.Listing, err error) {
+	b.ctx = ctx
+	listing, err = b.Bucket.ListObjects(ctx, req)
+	return
+}
+
+func (b *contextRecordingBucket) NewReader(
+	ctx context.Context,
+	req *gcs.ReadObjectRequest) (rc gcs.ReadSeekCloser, err error) {
+	b.ctx = ctx
+	rc, err = b.Bucket.NewReader(ctx, req)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type TimeoutBucketTest struct {
+	ctx     context.Context
+	wrapped contextRecordingBucket
+	bucket  gcs.Bucket
+}
+
+var _ SetUpInterface = &TimeoutBucketTest{}
+
+func init() { RegisterTestSuite(&TimeoutBucketTest{}) }
+
+func (t *TimeoutBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = context.Background()
+	t.wrapped.Bucket = gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket")
+	t.bucket = gcs.NewTimeoutBucket(&t.wrapped, gcs.TimeoutPolicy{
+		Metadata: time.Minute,
+		Transfer: time.Hour,
+	})
+
+	_, err := gcsutil.CreateObject(t.ctx, t.wrapped.Bucket, "foo", []byte("taco"))
+	AssertEq(nil, err)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *TimeoutBucketTest) MetadataDeadline() {
+	before := time.Now()
+	_, err := t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	deadline, ok := t.wrapped.ctx.Deadline()
+	AssertTrue(ok)
+	ExpectThat(deadline, timeutil.TimeNear(before.Add(time.Minute), time.Second))
+
+	// The deadline's context is released when the call returns.
+	ExpectEq(context.Canceled, t.wrapped.ctx.Err())
+}
+
+func (t *TimeoutBucketTest) TransferDeadlineLastsUntilClose() {
+	before := time.Now()
+	rc, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	deadline, ok := t.wrapped.ctx.Deadline()
+	AssertTrue(ok)
+	ExpectThat(deadline, timeutil.TimeNear(before.Add(time.Hour), time.Second))
+	ExpectEq(nil, t.wrapped.ctx.Err())
+
+	AssertEq(nil, rc.Close())
+	ExpectEq(context.Canceled, t.wrapped.ctx.Err())
+}
+
+func (t *TimeoutBucketTest) CallerDeadlinePreserved() {
+	ctx, cancel := context.WithTimeout(t.ctx, 24*time.Hour)
+	defer cancel()
+
+	_, err := t.bucket.ListObjects(ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	ExpectEq(ctx, t.wrapped.ctx)
+}
+
+func (t *TimeoutBucketTest) ZeroMeansNoDeadline() {
+	t.bucket = gcs.NewTimeoutBucket(&t.wrapped, gcs.TimeoutPolicy{})
+
+	_, err := t.bucket.ListObjects(t.ctx, &gcs.ListObjectsRequest{})
+	AssertEq(nil, err)
+
+	_, ok := t.wrapped.ctx.Deadline()
+	ExpectFalse(ok)
+}
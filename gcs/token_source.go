@@ -0,0 +1,93 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// How long before a token expires that NewConn fetches a new one, so that
+// requests in flight don't carry a token that expires before GCS sees it.
+const tokenRefreshMargin = time.Minute
+
+// Return the narrowest OAuth scope that permits either only reading buckets
+// and objects, or also creating, modifying, and deleting them. Neither permits
+// changing ACLs, IAM policies, or other access controls; for that use
+// Scope_FullControl.
+func ChooseScope(readOnly bool) (scope string) {
+	if readOnly {
+		scope = Scope_ReadOnly
+		return
+	}
+
+	scope = Scope_ReadWrite
+	return
+}
+
+// Return a token source for the credentials described by the contents of a
+// JSON file, with the supplied scopes. Any of the kinds of file understood by
+// the gcloud tool are supported:
+//
+//  *  A service account key, as downloaded from the Cloud Console.
+//
+//  *  User credentials, as written by "gcloud auth application-default login".
+//
+//  *  An external account configuration for workload identity federation.
+//
+// For workload identity on GKE and other credentials found in the
+// environment, use google.DefaultTokenSource instead. Either way, NewConn
+// caches the tokens obtained and refreshes them before they expire.
+func TokenSourceFromJSON(
+	ctx context.Context,
+	jsonKey []byte,
+	scopes ...string) (ts oauth2.TokenSource, err error) {
+	creds, err := google.CredentialsFromJSON(ctx, jsonKey, scopes...)
+	if err != nil {
+		err = fmt.Errorf("CredentialsFromJSON: %v", err)
+		return
+	}
+
+	ts = creds.TokenSource
+	return
+}
+
+// Like TokenSourceFromJSON, but read the JSON from the file at the given path.
+func TokenSourceFromFile(
+	ctx context.Context,
+	path string,
+	scopes ...string) (ts oauth2.TokenSource, err error) {
+	jsonKey, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("ReadFile: %v", err)
+		return
+	}
+
+	ts, err = TokenSourceFromJSON(ctx, jsonKey, scopes...)
+	return
+}
+
+// Wrap the supplied token source so that tokens are reused until shortly
+// before they expire, rather than fetched anew for every request.
+func newRefreshingTokenSource(
+	wrapped oauth2.TokenSource) (ts oauth2.TokenSource) {
+	ts = oauth2.ReuseTokenSourceWithExpiry(nil, wrapped, tokenRefreshMargin)
+	return
+}
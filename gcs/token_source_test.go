@@ -0,0 +1,163 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestTokenSource(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A token source that mints a new token each time it is called, expiring
+// after the configured lifetime.
+type countingTokenSource struct {
+	lifetime time.Duration
+
+	mu    sync.Mutex
+	count int // GUARDED_BY(mu)
+}
+
+func (ts *countingTokenSource) Token() (t *oauth2.Token, err error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.count++
+	t = &oauth2.Token{
+		AccessToken: fmt.Sprintf("tok%d", ts.count),
+		Expiry:      time.Now().Add(ts.lifetime),
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type TokenSourceTest struct {
+	ctx    context.Context
+	server *httptest.Server
+
+	mu      sync.Mutex
+	headers []string // GUARDED_BY(mu)
+}
+
+var _ SetUpInterface = &TokenSourceTest{}
+var _ TearDownInterface = &TokenSourceTest{}
+
+func init() { RegisterTestSuite(&TokenSourceTest{}) }
+
+func (t *TokenSourceTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.server = httptest.NewServer(http.HandlerFunc(t.serveHTTP))
+}
+
+func (t *TokenSourceTest) TearDown() {
+	t.server.Close()
+}
+
+// Record the Authorization header and serve empty listings.
+func (t *TokenSourceTest) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	t.headers = append(t.headers, r.Header.Get("Authorization"))
+	t.mu.Unlock()
+
+	w.Write([]byte("{}"))
+}
+
+// Open a bucket and list its objects n times using a connection with the
+// supplied token source, returning the Authorization headers sent. Opening the
+// bucket also sends a request.
+func (t *TokenSourceTest) listObjects(
+	ts oauth2.TokenSource,
+	n int) (headers []string) {
+	conn, err := NewConn(&ConnConfig{
+		TokenSource: ts,
+		Endpoint:    t.server.URL,
+	})
+
+	AssertEq(nil, err)
+
+	b, err := conn.OpenBucket(t.ctx, "some_bucket")
+	AssertEq(nil, err)
+
+	for i := 0; i < n; i++ {
+		_, err = b.ListObjects(t.ctx, &ListObjectsRequest{})
+		AssertEq(nil, err)
+	}
+
+	t.mu.Lock()
+	headers = t.headers
+	t.mu.Unlock()
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *TokenSourceTest) ChooseScope() {
+	ExpectEq(Scope_ReadOnly, ChooseScope(true))
+	ExpectEq(Scope_ReadWrite, ChooseScope(false))
+}
+
+func (t *TokenSourceTest) TokensAreReused() {
+	ts := &countingTokenSource{lifetime: time.Hour}
+	headers := t.listObjects(ts, 3)
+
+	ExpectThat(headers, ElementsAre(
+		"Bearer tok1",
+		"Bearer tok1",
+		"Bearer tok1",
+		"Bearer tok1"))
+
+	ExpectEq(1, ts.count)
+}
+
+func (t *TokenSourceTest) TokensAreRefreshedBeforeExpiry() {
+	// Tokens that expire within the refresh margin are never worth reusing.
+	ts := &countingTokenSource{lifetime: tokenRefreshMargin / 2}
+	headers := t.listObjects(ts, 3)
+
+	ExpectThat(headers, ElementsAre(
+		"Bearer tok1",
+		"Bearer tok2",
+		"Bearer tok3",
+		"Bearer tok4"))
+
+	ExpectEq(4, ts.count)
+}
+
+func (t *TokenSourceTest) TokenSourceFromFile_MissingFile() {
+	_, err := TokenSourceFromFile(t.ctx, "/no/such/file.json", Scope_ReadOnly)
+
+	ExpectThat(err, Error(HasSubstr("ReadFile")))
+}
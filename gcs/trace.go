@@ -0,0 +1,90 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"github.com/jacobsa/reqtrace"
+	"golang.org/x/net/context"
+)
+
+// A function called at the start of each span of work traced by this
+// package: one for each call to a Bucket method, and nested ones for the
+// steps it takes, e.g. starting a resumable upload session, sending each
+// chunk, decoding a JSON response, or sleeping before a retry. It returns
+// the context to use for work within the span, and a function that is called
+// exactly once with the outcome of the span when it ends.
+//
+// A hook can be used to bridge these spans to another tracing system such as
+// OpenTelemetry. See ConnConfig.SpanHook.
+type SpanHook func(
+	ctx context.Context,
+	desc string) (context.Context, func(error))
+
+type spanHookKey struct{}
+
+// Return a context that causes spans started with it to be reported to the
+// supplied hook, after any hook that the context already carries.
+func withSpanHook(ctx context.Context, hook SpanHook) context.Context {
+	if outer, ok := ctx.Value(spanHookKey{}).(SpanHook); ok {
+		inner := hook
+		hook = func(
+			ctx context.Context,
+			desc string) (context.Context, func(error)) {
+			ctx, outerReport := outer(ctx, desc)
+			ctx, innerReport := inner(ctx, desc)
+			report := func(err error) {
+				innerReport(err)
+				outerReport(err)
+			}
+
+			return ctx, report
+		}
+	}
+
+	return context.WithValue(ctx, spanHookKey{}, hook)
+}
+
+// Start a span with reqtrace, and report it to any hook in the context. Like
+// reqtrace.StartSpan, this is cheap when neither is active.
+func startSpan(
+	parent context.Context,
+	desc string) (ctx context.Context, report reqtrace.ReportFunc) {
+	ctx, report = reqtrace.StartSpan(parent, desc)
+
+	hook, _ := ctx.Value(spanHookKey{}).(SpanHook)
+	if hook == nil {
+		return
+	}
+
+	ctx, hookReport := hook(ctx, desc)
+	traceReport := report
+	report = func(err error) {
+		hookReport(err)
+		traceReport(err)
+	}
+
+	return
+}
+
+// Like reqtrace.StartSpanWithError, but using startSpan.
+func startSpanWithError(
+	ctx *context.Context,
+	err *error,
+	desc string) (f func()) {
+	var report reqtrace.ReportFunc
+	*ctx, report = startSpan(*ctx, desc)
+	f = func() { report(*err) }
+	return
+}
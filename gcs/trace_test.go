@@ -0,0 +1,298 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestTrace(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Span recorder
+////////////////////////////////////////////////////////////////////////
+
+type recordedSpan struct {
+	desc   string
+	parent string
+	err    error
+}
+
+type parentSpanKey struct{}
+
+// Records the spans reported to its hook, along with the description of the
+// span each was started within.
+type spanRecorder struct {
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	spans []*recordedSpan
+}
+
+func (r *spanRecorder) Hook(
+	ctx context.Context,
+	desc string) (context.Context, func(error)) {
+	parent, _ := ctx.Value(parentSpanKey{}).(string)
+	s := &recordedSpan{
+		desc:   desc,
+		parent: parent,
+	}
+
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+
+	report := func(err error) {
+		r.mu.Lock()
+		s.err = err
+		r.mu.Unlock()
+	}
+
+	return context.WithValue(ctx, parentSpanKey{}, desc), report
+}
+
+// Return the descriptions of the recorded spans started within the given
+// parent, in order.
+func (r *spanRecorder) Children(parent string) (descs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.spans {
+		if s.parent == parent {
+			descs = append(descs, s.desc)
+		}
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type TraceTest struct {
+	ctx       context.Context
+	transport headerTransport
+	recorder  spanRecorder
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &TraceTest{}
+
+func init() { RegisterTestSuite(&TraceTest{}) }
+
+func (t *TraceTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.transport.status = http.StatusOK
+	t.transport.header = make(http.Header)
+
+	t.bucket = &reqtraceBucket{
+		Wrapped: newBucket(
+			&http.Client{Transport: &t.transport},
+			"test",
+			"some_bucket",
+			defaultUploadChunkSize,
+			nil,
+			""),
+		Hook: t.recorder.Hook,
+	}
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *TraceTest) NoHookInContext() {
+	ctx, report := startSpan(t.ctx, "taco")
+	report(nil)
+
+	ExpectTrue(ctx == t.ctx)
+	ExpectEq(0, len(t.recorder.Children("")))
+}
+
+func (t *TraceTest) HooksCompose() {
+	var other spanRecorder
+	ctx := withSpanHook(t.ctx, t.recorder.Hook)
+	ctx = withSpanHook(ctx, other.Hook)
+
+	_, report := startSpan(ctx, "taco")
+	report(nil)
+
+	ExpectThat(t.recorder.Children(""), ElementsAre("taco"))
+	AssertEq(1, len(other.spans))
+	ExpectEq("taco", other.spans[0].desc)
+}
+
+func (t *TraceTest) OperationSpan() {
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	ExpectThat(t.recorder.Children(""), ElementsAre(`StatObject: "foo"`))
+
+	AssertEq(2, len(t.recorder.spans))
+	ExpectEq(nil, t.recorder.spans[0].err)
+}
+
+func (t *TraceTest) OperationSpanIncludesRequestID() {
+	ctx := WithRequestID(t.ctx, "taco")
+	_, err := t.bucket.StatObject(ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	ExpectThat(
+		t.recorder.Children(""),
+		ElementsAre(`StatObject: "foo" [request ID taco]`))
+}
+
+func (t *TraceTest) DecodeSpanIsNested() {
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	ExpectThat(
+		t.recorder.Children(`StatObject: "foo"`),
+		ElementsAre("Decode JSON response"))
+}
+
+func (t *TraceTest) ErrorReported() {
+	t.transport.status = http.StatusNotFound
+
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertNe(nil, err)
+
+	AssertEq(1, len(t.recorder.spans))
+	ExpectEq(err, t.recorder.spans[0].err)
+}
+
+func (t *TraceTest) ReaderSpanEndsOnClose() {
+	rc, err := t.bucket.NewReader(t.ctx, &ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertThat(t.recorder.Children(""), ElementsAre(`Read: "foo"`))
+
+	AssertEq(nil, rc.Close())
+	ExpectEq(nil, t.recorder.spans[0].err)
+}
+
+func (t *TraceTest) MoveObjectTraced() {
+	t.bucket.MoveObject(t.ctx, &MoveObjectRequest{
+		SrcName: "foo",
+		DstName: "bar",
+	})
+
+	ExpectThat(t.recorder.Children(""), ElementsAre(`MoveObject: "foo" -> "bar"`))
+}
+
+////////////////////////////////////////////////////////////////////////
+// Upload spans
+////////////////////////////////////////////////////////////////////////
+
+type UploadTraceTest struct {
+	ctx      context.Context
+	session  fakeUploadSession
+	server   *httptest.Server
+	recorder spanRecorder
+	bucket   *bucket
+
+	oldPolicy RetryPolicy
+}
+
+var _ SetUpInterface = &UploadTraceTest{}
+var _ TearDownInterface = &UploadTraceTest{}
+
+func init() { RegisterTestSuite(&UploadTraceTest{}) }
+
+func (t *UploadTraceTest) SetUp(ti *TestInfo) {
+	t.ctx = withSpanHook(ti.Ctx, t.recorder.Hook)
+	t.session.dropAfter = make(map[int]int)
+	t.server = httptest.NewServer(&t.session)
+
+	t.bucket = &bucket{
+		client:          http.DefaultClient,
+		userAgent:       "test",
+		name:            "some_bucket",
+		uploadChunkSize: uploadTestChunkSize,
+		bufferPool:      NewBufferPool(0),
+	}
+
+	// Don't wait around between attempts.
+	t.oldPolicy = uploadChunkRetryPolicy
+	uploadChunkRetryPolicy = RetryPolicy{
+		InitialDelay: time.Millisecond,
+		MaxDelay:     time.Millisecond,
+	}
+}
+
+func (t *UploadTraceTest) TearDown() {
+	uploadChunkRetryPolicy = t.oldPolicy
+	t.server.Close()
+}
+
+func (t *UploadTraceTest) ChunkSpans() {
+	u, err := url.Parse(t.server.URL)
+	AssertEq(nil, err)
+
+	_, err = t.bucket.uploadChunks(
+		t.ctx,
+		u,
+		"text/plain",
+		strings.NewReader("tacoburrito"),
+		uploadTestChunkSize,
+		nil)
+
+	AssertEq(nil, err)
+
+	ExpectThat(t.recorder.Children(""), ElementsAre("Upload chunks"))
+	ExpectThat(
+		t.recorder.Children("Upload chunks"),
+		ElementsAre(
+			"PUT chunk: offset 0, 4 bytes",
+			"PUT chunk: offset 4, 4 bytes",
+			"PUT chunk: offset 8, 3 bytes",
+		))
+
+	ExpectThat(
+		t.recorder.Children("PUT chunk: offset 8, 3 bytes"),
+		ElementsAre("Decode JSON response"))
+}
+
+func (t *UploadTraceTest) QueryAfterDroppedConnection() {
+	t.session.dropAfter[1] = 2
+
+	u, err := url.Parse(t.server.URL)
+	AssertEq(nil, err)
+
+	_, err = t.bucket.uploadChunks(
+		t.ctx,
+		u,
+		"text/plain",
+		strings.NewReader("tacoburrito"),
+		uploadTestChunkSize,
+		nil)
+
+	AssertEq(nil, err)
+	ExpectThat(
+		t.recorder.Children("Upload chunks"),
+		Contains("Query upload status"))
+}
@@ -0,0 +1,395 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// OperationTrace describes a single call to a method of a bucket created with
+// NewTracingBucket.
+type OperationTrace struct {
+	// The name of the Bucket method, e.g. "NewReader" or "CreateObject".
+	Op string
+
+	// The name of the object operated upon: the destination object for copies,
+	// moves, and composes, and the prefix for listings.
+	Name string
+
+	// The request ID attached to the call's context with WithRequestID, if any.
+	RequestID string
+
+	// The number of bytes of object contents transferred: read by the caller
+	// for NewReader, and consumed from req.Contents for CreateObject (including
+	// any that the wrapped bucket re-reads in order to retry). Zero for other
+	// operations.
+	Bytes int64
+
+	// When the call started, and how long it took. For NewReader, the call is
+	// considered to last until the reader is closed.
+	Start   time.Time
+	Latency time.Duration
+
+	// The error returned by the call, if any. For NewReader, this is the first
+	// error other than io.EOF returned by the reader if NewReader itself
+	// succeeded.
+	Err error
+}
+
+// Create a bucket that calls through to the wrapped bucket, passing a record
+// of each call to the supplied function once it completes. The function may
+// be called concurrently from multiple goroutines.
+//
+// Calls to NewReader are reported when the reader is closed, so callers must
+// close readers they obtain in order for them to be reported.
+func NewTracingBucket(
+	wrapped Bucket,
+	report func(*OperationTrace)) (b Bucket) {
+	b = &tracingBucket{
+		wrapped: wrapped,
+		report:  report,
+	}
+
+	return
+}
+
+type tracingBucket struct {
+	wrapped Bucket
+	report  func(*OperationTrace)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+func (b *tracingBucket) startOp(
+	ctx context.Context,
+	op string,
+	name string) (t *OperationTrace) {
+	t = &OperationTrace{
+		Op:        op,
+		Name:      name,
+		RequestID: RequestIDFromContext(ctx),
+		Start:     time.Now(),
+	}
+
+	return
+}
+
+func (b *tracingBucket) finishOp(t *OperationTrace, err *error) {
+	t.Latency = time.Since(t.Start)
+	t.Err = *err
+	b.report(t)
+}
+
+// A reader that counts the bytes that pass through it.
+type countingReader struct {
+	wrapped io.Reader
+	count   int64
+}
+
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.wrapped.Read(p)
+	cr.count += int64(n)
+	return
+}
+
+// A countingReader for contents that support seeking, preserving the ability
+// of the wrapped bucket to rewind them.
+type countingReadSeeker struct {
+	countingReader
+	seeker io.Seeker
+}
+
+func (crs *countingReadSeeker) Seek(
+	offset int64,
+	whence int) (n int64, err error) {
+	n, err = crs.seeker.Seek(offset, whence)
+	return
+}
+
+// A countingReadSeeker for contents that can also be read at arbitrary
+// offsets, preserving the ability of the wrapped bucket to send them without
+// buffering. Bytes read with ReadAt are counted too.
+type countingReaderAt struct {
+	countingReadSeeker
+	ra sizedReaderAt
+}
+
+func (cra *countingReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = cra.ra.ReadAt(p, off)
+	cra.count += int64(n)
+	return
+}
+
+func (cra *countingReaderAt) Size() int64 {
+	return cra.ra.Size()
+}
+
+// A reader that reports its operation when closed.
+type tracingReader struct {
+	bucket  *tracingBucket
+	trace   *OperationTrace
+	wrapped ReadSeekCloser
+	err     error
+}
+
+func (tr *tracingReader) Read(p []byte) (n int, err error) {
+	n, err = tr.wrapped.Read(p)
+	tr.trace.Bytes += int64(n)
+
+	if err != nil && err != io.EOF && tr.err == nil {
+		tr.err = err
+	}
+
+	return
+}
+
+func (tr *tracingReader) Seek(offset int64, whence int) (n int64, err error) {
+	n, err = tr.wrapped.Seek(offset, whence)
+	return
+}
+
+func (tr *tracingReader) Close() (err error) {
+	err = tr.wrapped.Close()
+
+	reported := tr.err
+	if reported == nil {
+		reported = err
+	}
+
+	tr.bucket.finishOp(tr.trace, &reported)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Bucket interface
+////////////////////////////////////////////////////////////////////////
+
+func (b *tracingBucket) Name() string {
+	return b.wrapped.Name()
+}
+
+func (b *tracingBucket) NewReader(
+	ctx context.Context,
+	req *ReadObjectRequest) (rc ReadSeekCloser, err error) {
+	t := b.startOp(ctx, "NewReader", req.Name)
+
+	rc, err = b.wrapped.NewReader(ctx, req)
+	if err != nil {
+		b.finishOp(t, &err)
+		return
+	}
+
+	rc = &tracingReader{
+		bucket:  b,
+		trace:   t,
+		wrapped: rc,
+	}
+
+	return
+}
+
+func (b *tracingBucket) CreateObject(
+	ctx context.Context,
+	req *CreateObjectRequest) (o *Object, err error) {
+	t := b.startOp(ctx, "CreateObject", req.Name)
+	defer b.finishOp(t, &err)
+
+	// Count the contents as they're consumed.
+	ra, err := sizedContents(req.Contents)
+	if err != nil {
+		return
+	}
+
+	var counter *countingReader
+	mReq := *req
+
+	seeker, isSeeker := req.Contents.(io.Seeker)
+	switch {
+	case ra != nil && isSeeker:
+		cra := &countingReaderAt{
+			countingReadSeeker: countingReadSeeker{
+				countingReader: countingReader{wrapped: req.Contents},
+				seeker:         seeker,
+			},
+			ra: ra,
+		}
+
+		counter = &cra.countingReader
+		mReq.Contents = cra
+
+	case isSeeker:
+		crs := &countingReadSeeker{
+			countingReader: countingReader{wrapped: req.Contents},
+			seeker:         seeker,
+		}
+
+		counter = &crs.countingReader
+		mReq.Contents = crs
+
+	default:
+		counter = &countingReader{wrapped: req.Contents}
+		mReq.Contents = counter
+	}
+
+	o, err = b.wrapped.CreateObject(ctx, &mReq)
+	t.Bytes = counter.count
+
+	return
+}
+
+func (b *tracingBucket) CopyObject(
+	ctx context.Context,
+	req *CopyObjectRequest) (o *Object, err error) {
+	t := b.startOp(ctx, "CopyObject", req.DstName)
+	defer b.finishOp(t, &err)
+
+	o, err = b.wrapped.CopyObject(ctx, req)
+	return
+}
+
+func (b *tracingBucket) MoveObject(
+	ctx context.Context,
+	req *MoveObjectRequest) (o *Object, err error) {
+	t := b.startOp(ctx, "MoveObject", req.DstName)
+	defer b.finishOp(t, &err)
+
+	o, err = b.wrapped.MoveObject(ctx, req)
+	return
+}
+
+func (b *tracingBucket) ComposeObjects(
+	ctx context.Context,
+	req *ComposeObjectsRequest) (o *Object, err error) {
+	t := b.startOp(ctx, "ComposeObjects", req.DstName)
+	defer b.finishOp(t, &err)
+
+	o, err = b.wrapped.ComposeObjects(ctx, req)
+	return
+}
+
+func (b *tracingBucket) StatObject(
+	ctx context.Context,
+	req *StatObjectRequest) (o *Object, err error) {
+	t := b.startOp(ctx, "StatObject", req.Name)
+	defer b.finishOp(t, &err)
+
+	o, err = b.wrapped.StatObject(ctx, req)
+	return
+}
+
+func (b *tracingBucket) ListObjects(
+	ctx context.Context,
+	req *ListObjectsRequest) (listing *Listing, err error) {
+	t := b.startOp(ctx, "ListObjects", req.Prefix)
+	defer b.finishOp(t, &err)
+
+	listing, err = b.wrapped.ListObjects(ctx, req)
+	return
+}
+
+func (b *tracingBucket) UpdateObject(
+	ctx context.Context,
+	req *UpdateObjectRequest) (o *Object, err error) {
+	t := b.startOp(ctx, "UpdateObject", req.Name)
+	defer b.finishOp(t, &err)
+
+	o, err = b.wrapped.UpdateObject(ctx, req)
+	return
+}
+
+func (b *tracingBucket) DeleteObject(
+	ctx context.Context,
+	req *DeleteObjectRequest) (err error) {
+	t := b.startOp(ctx, "DeleteObject", req.Name)
+	defer b.finishOp(t, &err)
+
+	err = b.wrapped.DeleteObject(ctx, req)
+	return
+}
+
+func (b *tracingBucket) RestoreObject(
+	ctx context.Context,
+	req *RestoreObjectRequest) (o *Object, err error) {
+	t := b.startOp(ctx, "RestoreObject", req.Name)
+	defer b.finishOp(t, &err)
+
+	o, err = b.wrapped.RestoreObject(ctx, req)
+	return
+}
+
+func (b *tracingBucket) SignedURL(
+	ctx context.Context,
+	req *SignedURLRequest) (signed string, err error) {
+	t := b.startOp(ctx, "SignedURL", req.Name)
+	defer b.finishOp(t, &err)
+
+	signed, err = b.wrapped.SignedURL(ctx, req)
+	return
+}
+
+func (b *tracingBucket) ListObjectACLs(
+	ctx context.Context,
+	req *ListObjectACLsRequest) (rules []*ACLRule, err error) {
+	t := b.startOp(ctx, "ListObjectACLs", req.Name)
+	defer b.finishOp(t, &err)
+
+	rules, err = b.wrapped.ListObjectACLs(ctx, req)
+	return
+}
+
+func (b *tracingBucket) UpdateObjectACL(
+	ctx context.Context,
+	req *UpdateObjectACLRequest) (rule *ACLRule, err error) {
+	t := b.startOp(ctx, "UpdateObjectACL", req.Name)
+	defer b.finishOp(t, &err)
+
+	rule, err = b.wrapped.UpdateObjectACL(ctx, req)
+	return
+}
+
+func (b *tracingBucket) DeleteObjectACL(
+	ctx context.Context,
+	req *DeleteObjectACLRequest) (err error) {
+	t := b.startOp(ctx, "DeleteObjectACL", req.Name)
+	defer b.finishOp(t, &err)
+
+	err = b.wrapped.DeleteObjectACL(ctx, req)
+	return
+}
+
+func (b *tracingBucket) RewriteObject(
+	ctx context.Context,
+	req *RewriteObjectRequest) (o *Object, err error) {
+	t := b.startOp(ctx, "RewriteObject", req.DstName)
+	defer b.finishOp(t, &err)
+
+	o, err = b.wrapped.RewriteObject(ctx, req)
+	return
+}
+
+func (b *tracingBucket) Batch(
+	ctx context.Context,
+	req *BatchRequest) (results []BatchResult, err error) {
+	t := b.startOp(ctx, "Batch", "")
+	defer b.finishOp(t, &err)
+
+	results, err = b.wrapped.Batch(ctx, req)
+	return
+}
@@ -0,0 +1,161 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/jacobsa/gcloud/gcs"
+	"github.com/jacobsa/gcloud/gcs/gcsfake"
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+	"github.com/jacobsa/timeutil"
+)
+
+func TestTracingBucket(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type TracingBucketTest struct {
+	ctx    context.Context
+	bucket gcs.Bucket
+
+	mu     sync.Mutex
+	traces []*gcs.OperationTrace // GUARDED_BY(mu)
+}
+
+var _ SetUpInterface = &TracingBucketTest{}
+
+func init() { RegisterTestSuite(&TracingBucketTest{}) }
+
+func (t *TracingBucketTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.bucket = gcs.NewTracingBucket(
+		gcsfake.NewFakeBucket(timeutil.RealClock(), "some_bucket"),
+		t.record)
+}
+
+func (t *TracingBucketTest) record(trace *gcs.OperationTrace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.traces = append(t.traces, trace)
+}
+
+func (t *TracingBucketTest) takeTraces() (traces []*gcs.OperationTrace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	traces = t.traces
+	t.traces = nil
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *TracingBucketTest) CreateAndRead() {
+	// Create.
+	_, err := t.bucket.CreateObject(
+		t.ctx,
+		&gcs.CreateObjectRequest{
+			Name:     "foo",
+			Contents: strings.NewReader("taco"),
+		})
+
+	AssertEq(nil, err)
+
+	traces := t.takeTraces()
+	AssertEq(1, len(traces))
+	ExpectEq("CreateObject", traces[0].Op)
+	ExpectEq("foo", traces[0].Name)
+	ExpectEq(4, traces[0].Bytes)
+	ExpectEq(nil, traces[0].Err)
+	ExpectGe(traces[0].Latency, 0)
+
+	// Read. Nothing should be reported until the reader is closed.
+	rc, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	contents, err := ioutil.ReadAll(rc)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(contents))
+	ExpectEq(0, len(t.takeTraces()))
+
+	AssertEq(nil, rc.Close())
+
+	traces = t.takeTraces()
+	AssertEq(1, len(traces))
+	ExpectEq("NewReader", traces[0].Op)
+	ExpectEq("foo", traces[0].Name)
+	ExpectEq(4, traces[0].Bytes)
+	ExpectEq(nil, traces[0].Err)
+}
+
+func (t *TracingBucketTest) Errors() {
+	_, err := t.bucket.NewReader(t.ctx, &gcs.ReadObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "bar"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	traces := t.takeTraces()
+	AssertEq(2, len(traces))
+
+	ExpectEq("NewReader", traces[0].Op)
+	ExpectEq("foo", traces[0].Name)
+	ExpectThat(traces[0].Err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	ExpectEq("StatObject", traces[1].Op)
+	ExpectEq("bar", traces[1].Name)
+	ExpectThat(traces[1].Err, HasSameTypeAs(&gcs.NotFoundError{}))
+}
+
+func (t *TracingBucketTest) RequestID() {
+	ctx := gcs.WithRequestID(t.ctx, "taco")
+	_, err := t.bucket.StatObject(ctx, &gcs.StatObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	_, err = t.bucket.StatObject(t.ctx, &gcs.StatObjectRequest{Name: "foo"})
+	ExpectThat(err, HasSameTypeAs(&gcs.NotFoundError{}))
+
+	traces := t.takeTraces()
+	AssertEq(2, len(traces))
+	ExpectEq("taco", traces[0].RequestID)
+	ExpectEq("", traces[1].RequestID)
+}
+
+func (t *TracingBucketTest) ListObjects() {
+	_, err := t.bucket.ListObjects(
+		t.ctx,
+		&gcs.ListObjectsRequest{Prefix: "baz/"})
+
+	AssertEq(nil, err)
+
+	traces := t.takeTraces()
+	AssertEq(1, len(traces))
+	ExpectEq("ListObjects", traces[0].Op)
+	ExpectEq("baz/", traces[0].Name)
+	ExpectEq(0, traces[0].Bytes)
+}
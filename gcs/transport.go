@@ -0,0 +1,97 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jacobsa/gcloud/httputil"
+)
+
+// How long TCP connections that NewConn dials are kept alive, matching
+// http.DefaultTransport.
+const dialKeepAlive = 30 * time.Second
+
+// Return true if the config asks for any HTTP transport tuning.
+func wantsTransportTuning(cfg *ConnConfig) bool {
+	return cfg.MaxIdleConnsPerHost != 0 ||
+		cfg.DisableHTTP2 ||
+		cfg.DialTimeout != 0 ||
+		cfg.TLSHandshakeTimeout != 0
+}
+
+// Choose the HTTP transport described by the supplied config: the one it
+// contains, a copy of http.DefaultTransport with its tuning options applied,
+// or http.DefaultTransport itself if there are none.
+func newHTTPTransport(
+	cfg *ConnConfig) (transport httputil.CancellableRoundTripper, err error) {
+	if cfg.Transport != nil {
+		if wantsTransportTuning(cfg) {
+			err = errors.New(
+				"MaxIdleConnsPerHost, DisableHTTP2, DialTimeout, and " +
+					"TLSHandshakeTimeout can't be combined with Transport")
+			return
+		}
+
+		transport = cfg.Transport
+		return
+	}
+
+	if !wantsTransportTuning(cfg) {
+		transport = http.DefaultTransport.(httputil.CancellableRoundTripper)
+		return
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.MaxIdleConnsPerHost != 0 {
+		if cfg.MaxIdleConnsPerHost < 0 {
+			err = errors.New("MaxIdleConnsPerHost must be non-negative")
+			return
+		}
+
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+		if t.MaxIdleConns != 0 && t.MaxIdleConns < cfg.MaxIdleConnsPerHost {
+			t.MaxIdleConns = cfg.MaxIdleConnsPerHost
+		}
+	}
+
+	// A non-nil empty TLSNextProto map is the documented way to disable HTTP/2.
+	if cfg.DisableHTTP2 {
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(
+			map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if cfg.DialTimeout != 0 {
+		dialer := &net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: dialKeepAlive,
+		}
+
+		t.DialContext = dialer.DialContext
+	}
+
+	if cfg.TLSHandshakeTimeout != 0 {
+		t.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+
+	transport = t
+	return
+}
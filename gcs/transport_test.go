@@ -0,0 +1,106 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestTransport(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type TransportTest struct {
+}
+
+func init() { RegisterTestSuite(&TransportTest{}) }
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *TransportTest) NoTuning() {
+	transport, err := newHTTPTransport(&ConnConfig{})
+
+	AssertEq(nil, err)
+	ExpectEq(http.DefaultTransport, transport)
+}
+
+func (t *TransportTest) SuppliedTransport() {
+	supplied := &http.Transport{}
+	transport, err := newHTTPTransport(&ConnConfig{Transport: supplied})
+
+	AssertEq(nil, err)
+	ExpectEq(supplied, transport)
+}
+
+func (t *TransportTest) SuppliedTransportWithTuning() {
+	_, err := newHTTPTransport(&ConnConfig{
+		Transport:           &http.Transport{},
+		MaxIdleConnsPerHost: 10,
+	})
+
+	ExpectThat(err, Error(HasSubstr("can't be combined with Transport")))
+}
+
+func (t *TransportTest) NegativeMaxIdleConnsPerHost() {
+	_, err := newHTTPTransport(&ConnConfig{MaxIdleConnsPerHost: -1})
+	ExpectThat(err, Error(HasSubstr("MaxIdleConnsPerHost")))
+}
+
+func (t *TransportTest) Tuning() {
+	transport, err := newHTTPTransport(&ConnConfig{
+		MaxIdleConnsPerHost: 500,
+		DisableHTTP2:        true,
+		DialTimeout:         time.Second,
+		TLSHandshakeTimeout: 2 * time.Second,
+	})
+
+	AssertEq(nil, err)
+	AssertNe(http.DefaultTransport, transport)
+
+	ht, ok := transport.(*http.Transport)
+	AssertTrue(ok)
+
+	ExpectEq(500, ht.MaxIdleConnsPerHost)
+	ExpectEq(500, ht.MaxIdleConns)
+	ExpectFalse(ht.ForceAttemptHTTP2)
+	ExpectNe(nil, ht.TLSNextProto)
+	ExpectEq(0, len(ht.TLSNextProto))
+	ExpectNe(nil, ht.DialContext)
+	ExpectEq(2*time.Second, ht.TLSHandshakeTimeout)
+
+	// The default transport should be untouched.
+	dt := http.DefaultTransport.(*http.Transport)
+	ExpectNe(500, dt.MaxIdleConnsPerHost)
+	ExpectTrue(dt.ForceAttemptHTTP2)
+}
+
+func (t *TransportTest) HTTP2EnabledByDefault() {
+	transport, err := newHTTPTransport(&ConnConfig{MaxIdleConnsPerHost: 10})
+	AssertEq(nil, err)
+
+	ht := transport.(*http.Transport)
+	ExpectTrue(ht.ForceAttemptHTTP2)
+	ExpectEq(nil, ht.TLSNextProto)
+	ExpectEq(100, ht.MaxIdleConns)
+}
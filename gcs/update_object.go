@@ -16,7 +16,6 @@ package gcs
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -60,13 +59,55 @@ func (b *bucket) makeUpdateObjectBody(
 
 	// Add a field for user metadata if appropriate.
 	if req.Metadata != nil {
+		for k, v := range req.Metadata {
+			var value string
+			if v != nil {
+				value = *v
+			}
+
+			if err = checkMetadataEntry(k, value); err != nil {
+				return
+			}
+		}
+
 		jsonMap["metadata"] = req.Metadata
 	}
 
+	// Add fields for holds. Unlike the strings above, false is meaningful.
+	if req.TemporaryHold != nil {
+		jsonMap["temporaryHold"] = *req.TemporaryHold
+	}
+
+	if req.EventBasedHold != nil {
+		jsonMap["eventBasedHold"] = *req.EventBasedHold
+	}
+
+	if req.CustomTime != nil {
+		jsonMap["customTime"] = fromTime(*req.CustomTime)
+	}
+
+	// Note that an empty but non-nil ACL must be sent as an empty list rather
+	// than omitted.
+	if req.ACL != nil {
+		acl := make([]*storagev1.ObjectAccessControl, 0, len(req.ACL))
+		for _, r := range req.ACL {
+			if err = checkACLRule(r.Entity, r.Role); err != nil {
+				return
+			}
+
+			acl = append(acl, &storagev1.ObjectAccessControl{
+				Entity: r.Entity,
+				Role:   r.Role,
+			})
+		}
+
+		jsonMap["acl"] = acl
+	}
+
 	// Set up a reader.
 	r, err := googleapi.WithoutDataWrapper.JSONReader(jsonMap)
 	if err != nil {
-		err = fmt.Errorf("JSONReader", err)
+		err = fmt.Errorf("JSONReader: %v", err)
 		return
 	}
 
@@ -84,9 +125,34 @@ func (b *bucket) makeUpdateObjectBody(
 func (b *bucket) UpdateObject(
 	ctx context.Context,
 	req *UpdateObjectRequest) (o *Object, err error) {
+	// Create an HTTP request.
+	httpReq, err := b.makeUpdateObjectRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// Execute the HTTP request.
+	httpRes, err := b.client.Do(httpReq)
+	if err != nil {
+		return
+	}
+
+	defer googleapi.CloseBody(httpRes)
+
+	o, err = handleUpdateObjectResponse(httpRes)
+	return
+}
+
+func (b *bucket) makeUpdateObjectRequest(
+	ctx context.Context,
+	req *UpdateObjectRequest) (httpReq *http.Request, err error) {
+	if err = CheckObjectName(req.Name); err != nil {
+		return
+	}
+
 	// Construct an appropriate URL (cf. http://goo.gl/B46IDy).
-	opaque := fmt.Sprintf(
-		"//www.googleapis.com/storage/v1/b/%s/o/%s",
+	path := fmt.Sprintf(
+		"/storage/v1/b/%s/o/%s",
 		httputil.EncodePathSegment(b.Name()),
 		httputil.EncodePathSegment(req.Name))
 
@@ -97,18 +163,21 @@ func (b *bucket) UpdateObject(
 		query.Set("generation", fmt.Sprintf("%d", req.Generation))
 	}
 
+	if req.GenerationPrecondition != nil {
+		query.Set(
+			"ifGenerationMatch",
+			fmt.Sprintf("%d", *req.GenerationPrecondition))
+	}
+
 	if req.MetaGenerationPrecondition != nil {
 		query.Set(
 			"ifMetagenerationMatch",
 			fmt.Sprintf("%d", *req.MetaGenerationPrecondition))
 	}
 
-	url := &url.URL{
-		Scheme:   "https",
-		Host:     "www.googleapis.com",
-		Opaque:   opaque,
-		RawQuery: query.Encode(),
-	}
+	b.addCommonParams(query)
+
+	url := makeURL(b.endpoint, path, query)
 
 	// Set up the request body.
 	body, err := b.makeUpdateObjectBody(req)
@@ -118,7 +187,7 @@ func (b *bucket) UpdateObject(
 	}
 
 	// Create an HTTP request.
-	httpReq, err := httputil.NewRequest(
+	httpReq, err = httputil.NewRequest(
 		ctx,
 		"PATCH",
 		url,
@@ -133,16 +202,12 @@ func (b *bucket) UpdateObject(
 
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	// Execute the HTTP request.
-	httpRes, err := b.client.Do(httpReq)
-	if err != nil {
-		return
-	}
-
-	defer googleapi.CloseBody(httpRes)
+	return
+}
 
+func handleUpdateObjectResponse(httpRes *http.Response) (o *Object, err error) {
 	// Check for HTTP-level errors.
-	if err = googleapi.CheckResponse(httpRes); err != nil {
+	if err = checkResponse(httpRes); err != nil {
 		// Special case: handle not found errors.
 		if typed, ok := err.(*googleapi.Error); ok {
 			if typed.Code == http.StatusNotFound {
@@ -162,7 +227,7 @@ func (b *bucket) UpdateObject(
 
 	// Parse the response.
 	var rawObject *storagev1.Object
-	if err = json.NewDecoder(httpRes.Body).Decode(&rawObject); err != nil {
+	if err = decodeResponse(httpRes, &rawObject); err != nil {
 		return
 	}
 
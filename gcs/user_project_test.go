@@ -0,0 +1,147 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestUserProject(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+// A round tripper that records requests and responds to each with the
+// supplied JSON, and the supplied status code if non-zero.
+type recordingTransport struct {
+	status   int
+	response string
+	requests []*http.Request
+}
+
+func (rt *recordingTransport) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	rt.requests = append(rt.requests, req)
+
+	status := rt.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	res = &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(rt.response)),
+		Request:    req,
+	}
+
+	return
+}
+
+type UserProjectTest struct {
+	ctx       context.Context
+	transport recordingTransport
+	bucket    Bucket
+}
+
+var _ SetUpInterface = &UserProjectTest{}
+
+func init() { RegisterTestSuite(&UserProjectTest{}) }
+
+func (t *UserProjectTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.transport.response = `{"name": "foo", "bucket": "some_bucket", "crc32c": "AAAAAA=="}`
+	t.bucket = newBucket(
+		&http.Client{Transport: &t.transport},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"some-project")
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *UserProjectTest) StatObject() {
+	_, err := t.bucket.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("some-project", query.Get("userProject"))
+	ExpectEq("full", query.Get("projection"))
+}
+
+func (t *UserProjectTest) NewReader() {
+	rc, err := t.bucket.NewReader(t.ctx, &ReadObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+	rc.Close()
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("some-project", query.Get("userProject"))
+	ExpectEq("media", query.Get("alt"))
+}
+
+func (t *UserProjectTest) DeleteObject() {
+	err := t.bucket.DeleteObject(t.ctx, &DeleteObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	ExpectEq("some-project", query.Get("userProject"))
+}
+
+func (t *UserProjectTest) NotSetByDefault() {
+	b := newBucket(
+		&http.Client{Transport: &t.transport},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+
+	_, err := b.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	AssertEq(1, len(t.transport.requests))
+	query := t.transport.requests[0].URL.Query()
+	_, ok := query["userProject"]
+	ExpectFalse(ok)
+}
+
+func (t *UserProjectTest) SignedURL() {
+	b := t.bucket.(*bucket)
+	_, b.signer = newTestURLSigner()
+
+	signed, err := b.SignedURL(
+		t.ctx,
+		&SignedURLRequest{Name: "foo", Method: "GET", Expiry: time.Minute})
+
+	AssertEq(nil, err)
+	ExpectThat(signed, HasSubstr("&userProject=some-project&"))
+}
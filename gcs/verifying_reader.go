@@ -0,0 +1,141 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Wrap the supplied reader for the full contents of the given object in a
+// layer that computes the CRC32C and (if the object has one) the MD5 of the
+// contents as they're read. If they don't match the object record when the
+// wrapped reader returns io.EOF, an error of type *ChecksumMismatchError is
+// returned in its place.
+//
+// The resulting reader doesn't support seeking.
+func NewVerifyingReader(
+	wrapped io.ReadCloser,
+	o *Object) (rc ReadSeekCloser) {
+	crc32c := o.CRC32C
+	rc = newVerifyingReader(wrapped, &crc32c, o.MD5)
+	return
+}
+
+// Like NewVerifyingReader, but either expected checksum may be nil, in which
+// case it is not checked.
+func newVerifyingReader(
+	wrapped io.ReadCloser,
+	crc32c *uint32,
+	md5Sum *[md5.Size]byte) (rc ReadSeekCloser) {
+	vr := &verifyingReader{
+		wrapped:        wrapped,
+		expectedCRC32C: crc32c,
+		expectedMD5:    md5Sum,
+	}
+
+	if crc32c != nil {
+		vr.crc32c = crc32.New(crc32cTable)
+	}
+
+	if md5Sum != nil {
+		vr.md5 = md5.New()
+	}
+
+	rc = vr
+	return
+}
+
+type verifyingReader struct {
+	wrapped io.ReadCloser
+
+	// Running checksums, nil if not being checked.
+	crc32c hash.Hash32
+	md5    hash.Hash
+
+	expectedCRC32C *uint32
+	expectedMD5    *[md5.Size]byte
+}
+
+func (vr *verifyingReader) Read(p []byte) (n int, err error) {
+	n, err = vr.wrapped.Read(p)
+
+	// Accumulate the data we're returning.
+	if vr.crc32c != nil {
+		vr.crc32c.Write(p[:n])
+	}
+
+	if vr.md5 != nil {
+		vr.md5.Write(p[:n])
+	}
+
+	// Check the checksums if we've reached the end.
+	if err == io.EOF {
+		if checkErr := vr.check(); checkErr != nil {
+			err = checkErr
+		}
+	}
+
+	return
+}
+
+func (vr *verifyingReader) check() (err error) {
+	if vr.crc32c != nil {
+		actual := vr.crc32c.Sum32()
+		if actual != *vr.expectedCRC32C {
+			err = &ChecksumMismatchError{
+				Err: fmt.Errorf(
+					"CRC32C mismatch: got 0x%08x, expected 0x%08x",
+					actual,
+					*vr.expectedCRC32C),
+			}
+
+			return
+		}
+	}
+
+	if vr.md5 != nil {
+		actual := vr.md5.Sum(nil)
+		if string(actual) != string(vr.expectedMD5[:]) {
+			err = &ChecksumMismatchError{
+				Err: fmt.Errorf(
+					"MD5 mismatch: got %s, expected %s",
+					hex.EncodeToString(actual),
+					hex.EncodeToString(vr.expectedMD5[:])),
+			}
+
+			return
+		}
+	}
+
+	return
+}
+
+func (vr *verifyingReader) Seek(offset int64, whence int) (n int64, err error) {
+	err = errors.New("Seeking is not supported while verifying checksums")
+	return
+}
+
+func (vr *verifyingReader) Close() (err error) {
+	err = vr.wrapped.Close()
+	return
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"crypto/md5"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestVerifyingReader(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type VerifyingReaderTest struct {
+}
+
+func init() { RegisterTestSuite(&VerifyingReaderTest{}) }
+
+func (t *VerifyingReaderTest) read(
+	contents string,
+	o *Object) (s string, err error) {
+	rc := NewVerifyingReader(
+		ioutil.NopCloser(strings.NewReader(contents)),
+		o)
+
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	s = string(b)
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *VerifyingReaderTest) ChecksumsMatch() {
+	const contents = "taco"
+	md5Sum := md5.Sum([]byte(contents))
+
+	o := &Object{
+		CRC32C: crc32.Checksum([]byte(contents), crc32cTable),
+		MD5:    &md5Sum,
+	}
+
+	s, err := t.read(contents, o)
+	AssertEq(nil, err)
+	ExpectEq(contents, s)
+}
+
+func (t *VerifyingReaderTest) NoMD5() {
+	const contents = "taco"
+
+	o := &Object{
+		CRC32C: crc32.Checksum([]byte(contents), crc32cTable),
+	}
+
+	s, err := t.read(contents, o)
+	AssertEq(nil, err)
+	ExpectEq(contents, s)
+}
+
+func (t *VerifyingReaderTest) CRC32CMismatch() {
+	const contents = "taco"
+	md5Sum := md5.Sum([]byte(contents))
+
+	o := &Object{
+		CRC32C: crc32.Checksum([]byte(contents), crc32cTable) + 1,
+		MD5:    &md5Sum,
+	}
+
+	_, err := t.read(contents, o)
+	ExpectThat(err, HasSameTypeAs(&ChecksumMismatchError{}))
+	ExpectThat(err, Error(HasSubstr("CRC32C")))
+}
+
+func (t *VerifyingReaderTest) MD5Mismatch() {
+	const contents = "taco"
+	md5Sum := md5.Sum([]byte("burrito"))
+
+	o := &Object{
+		CRC32C: crc32.Checksum([]byte(contents), crc32cTable),
+		MD5:    &md5Sum,
+	}
+
+	_, err := t.read(contents, o)
+	ExpectThat(err, HasSameTypeAs(&ChecksumMismatchError{}))
+	ExpectThat(err, Error(HasSubstr("MD5")))
+}
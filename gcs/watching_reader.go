@@ -0,0 +1,104 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"time"
+)
+
+// Wrap the supplied reader in a layer that reports the number of bytes read
+// so far to progress (if non-nil) and, if idleTimeout is positive, calls
+// cancel and returns an error of type *StalledReadError when a call to Read
+// sees no data for that long. cancel must cause the blocked read to return,
+// for example by cancelling the HTTP request that it is reading from. It is
+// also called when the reader is closed.
+func newWatchingReader(
+	wrapped ReadSeekCloser,
+	progress func(int64),
+	idleTimeout time.Duration,
+	cancel func()) (rc ReadSeekCloser) {
+	rc = &watchingReader{
+		wrapped:     wrapped,
+		progress:    progress,
+		idleTimeout: idleTimeout,
+		cancel:      cancel,
+	}
+
+	return
+}
+
+type watchingReader struct {
+	wrapped     ReadSeekCloser
+	progress    func(int64)
+	idleTimeout time.Duration
+	cancel      func()
+
+	// The number of bytes returned so far.
+	bytesRead int64
+
+	// Set once a read has stalled, after which the wrapped reader is no longer
+	// usable.
+	stalled bool
+}
+
+func (wr *watchingReader) stalledError() error {
+	return &StalledReadError{
+		Err: fmt.Errorf("No data received for %v", wr.idleTimeout),
+	}
+}
+
+func (wr *watchingReader) Read(p []byte) (n int, err error) {
+	if wr.stalled {
+		err = wr.stalledError()
+		return
+	}
+
+	// Arrange to abort the read if it takes too long. If the timer has already
+	// fired by the time the read returns, the request has been cancelled and
+	// whatever the wrapped reader says, the read stalled.
+	var timer *time.Timer
+	if wr.idleTimeout > 0 {
+		timer = time.AfterFunc(wr.idleTimeout, wr.cancel)
+	}
+
+	n, err = wr.wrapped.Read(p)
+
+	if timer != nil && !timer.Stop() {
+		wr.stalled = true
+		err = wr.stalledError()
+	}
+
+	// Report progress.
+	if n > 0 {
+		wr.bytesRead += int64(n)
+		if wr.progress != nil {
+			wr.progress(wr.bytesRead)
+		}
+	}
+
+	return
+}
+
+func (wr *watchingReader) Seek(offset int64, whence int) (n int64, err error) {
+	n, err = wr.wrapped.Seek(offset, whence)
+	return
+}
+
+func (wr *watchingReader) Close() (err error) {
+	err = wr.wrapped.Close()
+	wr.cancel()
+	return
+}
@@ -0,0 +1,217 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestWatchingReader(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A reader that returns some contents and then blocks until the supplied
+// channel is closed.
+type stallingReader struct {
+	contents string
+	unblock  <-chan struct{}
+}
+
+func (sr *stallingReader) Read(p []byte) (n int, err error) {
+	if sr.contents != "" {
+		n = copy(p, sr.contents)
+		sr.contents = sr.contents[n:]
+		return
+	}
+
+	<-sr.unblock
+	err = errors.New("unblocked")
+	return
+}
+
+func (sr *stallingReader) Seek(offset int64, whence int) (n int64, err error) {
+	err = errors.New("not supported")
+	return
+}
+
+func (sr *stallingReader) Close() (err error) {
+	return
+}
+
+// A round tripper that responds with the supplied contents in a body that
+// then stalls until the request is cancelled.
+type stallingTransport struct {
+	contents string
+}
+
+func (st *stallingTransport) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	res = &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body: &stallingReader{
+			contents: st.contents,
+			unblock:  req.Context().Done(),
+		},
+		Request: req,
+	}
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type WatchingReaderTest struct {
+	progress  []int64
+	cancelled chan struct{}
+}
+
+var _ SetUpInterface = &WatchingReaderTest{}
+
+func init() { RegisterTestSuite(&WatchingReaderTest{}) }
+
+func (t *WatchingReaderTest) SetUp(ti *TestInfo) {
+	t.cancelled = make(chan struct{})
+}
+
+func (t *WatchingReaderTest) recordProgress(n int64) {
+	t.progress = append(t.progress, n)
+}
+
+func (t *WatchingReaderTest) cancel() {
+	close(t.cancelled)
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *WatchingReaderTest) ReportsProgress() {
+	rc := newWatchingReader(
+		readSeekCloser{
+			ioutil.NopCloser(iotest.OneByteReader(strings.NewReader("taco"))),
+			nil,
+		},
+		t.recordProgress,
+		0,
+		func() {})
+
+	b, err := ioutil.ReadAll(rc)
+	AssertEq(nil, err)
+	ExpectEq("taco", string(b))
+	ExpectThat(t.progress, ElementsAre(1, 2, 3, 4))
+}
+
+func (t *WatchingReaderTest) SlowReadsDontStall() {
+	rc := newWatchingReader(
+		readSeekCloser{
+			ioutil.NopCloser(strings.NewReader("taco")),
+			nil,
+		},
+		nil,
+		time.Millisecond,
+		t.cancel)
+
+	// Time between calls to Read doesn't count.
+	p := make([]byte, 2)
+	for i := 0; i < 2; i++ {
+		n, err := rc.Read(p)
+		AssertEq(nil, err)
+		AssertEq(2, n)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-t.cancelled:
+		AddFailure("Unexpectedly cancelled")
+	default:
+	}
+}
+
+func (t *WatchingReaderTest) StalledRead() {
+	rc := newWatchingReader(
+		&stallingReader{contents: "taco", unblock: t.cancelled},
+		t.recordProgress,
+		10*time.Millisecond,
+		t.cancel)
+
+	b, err := ioutil.ReadAll(rc)
+
+	ExpectEq("taco", string(b))
+	ExpectThat(err, HasSameTypeAs(&StalledReadError{}))
+	ExpectThat(err, Error(HasSubstr("No data received")))
+	ExpectThat(t.progress, ElementsAre(4))
+
+	// Further reads should fail the same way.
+	_, err = rc.Read(make([]byte, 1))
+	ExpectThat(err, HasSameTypeAs(&StalledReadError{}))
+}
+
+func (t *WatchingReaderTest) CloseCancels() {
+	rc := newWatchingReader(
+		readSeekCloser{ioutil.NopCloser(strings.NewReader("")), nil},
+		nil,
+		time.Hour,
+		t.cancel)
+
+	AssertEq(nil, rc.Close())
+
+	select {
+	case <-t.cancelled:
+	default:
+		AddFailure("Not cancelled")
+	}
+}
+
+func (t *WatchingReaderTest) NewReader_Stalled() {
+	b := newBucket(
+		&http.Client{Transport: &stallingTransport{contents: "taco"}},
+		"test",
+		"some_bucket",
+		defaultUploadChunkSize,
+		nil,
+		"")
+
+	rc, err := b.NewReader(
+		context.Background(),
+		&ReadObjectRequest{
+			Name:         "foo",
+			IdleTimeout:  10 * time.Millisecond,
+			ProgressFunc: t.recordProgress,
+		})
+
+	AssertEq(nil, err)
+	defer rc.Close()
+
+	contents, err := ioutil.ReadAll(rc)
+	ExpectEq("taco", string(contents))
+	ExpectThat(err, HasSameTypeAs(&StalledReadError{}))
+	ExpectThat(t.progress, ElementsAre(4))
+}
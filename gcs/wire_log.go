@@ -0,0 +1,217 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/gcloud/httputil"
+	"golang.org/x/net/context"
+)
+
+// Headers whose values are credentials, and so are redacted from the wire log.
+// GCS returns the ID of a resumable upload session, which is enough to write
+// to it, in X-Guploader-Uploadid as well as in the session URL; the latter is
+// sent in the Location header, which is sanitized like request URLs.
+var wireLogRedactedHeaders = map[string]bool{
+	"Authorization":                     true,
+	"Proxy-Authorization":               true,
+	"Cookie":                            true,
+	"Set-Cookie":                        true,
+	"X-Goog-Encryption-Key":             true,
+	"X-Goog-Copy-Source-Encryption-Key": true,
+	"X-Goog-Api-Key":                    true,
+	"X-Goog-Iam-Authorization-Token":    true,
+	"X-Guploader-Uploadid":              true,
+}
+
+// Query parameters whose values grant access, and so are redacted from the
+// wire log. An upload ID is enough to write to a resumable upload session.
+var wireLogRedactedParams = []string{
+	"access_token",
+	"key",
+	"upload_id",
+}
+
+const wireLogRedacted = "REDACTED"
+
+type attemptKey struct{}
+
+// Return a context recording that requests made with it belong to the given
+// attempt at an operation, counting from one.
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// Return the attempt recorded in the context with withAttempt, or zero if
+// none.
+func attemptFromContext(ctx context.Context) (attempt int) {
+	attempt, _ = ctx.Value(attemptKey{}).(int)
+	return
+}
+
+// Wrap the supplied round tripper in a layer that writes a sanitized
+// description of each request and its response to w. See ConnConfig.WireLog.
+func newWireLoggingRoundTripper(
+	wrapped httputil.CancellableRoundTripper,
+	w io.Writer) (rt httputil.CancellableRoundTripper) {
+	rt = &wireLoggingRoundTripper{
+		wrapped: wrapped,
+		w:       w,
+	}
+
+	return
+}
+
+type wireLoggingRoundTripper struct {
+	wrapped httputil.CancellableRoundTripper
+
+	// Serializes writes, so that the descriptions of concurrent requests aren't
+	// interleaved.
+	mu sync.Mutex
+
+	// GUARDED_BY(mu)
+	w io.Writer
+}
+
+func (t *wireLoggingRoundTripper) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	desc := fmt.Sprintf("%s %s", req.Method, sanitizeURL(req.URL))
+	if attempt := attemptFromContext(req.Context()); attempt != 0 {
+		desc += fmt.Sprintf(" (attempt %d)", attempt)
+	}
+
+	// Describe the request.
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--> %s\n", desc)
+	writeSanitizedHeader(&buf, req.Header)
+	writeElidedBody(&buf, req.Body != nil, req.ContentLength)
+	t.write(buf.Bytes())
+
+	// Execute it.
+	start := time.Now()
+	res, err = t.wrapped.RoundTrip(req)
+	latency := time.Since(start)
+
+	// Describe the outcome.
+	buf.Reset()
+	if err != nil {
+		fmt.Fprintf(&buf, "<-- %s: error after %v: %v\n", desc, latency, err)
+		t.write(buf.Bytes())
+		return
+	}
+
+	fmt.Fprintf(
+		&buf,
+		"<-- %s: %d %s in %v\n",
+		desc,
+		res.StatusCode,
+		http.StatusText(res.StatusCode),
+		latency)
+	writeSanitizedHeader(&buf, res.Header)
+	writeElidedBody(&buf, res.Body != nil && res.Body != http.NoBody, res.ContentLength)
+	t.write(buf.Bytes())
+
+	return
+}
+
+func (t *wireLoggingRoundTripper) CancelRequest(req *http.Request) {
+	t.wrapped.CancelRequest(req)
+}
+
+// LOCKS_EXCLUDED(t.mu)
+func (t *wireLoggingRoundTripper) write(p []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// There's nothing useful to do with an error writing debug output.
+	t.w.Write(p)
+}
+
+// Return the supplied URL with credentials in its query redacted.
+func sanitizeURL(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+	for _, p := range wireLogRedactedParams {
+		if _, ok := query[p]; ok {
+			query.Set(p, wireLogRedacted)
+			redacted = true
+		}
+	}
+
+	if !redacted {
+		return u.String()
+	}
+
+	uCopy := *u
+	uCopy.RawQuery = query.Encode()
+	return uCopy.String()
+}
+
+// Like sanitizeURL, but for a URL that may not parse, in which case it is
+// redacted altogether.
+func sanitizeRawURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return wireLogRedacted
+	}
+
+	return sanitizeURL(u)
+}
+
+// Write the supplied headers, sorted by name and with credentials redacted.
+func writeSanitizedHeader(w io.Writer, h http.Header) {
+	var names []string
+	for name := range h {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		canonical := http.CanonicalHeaderKey(name)
+		for _, v := range h[name] {
+			switch {
+			case wireLogRedactedHeaders[canonical]:
+				v = wireLogRedacted
+
+			case canonical == "Location":
+				v = sanitizeRawURL(v)
+			}
+
+			fmt.Fprintf(w, "    %s: %s\n", name, v)
+		}
+	}
+}
+
+// Write a placeholder for a body, if there is one. length is -1 if unknown.
+func writeElidedBody(w io.Writer, present bool, length int64) {
+	switch {
+	case !present || length == 0:
+
+	case length < 0:
+		fmt.Fprintf(w, "    [body elided]\n")
+
+	default:
+		fmt.Fprintf(w, "    [body elided: %d bytes]\n", length)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	. "github.com/jacobsa/oglematchers"
+	. "github.com/jacobsa/ogletest"
+)
+
+func TestWireLog(t *testing.T) { RunTests(t) }
+
+////////////////////////////////////////////////////////////////////////
+// Helpers
+////////////////////////////////////////////////////////////////////////
+
+// A transport that fails the first few requests it receives with HTTP 503,
+// and otherwise behaves like a headerTransport.
+type flakyTransport struct {
+	headerTransport
+	failures int
+}
+
+func (ft *flakyTransport) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	res, err = ft.headerTransport.RoundTrip(req)
+	if ft.failures > 0 {
+		ft.failures--
+		res.StatusCode = http.StatusServiceUnavailable
+	}
+
+	return
+}
+
+// A transport that fails every request without a response.
+type failingTransport struct{}
+
+func (ft *failingTransport) RoundTrip(
+	req *http.Request) (res *http.Response, err error) {
+	err = errors.New("taco")
+	return
+}
+
+func (ft *failingTransport) CancelRequest(req *http.Request) {
+}
+
+////////////////////////////////////////////////////////////////////////
+// Boilerplate
+////////////////////////////////////////////////////////////////////////
+
+type WireLogTest struct {
+	ctx       context.Context
+	transport flakyTransport
+	log       bytes.Buffer
+	rt        http.RoundTripper
+}
+
+var _ SetUpInterface = &WireLogTest{}
+
+func init() { RegisterTestSuite(&WireLogTest{}) }
+
+func (t *WireLogTest) SetUp(ti *TestInfo) {
+	t.ctx = ti.Ctx
+	t.transport.status = http.StatusOK
+	t.transport.header = http.Header{
+		"X-Guploader-Uploadid": []string{"some-upload"},
+	}
+
+	t.rt = newWireLoggingRoundTripper(&t.transport, &t.log)
+}
+
+func (t *WireLogTest) newRequest(
+	method string,
+	rawURL string,
+	body string) (req *http.Request) {
+	req, err := http.NewRequest(method, rawURL, strings.NewReader(body))
+	AssertEq(nil, err)
+
+	req = req.WithContext(t.ctx)
+	return
+}
+
+////////////////////////////////////////////////////////////////////////
+// Tests
+////////////////////////////////////////////////////////////////////////
+
+func (t *WireLogTest) RequestAndResponseLines() {
+	req := t.newRequest("PATCH", "https://example.com/storage/v1/b/b/o/foo", "{}")
+
+	_, err := t.rt.RoundTrip(req)
+	AssertEq(nil, err)
+
+	lines := strings.Split(t.log.String(), "\n")
+	ExpectEq("--> PATCH https://example.com/storage/v1/b/b/o/foo", lines[0])
+	ExpectThat(
+		t.log.String(),
+		MatchesRegexp(`\n<-- PATCH https://example.com/storage/v1/b/b/o/foo: `+
+			`200 OK in \S+\n`))
+
+	ExpectThat(t.log.String(), HasSubstr("    X-Guploader-Uploadid: REDACTED\n"))
+	ExpectThat(t.log.String(), Not(HasSubstr("some-upload")))
+}
+
+func (t *WireLogTest) BodiesElided() {
+	req := t.newRequest("PATCH", "https://example.com/", `{"name": "secret"}`)
+
+	_, err := t.rt.RoundTrip(req)
+	AssertEq(nil, err)
+
+	ExpectThat(t.log.String(), HasSubstr("[body elided: 18 bytes]"))
+	ExpectThat(t.log.String(), Not(HasSubstr("secret")))
+	ExpectThat(t.log.String(), Not(HasSubstr(`"name"`)))
+}
+
+func (t *WireLogTest) CredentialsRedacted() {
+	req := t.newRequest(
+		"PUT",
+		"https://example.com/upload?upload_id=secret&uploadType=resumable",
+		"")
+
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Goog-Encryption-Key", "secret")
+	req.Header.Set("Content-Type", "text/plain")
+
+	_, err := t.rt.RoundTrip(req)
+	AssertEq(nil, err)
+
+	ExpectThat(t.log.String(), Not(HasSubstr("secret")))
+	ExpectThat(t.log.String(), HasSubstr("    Authorization: REDACTED\n"))
+	ExpectThat(t.log.String(), HasSubstr("    X-Goog-Encryption-Key: REDACTED\n"))
+	ExpectThat(t.log.String(), HasSubstr("    Content-Type: text/plain\n"))
+	ExpectThat(t.log.String(), HasSubstr("upload_id=REDACTED"))
+	ExpectThat(t.log.String(), HasSubstr("uploadType=resumable"))
+}
+
+func (t *WireLogTest) UploadSessionRedacted() {
+	// GCS returns the session URL when a resumable upload is started.
+	t.transport.header.Set(
+		"Location",
+		"https://example.com/upload?uploadType=resumable&upload_id=secret")
+
+	req := t.newRequest("POST", "https://example.com/upload?uploadType=resumable", "")
+
+	_, err := t.rt.RoundTrip(req)
+	AssertEq(nil, err)
+
+	ExpectThat(t.log.String(), Not(HasSubstr("secret")))
+	ExpectThat(
+		t.log.String(),
+		HasSubstr("    Location: https://example.com/upload?"+
+			"uploadType=resumable&upload_id=REDACTED\n"))
+
+	// A Location header that can't be parsed is redacted altogether.
+	t.log.Reset()
+	t.transport.header.Set("Location", "%zz?upload_id=secret")
+
+	_, err = t.rt.RoundTrip(t.newRequest("GET", "https://example.com/", ""))
+	AssertEq(nil, err)
+
+	ExpectThat(t.log.String(), Not(HasSubstr("secret")))
+	ExpectThat(t.log.String(), HasSubstr("    Location: REDACTED\n"))
+}
+
+func (t *WireLogTest) AttemptNumber() {
+	req := t.newRequest("GET", "https://example.com/", "")
+	req = req.WithContext(withAttempt(t.ctx, 3))
+
+	_, err := t.rt.RoundTrip(req)
+	AssertEq(nil, err)
+
+	ExpectThat(t.log.String(), HasSubstr("--> GET https://example.com/ (attempt 3)\n"))
+	ExpectThat(t.log.String(), HasSubstr("<-- GET https://example.com/ (attempt 3): "))
+}
+
+func (t *WireLogTest) TransportError() {
+	rt := newWireLoggingRoundTripper(&failingTransport{}, &t.log)
+	req := t.newRequest("GET", "https://example.com/", "")
+
+	_, err := rt.RoundTrip(req)
+	AssertNe(nil, err)
+
+	ExpectThat(
+		t.log.String(),
+		MatchesRegexp(`<-- GET https://example.com/: error after \S+: taco\n`))
+}
+
+func (t *WireLogTest) RetriesNumbered() {
+	t.transport.failures = 2
+
+	b := NewRetryBucket(
+		newBucket(
+			&http.Client{Transport: t.rt},
+			"test",
+			"some_bucket",
+			defaultUploadChunkSize,
+			nil,
+			""),
+		RetryPolicy{MaxSleep: time.Minute})
+
+	_, err := b.StatObject(t.ctx, &StatObjectRequest{Name: "foo"})
+	AssertEq(nil, err)
+
+	statuses := []string{
+		"503 Service Unavailable",
+		"503 Service Unavailable",
+		"200 OK",
+	}
+
+	for i, status := range statuses {
+		ExpectThat(
+			t.log.String(),
+			HasSubstr(fmt.Sprintf("(attempt %d): %s", i+1, status)))
+	}
+}
+
+func (t *WireLogTest) SanitizeURL() {
+	u, err := url.Parse("https://example.com/o?key=secret&access_token=secret&a=b")
+	AssertEq(nil, err)
+
+	ExpectEq(
+		"https://example.com/o?a=b&access_token=REDACTED&key=REDACTED",
+		sanitizeURL(u))
+}
@@ -28,7 +28,8 @@ import (
 // Unlike http.NewRequest:
 //
 //  *  This function configures the request to be cancelled when the supplied
-//     context is.
+//     context is, including while its body or the response body is still
+//     being transferred.
 //
 //  *  This function doesn't mangle the supplied URL by round tripping it to a
 //     string. For example, the Opaque field will continue to differentiate
@@ -63,6 +64,10 @@ func NewRequest(
 		Cancel:        ctx.Done(),
 	}
 
+	// Let the transport observe the context's deadline and values too, not
+	// just its cancellation.
+	req = req.WithContext(ctx)
+
 	// Set the User-Agent header.
 	req.Header.Set("User-Agent", userAgent)
 